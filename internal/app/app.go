@@ -12,6 +12,7 @@ package app
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -20,11 +21,14 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
 
 	"github.com/cbwinslow/cbwsh/pkg/ai"
+	aicontext "github.com/cbwinslow/cbwsh/pkg/ai/context"
 	"github.com/cbwinslow/cbwsh/pkg/ai/monitor"
 	"github.com/cbwinslow/cbwsh/pkg/config"
 	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/history"
 	"github.com/cbwinslow/cbwsh/pkg/logging"
 	"github.com/cbwinslow/cbwsh/pkg/panes"
 	"github.com/cbwinslow/cbwsh/pkg/plugins"
@@ -33,12 +37,19 @@ import (
 	"github.com/cbwinslow/cbwsh/pkg/secrets"
 	"github.com/cbwinslow/cbwsh/pkg/shell"
 	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/aichat"
 	"github.com/cbwinslow/cbwsh/pkg/ui/aimonitor"
 	"github.com/cbwinslow/cbwsh/pkg/ui/autocomplete"
+	"github.com/cbwinslow/cbwsh/pkg/ui/browser"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
 	"github.com/cbwinslow/cbwsh/pkg/ui/highlight"
 	"github.com/cbwinslow/cbwsh/pkg/ui/markdown"
 	"github.com/cbwinslow/cbwsh/pkg/ui/menu"
+	"github.com/cbwinslow/cbwsh/pkg/ui/notifications"
+	"github.com/cbwinslow/cbwsh/pkg/ui/palette"
 	"github.com/cbwinslow/cbwsh/pkg/ui/styles"
+	"github.com/cbwinslow/cbwsh/pkg/ui/transfers"
+	"github.com/cbwinslow/cbwsh/pkg/ui/tunnels"
 )
 
 // Mode represents the current application mode.
@@ -67,9 +78,20 @@ type Model struct {
 	pluginManager    *plugins.Manager
 	secretsManager   *secrets.Manager
 	sshManager       *ssh.Manager
+	remotePanes      map[string]string // Pane ID to the connection it is attached to
+	sshEvents        <-chan ssh.StateEvent
+	prompts          <-chan promptMsg
+	unlockSecrets    ssh.UnlockFunc
+	askPassword      passwordFunc // Asks for passwords other than the master password
+	keyCache         *secrets.KeyCacheClient
+	lockEvents       <-chan struct{} // The secrets store locked itself
+	pendingPrompts   []promptMsg // Dialogs background tasks wait on
 	aiManager        *ai.Manager
 	activityMonitor  *monitor.Monitor
+	contextAnalyzer  *aicontext.Analyzer
 	history          *shell.History
+	historyStore     *history.Store
+	historyWriter    *historyWriter
 	jobManager       *process.JobManager
 	privilegeManager *privileges.Manager
 	logger           *logging.Logger
@@ -80,9 +102,21 @@ type Model struct {
 	styles      *styles.Styles
 	highlighter *highlight.ShellHighlighter
 	completer   *autocomplete.Completer
+	specs       *autocomplete.SpecProvider
 	mdRenderer  *markdown.Renderer
-	menuBar     *menu.MenuBar
-	monitorPane *aimonitor.MonitorPane
+	menuBar       *menu.MenuBar
+	monitorPane   *aimonitor.MonitorPane
+	chatPane      *aichat.ChatPane
+	dialog        *dialog.Dialog
+	notifications *notifications.Manager
+	historySearch *palette.Palette
+	tunnelView    *tunnels.View
+	transferView  *transfers.View
+	browser       *browser.Browser
+
+	// Identity recorded with each history entry
+	sessionID string
+	hostname  string
 
 	// State
 	mode          Mode
@@ -98,11 +132,41 @@ type Model struct {
 	selectedSugg  int
 	commandOutput []outputLine
 	lastError     string
+	// outputMask hides the secrets passed to the running command in its
+	// output
+	outputMask *secrets.Masker
+
+	// Risky command waiting for confirmation
+	pendingCommand string
+	// aiSuggestion is the command the AI put at the prompt; running it
+	// unedited uses the AI confirmation threshold
+	aiSuggestion string
+
+	// History search state
+	historyStatus   historyStatus   // Exit status filter of the search
+	historyFailures map[string]bool // Searched commands whose last run failed
+
+	// Running command state
+	running       <-chan core.CommandEvent
+	runningPane   *panes.Pane
+	execStart     time.Time
+	execCommand   string // Entered command to record in history on completion
+	execDir       string
+	pendingStdout string
+	pendingStderr string
+
+	// Job control state
+	fgJob       *process.Job       // Job shown as the running command
+	fgCancel    context.CancelFunc // Stops following fgJob or a wait
+	suspendPgid int                // Process group suspended with Ctrl+Z
+	sessionJobs map[int]sessionJob // Jobs adopted from pane sessions
+	fgFinished  map[int]bool       // Jobs whose exit was shown in the foreground
 }
 
 type outputLine struct {
 	content   string
 	isCommand bool
+	isStderr  bool
 	exitCode  int
 }
 
@@ -123,6 +187,9 @@ type KeyMap struct {
 	AIAssist        key.Binding
 	Execute         key.Binding
 	Cancel          key.Binding
+	Suspend         key.Binding
+	HistorySearch   key.Binding
+	HistoryFilter   key.Binding
 	Up              key.Binding
 	Down            key.Binding
 	Tab             key.Binding
@@ -192,6 +259,18 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("ctrl+c"),
 			key.WithHelp("ctrl+c", "cancel"),
 		),
+		Suspend: key.NewBinding(
+			key.WithKeys("ctrl+z"),
+			key.WithHelp("ctrl+z", "suspend"),
+		),
+		HistorySearch: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "search history"),
+		),
+		HistoryFilter: key.NewBinding(
+			key.WithKeys("ctrl+f"),
+			key.WithHelp("ctrl+f", "filter by exit status"),
+		),
 		Up: key.NewBinding(
 			key.WithKeys("up"),
 			key.WithHelp("↑", "history up"),
@@ -267,18 +346,158 @@ func New() Model {
 	}
 	activityMonitor := monitor.NewMonitor(monitorCfg)
 
+	// The history database is shared by every cbwsh instance; the context
+	// analyzer adds a project's past commands to AI prompts
+	historyStore := history.NewStore(cfg.Shell.HistoryPath)
+	contextAnalyzer := aicontext.NewAnalyzer()
+	contextAnalyzer.SetHistory(historyStore)
+	activityMonitor.SetContextAnalyzer(contextAnalyzer)
+
+	// Completion specs: the built-in ones, overridden by the user's own
+	completer := autocomplete.NewCompleter()
+	specs := autocomplete.NewSpecProvider(autocomplete.BuiltinSpecs()...)
+	if err := specs.LoadDir(cfg.Shell.CompletionSpecsDir); err != nil {
+		logger.Warnf("Failed to load completion specs: %v", err)
+	}
+	completer.AddProvider(specs)
+
+	// The configured provider backs the AI agent
+	aiManager := ai.NewManager()
+	aiAgent := ai.NewAgentFromConfig(cfg.AI)
+	if cfg.AI.Provider != core.AIProviderNone {
+		if err := aiManager.RegisterAgent(aiAgent); err != nil {
+			logger.Warnf("Failed to register AI agent: %v", err)
+		}
+	}
+
 	// Create monitor pane UI component
 	monitorPane := aimonitor.NewMonitorPane(activityMonitor)
 
+	// The AI chat may inspect the active pane's directory with the shell
+	// tools, subject to the configured approval policies
+	paneManager := panes.NewManager(cfg.Shell.DefaultShell)
+	tools := ai.NewToolRegistry()
+	// The tools read local files, so remote panes fall back to the local directory
+	workDir := func() string {
+		if pane := paneManager.ActivePane(); pane != nil && pane.GetShellExecutor().Local() {
+			return paneDir(pane)
+		}
+		wd, _ := os.Getwd()
+		return wd
+	}
+	for _, tool := range ai.ShellTools(workDir) {
+		_ = tools.Register(tool)
+	}
+	for name, policy := range cfg.AI.ToolApproval {
+		if err := tools.SetApproval(name, ai.ApprovalPolicy(policy)); err != nil {
+			logger.Warnf("Invalid tool approval for %s: %v", name, err)
+		}
+	}
+	chatPane := aichat.NewChatPane(aiManager)
+	chatPane.SetTools(tools)
+
+	sshManager := ssh.NewManager("", time.Duration(cfg.SSH.ConnectTimeout)*time.Second)
+	if cfg.SSH.KnownHostsPath != "" {
+		sshManager.SetKnownHostsPath(cfg.SSH.KnownHostsPath)
+	}
+	// New hosts are asked about in a dialog
+	hostKeyPolicy, err := ssh.ParseHostKeyPolicy(cfg.SSH.StrictHostKeyChecking)
+	if err != nil {
+		logger.Warnf("Invalid host key checking: %v", err)
+		hostKeyPolicy = ssh.HostKeyAsk
+	}
+	prompts := make(chan promptMsg)
+	sshManager.SetHostKeyPolicy(hostKeyPolicy)
+	sshManager.SetHostKeyPrompt(newHostKeyPrompt(prompts))
+	sshManager.SetHashKnownHosts(cfg.SSH.HashKnownHosts)
+	for _, path := range cfg.SSH.HostCAFiles {
+		if err := sshManager.AddHostCAFile(path); err != nil {
+			logger.Warnf("Failed to load host CA: %v", err)
+		}
+	}
+	if cfg.SSH.DefaultKeyPath != "" {
+		sshManager.AddIdentityFile(cfg.SSH.DefaultKeyPath)
+	}
+	for _, host := range cfg.SSH.SavedHosts {
+		_ = sshManager.SaveHost(host)
+	}
+	if cfg.SSH.ConfigPath != "" {
+		if err := sshManager.LoadClientConfig(cfg.SSH.ConfigPath); err != nil {
+			logger.Warnf("Failed to load SSH config: %v", err)
+		}
+	}
+	// Host passphrases and passwords are read from the secrets store,
+	// which is unlocked when the first of them is needed and locks itself
+	// when left unused
+	secretsManager := secrets.NewManager(cfg.Secrets.StorePath)
+	if err := secretsManager.SetStoreFormat(storeFormat(cfg.Secrets)); err != nil {
+		logger.Warnf("Invalid secrets store format, using the defaults: %v", err)
+	}
+	if cfg.Secrets.AuditLog != "" {
+		auditLog, err := secrets.OpenAuditLog(cfg.Secrets.AuditLog)
+		if err != nil {
+			logger.Warnf("Failed to open secrets audit log: %v", err)
+		} else {
+			secretsManager.SetAuditLog(auditLog)
+		}
+	}
+	var keyCache *secrets.KeyCacheClient
+	if cfg.Secrets.CacheTTL > 0 && cfg.Secrets.AgentSocket != "" {
+		keyCache = secrets.NewKeyCacheClient(cfg.Secrets.AgentSocket,
+			time.Duration(cfg.Secrets.CacheTTL)*time.Second, startKeyCache(cfg.Secrets.AgentSocket))
+	}
+	lockEvents := make(chan struct{}, 1)
+	secretsManager.SetAutoLock(time.Duration(cfg.Secrets.AutoLock)*time.Second,
+		forgetOnLock(keyCache, secretsManager, notifyLocked(lockEvents)))
+	unlockSecrets := newUnlockPrompt(prompts, secretsManager, keyCache)
+	sshManager.SetSecretStore(secretsManager.For("ssh"), unlockSecrets)
+	if cfg.AI.APIKey == "" && cfg.AI.APIKeySecret != "" {
+		aiAgent.SetAPIKeySource(apiKeySource(secretsManager, unlockSecrets, cfg.AI.APIKeySecret))
+	}
+	switch cfg.SSH.Agent {
+	case "none":
+	case "vault":
+		sshManager.SetAgent(ssh.NewVaultAgent(secretsManager.For("ssh-agent")))
+	default:
+		if err := sshManager.ConnectAgent(""); err != nil && cfg.SSH.Agent == "system" {
+			logger.Warnf("Failed to connect to SSH agent: %v", err)
+		}
+	}
+	sshManager.SetForwardAgent(cfg.SSH.ForwardAgent)
+	sshManager.SetGroups(cfg.SSH.Groups)
+	sshManager.SetKeepAlive(time.Duration(cfg.SSH.KeepAliveInterval)*time.Second, cfg.SSH.KeepAliveCountMax)
+	reconnect := ssh.DefaultReconnectPolicy()
+	reconnect.Enabled = cfg.SSH.Reconnect
+	reconnect.MaxAttempts = cfg.SSH.ReconnectMaxAttempts
+	sshManager.SetReconnectPolicy(reconnect)
+	// The subscription lasts as long as the manager
+	sshEvents, _ := sshManager.Subscribe()
+	registerRemoteCompletion(specs, sshManager)
+	registerSecretsCompletion(specs, secretsManager)
+
+	// Hostname is recorded with history entries; it is empty if unknown
+	hostname, _ := os.Hostname()
+
 	return Model{
 		config:           cfg,
-		paneManager:      panes.NewManager(cfg.Shell.DefaultShell),
+		paneManager:      paneManager,
 		pluginManager:    plugins.NewManager(),
-		secretsManager:   secrets.NewManager(cfg.Secrets.StorePath),
-		sshManager:       ssh.NewManager("", time.Duration(cfg.SSH.ConnectTimeout)*time.Second),
-		aiManager:        ai.NewManager(),
+		secretsManager:   secretsManager,
+		sshManager:       sshManager,
+		remotePanes:      make(map[string]string),
+		sshEvents:        sshEvents,
+		prompts:          prompts,
+		unlockSecrets:    unlockSecrets,
+		askPassword:      newPasswordPrompt(prompts),
+		keyCache:         keyCache,
+		lockEvents:       lockEvents,
+		aiManager:        aiManager,
 		activityMonitor:  activityMonitor,
-		history:          shell.NewHistory(cfg.Shell.HistorySize, cfg.Shell.HistoryPath),
+		contextAnalyzer:  contextAnalyzer,
+		// Up/down recall only; entries are persisted by historyStore
+		history:          shell.NewHistory(cfg.Shell.HistorySize, ""),
+		historyStore:     historyStore,
+		historyWriter:    newHistoryWriter(historyStore, logger),
 		jobManager:       process.NewJobManager(100),
 		privilegeManager: privileges.NewManager(),
 		logger:           logger,
@@ -286,14 +505,26 @@ func New() Model {
 		spinner:          s,
 		styles:           styles.DefaultStyles(),
 		highlighter:      highlight.NewShellHighlighter(),
-		completer:        autocomplete.NewCompleter(),
+		completer:        completer,
+		specs:            specs,
 		mdRenderer:       mdRenderer,
 		menuBar:          menuBar,
 		monitorPane:      monitorPane,
+		chatPane:         chatPane,
+		dialog:           dialog.New(),
+		notifications:    notifications.NewManager(),
+		historySearch:    newHistorySearch(),
+		tunnelView:       tunnels.NewView(sshManager.Tunnels),
+		transferView:     transfers.NewView(sshManager.Transfers),
+		browser:          browser.New(),
+		sessionID:        uuid.NewString(),
+		hostname:         hostname,
 		mode:             ModeNormal,
 		showStatusBar:    cfg.UI.ShowStatusBar,
 		showMonitor:      cfg.AI.EnableMonitoring,
 		commandOutput:    make([]outputLine, 0),
+		sessionJobs:      make(map[int]sessionJob),
+		fgFinished:       make(map[int]bool),
 	}
 }
 
@@ -314,8 +545,8 @@ func (m Model) Init() tea.Cmd {
 		m.logger.Errorf("Failed to create initial pane: %v", err)
 	}
 
-	// Load command history from persistent storage
-	if err := m.history.Load(); err != nil {
+	// Load command history from the history database
+	if err := m.loadHistory(); err != nil {
 		// Log error but continue - history will be empty
 		m.logger.Warnf("Failed to load command history: %v", err)
 	}
@@ -327,9 +558,13 @@ func (m Model) Init() tea.Cmd {
 
 	// Return initial commands to start UI animations
 	return tea.Batch(
-		textinput.Blink,   // Start cursor blinking
-		m.spinner.Tick,    // Start spinner animation
-		aimonitor.Tick(),  // Start AI monitor updates
+		textinput.Blink,           // Start cursor blinking
+		m.spinner.Tick,            // Start spinner animation
+		aimonitor.Tick(),          // Start AI monitor updates
+		waitSSHEvent(m.sshEvents), // Report connections lost and regained
+		waitPrompt(m.prompts),
+		waitSecretsLocked(m.lockEvents),
+		checkDueSecrets(m.secretsManager), // Remind of secrets to rotate
 	)
 }
 
@@ -343,7 +578,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 		m.input.Width = msg.Width - 10
 
-		// Calculate available width for panes and monitor
+		// Calculate available width for panes, monitor and chat
 		availableWidth := msg.Width
 		monitorWidth := 0
 		if m.showMonitor && m.monitorPane != nil {
@@ -351,13 +586,37 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			availableWidth = msg.Width - monitorWidth
 			m.monitorPane.SetSize(monitorWidth, msg.Height-4)
 		}
+		if m.tunnelView.IsVisible() {
+			tunnelWidth := msg.Width / 4
+			availableWidth -= tunnelWidth
+			m.tunnelView.SetSize(tunnelWidth, msg.Height-4)
+		}
+		if m.transferView.IsVisible() {
+			transferWidth := msg.Width / 4
+			availableWidth -= transferWidth
+			m.transferView.SetSize(transferWidth, msg.Height-4)
+		}
+		if m.chatPane.IsVisible() {
+			chatWidth := m.chatPane.GetWidth(msg.Width)
+			availableWidth -= chatWidth
+			m.chatPane.SetSize(chatWidth, msg.Height-4)
+		}
 
 		m.paneManager.UpdateAllSizes(availableWidth, msg.Height-4)
+		m.browser.SetSize(availableWidth, msg.Height-4)
 		m.menuBar.SetWidth(msg.Width)
+		m.notifications.SetSize(msg.Width, msg.Height)
+		m.historySearch.SetSize(msg.Width, msg.Height)
+		m.dialog.SetSize(msg.Width, msg.Height)
 		m.ready = true
 		return m, nil
 
 	case tea.KeyMsg:
+		// An open dialog takes all keys until it is answered
+		if handled, cmd := m.dialog.Update(msg); handled {
+			return m, cmd
+		}
+
 		// Handle menu bar input first when it's open
 		if m.menuBar.IsOpen() {
 			handled, cmd := m.menuBar.Update(msg)
@@ -366,30 +625,76 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+		// The history search takes all keys while it is open
+		if m.historySearch.IsVisible() {
+			return m, m.updateHistorySearch(msg)
+		}
+
+		// The file browser takes the keys it uses while it is open
+		if handled, cmd := m.browser.Update(msg); handled {
+			return m, cmd
+		}
+
+		// The focused AI chat takes all keys but quit and its toggle
+		if m.chatPane.IsVisible() && m.chatPane.IsFocused() &&
+			!key.Matches(msg, keys.Quit) && !key.Matches(msg, keys.AIAssist) {
+			var cmd tea.Cmd
+			m.chatPane, cmd = m.chatPane.Update(msg)
+			if !m.chatPane.IsFocused() {
+				m.input.Focus()
+			}
+			return m, cmd
+		}
+
 		switch {
 		case key.Matches(msg, keys.Quit):
 			m.logger.Info("Application shutting down")
-			_ = m.history.Save()
+			m.killJobs()
+			m.paneManager.CloseAll()
+			m.sshManager.CloseAll()
+			m.historyWriter.close()
 			return m, tea.Quit
 
 		case key.Matches(msg, keys.Cancel):
+			if m.executing && m.interruptForeground() {
+				return m, nil
+			}
 			if m.executing {
-				pane := m.paneManager.ActivePane()
+				pane := m.runningPane
+				if pane == nil {
+					pane = m.paneManager.ActivePane()
+				}
 				if pane != nil {
 					_ = pane.GetShellExecutor().Interrupt()
 				}
-				m.executing = false
+				// A streaming command stays active until its result arrives
+				if m.running == nil {
+					m.executing = false
+				}
 			}
 			m.input.Reset()
 			m.suggestions = nil
 			return m, nil
 
+		case key.Matches(msg, keys.Suspend):
+			if m.executing {
+				m.suspendForeground()
+			}
+			return m, nil
+
 		case key.Matches(msg, keys.Execute):
-			if m.input.Value() != "" {
+			if m.input.Value() != "" && !m.executing {
 				return m.executeCommand()
 			}
 			return m, nil
 
+		case key.Matches(msg, keys.HistorySearch):
+			if m.executing {
+				break
+			}
+			m.suggestions = nil
+			return m, m.openHistorySearch()
+
 		case key.Matches(msg, keys.Up):
 			if len(m.suggestions) > 0 {
 				m.selectedSugg--
@@ -419,16 +724,23 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case key.Matches(msg, keys.Tab):
 			if len(m.suggestions) > 0 && m.selectedSugg >= 0 && m.selectedSugg < len(m.suggestions) {
-				m.input.SetValue(m.suggestions[m.selectedSugg].Text)
-				m.input.SetCursor(len(m.input.Value()))
+				sugg := m.suggestions[m.selectedSugg]
+				if sugg.Category == "history" {
+					// History suggestions are whole command lines
+					m.input.SetValue(sugg.Text)
+					m.input.SetCursor(len(sugg.Text))
+				} else {
+					value, cursor := autocomplete.ReplaceWord(m.input.Value(), m.input.Position(), sugg.Text)
+					m.input.SetValue(value)
+					m.input.SetCursor(cursor)
+				}
 				m.suggestions = nil
 				return m, nil
 			}
-			// Get completions
-			suggestions, _ := m.completer.Complete(m.input.Value(), m.input.Position())
-			m.suggestions = suggestions
-			m.selectedSugg = 0
-			return m, nil
+			// Spec generators run in the active pane's directory and may
+			// take a moment, so completions are gathered off the UI goroutine
+			m.specs.SetWorkingDirectory(paneDir(m.paneManager.ActivePane()))
+			return m, complete(m.completer, m.input.Value(), m.input.Position())
 
 		case key.Matches(msg, keys.Clear):
 			m.commandOutput = m.commandOutput[:0]
@@ -495,21 +807,62 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 
 		case key.Matches(msg, keys.AIAssist):
-			if m.mode == ModeAI {
+			// Open and focus the chat, or close it if it has focus
+			if m.chatPane.IsVisible() && m.chatPane.IsFocused() {
 				m.mode = ModeNormal
+				m.chatPane.Blur()
+				m.chatPane.Hide()
+				m.input.Focus()
 			} else {
 				m.mode = ModeAI
+				m.chatPane.Show()
+				m.chatPane.Focus()
+				m.input.Blur()
+			}
+			// Force resize to make room for the chat
+			if m.ready {
+				return m.Update(tea.WindowSizeMsg{Width: m.width, Height: m.height})
 			}
 			return m, nil
 		}
 
+	case commandOutputMsg:
+		m.appendChunk(core.OutputChunk(msg))
+		return m, waitForCommandEvent(m.running)
+
 	case commandResultMsg:
 		m.executing = false
 		result := core.CommandResult(msg)
-		m.addOutput(result.Output, false, result.ExitCode)
-		if result.Error != "" {
-			m.addOutput(result.Error, false, result.ExitCode)
+		result.Output = m.outputMask.Mask(result.Output)
+		result.Error = m.outputMask.Mask(result.Error)
+		// Commands resumed with fg or awaited with wait were recorded
+		// when the builtin was entered
+		if m.execCommand != "" {
+			historyPane := m.runningPane
+			if historyPane == nil {
+				historyPane = m.paneManager.ActivePane()
+			}
+			m.recordHistory(m.execCommand, historyPane, m.execDir, m.execStart, result.ExitCode)
+			m.execCommand = ""
+		}
+		if m.running != nil {
+			// Output was already streamed; flush partial lines and report failures
+			m.flushPendingOutput()
+			reported, cmd := m.finishJobControl(result, m.runningPane)
+			if !reported && result.ExitCode != 0 {
+				m.addOutput(fmt.Sprintf("✗ exit status %d", result.ExitCode), false, result.ExitCode)
+			}
+			cmds = append(cmds, cmd)
+		} else {
+			m.addOutput(result.Output, false, result.ExitCode)
+			if result.Error != "" {
+				m.addOutput(result.Error, false, result.ExitCode)
+			}
 		}
+		// Partial lines were masked as they were flushed
+		m.outputMask = nil
+		m.running = nil
+		m.runningPane = nil
 		m.logger.Debugf("Command completed: %s (exit code: %d)", result.Command, result.ExitCode)
 
 		// Record activity to monitor
@@ -522,6 +875,124 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.activityMonitor.RecordCommand(&result, workDir)
 		}
 
+		return m, tea.Batch(cmds...)
+
+	case secretsResolvedMsg:
+		cmd := m.secretsResolved(msg)
+		return m, cmd
+
+	case dialog.ResultMsg:
+		model, cmd := m.dialogResult(msg)
+		// Prompts wait for other dialogs to close
+		if next, ok := model.(Model); ok && !next.dialog.IsVisible() {
+			return next, tea.Batch(cmd, next.askPrompt())
+		}
+		return model, cmd
+
+	case promptMsg:
+		return m, tea.Batch(m.queuePrompt(msg), waitPrompt(m.prompts))
+
+	case secretsLockedMsg:
+		cmd := m.secretsLocked()
+		return m, cmd
+
+	case secretsDueMsg:
+		cmd := m.secretsDue(msg)
+		return m, cmd
+
+	case secretMetadataMsg:
+		m.secretMetadataUpdated(msg)
+		return m, nil
+
+	case secretsTaskMsg:
+		m.secretsTaskDone(msg)
+		return m, nil
+
+	case aichat.InsertCommandMsg:
+		// Put the suggestion at the prompt for review
+		m.input.SetValue(msg.Command)
+		m.input.CursorEnd()
+		m.aiSuggestion = msg.Command
+		m.chatPane.Blur()
+		m.input.Focus()
+		return m, nil
+
+	case historySelectedMsg:
+		m.input.SetValue(msg.command)
+		m.input.CursorEnd()
+		return m, nil
+
+	case remoteOpenedMsg:
+		return m.remoteOpened(msg)
+
+	case sshStateMsg:
+		return m, tea.Batch(m.sshStateChanged(msg.event), waitSSHEvent(m.sshEvents))
+
+	case tea.MouseMsg:
+		// The browser's coordinates start below the menu bar and header
+		if m.browser.IsVisible() {
+			if m.showMenuBar || m.menuBar.IsOpen() {
+				msg.Y -= lipgloss.Height(m.menuBar.View())
+			}
+			msg.Y -= lipgloss.Height(m.renderHeader())
+			_, cmd := m.browser.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+
+	case browser.ListedMsg:
+		_, cmd := m.browser.Update(msg)
+		return m, cmd
+
+	case browser.CopyMsg:
+		return m, m.browserCopy(msg)
+
+	case browserOpenedMsg:
+		return m, m.browserOpened(msg)
+
+	case fanOutDoneMsg:
+		return m, m.fanOutDone(msg)
+
+	case transfersQueuedMsg:
+		return m, m.transfersQueued(msg)
+
+	case transfersDoneMsg:
+		return m, m.transfersDone(msg)
+
+	case transfers.TickMsg:
+		// Keep the progress bars of a visible transfer view moving
+		if m.transferView.IsVisible() {
+			return m, transfers.Tick()
+		}
+		return m, nil
+
+	case tunnels.TickMsg:
+		// Keep the counters of a visible tunnel view moving
+		if m.tunnelView.IsVisible() {
+			return m, tunnels.Tick()
+		}
+		return m, nil
+
+	case jobDoneMsg:
+		return m, m.jobFinished(msg.job)
+
+	case sessionJobsMsg:
+		return m, m.adoptSessionJob(msg)
+
+	case jobResumedMsg:
+		m.jobResumed(msg)
+		return m, nil
+
+	case completionMsg:
+		// Drop completions for input that has changed since Tab
+		if msg.input == m.input.Value() && msg.cursor == m.input.Position() {
+			m.suggestions = msg.suggestions
+			m.selectedSugg = 0
+		}
+		return m, nil
+
+	case notificationTickMsg:
+		m.notifications.CleanExpired()
 		return m, nil
 
 	case spinner.TickMsg:
@@ -544,6 +1015,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	}
 
+	// Update the AI chat; it handles its running request even when hidden
+	if _, ok := msg.(tea.KeyMsg); !ok {
+		var cmd tea.Cmd
+		m.chatPane, cmd = m.chatPane.Update(msg)
+		cmds = append(cmds, cmd)
+
+		// Cursor blink of an input dialog
+		_, cmd = m.dialog.Update(msg)
+		cmds = append(cmds, cmd)
+	}
+
 	// Update monitor pane
 	if m.monitorPane != nil {
 		var cmd tea.Cmd
@@ -558,6 +1040,43 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 type commandResultMsg core.CommandResult
 
+// commandOutputMsg carries a chunk of output from the running command.
+type commandOutputMsg core.OutputChunk
+
+// completionMsg carries the completions for input at cursor.
+type completionMsg struct {
+	input       string
+	cursor      int
+	suggestions []core.Suggestion
+}
+
+// complete gathers completions for input at cursor.
+func complete(completer *autocomplete.Completer, input string, cursor int) tea.Cmd {
+	return func() tea.Msg {
+		suggestions, _ := completer.Complete(input, cursor)
+		return completionMsg{input: input, cursor: cursor, suggestions: suggestions}
+	}
+}
+
+// waitForCommandEvent returns a command that waits for the next event from
+// a running command and converts it into a message.
+func waitForCommandEvent(events <-chan core.CommandEvent) tea.Cmd {
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		for ev := range events {
+			if ev.Result != nil {
+				return commandResultMsg(*ev.Result)
+			}
+			if ev.Chunk != nil {
+				return commandOutputMsg(*ev.Chunk)
+			}
+		}
+		return nil
+	}
+}
+
 // executeCommand processes and executes a user command.
 //
 // This function:
@@ -574,6 +1093,101 @@ func (m Model) executeCommand() (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	// Risky commands wait for confirmation; unedited AI suggestions have
+	// their own, usually lower, threshold
+	fromAI := command == strings.TrimSpace(m.aiSuggestion)
+	threshold := m.config.Shell.ConfirmRisk
+	if fromAI {
+		threshold = m.config.AI.ConfirmRisk
+	}
+	if risk := assessCommand(command); risk.NeedsConfirmation(threshold) {
+		m.pendingCommand = command
+		return m, m.dialog.Open(riskDialog(risk, fromAI))
+	}
+
+	return m.runInput(command)
+}
+
+// assessCommand rates the risk of a command line. Builtins running another
+// command are rated by that command: with-secrets by the one after --,
+// and fanout by the one it runs remotely, a level higher as it runs on
+// every host of the group.
+func assessCommand(command string) *privileges.Assessment {
+	if fields := strings.Fields(command); len(fields) > 0 && fields[0] == "with-secrets" {
+		if _, run, err := parseWithSecrets(command); err == nil {
+			risk := assessCommand(run)
+			risk.Command = command
+			return risk
+		}
+	}
+	if isFanOut(command) {
+		if req, err := parseFanOut(command, ssh.FanOutOptions{}); err == nil && !req.listGroups {
+			risk := privileges.AnalyzeCommand(req.remote)
+			risk.Command = command
+			risk.Escalate("runs on every host of " + req.target)
+			return risk
+		}
+	}
+	return privileges.AnalyzeCommand(command)
+}
+
+// riskDialogID identifies the risky command confirmation dialog.
+const riskDialogID = "risk"
+
+// riskDialog asks whether to run a risky command. Critical commands must
+// be confirmed by typing "yes" rather than with a single key.
+func riskDialog(risk *privileges.Assessment, fromAI bool) dialog.Spec {
+	title := "Run this command?"
+	if fromAI {
+		title = "Run this AI-suggested command?"
+	}
+
+	var body strings.Builder
+	body.WriteString(risk.Command)
+	fmt.Fprintf(&body, "\n\nRisk: %s", risk.Level)
+	for _, reason := range risk.Reasons() {
+		body.WriteString("\n  • " + reason)
+	}
+
+	spec := dialog.Spec{
+		ID:      riskDialogID,
+		Title:   title,
+		Danger:  risk.Level >= privileges.RiskHigh,
+		Options: []dialog.Option{{Key: "y", Label: "run it"}, {Key: "n", Label: "don't run"}},
+	}
+	if risk.Level == privileges.RiskCritical {
+		body.WriteString("\n\nType yes to run it.")
+		spec.Input = true
+		spec.Placeholder = "yes"
+	}
+	spec.Body = body.String()
+	return spec
+}
+
+// dialogResult acts on the answer to a dialog.
+func (m Model) dialogResult(msg dialog.ResultMsg) (tea.Model, tea.Cmd) {
+	switch msg.ID {
+	case riskDialogID:
+		command := m.pendingCommand
+		m.pendingCommand = ""
+		confirmed := msg.Choice == "y" || strings.EqualFold(strings.TrimSpace(msg.Input), "yes")
+		// The prompt is left as it was so a declined command can be edited
+		if !confirmed || command == "" {
+			return m, nil
+		}
+		return m.runInput(command)
+
+	case hostKeyDialogID, unlockDialogID, passwordDialogID:
+		m.promptAnswered(msg)
+	}
+	return m, nil
+}
+
+// runInput runs the command entered at the prompt: a background job, a
+// builtin or a shell command.
+func (m Model) runInput(command string) (tea.Model, tea.Cmd) {
+	m.aiSuggestion = ""
+
 	// Add to command history for up/down arrow recall
 	m.history.Add(command)
 	m.history.Reset()
@@ -581,56 +1195,202 @@ func (m Model) executeCommand() (tea.Model, tea.Cmd) {
 	// Show command in output with current prompt
 	m.addOutput(m.getPrompt()+command, true, 0)
 
+	pane := m.paneManager.ActivePane()
+	start := time.Now()
+
+	// Trailing & runs the command as a background job
+	if job, ok := backgroundCommand(command); ok {
+		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
+		return m, m.startBackgroundJob(job)
+	}
+
+	// Handle job control builtins (jobs, fg, bg, kill %n, wait)
+	if handled, cmd := m.handleJobBuiltin(command); handled {
+		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
+		return m, cmd
+	}
+
+	// Handle the remote pane builtin
+	if handled, cmd := m.handleRemoteBuiltin(command); handled {
+		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
+		return m, cmd
+	}
+
+	// Handle the SSH tunnel builtin
+	if handled, cmd := m.handleTunnelBuiltin(command); handled {
+		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
+		return m, cmd
+	}
+
+	// Handle the fan-out builtin for host groups
+	if handled, cmd := m.handleFanOutBuiltin(command); handled {
+		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
+		return m, cmd
+	}
+
+	// Handle the SFTP file transfer builtins
+	if handled, cmd := m.handleTransferBuiltin(command); handled {
+		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
+		return m, cmd
+	}
+
+	// List secrets and manage their metadata
+	if handled, cmd := m.handleSecretsStoreBuiltin(command); handled {
+		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
+		return m, cmd
+	}
+
+	// Run a command with secrets in its environment; like other commands
+	// it is recorded once it finishes
+	if handled, cmd := m.handleSecretsBuiltin(command); handled {
+		m.input.Reset()
+		if cmd == nil {
+			m.recordHistory(command, pane, paneDir(pane), start, 1)
+			return m, nil
+		}
+		m.executing = true
+		m.execStart = start
+		m.execCommand = command
+		m.execDir = paneDir(pane)
+		return m, tea.Batch(m.spinner.Tick, cmd)
+	}
+
 	// Handle built-in commands (cd, exit, help, etc.)
 	if handled, model := m.handleBuiltin(command); handled {
 		m.input.Reset()
+		m.recordHistory(command, pane, paneDir(pane), start, 0)
 		return model, nil
 	}
 
 	// Execute external command via shell
 	m.executing = true
+	m.execStart = start
+	m.execCommand = command
+	m.execDir = paneDir(pane)
 	m.input.Reset()
 
+	// Start the command before returning m so the stream is recorded
+	run := m.runCommand(command)
+
 	return m, tea.Batch(
-		m.spinner.Tick,      // Show spinner while executing
-		m.runCommand(command), // Run the command asynchronously
+		m.spinner.Tick, // Show spinner while executing
+		run,            // Stream the command's output
 	)
 }
 
-// runCommand executes a shell command and returns the result as a message.
+// runCommand starts a shell command in the active pane and streams its output.
 //
-// This function runs asynchronously in the background and sends a
-// commandResultMsg when complete. It handles:
+// The command is started immediately via the executor's ExecuteAsync and
+// the stream is recorded on the model. The returned tea.Cmd delivers output
+// as commandOutputMsg values while the command runs, followed by a
+// commandResultMsg once it completes. It handles:
 //   - Checking for an active pane
-//   - Executing the command via the shell executor
-//   - Capturing output, errors, and exit codes
+//   - Starting the command via the shell executor
 //   - Proper error handling and reporting
 //
-// Returns a tea.Cmd that will send a commandResultMsg when the command completes.
+// Returns a tea.Cmd that will send the first event from the command.
 func (m *Model) runCommand(command string) tea.Cmd {
-	return func() tea.Msg {
-		// Ensure we have an active pane to run the command in
-		pane := m.paneManager.ActivePane()
-		if pane == nil {
+	return m.runCommandIn(m.paneManager.ActivePane(), command)
+}
+
+// runCommandIn starts a shell command in the given pane and streams its
+// output, as runCommand does for the active pane.
+func (m *Model) runCommandIn(pane *panes.Pane, command string) tea.Cmd {
+	return m.runCommandWithEnv(pane, command, nil)
+}
+
+// runCommandWithEnv starts a shell command in the given pane as
+// runCommandIn does, with env, if not nil, added to the environment of the
+// command's process only.
+func (m *Model) runCommandWithEnv(pane *panes.Pane, command string, env map[string]string) tea.Cmd {
+	// Ensure we have an active pane to run the command in
+	if pane == nil {
+		return func() tea.Msg {
 			return commandResultMsg{
 				Command:  command,
 				Error:    "No active pane available. Press Ctrl+N to create a new pane.",
 				ExitCode: -1,
 			}
 		}
+	}
 
-		// Execute the command with a background context
-		// Context allows for future cancellation support
-		result, err := pane.GetShellExecutor().Execute(context.Background(), command)
-		if err != nil {
+	// Execute the command with a background context
+	// Ctrl+C interrupts via the executor instead of cancelling the context
+	executor := pane.GetShellExecutor()
+	var events <-chan core.CommandEvent
+	var err error
+	if env != nil {
+		events, err = executor.ExecuteAsyncWithEnv(context.Background(), command, env)
+	} else {
+		events, err = executor.ExecuteAsync(context.Background(), command)
+	}
+	if err != nil {
+		return func() tea.Msg {
 			return commandResultMsg{
 				Command:  command,
 				Error:    fmt.Sprintf("Execution failed: %v", err),
 				ExitCode: -1,
 			}
 		}
+	}
 
-		return commandResultMsg(*result)
+	m.running = events
+	m.runningPane = pane
+	m.pendingStdout = ""
+	m.pendingStderr = ""
+
+	return waitForCommandEvent(events)
+}
+
+// appendChunk adds streamed output to the output buffer, one line at a
+// time. A trailing partial line is held until the rest of it arrives.
+func (m *Model) appendChunk(chunk core.OutputChunk) {
+	isStderr := chunk.Stream == core.StreamStderr
+	pending := &m.pendingStdout
+	if isStderr {
+		pending = &m.pendingStderr
+	}
+
+	lines := strings.Split(*pending+chunk.Data, "\n")
+	*pending = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		m.addStreamLine(strings.TrimSuffix(line, "\r"), isStderr)
+	}
+}
+
+// flushPendingOutput adds any partial lines left when the command finishes.
+func (m *Model) flushPendingOutput() {
+	if m.pendingStdout != "" {
+		m.addStreamLine(strings.TrimSuffix(m.pendingStdout, "\r"), false)
+		m.pendingStdout = ""
+	}
+	if m.pendingStderr != "" {
+		m.addStreamLine(strings.TrimSuffix(m.pendingStderr, "\r"), true)
+		m.pendingStderr = ""
+	}
+}
+
+// addStreamLine adds a line of command output to the output buffer and to
+// the output buffer of the pane running the command.
+func (m *Model) addStreamLine(content string, isStderr bool) {
+	content = m.outputMask.Mask(content)
+	m.commandOutput = append(m.commandOutput, outputLine{
+		content:  content,
+		isStderr: isStderr,
+	})
+	if len(m.commandOutput) > 1000 {
+		m.commandOutput = m.commandOutput[len(m.commandOutput)-1000:]
+	}
+
+	if m.runningPane != nil {
+		m.runningPane.AppendOutput(content)
 	}
 }
 
@@ -642,8 +1402,8 @@ func (m *Model) runCommand(command string) tea.Cmd {
 //   - clear: Clear the output buffer
 //   - cd: Change working directory
 //   - help: Show help information
-//   - jobs: List background jobs
 //   - whoami: Show current user
+//   - history: Search the command history database
 //
 // Returns:
 //   - bool: true if the command was handled as a built-in
@@ -665,6 +1425,12 @@ func (m *Model) handleBuiltin(command string) (bool, tea.Model) {
 		return true, m
 		
 	case "cd":
+		// Panes with a persistent session let the shell handle cd itself,
+		// which also covers `cd -`, bare `cd` and cd in compound commands
+		if pane := m.paneManager.ActivePane(); pane != nil && pane.GetShellExecutor().SessionEnabled() {
+			return false, m
+		}
+
 		// Change working directory
 		if len(parts) > 1 {
 			pane := m.paneManager.ActivePane()
@@ -683,23 +1449,15 @@ func (m *Model) handleBuiltin(command string) (bool, tea.Model) {
 	case "help":
 		m.mode = ModeHelp
 		return true, m
-	case "jobs":
-		// List background jobs
-		jobs := m.jobManager.ListJobs()
-		if len(jobs) == 0 {
-			m.addOutput("No background jobs", false, 0)
-		} else {
-			for _, job := range jobs {
-				m.addOutput(job.String(), false, 0)
-			}
-		}
-		return true, m
 	case "whoami":
 		info := m.privilegeManager.GetUserInfo()
 		if info != nil {
 			m.addOutput(info.Username, false, 0)
 		}
 		return true, m
+	case "history":
+		m.showHistory(parts[1:])
+		return true, m
 	}
 
 	return false, m
@@ -724,6 +1482,9 @@ func (m Model) getPrompt() string {
 		return "$ "
 	}
 	cwd := pane.GetShellExecutor().GetWorkingDirectory()
+	if !pane.GetShellExecutor().Local() {
+		cwd = pane.Title() + ":" + cwd
+	}
 	return m.styles.Prompt.Render(cwd) + " " + m.styles.PromptSymbol.Render("❯") + " "
 }
 
@@ -745,18 +1506,43 @@ func (m Model) View() string {
 	header := m.renderHeader()
 	sections = append(sections, header)
 
-	// Main content area (with monitor pane if visible)
-	var mainArea string
+	// Main content area (with monitor and chat panes if visible)
+	content := m.renderContent()
+	if m.browser.IsVisible() {
+		content = m.browser.View()
+	}
+	columns := []string{content}
 	if m.showMonitor && m.monitorPane != nil {
 		// Split layout: content on left, monitor on right
-		content := m.renderContent()
-		monitorView := m.monitorPane.View()
-		mainArea = lipgloss.JoinHorizontal(lipgloss.Top, content, monitorView)
-	} else {
-		mainArea = m.renderContent()
+		columns = append(columns, m.monitorPane.View())
+	}
+	if m.tunnelView.IsVisible() {
+		columns = append(columns, m.tunnelView.View())
 	}
+	if m.transferView.IsVisible() {
+		columns = append(columns, m.transferView.View())
+	}
+	if m.chatPane.IsVisible() {
+		columns = append(columns, m.chatPane.View())
+	}
+	mainArea := lipgloss.JoinHorizontal(lipgloss.Top, columns...)
 	sections = append(sections, mainArea)
 
+	// History search overlay, above the prompt it fills in
+	if m.historySearch.IsVisible() {
+		sections = append(sections, m.historySearch.View())
+	}
+
+	// Dialog awaiting an answer, such as a risky command's confirmation
+	if m.dialog.IsVisible() {
+		sections = append(sections, m.dialog.View())
+	}
+
+	// Toasts (background job completion, etc.)
+	if toasts := m.notifications.View(); toasts != "" {
+		sections = append(sections, lipgloss.PlaceHorizontal(m.width, lipgloss.Right, toasts))
+	}
+
 	// Input area
 	inputArea := m.renderInput()
 	sections = append(sections, inputArea)
@@ -789,18 +1575,26 @@ func (m Model) renderContent() string {
 		return m.renderHelp()
 	}
 
+	// Include partial lines from the running command
+	output := m.commandOutput
+	if m.executing && (m.pendingStdout != "" || m.pendingStderr != "") {
+		output = append(output[:len(output):len(output)], m.pendingLines()...)
+	}
+
 	// Render command output
 	var lines []string
 	outputHeight := m.height - 6 // Leave room for header, input, status
 	startIdx := 0
-	if len(m.commandOutput) > outputHeight {
-		startIdx = len(m.commandOutput) - outputHeight
+	if len(output) > outputHeight {
+		startIdx = len(output) - outputHeight
 	}
 
-	for i := startIdx; i < len(m.commandOutput); i++ {
-		line := m.commandOutput[i]
+	for i := startIdx; i < len(output); i++ {
+		line := output[i]
 		if line.isCommand {
 			lines = append(lines, m.highlighter.HighlightCommand(line.content))
+		} else if line.isStderr {
+			lines = append(lines, m.styles.Stderr.Render(line.content))
 		} else if line.exitCode != 0 {
 			lines = append(lines, m.styles.Error.Render(line.content))
 		} else {
@@ -812,11 +1606,24 @@ func (m Model) renderContent() string {
 	return content
 }
 
+// pendingLines returns the running command's partial lines for display.
+func (m Model) pendingLines() []outputLine {
+	var lines []outputLine
+	if m.pendingStdout != "" {
+		lines = append(lines, outputLine{content: m.outputMask.Mask(strings.TrimSuffix(m.pendingStdout, "\r"))})
+	}
+	if m.pendingStderr != "" {
+		lines = append(lines, outputLine{content: m.outputMask.Mask(strings.TrimSuffix(m.pendingStderr, "\r")), isStderr: true})
+	}
+	return lines
+}
+
 func (m Model) renderInput() string {
 	prompt := m.getPrompt()
 
 	if m.executing {
-		return prompt + m.spinner.View() + " Running..."
+		elapsed := m.styles.Muted.Render(formatElapsed(time.Since(m.execStart)))
+		return prompt + m.spinner.View() + " Running... " + elapsed
 	}
 
 	inputView := m.input.View()
@@ -830,6 +1637,15 @@ func (m Model) renderInput() string {
 	return prompt + inputView
 }
 
+// formatElapsed formats a running command's elapsed time for the input line.
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+}
+
 func (m Model) renderSuggestions() string {
 	var lines []string
 
@@ -860,6 +1676,9 @@ func (m Model) renderStatusBar() string {
 
 	left := fmt.Sprintf(" %s | %s", shellType, cwd)
 	right := fmt.Sprintf("Panes: %d ", m.paneManager.Count())
+	if connections := m.connectionStatus(); connections != "" {
+		right = connections + " | " + right
+	}
 
 	gap := m.width - lipgloss.Width(left) - lipgloss.Width(right)
 	if gap < 0 {
@@ -878,6 +1697,8 @@ func (m Model) renderHelp() string {
 |-----|--------|
 | Ctrl+Q | Quit |
 | Ctrl+C | Cancel current command |
+| Ctrl+Z | Suspend current command into a job |
+| Ctrl+R | Search history (Ctrl+F filters by exit status) |
 | Enter | Execute command |
 | Tab | Autocomplete |
 | ↑/↓ | Navigate history |
@@ -899,6 +1720,22 @@ func (m Model) renderHelp() string {
 - **clear** - Clear screen
 - **exit** - Exit shell
 - **help** - Show this help
+- **jobs** - List background jobs
+- **fg** / **bg** *%n* - Resume a job in the foreground or background
+- **kill** *[-SIGNAL] %n* - Signal a job
+- **wait** *[%n]* - Wait for background jobs
+- **joblog** *%n* - Show a background job's output
+- **history** *[--failed] [--here] [--since 7d] [text]* - Search history
+- **remote** *[open] host | list | close name* - Open a pane on a remote host
+- **tunnel** *list | view | add name -L/-R/-D spec | stop id* - Manage SSH port forwards
+- **put** / **get** *[-r] [-a] name source [dest]* - Copy files over SFTP
+- **transfer** *list | view | cancel id | retry id | clear* - Manage file transfers
+- **browse** *name* - Browse local and remote files side by side
+- **fanout** *[-j n] [-t duration] group command | groups* - Run a command on a host group
+- **with-secrets** *[NAME=]secret... -- command* - Run a command with secrets in its environment
+- **secrets** *[list [tag] | expiring [within] | describe/tag/expire/rotate name ... | audit [n] | lock | info | rekey | export file | import file]* - Manage secrets, their audit log and bundles
+
+End a command with **&** to run it as a background job.
 
 Press any key to return...
 `
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/textinput"
+
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/privileges"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+)
+
+func newRiskTestModel(input, aiSuggestion string) Model {
+	ti := textinput.New()
+	ti.SetValue(input)
+	return Model{
+		config:       config.Default(),
+		dialog:       dialog.New(),
+		input:        ti,
+		aiSuggestion: aiSuggestion,
+	}
+}
+
+func TestExecuteCommandConfirmsRisk(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		aiSuggestion string
+		critical     bool
+	}{
+		{"high risk", "git push --force", "", false},
+		{"critical risk", "sudo rm -rf /", "", true},
+		{"AI suggestion", "rm notes.txt", "rm notes.txt", false},
+		{"fan-out", "fanout web rm -rf /", "", true},
+		{"with secrets", "with-secrets TOKEN -- rm -rf /", "", true},
+		{"fan-out raises the risk", "fanout -j 2 web rm -rf build", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, _ := newRiskTestModel(tt.input, tt.aiSuggestion).executeCommand()
+			m := model.(Model)
+
+			if !m.dialog.IsVisible() || m.dialog.ID() != riskDialogID {
+				t.Fatal("expected the risk confirmation dialog")
+			}
+			if m.pendingCommand != tt.input {
+				t.Errorf("pendingCommand = %q, want %q", m.pendingCommand, tt.input)
+			}
+			if m.input.Value() != tt.input {
+				t.Errorf("input = %q; it should stay until the command runs", m.input.Value())
+			}
+
+			view := m.dialog.View()
+			if tt.critical != strings.Contains(view, "Type yes") {
+				t.Errorf("typed confirmation = %v, want %v", !tt.critical, tt.critical)
+			}
+			if (tt.aiSuggestion != "") != strings.Contains(view, "AI-suggested") {
+				t.Error("the dialog should say when the AI suggested the command")
+			}
+		})
+	}
+}
+
+func TestDialogResultDeclined(t *testing.T) {
+	m := newRiskTestModel("git push --force", "")
+	m.pendingCommand = "git push --force"
+
+	model, cmd := m.dialogResult(dialog.ResultMsg{ID: riskDialogID, Choice: "n"})
+	m = model.(Model)
+	if cmd != nil || m.pendingCommand != "" {
+		t.Error("a declined command should not run")
+	}
+	if m.input.Value() != "git push --force" {
+		t.Error("a declined command should stay at the prompt for editing")
+	}
+
+	m.pendingCommand = "sudo rm -rf /"
+	model, cmd = m.dialogResult(dialog.ResultMsg{ID: riskDialogID, Input: "y"})
+	if cmd != nil || model.(Model).pendingCommand != "" {
+		t.Error("a critical command should only run when yes is typed")
+	}
+}
+
+func TestRiskDialog(t *testing.T) {
+	spec := riskDialog(privileges.AnalyzeCommand("chmod -R 777 public"), false)
+	if !spec.Danger || spec.Input {
+		t.Errorf("spec = %+v; want a y/n danger dialog", spec)
+	}
+	for _, want := range []string{"chmod -R 777 public", "Risk: high", "writable by every user"} {
+		if !strings.Contains(spec.Body, want) {
+			t.Errorf("body %q does not contain %q", spec.Body, want)
+		}
+	}
+}
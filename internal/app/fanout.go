@@ -0,0 +1,219 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/fanout"
+)
+
+// fanoutSpec describes the fanout builtin for completion.
+const fanoutSpec = `
+name: fanout
+description: Run a command on every host of a group
+subcommands:
+  - name: groups
+    description: List host groups and their hosts
+options:
+  - names: [-j]
+    description: Hosts to run on at once
+    arg: {name: count}
+  - names: [-t]
+    description: Timeout for each host
+    arg: {name: duration}
+args:
+  - name: group
+    generator: groups
+  - name: command
+    variadic: true
+`
+
+// fanOutDoneMsg carries the results of a fan-out.
+type fanOutDoneMsg struct {
+	target  string
+	results []ssh.HostResult
+}
+
+// handleFanOutBuiltin runs the fanout builtin, which runs a command on
+// every host of a group:
+//
+//	fanout [-j N] [-t DURATION] GROUP COMMAND   run COMMAND on GROUP
+//	fanout groups                               list groups and their hosts
+//
+// GROUP is a group from the configuration, a Host pattern of
+// ~/.ssh/config such as web-*, or a comma-separated list of hosts and
+// patterns. -j bounds how many hosts run at once and -t how long each host
+// may take, overriding the configuration. The results are grouped by
+// outcome once every host is done.
+//
+// Returns whether the command was handled and any command to run.
+func (m *Model) handleFanOutBuiltin(command string) (bool, tea.Cmd) {
+	if !isFanOut(command) {
+		return false, nil
+	}
+	req, err := parseFanOut(command, m.fanOutOptions())
+	if err != nil {
+		m.addOutput(err.Error(), false, 1)
+		return true, nil
+	}
+	if req.listGroups {
+		m.listGroups()
+		return true, nil
+	}
+	target, remote, opts := req.target, req.remote, req.opts
+
+	hosts, err := m.sshManager.GroupHosts(target)
+	if err != nil {
+		m.addOutput("fanout: "+err.Error(), false, 1)
+		return true, nil
+	}
+
+	m.addOutput(fmt.Sprintf("Running on %s: %s", plural(len(hosts), "host"), strings.Join(hosts, ", ")), false, 0)
+	manager := m.sshManager
+	return true, func() tea.Msg {
+		results := manager.FanOut(context.Background(), hosts, remote, opts)
+		return fanOutDoneMsg{target: target, results: results}
+	}
+}
+
+// fanOutUsage describes the fanout builtin.
+const fanOutUsage = "usage: fanout [-j N] [-t DURATION] GROUP COMMAND | fanout groups"
+
+// fanOutRequest is a parsed fanout command line.
+type fanOutRequest struct {
+	listGroups bool   // fanout groups
+	target     string // The group, pattern or list of hosts
+	remote     string // The command to run on each host, as written
+	opts       ssh.FanOutOptions
+}
+
+// isFanOut reports whether command runs the fanout builtin.
+func isFanOut(command string) bool {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(command), "fanout")
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t')
+}
+
+// parseFanOut parses a fanout command line, with opts as the limits
+// unless it overrides them.
+func parseFanOut(command string, opts ssh.FanOutOptions) (fanOutRequest, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(command), "fanout")
+	var target string
+	for target == "" {
+		word, remaining := nextWord(rest)
+		rest = remaining
+		switch word {
+		case "":
+			return fanOutRequest{}, errors.New(fanOutUsage)
+		case "groups":
+			if strings.TrimSpace(rest) == "" {
+				return fanOutRequest{listGroups: true}, nil
+			}
+			target = word
+		case "-j":
+			word, rest = nextWord(rest)
+			n, err := strconv.Atoi(word)
+			if err != nil || n < 1 {
+				return fanOutRequest{}, errors.New("fanout: -j needs a number of hosts")
+			}
+			opts.Concurrency = n
+		case "-t":
+			word, rest = nextWord(rest)
+			timeout, err := parseTimeout(word)
+			if err != nil {
+				return fanOutRequest{}, errors.New("fanout: -t needs a duration such as 10s")
+			}
+			opts.Timeout = timeout
+		default:
+			if strings.HasPrefix(word, "-") {
+				return fanOutRequest{}, fmt.Errorf("fanout: unknown option %s", word)
+			}
+			target = word
+		}
+	}
+
+	remote := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), "--"))
+	if remote == "" {
+		return fanOutRequest{}, errors.New(fanOutUsage)
+	}
+	return fanOutRequest{target: target, remote: remote, opts: opts}, nil
+}
+
+// fanOutDone reports the results of a fan-out, grouped by outcome, and
+// raises a toast with the number of hosts that failed.
+func (m *Model) fanOutDone(msg fanOutDoneMsg) tea.Cmd {
+	for _, line := range fanout.NewReport().Lines(msg.results) {
+		m.addOutput(line, false, 0)
+	}
+
+	failed := 0
+	for _, result := range msg.results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		m.notifications.ShowError("Fan-out finished",
+			fmt.Sprintf("%s: %d of %s failed", msg.target, failed, plural(len(msg.results), "host")))
+	} else {
+		m.notifications.ShowSuccess("Fan-out finished",
+			fmt.Sprintf("%s: %s ok", msg.target, plural(len(msg.results), "host")))
+	}
+	return notificationTick()
+}
+
+// listGroups prints the host groups and their hosts.
+func (m *Model) listGroups() {
+	groups := m.sshManager.Groups()
+	if len(groups) == 0 {
+		m.addOutput("No host groups", false, 0)
+		return
+	}
+	for _, group := range groups {
+		hosts, err := m.sshManager.GroupHosts(group)
+		if err != nil {
+			m.addOutput(fmt.Sprintf("%-16s %v", group, err), false, 1)
+			continue
+		}
+		m.addOutput(fmt.Sprintf("%-16s %s", group, strings.Join(hosts, ", ")), false, 0)
+	}
+}
+
+// fanOutOptions returns the fan-out limits of the configuration.
+func (m *Model) fanOutOptions() ssh.FanOutOptions {
+	if m.config == nil {
+		return ssh.FanOutOptions{}
+	}
+	return ssh.FanOutOptions{
+		Concurrency: m.config.SSH.FanOutConcurrency,
+		Timeout:     time.Duration(m.config.SSH.FanOutTimeout) * time.Second,
+	}
+}
+
+// nextWord splits the first space-separated word off s, leaving the rest
+// as written so the command keeps its quoting and spacing.
+func nextWord(s string) (string, string) {
+	s = strings.TrimLeft(s, " \t")
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], s[i:]
+	}
+	return s, ""
+}
+
+// parseTimeout reads a duration such as 10s, or a number of seconds.
+func parseTimeout(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(s)
+	if err == nil && timeout <= 0 {
+		err = fmt.Errorf("invalid timeout: %s", s)
+	}
+	return timeout, err
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/autocomplete"
+)
+
+func TestHandleFanOutBuiltin(t *testing.T) {
+	m := newRemoteTestModel()
+	m.sshManager.SetGroups(map[string][]string{"web": {"web", "web-2.example.com"}})
+
+	if handled, _ := m.handleFanOutBuiltin("fanouts web uptime"); handled {
+		t.Error("only fanout should be handled")
+	}
+
+	m.handleFanOutBuiltin("fanout groups")
+	if got := lastOutput(m); !strings.Contains(got, "web") || !strings.Contains(got, "web-2.example.com") {
+		t.Errorf("expected the web group listed, got %q", got)
+	}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"fanout", "usage: fanout"},
+		{"fanout web", "usage: fanout"},
+		{"fanout -j 0 web uptime", "-j needs a number"},
+		{"fanout -t soon web uptime", "-t needs a duration"},
+		{"fanout -x web uptime", "unknown option -x"},
+		{"fanout db uptime", "group not found: db"},
+		{"fanout cache-* uptime", "no hosts in cache-*"},
+	}
+	for _, tt := range tests {
+		if handled, cmd := m.handleFanOutBuiltin(tt.command); !handled || cmd != nil {
+			t.Errorf("%s: expected to be handled without running", tt.command)
+		}
+		if !strings.Contains(lastOutput(m), tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.want, lastOutput(m))
+		}
+	}
+
+	if _, cmd := m.handleFanOutBuiltin("fanout -j 2 -t 5 web -- uptime"); cmd == nil {
+		t.Fatal("expected a command running the fan-out")
+	}
+	if got := lastOutput(m); got != "Running on 2 hosts: web, web-2.example.com" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestFanOutDone(t *testing.T) {
+	m := newRemoteTestModel()
+
+	m.fanOutDone(fanOutDoneMsg{target: "web", results: []ssh.HostResult{
+		{Host: "web-1", Output: "ok\n"},
+		{Host: "web-2", ExitCode: -1, Err: errors.New("timed out after 30s")},
+	}})
+
+	var out []string
+	for _, line := range m.commandOutput {
+		out = append(out, line.content)
+	}
+	report := strings.Join(out, "\n")
+	for _, want := range []string{"1 failed", "timed out after 30s", "web-2", "ok"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected %q in the report:\n%s", want, report)
+		}
+	}
+	if strings.Index(report, "web-2") > strings.Index(report, "web-1") {
+		t.Error("expected failures before successes")
+	}
+}
+
+func TestNextWordKeepsSpacing(t *testing.T) {
+	word, rest := nextWord("  web  echo 'a  b'")
+	if word != "web" || rest != "  echo 'a  b'" {
+		t.Errorf("nextWord = %q, %q", word, rest)
+	}
+}
+
+func TestFanOutSpecParses(t *testing.T) {
+	if _, err := autocomplete.ParseSpec([]byte(fanoutSpec)); err != nil {
+		t.Fatalf("fanout spec does not parse: %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/history"
+	"github.com/cbwinslow/cbwsh/pkg/logging"
+	"github.com/cbwinslow/cbwsh/pkg/panes"
+	"github.com/cbwinslow/cbwsh/pkg/ui/palette"
+)
+
+// defaultHistoryLimit is how many entries the history builtin shows when
+// no -n is given.
+const defaultHistoryLimit = 25
+
+// loadHistory seeds up/down arrow recall with the most recent commands in
+// the history database.
+func (m *Model) loadHistory() error {
+	entries, err := m.historyStore.Entries()
+	if err != nil {
+		return err
+	}
+	if size := m.config.Shell.HistorySize; size > 0 && len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	for _, entry := range entries {
+		m.history.Add(entry.Command)
+	}
+	m.history.Reset()
+	return nil
+}
+
+// historyWriter appends entries to the history database from its own
+// goroutine, in the order they were recorded, so that file locking and
+// syncing never hold up the UI.
+type historyWriter struct {
+	entries chan history.Entry
+	done    chan struct{}
+}
+
+// newHistoryWriter starts a writer for store. Failed appends are logged.
+func newHistoryWriter(store *history.Store, logger *logging.Logger) *historyWriter {
+	w := &historyWriter{
+		entries: make(chan history.Entry, 256),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for entry := range w.entries {
+			if err := store.Append(entry); err != nil {
+				logger.Warnf("Failed to record command history: %v", err)
+			}
+		}
+	}()
+	return w
+}
+
+// record queues an entry to be appended.
+func (w *historyWriter) record(entry history.Entry) {
+	w.entries <- entry
+}
+
+// close waits for queued entries to be written and stops the writer.
+func (w *historyWriter) close() {
+	close(w.entries)
+	<-w.done
+}
+
+// recordHistory queues a command to be appended to the history database.
+// Commands that cbwsh handles itself, and commands sent to the background,
+// are recorded as soon as they are entered with the status the shell would
+// give them.
+func (m *Model) recordHistory(command string, pane *panes.Pane, dir string, start time.Time, exitCode int) {
+	entry := history.Entry{
+		Command:   command,
+		Timestamp: start,
+		Dir:       dir,
+		ExitCode:  exitCode,
+		Duration:  time.Since(start),
+		Host:      m.hostname,
+		SessionID: m.sessionID,
+	}
+	if pane != nil {
+		entry.PaneID = pane.ID()
+	}
+	m.historyWriter.record(entry)
+}
+
+// paneDir returns the working directory of pane, or "" without one.
+func paneDir(pane *panes.Pane) string {
+	if pane == nil {
+		return ""
+	}
+	return pane.GetShellExecutor().GetWorkingDirectory()
+}
+
+// showHistory runs the history builtin, listing matching commands from
+// every cbwsh instance, oldest of the selection first:
+//
+//	history [-n N] [--failed] [--here] [--dir DIR] [--since AGE]
+//	        [--host HOST] [--session] [text]
+func (m *Model) showHistory(args []string) {
+	query, err := m.parseHistoryArgs(args)
+	if err != nil {
+		m.addOutput("history: "+err.Error(), false, 1)
+		return
+	}
+
+	entries, err := m.historyStore.Query(query)
+	if err != nil {
+		m.addOutput("history: "+err.Error(), false, 1)
+		return
+	}
+	if len(entries) == 0 {
+		m.addOutput("No matching commands", false, 0)
+		return
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		m.addOutput(formatHistoryEntry(entries[i]), false, entries[i].ExitCode)
+	}
+}
+
+// parseHistoryArgs builds a history query from the builtin's arguments.
+func (m *Model) parseHistoryArgs(args []string) (history.Query, error) {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	limit := flags.Int("n", defaultHistoryLimit, "")
+	failed := flags.Bool("failed", false, "")
+	here := flags.Bool("here", false, "")
+	dir := flags.String("dir", "", "")
+	since := flags.String("since", "", "")
+	host := flags.String("host", "", "")
+	session := flags.Bool("session", false, "")
+	if err := flags.Parse(args); err != nil {
+		return history.Query{}, err
+	}
+
+	query := history.Query{
+		Text:       strings.Join(flags.Args(), " "),
+		Dir:        *dir,
+		Host:       *host,
+		FailedOnly: *failed,
+		Limit:      *limit,
+	}
+	if *here {
+		// The repository, or the directory outside of one
+		cwd := paneDir(m.paneManager.ActivePane())
+		if query.Dir = history.ProjectRoot(cwd); query.Dir == "" {
+			query.Dir = cwd
+		}
+	}
+	if *session {
+		query.SessionID = m.sessionID
+	}
+	if *since != "" {
+		age, err := history.ParseAge(*since)
+		if err != nil {
+			return history.Query{}, err
+		}
+		query.Since = time.Now().Add(-age)
+	}
+	return query, nil
+}
+
+// formatHistoryEntry renders an entry as a line of history output.
+func formatHistoryEntry(e history.Entry) string {
+	// Entries carried over from the plain-text format have no metadata
+	when, status, elapsed := "-", "", "-"
+	if !e.Timestamp.IsZero() {
+		when = e.Timestamp.Local().Format("2006-01-02 15:04")
+		status = "✓"
+		if e.Failed() {
+			status = fmt.Sprintf("✗%d", e.ExitCode)
+		}
+		elapsed = formatElapsed(e.Duration)
+	}
+
+	line := fmt.Sprintf("%-16s %-4s %7s  %s", when, status, elapsed, e.Command)
+	if e.Dir != "" {
+		line += "  # " + e.Dir
+	}
+	return line
+}
+
+// historyStatus selects which commands the history search shows by the
+// exit status of their most recent run.
+type historyStatus int
+
+const (
+	historyAnyStatus historyStatus = iota
+	historySucceeded
+	historyFailed
+)
+
+// historySearchPrompt returns the search prompt for the status filter.
+func (s historyStatus) historySearchPrompt() string {
+	switch s {
+	case historySucceeded:
+		return "(reverse-i-search, succeeded) "
+	case historyFailed:
+		return "(reverse-i-search, failed) "
+	default:
+		return "(reverse-i-search) "
+	}
+}
+
+// historySelectedMsg carries the command picked in the history search.
+type historySelectedMsg struct {
+	command string
+}
+
+// historyWeightScale converts frecency scores into palette weights, which
+// are comparable to fuzzy match scores for short queries.
+const historyWeightScale = 8
+
+// newHistorySearch creates the Ctrl+R overlay.
+func newHistorySearch() *palette.Palette {
+	p := palette.New()
+	p.SetPrompt(historyAnyStatus.historySearchPrompt())
+	p.SetPlaceholder("type to search history")
+	return p
+}
+
+// openHistorySearch shows the Ctrl+R overlay, starting from the text
+// already typed at the prompt.
+func (m *Model) openHistorySearch() tea.Cmd {
+	entries, err := m.historyStore.Entries()
+	if err != nil {
+		m.logger.Warnf("Failed to read command history: %v", err)
+	}
+
+	ranked := history.Rank(entries, paneDir(m.paneManager.ActivePane()), time.Now())
+	failed := make(map[string]bool, len(ranked))
+	commands := make([]palette.Command, 0, len(ranked))
+	for _, r := range ranked {
+		command := r.Command
+		failed[command] = r.Failed()
+		commands = append(commands, palette.Command{
+			ID:      command,
+			Name:    strings.ReplaceAll(command, "\n", " ⏎ "),
+			Preview: command + "\n" + describeRanked(r),
+			Weight:  historyWeightScale * math.Log1p(r.Score),
+			Action: func() tea.Cmd {
+				return func() tea.Msg { return historySelectedMsg{command: command} }
+			},
+		})
+	}
+
+	m.historySearch.ClearCommands()
+	m.historySearch.AddCommands(commands)
+	m.historyFailures = failed
+	m.setHistoryStatus(historyAnyStatus)
+
+	cmd := m.historySearch.Open()
+	m.historySearch.SetQuery(m.input.Value())
+	return cmd
+}
+
+// updateHistorySearch handles a key while the Ctrl+R overlay is open.
+// Ctrl+R moves to the next match and Ctrl+F cycles the exit status filter.
+func (m *Model) updateHistorySearch(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, keys.HistorySearch):
+		_, cmd := m.historySearch.Update(tea.KeyMsg{Type: tea.KeyDown})
+		return cmd
+
+	case key.Matches(msg, keys.HistoryFilter):
+		m.setHistoryStatus((m.historyStatus + 1) % 3)
+		return nil
+
+	case key.Matches(msg, keys.Cancel):
+		m.historySearch.Close()
+		return nil
+	}
+
+	_, cmd := m.historySearch.Update(msg)
+	return cmd
+}
+
+// setHistoryStatus filters the history search by exit status.
+func (m *Model) setHistoryStatus(status historyStatus) {
+	failed := m.historyFailures
+	m.historyStatus = status
+	m.historySearch.SetPrompt(status.historySearchPrompt())
+	m.historySearch.SetFilter(func(cmd palette.Command) bool {
+		switch status {
+		case historySucceeded:
+			return !failed[cmd.ID]
+		case historyFailed:
+			return failed[cmd.ID]
+		}
+		return true
+	})
+}
+
+// describeRanked summarizes a ranked command for the search preview.
+func describeRanked(r history.Ranked) string {
+	var parts []string
+	if !r.Timestamp.IsZero() {
+		status := "succeeded"
+		if r.Failed() {
+			status = fmt.Sprintf("exit %d", r.ExitCode)
+		}
+		parts = append(parts, status, "last run "+formatAge(time.Since(r.Timestamp))+" ago")
+	}
+	if r.Count > 1 {
+		parts = append(parts, fmt.Sprintf("%d runs", r.Count))
+	}
+	if r.Dir != "" {
+		parts = append(parts, r.Dir)
+	}
+	if r.Host != "" {
+		parts = append(parts, r.Host)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// formatAge renders an age in the largest whole unit, such as "3d".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+)
+
+// hostKeyDialogID identifies the dialog asking whether to trust a host.
+const hostKeyDialogID = "hostkey"
+
+// newHostKeyPrompt returns a host key prompt that asks whether to trust
+// hosts through prompts, for the UI to put to the user.
+func newHostKeyPrompt(prompts chan<- promptMsg) ssh.HostKeyPrompt {
+	return func(ctx context.Context, question ssh.HostKeyQuestion) bool {
+		late := fmt.Sprintf("Host key of %s was not confirmed in time", question.Host)
+		result, ok := ask(ctx, prompts, hostKeyDialog(question), late)
+		return ok && result.Choice == "y"
+	}
+}
+
+// hostKeyDialog asks whether to trust a host, showing its key's
+// fingerprint to compare with the one the host's administrator published.
+func hostKeyDialog(question ssh.HostKeyQuestion) dialog.Spec {
+	host := question.Host
+	if question.Address != "" {
+		host += " (" + question.Address + ")"
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "The authenticity of %s can't be established.\n\n", host)
+	fmt.Fprintf(&body, "%s key fingerprint:\n  %s\n\n", question.KeyType, question.Fingerprint)
+	body.WriteString("Trusting it adds the key to known_hosts.")
+
+	return dialog.Spec{
+		ID:      hostKeyDialogID,
+		Title:   "Trust this host?",
+		Body:    body.String(),
+		Options: []dialog.Option{{Key: "y", Label: "trust and connect"}, {Key: "n", Label: "don't connect"}},
+	}
+}
+
+// warnHostKeyChanged reports a connection refused because the host's key
+// changed.
+func (m *Model) warnHostKeyChanged(name string, err *ssh.HostKeyChangedError) tea.Cmd {
+	m.addOutput(fmt.Sprintf("WARNING: the host key of %s has changed!", name), false, 1)
+	m.addOutput(err.Error(), false, 1)
+	m.notifications.ShowError("Host key changed", fmt.Sprintf("%s: connection refused", name))
+	return notificationTick()
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+)
+
+func TestHostKeyPrompt(t *testing.T) {
+	m := newRemoteTestModel()
+	prompts := make(chan promptMsg)
+	prompt := newHostKeyPrompt(prompts)
+
+	answers := make(chan bool, 2)
+	ask := func(ctx context.Context, host string) {
+		go func() {
+			answers <- prompt(ctx, ssh.HostKeyQuestion{Host: host, KeyType: "ssh-ed25519", Fingerprint: "SHA256:abc"})
+		}()
+	}
+
+	ask(context.Background(), "web:22")
+	m.queuePrompt(<-prompts)
+	if !m.dialog.IsVisible() || m.dialog.ID() != hostKeyDialogID {
+		t.Fatal("expected the host key dialog to open")
+	}
+	if view := m.dialog.View(); !strings.Contains(view, "SHA256:abc") || !strings.Contains(view, "web:22") {
+		t.Errorf("expected the host and fingerprint in the dialog, got %q", view)
+	}
+
+	// A second question waits for the first to be answered
+	ask(context.Background(), "db:22")
+	m.queuePrompt(<-prompts)
+	if len(m.pendingPrompts) != 2 {
+		t.Fatalf("expected 2 pending questions, got %d", len(m.pendingPrompts))
+	}
+
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: hostKeyDialogID, Choice: "y"})
+	if !<-answers {
+		t.Error("expected the first host to be trusted")
+	}
+	m.askPrompt()
+	if !m.dialog.IsVisible() || !strings.Contains(m.dialog.View(), "db:22") {
+		t.Fatal("expected the second question to be asked next")
+	}
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: hostKeyDialogID, Choice: "n"})
+	if <-answers {
+		t.Error("expected the second host not to be trusted")
+	}
+
+	// Questions of connections that gave up are skipped
+	ctx, cancel := context.WithCancel(context.Background())
+	ask(ctx, "gone:22")
+	msg := <-prompts
+	cancel()
+	if <-answers {
+		t.Error("expected an abandoned question to be declined")
+	}
+	m.pendingPrompts = append(m.pendingPrompts, msg)
+	if m.askPrompt(); m.dialog.IsVisible() || len(m.pendingPrompts) != 0 {
+		t.Error("expected an abandoned question to be dropped")
+	}
+}
+
+func TestWarnHostKeyChanged(t *testing.T) {
+	m := newRemoteTestModel()
+	err := &ssh.HostKeyChangedError{Host: "web:22", KeyType: "ssh-ed25519", Fingerprint: "SHA256:new"}
+
+	model, _ := m.remoteOpened(remoteOpenedMsg{name: "web", err: err})
+	got := model.(Model)
+	m = &got
+	if len(m.commandOutput) != 2 || !strings.Contains(m.commandOutput[0].content, "has changed") {
+		t.Fatalf("expected a warning, got %+v", m.commandOutput)
+	}
+	if !strings.Contains(lastOutput(m), "ssh-keygen -R web") {
+		t.Errorf("expected advice on removing the old key, got %q", lastOutput(m))
+	}
+	if m.notifications.Count() != 1 {
+		t.Error("expected a toast")
+	}
+}
@@ -0,0 +1,691 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/panes"
+	"github.com/cbwinslow/cbwsh/pkg/process"
+)
+
+// jobFollowInterval is how often a foreground job's output is polled.
+const jobFollowInterval = 50 * time.Millisecond
+
+// exitStatusStopped is the status a shell reports for a command stopped
+// by SIGTSTP.
+const exitStatusStopped = 128 + int(syscall.SIGTSTP)
+
+// sessionJob links a job adopted from a pane's session to the shell's own
+// job number, which fg and bg must use to resume it.
+type sessionJob struct {
+	pane *panes.Pane
+	spec string
+}
+
+// jobDoneMsg reports that a background job has finished.
+type jobDoneMsg struct {
+	job *process.Job
+}
+
+// sessionJobsMsg carries the job listing of a pane's session, from which a
+// job started or stopped there is adopted.
+type sessionJobsMsg struct {
+	pane    *panes.Pane
+	command string
+	pgid    int
+	listing string
+	err     error
+}
+
+// jobResumedMsg reports the result of resuming a session job with bg.
+type jobResumedMsg struct {
+	job *process.Job
+	err error
+}
+
+// notificationTickMsg triggers a redraw once toasts have expired.
+type notificationTickMsg struct{}
+
+// shellJobLine matches a line of `jobs -l` output from bash or zsh,
+// capturing the job number and the process group leader.
+var shellJobLine = regexp.MustCompile(`^\[(\d+)\]\s*[+-]?\s+(\d+)\s`)
+
+// backgroundCommand reports whether command is a single pipeline ending
+// with `&` and returns the pipeline to run in the background. Lists such
+// as `a; b &` or `a && b &` are left to the shell, which only backgrounds
+// their last element.
+func backgroundCommand(command string) (string, bool) {
+	trimmed := strings.TrimSpace(command)
+	if !strings.HasSuffix(trimmed, "&") || strings.HasSuffix(trimmed, "&&") ||
+		strings.HasSuffix(trimmed, `\&`) || strings.HasSuffix(trimmed, "|&") {
+		return "", false
+	}
+	body := strings.TrimSpace(strings.TrimSuffix(trimmed, "&"))
+	if body == "" || isCommandList(body) {
+		return "", false
+	}
+	return body, true
+}
+
+// isCommandList reports whether command contains an unquoted list
+// operator (`;`, `&`, `&&`, `||` or a newline) outside of any subshell or
+// group.
+func isCommandList(command string) bool {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case c == ';' || c == '\n':
+			return true
+		case c == '&':
+			// `>&` and `&>` are redirections, not list operators
+			if i > 0 && (command[i-1] == '>' || command[i-1] == '<') {
+				continue
+			}
+			if i+1 < len(command) && command[i+1] == '>' {
+				i++
+				continue
+			}
+			return true
+		case c == '|':
+			if i+1 < len(command) && command[i+1] == '|' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// watchJob returns a command that reports when job finishes.
+func watchJob(job *process.Job) tea.Cmd {
+	return func() tea.Msg {
+		<-job.Done()
+		return jobDoneMsg{job: job}
+	}
+}
+
+func notificationTick() tea.Cmd {
+	return tea.Tick(5*time.Second+100*time.Millisecond, func(time.Time) tea.Msg {
+		return notificationTickMsg{}
+	})
+}
+
+// startBackgroundJob launches command as a background job. In a pane with
+// a persistent session the shell itself runs `command &`, so the job sees
+// the session's exports, functions and aliases, and is adopted as a job
+// the same way a command suspended with Ctrl+Z is; its output appears in
+// the pane. Otherwise the command runs through the job manager with the
+// pane's working directory, environment and aliases.
+func (m *Model) startBackgroundJob(command string) tea.Cmd {
+	pane := m.paneManager.ActivePane()
+	if pane == nil {
+		m.addOutput("No active pane available. Press Ctrl+N to create a new pane.", false, 1)
+		return nil
+	}
+
+	executor := pane.GetShellExecutor()
+	if executor.SessionEnabled() {
+		return listSessionJobs(pane, command, 0, command+" & jobs -l %+")
+	}
+
+	job, err := m.jobManager.StartJobWithOptions(context.Background(), executor.ExpandAliases(command), executor.ShellPath(), process.JobOptions{
+		Dir: executor.GetWorkingDirectory(),
+		Env: executor.Environ(),
+	})
+	if err != nil {
+		m.addOutput(fmt.Sprintf("cbwsh: %v", err), false, 1)
+		return nil
+	}
+
+	m.addOutput(fmt.Sprintf("[%d] %d", job.ID, job.GetPID()), false, 0)
+	return watchJob(job)
+}
+
+// handleJobBuiltin runs the job control builtins: jobs, fg, bg, kill with
+// job specs, wait and joblog. Job specs are `%n`, `n`, or `%%`/`%+` and
+// nothing for the most recent job.
+//
+// Returns whether the command was handled and any command to run.
+func (m *Model) handleJobBuiltin(command string) (bool, tea.Cmd) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return false, nil
+	}
+	args := parts[1:]
+
+	switch parts[0] {
+	case "jobs":
+		jobs := m.jobManager.ListJobs()
+		if len(jobs) == 0 {
+			m.addOutput("No background jobs", false, 0)
+		}
+		for _, job := range jobs {
+			m.addOutput(formatJob(job), false, 0)
+		}
+		return true, nil
+
+	case "fg":
+		job, err := m.findJob(args)
+		if err != nil {
+			m.addOutput("fg: "+err.Error(), false, 1)
+			return true, nil
+		}
+		return true, m.foregroundJob(job)
+
+	case "bg":
+		job, err := m.findJob(args)
+		if err != nil {
+			m.addOutput("bg: "+err.Error(), false, 1)
+			return true, nil
+		}
+		return true, m.resumeJob(job)
+
+	case "kill":
+		// Plain PIDs are left to the shell
+		sig, specs, ok := parseKillArgs(args)
+		if !ok {
+			return false, nil
+		}
+		for _, spec := range specs {
+			job, err := m.findJob([]string{spec})
+			if err == nil {
+				err = m.jobManager.SignalJob(job.ID, sig)
+			}
+			if err != nil {
+				m.addOutput("kill: "+err.Error(), false, 1)
+			}
+		}
+		return true, nil
+
+	case "wait":
+		var jobs []*process.Job
+		if len(args) == 0 {
+			jobs = m.jobManager.ListActiveJobs()
+		}
+		for _, spec := range args {
+			job, err := m.findJob([]string{spec})
+			if err != nil {
+				m.addOutput("wait: "+err.Error(), false, 1)
+				return true, nil
+			}
+			jobs = append(jobs, job)
+		}
+		if len(jobs) == 0 {
+			return true, nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		m.fgCancel = cancel
+		return true, m.follow(waitForJobs(ctx, jobs), nil)
+
+	case "joblog":
+		job, err := m.findJob(args)
+		if err != nil {
+			m.addOutput("joblog: "+err.Error(), false, 1)
+			return true, nil
+		}
+		if job.External {
+			m.addOutput(fmt.Sprintf("joblog: output of job %d is shown in its pane", job.ID), false, 1)
+			return true, nil
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(job.Output(), "\n"), "\n") {
+			m.addOutput(line, false, 0)
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// findJob resolves a job spec argument list to a job.
+func (m *Model) findJob(args []string) (*process.Job, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("too many arguments")
+	}
+
+	spec := "%+"
+	if len(args) == 1 {
+		spec = args[0]
+	}
+
+	if spec == "%%" || spec == "%+" {
+		active := m.jobManager.ListActiveJobs()
+		if len(active) == 0 {
+			return nil, fmt.Errorf("no current job")
+		}
+		return active[len(active)-1], nil
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	job, ok := m.jobManager.GetJob(id)
+	if !ok {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return job, nil
+}
+
+// foregroundJob brings a job to the foreground. Jobs started with `&` are
+// continued and their output followed; jobs adopted from a pane's session
+// are resumed with the shell's own fg so they regain the terminal.
+func (m *Model) foregroundJob(job *process.Job) tea.Cmd {
+	state := job.GetState()
+	if state != process.JobStateRunning && state != process.JobStateStopped {
+		m.addOutput(fmt.Sprintf("fg: job %d has terminated", job.ID), false, 1)
+		return nil
+	}
+
+	m.addOutput(job.Command, false, 0)
+
+	if job.External {
+		sj, ok := m.sessionJobs[job.ID]
+		if !ok {
+			m.addOutput(fmt.Sprintf("fg: job %d is not known to its shell", job.ID), false, 1)
+			return nil
+		}
+		job.SetState(process.JobStateRunning)
+		m.fgJob = job
+		m.executing = true
+		m.execStart = time.Now()
+		return tea.Batch(m.spinner.Tick, m.runCommandIn(sj.pane, "fg "+sj.spec))
+	}
+
+	if state == process.JobStateStopped {
+		if err := m.jobManager.ContinueJob(job.ID); err != nil {
+			m.addOutput("fg: "+err.Error(), false, 1)
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.fgCancel = cancel
+	return m.follow(followJob(ctx, job), job)
+}
+
+// follow shows events from a job-backed stream as the running command.
+func (m *Model) follow(events <-chan core.CommandEvent, job *process.Job) tea.Cmd {
+	m.fgJob = job
+	m.running = events
+	m.runningPane = nil
+	m.pendingStdout = ""
+	m.pendingStderr = ""
+	m.executing = true
+	m.execStart = time.Now()
+	return tea.Batch(m.spinner.Tick, waitForCommandEvent(events))
+}
+
+// resumeJob continues a stopped job in the background. Jobs adopted from a
+// pane's session are resumed with the shell's own bg, which reports back
+// through a jobResumedMsg.
+func (m *Model) resumeJob(job *process.Job) tea.Cmd {
+	if job.GetState() != process.JobStateStopped {
+		m.addOutput(fmt.Sprintf("bg: job %d is not stopped", job.ID), false, 1)
+		return nil
+	}
+
+	if !job.External {
+		if err := m.jobManager.ContinueJob(job.ID); err != nil {
+			m.addOutput("bg: "+err.Error(), false, 1)
+			return nil
+		}
+		m.addOutput(fmt.Sprintf("[%d]+ %s &", job.ID, job.Command), false, 0)
+		return nil
+	}
+
+	sj, ok := m.sessionJobs[job.ID]
+	if !ok {
+		m.addOutput(fmt.Sprintf("bg: job %d is not known to its shell", job.ID), false, 1)
+		return nil
+	}
+	return func() tea.Msg {
+		result, err := sj.pane.GetShellExecutor().Execute(context.Background(), "bg "+sj.spec)
+		if err == nil && result.ExitCode != 0 {
+			err = fmt.Errorf("%s", strings.TrimSpace(result.Error))
+		}
+		return jobResumedMsg{job: job, err: err}
+	}
+}
+
+// jobResumed reports the outcome of resuming a session job with bg.
+func (m *Model) jobResumed(msg jobResumedMsg) {
+	if msg.err != nil {
+		m.addOutput("bg: "+msg.err.Error(), false, 1)
+		return
+	}
+	msg.job.SetState(process.JobStateRunning)
+	m.addOutput(fmt.Sprintf("[%d]+ %s &", msg.job.ID, msg.job.Command), false, 0)
+}
+
+// suspendForeground stops the foreground command, as Ctrl+Z does in a
+// terminal. The stopped command is reported as a job once its result
+// arrives.
+func (m *Model) suspendForeground() {
+	if job := m.fgJob; job != nil && !job.External {
+		if err := m.jobManager.StopJob(job.ID); err != nil {
+			m.addOutput("cbwsh: "+err.Error(), false, 1)
+			return
+		}
+		m.fgCancel()
+		return
+	}
+
+	if m.runningPane == nil {
+		return
+	}
+	pgid, err := m.runningPane.GetShellExecutor().Suspend()
+	if err != nil {
+		m.addOutput("cbwsh: cannot suspend: "+err.Error(), false, 1)
+		return
+	}
+	m.suspendPgid = pgid
+}
+
+// interruptForeground delivers Ctrl+C to a foreground job or ends a wait.
+// It returns false when the foreground command is not job-backed.
+func (m *Model) interruptForeground() bool {
+	if job := m.fgJob; job != nil && !job.External {
+		_ = m.jobManager.SignalJob(job.ID, syscall.SIGINT)
+		return true
+	}
+	if m.fgJob == nil && m.fgCancel != nil {
+		m.fgCancel()
+		return true
+	}
+	return false
+}
+
+// finishJobControl updates job state once the foreground command returns.
+// It reports whether the outcome was already written to the output, in
+// which case no exit status line is needed, along with any command to run.
+func (m *Model) finishJobControl(result core.CommandResult, pane *panes.Pane) (bool, tea.Cmd) {
+	job := m.fgJob
+	pgid := m.suspendPgid
+	m.fgJob = nil
+	m.suspendPgid = 0
+	if m.fgCancel != nil {
+		m.fgCancel()
+		m.fgCancel = nil
+	}
+
+	switch {
+	case job != nil && !job.External:
+		if job.GetState() == process.JobStateStopped {
+			m.addOutput(fmt.Sprintf("[%d]+ Stopped  %s", job.ID, job.Command), false, 0)
+			return true, nil
+		}
+		m.fgFinished[job.ID] = true
+		return false, nil
+
+	case pgid != 0 && result.ExitCode == exitStatusStopped:
+		if job == nil {
+			if pane == nil {
+				return false, nil
+			}
+			// The stopped line is written once the job is adopted
+			return true, listSessionJobs(pane, result.Command, pgid, "jobs -l")
+		}
+		job.SetState(process.JobStateStopped)
+		m.addOutput(fmt.Sprintf("[%d]+ Stopped  %s", job.ID, job.Command), false, 0)
+		return true, nil
+
+	case job != nil:
+		m.fgFinished[job.ID] = true
+		_ = m.jobManager.FinishJob(job.ID, result.ExitCode)
+	}
+	return false, nil
+}
+
+// listSessionJobs returns a command that runs script in pane's session,
+// which must list the job to adopt in `jobs -l` format, and reports the
+// listing in a sessionJobsMsg. A pgid of 0 adopts the first job listed;
+// otherwise the job is the stopped process group pgid.
+func listSessionJobs(pane *panes.Pane, command string, pgid int, script string) tea.Cmd {
+	return func() tea.Msg {
+		result, err := pane.GetShellExecutor().Execute(context.Background(), script)
+		msg := sessionJobsMsg{pane: pane, command: command, pgid: pgid, err: err}
+		if result != nil {
+			msg.listing = result.Output
+		}
+		return msg
+	}
+}
+
+// adoptSessionJob registers the job found in a session's job listing with
+// the job manager, keeping the shell's job number for later fg and bg.
+func (m *Model) adoptSessionJob(msg sessionJobsMsg) tea.Cmd {
+	if msg.err != nil {
+		m.addOutput("cbwsh: "+msg.err.Error(), false, 1)
+		return nil
+	}
+
+	spec, pgid := findSessionJob(msg.listing, msg.pgid)
+	if spec == "" {
+		if msg.pgid != 0 {
+			m.addOutput(fmt.Sprintf("✗ exit status %d", exitStatusStopped), false, exitStatusStopped)
+		} else {
+			m.addOutput("cbwsh: background job not found in shell", false, 1)
+		}
+		return nil
+	}
+
+	job, err := m.jobManager.AdoptJob(msg.command, pgid)
+	if err != nil {
+		m.addOutput("cbwsh: "+err.Error(), false, 1)
+		return nil
+	}
+	m.sessionJobs[job.ID] = sessionJob{pane: msg.pane, spec: spec}
+
+	if msg.pgid != 0 {
+		m.addOutput(fmt.Sprintf("[%d]+ Stopped  %s", job.ID, job.Command), false, 0)
+	} else {
+		job.SetState(process.JobStateRunning)
+		m.addOutput(fmt.Sprintf("[%d] %d", job.ID, pgid), false, 0)
+	}
+	return watchJob(job)
+}
+
+// findSessionJob finds a job in `jobs -l` output and returns its job spec
+// and process group. A pgid of 0 matches the first job listed.
+func findSessionJob(listing string, pgid int) (string, int) {
+	for _, line := range strings.Split(listing, "\n") {
+		match := shellJobLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		leader, _ := strconv.Atoi(match[2])
+		if pgid == 0 || leader == pgid {
+			return "%" + match[1], leader
+		}
+	}
+	return "", 0
+}
+
+// jobFinished reports a background job that has completed.
+func (m *Model) jobFinished(job *process.Job) tea.Cmd {
+	delete(m.sessionJobs, job.ID)
+
+	// Jobs in the foreground report through the command result instead
+	if job == m.fgJob || m.fgFinished[job.ID] {
+		delete(m.fgFinished, job.ID)
+		return nil
+	}
+
+	status := "Done"
+	if code := job.GetExitCode(); code != 0 {
+		status = fmt.Sprintf("Exit %d", code)
+	}
+	m.addOutput(fmt.Sprintf("[%d]+ %s  %s", job.ID, status, job.Command), false, 0)
+	m.notifications.ShowCommandComplete(job.Command, job.GetExitCode(), job.Duration())
+	return notificationTick()
+}
+
+// killJobs kills the jobs cbwsh started; jobs adopted from a session end
+// with their shell.
+func (m *Model) killJobs() {
+	for _, job := range m.jobManager.ListActiveJobs() {
+		if !job.External {
+			_ = m.jobManager.KillJob(job.ID)
+		}
+	}
+}
+
+// followJob streams a job's captured output until it finishes or ctx is
+// cancelled, such as when the job is suspended again.
+func followJob(ctx context.Context, job *process.Job) <-chan core.CommandEvent {
+	events := make(chan core.CommandEvent, 64)
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(jobFollowInterval)
+		defer ticker.Stop()
+
+		var offset int64
+		flush := func() {
+			var out string
+			out, offset = job.OutputSince(offset)
+			if out != "" {
+				events <- core.CommandEvent{Chunk: &core.OutputChunk{Stream: core.StreamStdout, Data: out}}
+			}
+		}
+
+		for {
+			select {
+			case <-job.Done():
+				flush()
+				events <- core.CommandEvent{Result: &core.CommandResult{
+					Command:  job.Command,
+					ExitCode: job.GetExitCode(),
+					Duration: job.Duration().Milliseconds(),
+				}}
+				return
+			case <-ctx.Done():
+				flush()
+				events <- core.CommandEvent{Result: &core.CommandResult{
+					Command:  job.Command,
+					ExitCode: exitStatusStopped,
+				}}
+				return
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+
+	return events
+}
+
+// waitForJobs completes once all jobs have finished, with the exit status
+// of the last one, or with 130 if ctx is cancelled first.
+func waitForJobs(ctx context.Context, jobs []*process.Job) <-chan core.CommandEvent {
+	events := make(chan core.CommandEvent, 1)
+
+	go func() {
+		defer close(events)
+
+		result := &core.CommandResult{Command: "wait"}
+		for _, job := range jobs {
+			select {
+			case <-job.Done():
+				result.ExitCode = job.GetExitCode()
+			case <-ctx.Done():
+				result.ExitCode = 130
+				events <- core.CommandEvent{Result: result}
+				return
+			}
+		}
+		events <- core.CommandEvent{Result: result}
+	}()
+
+	return events
+}
+
+// parseKillArgs parses `kill [-SIGNAL] %job...`. It reports false if any
+// target is not a job spec, leaving the command to the shell.
+func parseKillArgs(args []string) (syscall.Signal, []string, bool) {
+	sig := syscall.SIGTERM
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		parsed, ok := parseSignal(strings.TrimPrefix(args[0], "-"))
+		if !ok {
+			return 0, nil, false
+		}
+		sig = parsed
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return 0, nil, false
+	}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "%") {
+			return 0, nil, false
+		}
+	}
+	return sig, args, true
+}
+
+func parseSignal(name string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), true
+	}
+
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "HUP":
+		return syscall.SIGHUP, true
+	case "INT":
+		return syscall.SIGINT, true
+	case "QUIT":
+		return syscall.SIGQUIT, true
+	case "KILL":
+		return syscall.SIGKILL, true
+	case "TERM":
+		return syscall.SIGTERM, true
+	case "STOP":
+		return syscall.SIGSTOP, true
+	case "TSTP":
+		return syscall.SIGTSTP, true
+	case "CONT":
+		return syscall.SIGCONT, true
+	case "USR1":
+		return syscall.SIGUSR1, true
+	case "USR2":
+		return syscall.SIGUSR2, true
+	}
+	return 0, false
+}
+
+// formatJob formats a job for the jobs builtin.
+func formatJob(job *process.Job) string {
+	state := job.GetState().String()
+	if code := job.GetExitCode(); job.GetState() == process.JobStateFailed && code > 0 {
+		state = fmt.Sprintf("Exit %d", code)
+	}
+	return fmt.Sprintf("[%d] %-10s %s", job.ID, state, job.Command)
+}
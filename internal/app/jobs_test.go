@@ -0,0 +1,210 @@
+package app
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/process"
+)
+
+func TestBackgroundCommand(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		ok    bool
+	}{
+		{"sleep 10 &", "sleep 10", true},
+		{"  make build &  ", "make build", true},
+		{"grep foo log | sort &", "grep foo log | sort", true},
+		{"cmd >out 2>&1 &", "cmd >out 2>&1", true},
+		{"cmd &>out &", "cmd &>out", true},
+		{"(a; b) &", "(a; b)", true},
+		{"{ a && b; } &", "{ a && b; }", true},
+		{"echo 'a; b' &", "echo 'a; b'", true},
+		{`echo a\; b &`, `echo a\; b`, true},
+		{"sleep 10", "", false},
+		{"a && b", "", false},
+		{"a |& b", "", false},
+		{`echo \&`, "", false},
+		{"&", "", false},
+		{"a; b &", "", false},
+		{"a && b &", "", false},
+		{"a || b &", "", false},
+		{"a & b &", "", false},
+		{"a\nb &", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := backgroundCommand(tt.input)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("backgroundCommand(%q) = %q, %v; want %q, %v", tt.input, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseKillArgs(t *testing.T) {
+	tests := []struct {
+		args  []string
+		sig   syscall.Signal
+		specs []string
+		ok    bool
+	}{
+		{[]string{"%1"}, syscall.SIGTERM, []string{"%1"}, true},
+		{[]string{"%1", "%2"}, syscall.SIGTERM, []string{"%1", "%2"}, true},
+		{[]string{"-9", "%1"}, syscall.SIGKILL, []string{"%1"}, true},
+		{[]string{"-KILL", "%1"}, syscall.SIGKILL, []string{"%1"}, true},
+		{[]string{"-sigint", "%%"}, syscall.SIGINT, []string{"%%"}, true},
+		{[]string{"1234"}, 0, nil, false},
+		{[]string{"%1", "1234"}, 0, nil, false},
+		{[]string{"-9"}, 0, nil, false},
+		{[]string{"-BOGUS", "%1"}, 0, nil, false},
+		{nil, 0, nil, false},
+	}
+
+	for _, tt := range tests {
+		sig, specs, ok := parseKillArgs(tt.args)
+		if ok != tt.ok || sig != tt.sig || len(specs) != len(tt.specs) {
+			t.Errorf("parseKillArgs(%q) = %v, %q, %v; want %v, %q, %v", tt.args, sig, specs, ok, tt.sig, tt.specs, tt.ok)
+			continue
+		}
+		for i := range specs {
+			if specs[i] != tt.specs[i] {
+				t.Errorf("parseKillArgs(%q) specs = %q, want %q", tt.args, specs, tt.specs)
+				break
+			}
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name string
+		want syscall.Signal
+		ok   bool
+	}{
+		{"15", syscall.SIGTERM, true},
+		{"HUP", syscall.SIGHUP, true},
+		{"SIGSTOP", syscall.SIGSTOP, true},
+		{"cont", syscall.SIGCONT, true},
+		{"usr1", syscall.SIGUSR1, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"WINCH", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseSignal(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSignal(%q) = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFindSessionJob(t *testing.T) {
+	listing := "[1]  12001 Stopped                 vim notes\n" +
+		"[2]- 12010 Running                 sleep 100 &\n" +
+		"[3]  + 12020 running    make\n"
+
+	tests := []struct {
+		pgid     int
+		wantSpec string
+		wantPgid int
+	}{
+		{0, "%1", 12001},
+		{12010, "%2", 12010},
+		{12020, "%3", 12020},
+		{99999, "", 0},
+	}
+
+	for _, tt := range tests {
+		spec, pgid := findSessionJob(listing, tt.pgid)
+		if spec != tt.wantSpec || pgid != tt.wantPgid {
+			t.Errorf("findSessionJob(%d) = %q, %d; want %q, %d", tt.pgid, spec, pgid, tt.wantSpec, tt.wantPgid)
+		}
+	}
+}
+
+// newJobTestModel returns a model whose job manager holds jobs adopted
+// from this test's own process group, which stays alive for the test.
+func newJobTestModel(t *testing.T, commands ...string) (*Model, []*process.Job) {
+	t.Helper()
+
+	m := &Model{jobManager: process.NewJobManager(10)}
+	jobs := make([]*process.Job, 0, len(commands))
+	for _, command := range commands {
+		job, err := m.jobManager.AdoptJob(command, syscall.Getpgrp())
+		if err != nil {
+			t.Fatalf("AdoptJob failed: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return m, jobs
+}
+
+func TestFindJob(t *testing.T) {
+	m, jobs := newJobTestModel(t, "vim", "make", "top")
+	if err := m.jobManager.FinishJob(jobs[2].ID, 0); err != nil {
+		t.Fatalf("FinishJob failed: %v", err)
+	}
+
+	tests := []struct {
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{nil, jobs[1].ID, false},
+		{[]string{"%%"}, jobs[1].ID, false},
+		{[]string{"%+"}, jobs[1].ID, false},
+		{[]string{"%1"}, jobs[0].ID, false},
+		{[]string{"1"}, jobs[0].ID, false},
+		{[]string{"%3"}, jobs[2].ID, false},
+		{[]string{"%9"}, 0, true},
+		{[]string{"%vim"}, 0, true},
+		{[]string{"%1", "%2"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		job, err := m.findJob(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("findJob(%q) expected error, got job %d", tt.args, job.ID)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findJob(%q) failed: %v", tt.args, err)
+			continue
+		}
+		if job.ID != tt.want {
+			t.Errorf("findJob(%q) = job %d, want %d", tt.args, job.ID, tt.want)
+		}
+	}
+
+	m, _ = newJobTestModel(t)
+	if _, err := m.findJob(nil); err == nil {
+		t.Error("expected error for current job with no jobs")
+	}
+}
+
+func TestFormatJob(t *testing.T) {
+	m, jobs := newJobTestModel(t, "vim notes", "make", "false")
+	jobs[1].SetState(process.JobStateRunning)
+	if err := m.jobManager.FinishJob(jobs[2].ID, 2); err != nil {
+		t.Fatalf("FinishJob failed: %v", err)
+	}
+
+	tests := []struct {
+		job  *process.Job
+		want string
+	}{
+		{jobs[0], "[1] Stopped    vim notes"},
+		{jobs[1], "[2] Running    make"},
+		{jobs[2], "[3] Exit 2     false"},
+	}
+
+	for _, tt := range tests {
+		if got := formatJob(tt.job); got != tt.want {
+			t.Errorf("formatJob() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+)
+
+// promptMsg asks the user a question in a dialog on behalf of a
+// background task, such as a connection. The task waits for the answer on
+// reply until done is closed.
+type promptMsg struct {
+	spec  dialog.Spec
+	late  string // Reported if the answer comes after done is closed
+	reply chan<- dialog.ResultMsg
+	done  <-chan struct{}
+}
+
+// ask puts the dialog described by spec to the user through prompts and
+// waits for the answer. It reports false if ctx ends first; late is shown
+// if the user answers after that.
+func ask(ctx context.Context, prompts chan<- promptMsg, spec dialog.Spec, late string) (dialog.ResultMsg, bool) {
+	reply := make(chan dialog.ResultMsg, 1)
+	select {
+	case prompts <- promptMsg{spec: spec, late: late, reply: reply, done: ctx.Done()}:
+	case <-ctx.Done():
+		return dialog.ResultMsg{}, false
+	}
+	select {
+	case result := <-reply:
+		return result, true
+	case <-ctx.Done():
+		return dialog.ResultMsg{}, false
+	}
+}
+
+// waitPrompt waits for the next prompt.
+func waitPrompt(prompts <-chan promptMsg) tea.Cmd {
+	if prompts == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return <-prompts
+	}
+}
+
+// queuePrompt queues a prompt, opening its dialog right away unless
+// another dialog is open.
+func (m *Model) queuePrompt(msg promptMsg) tea.Cmd {
+	m.pendingPrompts = append(m.pendingPrompts, msg)
+	if m.dialog.IsVisible() {
+		return nil
+	}
+	return m.askPrompt()
+}
+
+// askPrompt opens the dialog of the first queued prompt whose task is
+// still waiting.
+func (m *Model) askPrompt() tea.Cmd {
+	for len(m.pendingPrompts) > 0 && expired(m.pendingPrompts[0].done) {
+		m.pendingPrompts = m.pendingPrompts[1:]
+	}
+	if len(m.pendingPrompts) == 0 {
+		return nil
+	}
+	return m.dialog.Open(m.pendingPrompts[0].spec)
+}
+
+// promptAnswered passes the answer to the first queued prompt on to its
+// task.
+func (m *Model) promptAnswered(msg dialog.ResultMsg) {
+	if len(m.pendingPrompts) == 0 {
+		return
+	}
+	pending := m.pendingPrompts[0]
+	m.pendingPrompts = m.pendingPrompts[1:]
+
+	if expired(pending.done) {
+		if pending.late != "" {
+			m.addOutput(pending.late, false, 1)
+		}
+		return
+	}
+	pending.reply <- msg
+}
+
+// expired reports whether done is closed.
+func expired(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
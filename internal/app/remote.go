@@ -0,0 +1,338 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/autocomplete"
+)
+
+// remoteSpec describes the remote builtin for completion.
+const remoteSpec = `
+name: remote
+description: Open a pane on a remote host
+subcommands:
+  - name: open
+    description: Open a pane on a host
+    args:
+      - name: host
+        generator: hosts
+  - name: list
+    description: List open connections
+  - name: close
+    description: Close a connection and its panes
+    args:
+      - name: connection
+        generator: connections
+args:
+  - name: host
+    generator: hosts
+`
+
+// registerRemoteCompletion completes hosts for ssh and the remote builtin
+// from the saved hosts, the OpenSSH client configuration and known_hosts,
+// connection names for remote close, tunnel add and the file transfer
+// builtins, and host groups for fanout.
+func registerRemoteCompletion(specs *autocomplete.SpecProvider, manager *ssh.Manager) {
+	for _, yaml := range []string{remoteSpec, tunnelSpec, putSpec, getSpec, transferSpec, browseSpec, fanoutSpec} {
+		if spec, err := autocomplete.ParseSpec([]byte(yaml)); err == nil {
+			specs.AddSpecs(spec)
+		}
+	}
+
+	hosts := func([]string) []core.Suggestion {
+		var suggestions []core.Suggestion
+		seen := make(map[string]bool)
+		add := func(name, description string) {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				suggestions = append(suggestions, core.Suggestion{Text: name, Description: description, Category: "argument"})
+			}
+		}
+
+		saved, _ := manager.ListSavedHosts()
+		for _, host := range saved {
+			add(host.Name, fmt.Sprintf("%s@%s", host.User, host.Host))
+		}
+		for _, alias := range manager.ConfigHosts() {
+			host := manager.ResolveHost(alias)
+			add(alias, fmt.Sprintf("%s@%s", host.User, host.Host))
+		}
+		for _, name := range manager.KnownHostNames() {
+			add(name, "known host")
+		}
+		return suggestions
+	}
+	specs.SetGeneratorFunc("ssh", "hosts", hosts)
+	specs.SetGeneratorFunc("remote", "hosts", hosts)
+
+	connections := func([]string) []core.Suggestion {
+		var suggestions []core.Suggestion
+		for _, conn := range manager.Connections() {
+			host := conn.Host()
+			suggestions = append(suggestions, core.Suggestion{
+				Text:        conn.Name(),
+				Description: fmt.Sprintf("%s@%s", host.User, host.Host),
+				Category:    "argument",
+			})
+		}
+		return suggestions
+	}
+	specs.SetGeneratorFunc("remote", "connections", connections)
+	specs.SetGeneratorFunc("tunnel", "connections", connections)
+	specs.SetGeneratorFunc("put", "connections", connections)
+	specs.SetGeneratorFunc("get", "connections", connections)
+	specs.SetGeneratorFunc("browse", "connections", connections)
+
+	specs.SetGeneratorFunc("fanout", "groups", func([]string) []core.Suggestion {
+		var suggestions []core.Suggestion
+		for _, group := range manager.Groups() {
+			description := "host group"
+			if hosts, err := manager.GroupHosts(group); err == nil {
+				description = plural(len(hosts), "host")
+			}
+			suggestions = append(suggestions, core.Suggestion{Text: group, Description: description, Category: "argument"})
+		}
+		return suggestions
+	})
+}
+
+// remoteOpenedMsg reports the outcome of opening a connection for a
+// remote pane.
+type remoteOpenedMsg struct {
+	name string
+	conn *ssh.Connection
+	err  error
+}
+
+// handleRemoteBuiltin runs the remote builtin, which attaches panes to
+// hosts over SSH:
+//
+//	remote [open] TARGET   open a pane on a saved host, a host of
+//	                       ~/.ssh/config or [user@]host[:port]
+//	remote list            list open connections and their panes
+//	remote close NAME      close a connection and its panes
+//
+// Each target is connected once; opening it again adds another pane on
+// the same connection.
+//
+// Returns whether the command was handled and any command to run.
+func (m *Model) handleRemoteBuiltin(command string) (bool, tea.Cmd) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 || parts[0] != "remote" {
+		return false, nil
+	}
+	args := parts[1:]
+
+	sub := ""
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch sub {
+	case "":
+		m.addOutput("usage: remote [open] TARGET | remote list | remote close NAME", false, 1)
+		return true, nil
+
+	case "list", "ls":
+		m.listRemotes()
+		return true, nil
+
+	case "close":
+		if len(args) != 2 {
+			m.addOutput("usage: remote close NAME", false, 1)
+			return true, nil
+		}
+		m.closeRemote(args[1])
+		return true, nil
+
+	case "open":
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		m.addOutput("usage: remote [open] TARGET", false, 1)
+		return true, nil
+	}
+	return true, m.openRemote(args[0])
+}
+
+// openRemote opens a pane on target, connecting to it first if needed.
+func (m *Model) openRemote(target string) tea.Cmd {
+	host := m.sshManager.ResolveHost(target)
+	name := host.Name
+
+	if conn, ok := m.sshManager.Connection(name); ok {
+		return func() tea.Msg {
+			return remoteOpenedMsg{name: name, conn: conn}
+		}
+	}
+
+	m.addOutput(fmt.Sprintf("Connecting to %s@%s:%d...", host.User, host.Host, host.Port), false, 0)
+	manager := m.sshManager
+	return func() tea.Msg {
+		conn, err := manager.Open(context.Background(), name, host)
+		return remoteOpenedMsg{name: name, conn: conn, err: err}
+	}
+}
+
+// remoteOpened attaches a new pane to a freshly opened connection and
+// makes it the active pane.
+func (m Model) remoteOpened(msg remoteOpenedMsg) (tea.Model, tea.Cmd) {
+	var changed *ssh.HostKeyChangedError
+	if errors.As(msg.err, &changed) {
+		return m, m.warnHostKeyChanged(msg.name, changed)
+	}
+	if msg.err != nil {
+		m.addOutput(fmt.Sprintf("remote: %s: %v", msg.name, msg.err), false, 1)
+		return m, nil
+	}
+
+	pane, err := m.paneManager.CreateRemote(msg.name, msg.conn.StartTerminal)
+	if err != nil {
+		m.addOutput(fmt.Sprintf("remote: %v", err), false, 1)
+		return m, nil
+	}
+	m.remotePanes[pane.ID()] = msg.name
+
+	host := msg.conn.Host()
+	m.addOutput(fmt.Sprintf("Opened pane %s on %s@%s:%d", pane.ID(), host.User, host.Host, host.Port), false, 0)
+
+	// Size the new pane's terminal like the others
+	return m.Update(tea.WindowSizeMsg{Width: m.width, Height: m.height})
+}
+
+// listRemotes prints the open connections, with the state of those that
+// are not up, and the panes attached to them.
+func (m *Model) listRemotes() {
+	conns := m.sshManager.Connections()
+	if len(conns) == 0 {
+		m.addOutput("No remote connections", false, 0)
+		return
+	}
+
+	for _, conn := range conns {
+		var paneIDs []string
+		for paneID, name := range m.remotePanes {
+			if _, open := m.paneManager.GetPane(paneID); !open {
+				delete(m.remotePanes, paneID)
+				continue
+			}
+			if name == conn.Name() {
+				paneIDs = append(paneIDs, paneID)
+			}
+		}
+		sort.Strings(paneIDs)
+
+		host := conn.Host()
+		line := fmt.Sprintf("%-16s %s@%s:%d", conn.Name(), host.User, host.Host, host.Port)
+		if state := conn.State(); state != core.SSHConnected {
+			line += "  " + state.String()
+			if err := conn.Err(); err != nil {
+				line += ": " + err.Error()
+			}
+		}
+		if len(paneIDs) > 0 {
+			line += "  panes: " + strings.Join(paneIDs, ", ")
+		}
+		m.addOutput(line, false, 0)
+	}
+}
+
+// closeRemote closes the named connection and the panes attached to it.
+func (m *Model) closeRemote(name string) {
+	if err := m.sshManager.CloseConnection(name); err != nil {
+		m.addOutput("remote: "+err.Error(), false, 1)
+		return
+	}
+
+	for paneID, conn := range m.remotePanes {
+		if conn == name {
+			_ = m.paneManager.Close(paneID)
+			delete(m.remotePanes, paneID)
+		}
+	}
+	m.addOutput("Closed "+name, false, 0)
+}
+
+// sshStateMsg carries a state change of an SSH connection.
+type sshStateMsg struct {
+	event ssh.StateEvent
+}
+
+// waitSSHEvent waits for the next state change of the SSH connections.
+func waitSSHEvent(events <-chan ssh.StateEvent) tea.Cmd {
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		event, ok := <-events
+		if !ok {
+			return nil
+		}
+		return sshStateMsg{event: event}
+	}
+}
+
+// sshStateChanged raises a toast when a connection is lost, comes back or
+// could not be reconnected. Connections opened or closed by the user pass
+// silently.
+func (m *Model) sshStateChanged(event ssh.StateEvent) tea.Cmd {
+	name := event.Connection
+	if name == "" {
+		name = "SSH"
+	}
+
+	switch {
+	case event.State == core.SSHReconnecting && event.Attempt == 1:
+		m.notifications.ShowWarning("Connection lost",
+			fmt.Sprintf("%s: %v, reconnecting in %s", name, event.Err, event.Delay))
+	case event.State == core.SSHConnected && event.Attempt > 0:
+		m.notifications.ShowSuccess("Reconnected",
+			fmt.Sprintf("%s is back after %s", name, plural(event.Attempt, "attempt")))
+	case event.State == core.SSHError:
+		m.notifications.ShowError("Reconnect failed", fmt.Sprintf("%s: %v", name, event.Err))
+	case event.State == core.SSHDisconnected && event.Err != nil:
+		m.notifications.ShowError("Connection lost", fmt.Sprintf("%s: %v", name, event.Err))
+	default:
+		return nil
+	}
+	return notificationTick()
+}
+
+// connectionStatus summarizes the remote connections for the status bar,
+// marking each as up, reconnecting or lost.
+func (m *Model) connectionStatus() string {
+	if m.sshManager == nil {
+		return ""
+	}
+	conns := m.sshManager.Connections()
+	if len(conns) == 0 {
+		return ""
+	}
+
+	parts := make([]string, 0, len(conns))
+	for _, conn := range conns {
+		parts = append(parts, conn.Name()+" "+stateMarker(conn.State()))
+	}
+	sort.Strings(parts)
+	return "SSH " + strings.Join(parts, " ")
+}
+
+// stateMarker returns the status bar mark of a connection state.
+func stateMarker(state core.SSHConnectionState) string {
+	switch state {
+	case core.SSHConnected:
+		return "✓"
+	case core.SSHReconnecting, core.SSHConnecting:
+		return "⟳"
+	default:
+		return "✗"
+	}
+}
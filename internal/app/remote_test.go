@@ -0,0 +1,117 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/panes"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/browser"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+	"github.com/cbwinslow/cbwsh/pkg/ui/notifications"
+	"github.com/cbwinslow/cbwsh/pkg/ui/transfers"
+	"github.com/cbwinslow/cbwsh/pkg/ui/tunnels"
+)
+
+func newRemoteTestModel() *Model {
+	manager := ssh.NewManager("", time.Second)
+	_ = manager.SaveHost(core.SSHHost{Name: "web", Host: "web.example.com", Port: 2222, User: "deploy"})
+	return &Model{
+		paneManager:   panes.NewManager(core.ShellTypeBash),
+		sshManager:    manager,
+		remotePanes:   make(map[string]string),
+		tunnelView:    tunnels.NewView(manager.Tunnels),
+		transferView:  transfers.NewView(manager.Transfers),
+		browser:       browser.New(),
+		dialog:        dialog.New(),
+		notifications: notifications.NewManager(),
+	}
+}
+
+func lastOutput(m *Model) string {
+	if len(m.commandOutput) == 0 {
+		return ""
+	}
+	return m.commandOutput[len(m.commandOutput)-1].content
+}
+
+func TestHandleRemoteBuiltin(t *testing.T) {
+	m := newRemoteTestModel()
+
+	if handled, _ := m.handleRemoteBuiltin("ls -l"); handled {
+		t.Error("only remote should be handled")
+	}
+
+	if handled, cmd := m.handleRemoteBuiltin("remote"); !handled || cmd != nil {
+		t.Fatal("expected bare remote to print usage")
+	}
+	if !strings.HasPrefix(lastOutput(m), "usage:") {
+		t.Errorf("expected usage, got %q", lastOutput(m))
+	}
+
+	m.handleRemoteBuiltin("remote list")
+	if lastOutput(m) != "No remote connections" {
+		t.Errorf("expected no connections, got %q", lastOutput(m))
+	}
+
+	m.handleRemoteBuiltin("remote close web")
+	if !strings.Contains(lastOutput(m), "connection not found") {
+		t.Errorf("expected an error closing an unknown connection, got %q", lastOutput(m))
+	}
+}
+
+func TestRemoteOpenedError(t *testing.T) {
+	m := newRemoteTestModel()
+
+	model, _ := m.remoteOpened(remoteOpenedMsg{name: "web", err: errors.New("connection refused")})
+	got := model.(Model)
+	if got.paneManager.Count() != 0 {
+		t.Error("expected no pane for a failed connection")
+	}
+	if out := lastOutput(&got); out != "remote: web: connection refused" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestSSHStateChanged(t *testing.T) {
+	m := newRemoteTestModel()
+	lost := errors.New("connection lost")
+
+	tests := []struct {
+		event ssh.StateEvent
+		title string
+	}{
+		{ssh.StateEvent{Connection: "web", State: core.SSHConnected}, ""},
+		{ssh.StateEvent{Connection: "web", State: core.SSHReconnecting, Err: lost, Attempt: 1, Delay: time.Second}, "Connection lost"},
+		{ssh.StateEvent{Connection: "web", State: core.SSHReconnecting, Err: lost, Attempt: 2, Delay: 2 * time.Second}, ""},
+		{ssh.StateEvent{Connection: "web", State: core.SSHConnected, Attempt: 2}, "Reconnected"},
+		{ssh.StateEvent{Connection: "web", State: core.SSHError, Err: lost}, "Reconnect failed"},
+		{ssh.StateEvent{Connection: "web", State: core.SSHDisconnected}, ""},
+	}
+	for _, tt := range tests {
+		before := m.notifications.Count()
+		cmd := m.sshStateChanged(tt.event)
+		if tt.title == "" {
+			if cmd != nil || m.notifications.Count() != before {
+				t.Errorf("%+v: expected no toast", tt.event)
+			}
+			continue
+		}
+		list := m.notifications.List()
+		if cmd == nil || len(list) != before+1 || list[len(list)-1].Title != tt.title {
+			t.Errorf("%+v: expected a %q toast", tt.event, tt.title)
+		}
+	}
+}
+
+func TestStateMarker(t *testing.T) {
+	if stateMarker(core.SSHConnected) != "✓" || stateMarker(core.SSHReconnecting) != "⟳" || stateMarker(core.SSHError) != "✗" {
+		t.Error("unexpected state markers")
+	}
+	if status := newRemoteTestModel().connectionStatus(); status != "" {
+		t.Errorf("expected no status without connections, got %q", status)
+	}
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+// withSecretsUsage describes the with-secrets builtin.
+const withSecretsUsage = "usage: with-secrets [NAME=]secret... -- command"
+
+// secretsResolvedMsg carries the secrets decrypted for a with-secrets
+// command, keyed by environment variable name.
+type secretsResolvedMsg struct {
+	command string
+	env     map[string]string
+	err     error
+}
+
+// handleSecretsBuiltin handles the with-secrets builtin, which runs a
+// command with secrets from the store in its environment:
+//
+//	with-secrets GITHUB_TOKEN=github/token -- gh pr list
+//	with-secrets aws/access-key aws/secret-key -- terraform plan
+//
+// A secret given without a variable name is passed in the variable named
+// after it, as AWS_ACCESS_KEY. The values reach only the command's own
+// process: never the pane's shell, its environment or the history, and
+// they are masked in the command's output.
+//
+// It reports whether command was the builtin. Valid commands start
+// running, with the store unlocked first if need be.
+func (m *Model) handleSecretsBuiltin(command string) (bool, tea.Cmd) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 || fields[0] != "with-secrets" {
+		return false, nil
+	}
+
+	mapping, run, err := parseWithSecrets(command)
+	if err != nil {
+		m.addOutput(err.Error(), false, 1)
+		return true, nil
+	}
+
+	pane := m.paneManager.ActivePane()
+	if pane != nil && !pane.GetShellExecutor().Local() {
+		m.addOutput("with-secrets: secrets can only be passed to local commands", false, 1)
+		return true, nil
+	}
+	return true, m.resolveSecrets(run, mapping)
+}
+
+// parseWithSecrets parses a with-secrets command line into the secrets
+// to pass, keyed by variable name, and the command to run.
+func parseWithSecrets(line string) (map[string]string, string, error) {
+	head, run, found := strings.Cut(line, " -- ")
+	run = strings.TrimSpace(run)
+	if !found || run == "" {
+		return nil, "", errors.New(withSecretsUsage)
+	}
+
+	args := strings.Fields(head)[1:]
+	if len(args) == 0 {
+		return nil, "", errors.New(withSecretsUsage)
+	}
+	mapping := make(map[string]string, len(args))
+	for _, arg := range args {
+		name, key, ok := strings.Cut(arg, "=")
+		if !ok {
+			name, key = secretEnvName(arg), arg
+		}
+		if !validEnvName(name) || key == "" {
+			return nil, "", fmt.Errorf("with-secrets: invalid secret %q", arg)
+		}
+		mapping[name] = key
+	}
+	return mapping, run, nil
+}
+
+// secretEnvName returns the variable a secret is passed in when no name
+// is given: its key upper-cased, with characters not allowed in variable
+// names replaced by underscores.
+func secretEnvName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// validEnvName reports whether name can be an environment variable.
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveSecrets decrypts the secrets of mapping in the background,
+// asking for the store's master password if it is locked.
+func (m *Model) resolveSecrets(command string, mapping map[string]string) tea.Cmd {
+	store, unlock := m.secretsManager, m.unlockSecrets
+	return func() tea.Msg {
+		if store == nil {
+			return secretsResolvedMsg{command: command, err: errors.New("no secrets store")}
+		}
+		if !store.IsUnlocked() {
+			if unlock == nil {
+				return secretsResolvedMsg{command: command, err: errors.New("secrets store is locked")}
+			}
+			if err := unlock(context.Background(), "the secrets for "+command); err != nil {
+				return secretsResolvedMsg{command: command, err: fmt.Errorf("failed to unlock secrets store: %w", err)}
+			}
+		}
+
+		names := make([]string, 0, len(mapping))
+		for name := range mapping {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		// The audit log records which command each secret was for
+		accessor := store.For("with-secrets: " + command)
+		env := make(map[string]string, len(mapping))
+		for _, name := range names {
+			value, err := accessor.Retrieve(mapping[name])
+			if err != nil {
+				return secretsResolvedMsg{command: command, err: fmt.Errorf("failed to read secret %s for %s: %w", mapping[name], name, err)}
+			}
+			env[name] = string(value)
+		}
+		return secretsResolvedMsg{command: command, env: env}
+	}
+}
+
+// secretsResolved starts a with-secrets command once its secrets are
+// decrypted, masking them in its output until it finishes.
+func (m *Model) secretsResolved(msg secretsResolvedMsg) tea.Cmd {
+	if msg.err != nil {
+		return func() tea.Msg {
+			return commandResultMsg{Command: msg.command, Error: "with-secrets: " + msg.err.Error(), ExitCode: 1}
+		}
+	}
+
+	values := make([]string, 0, len(msg.env))
+	for _, value := range msg.env {
+		values = append(values, value)
+	}
+	m.outputMask = secrets.NewMasker(values...)
+	return m.runCommandWithEnv(m.paneManager.ActivePane(), msg.command, msg.env)
+}
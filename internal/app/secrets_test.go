@@ -0,0 +1,155 @@
+package app
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/logging"
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+func TestParseWithSecrets(t *testing.T) {
+	tests := []struct {
+		line    string
+		mapping map[string]string
+		command string
+		wantErr bool
+	}{
+		{line: "with-secrets GH=github/token -- gh pr list", mapping: map[string]string{"GH": "github/token"}, command: "gh pr list"},
+		{line: "with-secrets aws/access-key 1pass -- env", mapping: map[string]string{"AWS_ACCESS_KEY": "aws/access-key", "_1PASS": "1pass"}, command: "env"},
+		{line: "with-secrets GH=github/token -- echo a -- b", mapping: map[string]string{"GH": "github/token"}, command: "echo a -- b"},
+		{line: "with-secrets GH=github/token", wantErr: true},
+		{line: "with-secrets -- env", wantErr: true},
+		{line: "with-secrets 1GH=github/token -- env", wantErr: true},
+		{line: "with-secrets GH= -- env", wantErr: true},
+	}
+	for _, tt := range tests {
+		mapping, command, err := parseWithSecrets(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseWithSecrets(%q) error = %v", tt.line, err)
+			continue
+		}
+		if command != tt.command || len(mapping) != len(tt.mapping) {
+			t.Errorf("parseWithSecrets(%q) = %v, %q", tt.line, mapping, command)
+			continue
+		}
+		for name, key := range tt.mapping {
+			if mapping[name] != key {
+				t.Errorf("parseWithSecrets(%q)[%s] = %q, want %q", tt.line, name, mapping[name], key)
+			}
+		}
+	}
+}
+
+func TestWithSecrets(t *testing.T) {
+	m := newRemoteTestModel()
+	if _, err := m.paneManager.Create(); err != nil {
+		t.Fatal(err)
+	}
+	m.secretsManager = secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	if err := m.secretsManager.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.secretsManager.Store("api/token", []byte("s3cret")); err != nil {
+		t.Fatal(err)
+	}
+
+	if handled, _ := m.handleSecretsBuiltin("with-secretsX -- env"); handled {
+		t.Error("only with-secrets should be handled")
+	}
+	handled, cmd := m.handleSecretsBuiltin(`with-secrets api/token -- echo "token=$API_TOKEN"`)
+	if !handled || cmd == nil {
+		t.Fatal("expected with-secrets to resolve its secrets")
+	}
+	resolved, ok := cmd().(secretsResolvedMsg)
+	if !ok || resolved.err != nil || resolved.env["API_TOKEN"] != "s3cret" {
+		t.Fatalf("unexpected resolution %+v", resolved)
+	}
+
+	// The value is masked in the command's output
+	cmd = m.secretsResolved(resolved)
+	for cmd != nil {
+		switch msg := cmd().(type) {
+		case commandOutputMsg:
+			m.appendChunk(core.OutputChunk(msg))
+			cmd = waitForCommandEvent(m.running)
+		case commandResultMsg:
+			if m.outputMask.Mask(msg.Output) != "token="+secrets.MaskText+"\n" {
+				t.Errorf("expected the captured output to be masked, got %q", m.outputMask.Mask(msg.Output))
+			}
+			m.flushPendingOutput()
+			cmd = nil
+		default:
+			t.Fatalf("unexpected message %T", msg)
+		}
+	}
+	if got := lastOutput(m); got != "token="+secrets.MaskText {
+		t.Errorf("expected masked output, got %q", got)
+	}
+
+	// It never reaches the pane's environment
+	if _, ok := m.paneManager.ActivePane().GetShellExecutor().GetEnvironment()["API_TOKEN"]; ok {
+		t.Error("expected the secret not to be in the pane's environment")
+	}
+
+	// Missing secrets fail the command
+	_, cmd = m.handleSecretsBuiltin("with-secrets missing -- env")
+	resolved = cmd().(secretsResolvedMsg)
+	if result, ok := m.secretsResolved(resolved)().(commandResultMsg); !ok || result.ExitCode == 0 || !strings.Contains(result.Error, "missing") {
+		t.Errorf("expected a missing secret to fail, got %+v", result)
+	}
+}
+
+func TestWithSecretsMasksPartialLines(t *testing.T) {
+	m := newRemoteTestModel()
+	m.logger = logging.New()
+	if _, err := m.paneManager.Create(); err != nil {
+		t.Fatal(err)
+	}
+	m.secretsManager = secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	if err := m.secretsManager.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.secretsManager.Store("api/token", []byte("s3cret")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last line has no newline
+	_, cmd := m.handleSecretsBuiltin(`with-secrets api/token -- printf "token=%s" "$API_TOKEN"`)
+	cmd = m.secretsResolved(cmd().(secretsResolvedMsg))
+	for cmd != nil {
+		switch msg := cmd().(type) {
+		case commandOutputMsg:
+			m.appendChunk(core.OutputChunk(msg))
+			for _, line := range m.pendingLines() {
+				if strings.Contains(line.content, "s3cret") {
+					t.Errorf("expected the pending line to be masked, got %q", line.content)
+				}
+			}
+			cmd = waitForCommandEvent(m.running)
+		case commandResultMsg:
+			model, _ := m.Update(msg)
+			*m = model.(Model)
+			cmd = nil
+		default:
+			t.Fatalf("unexpected message %T", msg)
+		}
+	}
+	if got := lastOutput(m); got != "token="+secrets.MaskText {
+		t.Errorf("expected the unterminated line to be masked, got %q", got)
+	}
+	if m.outputMask != nil {
+		t.Error("expected the mask to be cleared once the command finished")
+	}
+
+	// Lines still being written are shown masked
+	m.outputMask = secrets.NewMasker("s3cret")
+	m.appendChunk(core.OutputChunk{Stream: core.StreamStdout, Data: "partial s3cret"})
+	m.appendChunk(core.OutputChunk{Stream: core.StreamStderr, Data: "error s3cret"})
+	lines := m.pendingLines()
+	if len(lines) != 2 || lines[0].content != "partial "+secrets.MaskText || lines[1].content != "error "+secrets.MaskText {
+		t.Errorf("unexpected pending lines %+v", lines)
+	}
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+)
+
+// passwordDialogID identifies the dialogs asking for a password other
+// than the one unlocking the store, such as a bundle's passphrase.
+const passwordDialogID = "password"
+
+// passwordFunc asks the user for a password in a dialog titled title,
+// explaining what it is for in body.
+type passwordFunc func(ctx context.Context, title, body string) (string, error)
+
+// newPasswordPrompt returns a passwordFunc asking through prompts.
+func newPasswordPrompt(prompts chan<- promptMsg) passwordFunc {
+	return func(ctx context.Context, title, body string) (string, error) {
+		spec := dialog.Spec{
+			ID:          passwordDialogID,
+			Title:       title,
+			Body:        body,
+			Input:       true,
+			Placeholder: "password",
+			Mask:        true,
+		}
+		result, ok := ask(ctx, prompts, spec, "")
+		if !ok {
+			return "", ctx.Err()
+		}
+		if result.Cancelled {
+			return "", errors.New("cancelled")
+		}
+		return result.Input, nil
+	}
+}
+
+// secretsTaskMsg reports the outcome of a task of the secrets builtin
+// run in the background.
+type secretsTaskMsg struct {
+	lines []string
+	err   error
+}
+
+// secretsTaskDone reports a finished task of the secrets builtin.
+func (m *Model) secretsTaskDone(msg secretsTaskMsg) {
+	for _, line := range msg.lines {
+		m.addOutput(line, false, 0)
+	}
+	if msg.err != nil {
+		m.addOutput("secrets: "+msg.err.Error(), false, 1)
+	}
+}
+
+// storeFormat returns the key derivation parameters and cipher stores
+// are created and rekeyed with, as configured.
+func storeFormat(cfg config.SecretsConfig) (secrets.KDFParams, string) {
+	params := secrets.KDFParams{
+		Algorithm: cfg.KeyDerivation,
+		Time:      cfg.KDFTime,
+		Memory:    cfg.KDFMemory,
+		Threads:   cfg.KDFThreads,
+	}
+	return params, cfg.EncryptionAlgorithm
+}
+
+// showSecretsFormat shows how the store is encrypted, and whether rekeying
+// would upgrade it to the configured format.
+func (m *Model) showSecretsFormat() {
+	format, err := m.secretsManager.Format()
+	if errors.Is(err, os.ErrNotExist) {
+		m.addOutput("No secrets store yet", false, 0)
+		return
+	}
+	if err != nil {
+		m.addOutput("secrets: "+err.Error(), false, 1)
+		return
+	}
+
+	m.addOutput(fmt.Sprintf("Store: %s (format %d)", m.secretsManager.Path(), format.Version), false, 0)
+	m.addOutput("Cipher: "+format.Algorithm, false, 0)
+	m.addOutput("Key derivation: "+format.KDF.String(), false, 0)
+
+	params, algorithm := storeFormat(m.config.Secrets)
+	if format.Version < secrets.StoreVersion || format.KDF.Weaker(params) || !strings.EqualFold(format.Algorithm, algorithm) {
+		m.addOutput(fmt.Sprintf("Run secrets rekey to move the store to %s and %s", algorithm, params), false, 0)
+	}
+}
+
+// rekeySecrets re-encrypts the store with the configured cipher and key
+// derivation parameters, asking for its master password.
+func (m *Model) rekeySecrets() tea.Cmd {
+	store, askPassword, cache := m.secretsManager, m.askPassword, m.keyCache
+	params, algorithm := storeFormat(m.config.Secrets)
+	return func() tea.Msg {
+		if !store.Initialized() {
+			return secretsTaskMsg{err: errors.New("there is no secrets store yet")}
+		}
+		if askPassword == nil {
+			return secretsTaskMsg{err: errors.New("cannot ask for the master password")}
+		}
+		password, err := askPassword(context.Background(), "Rekey secrets",
+			fmt.Sprintf("The store will be re-encrypted with %s and %s. Enter its master password.", algorithm, params))
+		if err != nil {
+			return secretsTaskMsg{err: fmt.Errorf("rekey: %w", err)}
+		}
+		if err := store.Rekey(password, params, algorithm); err != nil {
+			return secretsTaskMsg{err: err}
+		}
+
+		// Keys cached by other instances no longer open the store
+		if cache != nil {
+			_ = cache.Forget(store.Path())
+			cacheKey(cache, store)
+		}
+		return secretsTaskMsg{lines: []string{fmt.Sprintf("Rekeyed the secrets store with %s and %s", algorithm, params)}}
+	}
+}
+
+// exportSecrets writes the secrets keys, or all of them, to a new bundle
+// at path, asking for its passphrase twice.
+func (m *Model) exportSecrets(path string, keys []string) tea.Cmd {
+	store, unlock, askPassword := m.secretsManager, m.unlockSecrets, m.askPassword
+	return func() tea.Msg {
+		if err := unlockFor(store, unlock, "the secrets to export"); err != nil {
+			return secretsTaskMsg{err: err}
+		}
+		if askPassword == nil {
+			return secretsTaskMsg{err: errors.New("cannot ask for a passphrase")}
+		}
+		body := fmt.Sprintf("Choose a passphrase to encrypt %s with. It is needed to import the bundle.", path)
+		passphrase, err := askPassword(context.Background(), "Export secrets", body)
+		if err != nil {
+			return secretsTaskMsg{err: fmt.Errorf("export: %w", err)}
+		}
+		confirmation, err := askPassword(context.Background(), "Export secrets", "Enter the passphrase again.")
+		if err != nil {
+			return secretsTaskMsg{err: fmt.Errorf("export: %w", err)}
+		}
+		if passphrase != confirmation {
+			return secretsTaskMsg{err: errors.New("export: the passphrases do not match")}
+		}
+
+		// An existing file is never replaced
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return secretsTaskMsg{err: err}
+		}
+		exported, err := secrets.ExportBundle(f, store.For("secrets export"), passphrase, keys...)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+			return secretsTaskMsg{err: err}
+		}
+		return secretsTaskMsg{lines: []string{fmt.Sprintf("Exported %s to %s", plural(len(exported), "secret"), path)}}
+	}
+}
+
+// importSecrets reads the bundle at path into the store, asking for its
+// passphrase. Secrets the store holds are kept unless replace is set.
+func (m *Model) importSecrets(path string, replace bool) tea.Cmd {
+	store, unlock, askPassword := m.secretsManager, m.unlockSecrets, m.askPassword
+	return func() tea.Msg {
+		f, err := os.Open(path)
+		if err != nil {
+			return secretsTaskMsg{err: err}
+		}
+		defer f.Close()
+
+		if err := unlockFor(store, unlock, "the secrets to import"); err != nil {
+			return secretsTaskMsg{err: err}
+		}
+		if askPassword == nil {
+			return secretsTaskMsg{err: errors.New("cannot ask for a passphrase")}
+		}
+		passphrase, err := askPassword(context.Background(), "Import secrets",
+			fmt.Sprintf("Enter the passphrase %s was exported with.", path))
+		if err != nil {
+			return secretsTaskMsg{err: fmt.Errorf("import: %w", err)}
+		}
+
+		imported, skipped, err := secrets.ImportBundle(f, store.For("secrets import"), passphrase, replace)
+		lines := []string{fmt.Sprintf("Imported %s from %s", plural(len(imported), "secret"), path)}
+		if len(skipped) > 0 {
+			lines = append(lines, fmt.Sprintf("Kept %s already in the store: %s (import -replace overwrites them)",
+				plural(len(skipped), "secret"), strings.Join(skipped, ", ")))
+		}
+		return secretsTaskMsg{lines: lines, err: err}
+	}
+}
+
+// unlockFor unlocks store with unlock for reason, if it is locked.
+func unlockFor(store *secrets.Manager, unlock func(context.Context, string) error, reason string) error {
+	if store.IsUnlocked() {
+		return nil
+	}
+	if unlock == nil {
+		return errors.New("secrets store is locked")
+	}
+	if err := unlock(context.Background(), reason); err != nil {
+		return fmt.Errorf("failed to unlock secrets store: %w", err)
+	}
+	return nil
+}
+
+// bundlePath resolves the path of a bundle given to the secrets builtin:
+// ~ is the home directory, and relative paths are taken from the active
+// pane's directory.
+func (m *Model) bundlePath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(m.localDir(), path)
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+// runSecretsTask runs a secrets builtin command running in the background
+// to completion.
+func runSecretsTask(t *testing.T, m *Model, command string) {
+	t.Helper()
+	handled, cmd := m.handleSecretsStoreBuiltin(command)
+	if !handled || cmd == nil {
+		t.Fatalf("expected %q to run in the background", command)
+	}
+	msg, ok := cmd().(secretsTaskMsg)
+	if !ok {
+		t.Fatalf("expected secretsTaskMsg from %q", command)
+	}
+	m.secretsTaskDone(msg)
+}
+
+// answerPasswords answers the password dialogs with answers in turn.
+func answerPasswords(m *Model, answers ...string) {
+	m.askPassword = func(context.Context, string, string) (string, error) {
+		if len(answers) == 0 {
+			return "", errors.New("cancelled")
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+}
+
+func TestSecretsRekey(t *testing.T) {
+	m := newSecretsTestModel(t)
+	m.config.Secrets.KDFMemory = 8 * 1024
+	m.config.Secrets.KDFThreads = 1
+	m.config.Secrets.EncryptionAlgorithm = secrets.AlgorithmXChaCha20Poly1305
+
+	m.commandOutput = nil
+	m.handleSecretsStoreBuiltin("secrets info")
+	if got := lastOutput(m); !strings.Contains(got, "secrets rekey") {
+		t.Errorf("expected a store in another format to suggest a rekey, got %q", got)
+	}
+
+	answerPasswords(m, "wrong")
+	runSecretsTask(t, m, "secrets rekey")
+	if got := lastOutput(m); !strings.Contains(got, "invalid master password") {
+		t.Errorf("expected a wrong password to fail the rekey, got %q", got)
+	}
+	answerPasswords(m, "master")
+	runSecretsTask(t, m, "secrets rekey")
+	if format, _ := m.secretsManager.Format(); format.Algorithm != secrets.AlgorithmXChaCha20Poly1305 || format.KDF.Memory != 8*1024 {
+		t.Errorf("Format() after rekey = %+v", format)
+	}
+
+	m.commandOutput = nil
+	m.handleSecretsStoreBuiltin("secrets info")
+	if len(m.commandOutput) != 3 || lastOutput(m) != "Key derivation: argon2id t=1 m=8MiB p=1" {
+		t.Errorf("unexpected info %q", lastOutput(m))
+	}
+}
+
+func TestSecretsBundles(t *testing.T) {
+	m := newSecretsTestModel(t)
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "secrets.age")
+
+	answerPasswords(m, "one", "two")
+	runSecretsTask(t, m, "secrets export "+bundle)
+	if _, err := os.Stat(bundle); !strings.Contains(lastOutput(m), "do not match") || err == nil {
+		t.Errorf("expected mismatched passphrases to write nothing, got %q", lastOutput(m))
+	}
+
+	answerPasswords(m, "bundle pass", "bundle pass")
+	runSecretsTask(t, m, "secrets export "+bundle+" token")
+	if got := lastOutput(m); got != "Exported 1 secret to "+bundle {
+		t.Errorf("unexpected output %q", got)
+	}
+	if info, err := os.Stat(bundle); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a private bundle, got %v, %v", info, err)
+	}
+	answerPasswords(m, "again", "again")
+	runSecretsTask(t, m, "secrets export "+bundle)
+	if got := lastOutput(m); !strings.Contains(got, "exists") {
+		t.Errorf("expected an existing file not to be replaced, got %q", got)
+	}
+
+	// Into the store of another machine
+	other := newSecretsTestModel(t)
+	if err := other.secretsManager.For("test").Store("token", []byte("theirs")); err != nil {
+		t.Fatal(err)
+	}
+	other.commandOutput = nil
+	answerPasswords(other, "bundle pass")
+	runSecretsTask(t, other, "secrets import "+bundle)
+	if len(other.commandOutput) != 2 || !strings.HasPrefix(lastOutput(other), "Kept 1 secret already in the store: token") {
+		t.Errorf("unexpected output %q", lastOutput(other))
+	}
+	answerPasswords(other, "bundle pass")
+	runSecretsTask(t, other, "secrets import -replace "+bundle)
+
+	// Bundle accesses are audited
+	entries, err := secrets.ReadAuditLog(other.config.Secrets.AuditLog)
+	if err != nil || len(entries) == 0 || entries[len(entries)-1].Caller != "secrets import" {
+		t.Errorf("expected the import to be audited, got %+v, %v", entries, err)
+	}
+	if got, err := other.secretsManager.Retrieve("token"); err != nil || string(got) != "value" {
+		t.Errorf("Retrieve() after import = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,455 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/history"
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+	"github.com/cbwinslow/cbwsh/pkg/ui/autocomplete"
+)
+
+// secretsSpec describes the secrets builtin for completion.
+const secretsSpec = `
+name: secrets
+description: List and describe secrets, and show their audit log
+subcommands:
+  - name: list
+    description: List secrets with their metadata
+    args:
+      - name: tag
+  - name: expiring
+    description: List secrets expiring or due for rotation
+    args:
+      - name: within
+  - name: describe
+    description: Set a secret's description
+    args:
+      - name: secret
+        generator: secrets
+      - name: description
+        variadic: true
+  - name: tag
+    description: Set a secret's tags
+    args:
+      - name: secret
+        generator: secrets
+      - name: tags
+        variadic: true
+  - name: expire
+    description: Set when a secret expires
+    args:
+      - name: secret
+        generator: secrets
+      - name: date
+  - name: rotate
+    description: Set how often a secret is rotated
+    args:
+      - name: secret
+        generator: secrets
+      - name: interval
+  - name: audit
+    description: Show the latest accesses to secrets
+    args:
+      - name: count
+  - name: lock
+    description: Lock the store and drop its cached key
+  - name: info
+    description: Show how the store is encrypted
+  - name: rekey
+    description: Re-encrypt the store with the configured cipher and key derivation
+  - name: export
+    description: Write secrets to an encrypted bundle
+    args:
+      - name: file
+        type: file
+      - name: secret
+        generator: secrets
+        variadic: true
+  - name: import
+    description: Read secrets from an encrypted bundle
+    options:
+      - names: [-replace]
+        description: Overwrite secrets the store holds
+    args:
+      - name: file
+        type: file
+`
+
+// registerSecretsCompletion completes secret names for the secrets
+// builtin from the store, which need not be unlocked.
+func registerSecretsCompletion(specs *autocomplete.SpecProvider, store *secrets.Manager) {
+	if spec, err := autocomplete.ParseSpec([]byte(secretsSpec)); err == nil {
+		specs.AddSpecs(spec)
+	}
+	specs.SetGeneratorFunc("secrets", "secrets", func([]string) []core.Suggestion {
+		metadata, _ := store.AllMetadata()
+		suggestions := make([]core.Suggestion, 0, len(metadata))
+		for key, md := range metadata {
+			description := md.Description
+			if description == "" {
+				description = "secret"
+			}
+			suggestions = append(suggestions, core.Suggestion{Text: key, Description: description, Category: "argument"})
+		}
+		sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].Text < suggestions[j].Text })
+		return suggestions
+	})
+}
+
+// secretsUsage describes the secrets builtin.
+const secretsUsage = "usage: secrets [list [TAG] | expiring [WITHIN] | describe NAME TEXT | tag NAME TAG... | expire NAME DATE|never | rotate NAME INTERVAL|never | audit [N] | lock | info | rekey | export FILE [NAME...] | import [-replace] FILE]"
+
+// Defaults of the secrets builtin.
+const (
+	defaultExpiringWithin = 30 * 24 * time.Hour
+	defaultAuditEntries   = 20
+)
+
+// secretDateLayout is how the secrets builtin reads and shows dates.
+const secretDateLayout = "2006-01-02"
+
+// secretMetadataMsg reports a change to a secret's metadata.
+type secretMetadataMsg struct {
+	key  string
+	text string
+	err  error
+}
+
+// secretsDueMsg carries the secrets that expired or were due for
+// rotation when the shell started.
+type secretsDueMsg struct {
+	due []secrets.DueSecret
+}
+
+// handleSecretsStoreBuiltin handles the secrets builtin, which shows the
+// secrets of the store and manages their metadata:
+//
+//	secrets [list [TAG]]                list secrets, or those tagged TAG
+//	secrets expiring [WITHIN]           list secrets due within 30d or WITHIN
+//	secrets describe NAME TEXT          describe a secret
+//	secrets tag NAME TAG...             set a secret's tags; none clears them
+//	secrets expire NAME DATE|never      set when a secret expires
+//	secrets rotate NAME INTERVAL|never  set how often a secret is rotated
+//	secrets audit [N]                   show the last 20 or N accesses
+//	secrets lock                        lock the store for every instance
+//	secrets info                        show how the store is encrypted
+//	secrets rekey                       re-encrypt it as configured
+//	secrets export FILE [NAME...]       write secrets to a bundle
+//	secrets import [-replace] FILE      read secrets from a bundle
+//
+// Names and metadata are read without unlocking the store; changing
+// metadata unlocks it first if need be. Bundles are encrypted with a
+// passphrase of their own, to move secrets to another machine or backend.
+//
+// Returns whether the command was handled and any command to run.
+func (m *Model) handleSecretsStoreBuiltin(command string) (bool, tea.Cmd) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 || fields[0] != "secrets" {
+		return false, nil
+	}
+	if m.secretsManager == nil {
+		m.addOutput("secrets: no secrets store", false, 1)
+		return true, nil
+	}
+
+	sub, args := "list", fields[1:]
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+	switch {
+	case sub == "list" && len(args) <= 1:
+		m.listSecrets(strings.Join(args, ""))
+	case sub == "expiring" && len(args) <= 1:
+		within := defaultExpiringWithin
+		if len(args) == 1 {
+			var err error
+			if within, err = history.ParseAge(args[0]); err != nil {
+				m.addOutput("secrets: "+err.Error(), false, 1)
+				return true, nil
+			}
+		}
+		m.listExpiringSecrets(within)
+	case sub == "audit" && len(args) <= 1:
+		n := defaultAuditEntries
+		if len(args) == 1 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+				m.addOutput("secrets: audit needs a number of entries", false, 1)
+				return true, nil
+			}
+		}
+		m.showSecretsAudit(n)
+	case sub == "describe" && len(args) >= 1:
+		description := strings.Join(args[1:], " ")
+		return true, m.updateSecretMetadata(args[0], func(md *secrets.Metadata) string {
+			md.Description = description
+			if description == "" {
+				return "cleared description"
+			}
+			return "described as " + description
+		})
+	case sub == "tag" && len(args) >= 1:
+		tags := slices.Compact(slices.Sorted(slices.Values(args[1:])))
+		return true, m.updateSecretMetadata(args[0], func(md *secrets.Metadata) string {
+			md.Tags = tags
+			if len(tags) == 0 {
+				return "cleared tags"
+			}
+			return "tagged " + strings.Join(tags, ", ")
+		})
+	case sub == "expire" && len(args) == 2:
+		var expires time.Time
+		if args[1] != "never" {
+			var err error
+			if expires, err = time.ParseInLocation(secretDateLayout, args[1], time.Local); err != nil {
+				m.addOutput(fmt.Sprintf("secrets: invalid date %q, expected YYYY-MM-DD or never", args[1]), false, 1)
+				return true, nil
+			}
+		}
+		return true, m.updateSecretMetadata(args[0], func(md *secrets.Metadata) string {
+			md.Expires = expires
+			if expires.IsZero() {
+				return "never expires"
+			}
+			return "expires " + expires.Format(secretDateLayout)
+		})
+	case sub == "rotate" && len(args) == 2:
+		var every time.Duration
+		if args[1] != "never" {
+			var err error
+			if every, err = history.ParseAge(args[1]); err != nil || every == 0 {
+				m.addOutput(fmt.Sprintf("secrets: invalid interval %q, expected one such as 90d or never", args[1]), false, 1)
+				return true, nil
+			}
+		}
+		return true, m.updateSecretMetadata(args[0], func(md *secrets.Metadata) string {
+			md.RotateEvery = every
+			if every == 0 {
+				return "need not be rotated"
+			}
+			return "rotated every " + formatAge(every)
+		})
+	case sub == "lock" && len(args) == 0:
+		return true, m.lockSecrets()
+	case sub == "info" && len(args) == 0:
+		m.showSecretsFormat()
+	case sub == "rekey" && len(args) == 0:
+		return true, m.rekeySecrets()
+	case sub == "export" && len(args) >= 1:
+		return true, m.exportSecrets(m.bundlePath(args[0]), args[1:])
+	case sub == "import" && len(args) == 1:
+		return true, m.importSecrets(m.bundlePath(args[0]), false)
+	case sub == "import" && len(args) == 2 && args[0] == "-replace":
+		return true, m.importSecrets(m.bundlePath(args[1]), true)
+	default:
+		m.addOutput(secretsUsage, false, 1)
+	}
+	return true, nil
+}
+
+// listSecrets lists the secrets of the store with their metadata, or only
+// those tagged tag if not empty.
+func (m *Model) listSecrets(tag string) {
+	metadata, err := m.secretsManager.AllMetadata()
+	if err != nil {
+		m.addOutput("secrets: "+err.Error(), false, 1)
+		return
+	}
+
+	keys := make([]string, 0, len(metadata))
+	for key, md := range metadata {
+		if tag == "" || slices.Contains(md.Tags, tag) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		m.addOutput("No secrets", false, 0)
+		return
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m.addOutput(describeSecret(key, metadata[key]), false, 0)
+	}
+}
+
+// describeSecret formats a secret's name and metadata on a line.
+func describeSecret(key string, md secrets.Metadata) string {
+	line := key
+	if md.Description != "" {
+		line += " - " + md.Description
+	}
+	if len(md.Tags) > 0 {
+		line += " [" + strings.Join(md.Tags, ", ") + "]"
+	}
+
+	var details []string
+	if !md.Updated.IsZero() {
+		details = append(details, "updated "+md.Updated.Local().Format(secretDateLayout))
+	}
+	if md.RotateEvery > 0 {
+		details = append(details, fmt.Sprintf("rotate every %s, by %s", formatAge(md.RotateEvery), md.RotateBy().Local().Format(secretDateLayout)))
+	}
+	if !md.Expires.IsZero() {
+		details = append(details, "expires "+md.Expires.Local().Format(secretDateLayout))
+	}
+	if len(details) > 0 {
+		line += " (" + strings.Join(details, "; ") + ")"
+	}
+	return line
+}
+
+// listExpiringSecrets lists the secrets that expire or are due for
+// rotation within the given time, soonest first.
+func (m *Model) listExpiringSecrets(within time.Duration) {
+	metadata, err := m.secretsManager.AllMetadata()
+	if err != nil {
+		m.addOutput("secrets: "+err.Error(), false, 1)
+		return
+	}
+
+	now := time.Now()
+	due := secrets.DueSecrets(metadata, now.Add(within))
+	if len(due) == 0 {
+		m.addOutput(fmt.Sprintf("No secrets expire or are due for rotation within %s", formatAge(within)), false, 0)
+		return
+	}
+	for _, secret := range due {
+		m.addOutput(describeDueSecret(secret, now), false, 0)
+	}
+}
+
+// describeDueSecret formats a secret that expires or is due for rotation.
+func describeDueSecret(secret secrets.DueSecret, now time.Time) string {
+	when := secret.When.Local().Format(secretDateLayout)
+	switch {
+	case secret.Reason == secrets.DueExpiry && secret.Overdue(now):
+		return fmt.Sprintf("%s expired on %s", secret.Key, when)
+	case secret.Reason == secrets.DueExpiry:
+		return fmt.Sprintf("%s expires on %s", secret.Key, when)
+	case secret.Overdue(now):
+		return fmt.Sprintf("%s was due for rotation on %s", secret.Key, when)
+	default:
+		return fmt.Sprintf("%s is due for rotation on %s", secret.Key, when)
+	}
+}
+
+// showSecretsAudit shows the last n entries of the audit log, and where
+// its hash chain breaks, if it does.
+func (m *Model) showSecretsAudit(n int) {
+	path := m.config.Secrets.AuditLog
+	if path == "" {
+		m.addOutput("secrets: the audit log is disabled", false, 1)
+		return
+	}
+
+	entries, err := secrets.ReadAuditLog(path)
+	if len(entries) == 0 && err == nil {
+		m.addOutput("No accesses recorded", false, 0)
+		return
+	}
+	for _, entry := range entries[max(0, len(entries)-n):] {
+		line := fmt.Sprintf("%d %s %s %s", entry.Seq, entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Op, entry.Key)
+		if entry.Caller != "" {
+			line += " by " + entry.Caller
+		}
+		if entry.Error != "" {
+			line += ": " + entry.Error
+		}
+		m.addOutput(line, false, 0)
+	}
+	if err != nil {
+		m.addOutput("secrets: "+err.Error(), false, 1)
+	}
+}
+
+// updateSecretMetadata changes the metadata of the secret key with update,
+// which returns a description of the change, unlocking the store first if
+// need be.
+func (m *Model) updateSecretMetadata(key string, update func(*secrets.Metadata) string) tea.Cmd {
+	store, unlock := m.secretsManager, m.unlockSecrets
+	return func() tea.Msg {
+		if err := unlockFor(store, unlock, "the metadata of "+key); err != nil {
+			return secretMetadataMsg{key: key, err: err}
+		}
+
+		metadata, err := store.AllMetadata()
+		if err != nil {
+			return secretMetadataMsg{key: key, err: err}
+		}
+		md, ok := metadata[key]
+		if !ok {
+			return secretMetadataMsg{key: key, err: fmt.Errorf("secret not found: %s", key)}
+		}
+		text := update(&md)
+		if err := store.SetMetadata(key, md); err != nil {
+			return secretMetadataMsg{key: key, err: err}
+		}
+		return secretMetadataMsg{key: key, text: text}
+	}
+}
+
+// secretMetadataUpdated reports a change to a secret's metadata.
+func (m *Model) secretMetadataUpdated(msg secretMetadataMsg) {
+	if msg.err != nil {
+		m.addOutput("secrets: "+msg.err.Error(), false, 1)
+		return
+	}
+	m.addOutput(fmt.Sprintf("%s %s", msg.key, msg.text), false, 0)
+}
+
+// checkDueSecrets looks for secrets that expired or are past their
+// rotation date, which needs no unlocking.
+func checkDueSecrets(store *secrets.Manager) tea.Cmd {
+	if store == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		metadata, err := store.AllMetadata()
+		if err != nil {
+			return nil
+		}
+		if due := secrets.DueSecrets(metadata, time.Now()); len(due) > 0 {
+			return secretsDueMsg{due: due}
+		}
+		return nil
+	}
+}
+
+// maxDueSecretsShown bounds the secrets named in a reminder toast.
+const maxDueSecretsShown = 3
+
+// secretsDue reminds the user of the secrets that expired or are past
+// their rotation date, in a toast for each reason.
+func (m *Model) secretsDue(msg secretsDueMsg) tea.Cmd {
+	var expired, rotate []string
+	for _, secret := range msg.due {
+		if secret.Reason == secrets.DueExpiry {
+			expired = append(expired, secret.Key)
+		} else {
+			rotate = append(rotate, secret.Key)
+		}
+	}
+	if len(expired) > 0 {
+		m.notifications.ShowWarning("Secrets expired", listKeys(expired))
+	}
+	if len(rotate) > 0 {
+		m.notifications.ShowWarning("Secrets due for rotation", listKeys(rotate))
+	}
+	return notificationTick()
+}
+
+// listKeys lists the first few of keys, counting the rest.
+func listKeys(keys []string) string {
+	if len(keys) <= maxDueSecretsShown {
+		return strings.Join(keys, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(keys[:maxDueSecretsShown], ", "), len(keys)-maxDueSecretsShown)
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+// newSecretsTestModel returns a model whose secrets store holds token and
+// password, and records accesses in an audit log.
+func newSecretsTestModel(t *testing.T) *Model {
+	t.Helper()
+	dir := t.TempDir()
+	m := newRemoteTestModel()
+	m.config = config.Default()
+	m.config.Secrets.AuditLog = filepath.Join(dir, "audit.log")
+
+	m.secretsManager = secrets.NewManager(filepath.Join(dir, "secrets.enc"))
+	if err := m.secretsManager.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := secrets.OpenAuditLog(m.config.Secrets.AuditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.secretsManager.SetAuditLog(auditLog)
+	for _, key := range []string{"token", "password"} {
+		if err := m.secretsManager.For("test").Store(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+// runSecrets runs a secrets builtin command to completion.
+func runSecrets(t *testing.T, m *Model, command string) {
+	t.Helper()
+	handled, cmd := m.handleSecretsStoreBuiltin(command)
+	if !handled {
+		t.Fatalf("expected %q to be handled", command)
+	}
+	if cmd != nil {
+		msg, ok := cmd().(secretMetadataMsg)
+		if !ok {
+			t.Fatalf("expected secretMetadataMsg from %q", command)
+		}
+		m.secretMetadataUpdated(msg)
+	}
+}
+
+func TestSecretsStoreBuiltin(t *testing.T) {
+	m := newSecretsTestModel(t)
+
+	if handled, _ := m.handleSecretsStoreBuiltin("secretsX"); handled {
+		t.Error("only secrets should be handled")
+	}
+
+	runSecrets(t, m, "secrets describe token CI deploy token")
+	runSecrets(t, m, "secrets tag token ci github ci")
+	runSecrets(t, m, "secrets rotate token 90d")
+	if got := lastOutput(m); got != "token rotated every 90d" {
+		t.Errorf("unexpected output %q", got)
+	}
+	md, _ := m.secretsManager.AllMetadata()
+	if token := md["token"]; token.Description != "CI deploy token" || !slices.Equal(token.Tags, []string{"ci", "github"}) || token.RotateEvery != 90*24*time.Hour {
+		t.Errorf("unexpected metadata %+v", token)
+	}
+
+	// Listing needs no unlocking
+	if err := m.secretsManager.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	m.commandOutput = nil
+	runSecrets(t, m, "secrets list ci")
+	if len(m.commandOutput) != 1 || !strings.HasPrefix(lastOutput(m), "token - CI deploy token [ci, github] (updated ") {
+		t.Errorf("unexpected listing %q", lastOutput(m))
+	}
+
+	// Changing metadata unlocks the store first
+	unlocked := false
+	m.unlockSecrets = func(_ context.Context, reason string) error {
+		unlocked = true
+		return m.secretsManager.Unlock("master")
+	}
+	runSecrets(t, m, "secrets expire password 2000-01-01")
+	if !unlocked || lastOutput(m) != "password expires 2000-01-01" {
+		t.Errorf("expected the store to be unlocked to set the expiry, got %q", lastOutput(m))
+	}
+	runSecrets(t, m, "secrets expire missing never")
+	if !strings.Contains(lastOutput(m), "secret not found") {
+		t.Errorf("expected a missing secret to be reported, got %q", lastOutput(m))
+	}
+	runSecrets(t, m, "secrets rotate token soon")
+	if !strings.Contains(lastOutput(m), "invalid interval") {
+		t.Errorf("expected an invalid interval to be refused, got %q", lastOutput(m))
+	}
+
+	runSecrets(t, m, "secrets expiring")
+	if got := lastOutput(m); got != "password expired on 2000-01-01" {
+		t.Errorf("unexpected expiring secrets %q", got)
+	}
+	runSecrets(t, m, "secrets expiring 100d")
+	if got := lastOutput(m); !strings.HasPrefix(got, "token is due for rotation on ") {
+		t.Errorf("unexpected expiring secrets %q", got)
+	}
+
+	// The audit log shows who stored the secrets
+	m.commandOutput = nil
+	runSecrets(t, m, "secrets audit 1")
+	if got := lastOutput(m); len(m.commandOutput) != 1 || !strings.HasSuffix(got, "store password by test") {
+		t.Errorf("unexpected audit log %q", got)
+	}
+}
+
+func TestSecretsDue(t *testing.T) {
+	m := newSecretsTestModel(t)
+	if msg := checkDueSecrets(m.secretsManager)(); msg != nil {
+		t.Fatalf("expected no secrets to be due, got %+v", msg)
+	}
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := m.secretsManager.Store(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.secretsManager.SetMetadata(key, secrets.Metadata{RotateEvery: time.Nanosecond}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.secretsManager.SetMetadata("token", secrets.Metadata{Expires: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.secretsManager.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, ok := checkDueSecrets(m.secretsManager)().(secretsDueMsg)
+	if !ok || len(msg.due) != 5 {
+		t.Fatalf("expected five secrets to be due, got %+v", msg)
+	}
+	m.secretsDue(msg)
+	var messages []string
+	for _, toast := range m.notifications.List() {
+		messages = append(messages, toast.Title+": "+toast.Message)
+	}
+	slices.Sort(messages)
+	want := []string{"Secrets due for rotation: a, b, c and 1 more", "Secrets expired: token"}
+	if !slices.Equal(messages, want) {
+		t.Errorf("toasts = %q, want %q", messages, want)
+	}
+}
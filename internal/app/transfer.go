@@ -0,0 +1,410 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/browser"
+	"github.com/cbwinslow/cbwsh/pkg/ui/transfers"
+	"github.com/cbwinslow/cbwsh/pkg/ui/tunnels"
+)
+
+// putSpec, getSpec, transferSpec and browseSpec describe the file transfer
+// builtins for completion.
+const putSpec = `
+name: put
+description: Upload files over SFTP
+options:
+  - names: [-r]
+    description: Copy directories recursively
+  - names: [-a]
+    description: Resume partly copied files
+args:
+  - name: connection
+    generator: connections
+  - name: local
+    type: file
+  - name: remote
+`
+
+const getSpec = `
+name: get
+description: Download files over SFTP
+options:
+  - names: [-r]
+    description: Copy directories recursively
+  - names: [-a]
+    description: Resume partly copied files
+args:
+  - name: connection
+    generator: connections
+  - name: remote
+  - name: local
+    type: file
+`
+
+const transferSpec = `
+name: transfer
+description: Manage the file transfer queue
+subcommands:
+  - name: list
+    description: List transfers
+  - name: view
+    description: Show or hide the transfer view
+  - name: cancel
+    description: Cancel a transfer
+  - name: retry
+    description: Resume a failed or canceled transfer
+  - name: clear
+    description: Remove finished transfers
+`
+
+const browseSpec = `
+name: browse
+description: Browse local and remote files side by side
+args:
+  - name: connection
+    generator: connections
+`
+
+// transfersQueuedMsg reports the outcome of a put or get.
+type transfersQueuedMsg struct {
+	label     string
+	transfers []*ssh.Transfer
+	err       error
+}
+
+// transfersDoneMsg reports that the transfers of a put or get finished.
+type transfersDoneMsg struct {
+	label     string
+	transfers []*ssh.Transfer
+}
+
+// browserOpenedMsg reports the outcome of starting SFTP for the browser.
+type browserOpenedMsg struct {
+	name   string
+	remote browser.Source
+	err    error
+}
+
+// handleTransferBuiltin runs the file transfer builtins, which copy files
+// over the SFTP subsystem of the connections of remote panes:
+//
+//	put [-r] [-a] NAME LOCAL [REMOTE]   upload files matching LOCAL
+//	get [-r] [-a] NAME REMOTE [LOCAL]   download files matching REMOTE
+//	transfer [list]                     list transfers
+//	transfer view                       show or hide the transfer view
+//	transfer cancel|retry ID            cancel or resume a transfer
+//	transfer clear                      remove finished transfers
+//	browse NAME                         browse local and remote files
+//
+// -r copies directories recursively and -a resumes files that were partly
+// copied before. Relative local paths are taken from the active pane's
+// directory and relative remote paths from the home directory.
+//
+// Returns whether the command was handled and any command to run.
+func (m *Model) handleTransferBuiltin(command string) (bool, tea.Cmd) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 {
+		return false, nil
+	}
+	switch parts[0] {
+	case "put", "get":
+		return true, m.queueTransfers(parts[0], parts[1:])
+	case "transfer":
+		return true, m.handleTransferCommand(parts[1:])
+	case "browse":
+		if len(parts) != 2 {
+			m.addOutput("usage: browse NAME", false, 1)
+			return true, nil
+		}
+		return true, m.openBrowser(parts[1])
+	}
+	return false, nil
+}
+
+// queueTransfers parses the arguments of put or get and queues the
+// transfers off the UI goroutine, as expanding remote globs takes round
+// trips.
+func (m *Model) queueTransfers(verb string, args []string) tea.Cmd {
+	usage := "usage: put [-r] [-a] NAME LOCAL [REMOTE]"
+	if verb == "get" {
+		usage = "usage: get [-r] [-a] NAME REMOTE [LOCAL]"
+	}
+
+	opts := ssh.TransferOptions{LocalDir: m.localDir()}
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-r":
+			opts.Recursive = true
+		case arg == "-a":
+			opts.Resume = true
+		case arg == "-ra" || arg == "-ar":
+			opts.Recursive, opts.Resume = true, true
+		case strings.HasPrefix(arg, "-"):
+			m.addOutput(fmt.Sprintf("%s: unknown option %s", verb, arg), false, 1)
+			return nil
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) < 2 || len(positional) > 3 {
+		m.addOutput(usage, false, 1)
+		return nil
+	}
+
+	name, source, dest := positional[0], positional[1], ""
+	if len(positional) == 3 {
+		dest = positional[2]
+	}
+	return m.startTransfers(verb, name, []string{source}, dest, opts)
+}
+
+// startTransfers returns a command that queues copying sources to dest
+// over the named connection: uploads for put and downloads for get.
+func (m *Model) startTransfers(verb, name string, sources []string, dest string, opts ssh.TransferOptions) tea.Cmd {
+	manager := m.sshManager
+	label := fmt.Sprintf("%s %s %s", verb, name, strings.Join(sources, " "))
+	return func() tea.Msg {
+		queue := manager.Put
+		if verb == "get" {
+			queue = manager.Get
+		}
+		queued, err := queue(name, sources, dest, opts)
+		return transfersQueuedMsg{label: label, transfers: queued, err: err}
+	}
+}
+
+// transfersQueued reports queued transfers, shows the transfer view and
+// waits for the transfers to finish.
+func (m *Model) transfersQueued(msg transfersQueuedMsg) tea.Cmd {
+	if msg.err != nil {
+		m.addOutput(fmt.Sprintf("%s: %v", strings.Fields(msg.label)[0], msg.err), false, 1)
+		return nil
+	}
+	if len(msg.transfers) == 0 {
+		m.addOutput("Nothing to transfer", false, 0)
+		return nil
+	}
+
+	first, last := msg.transfers[0].ID(), msg.transfers[len(msg.transfers)-1].ID()
+	ids := fmt.Sprintf("#%d", first)
+	if last != first {
+		ids = fmt.Sprintf("#%d-#%d", first, last)
+	}
+	m.addOutput(fmt.Sprintf("Queued %s (%s)", plural(len(msg.transfers), "file"), ids), false, 0)
+
+	wait := func() tea.Msg {
+		for _, t := range msg.transfers {
+			<-t.Done()
+		}
+		return transfersDoneMsg{label: msg.label, transfers: msg.transfers}
+	}
+	if m.transferView.IsVisible() {
+		return wait
+	}
+	m.transferView.Show()
+	return tea.Batch(wait, m.relayout(), transfers.Tick())
+}
+
+// transfersDone reports how the transfers of a put or get went, and lists
+// the browser's directories again so copied files show up.
+func (m *Model) transfersDone(msg transfersDoneMsg) tea.Cmd {
+	var done, failed, canceled int
+	var firstErr error
+	for _, t := range msg.transfers {
+		switch t.State() {
+		case ssh.TransferDone:
+			done++
+		case ssh.TransferCanceled:
+			canceled++
+		case ssh.TransferFailed:
+			failed++
+			if firstErr == nil {
+				firstErr = t.Err()
+			}
+		}
+	}
+
+	switch {
+	case failed > 0:
+		m.notifications.ShowError("Transfer failed",
+			fmt.Sprintf("%s: %d of %d failed: %v", msg.label, failed, len(msg.transfers), firstErr))
+	case canceled > 0:
+		m.notifications.ShowWarning("Transfer canceled",
+			fmt.Sprintf("%s: %d of %d canceled", msg.label, canceled, len(msg.transfers)))
+	default:
+		m.notifications.ShowSuccess("Transfer complete",
+			fmt.Sprintf("%s: %s copied", msg.label, plural(done, "file")))
+	}
+	return tea.Batch(notificationTick(), m.browser.Refresh())
+}
+
+// handleTransferCommand runs the transfer builtin.
+func (m *Model) handleTransferCommand(args []string) tea.Cmd {
+	sub := "list"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch sub {
+	case "list", "ls":
+		m.listTransfers()
+		return nil
+
+	case "view":
+		m.transferView.Toggle()
+		if !m.transferView.IsVisible() {
+			return m.relayout()
+		}
+		return tea.Batch(m.relayout(), transfers.Tick())
+
+	case "clear":
+		m.sshManager.ClearTransfers()
+		m.addOutput("Cleared finished transfers", false, 0)
+		return nil
+
+	case "cancel", "retry":
+		id, err := strconv.Atoi(strings.TrimPrefix(strings.Join(args[1:], ""), "#"))
+		if len(args) != 2 || err != nil {
+			m.addOutput(fmt.Sprintf("usage: transfer %s ID", sub), false, 1)
+			return nil
+		}
+		if sub == "cancel" {
+			if err := m.sshManager.CancelTransfer(id); err != nil {
+				m.addOutput("transfer: "+err.Error(), false, 1)
+				return nil
+			}
+			m.addOutput(fmt.Sprintf("Canceled transfer #%d", id), false, 0)
+			return nil
+		}
+		retry, err := m.sshManager.RetryTransfer(id)
+		if err != nil {
+			m.addOutput("transfer: "+err.Error(), false, 1)
+			return nil
+		}
+		return m.transfersQueued(transfersQueuedMsg{
+			label:     fmt.Sprintf("retry %s %s", retry.Connection(), retry.Source()),
+			transfers: []*ssh.Transfer{retry},
+		})
+	}
+
+	m.addOutput("usage: transfer [list] | transfer view | transfer cancel|retry ID | transfer clear", false, 1)
+	return nil
+}
+
+// listTransfers prints the transfers and how far they got.
+func (m *Model) listTransfers() {
+	all := m.sshManager.Transfers()
+	if len(all) == 0 {
+		m.addOutput("No transfers", false, 0)
+		return
+	}
+
+	for _, t := range all {
+		arrow := "→"
+		if t.Direction() == ssh.TransferDownload {
+			arrow = "←"
+		}
+		line := fmt.Sprintf("#%-3d %-9s %-12s %s %s %s", t.ID(), t.State(), t.Connection(), t.Source(), arrow, t.Dest())
+		switch t.State() {
+		case ssh.TransferFailed:
+			m.addOutput(line+"  "+t.Err().Error(), false, 1)
+		case ssh.TransferRunning, ssh.TransferCanceled:
+			m.addOutput(fmt.Sprintf("%s  %s of %s", line, tunnels.FormatBytes(t.Transferred()), tunnels.FormatBytes(t.Size())), false, 0)
+		default:
+			m.addOutput(fmt.Sprintf("%s  %s", line, tunnels.FormatBytes(t.Size())), false, 0)
+		}
+	}
+}
+
+// openBrowser returns a command that starts SFTP on the named connection
+// for the file browser.
+func (m *Model) openBrowser(name string) tea.Cmd {
+	manager := m.sshManager
+	return func() tea.Msg {
+		client, err := manager.SFTP(name)
+		if err != nil {
+			return browserOpenedMsg{name: name, err: err}
+		}
+		home, err := client.Getwd()
+		if err != nil {
+			return browserOpenedMsg{name: name, err: err}
+		}
+		return browserOpenedMsg{name: name, remote: browser.Source{
+			Title:  name,
+			Dir:    home,
+			List:   client.ReadDir,
+			Join:   path.Join,
+			Parent: path.Dir,
+		}}
+	}
+}
+
+// browserOpened opens the browser on the active pane's directory and the
+// host's home directory.
+func (m *Model) browserOpened(msg browserOpenedMsg) tea.Cmd {
+	if msg.err != nil {
+		m.addOutput(fmt.Sprintf("browse: %s: %v", msg.name, msg.err), false, 1)
+		return nil
+	}
+	local := browser.Source{
+		Title:  "local",
+		Dir:    m.localDir(),
+		List:   readLocalDir,
+		Join:   filepath.Join,
+		Parent: filepath.Dir,
+	}
+	return m.browser.Open(local, msg.remote)
+}
+
+// browserCopy queues the copy the browser asked for.
+func (m *Model) browserCopy(msg browser.CopyMsg) tea.Cmd {
+	verb := "put"
+	if msg.From == browser.Remote {
+		verb = "get"
+	}
+	return m.startTransfers(verb, m.browser.Title(), msg.Paths, msg.Dest, ssh.TransferOptions{Recursive: true})
+}
+
+// localDir returns the active pane's directory, or the working directory
+// for remote panes.
+func (m *Model) localDir() string {
+	if pane := m.paneManager.ActivePane(); pane != nil && pane.GetShellExecutor().Local() {
+		if dir := paneDir(pane); dir != "" {
+			return dir
+		}
+	}
+	wd, _ := os.Getwd()
+	return wd
+}
+
+// readLocalDir lists a local directory for the browser.
+func readLocalDir(dir string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// plural formats a count of things, such as "1 file" or "3 files".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
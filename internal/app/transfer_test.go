@@ -0,0 +1,67 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/ui/autocomplete"
+)
+
+func TestHandleTransferBuiltin(t *testing.T) {
+	m := newRemoteTestModel()
+
+	if handled, _ := m.handleTransferBuiltin("putty web"); handled {
+		t.Error("only the transfer builtins should be handled")
+	}
+
+	m.handleTransferBuiltin("transfer")
+	if lastOutput(m) != "No transfers" {
+		t.Errorf("expected no transfers, got %q", lastOutput(m))
+	}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"put web", "usage: put"},
+		{"get web a b c", "usage: get"},
+		{"put -x web a.txt", "unknown option -x"},
+		{"put web a.txt", "put: connection not found: web"},
+		{"get -r web logs", "get: connection not found: web"},
+		{"transfer cancel 7", "transfer not found: 7"},
+		{"transfer retry x", "usage: transfer retry"},
+		{"transfer frobnicate", "usage: transfer"},
+		{"browse", "usage: browse"},
+		{"browse web", "browse: web: connection not found: web"},
+	}
+	for _, tt := range tests {
+		_, cmd := m.handleTransferBuiltin(tt.command)
+		if cmd != nil {
+			switch msg := cmd().(type) {
+			case transfersQueuedMsg:
+				m.transfersQueued(msg)
+			case browserOpenedMsg:
+				m.browserOpened(msg)
+			}
+		}
+		if !strings.Contains(lastOutput(m), tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.want, lastOutput(m))
+		}
+	}
+
+	if _, cmd := m.handleTransferBuiltin("transfer view"); !m.transferView.IsVisible() || cmd == nil {
+		t.Error("expected transfer view to show the view and start refreshing")
+	}
+	m.handleTransferBuiltin("transfer view")
+	if m.transferView.IsVisible() {
+		t.Error("expected transfer view to hide the view again")
+	}
+}
+
+func TestTransferSpecsParse(t *testing.T) {
+	for _, spec := range []string{putSpec, getSpec, transferSpec, browseSpec} {
+		if _, err := autocomplete.ParseSpec([]byte(spec)); err != nil {
+			t.Fatalf("spec does not parse: %v\n%s", err, spec)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/tunnels"
+)
+
+// tunnelSpec describes the tunnel builtin for completion.
+const tunnelSpec = `
+name: tunnel
+description: Manage SSH port forwards
+subcommands:
+  - name: list
+    description: List tunnels and their traffic
+  - name: view
+    description: Show or hide the tunnel view
+  - name: add
+    description: Forward a port over a connection
+    options:
+      - names: [-L]
+        description: Local forward
+        arg: {name: "[bind:]port:host:hostport"}
+      - names: [-R]
+        description: Remote forward
+        arg: {name: "[bind:]port:host:hostport"}
+      - names: [-D]
+        description: SOCKS5 proxy
+        arg: {name: "[bind:]port"}
+    args:
+      - name: connection
+        generator: connections
+  - name: stop
+    description: Stop a tunnel
+`
+
+// handleTunnelBuiltin runs the tunnel builtin, which manages port forwards
+// over the connections of remote panes:
+//
+//	tunnel [list]                    list tunnels and their traffic
+//	tunnel view                      show or hide the tunnel view
+//	tunnel add NAME -L|-R|-D SPEC    forward a port over connection NAME,
+//	                                 with ssh's syntax
+//	tunnel stop ID                   stop a tunnel
+//
+// Returns whether the command was handled and any command to run.
+func (m *Model) handleTunnelBuiltin(command string) (bool, tea.Cmd) {
+	parts := strings.Fields(command)
+	if len(parts) == 0 || parts[0] != "tunnel" {
+		return false, nil
+	}
+	args := parts[1:]
+
+	sub := "list"
+	if len(args) > 0 {
+		sub = args[0]
+	}
+	switch sub {
+	case "list", "ls":
+		m.listTunnels()
+		return true, nil
+
+	case "view":
+		m.tunnelView.Toggle()
+		if !m.tunnelView.IsVisible() {
+			return true, m.relayout()
+		}
+		return true, tea.Batch(m.relayout(), tunnels.Tick())
+
+	case "add":
+		if len(args) != 4 {
+			m.addOutput("usage: tunnel add NAME -L|-R|-D SPEC", false, 1)
+			return true, nil
+		}
+		m.addTunnel(args[1], args[2], args[3])
+		return true, nil
+
+	case "stop":
+		id, err := strconv.Atoi(strings.TrimPrefix(strings.Join(args[1:], ""), "#"))
+		if len(args) != 2 || err != nil {
+			m.addOutput("usage: tunnel stop ID", false, 1)
+			return true, nil
+		}
+		if err := m.sshManager.StopTunnel(id); err != nil {
+			m.addOutput("tunnel: "+err.Error(), false, 1)
+			return true, nil
+		}
+		m.addOutput(fmt.Sprintf("Stopped tunnel #%d", id), false, 0)
+		return true, nil
+	}
+
+	m.addOutput("usage: tunnel [list] | tunnel view | tunnel add NAME -L|-R|-D SPEC | tunnel stop ID", false, 1)
+	return true, nil
+}
+
+// addTunnel starts a forward given with ssh's syntax over the named
+// connection.
+func (m *Model) addTunnel(name, flag, forward string) {
+	spec, err := ssh.ParseForward(flag, forward)
+	if err != nil {
+		m.addOutput("tunnel: "+err.Error(), false, 1)
+		return
+	}
+	t, err := m.sshManager.StartTunnel(name, spec)
+	if err != nil {
+		m.addOutput("tunnel: "+err.Error(), false, 1)
+		return
+	}
+	m.addOutput(fmt.Sprintf("Started tunnel #%d: %s → %s", t.ID(), t.Addr(), tunnels.Target(spec)), false, 0)
+}
+
+// listTunnels prints the tunnels and their traffic.
+func (m *Model) listTunnels() {
+	all := m.sshManager.Tunnels()
+	if len(all) == 0 {
+		m.addOutput("No tunnels", false, 0)
+		return
+	}
+
+	for _, t := range all {
+		spec := t.Spec()
+		connection := t.Connection()
+		if connection == "" {
+			connection = "current"
+		}
+		listen := spec.Listen
+		if addr := t.Addr(); addr != "" {
+			listen = addr
+		}
+		line := fmt.Sprintf("#%-3d %-12s %-8s %s → %s", t.ID(), connection, spec.Type, listen, tunnels.Target(spec))
+		if err := t.Err(); err != nil {
+			m.addOutput(line+"  failed: "+err.Error(), false, 1)
+			continue
+		}
+		m.addOutput(fmt.Sprintf("%s  sent %s, received %s, %d open", line,
+			tunnels.FormatBytes(t.BytesSent()), tunnels.FormatBytes(t.BytesReceived()), t.ActiveConnections()), false, 0)
+	}
+}
+
+// relayout sizes the panes again, as when the tunnel view is shown or
+// hidden.
+func (m *Model) relayout() tea.Cmd {
+	if !m.ready {
+		return nil
+	}
+	width, height := m.width, m.height
+	return func() tea.Msg {
+		return tea.WindowSizeMsg{Width: width, Height: height}
+	}
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/ui/autocomplete"
+)
+
+func TestHandleTunnelBuiltin(t *testing.T) {
+	m := newRemoteTestModel()
+
+	if handled, _ := m.handleTunnelBuiltin("tunnels"); handled {
+		t.Error("only tunnel should be handled")
+	}
+
+	m.handleTunnelBuiltin("tunnel")
+	if lastOutput(m) != "No tunnels" {
+		t.Errorf("expected no tunnels, got %q", lastOutput(m))
+	}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"tunnel add web -X 8080:db:5432", "unknown forward flag"},
+		{"tunnel add web -L 8080", "invalid forward"},
+		{"tunnel add web -L 8080:db:5432", "connection not found: web"},
+		{"tunnel add web", "usage: tunnel add"},
+		{"tunnel stop 7", "tunnel not found: 7"},
+		{"tunnel stop x", "usage: tunnel stop"},
+		{"tunnel frobnicate", "usage: tunnel"},
+	}
+	for _, tt := range tests {
+		m.handleTunnelBuiltin(tt.command)
+		if !strings.Contains(lastOutput(m), tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.want, lastOutput(m))
+		}
+	}
+
+	if _, cmd := m.handleTunnelBuiltin("tunnel view"); !m.tunnelView.IsVisible() || cmd == nil {
+		t.Error("expected tunnel view to show the view and start refreshing")
+	}
+	m.handleTunnelBuiltin("tunnel view")
+	if m.tunnelView.IsVisible() {
+		t.Error("expected tunnel view to hide the view again")
+	}
+}
+
+func TestTunnelSpecParses(t *testing.T) {
+	if _, err := autocomplete.ParseSpec([]byte(tunnelSpec)); err != nil {
+		t.Fatalf("tunnel spec does not parse: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+)
+
+// unlockDialogID identifies the dialog asking for the secrets store's
+// master password.
+const unlockDialogID = "unlock"
+
+// unlockAttempts is how many times a wrong master password may be entered
+// before unlocking fails.
+const unlockAttempts = 3
+
+// newUnlockPrompt returns an unlock function that asks for the master
+// password of store through prompts, creating the store with it, once
+// entered twice, if there is none yet. If cache is not nil, a key another instance cached is
+// used instead of asking, and the key of a store unlocked is cached.
+func newUnlockPrompt(prompts chan<- promptMsg, store *secrets.Manager, cache *secrets.KeyCacheClient) ssh.UnlockFunc {
+	return func(ctx context.Context, reason string) error {
+		if store.IsUnlocked() {
+			return nil
+		}
+		if cache != nil {
+			if key, err := cache.Get(store.Path()); err == nil {
+				err = store.UnlockWithKey(key)
+				clear(key)
+				if err == nil {
+					return nil
+				}
+			}
+		}
+
+		var failure error
+		for attempt := 0; attempt < unlockAttempts; attempt++ {
+			create := !store.Initialized()
+			late := "The secrets store was not unlocked in time"
+			result, ok := ask(ctx, prompts, unlockDialog(reason, create, failure), late)
+			if !ok {
+				return ctx.Err()
+			}
+			if result.Cancelled {
+				return errors.New("unlock cancelled")
+			}
+
+			if create {
+				// A mistyped master password would lock the new store for good
+				confirmation, ok := ask(ctx, prompts, confirmPasswordDialog(reason), late)
+				if !ok {
+					return ctx.Err()
+				}
+				if confirmation.Cancelled {
+					return errors.New("unlock cancelled")
+				}
+				if confirmation.Input != result.Input {
+					failure = errors.New("the passwords do not match")
+					continue
+				}
+				failure = store.Initialize(result.Input)
+			} else {
+				failure = store.Unlock(result.Input)
+			}
+			if failure == nil {
+				cacheKey(cache, store)
+				return nil
+			}
+		}
+		return failure
+	}
+}
+
+// unlockDialog asks for the master password of the secrets store, which
+// reason needs. failure is the error of the previous attempt, if any.
+func unlockDialog(reason string, create bool, failure error) dialog.Spec {
+	spec := dialog.Spec{
+		ID:          unlockDialogID,
+		Title:       "Unlock secrets",
+		Body:        fmt.Sprintf("The secrets store holds %s.", reason),
+		Input:       true,
+		Placeholder: "master password",
+		Mask:        true,
+	}
+	if create {
+		spec.Title = "Create secrets store"
+		spec.Body = fmt.Sprintf("There is no secrets store for %s yet. Choose a master password to create it.", reason)
+	}
+	if failure != nil {
+		spec.Body += fmt.Sprintf("\n\n%v, try again.", failure)
+	}
+	return spec
+}
+
+// confirmPasswordDialog asks for the master password of a new secrets
+// store again, which reason needs.
+func confirmPasswordDialog(reason string) dialog.Spec {
+	return dialog.Spec{
+		ID:          unlockDialogID,
+		Title:       "Create secrets store",
+		Body:        fmt.Sprintf("Enter the master password of the store for %s again.", reason),
+		Input:       true,
+		Placeholder: "master password",
+		Mask:        true,
+	}
+}
+
+// cacheKey caches the key of the unlocked store for other instances. The
+// cache only spares them the password, so failing to reach it is not an
+// error.
+func cacheKey(cache *secrets.KeyCacheClient, store *secrets.Manager) {
+	if cache == nil {
+		return
+	}
+	key, err := store.Key()
+	if err != nil {
+		return
+	}
+	_ = cache.Put(store.Path(), key)
+	clear(key)
+}
+
+// forgetKey drops the key of store from cache, so that other instances
+// ask for the master password again. As with cacheKey, failing to reach
+// the cache is not an error: there is no key to drop then.
+func forgetKey(cache *secrets.KeyCacheClient, store *secrets.Manager) {
+	if cache == nil {
+		return
+	}
+	_ = cache.Forget(store.Path())
+}
+
+// forgetOnLock returns onLock extended to drop the key of store from
+// cache first, so that the store locking itself when idle is locked for
+// other instances too.
+func forgetOnLock(cache *secrets.KeyCacheClient, store *secrets.Manager, onLock func()) func() {
+	return func() {
+		forgetKey(cache, store)
+		onLock()
+	}
+}
+
+// lockSecrets locks the secrets store and drops its cached key.
+func (m *Model) lockSecrets() tea.Cmd {
+	store, cache := m.secretsManager, m.keyCache
+	return func() tea.Msg {
+		if err := store.Lock(); err != nil {
+			return secretsTaskMsg{err: err}
+		}
+		forgetKey(cache, store)
+		return secretsTaskMsg{lines: []string{"Locked the secrets store"}}
+	}
+}
+
+// startKeyCache returns a function starting a secrets agent caching keys
+// on socket: cbwsh itself, detached from the terminal so that it outlives
+// this instance.
+func startKeyCache(socket string) func() error {
+	return func() error {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(executable, "-secrets-agent", socket)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		go func() { _ = cmd.Wait() }()
+		return nil
+	}
+}
+
+// secretsLockedMsg reports that the secrets store locked itself after
+// being idle.
+type secretsLockedMsg struct{}
+
+// notifyLocked returns a function reporting on locked that the store
+// locked itself, for the UI to tell the user.
+func notifyLocked(locked chan<- struct{}) func() {
+	return func() {
+		select {
+		case locked <- struct{}{}:
+		default:
+			// The UI has yet to hear of the previous lock
+		}
+	}
+}
+
+// waitSecretsLocked waits for the secrets store to lock itself.
+func waitSecretsLocked(locked <-chan struct{}) tea.Cmd {
+	if locked == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		<-locked
+		return secretsLockedMsg{}
+	}
+}
+
+// secretsLocked tells the user the secrets store locked itself; it is
+// unlocked again when next needed.
+func (m *Model) secretsLocked() tea.Cmd {
+	idle := time.Duration(m.config.Secrets.AutoLock) * time.Second
+	m.notifications.ShowInfo("Secrets locked", fmt.Sprintf("Unused for %s", idle))
+	return tea.Batch(notificationTick(), waitSecretsLocked(m.lockEvents))
+}
+
+// apiKeySource returns a lookup of the API key in the secret name of
+// store, unlocked with unlock first.
+func apiKeySource(store *secrets.Manager, unlock ssh.UnlockFunc, name string) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		if err := unlock(ctx, "the AI API key"); err != nil {
+			return "", err
+		}
+		key, err := store.For("ai").Retrieve(name)
+		if err != nil {
+			return "", err
+		}
+		return string(key), nil
+	}
+}
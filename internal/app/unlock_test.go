@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+	"github.com/cbwinslow/cbwsh/pkg/ui/dialog"
+)
+
+func TestUnlockPrompt(t *testing.T) {
+	m := newRemoteTestModel()
+	store := secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	prompts := make(chan promptMsg)
+	unlock := newUnlockPrompt(prompts, store, nil)
+
+	errs := make(chan error, 1)
+	go func() { errs <- unlock(context.Background(), "the passphrase of web") }()
+
+	// Without a store, the password creates one
+	m.queuePrompt(<-prompts)
+	if m.dialog.ID() != unlockDialogID || !strings.Contains(m.dialog.View(), "Create secrets store") {
+		t.Fatalf("expected the dialog creating the store, got %q", m.dialog.View())
+	}
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: unlockDialogID, Input: "s3cret"})
+
+	// The password is entered twice, and asked for again if they differ
+	m.queuePrompt(<-prompts)
+	if view := m.dialog.View(); !strings.Contains(view, "again") {
+		t.Fatalf("expected the password to be confirmed, got %q", view)
+	}
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: unlockDialogID, Input: "s3crte"})
+	m.queuePrompt(<-prompts)
+	if view := m.dialog.View(); !strings.Contains(view, "do not match") || store.Initialized() {
+		t.Fatalf("expected mismatched passwords not to create the store, got %q", view)
+	}
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: unlockDialogID, Input: "s3cret"})
+	m.queuePrompt(<-prompts)
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: unlockDialogID, Input: "s3cret"})
+	if err := <-errs; err != nil || !store.IsUnlocked() {
+		t.Fatalf("expected the store to be created and unlocked, got %v", err)
+	}
+
+	// A wrong password is asked for again
+	if err := store.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	go func() { errs <- unlock(context.Background(), "the passphrase of web") }()
+	m.queuePrompt(<-prompts)
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: unlockDialogID, Input: "wrong"})
+	m.queuePrompt(<-prompts)
+	if view := m.dialog.View(); !strings.Contains(view, "invalid master password") {
+		t.Errorf("expected the failure in the dialog, got %q", view)
+	}
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: unlockDialogID, Input: "s3cret"})
+	if err := <-errs; err != nil || !store.IsUnlocked() {
+		t.Fatalf("expected the store to be unlocked, got %v", err)
+	}
+
+	// Cancelling gives up
+	if err := store.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	go func() { errs <- unlock(context.Background(), "the passphrase of web") }()
+	m.queuePrompt(<-prompts)
+	m.dialog.Close()
+	m.promptAnswered(dialog.ResultMsg{ID: unlockDialogID, Cancelled: true})
+	if err := <-errs; err == nil || store.IsUnlocked() {
+		t.Errorf("expected a cancelled unlock to fail, got %v", err)
+	}
+}
+
+func TestUnlockPromptKeyCache(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := func() error {
+		go func() { _ = secrets.NewKeyCache(time.Minute).Serve(ctx, socket) }()
+		return nil
+	}
+	cache := secrets.NewKeyCacheClient(socket, time.Minute, start)
+
+	// Unlocking in one instance caches the key
+	storePath := filepath.Join(dir, "secrets.enc")
+	first := secrets.NewManager(storePath)
+	prompts := make(chan promptMsg)
+	errs := make(chan error, 1)
+	go func() { errs <- newUnlockPrompt(prompts, first, cache)(context.Background(), "a secret") }()
+	for range 2 {
+		prompt := <-prompts
+		prompt.reply <- dialog.ResultMsg{ID: unlockDialogID, Input: "s3cret"}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance is not asked
+	second := secrets.NewManager(storePath)
+	askCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	if err := newUnlockPrompt(nil, second, cache)(askCtx, "a secret"); err != nil || !second.IsUnlocked() {
+		t.Errorf("expected the cached key to unlock the store, got %v", err)
+	}
+
+	// Locking the store, by itself or with secrets lock, drops the key
+	locked := make(chan struct{}, 1)
+	first.SetAutoLock(time.Millisecond, forgetOnLock(cache, first, notifyLocked(locked)))
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the store to lock itself")
+	}
+	if _, err := cache.Get(storePath); err == nil {
+		t.Error("expected the store locking itself to drop the cached key")
+	}
+	cacheKey(cache, second)
+	m := newRemoteTestModel()
+	m.secretsManager, m.keyCache = second, cache
+	handled, cmd := m.handleSecretsStoreBuiltin("secrets lock")
+	if !handled || cmd == nil {
+		t.Fatal("expected secrets lock to run in the background")
+	}
+	m.secretsTaskDone(cmd().(secretsTaskMsg))
+	if _, err := cache.Get(storePath); err == nil || second.IsUnlocked() {
+		t.Errorf("expected secrets lock to lock the store and drop the key, got %v", err)
+	}
+	if got := lastOutput(m); got != "Locked the secrets store" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestNotifyLocked(t *testing.T) {
+	locked := make(chan struct{}, 1)
+	notify := notifyLocked(locked)
+	// A lock the UI has yet to hear of does not block the next
+	notify()
+	notify()
+	if msg := waitSecretsLocked(locked)(); msg != (secretsLockedMsg{}) {
+		t.Errorf("expected secretsLockedMsg, got %T", msg)
+	}
+}
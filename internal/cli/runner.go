@@ -0,0 +1,233 @@
+// Package cli runs cbwsh without the TUI.
+//
+// It backs the non-interactive entry points of the cbwsh binary:
+//
+//	cbwsh -c "command" [args...]
+//	cbwsh script.cbw [args...]
+//	some-command | cbwsh
+//
+// Commands run through shell.Executor with the aliases and environment
+// from the user's configuration, pass through any registered plugin hooks,
+// and report the shell's exit status so cbwsh can be used from scripts,
+// cron and CI.
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/plugins"
+	"github.com/cbwinslow/cbwsh/pkg/shell"
+)
+
+// Exit statuses reported for failures that happen before the shell runs,
+// matching the conventions of bash.
+const (
+	// ExitFailure is reported when a plugin hook rejects the command.
+	ExitFailure = 1
+	// ExitUsage is reported for invalid command-line usage.
+	ExitUsage = 2
+	// ExitCannotExecute is reported when a script cannot be read.
+	ExitCannotExecute = 126
+	// ExitNotFound is reported when a script or the shell does not exist.
+	ExitNotFound = 127
+)
+
+// SecretStore resolves secret values by key.
+type SecretStore interface {
+	Retrieve(key string) ([]byte, error)
+}
+
+// Runner executes commands and scripts non-interactively.
+type Runner struct {
+	executor  *shell.Executor
+	shellType core.ShellType
+	aliases   map[string]string
+	plugins   *plugins.Manager
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+}
+
+// NewRunner creates a runner using the shell, aliases and environment from
+// cfg. Output is written to stdout and stderr as it is produced.
+func NewRunner(cfg *config.Config, stdout, stderr io.Writer) (*Runner, error) {
+	executor := shell.NewExecutor(cfg.Shell.DefaultShell)
+	if err := executor.SetEnvironment(cfg.Shell.Environment); err != nil {
+		return nil, fmt.Errorf("shell.environment: %w", err)
+	}
+
+	aliases := make(map[string]string, len(cfg.Shell.Aliases))
+	for name, command := range cfg.Shell.Aliases {
+		aliases[name] = command
+	}
+
+	return &Runner{
+		executor:  executor,
+		shellType: cfg.Shell.DefaultShell,
+		aliases:   aliases,
+		plugins:   plugins.NewManager(),
+		stdout:    stdout,
+		stderr:    stderr,
+	}, nil
+}
+
+// SetPluginManager sets the plugin manager whose hooks wrap each run.
+func (r *Runner) SetPluginManager(manager *plugins.Manager) {
+	r.plugins = manager
+}
+
+// SetStdin sets the input passed to the command. By default commands read
+// from nothing.
+func (r *Runner) SetStdin(stdin io.Reader) {
+	r.stdin = stdin
+}
+
+// SetWorkingDirectory sets the directory commands run in.
+func (r *Runner) SetWorkingDirectory(path string) error {
+	return r.executor.SetWorkingDirectory(path)
+}
+
+// InjectSecrets decrypts the secrets named in mapping and exports them into
+// the environment of the commands this runner executes. The mapping is
+// keyed by environment variable name with the secret key as value.
+func (r *Runner) InjectSecrets(store SecretStore, mapping map[string]string) error {
+	if len(mapping) == 0 {
+		return nil
+	}
+
+	env := make(map[string]string, len(mapping))
+	for name, key := range mapping {
+		value, err := store.Retrieve(key)
+		if err != nil {
+			return fmt.Errorf("failed to read secret %s for %s: %w", key, name, err)
+		}
+		env[name] = string(value)
+	}
+	return r.executor.SetEnvironment(env)
+}
+
+// Run executes script with args as its positional parameters and returns
+// the exit status.
+func (r *Runner) Run(ctx context.Context, script string, args []string) int {
+	script, err := r.plugins.RunPreExecuteHooks(script)
+	if err != nil {
+		r.errorf("%v", err)
+		return ExitFailure
+	}
+
+	var stdout, stderr strings.Builder
+	start := time.Now()
+	err = r.executor.ExecuteInteractive(ctx, r.prelude(args)+script, r.stdin,
+		io.MultiWriter(r.stdout, &stdout), io.MultiWriter(r.stderr, &stderr))
+
+	result := &core.CommandResult{
+		Command:  script,
+		Output:   stdout.String(),
+		Error:    stderr.String(),
+		ExitCode: exitStatus(err),
+		Duration: time.Since(start).Milliseconds(),
+	}
+	if result.ExitCode == ExitNotFound && !isExitError(err) {
+		r.errorf("%v", err)
+	}
+
+	if err := r.plugins.RunPostExecuteHooks(result); err != nil {
+		r.errorf("%v", err)
+	}
+
+	return result.ExitCode
+}
+
+// RunFile executes the script at path and returns the exit status.
+func (r *Runner) RunFile(ctx context.Context, path string, args []string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		r.errorf("%s: %v", path, errors.Unwrap(err))
+		if errors.Is(err, os.ErrNotExist) {
+			return ExitNotFound
+		}
+		return ExitCannotExecute
+	}
+	return r.Run(ctx, string(data), args)
+}
+
+// RunReader reads a whole script from in, such as piped stdin, and
+// executes it. The script's commands do not inherit in.
+func (r *Runner) RunReader(ctx context.Context, in io.Reader, args []string) int {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		r.errorf("failed to read script: %v", err)
+		return ExitCannotExecute
+	}
+	return r.Run(ctx, string(data), args)
+}
+
+// prelude returns the lines run ahead of every script: configured aliases
+// and the positional parameters.
+func (r *Runner) prelude(args []string) string {
+	var b strings.Builder
+
+	if len(r.aliases) > 0 {
+		// Bash only expands aliases in non-interactive shells when asked to
+		if r.shellType == core.ShellTypeBash {
+			b.WriteString("shopt -s expand_aliases\n")
+		}
+
+		names := make([]string, 0, len(r.aliases))
+		for name := range r.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "alias %s=%s\n", name, shell.Quote(r.aliases[name]))
+		}
+	}
+
+	if len(args) > 0 {
+		b.WriteString("set --")
+		for _, arg := range args {
+			b.WriteString(" " + shell.Quote(arg))
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func (r *Runner) errorf(format string, args ...interface{}) {
+	fmt.Fprintf(r.stderr, "cbwsh: "+format+"\n", args...)
+}
+
+// exitStatus converts the error from running the shell into a status code,
+// using 128+N for a shell killed by signal N as shells do.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ExitNotFound
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+func isExitError(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr)
+}
@@ -0,0 +1,189 @@
+package cli_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/internal/cli"
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/plugins"
+)
+
+func newTestRunner(t *testing.T, cfg *config.Config) (*cli.Runner, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	if _, err := os.Stat("/bin/bash"); err != nil {
+		t.Skip("bash not available")
+	}
+
+	if cfg == nil {
+		cfg = config.Default()
+	}
+	cfg.Shell.DefaultShell = core.ShellTypeBash
+
+	var stdout, stderr bytes.Buffer
+	runner, err := cli.NewRunner(cfg, &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runner, &stdout, &stderr
+}
+
+func TestRunExitStatusAndOutput(t *testing.T) {
+	runner, stdout, stderr := newTestRunner(t, nil)
+
+	code := runner.Run(context.Background(), "echo out; echo err >&2; exit 3", nil)
+	if code != 3 {
+		t.Errorf("expected exit status 3, got %d", code)
+	}
+	if stdout.String() != "out\n" {
+		t.Errorf("expected stdout 'out\\n', got %q", stdout.String())
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("expected stderr 'err\\n', got %q", stderr.String())
+	}
+}
+
+func TestRunAliasesAndArgs(t *testing.T) {
+	cfg := config.Default()
+	cfg.Shell.Aliases["greet"] = "echo hello"
+	runner, stdout, _ := newTestRunner(t, cfg)
+
+	code := runner.Run(context.Background(), "greet \"$1\" && greet \"$#\"", []string{"it's me", "two"})
+	if code != 0 {
+		t.Fatalf("expected exit status 0, got %d", code)
+	}
+	if stdout.String() != "hello it's me\nhello 2\n" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	runner, stdout, _ := newTestRunner(t, nil)
+	runner.SetStdin(strings.NewReader("piped\n"))
+
+	if code := runner.Run(context.Background(), "cat", nil); code != 0 {
+		t.Fatalf("expected exit status 0, got %d", code)
+	}
+	if stdout.String() != "piped\n" {
+		t.Errorf("expected command to read stdin, got %q", stdout.String())
+	}
+}
+
+func TestRunPluginHooks(t *testing.T) {
+	runner, stdout, _ := newTestRunner(t, nil)
+
+	var result *core.CommandResult
+	hook := plugins.NewHookPlugin("audit", "1.0.0")
+	hook.SetPreExecuteHook(func(command string) (string, error) {
+		if strings.Contains(command, "forbidden") {
+			return "", errors.New("command not allowed")
+		}
+		return strings.ReplaceAll(command, "draft", "final"), nil
+	})
+	hook.SetPostExecuteHook(func(r *core.CommandResult) error {
+		result = r
+		return nil
+	})
+
+	manager := plugins.NewManager()
+	if err := manager.Register(hook); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	runner.SetPluginManager(manager)
+
+	if code := runner.Run(context.Background(), "echo draft", nil); code != 0 {
+		t.Fatalf("expected exit status 0, got %d", code)
+	}
+	if stdout.String() != "final\n" {
+		t.Errorf("expected pre-execute hook to rewrite command, got %q", stdout.String())
+	}
+	if result == nil || result.Output != "final\n" || result.Command != "echo final" {
+		t.Errorf("expected post-execute hook to see the result, got %+v", result)
+	}
+
+	if code := runner.Run(context.Background(), "echo forbidden", nil); code != cli.ExitFailure {
+		t.Errorf("expected exit status %d for rejected command, got %d", cli.ExitFailure, code)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	runner, stdout, stderr := newTestRunner(t, nil)
+
+	path := filepath.Join(t.TempDir(), "script.cbw")
+	script := "#!/usr/bin/env cbwsh\nname=$1\nif [ -n \"$name\" ]; then\n  echo \"hi $name\"\nfi\nexit 4\n"
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		t.Fatalf("write script failed: %v", err)
+	}
+
+	if code := runner.RunFile(context.Background(), path, []string{"there"}); code != 4 {
+		t.Errorf("expected exit status 4, got %d", code)
+	}
+	if stdout.String() != "hi there\n" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.cbw")
+	if code := runner.RunFile(context.Background(), missing, nil); code != cli.ExitNotFound {
+		t.Errorf("expected exit status %d for missing script, got %d", cli.ExitNotFound, code)
+	}
+	if !strings.Contains(stderr.String(), "missing.cbw") {
+		t.Errorf("expected error naming the script, got %q", stderr.String())
+	}
+}
+
+func TestRunReader(t *testing.T) {
+	runner, stdout, _ := newTestRunner(t, nil)
+	dir := t.TempDir()
+
+	code := runner.RunReader(context.Background(), strings.NewReader("cd "+dir+"\npwd\nfalse\n"), nil)
+	if code != 1 {
+		t.Errorf("expected exit status of last command, got %d", code)
+	}
+	if stdout.String() != dir+"\n" {
+		t.Errorf("expected state to carry across lines, got %q", stdout.String())
+	}
+}
+
+type fakeStore map[string]string
+
+func (s fakeStore) Retrieve(key string) ([]byte, error) {
+	value, ok := s[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return []byte(value), nil
+}
+
+func TestInjectSecrets(t *testing.T) {
+	runner, stdout, _ := newTestRunner(t, nil)
+	store := fakeStore{"github-token": "s3cret"}
+
+	if err := runner.InjectSecrets(store, map[string]string{"GITHUB_TOKEN": "github-token"}); err != nil {
+		t.Fatalf("inject secrets failed: %v", err)
+	}
+	if code := runner.Run(context.Background(), "echo \"$GITHUB_TOKEN\"", nil); code != 0 {
+		t.Fatalf("expected exit status 0, got %d", code)
+	}
+	if stdout.String() != "s3cret\n" {
+		t.Errorf("expected secret in environment, got %q", stdout.String())
+	}
+
+	if err := runner.InjectSecrets(store, map[string]string{"OTHER": "missing"}); err == nil {
+		t.Error("expected error for missing secret")
+	}
+	if err := runner.InjectSecrets(store, map[string]string{"GH TOKEN": "github-token"}); err == nil {
+		t.Error("expected error for an invalid variable name")
+	}
+
+	cfg := config.Default()
+	cfg.Shell.Environment["BAD NAME"] = "value"
+	if _, err := cli.NewRunner(cfg, stdout, stdout); err == nil {
+		t.Error("expected an invalid variable name in the config to be refused")
+	}
+}
@@ -7,9 +7,32 @@
 // Usage:
 //
 //	cbwsh [flags]
+//	cbwsh [flags] -c command [args...]
+//	cbwsh [flags] script [args...]
+//	command | cbwsh [flags]
 //
-// The shell starts in interactive mode by default. Use keyboard shortcuts
-// to access various features:
+// The shell starts in interactive mode by default. Given a command with -c,
+// a script file, or a script piped on stdin, cbwsh runs it without the TUI
+// and exits with the command's exit status.
+//
+// Flags:
+//
+//	-c command               Run command non-interactively
+//	-config path             Configuration file (default ~/.cbwsh/config.yaml)
+//	-migrate-secrets         Move the passphrases of saved SSH hosts into the
+//	                         secrets store and exit
+//	-secrets-agent socket    Cache the keys of unlocked secrets stores on
+//	                         socket for other instances, until none are left
+//
+// Executable hook scripts in plugins.directory wrap each non-interactive
+// run; see plugins.ScriptHook.
+//
+// Secrets listed under secrets.environment in the configuration are
+// exported to non-interactive commands; the store is unlocked with the
+// password in CBWSH_SECRETS_PASSWORD, or with the key cached by an
+// interactive instance if secrets.cache_ttl is set.
+//
+// In interactive mode, use keyboard shortcuts to access various features:
 //   - Ctrl+Q: Quit
 //   - Ctrl+?: Help
 //   - Ctrl+A: AI assist mode
@@ -19,23 +42,269 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/cbwinslow/cbwsh/internal/app"
+	"github.com/cbwinslow/cbwsh/internal/cli"
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/plugins"
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
 )
 
+// secretsPasswordEnv names the variable holding the secrets store password
+// for non-interactive runs.
+const secretsPasswordEnv = "CBWSH_SECRETS_PASSWORD"
+
+// keyCacheLinger is how long the secrets agent waits for a key once it
+// holds none.
+const keyCacheLinger = time.Minute
+
 // main is the entry point for the cbwsh application.
 // It initializes and runs the shell, handling any errors gracefully.
 func main() {
+	flags := flag.NewFlagSet("cbwsh", flag.ContinueOnError)
+	command := flags.String("c", "", "run `command` non-interactively")
+	configPath := flags.String("config", "", "configuration file `path`")
+	migrate := flags.Bool("migrate-secrets", false, "move saved SSH host passphrases into the secrets store")
+	agentSocket := flags.String("secrets-agent", "", "cache secrets store keys on `socket` for other instances")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(cli.ExitUsage)
+	}
+
+	if *migrate {
+		os.Exit(migrateSecrets(*configPath))
+	}
+	if *agentSocket != "" {
+		os.Exit(serveKeyCache(*agentSocket))
+	}
+
+	commandSet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "c" {
+			commandSet = true
+		}
+	})
+
+	// Run without the TUI when there is something to execute
+	if commandSet || flags.NArg() > 0 || !isTerminal(os.Stdin) {
+		os.Exit(runNonInteractive(*configPath, commandSet, *command, flags.Args()))
+	}
+
 	// Run the application and handle errors
 	if err := app.Run(); err != nil {
 		// Print error to stderr with a clear prefix
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		
+
 		// Exit with non-zero status code to indicate failure
 		os.Exit(1)
 	}
-	
+
 	// Successful execution - exit with status 0 (implicit)
 }
+
+// runNonInteractive runs a -c command, a script file or a script read from
+// stdin and returns the exit status.
+func runNonInteractive(configPath string, commandSet bool, command string, args []string) int {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitUsage
+	}
+
+	runner, err := cli.NewRunner(cfg, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitUsage
+	}
+	if err := injectSecrets(runner, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitFailure
+	}
+
+	// Interrupts from the terminal reach the foreground command directly;
+	// cbwsh only waits for it to exit. SIGTERM stops the command.
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	// Plugin hooks wrap the run as they do in the TUI
+	pluginManager := plugins.NewManager()
+	if err := pluginManager.Load(ctx, cfg.Plugins); err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitFailure
+	}
+	defer func() { _ = pluginManager.Shutdown(context.Background()) }()
+	runner.SetPluginManager(pluginManager)
+
+	switch {
+	case commandSet:
+		runner.SetStdin(os.Stdin)
+		return runner.Run(ctx, command, args)
+	case len(args) > 0:
+		runner.SetStdin(os.Stdin)
+		return runner.RunFile(ctx, args[0], args[1:])
+	default:
+		return runner.RunReader(ctx, os.Stdin, nil)
+	}
+}
+
+func loadConfig(path string) (*config.Config, error) {
+	if path == "" {
+		return config.LoadFromDefaultPath()
+	}
+	return config.Load(filepath.Clean(path))
+}
+
+func injectSecrets(runner *cli.Runner, cfg *config.Config) error {
+	if len(cfg.Secrets.Environment) == 0 {
+		return nil
+	}
+
+	store, err := openSecretsStore(cfg)
+	if err != nil {
+		return err
+	}
+	password, ok := os.LookupEnv(secretsPasswordEnv)
+	switch {
+	case ok:
+		if err := store.Unlock(password); err != nil {
+			return fmt.Errorf("failed to unlock secrets store: %w", err)
+		}
+	case cfg.Secrets.CacheTTL > 0:
+		cache := secrets.NewKeyCacheClient(cfg.Secrets.AgentSocket, 0, nil)
+		key, err := cache.Get(cfg.Secrets.StorePath)
+		if err != nil {
+			return fmt.Errorf("secrets.environment is configured but %s is not set and no key is cached: %w", secretsPasswordEnv, err)
+		}
+		err = store.UnlockWithKey(key)
+		clear(key)
+		if err != nil {
+			return fmt.Errorf("failed to unlock secrets store: %w", err)
+		}
+	default:
+		return fmt.Errorf("secrets.environment is configured but %s is not set", secretsPasswordEnv)
+	}
+	defer func() { _ = store.Lock() }()
+
+	return runner.InjectSecrets(store.For("secrets.environment"), cfg.Secrets.Environment)
+}
+
+// openSecretsStore returns the configured secrets store, created in the
+// configured format if need be, recording accesses in its audit log.
+func openSecretsStore(cfg *config.Config) (*secrets.Manager, error) {
+	store := secrets.NewManager(cfg.Secrets.StorePath)
+	kdf := secrets.KDFParams{
+		Algorithm: cfg.Secrets.KeyDerivation,
+		Time:      cfg.Secrets.KDFTime,
+		Memory:    cfg.Secrets.KDFMemory,
+		Threads:   cfg.Secrets.KDFThreads,
+	}
+	if err := store.SetStoreFormat(kdf, cfg.Secrets.EncryptionAlgorithm); err != nil {
+		return nil, err
+	}
+	if cfg.Secrets.AuditLog != "" {
+		auditLog, err := secrets.OpenAuditLog(cfg.Secrets.AuditLog)
+		if err != nil {
+			return nil, err
+		}
+		store.SetAuditLog(auditLog)
+	}
+	return store, nil
+}
+
+// migrateSecrets moves the plaintext passphrases of the saved SSH hosts
+// into the secrets store and replaces them in the configuration file with
+// references to their secrets.
+func migrateSecrets(configPath string) int {
+	if configPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+			return cli.ExitFailure
+		}
+		configPath = filepath.Join(home, ".cbwsh", "config.yaml")
+	}
+	configPath = filepath.Clean(configPath)
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitUsage
+	}
+
+	password, ok := os.LookupEnv(secretsPasswordEnv)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cbwsh: %s must hold the secrets store password\n", secretsPasswordEnv)
+		return cli.ExitUsage
+	}
+	store, err := openSecretsStore(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitFailure
+	}
+	unlock := store.Unlock
+	if !store.Initialized() {
+		unlock = store.Initialize
+	}
+	if err := unlock(password); err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: failed to unlock secrets store: %v\n", err)
+		return cli.ExitFailure
+	}
+	defer func() { _ = store.Lock() }()
+
+	hosts, moved, err := ssh.MigrateHostSecrets(cfg.SSH.SavedHosts, store.For("migrate-secrets"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitFailure
+	}
+	if moved == 0 {
+		fmt.Println("No passphrases to migrate")
+		return 0
+	}
+
+	names := make(map[string]string, moved)
+	for i, host := range hosts {
+		if cfg.SSH.SavedHosts[i].Passphrase != "" {
+			names[host.Name] = host.PassphraseSecret
+		}
+	}
+	if err := config.ReplaceHostPassphrases(configPath, names); err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitFailure
+	}
+	fmt.Printf("Moved %d passphrase(s) into the secrets store\n", moved)
+	return 0
+}
+
+// serveKeyCache runs the secrets agent on socket until it has held no key
+// for keyCacheLinger.
+func serveKeyCache(socket string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := secrets.NewKeyCache(keyCacheLinger).Serve(ctx, socket); err != nil {
+		fmt.Fprintf(os.Stderr, "cbwsh: %v\n", err)
+		return cli.ExitFailure
+	}
+	return 0
+}
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -5,30 +5,99 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"sort"
 	"strings"
 	"sync"
 
+	"github.com/cbwinslow/cbwsh/pkg/config"
 	"github.com/cbwinslow/cbwsh/pkg/core"
 )
 
 // Agent represents an AI agent that can assist with shell commands.
 type Agent struct {
-	mu       sync.RWMutex
-	name     string
-	provider core.AIProvider
-	apiKey   string
-	model    string
-	enabled  bool
+	mu          sync.RWMutex
+	name        string
+	provider    core.AIProvider
+	apiKey      string
+	model       string
+	baseURL     string
+	maxTokens   int
+	temperature float64
+	httpClient  *http.Client
+	enabled     bool
+	keySource   func(ctx context.Context) (string, error) // Looks the API key up when none is set
 }
 
 // NewAgent creates a new AI agent.
 func NewAgent(name string, provider core.AIProvider, apiKey, model string) *Agent {
 	return &Agent{
-		name:     name,
-		provider: provider,
-		apiKey:   apiKey,
-		model:    model,
-		enabled:  true,
+		name:        name,
+		provider:    provider,
+		apiKey:      apiKey,
+		model:       orDefault(model, defaultModel(provider)),
+		temperature: defaultTemperature,
+		httpClient:  &http.Client{},
+		enabled:     true,
+	}
+}
+
+// NewAgentFromConfig creates an agent for the configured provider, named
+// after it. An empty API key is read from the provider's usual environment
+// variable, unless it is to be read from a secret with SetAPIKeySource,
+// and Ollama falls back to the Ollama URL and model settings.
+func NewAgentFromConfig(cfg config.AIConfig) *Agent {
+	apiKey := cfg.APIKey
+	if apiKey == "" && cfg.APIKeySecret == "" {
+		if env := apiKeyEnv(cfg.Provider); env != "" {
+			apiKey = os.Getenv(env)
+		}
+	}
+
+	model, baseURL := cfg.Model, cfg.BaseURL
+	if cfg.Provider == core.AIProviderOllama {
+		model = orDefault(model, cfg.OllamaModel)
+		baseURL = orDefault(baseURL, cfg.OllamaURL)
+	}
+
+	agent := NewAgent(cfg.Provider.String(), cfg.Provider, apiKey, model)
+	agent.baseURL = baseURL
+	agent.maxTokens = cfg.MaxTokens
+	agent.temperature = cfg.Temperature
+	return agent
+}
+
+// defaultTemperature is used by agents not created from configuration.
+const defaultTemperature = 0.7
+
+// defaultModel returns the model used when none is configured.
+func defaultModel(provider core.AIProvider) string {
+	switch provider {
+	case core.AIProviderOpenAI:
+		return "gpt-4o-mini"
+	case core.AIProviderAnthropic:
+		return "claude-3-5-haiku-latest"
+	case core.AIProviderGemini:
+		return "gemini-1.5-flash"
+	case core.AIProviderOllama:
+		return "llama2"
+	default:
+		return ""
+	}
+}
+
+// apiKeyEnv returns the environment variable holding provider's API key.
+func apiKeyEnv(provider core.AIProvider) string {
+	switch provider {
+	case core.AIProviderOpenAI:
+		return "OPENAI_API_KEY"
+	case core.AIProviderAnthropic:
+		return "ANTHROPIC_API_KEY"
+	case core.AIProviderGemini:
+		return "GEMINI_API_KEY"
+	default:
+		return ""
 	}
 }
 
@@ -43,63 +112,63 @@ func (a *Agent) Provider() core.AIProvider {
 }
 
 // Query sends a query to the AI agent.
-func (a *Agent) Query(_ context.Context, prompt string) (string, error) {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
-
-	if !a.enabled {
-		return "", errors.New("agent is disabled")
+func (a *Agent) Query(ctx context.Context, prompt string) (string, error) {
+	client, req, err := a.chat(ctx, prompt)
+	if err != nil {
+		return "", err
 	}
-
-	// This is a placeholder - in a real implementation, you would call the actual AI API
-	switch a.provider {
-	case core.AIProviderOpenAI:
-		return a.mockOpenAIQuery(prompt)
-	case core.AIProviderAnthropic:
-		return a.mockAnthropicQuery(prompt)
-	case core.AIProviderGemini:
-		return a.mockGeminiQuery(prompt)
-	case core.AIProviderLocal:
-		return a.mockLocalQuery(prompt)
-	case core.AIProviderOllama:
-		return a.mockOllamaQuery(prompt)
-	default:
-		return "", errors.New("no AI provider configured")
+	reply, err := client.Chat(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", a.provider, err)
 	}
+	return strings.TrimSpace(reply.Content), nil
 }
 
-// StreamQuery sends a query and streams the response.
+// StreamQuery sends a query and streams the response as it is generated.
 func (a *Agent) StreamQuery(ctx context.Context, prompt string) (<-chan string, error) {
-	a.mu.RLock()
-	if !a.enabled {
-		a.mu.RUnlock()
-		return nil, errors.New("agent is disabled")
+	client, req, err := a.chat(ctx, prompt)
+	if err != nil {
+		return nil, err
 	}
-	a.mu.RUnlock()
-
-	ch := make(chan string, 10)
+	ch, err := client.StreamChat(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", a.provider, err)
+	}
+	return ch, nil
+}
 
-	go func() {
-		defer close(ch)
+// chat returns a client and request for prompt using the agent's current
+// settings.
+func (a *Agent) chat(ctx context.Context, prompt string) (ChatClient, *ChatRequest, error) {
+	a.mu.RLock()
+	enabled, apiKey, keySource := a.enabled, a.apiKey, a.keySource
+	a.mu.RUnlock()
 
-		response, err := a.Query(ctx, prompt)
+	if !enabled {
+		return nil, nil, errors.New("agent is disabled")
+	}
+	// The lookup may wait for the user, so the agent is not locked meanwhile
+	if apiKey == "" && keySource != nil {
+		key, err := keySource(ctx)
 		if err != nil {
-			ch <- fmt.Sprintf("Error: %v", err)
-			return
+			return nil, nil, fmt.Errorf("%s: failed to get API key: %w", a.provider, err)
 		}
+		apiKey = key
+	}
 
-		// Simulate streaming by sending word by word
-		words := strings.Fields(response)
-		for _, word := range words {
-			select {
-			case <-ctx.Done():
-				return
-			case ch <- word + " ":
-			}
-		}
-	}()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 
-	return ch, nil
+	client, err := NewChatClient(a.provider, a.baseURL, apiKey, a.httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, &ChatRequest{
+		Model:       a.model,
+		Messages:    []Message{{Role: RoleUser, Content: prompt}},
+		MaxTokens:   a.maxTokens,
+		Temperature: a.temperature,
+	}, nil
 }
 
 // SuggestCommand suggests a command based on natural language.
@@ -148,6 +217,15 @@ func (a *Agent) SetAPIKey(apiKey string) {
 	a.apiKey = apiKey
 }
 
+// SetAPIKeySource makes the agent look its API key up with source for
+// each request, unless an API key is set. source may block, for example
+// to have a secrets store unlocked, until ctx ends.
+func (a *Agent) SetAPIKeySource(source func(ctx context.Context) (string, error)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keySource = source
+}
+
 // SetModel sets the model.
 func (a *Agent) SetModel(model string) {
 	a.mu.Lock()
@@ -155,34 +233,26 @@ func (a *Agent) SetModel(model string) {
 	a.model = model
 }
 
-func (a *Agent) mockOpenAIQuery(prompt string) (string, error) {
-	// Mock response for demonstration
-	if strings.Contains(strings.ToLower(prompt), "suggest") {
-		return "echo 'This is a suggested command'", nil
-	}
-	if strings.Contains(strings.ToLower(prompt), "explain") {
-		return "This command outputs text to the terminal.", nil
-	}
-	if strings.Contains(strings.ToLower(prompt), "fix") {
-		return "Try checking your permissions or syntax.", nil
-	}
-	return "I can help with shell commands. Please ask me to suggest, explain, or fix commands.", nil
-}
-
-func (a *Agent) mockAnthropicQuery(prompt string) (string, error) {
-	return a.mockOpenAIQuery(prompt) // Same mock behavior
-}
-
-func (a *Agent) mockGeminiQuery(prompt string) (string, error) {
-	return a.mockOpenAIQuery(prompt) // Same mock behavior
+// SetBaseURL sets the API endpoint, replacing the provider's default.
+func (a *Agent) SetBaseURL(baseURL string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.baseURL = baseURL
 }
 
-func (a *Agent) mockLocalQuery(prompt string) (string, error) {
-	return a.mockOpenAIQuery(prompt) // Same mock behavior
+// SetMaxTokens sets the response length limit; zero uses the provider's
+// default.
+func (a *Agent) SetMaxTokens(maxTokens int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxTokens = maxTokens
 }
 
-func (a *Agent) mockOllamaQuery(prompt string) (string, error) {
-	return a.mockOpenAIQuery(prompt) // Same mock behavior
+// SetTemperature sets the sampling temperature.
+func (a *Agent) SetTemperature(temperature float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.temperature = temperature
 }
 
 // Manager manages multiple AI agents.
@@ -320,7 +390,19 @@ func (m *Manager) FixError(ctx context.Context, command, errMsg string) (string,
 type Tool struct {
 	Name        string
 	Description string
-	Handler     func(ctx context.Context, args map[string]string) (string, error)
+	// Parameters describe the arguments the model passes in args.
+	Parameters []ToolParameter
+	// Approval decides whether a call needs the user's consent; empty
+	// means ApprovalAuto.
+	Approval ApprovalPolicy
+	Handler  func(ctx context.Context, args map[string]string) (string, error)
+}
+
+// ToolParameter is a string argument of a tool.
+type ToolParameter struct {
+	Name        string
+	Description string
+	Required    bool
 }
 
 // ToolRegistry manages AI tools.
@@ -370,7 +452,7 @@ func (r *ToolRegistry) Get(name string) (*Tool, bool) {
 	return tool, exists
 }
 
-// List returns all registered tools.
+// List returns all registered tools, sorted by name.
 func (r *ToolRegistry) List() []*Tool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -379,9 +461,31 @@ func (r *ToolRegistry) List() []*Tool {
 	for _, tool := range r.tools {
 		result = append(result, tool)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
 	return result
 }
 
+// SetApproval changes the approval policy of a tool.
+func (r *ToolRegistry) SetApproval(name string, policy ApprovalPolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch policy {
+	case ApprovalAuto, ApprovalAsk, ApprovalDeny:
+	default:
+		return fmt.Errorf("unknown approval policy: %s", policy)
+	}
+
+	tool, exists := r.tools[name]
+	if !exists {
+		return fmt.Errorf("tool not found: %s", name)
+	}
+	tool.Approval = policy
+	return nil
+}
+
 // Execute executes a tool by name.
 func (r *ToolRegistry) Execute(ctx context.Context, name string, args map[string]string) (string, error) {
 	r.mu.RLock()
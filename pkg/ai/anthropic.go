@@ -0,0 +1,195 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// anthropicVersion is the Messages API version sent with each request.
+	anthropicVersion = "2023-06-01"
+	// anthropicMaxTokens is used when no limit is configured, since the
+	// API requires one.
+	anthropicMaxTokens = 1024
+)
+
+// anthropicClient speaks the Anthropic Messages API.
+type anthropicClient struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newAnthropicClient(baseURL, apiKey string, httpClient *http.Client) *anthropicClient {
+	return &anthropicClient{baseURL: baseURL, apiKey: apiKey, httpClient: httpClient}
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block: text, a tool_use request or a
+// tool_result answer.
+type anthropicBlock struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Input     any    `json:"input,omitempty"`
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Chat sends a Messages API request.
+func (c *anthropicClient) Chat(ctx context.Context, req *ChatRequest) (*Message, error) {
+	resp, err := postJSON(ctx, c.httpClient, c.baseURL+"/v1/messages", c.headers(), c.request(req, false))
+	if err != nil {
+		return nil, err
+	}
+
+	var out anthropicResponse
+	if err := decodeJSON(resp, &out); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{Role: RoleAssistant}
+	var text strings.Builder
+	for _, block := range out.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			input, _ := block.Input.(map[string]any)
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: block.ID, Name: block.Name, Args: toolArgs(input)})
+		}
+	}
+	msg.Content = text.String()
+	return msg, nil
+}
+
+// StreamChat streams a Messages API response.
+func (c *anthropicClient) StreamChat(ctx context.Context, req *ChatRequest) (<-chan string, error) {
+	resp, err := postJSON(ctx, c.httpClient, c.baseURL+"/v1/messages", c.headers(), c.request(req, true))
+	if err != nil {
+		return nil, err
+	}
+
+	return streamEvents(ctx, resp, func(_, data string) (string, bool, error) {
+		var event anthropicEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return "", false, err
+		}
+		switch event.Type {
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				return event.Delta.Text, false, nil
+			}
+		case "message_stop":
+			return "", true, nil
+		case "error":
+			return "", false, errors.New(event.Error.Message)
+		}
+		return "", false, nil
+	}), nil
+}
+
+// request converts req for the Messages API, which takes system messages
+// as a separate prompt and tool results as user content blocks.
+func (c *anthropicClient) request(req *ChatRequest, stream bool) *anthropicRequest {
+	out := &anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stream:      stream,
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = anthropicMaxTokens
+	}
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: toolSchema(tool),
+		})
+	}
+
+	var system []string
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case RoleSystem:
+			system = append(system, msg.Content)
+
+		case RoleTool:
+			block := anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content}
+			// Results of one turn's calls share a single user message
+			if n := len(out.Messages); n > 0 && isToolResults(out.Messages[n-1]) {
+				out.Messages[n-1].Content = append(out.Messages[n-1].Content, block)
+				continue
+			}
+			out.Messages = append(out.Messages, anthropicMessage{Role: RoleUser, Content: []anthropicBlock{block}})
+
+		default:
+			m := anthropicMessage{Role: msg.Role}
+			if msg.Content != "" {
+				m.Content = append(m.Content, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := make(map[string]any, len(call.Args))
+				for name, value := range call.Args {
+					input[name] = value
+				}
+				m.Content = append(m.Content, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: input})
+			}
+			out.Messages = append(out.Messages, m)
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+	return out
+}
+
+func isToolResults(msg anthropicMessage) bool {
+	return msg.Role == RoleUser && len(msg.Content) > 0 && msg.Content[0].Type == "tool_result"
+}
+
+func (c *anthropicClient) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         c.apiKey,
+		"anthropic-version": anthropicVersion,
+	}
+}
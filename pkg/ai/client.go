@@ -0,0 +1,270 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// Message roles.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	// RoleTool carries the result of a tool call back to the model.
+	RoleTool = "tool"
+)
+
+// Default API endpoints. Each can be replaced with a base URL, for example
+// to use a local OpenAI-compatible server.
+const (
+	DefaultOpenAIURL    = "https://api.openai.com/v1"
+	DefaultAnthropicURL = "https://api.anthropic.com"
+	DefaultGeminiURL    = "https://generativelanguage.googleapis.com/v1beta"
+	DefaultLocalURL     = "http://localhost:8080/v1"
+	DefaultOllamaURL    = "http://localhost:11434"
+)
+
+// Message is a message in a chat conversation.
+type Message struct {
+	Role    string
+	Content string
+	// ToolCalls are the tools an assistant message asks to run.
+	ToolCalls []ToolCall
+	// ToolCallID and ToolName identify the call a RoleTool message
+	// answers.
+	ToolCallID string
+	ToolName   string
+}
+
+// ToolCall is a model's request to run a tool.
+type ToolCall struct {
+	ID   string
+	Name string
+	Args map[string]string
+}
+
+// ChatRequest is a chat-completion request.
+type ChatRequest struct {
+	// Model is the model name; servers that host one model may ignore it.
+	Model string
+	// Messages is the conversation so far.
+	Messages []Message
+	// MaxTokens limits the length of the response; zero uses the
+	// provider's default.
+	MaxTokens int
+	// Temperature controls response randomness.
+	Temperature float64
+	// Tools are the tools the model may call.
+	Tools []*Tool
+}
+
+// ChatClient is a chat-completion API.
+type ChatClient interface {
+	// Chat returns the model's reply to the conversation, which may ask
+	// for tool calls instead of answering.
+	Chat(ctx context.Context, req *ChatRequest) (*Message, error)
+	// StreamChat streams the reply as it is generated. The channel is
+	// closed when the reply is complete; a failure mid-stream is sent as a
+	// final "Error: ..." chunk.
+	StreamChat(ctx context.Context, req *ChatRequest) (<-chan string, error)
+}
+
+// NewChatClient returns a client for provider's API at baseURL, or at the
+// provider's default endpoint if baseURL is empty. Local models and Ollama
+// are reached through their OpenAI-compatible endpoints.
+func NewChatClient(provider core.AIProvider, baseURL, apiKey string, httpClient *http.Client) (ChatClient, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	switch provider {
+	case core.AIProviderOpenAI:
+		return newOpenAIClient(orDefault(baseURL, DefaultOpenAIURL), apiKey, httpClient), nil
+	case core.AIProviderAnthropic:
+		return newAnthropicClient(orDefault(baseURL, DefaultAnthropicURL), apiKey, httpClient), nil
+	case core.AIProviderGemini:
+		return newGeminiClient(orDefault(baseURL, DefaultGeminiURL), apiKey, httpClient), nil
+	case core.AIProviderLocal:
+		return newOpenAIClient(orDefault(baseURL, DefaultLocalURL), apiKey, httpClient), nil
+	case core.AIProviderOllama:
+		return newOpenAIClient(orDefault(baseURL, DefaultOllamaURL)+"/v1", apiKey, httpClient), nil
+	default:
+		return nil, errors.New("no AI provider configured")
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// postJSON sends body as JSON to url and returns the response, or an error
+// carrying the API's message if the status is not 200.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
+
+// apiError describes a failed API response. OpenAI, Anthropic and Gemini
+// all report errors as {"error": {"message": ...}}.
+func apiError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var payload struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &payload) == nil && payload.Error.Message != "" {
+		message = payload.Error.Message
+	}
+	return fmt.Errorf("API returned status %d: %s", resp.StatusCode, message)
+}
+
+// decodeJSON decodes a complete JSON response.
+func decodeJSON(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// streamEvents reads server-sent events from resp and sends the text that
+// parse extracts from each one. parse returns done to end the stream.
+func streamEvents(ctx context.Context, resp *http.Response, parse func(event, data string) (text string, done bool, err error)) <-chan string {
+	ch := make(chan string, 10)
+
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		send := func(text string) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case ch <- text:
+				return true
+			}
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		var event string
+		var data []string
+		// dispatch handles the buffered event and reports whether to go on
+		dispatch := func() bool {
+			if len(data) == 0 {
+				event = ""
+				return true
+			}
+			text, done, err := parse(event, strings.Join(data, "\n"))
+			event, data = "", data[:0]
+			if err != nil {
+				send(fmt.Sprintf("Error: %v", err))
+				return false
+			}
+			if text != "" && !send(text) {
+				return false
+			}
+			return !done
+		}
+
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				// A blank line ends the event
+				if !dispatch() {
+					return
+				}
+			case strings.HasPrefix(line, "event:"):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			if ctx.Err() == nil {
+				send(fmt.Sprintf("Error: %v", err))
+			}
+			return
+		}
+		dispatch()
+	}()
+
+	return ch
+}
+
+// toolSchema returns the JSON schema of tool's parameters, all of which
+// are strings.
+func toolSchema(tool *Tool) map[string]any {
+	properties := make(map[string]any, len(tool.Parameters))
+	required := make([]string, 0, len(tool.Parameters))
+	for _, param := range tool.Parameters {
+		properties[param.Name] = map[string]any{
+			"type":        "string",
+			"description": param.Description,
+		}
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// toolArgs converts decoded tool arguments to strings.
+func toolArgs(raw map[string]any) map[string]string {
+	args := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			args[name] = v
+		case nil:
+		default:
+			data, _ := json.Marshal(v)
+			args[name] = string(data)
+		}
+	}
+	return args
+}
@@ -0,0 +1,267 @@
+package ai_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/ai"
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// fakeAPI is an httptest stand-in for a provider API. It records the last
+// request and replies with reply, or with stream written as server-sent
+// events when the request asks to stream.
+type fakeAPI struct {
+	*httptest.Server
+	path    string
+	headers http.Header
+	body    map[string]any
+	reply   string
+	stream  []string
+}
+
+func newFakeAPI(t *testing.T, reply string, stream ...string) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{reply: reply, stream: stream}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.path = r.URL.Path
+		api.headers = r.Header.Clone()
+		data, _ := io.ReadAll(r.Body)
+		api.body = nil
+		_ = json.Unmarshal(data, &api.body)
+
+		if streaming, _ := api.body["stream"].(bool); streaming || r.URL.Query().Get("alt") == "sse" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, event := range api.stream {
+				fmt.Fprintf(w, "%s\n\n", event)
+				w.(http.Flusher).Flush()
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, api.reply)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func collect(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	var out strings.Builder
+	for chunk := range ch {
+		out.WriteString(chunk)
+	}
+	return out.String()
+}
+
+func TestOpenAIAgent(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t,
+		`{"choices":[{"message":{"role":"assistant","content":" ls -la\n"}}]}`,
+		`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+		`data: {"choices":[{"delta":{"content":"Hello"}}]}`,
+		`data: {"choices":[{"delta":{"content":", world"}}]}`,
+		`data: [DONE]`,
+	)
+
+	agent := ai.NewAgentFromConfig(config.AIConfig{
+		Provider:    core.AIProviderOpenAI,
+		APIKey:      "sk-test",
+		Model:       "gpt-test",
+		MaxTokens:   256,
+		Temperature: 0.2,
+		BaseURL:     api.URL + "/v1",
+	})
+
+	response, err := agent.Query(context.Background(), "list files")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if response != "ls -la" {
+		t.Errorf("expected 'ls -la', got %q", response)
+	}
+	if api.path != "/v1/chat/completions" {
+		t.Errorf("unexpected path %s", api.path)
+	}
+	if got := api.headers.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("unexpected Authorization header %q", got)
+	}
+	if api.body["model"] != "gpt-test" || api.body["max_tokens"] != 256.0 || api.body["temperature"] != 0.2 {
+		t.Errorf("request does not honor settings: %v", api.body)
+	}
+
+	ch, err := agent.StreamQuery(context.Background(), "greet")
+	if err != nil {
+		t.Fatalf("StreamQuery failed: %v", err)
+	}
+	if got := collect(t, ch); got != "Hello, world" {
+		t.Errorf("expected streamed 'Hello, world', got %q", got)
+	}
+}
+
+func TestAnthropicAgent(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t,
+		`{"content":[{"type":"text","text":"git status"}]}`,
+		"event: message_start\ndata: {\"type\":\"message_start\"}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\" there\"}}",
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}",
+	)
+
+	agent := ai.NewAgent("claude", core.AIProviderAnthropic, "key", "claude-test")
+	agent.SetBaseURL(api.URL)
+
+	response, err := agent.Query(context.Background(), "show changes")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if response != "git status" {
+		t.Errorf("expected 'git status', got %q", response)
+	}
+	if api.path != "/v1/messages" || api.headers.Get("x-api-key") != "key" || api.headers.Get("anthropic-version") == "" {
+		t.Errorf("unexpected request %s %v", api.path, api.headers)
+	}
+	if api.body["max_tokens"] == nil {
+		t.Error("expected max_tokens to always be sent")
+	}
+
+	ch, err := agent.StreamQuery(context.Background(), "greet")
+	if err != nil {
+		t.Fatalf("StreamQuery failed: %v", err)
+	}
+	if got := collect(t, ch); got != "Hi there" {
+		t.Errorf("expected streamed 'Hi there', got %q", got)
+	}
+}
+
+func TestGeminiAgent(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"df -h"}]}}]}`,
+		`data: {"candidates":[{"content":{"parts":[{"text":"Disk "}]}}]}`,
+		`data: {"candidates":[{"content":{"parts":[{"text":"usage"}]}}]}`,
+	)
+
+	agent := ai.NewAgent("gemini", core.AIProviderGemini, "key", "gemini-test")
+	agent.SetBaseURL(api.URL)
+	agent.SetMaxTokens(100)
+
+	response, err := agent.Query(context.Background(), "disk space")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if response != "df -h" {
+		t.Errorf("expected 'df -h', got %q", response)
+	}
+	if api.path != "/models/gemini-test:generateContent" || api.headers.Get("x-goog-api-key") != "key" {
+		t.Errorf("unexpected request %s %v", api.path, api.headers)
+	}
+	generation, _ := api.body["generationConfig"].(map[string]any)
+	if generation["maxOutputTokens"] != 100.0 {
+		t.Errorf("expected maxOutputTokens 100, got %v", api.body["generationConfig"])
+	}
+
+	ch, err := agent.StreamQuery(context.Background(), "explain")
+	if err != nil {
+		t.Fatalf("StreamQuery failed: %v", err)
+	}
+	if got := collect(t, ch); got != "Disk usage" {
+		t.Errorf("expected streamed 'Disk usage', got %q", got)
+	}
+	if api.path != "/models/gemini-test:streamGenerateContent" {
+		t.Errorf("unexpected stream path %s", api.path)
+	}
+}
+
+func TestOllamaAgentUsesOllamaSettings(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t, `{"choices":[{"message":{"content":"ok"}}]}`)
+
+	agent := ai.NewAgentFromConfig(config.AIConfig{
+		Provider:    core.AIProviderOllama,
+		OllamaURL:   api.URL,
+		OllamaModel: "phi3",
+	})
+
+	if _, err := agent.Query(context.Background(), "hi"); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if api.path != "/v1/chat/completions" || api.body["model"] != "phi3" {
+		t.Errorf("unexpected request %s %v", api.path, api.body)
+	}
+}
+
+func TestAgentAPIError(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"message":"invalid api key"}}`)
+	}))
+	defer server.Close()
+
+	agent := ai.NewAgent("openai", core.AIProviderOpenAI, "bad", "")
+	agent.SetBaseURL(server.URL)
+
+	_, err := agent.Query(context.Background(), "hi")
+	if err == nil || !strings.Contains(err.Error(), "invalid api key") {
+		t.Errorf("expected API error message, got %v", err)
+	}
+	if _, err := agent.StreamQuery(context.Background(), "hi"); err == nil {
+		t.Error("expected StreamQuery to fail")
+	}
+}
+
+func TestAgentAPIKeySource(t *testing.T) {
+	t.Parallel()
+
+	api := newFakeAPI(t, `{"content":[{"type":"text","text":"ok"}]}`)
+	agent := ai.NewAgent("claude", core.AIProviderAnthropic, "", "claude-test")
+	agent.SetBaseURL(api.URL)
+
+	lookups := 0
+	agent.SetAPIKeySource(func(context.Context) (string, error) {
+		lookups++
+		return "from-store", nil
+	})
+	if _, err := agent.Query(context.Background(), "hi"); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if api.headers.Get("x-api-key") != "from-store" || lookups != 1 {
+		t.Errorf("expected the looked up key, got %q after %d lookups", api.headers.Get("x-api-key"), lookups)
+	}
+
+	agent.SetAPIKeySource(func(context.Context) (string, error) {
+		return "", fmt.Errorf("unlock cancelled")
+	})
+	if _, err := agent.Query(context.Background(), "hi"); err == nil || !strings.Contains(err.Error(), "unlock cancelled") {
+		t.Errorf("expected the lookup to fail the query, got %v", err)
+	}
+
+	// A key that is set is used as it is
+	agent.SetAPIKey("set")
+	if _, err := agent.Query(context.Background(), "hi"); err != nil || api.headers.Get("x-api-key") != "set" {
+		t.Errorf("expected the set key, got %q, %v", api.headers.Get("x-api-key"), err)
+	}
+}
+
+func TestAgentWithoutProvider(t *testing.T) {
+	t.Parallel()
+
+	agent := ai.NewAgent("none", core.AIProviderNone, "", "")
+	if _, err := agent.Query(context.Background(), "hi"); err == nil {
+		t.Error("expected error without a provider")
+	}
+}
@@ -7,8 +7,14 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/history"
 )
 
+// recentFailureWindow is how far back Analyze looks for failed commands.
+const recentFailureWindow = 7 * 24 * time.Hour
+
 // ProjectType represents the type of project detected.
 type ProjectType string
 
@@ -58,6 +64,17 @@ type Context struct {
 	RecentCommands []string
 	// EnvVars are relevant environment variables.
 	EnvVars map[string]string
+	// ProjectHistory holds the latest commands run in this project, newest
+	// first. It is empty unless the analyzer has a history source.
+	ProjectHistory []history.Entry
+	// RecentFailures holds commands that failed in this project during the
+	// last week, newest first.
+	RecentFailures []history.Entry
+}
+
+// HistorySource provides queries over the command history database.
+type HistorySource interface {
+	Query(q history.Query) ([]history.Entry, error)
 }
 
 // Suggestion represents a context-aware command suggestion.
@@ -78,6 +95,7 @@ type Analyzer struct {
 	recentCommands []string
 	maxRecent      int
 	cache          map[string]*Context
+	history        HistorySource
 }
 
 // NewAnalyzer creates a new context analyzer.
@@ -89,14 +107,35 @@ func NewAnalyzer() *Analyzer {
 	}
 }
 
+// SetHistory sets the history database used to add project history to
+// analyzed contexts.
+func (a *Analyzer) SetHistory(source HistorySource) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.history = source
+}
+
+// QueryHistory runs a query against the history database. It returns no
+// entries when no history source is set.
+func (a *Analyzer) QueryHistory(q history.Query) ([]history.Entry, error) {
+	a.mu.RLock()
+	source := a.history
+	a.mu.RUnlock()
+
+	if source == nil {
+		return nil, nil
+	}
+	return source.Query(q)
+}
+
 // Analyze analyzes the current directory context.
 func (a *Analyzer) Analyze(cwd string) (*Context, error) {
 	a.mu.RLock()
-	if cached, ok := a.cache[cwd]; ok {
-		a.mu.RUnlock()
-		return cached, nil
-	}
+	cached, ok := a.cache[cwd]
 	a.mu.RUnlock()
+	if ok {
+		return a.withHistory(cached), nil
+	}
 
 	ctx := &Context{
 		CWD:            cwd,
@@ -118,7 +157,33 @@ func (a *Analyzer) Analyze(cwd string) (*Context, error) {
 	a.cache[cwd] = ctx
 	a.mu.Unlock()
 
-	return ctx, nil
+	return a.withHistory(ctx), nil
+}
+
+// withHistory returns a copy of ctx with the project's history filled in.
+// History is looked up on every call since it changes with each command.
+func (a *Analyzer) withHistory(ctx *Context) *Context {
+	a.mu.RLock()
+	hasHistory := a.history != nil
+	a.mu.RUnlock()
+	if !hasHistory {
+		return ctx
+	}
+
+	root := history.ProjectRoot(ctx.CWD)
+	if root == "" {
+		root = ctx.CWD
+	}
+
+	result := *ctx
+	result.ProjectHistory, _ = a.QueryHistory(history.Query{Dir: root, Limit: 20})
+	result.RecentFailures, _ = a.QueryHistory(history.Query{
+		Dir:        root,
+		FailedOnly: true,
+		Since:      time.Now().Add(-recentFailureWindow),
+		Limit:      10,
+	})
+	return &result
 }
 
 // AddRecentCommand adds a command to the recent commands list.
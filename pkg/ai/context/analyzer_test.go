@@ -4,6 +4,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/history"
 )
 
 func TestAnalyzer_DetectProjectType(t *testing.T) {
@@ -275,3 +278,55 @@ func TestGitInfo(t *testing.T) {
 		t.Errorf("Behind = %v, want %v", info.Behind, 1)
 	}
 }
+
+func TestAnalyzer_History(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(repo, "pkg")
+	if err := os.Mkdir(sub, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	store := history.NewStore(filepath.Join(t.TempDir(), "history"))
+	now := time.Now()
+	for _, entry := range []history.Entry{
+		{Command: "go test ./...", Dir: sub, ExitCode: 1, Timestamp: now.Add(-48 * time.Hour)},
+		{Command: "go vet", Dir: repo, ExitCode: 1, Timestamp: now.Add(-30 * 24 * time.Hour)},
+		{Command: "go build", Dir: repo, Timestamp: now},
+		{Command: "make", Dir: t.TempDir(), ExitCode: 2, Timestamp: now},
+	} {
+		if err := store.Append(entry); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+
+	analyzer := NewAnalyzer()
+	analyzer.SetHistory(store)
+
+	ctx, err := analyzer.Analyze(sub)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if len(ctx.ProjectHistory) != 3 || ctx.ProjectHistory[0].Command != "go build" {
+		t.Errorf("expected project history newest first, got %+v", ctx.ProjectHistory)
+	}
+	if len(ctx.RecentFailures) != 1 || ctx.RecentFailures[0].Command != "go test ./..." {
+		t.Errorf("expected last week's failure in the repo, got %+v", ctx.RecentFailures)
+	}
+
+	// History added after the first analysis is picked up despite caching
+	if err := store.Append(history.Entry{Command: "go test -run X", Dir: repo, ExitCode: 1}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	ctx, _ = analyzer.Analyze(sub)
+	if len(ctx.RecentFailures) != 2 {
+		t.Errorf("expected new failure to be included, got %+v", ctx.RecentFailures)
+	}
+
+	entries, err := analyzer.QueryHistory(history.Query{Text: "make"})
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected QueryHistory to search all history, got %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,185 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// geminiClient speaks the Gemini generateContent API.
+type geminiClient struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newGeminiClient(baseURL, apiKey string, httpClient *http.Client) *geminiClient {
+	return &geminiClient{baseURL: baseURL, apiKey: apiKey, httpClient: httpClient}
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent `json:"contents"`
+	Tools             []geminiTool    `json:"tools,omitempty"`
+	GenerationConfig  struct {
+		MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
+		Temperature     float64 `json:"temperature"`
+	} `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+}
+
+// message converts the first candidate to a message.
+func (r *geminiResponse) message() *Message {
+	msg := &Message{Role: RoleAssistant}
+	if len(r.Candidates) == 0 {
+		return msg
+	}
+
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+		if call := part.FunctionCall; call != nil {
+			// Gemini has no call IDs; results are matched by name
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: call.Name, Name: call.Name, Args: toolArgs(call.Args)})
+		}
+	}
+	msg.Content = text.String()
+	return msg
+}
+
+// Chat sends a generateContent request.
+func (c *geminiClient) Chat(ctx context.Context, req *ChatRequest) (*Message, error) {
+	resp, err := postJSON(ctx, c.httpClient, c.endpoint(req.Model, "generateContent"), c.headers(), c.request(req))
+	if err != nil {
+		return nil, err
+	}
+
+	var out geminiResponse
+	if err := decodeJSON(resp, &out); err != nil {
+		return nil, err
+	}
+	return out.message(), nil
+}
+
+// StreamChat streams a generateContent response.
+func (c *geminiClient) StreamChat(ctx context.Context, req *ChatRequest) (<-chan string, error) {
+	resp, err := postJSON(ctx, c.httpClient, c.endpoint(req.Model, "streamGenerateContent")+"?alt=sse", c.headers(), c.request(req))
+	if err != nil {
+		return nil, err
+	}
+
+	return streamEvents(ctx, resp, func(_, data string) (string, bool, error) {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", false, err
+		}
+		return chunk.message().Content, false, nil
+	}), nil
+}
+
+func (c *geminiClient) endpoint(model, method string) string {
+	return c.baseURL + "/models/" + url.PathEscape(model) + ":" + method
+}
+
+// request converts req; Gemini calls the assistant role "model", takes
+// system messages as a separate instruction and tool results as function
+// responses.
+func (c *geminiClient) request(req *ChatRequest) *geminiRequest {
+	out := &geminiRequest{}
+	out.GenerationConfig.MaxOutputTokens = req.MaxTokens
+	out.GenerationConfig.Temperature = req.Temperature
+
+	if len(req.Tools) > 0 {
+		var tool geminiTool
+		for _, t := range req.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, geminiFunctionDeclaration{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  toolSchema(t),
+			})
+		}
+		out.Tools = []geminiTool{tool}
+	}
+
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case RoleSystem:
+			if out.SystemInstruction == nil {
+				out.SystemInstruction = &geminiContent{}
+			}
+			out.SystemInstruction.Parts = append(out.SystemInstruction.Parts, geminiPart{Text: msg.Content})
+
+		case RoleTool:
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     msg.ToolName,
+				Response: map[string]any{"content": msg.Content},
+			}}
+			// Results of one turn's calls share a single content
+			if n := len(out.Contents); n > 0 && len(out.Contents[n-1].Parts) > 0 && out.Contents[n-1].Parts[0].FunctionResponse != nil {
+				out.Contents[n-1].Parts = append(out.Contents[n-1].Parts, part)
+				continue
+			}
+			out.Contents = append(out.Contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+
+		case RoleAssistant:
+			content := geminiContent{Role: "model"}
+			if msg.Content != "" {
+				content.Parts = append(content.Parts, geminiPart{Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				args := make(map[string]any, len(call.Args))
+				for name, value := range call.Args {
+					args[name] = value
+				}
+				content.Parts = append(content.Parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Name, Args: args}})
+			}
+			out.Contents = append(out.Contents, content)
+
+		default:
+			out.Contents = append(out.Contents, geminiContent{Role: "user", Parts: []geminiPart{{Text: msg.Content}}})
+		}
+	}
+	return out
+}
+
+func (c *geminiClient) headers() map[string]string {
+	return map[string]string{"x-goog-api-key": c.apiKey}
+}
@@ -0,0 +1,137 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maxToolRounds bounds how many times the model may call tools before it
+// must answer.
+const maxToolRounds = 10
+
+// toolSystemPrompt introduces the tools to the model.
+const toolSystemPrompt = "You are the assistant built into cbwsh, a terminal shell. " +
+	"Use the tools to look at the user's files, repository and system before answering. " +
+	"The tools only read; never ask to change anything. " +
+	"When you have enough information, answer concisely; put commands in code blocks."
+
+// StepKind is the kind of a step of an agent run.
+type StepKind int
+
+const (
+	// StepToolCall is the model asking to run a tool.
+	StepToolCall StepKind = iota
+	// StepToolResult is the outcome of a tool call.
+	StepToolResult
+	// StepAnswer is the model's final answer.
+	StepAnswer
+)
+
+// Step is a step of an agent run, reported as it happens.
+type Step struct {
+	Kind StepKind
+	// Call is the tool call of StepToolCall and StepToolResult steps.
+	Call ToolCall
+	// Text is the tool output, or the answer of a StepAnswer step.
+	Text string
+	// Err is set if the tool failed.
+	Err error
+	// Denied is set if the call was not approved.
+	Denied bool
+}
+
+// Approver asks the user whether a tool call may run. It should return
+// false if ctx is cancelled while waiting.
+type Approver func(ctx context.Context, call ToolCall) bool
+
+// RunWithTools answers prompt, letting the model call the tools in
+// registry until it produces an answer. Each step is passed to onStep as it
+// happens. Tools with ApprovalAsk run only if approve returns true; without
+// an approver they are denied. Cancelling ctx stops the run.
+func (a *Agent) RunWithTools(ctx context.Context, prompt string, registry *ToolRegistry, approve Approver, onStep func(Step)) (string, error) {
+	client, req, err := a.chat(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	req.Tools = registry.List()
+	req.Messages = append([]Message{{Role: RoleSystem, Content: toolSystemPrompt}}, req.Messages...)
+	if onStep == nil {
+		onStep = func(Step) {}
+	}
+
+	for round := 0; round < maxToolRounds; round++ {
+		reply, err := client.Chat(ctx, req)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", a.provider, err)
+		}
+		req.Messages = append(req.Messages, *reply)
+
+		if len(reply.ToolCalls) == 0 {
+			onStep(Step{Kind: StepAnswer, Text: reply.Content})
+			return reply.Content, nil
+		}
+
+		for _, call := range reply.ToolCalls {
+			onStep(Step{Kind: StepToolCall, Call: call})
+			step := runToolCall(ctx, registry, approve, call)
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			onStep(step)
+
+			result := step.Text
+			switch {
+			case step.Denied:
+				result = "The user did not allow this tool call."
+			case step.Err != nil:
+				result = "Error: " + step.Err.Error()
+			}
+			req.Messages = append(req.Messages, Message{
+				Role:       RoleTool,
+				Content:    result,
+				ToolCallID: call.ID,
+				ToolName:   call.Name,
+			})
+		}
+	}
+
+	return "", errors.New("the model did not answer within the tool call limit")
+}
+
+// runToolCall applies the tool's approval policy and runs it.
+func runToolCall(ctx context.Context, registry *ToolRegistry, approve Approver, call ToolCall) Step {
+	step := Step{Kind: StepToolResult, Call: call}
+
+	tool, ok := registry.Get(call.Name)
+	if !ok {
+		step.Err = fmt.Errorf("tool not found: %s", call.Name)
+		return step
+	}
+
+	switch tool.Approval {
+	case ApprovalAuto, "":
+	case ApprovalDeny:
+		step.Denied = true
+		return step
+	default:
+		// ApprovalAsk, and any policy this version does not know
+		if approve == nil || !approve(ctx, call) {
+			step.Denied = true
+			return step
+		}
+	}
+
+	step.Text, step.Err = tool.Handler(ctx, call.Args)
+	return step
+}
+
+// RunWithTools answers prompt with the active agent; see
+// Agent.RunWithTools.
+func (m *Manager) RunWithTools(ctx context.Context, prompt string, registry *ToolRegistry, approve Approver, onStep func(Step)) (string, error) {
+	agent := m.ActiveAgent()
+	if agent == nil {
+		return "", errors.New("no active AI agent")
+	}
+	return agent.RunWithTools(ctx, prompt, registry, approve, onStep)
+}
@@ -0,0 +1,329 @@
+package ai_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/ai"
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// scriptedAPI replies to successive chat completion requests with replies
+// in order and records each request's messages.
+type scriptedAPI struct {
+	*httptest.Server
+	mu       sync.Mutex
+	replies  []string
+	requests [][]map[string]any
+}
+
+func newScriptedAPI(t *testing.T, replies ...string) *scriptedAPI {
+	t.Helper()
+	api := &scriptedAPI{replies: replies}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body struct {
+			Messages []map[string]any `json:"messages"`
+		}
+		_ = json.Unmarshal(data, &body)
+
+		api.mu.Lock()
+		n := len(api.requests)
+		api.requests = append(api.requests, body.Messages)
+		api.mu.Unlock()
+
+		if n >= len(api.replies) {
+			http.Error(w, `{"error":{"message":"unexpected request"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, api.replies[n])
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func toolCallReply(id, name, args string) string {
+	quoted, _ := json.Marshal(args)
+	return `{"choices":[{"message":{"role":"assistant","content":"","tool_calls":[` +
+		`{"id":"` + id + `","type":"function","function":{"name":"` + name + `","arguments":` + string(quoted) + `}}]}}]}`
+}
+
+func newToolAgent(t *testing.T, api *scriptedAPI) *ai.Agent {
+	t.Helper()
+	return ai.NewAgentFromConfig(config.AIConfig{
+		Provider: core.AIProviderOpenAI,
+		APIKey:   "sk-test",
+		BaseURL:  api.URL,
+	})
+}
+
+func newShellTools(t *testing.T, dir string) *ai.ToolRegistry {
+	t.Helper()
+	registry := ai.NewToolRegistry()
+	for _, tool := range ai.ShellTools(func() string { return dir }) {
+		if err := registry.Register(tool); err != nil {
+			t.Fatalf("Register(%s) error: %v", tool.Name, err)
+		}
+	}
+	return registry
+}
+
+func TestRunWithTools(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("remember the milk\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	api := newScriptedAPI(t,
+		toolCallReply("call_1", "read_file", `{"path":"notes.txt"}`),
+		`{"choices":[{"message":{"role":"assistant","content":"It says to remember the milk."}}]}`,
+	)
+
+	var steps []ai.Step
+	answer, err := newToolAgent(t, api).RunWithTools(context.Background(), "What is in notes.txt?",
+		newShellTools(t, dir), nil, func(step ai.Step) { steps = append(steps, step) })
+	if err != nil {
+		t.Fatalf("RunWithTools() error: %v", err)
+	}
+	if answer != "It says to remember the milk." {
+		t.Errorf("answer = %q", answer)
+	}
+
+	kinds := []ai.StepKind{ai.StepToolCall, ai.StepToolResult, ai.StepAnswer}
+	if len(steps) != len(kinds) {
+		t.Fatalf("got %d steps, want %d", len(steps), len(kinds))
+	}
+	for i, kind := range kinds {
+		if steps[i].Kind != kind {
+			t.Errorf("step %d kind = %v, want %v", i, steps[i].Kind, kind)
+		}
+	}
+	if steps[0].Call.Name != "read_file" || steps[0].Call.Args["path"] != "notes.txt" {
+		t.Errorf("tool call = %+v", steps[0].Call)
+	}
+	if steps[1].Text != "remember the milk\n" {
+		t.Errorf("tool result = %q", steps[1].Text)
+	}
+
+	// The second request carries the call and its result
+	if len(api.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(api.requests))
+	}
+	last := api.requests[1][len(api.requests[1])-1]
+	if last["role"] != "tool" || last["tool_call_id"] != "call_1" || last["content"] != "remember the milk\n" {
+		t.Errorf("tool result message = %v", last)
+	}
+}
+
+func TestRunWithToolsApproval(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		approve bool
+		want    string
+	}{
+		{"approved", true, "hello\n"},
+		{"denied", false, "The user did not allow this tool call."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			api := newScriptedAPI(t,
+				toolCallReply("call_1", "run_command", `{"command":"echo hello"}`),
+				`{"choices":[{"message":{"role":"assistant","content":"done"}}]}`,
+			)
+
+			var asked []ai.ToolCall
+			approve := func(_ context.Context, call ai.ToolCall) bool {
+				asked = append(asked, call)
+				return tt.approve
+			}
+
+			_, err := newToolAgent(t, api).RunWithTools(context.Background(), "say hello",
+				newShellTools(t, t.TempDir()), approve, nil)
+			if err != nil {
+				t.Fatalf("RunWithTools() error: %v", err)
+			}
+			if len(asked) != 1 || asked[0].Args["command"] != "echo hello" {
+				t.Errorf("approval requests = %+v", asked)
+			}
+
+			last := api.requests[1][len(api.requests[1])-1]
+			if last["content"] != tt.want {
+				t.Errorf("tool result = %q, want %q", last["content"], tt.want)
+			}
+		})
+	}
+}
+
+func TestRunWithToolsDenyPolicy(t *testing.T) {
+	t.Parallel()
+
+	api := newScriptedAPI(t,
+		toolCallReply("call_1", "git_status", `{}`),
+		`{"choices":[{"message":{"role":"assistant","content":"done"}}]}`,
+	)
+	registry := newShellTools(t, t.TempDir())
+	if err := registry.SetApproval("git_status", ai.ApprovalDeny); err != nil {
+		t.Fatalf("SetApproval() error: %v", err)
+	}
+
+	var result ai.Step
+	_, err := newToolAgent(t, api).RunWithTools(context.Background(), "status?", registry,
+		func(context.Context, ai.ToolCall) bool {
+			t.Error("a denied tool should not ask for approval")
+			return true
+		},
+		func(step ai.Step) {
+			if step.Kind == ai.StepToolResult {
+				result = step
+			}
+		})
+	if err != nil {
+		t.Fatalf("RunWithTools() error: %v", err)
+	}
+	if !result.Denied {
+		t.Errorf("result = %+v, want denied", result)
+	}
+}
+
+func TestRunWithToolsCancel(t *testing.T) {
+	t.Parallel()
+
+	api := newScriptedAPI(t,
+		toolCallReply("call_1", "run_command", `{"command":"ls"}`),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	approve := func(ctx context.Context, _ ai.ToolCall) bool {
+		cancel()
+		<-ctx.Done()
+		return false
+	}
+
+	_, err := newToolAgent(t, api).RunWithTools(ctx, "list", newShellTools(t, t.TempDir()), approve, nil)
+	if err != context.Canceled {
+		t.Errorf("RunWithTools() error = %v, want context.Canceled", err)
+	}
+	if len(api.requests) != 1 {
+		t.Errorf("got %d requests after cancelling, want 1", len(api.requests))
+	}
+}
+
+func TestCheckReadOnly(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		command string
+		ok      bool
+	}{
+		{"ls -la", true},
+		{"grep -rn TODO . | head -20", true},
+		{"git log --oneline -5", true},
+		{"find . -name '*.go'", true},
+		{"ps -o pid,comm", true},
+		{"", false},
+		{"rm -rf /", false},
+		{"ls; rm -rf /", false},
+		{"cat foo > bar", false},
+		{"echo $(whoami)", false},
+		{"echo `whoami`", false},
+		{"ls && rm x", false},
+		{"ls | sh", false},
+		{"git push", false},
+		{"git diff --output=patch", false},
+		{"sort -o out.txt in.txt", false},
+		{"find . -delete", false},
+		{"find . -exec rm {} +", false},
+		{"sort -uo out.txt in.txt", false},
+		{"sort -o=out.txt in.txt", false},
+		{"find . -fprint out.txt", false},
+		{"find . -fprint0 out.txt", false},
+		{"find . -fls out.txt", false},
+		{"printenv", false},
+		{"printenv AWS_SECRET_ACCESS_KEY", false},
+		{"env", false},
+		{"ls | env", false},
+	}
+
+	for _, tt := range tests {
+		err := ai.CheckReadOnly(tt.command)
+		if (err == nil) != tt.ok {
+			t.Errorf("CheckReadOnly(%q) error = %v, want ok %v", tt.command, err, tt.ok)
+		}
+	}
+}
+
+func TestShellToolsFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registry := newShellTools(t, dir)
+	ctx := context.Background()
+
+	out, err := registry.Execute(ctx, "list_directory", nil)
+	if err != nil {
+		t.Fatalf("list_directory error: %v", err)
+	}
+	if out != "README\nsrc/\n" {
+		t.Errorf("list_directory = %q", out)
+	}
+
+	if _, err := registry.Execute(ctx, "read_file", map[string]string{"path": "src"}); err == nil {
+		t.Error("read_file of a directory should fail")
+	}
+	if _, err := registry.Execute(ctx, "read_file", map[string]string{"path": "missing"}); err == nil {
+		t.Error("read_file of a missing file should fail")
+	}
+
+	out, err = registry.Execute(ctx, "run_command", map[string]string{"command": "ls | wc -l"})
+	if err != nil {
+		t.Fatalf("run_command error: %v", err)
+	}
+	if strings.TrimSpace(out) != "2" {
+		t.Errorf("run_command = %q", out)
+	}
+
+	// Nothing outside the working directory is read without approval
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(outside, "secret"), "../" + filepath.Base(outside) + "/secret", "link/secret", "~/.ssh/id_ed25519", "/etc/passwd"} {
+		if _, err := registry.Execute(ctx, "read_file", map[string]string{"path": path}); err == nil {
+			t.Errorf("read_file(%s) outside the working directory should fail", path)
+		}
+	}
+	for _, path := range []string{outside, "..", "link", "~/", "/"} {
+		if _, err := registry.Execute(ctx, "list_directory", map[string]string{"path": path}); err == nil {
+			t.Errorf("list_directory(%s) outside the working directory should fail", path)
+		}
+	}
+	if out, err := registry.Execute(ctx, "read_file", map[string]string{"path": "src/../README"}); err != nil || out != "hi" {
+		t.Errorf("read_file inside the working directory = %q, %v", out, err)
+	}
+}
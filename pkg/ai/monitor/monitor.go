@@ -8,8 +8,10 @@ import (
 	"sync"
 	"time"
 
+	aicontext "github.com/cbwinslow/cbwsh/pkg/ai/context"
 	"github.com/cbwinslow/cbwsh/pkg/ai/ollama"
 	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/history"
 )
 
 // ActivityType represents the type of shell activity.
@@ -70,6 +72,9 @@ type Monitor struct {
 	// Ollama client
 	ollama *ollama.Client
 
+	// Context analyzer adding project history to prompts, if set
+	analyzer *aicontext.Analyzer
+
 	// Activity tracking
 	activities      []Activity
 	maxActivities   int
@@ -293,7 +298,42 @@ func (m *Monitor) GenerateRecommendation(ctx context.Context) error {
 		return nil
 	}
 
-	// Build context for AI
+	prompt := m.buildPrompt(activities)
+
+	// Query Ollama
+	response, err := m.ollama.Generate(ctx, prompt)
+	if err != nil {
+		return fmt.Errorf("failed to generate recommendation: %w", err)
+	}
+
+	// Create recommendation
+	rec := Recommendation{
+		Type:      determineRecommendationType(activities),
+		Title:     "AI Suggestion",
+		Message:   response,
+		Timestamp: time.Now(),
+	}
+
+	// Add the most recent activity as context
+	if len(activities) > 0 {
+		lastActivity := activities[len(activities)-1]
+		rec.Activity = &lastActivity
+	}
+
+	m.addRecommendation(rec)
+	return nil
+}
+
+// SetContextAnalyzer sets the analyzer whose project history and recent
+// failures are added to recommendation prompts.
+func (m *Monitor) SetContextAnalyzer(analyzer *aicontext.Analyzer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.analyzer = analyzer
+}
+
+// buildPrompt builds the recommendation prompt for recent activities.
+func (m *Monitor) buildPrompt(activities []Activity) string {
 	var contextBuilder strings.Builder
 	contextBuilder.WriteString("Recent shell activity:\n")
 	for i, act := range activities {
@@ -309,11 +349,21 @@ func (m *Monitor) GenerateRecommendation(ctx context.Context) error {
 	}
 
 	// Add current working directory context
-	if len(activities) > 0 {
-		contextBuilder.WriteString(fmt.Sprintf("\nWorking directory: %s\n", activities[len(activities)-1].WorkDir))
+	workDir := activities[len(activities)-1].WorkDir
+	contextBuilder.WriteString(fmt.Sprintf("\nWorking directory: %s\n", workDir))
+
+	// Add what has been run in this project before, from the history database
+	m.mu.RLock()
+	analyzer := m.analyzer
+	m.mu.RUnlock()
+	if analyzer != nil && workDir != "" {
+		if projectCtx, err := analyzer.Analyze(workDir); err == nil {
+			writeHistory(&contextBuilder, "Earlier commands in this project", projectCtx.ProjectHistory)
+			writeHistory(&contextBuilder, "Commands that failed in this project this week", projectCtx.RecentFailures)
+		}
 	}
 
-	prompt := fmt.Sprintf(`%s
+	return fmt.Sprintf(`%s
 Analyze the above shell activity and provide a brief helpful comment, tip, or suggestion. 
 Focus on:
 - Command improvements or alternatives
@@ -322,29 +372,21 @@ Focus on:
 - Workflow optimization
 
 Keep response under 100 words. Be concise and actionable.`, contextBuilder.String())
+}
 
-	// Query Ollama
-	response, err := m.ollama.Generate(ctx, prompt)
-	if err != nil {
-		return fmt.Errorf("failed to generate recommendation: %w", err)
-	}
-
-	// Create recommendation
-	rec := Recommendation{
-		Type:      determineRecommendationType(activities),
-		Title:     "AI Suggestion",
-		Message:   response,
-		Timestamp: time.Now(),
+// writeHistory adds a titled list of history entries to a prompt.
+func writeHistory(b *strings.Builder, title string, entries []history.Entry) {
+	if len(entries) == 0 {
+		return
 	}
-
-	// Add the most recent activity as context
-	if len(activities) > 0 {
-		lastActivity := activities[len(activities)-1]
-		rec.Activity = &lastActivity
+	b.WriteString("\n" + title + ":\n")
+	for _, e := range entries {
+		b.WriteString("- " + truncate(e.Command, 100))
+		if e.Failed() {
+			b.WriteString(fmt.Sprintf(" (exit code: %d)", e.ExitCode))
+		}
+		b.WriteString("\n")
 	}
-
-	m.addRecommendation(rec)
-	return nil
 }
 
 // backgroundRecommender periodically generates recommendations.
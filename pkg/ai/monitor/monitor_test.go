@@ -2,10 +2,14 @@ package monitor
 
 import (
 	"context"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	aicontext "github.com/cbwinslow/cbwsh/pkg/ai/context"
 	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/history"
 )
 
 func TestNewMonitor(t *testing.T) {
@@ -314,3 +318,38 @@ func TestGenerateRecommendation_NoActivities(t *testing.T) {
 		t.Errorf("expected no error with no activities, got %v", err)
 	}
 }
+
+func TestBuildPromptIncludesProjectHistory(t *testing.T) {
+	dir := t.TempDir()
+	store := history.NewStore(filepath.Join(dir, "history"))
+	now := time.Now()
+	for _, e := range []history.Entry{
+		{Command: "go test ./...", Dir: dir, Timestamp: now.Add(-time.Hour), ExitCode: 1},
+		{Command: "go build", Dir: dir, Timestamp: now.Add(-time.Minute)},
+	} {
+		if err := store.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	analyzer := aicontext.NewAnalyzer()
+	analyzer.SetHistory(store)
+
+	cfg := DefaultConfig()
+	cfg.AutoRecommend = false
+	monitor := NewMonitor(cfg)
+	activities := []Activity{{Type: ActivityCommand, Command: "ls", WorkDir: dir}}
+
+	if prompt := monitor.buildPrompt(activities); strings.Contains(prompt, "go build") {
+		t.Error("expected no project history without an analyzer")
+	}
+
+	monitor.SetContextAnalyzer(analyzer)
+	prompt := monitor.buildPrompt(activities)
+	if !strings.Contains(prompt, "Earlier commands in this project:\n- go build\n- go test ./... (exit code: 1)") {
+		t.Errorf("expected project history in prompt, got:\n%s", prompt)
+	}
+	if !strings.Contains(prompt, "failed in this project this week:\n- go test ./... (exit code: 1)") {
+		t.Errorf("expected recent failures in prompt, got:\n%s", prompt)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"sync"
 
 	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/privileges"
 )
 
 // Common errors.
@@ -31,6 +32,10 @@ type TranslationResult struct {
 	Alternatives []string
 	// Warnings contains any warnings about the command.
 	Warnings []string
+	// Risk is how much damage the command can do; Warnings explain why.
+	// Callers should have the user confirm risky commands before running
+	// them.
+	Risk privileges.RiskLevel
 }
 
 // ShellContext provides context for more accurate translations.
@@ -202,6 +207,11 @@ func (t *AITranslator) parseResponse(response, originalDescription string) *Tran
 	// Generate explanation
 	result.Explanation = t.generateExplanation(command)
 
+	// Flag destructive commands
+	risk := privileges.AnalyzeCommand(command)
+	result.Risk = risk.Level
+	result.Warnings = append(result.Warnings, risk.Reasons()...)
+
 	return result
 }
 
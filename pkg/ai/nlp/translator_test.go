@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/privileges"
 )
 
 // mockAgent implements core.AIAgent for testing.
@@ -95,6 +96,33 @@ func TestAITranslator_Translate(t *testing.T) {
 	}
 }
 
+func TestAITranslator_Risk(t *testing.T) {
+	tests := []struct {
+		response string
+		risk     privileges.RiskLevel
+	}{
+		{"ls -la", privileges.RiskNone},
+		{"git push --force", privileges.RiskHigh},
+		{"```sh\nsudo rm -rf /\n```", privileges.RiskCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.response, func(t *testing.T) {
+			translator := NewAITranslator(&mockAgent{response: tt.response})
+			result, err := translator.Translate(context.Background(), "do it")
+			if err != nil {
+				t.Fatalf("Translate() error = %v", err)
+			}
+			if result.Risk != tt.risk {
+				t.Errorf("Risk = %v, want %v", result.Risk, tt.risk)
+			}
+			if tt.risk != privileges.RiskNone && len(result.Warnings) == 0 {
+				t.Error("a risky command should carry warnings")
+			}
+		})
+	}
+}
+
 func TestAITranslator_TranslateWithContext(t *testing.T) {
 	agent := &mockAgent{response: "ls -la"}
 	translator := NewAITranslator(agent)
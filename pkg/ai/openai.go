@@ -0,0 +1,151 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// openAIClient speaks the OpenAI chat completions API, which local servers
+// such as llama.cpp, vLLM and Ollama also implement.
+type openAIClient struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newOpenAIClient(baseURL, apiKey string, httpClient *http.Client) *openAIClient {
+	return &openAIClient{baseURL: baseURL, apiKey: apiKey, httpClient: httpClient}
+}
+
+type openAIRequest struct {
+	Model       string          `json:"model,omitempty"`
+	Messages    []openAIMessage `json:"messages"`
+	Tools       []openAITool    `json:"tools,omitempty"`
+	MaxTokens   int             `json:"max_tokens,omitempty"`
+	Temperature float64         `json:"temperature"`
+	Stream      bool            `json:"stream,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+}
+
+// Chat sends a chat completion request.
+func (c *openAIClient) Chat(ctx context.Context, req *ChatRequest) (*Message, error) {
+	resp, err := postJSON(ctx, c.httpClient, c.baseURL+"/chat/completions", c.headers(), c.request(req, false))
+	if err != nil {
+		return nil, err
+	}
+
+	var out openAIResponse
+	if err := decodeJSON(resp, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Choices) == 0 {
+		return nil, errors.New("response has no choices")
+	}
+
+	reply := out.Choices[0].Message
+	msg := &Message{Role: RoleAssistant, Content: reply.Content}
+	for _, call := range reply.ToolCalls {
+		var raw map[string]any
+		if call.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(call.Function.Arguments), &raw); err != nil {
+				return nil, fmt.Errorf("invalid arguments for tool %s: %w", call.Function.Name, err)
+			}
+		}
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Args: toolArgs(raw)})
+	}
+	return msg, nil
+}
+
+// StreamChat streams a chat completion.
+func (c *openAIClient) StreamChat(ctx context.Context, req *ChatRequest) (<-chan string, error) {
+	resp, err := postJSON(ctx, c.httpClient, c.baseURL+"/chat/completions", c.headers(), c.request(req, true))
+	if err != nil {
+		return nil, err
+	}
+
+	return streamEvents(ctx, resp, func(_, data string) (string, bool, error) {
+		if data == "[DONE]" {
+			return "", true, nil
+		}
+		var chunk openAIResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", false, err
+		}
+		if len(chunk.Choices) == 0 {
+			return "", false, nil
+		}
+		return chunk.Choices[0].Delta.Content, false, nil
+	}), nil
+}
+
+func (c *openAIClient) request(req *ChatRequest, stream bool) *openAIRequest {
+	out := &openAIRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stream:      stream,
+	}
+
+	for _, tool := range req.Tools {
+		t := openAITool{Type: "function"}
+		t.Function.Name = tool.Name
+		t.Function.Description = tool.Description
+		t.Function.Parameters = toolSchema(tool)
+		out.Tools = append(out.Tools, t)
+	}
+
+	for _, msg := range req.Messages {
+		m := openAIMessage{Role: msg.Role, Content: msg.Content, ToolCallID: msg.ToolCallID}
+		for _, call := range msg.ToolCalls {
+			tc := openAIToolCall{ID: call.ID, Type: "function"}
+			tc.Function.Name = call.Name
+			args, _ := json.Marshal(call.Args)
+			tc.Function.Arguments = string(args)
+			m.ToolCalls = append(m.ToolCalls, tc)
+		}
+		out.Messages = append(out.Messages, m)
+	}
+	return out
+}
+
+func (c *openAIClient) headers() map[string]string {
+	// Local servers usually need no key
+	if c.apiKey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + c.apiKey}
+}
@@ -0,0 +1,253 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ApprovalPolicy decides whether a tool call needs the user's consent.
+type ApprovalPolicy string
+
+const (
+	// ApprovalAuto runs the tool without asking.
+	ApprovalAuto ApprovalPolicy = "auto"
+	// ApprovalAsk asks the user before each call.
+	ApprovalAsk ApprovalPolicy = "ask"
+	// ApprovalDeny never runs the tool.
+	ApprovalDeny ApprovalPolicy = "deny"
+)
+
+// Limits for the built-in shell tools.
+const (
+	// toolTimeout bounds how long a tool command may run.
+	toolTimeout = 30 * time.Second
+	// toolOutputLimit caps the output returned to the model.
+	toolOutputLimit = 16 * 1024
+	// toolDirLimit caps the number of directory entries listed.
+	toolDirLimit = 500
+)
+
+// readOnlyCommands are the commands run_command accepts. Commands that can
+// write or execute others, such as sed -i, awk or find -exec, are left out,
+// as are env and printenv, which would hand the model the environment's
+// secrets.
+var readOnlyCommands = map[string]bool{
+	"basename": true, "cat": true, "cmp": true, "cut": true, "date": true,
+	"df": true, "diff": true, "dirname": true, "du": true, "echo": true,
+	"file": true, "find": true, "free": true, "grep": true, "head": true,
+	"hostname": true, "id": true, "ls": true, "md5sum": true, "nl": true,
+	"ps": true, "pwd": true, "readlink": true,
+	"realpath": true, "rg": true, "sha256sum": true, "sort": true,
+	"stat": true, "tail": true, "tr": true, "tree": true, "type": true,
+	"uname": true, "uptime": true, "wc": true, "which": true,
+	"whoami": true, "git": true,
+}
+
+// readOnlyGitCommands are the git subcommands run_command accepts.
+var readOnlyGitCommands = map[string]bool{
+	"blame": true, "describe": true, "diff": true, "log": true,
+	"ls-files": true, "rev-parse": true, "shortlog": true, "show": true,
+	"status": true,
+}
+
+// ShellTools returns the built-in tools that let a model inspect the
+// user's system: run_command, read_file, list_directory and git_status.
+// workDir returns the directory they work in; read_file and list_directory
+// stay inside it. Only run_command asks for approval by default.
+func ShellTools(workDir func() string) []*Tool {
+	return []*Tool{
+		{
+			Name:        "run_command",
+			Description: "Run a read-only shell command such as ls, grep, find or git log and return its output. Pipes are allowed; redirections, command lists and substitutions are not.",
+			Parameters: []ToolParameter{
+				{Name: "command", Description: "The command line to run", Required: true},
+			},
+			Approval: ApprovalAsk,
+			Handler: func(ctx context.Context, args map[string]string) (string, error) {
+				command := args["command"]
+				if err := CheckReadOnly(command); err != nil {
+					return "", err
+				}
+				return runTool(ctx, workDir(), "/bin/sh", "-c", command)
+			},
+		},
+		{
+			Name:        "read_file",
+			Description: "Read a text file inside the working directory, given relative to it.",
+			Parameters: []ToolParameter{
+				{Name: "path", Description: "The file to read", Required: true},
+			},
+			Approval: ApprovalAuto,
+			Handler: func(_ context.Context, args map[string]string) (string, error) {
+				path, err := resolvePath(workDir(), args["path"])
+				if err != nil {
+					return "", err
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					return "", err
+				}
+				if info.IsDir() {
+					return "", fmt.Errorf("%s is a directory", args["path"])
+				}
+				f, err := os.Open(path)
+				if err != nil {
+					return "", err
+				}
+				defer f.Close()
+				data, err := io.ReadAll(io.LimitReader(f, toolOutputLimit+1))
+				if err != nil {
+					return "", err
+				}
+				return truncateOutput(string(data)), nil
+			},
+		},
+		{
+			Name:        "list_directory",
+			Description: "List the entries of a directory inside the working directory, given relative to it. Directories end with /.",
+			Parameters: []ToolParameter{
+				{Name: "path", Description: "The directory to list; defaults to the working directory"},
+			},
+			Approval: ApprovalAuto,
+			Handler: func(_ context.Context, args map[string]string) (string, error) {
+				path, err := resolvePath(workDir(), args["path"])
+				if err != nil {
+					return "", err
+				}
+				entries, err := os.ReadDir(path)
+				if err != nil {
+					return "", err
+				}
+				var out strings.Builder
+				for i, entry := range entries {
+					if i == toolDirLimit {
+						fmt.Fprintf(&out, "... %d more entries\n", len(entries)-i)
+						break
+					}
+					out.WriteString(entry.Name())
+					if entry.IsDir() {
+						out.WriteByte('/')
+					}
+					out.WriteByte('\n')
+				}
+				return out.String(), nil
+			},
+		},
+		{
+			Name:        "git_status",
+			Description: "Show the branch and changed files of the git repository in the working directory.",
+			Approval:    ApprovalAuto,
+			Handler: func(ctx context.Context, _ map[string]string) (string, error) {
+				return runTool(ctx, workDir(), "git", "status", "--short", "--branch")
+			},
+		},
+	}
+}
+
+// CheckReadOnly returns an error unless command is a pipeline of commands
+// that only read.
+func CheckReadOnly(command string) error {
+	if strings.TrimSpace(command) == "" {
+		return errors.New("empty command")
+	}
+	if strings.ContainsAny(command, ";&<>`\n") || strings.Contains(command, "$(") {
+		return errors.New("command lists, redirections and substitutions are not allowed")
+	}
+
+	for _, segment := range strings.Split(command, "|") {
+		words := strings.Fields(segment)
+		if len(words) == 0 {
+			return errors.New("empty pipeline stage")
+		}
+		name := filepath.Base(words[0])
+		if !readOnlyCommands[name] {
+			return fmt.Errorf("%s is not an allowed read-only command", name)
+		}
+		for _, word := range words[1:] {
+			// git diff --output, sort -o and tree -o write files, and
+			// sort takes -o among other flags, as in -uo
+			if strings.HasPrefix(word, "--output") || (name == "tree" && strings.HasPrefix(word, "-o")) ||
+				(name == "sort" && !strings.HasPrefix(word, "--") && strings.HasPrefix(word, "-") && strings.Contains(word, "o")) {
+				return fmt.Errorf("%s %s is not allowed", name, word)
+			}
+		}
+		switch name {
+		case "git":
+			if len(words) < 2 || !readOnlyGitCommands[words[1]] {
+				return errors.New("only read-only git subcommands are allowed")
+			}
+		case "find":
+			for _, word := range words[1:] {
+				switch {
+				case word == "-exec", word == "-execdir", word == "-ok", word == "-okdir", word == "-delete",
+					strings.HasPrefix(word, "-fprint"), word == "-fls":
+					return fmt.Errorf("find %s is not allowed", word)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// runTool runs a command in dir and returns its combined output.
+func runTool(ctx context.Context, dir, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, toolTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	output := truncateOutput(out.String())
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// A failing command is still a result the model can use
+		return fmt.Sprintf("%s\n(exit status %d)", output, exitErr.ExitCode()), nil
+	}
+	return output, err
+}
+
+// resolvePath resolves path, relative to dir, following symlinks. The file
+// tools run without approval, so paths leading out of dir are refused.
+func resolvePath(dir, path string) (string, error) {
+	resolved := path
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			resolved = filepath.Join(home, rest)
+		}
+	}
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(dir, resolved)
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err = filepath.EvalSymlinks(resolved); err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the working directory", path)
+	}
+	return resolved, nil
+}
+
+func truncateOutput(s string) string {
+	if len(s) <= toolOutputLimit {
+		return s
+	}
+	return s[:toolOutputLimit] + "\n... output truncated"
+}
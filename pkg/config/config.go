@@ -2,12 +2,14 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 
 	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/privileges"
 	"gopkg.in/yaml.v3"
 )
 
@@ -51,6 +53,12 @@ type ShellConfig struct {
 	Environment map[string]string `yaml:"environment"`
 	// Aliases holds command aliases.
 	Aliases map[string]string `yaml:"aliases"`
+	// CompletionSpecsDir holds completion specs that add to or override
+	// the built-in ones.
+	CompletionSpecsDir string `yaml:"completion_specs_dir"`
+	// ConfirmRisk is the lowest risk level (low, medium, high or critical)
+	// at which a command waits for confirmation before it runs.
+	ConfirmRisk privileges.RiskLevel `yaml:"confirm_risk"`
 }
 
 // UIConfig holds UI-specific configuration.
@@ -95,12 +103,18 @@ type AIConfig struct {
 	Provider core.AIProvider `yaml:"provider"`
 	// APIKey is the API key for the AI service.
 	APIKey string `yaml:"api_key"`
+	// APIKeySecret names the secret holding the API key, read from the
+	// secrets store instead of APIKey.
+	APIKeySecret string `yaml:"api_key_secret"`
 	// Model is the AI model to use.
 	Model string `yaml:"model"`
 	// MaxTokens is the maximum tokens for AI responses.
 	MaxTokens int `yaml:"max_tokens"`
 	// Temperature controls AI response randomness.
 	Temperature float64 `yaml:"temperature"`
+	// BaseURL replaces the provider's API endpoint, for example to use a
+	// local OpenAI-compatible server.
+	BaseURL string `yaml:"base_url"`
 	// EnableSuggestions toggles AI command suggestions.
 	EnableSuggestions bool `yaml:"enable_suggestions"`
 	// LocalModelPath is the path to a local LLM model.
@@ -113,6 +127,12 @@ type AIConfig struct {
 	EnableMonitoring bool `yaml:"enable_monitoring"`
 	// MonitoringInterval is the interval for generating recommendations (in seconds).
 	MonitoringInterval int `yaml:"monitoring_interval"`
+	// ToolApproval overrides whether the AI chat's tools run without asking
+	// ("auto"), ask first ("ask") or never run ("deny"), keyed by tool name.
+	ToolApproval map[string]string `yaml:"tool_approval"`
+	// ConfirmRisk is ShellConfig.ConfirmRisk for commands the AI
+	// suggested; it is usually lower so they get more review.
+	ConfirmRisk privileges.RiskLevel `yaml:"confirm_risk"`
 }
 
 // SSHConfig holds SSH-specific configuration.
@@ -121,22 +141,82 @@ type SSHConfig struct {
 	DefaultKeyPath string `yaml:"default_key_path"`
 	// KnownHostsPath is the path to known_hosts file.
 	KnownHostsPath string `yaml:"known_hosts_path"`
+	// StrictHostKeyChecking decides what happens to hosts missing from
+	// known_hosts: "ask" shows their fingerprint and asks whether to trust
+	// them, "accept-new" trusts them, "strict" refuses them and "off"
+	// checks no host keys at all.
+	StrictHostKeyChecking string `yaml:"strict_host_key_checking"`
+	// HashKnownHosts hashes the hosts added to known_hosts, so the file
+	// does not reveal them.
+	HashKnownHosts bool `yaml:"hash_known_hosts"`
+	// HostCAFiles are public key files of certificate authorities trusted
+	// to sign host certificates.
+	HostCAFiles []string `yaml:"host_ca_files"`
+	// ConfigPath is the OpenSSH client configuration whose hosts can be
+	// connected to by alias.
+	ConfigPath string `yaml:"config_path"`
+	// Agent selects the SSH agent used for authentication: "auto" uses
+	// the agent on SSH_AUTH_SOCK if there is one, "system" requires it,
+	// "vault" serves keys stored in the secrets store and "none" disables
+	// agents.
+	Agent string `yaml:"agent"`
+	// ForwardAgent forwards the agent into sessions on every host.
+	ForwardAgent bool `yaml:"forward_agent"`
 	// ConnectTimeout is the connection timeout in seconds.
 	ConnectTimeout int `yaml:"connect_timeout"`
 	// KeepAliveInterval is the keep-alive interval in seconds.
 	KeepAliveInterval int `yaml:"keep_alive_interval"`
+	// KeepAliveCountMax is how many keep-alives may go unanswered before
+	// a connection is considered dead.
+	KeepAliveCountMax int `yaml:"keep_alive_count_max"`
+	// Reconnect re-establishes lost connections, waiting longer after
+	// each failed attempt.
+	Reconnect bool `yaml:"reconnect"`
+	// ReconnectMaxAttempts is how many attempts are made before giving
+	// up; 0 keeps trying.
+	ReconnectMaxAttempts int `yaml:"reconnect_max_attempts"`
 	// SavedHosts holds saved SSH host configurations.
 	SavedHosts []core.SSHHost `yaml:"saved_hosts"`
+	// Groups maps host group names to their members: saved hosts, aliases
+	// of the OpenSSH client configuration, or patterns such as web-*.
+	Groups map[string][]string `yaml:"groups"`
+	// FanOutConcurrency is how many hosts of a group run a command at once.
+	FanOutConcurrency int `yaml:"fanout_concurrency"`
+	// FanOutTimeout bounds connecting to each host of a group and running
+	// the command there, in seconds.
+	FanOutTimeout int `yaml:"fanout_timeout"`
 }
 
 // SecretsConfig holds secrets-specific configuration.
 type SecretsConfig struct {
 	// StorePath is the path to the secrets store.
 	StorePath string `yaml:"store_path"`
-	// EncryptionAlgorithm is the encryption algorithm to use.
+	// EncryptionAlgorithm is the cipher new stores are encrypted with,
+	// AES-256-GCM or XChaCha20-Poly1305; secrets rekey moves an existing
+	// store to it.
 	EncryptionAlgorithm string `yaml:"encryption_algorithm"`
 	// KeyDerivation is the key derivation function.
 	KeyDerivation string `yaml:"key_derivation"`
+	// KDFTime, KDFMemory (in KiB) and KDFThreads are the Argon2id
+	// parameters deriving the key of new stores from the master password.
+	// Each store records its own; secrets rekey upgrades them.
+	KDFTime    uint32 `yaml:"kdf_time"`
+	KDFMemory  uint32 `yaml:"kdf_memory"`
+	KDFThreads uint8  `yaml:"kdf_threads"`
+	// Environment maps environment variable names to secret keys that are
+	// decrypted and exported when running non-interactively.
+	Environment map[string]string `yaml:"environment"`
+	// AutoLock is how long the store stays unlocked without a secret being
+	// used, in seconds; 0 keeps it unlocked until cbwsh exits.
+	AutoLock int `yaml:"auto_lock"`
+	// CacheTTL is how long, in seconds, the key of an unlocked store is
+	// cached for other cbwsh instances by a local agent; 0 disables it.
+	CacheTTL int `yaml:"cache_ttl"`
+	// AgentSocket is the Unix socket of the key cache agent.
+	AgentSocket string `yaml:"agent_socket"`
+	// AuditLog is the file recording every access to a secret; empty
+	// disables it.
+	AuditLog string `yaml:"audit_log"`
 }
 
 // KeybindingsConfig holds keybinding configuration.
@@ -167,6 +247,9 @@ func Default() *Config {
 			HistoryPath:  filepath.Join(configDir, "history"),
 			Environment:  make(map[string]string),
 			Aliases:      make(map[string]string),
+			// Specs here override the built-in ones of the same name
+			CompletionSpecsDir: filepath.Join(configDir, "completions"),
+			ConfirmRisk:        privileges.RiskHigh,
 		},
 		UI: UIConfig{
 			Theme:              "default",
@@ -195,18 +278,35 @@ func Default() *Config {
 			OllamaModel:        "llama2",
 			EnableMonitoring:   false,
 			MonitoringInterval: 30,
+			ConfirmRisk:        privileges.RiskLow,
 		},
 		SSH: SSHConfig{
-			DefaultKeyPath:    filepath.Join(homeDir, ".ssh", "id_rsa"),
-			KnownHostsPath:    filepath.Join(homeDir, ".ssh", "known_hosts"),
-			ConnectTimeout:    30,
-			KeepAliveInterval: 60,
-			SavedHosts:        []core.SSHHost{},
+			DefaultKeyPath:        filepath.Join(homeDir, ".ssh", "id_rsa"),
+			KnownHostsPath:        filepath.Join(homeDir, ".ssh", "known_hosts"),
+			StrictHostKeyChecking: "ask",
+			HashKnownHosts:        true,
+			ConfigPath:            filepath.Join(homeDir, ".ssh", "config"),
+			Agent:                 "auto",
+			ConnectTimeout:        30,
+			KeepAliveInterval:     60,
+			KeepAliveCountMax:     3,
+			Reconnect:             true,
+			ReconnectMaxAttempts:  10,
+			SavedHosts:            []core.SSHHost{},
+			Groups:                make(map[string][]string),
+			FanOutConcurrency:     10,
+			FanOutTimeout:         30,
 		},
 		Secrets: SecretsConfig{
 			StorePath:           filepath.Join(configDir, "secrets.enc"),
 			EncryptionAlgorithm: "AES-256-GCM",
 			KeyDerivation:       "argon2id",
+			KDFTime:             1,
+			KDFMemory:           64 * 1024,
+			KDFThreads:          4,
+			AutoLock:            900,
+			AgentSocket:         filepath.Join(configDir, "secrets-agent.sock"),
+			AuditLog:            filepath.Join(configDir, "secrets-audit.log"),
 		},
 		Keybindings: KeybindingsConfig{
 			Quit:            "ctrl+q",
@@ -376,3 +476,95 @@ func (c *Config) SetAIConfig(ai AIConfig) {
 	defer c.mu.Unlock()
 	c.AI = ai
 }
+
+// ReplaceHostPassphrases rewrites the configuration file at path so the
+// saved hosts named in secrets refer to the secret holding their
+// passphrase, under passphrase_secret, instead of spelling it out under
+// passphrase. The rest of the file, comments included, is kept; only its
+// indentation may change.
+func ReplaceHostPassphrases(path string, secrets map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	hosts := mappingValue(mappingValue(doc.Content[0], "ssh"), "saved_hosts")
+	if hosts == nil || hosts.Kind != yaml.SequenceNode {
+		return nil
+	}
+	for _, host := range hosts.Content {
+		name := mappingValue(host, "name")
+		if name == nil {
+			continue
+		}
+		secret, ok := secrets[name.Value]
+		if !ok {
+			continue
+		}
+		setPassphraseSecret(host, secret)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	// Replace the file in one step so a failed write loses nothing
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config file %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// mappingValue returns the value of key in the mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setPassphraseSecret replaces the passphrase of the host node with a
+// reference to secret.
+func setPassphraseSecret(host *yaml.Node, secret string) {
+	content := host.Content[:0]
+	for i := 0; i+1 < len(host.Content); i += 2 {
+		if key := host.Content[i].Value; key == "passphrase" || key == "passphrase_secret" {
+			continue
+		}
+		content = append(content, host.Content[i], host.Content[i+1])
+	}
+	host.Content = append(content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "passphrase_secret"},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: secret},
+	)
+}
@@ -3,6 +3,7 @@ package config_test
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/cbwinslow/cbwsh/pkg/config"
@@ -148,3 +149,47 @@ func TestSaveCreatesDirectory(t *testing.T) {
 		t.Error("config file was not created")
 	}
 }
+
+func TestReplaceHostPassphrases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := `# My hosts
+ssh:
+  saved_hosts:
+    - name: web
+      host: web.example.com
+      passphrase: hunter2 # the key's passphrase
+    - name: db
+      host: db.example.com
+      passphrase: other
+`
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.ReplaceHostPassphrases(path, map[string]string{"web": "ssh-passphrase/web"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("expected the passphrase to be removed, got:\n%s", data)
+	}
+	if !strings.Contains(string(data), "# My hosts") {
+		t.Errorf("expected comments to be kept, got:\n%s", data)
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web, db := cfg.SSH.SavedHosts[0], cfg.SSH.SavedHosts[1]
+	if web.Passphrase != "" || web.PassphraseSecret != "ssh-passphrase/web" || web.Host != "web.example.com" {
+		t.Errorf("unexpected web host %+v", web)
+	}
+	if db.Passphrase != "other" {
+		t.Errorf("expected hosts not named to be unchanged, got %+v", db)
+	}
+}
@@ -4,6 +4,9 @@ package core
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
 // Common errors.
@@ -116,6 +119,46 @@ type CommandResult struct {
 	Duration int64
 }
 
+// OutputStream identifies which stream a chunk of command output came from.
+type OutputStream int
+
+const (
+	// StreamStdout is the command's standard output.
+	StreamStdout OutputStream = iota
+	// StreamStderr is the command's standard error.
+	StreamStderr
+)
+
+// String returns the string representation of the output stream.
+func (o OutputStream) String() string {
+	switch o {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	default:
+		return "unknown"
+	}
+}
+
+// OutputChunk is a piece of output produced by a running command.
+type OutputChunk struct {
+	// Stream is the stream the output was written to.
+	Stream OutputStream
+	// Data is the output text. It is not necessarily a whole line.
+	Data string
+}
+
+// CommandEvent is sent on the channel returned by Executor.ExecuteAsync.
+// Exactly one of Chunk or Result is set. The Result event is always the
+// last event before the channel is closed.
+type CommandEvent struct {
+	// Chunk is output produced while the command runs.
+	Chunk *OutputChunk
+	// Result is the final result once the command has finished.
+	Result *CommandResult
+}
+
 // SSHConnectionState represents the state of an SSH connection.
 type SSHConnectionState int
 
@@ -128,6 +171,8 @@ const (
 	SSHConnected
 	// SSHError indicates a connection error.
 	SSHError
+	// SSHReconnecting indicates a lost connection being re-established.
+	SSHReconnecting
 )
 
 // String returns the string representation of the SSH connection state.
@@ -141,6 +186,8 @@ func (s SSHConnectionState) String() string {
 		return "connected"
 	case SSHError:
 		return "error"
+	case SSHReconnecting:
+		return "reconnecting"
 	default:
 		return "unknown"
 	}
@@ -184,12 +231,32 @@ func (a AIProvider) String() string {
 	}
 }
 
+// MarshalText encodes the provider by name, as written in configuration
+// files.
+func (a AIProvider) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes a provider name such as "openai". The numeric
+// values written by earlier versions are accepted too.
+func (a *AIProvider) UnmarshalText(text []byte) error {
+	name := strings.ToLower(strings.TrimSpace(string(text)))
+	for p := AIProviderNone; p <= AIProviderOllama; p++ {
+		if name == p.String() || name == strconv.Itoa(int(p)) {
+			*a = p
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown AI provider %q", ErrInvalidInput, text)
+}
+
 // Executor defines the interface for command execution.
 type Executor interface {
 	// Execute runs a command and returns the result.
 	Execute(ctx context.Context, command string) (*CommandResult, error)
-	// ExecuteAsync runs a command asynchronously.
-	ExecuteAsync(ctx context.Context, command string) (<-chan *CommandResult, error)
+	// ExecuteAsync runs a command asynchronously, streaming output chunks
+	// as they are produced followed by the final result.
+	ExecuteAsync(ctx context.Context, command string) (<-chan CommandEvent, error)
 	// Interrupt stops the currently running command.
 	Interrupt() error
 	// SetShellType sets the shell type (bash/zsh).
@@ -240,12 +307,57 @@ type SSHManager interface {
 
 // SSHHost represents a saved SSH host configuration.
 type SSHHost struct {
-	Name       string
-	Host       string
-	Port       int
-	User       string
-	KeyPath    string
-	Passphrase string
+	Name    string
+	Host    string
+	Port    int
+	User    string
+	KeyPath string
+	// Passphrase is the passphrase of the key file in plain text.
+	//
+	// Deprecated: use PassphraseSecret. It is read only so that older
+	// configurations keep working until they are migrated.
+	Passphrase string `yaml:"passphrase,omitempty"`
+	// PassphraseSecret names the secret holding the passphrase of the key
+	// file in the secrets store.
+	PassphraseSecret string `yaml:"passphrase_secret,omitempty"`
+	// PasswordSecret names the secret holding the password of the user in
+	// the secrets store, for hosts that take passwords.
+	PasswordSecret string `yaml:"password_secret,omitempty"`
+	// ProxyJump lists jump hosts to connect through, separated by commas,
+	// as in OpenSSH's ProxyJump.
+	ProxyJump string
+	// ForwardAgent forwards the SSH agent into sessions on the host.
+	ForwardAgent bool
+	// Tunnels are started whenever the host is connected to.
+	Tunnels []TunnelSpec
+}
+
+// TunnelType is the kind of an SSH port forward.
+type TunnelType string
+
+const (
+	// TunnelLocal forwards a local port to an address reached from the
+	// host, like ssh -L.
+	TunnelLocal TunnelType = "local"
+	// TunnelRemote forwards a port on the host to an address reached from
+	// here, like ssh -R.
+	TunnelRemote TunnelType = "remote"
+	// TunnelDynamic runs a local SOCKS5 proxy whose connections are made
+	// from the host, like ssh -D.
+	TunnelDynamic TunnelType = "dynamic"
+)
+
+// TunnelSpec declares an SSH port forward.
+type TunnelSpec struct {
+	// Type is the kind of forward.
+	Type TunnelType `yaml:"type"`
+	// Listen is the [address:]port listened on: here for local and
+	// dynamic tunnels, on the host for remote ones. The address defaults
+	// to localhost.
+	Listen string `yaml:"listen"`
+	// Target is the host:port connections are forwarded to; unused for
+	// dynamic tunnels.
+	Target string `yaml:"target,omitempty"`
 }
 
 // Plugin defines the interface that all plugins must implement.
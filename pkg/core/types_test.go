@@ -26,6 +26,26 @@ func TestShellTypeString(t *testing.T) {
 	}
 }
 
+func TestOutputStreamString(t *testing.T) {
+	tests := []struct {
+		stream   core.OutputStream
+		expected string
+	}{
+		{core.StreamStdout, "stdout"},
+		{core.StreamStderr, "stderr"},
+		{core.OutputStream(99), "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			result := tt.stream.String()
+			if result != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestPaneLayoutString(t *testing.T) {
 	tests := []struct {
 		layout   core.PaneLayout
@@ -115,3 +135,31 @@ func TestAIProviderString(t *testing.T) {
 		})
 	}
 }
+
+func TestAIProviderUnmarshalText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected core.AIProvider
+		wantErr  bool
+	}{
+		{"openai", core.AIProviderOpenAI, false},
+		{"Anthropic", core.AIProviderAnthropic, false},
+		{"ollama", core.AIProviderOllama, false},
+		{"3", core.AIProviderGemini, false},
+		{"unknown", core.AIProviderNone, true},
+		{"9", core.AIProviderNone, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var provider core.AIProvider
+			err := provider.UnmarshalText([]byte(tt.text))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshalText(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if provider != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, provider)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package history
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// frecencyHalfLife is how long it takes a run of a command to count for
+// half as much in its ranking.
+const frecencyHalfLife = 7 * 24 * time.Hour
+
+// Location boosts applied to runs of a command near the current directory.
+const (
+	sameDirBoost     = 3.0
+	sameProjectBoost = 2.0
+)
+
+// Ranked is a distinct command from the history with its frecency score.
+type Ranked struct {
+	// Entry is the most recent run of the command.
+	Entry
+	// Count is how many times the command was run.
+	Count int
+	// Score combines how often and how recently the command was run,
+	// boosted for runs in the current directory or repository.
+	Score float64
+}
+
+// Rank collapses entries into one per distinct command, ordered by
+// frecency: every run counts for less the older it is, and runs in dir,
+// or elsewhere in dir's git repository, count for more. Entries must be
+// oldest first, as returned by Store.Entries.
+func Rank(entries []Entry, dir string, now time.Time) []Ranked {
+	root := ""
+	if dir != "" {
+		root = ProjectRoot(dir)
+	}
+
+	index := make(map[string]int)
+	var ranked []Ranked
+	for _, entry := range entries {
+		i, ok := index[entry.Command]
+		if !ok {
+			i = len(ranked)
+			index[entry.Command] = i
+			ranked = append(ranked, Ranked{})
+		}
+
+		r := &ranked[i]
+		r.Entry = entry
+		r.Count++
+		r.Score += runWeight(entry, dir, root, now)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Timestamp.After(ranked[j].Timestamp)
+	})
+	return ranked
+}
+
+// runWeight scores a single run of a command.
+func runWeight(e Entry, dir, root string, now time.Time) float64 {
+	// Entries from the plain-text format have no time and count as old
+	weight := 0.1
+	if !e.Timestamp.IsZero() {
+		age := now.Sub(e.Timestamp)
+		if age < 0 {
+			age = 0
+		}
+		weight = math.Exp2(-float64(age) / float64(frecencyHalfLife))
+	}
+
+	switch {
+	case dir != "" && e.Dir == dir:
+		weight *= sameDirBoost
+	case root != "" && withinDir(e.Dir, root):
+		weight *= sameProjectBoost
+	}
+	return weight
+}
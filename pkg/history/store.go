@@ -0,0 +1,333 @@
+// Package history provides the persistent command history database for
+// cbwsh.
+//
+// Every executed command is recorded as an Entry carrying where, when and
+// how it ran. Entries are appended as JSON lines to a single file that any
+// number of cbwsh instances may write to at once: each append takes an
+// exclusive lock on the file and is a single write followed by fsync, and
+// readers ignore a trailing line left incomplete by a crash. Files in the
+// older plain-text format (one command per line) are read as entries
+// without metadata, so existing history carries over.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Entry is one executed command and its metadata.
+type Entry struct {
+	// Command is the command line as entered.
+	Command string `json:"cmd"`
+	// Timestamp is when the command started.
+	Timestamp time.Time `json:"ts"`
+	// Dir is the working directory the command ran in.
+	Dir string `json:"dir,omitempty"`
+	// ExitCode is the command's exit status.
+	ExitCode int `json:"exit"`
+	// Duration is how long the command ran.
+	Duration time.Duration `json:"dur,omitempty"`
+	// PaneID identifies the pane the command ran in.
+	PaneID string `json:"pane,omitempty"`
+	// Host is the local hostname or the SSH host the command ran on.
+	Host string `json:"host,omitempty"`
+	// SessionID identifies the cbwsh instance that ran the command.
+	SessionID string `json:"session,omitempty"`
+}
+
+// Failed reports whether the command exited with a non-zero status.
+func (e Entry) Failed() bool {
+	return e.ExitCode != 0
+}
+
+// Query selects history entries. Zero-valued fields match everything.
+type Query struct {
+	// Text matches commands containing it.
+	Text string
+	// Dir matches commands run in this directory or below it.
+	Dir string
+	// Host matches commands run on this host.
+	Host string
+	// SessionID matches commands from this cbwsh instance.
+	SessionID string
+	// PaneID matches commands run in this pane.
+	PaneID string
+	// FailedOnly matches only commands with a non-zero exit status.
+	FailedOnly bool
+	// Since matches commands started at or after this time.
+	Since time.Time
+	// Until matches commands started before this time.
+	Until time.Time
+	// Limit caps the number of results; 0 means no limit.
+	Limit int
+}
+
+// Match reports whether e satisfies the query.
+func (q Query) Match(e Entry) bool {
+	if q.Text != "" && !strings.Contains(e.Command, q.Text) {
+		return false
+	}
+	if q.Dir != "" && !withinDir(e.Dir, q.Dir) {
+		return false
+	}
+	if q.Host != "" && e.Host != q.Host {
+		return false
+	}
+	if q.SessionID != "" && e.SessionID != q.SessionID {
+		return false
+	}
+	if q.PaneID != "" && e.PaneID != q.PaneID {
+		return false
+	}
+	if q.FailedOnly && !e.Failed() {
+		return false
+	}
+	if !q.Since.IsZero() && e.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.Timestamp.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Store is an append-only history file shared between cbwsh instances.
+//
+// Store keeps the file's entries in memory and picks up entries appended
+// by other instances whenever it is queried.
+//
+// It is safe for concurrent use by multiple goroutines.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	entries []Entry
+	offset  int64 // bytes of the file already read into entries
+}
+
+// NewStore creates a store for the history file at path. The file is
+// created on the first append.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the path of the history file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append records an entry. The entry is durable once Append returns.
+func (s *Store) Append(entry Entry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock history file: %w", err)
+	}
+	defer func() { _ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }()
+
+	// Terminate a line left incomplete by a crashed writer so that this
+	// entry starts on a line of its own
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to write history entry: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync history file: %w", err)
+	}
+	return nil
+}
+
+// Refresh reads entries appended to the file since the last read.
+func (s *Store) Refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer f.Close()
+
+	// Start over if the file was truncated or replaced by a shorter one
+	if info, err := f.Stat(); err == nil && info.Size() < s.offset {
+		s.entries = nil
+		s.offset = 0
+	}
+
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return fmt.Errorf("failed to read history file: %w", err)
+			}
+			// A JSON line without a newline is still being written, or was
+			// torn by a crash, and is read again on the next refresh. Only
+			// legacy files end in a plain command without a newline.
+			if len(line) == 0 || line[0] == '{' {
+				return nil
+			}
+		}
+		s.offset += int64(len(line))
+
+		if entry, ok := parseLine(line); ok {
+			s.entries = append(s.entries, entry)
+		}
+	}
+}
+
+// Entries returns all entries, oldest first.
+func (s *Store) Entries() ([]Entry, error) {
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]Entry, len(s.entries))
+	copy(result, s.entries)
+	return result, nil
+}
+
+// Query returns the entries matching q, newest first.
+func (s *Store) Query(q Query) ([]Entry, error) {
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []Entry
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		if q.Match(s.entries[i]) {
+			result = append(result, s.entries[i])
+			if q.Limit > 0 && len(result) >= q.Limit {
+				break
+			}
+		}
+	}
+
+	// Entries from concurrent writers may be slightly out of order
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.After(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// parseLine decodes a line of the history file. Lines in the legacy
+// plain-text format become entries holding only the command. A JSON line
+// cut short by a crash is skipped, while a legacy command that merely
+// looks like JSON, such as `{ make; }`, is kept.
+func parseLine(line []byte) (Entry, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return Entry{}, false
+	}
+
+	if line[0] == '{' {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err == nil && entry.Command != "" {
+			return entry, true
+		}
+		if json.Valid(line) || !bytes.HasSuffix(line, []byte("}")) {
+			return Entry{}, false
+		}
+	}
+
+	return Entry{Command: string(line)}, true
+}
+
+// withinDir reports whether dir is root or a directory below it.
+func withinDir(dir, root string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// ProjectRoot returns the root of the git repository containing dir, or
+// "" when it is not inside a repository.
+func ProjectRoot(dir string) string {
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return ""
+		}
+		current = parent
+	}
+}
+
+// ParseAge parses a duration such as "90m", "36h", "7d" or "2w".
+func ParseAge(s string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
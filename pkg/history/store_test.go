@@ -0,0 +1,251 @@
+package history_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/history"
+)
+
+func TestStoreAppendAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	store := history.NewStore(path)
+
+	now := time.Now()
+	entries := []history.Entry{
+		{Command: "make test", Dir: "/src/app", ExitCode: 2, Timestamp: now.Add(-10 * 24 * time.Hour), Host: "laptop"},
+		{Command: "make build", Dir: "/src/app/cmd", ExitCode: 1, Timestamp: now.Add(-2 * 24 * time.Hour), Host: "laptop"},
+		{Command: "make build", Dir: "/src/app", ExitCode: 0, Timestamp: now.Add(-time.Hour), Host: "laptop", Duration: 3 * time.Second},
+		{Command: "ls", Dir: "/src/application", ExitCode: 1, Timestamp: now, Host: "server", SessionID: "s2", PaneID: "p1"},
+	}
+	for _, entry := range entries {
+		if err := store.Append(entry); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+
+	// Commands that failed in this repo last week
+	failed, err := store.Query(history.Query{Dir: "/src/app", FailedOnly: true, Since: now.Add(-7 * 24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(failed) != 1 || failed[0].Command != "make build" || failed[0].Dir != "/src/app/cmd" {
+		t.Errorf("unexpected failed commands: %+v", failed)
+	}
+
+	latest, err := store.Query(history.Query{Text: "make", Limit: 1})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(latest) != 1 || latest[0].Duration != 3*time.Second || latest[0].ExitCode != 0 {
+		t.Errorf("expected newest make command with metadata, got %+v", latest)
+	}
+
+	remote, _ := store.Query(history.Query{Host: "server", SessionID: "s2", PaneID: "p1"})
+	if len(remote) != 1 || remote[0].Command != "ls" {
+		t.Errorf("expected host/session/pane filters to match ls, got %+v", remote)
+	}
+
+	// A second store sees the same file
+	all, err := history.NewStore(path).Entries()
+	if err != nil {
+		t.Fatalf("entries failed: %v", err)
+	}
+	if len(all) != len(entries) || all[0].Command != "make test" {
+		t.Errorf("expected entries oldest first from a fresh store, got %+v", all)
+	}
+}
+
+func TestStoreConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	reader := history.NewStore(path)
+
+	const writers, perWriter = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Each writer stands in for a separate cbwsh instance
+			store := history.NewStore(path)
+			for i := 0; i < perWriter; i++ {
+				if err := store.Append(history.Entry{Command: "echo", SessionID: string(rune('a' + w))}); err != nil {
+					t.Errorf("append failed: %v", err)
+				}
+				if i%10 == 0 {
+					_, _ = reader.Entries()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	all, err := reader.Entries()
+	if err != nil {
+		t.Fatalf("entries failed: %v", err)
+	}
+	if len(all) != writers*perWriter {
+		t.Errorf("expected %d entries, got %d", writers*perWriter, len(all))
+	}
+}
+
+func TestStoreRecoversFromTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	store := history.NewStore(path)
+
+	if err := store.Append(history.Entry{Command: "first"}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	// Simulate a writer that crashed halfway through a line
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	_, _ = f.WriteString(`{"cmd":"torn","ts":"20`)
+	_ = f.Close()
+
+	all, _ := store.Entries()
+	if len(all) != 1 {
+		t.Errorf("expected the torn line to be ignored, got %+v", all)
+	}
+
+	if err := store.Append(history.Entry{Command: "second"}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	all, _ = store.Entries()
+	if len(all) != 2 || all[1].Command != "second" {
+		t.Errorf("expected append after torn line to be readable, got %+v", all)
+	}
+}
+
+func TestStoreReadsLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte("ls -la\n{ make; }\ngit status"), 0o600); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	store := history.NewStore(path)
+	if err := store.Append(history.Entry{Command: "pwd", ExitCode: 1}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	all, err := store.Entries()
+	if err != nil {
+		t.Fatalf("entries failed: %v", err)
+	}
+	want := []string{"ls -la", "{ make; }", "git status", "pwd"}
+	if len(all) != len(want) {
+		t.Fatalf("expected %d entries, got %+v", len(want), all)
+	}
+	for i, cmd := range want {
+		if all[i].Command != cmd {
+			t.Errorf("entry %d: expected %q, got %q", i, cmd, all[i].Command)
+		}
+	}
+	if all[3].ExitCode != 1 {
+		t.Errorf("expected metadata on new entry, got %+v", all[3])
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{"90m", 90 * time.Minute, false},
+		{"36h", 36 * time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"xd", 0, true},
+		{"-1h", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := history.ParseAge(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.expected {
+			t.Errorf("ParseAge(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestProjectRoot(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(filepath.Join(root, ".git"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(sub, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := history.ProjectRoot(sub); got != root {
+		t.Errorf("expected %s, got %s", root, got)
+	}
+
+	outside := t.TempDir()
+	if got := history.ProjectRoot(outside); got != "" {
+		t.Errorf("expected no root outside a repository, got %s", got)
+	}
+}
+
+func TestRank(t *testing.T) {
+	repo := t.TempDir()
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(repo, "cmd")
+	elsewhere := t.TempDir()
+
+	now := time.Now()
+	day := 24 * time.Hour
+	entries := []history.Entry{
+		{Command: "make", Dir: elsewhere, Timestamp: now.Add(-60 * day)},
+		{Command: "make", Dir: elsewhere, Timestamp: now.Add(-59 * day)},
+		{Command: "make", Dir: elsewhere, Timestamp: now.Add(-58 * day)},
+		{Command: "ls", Dir: elsewhere, Timestamp: now.Add(-day)},
+		{Command: "go test", Dir: repo, Timestamp: now.Add(-day), ExitCode: 1},
+		{Command: "go build", Dir: sub, Timestamp: now.Add(-day)},
+		{Command: "go test", Dir: repo, Timestamp: now.Add(-time.Hour)},
+	}
+
+	ranked := history.Rank(entries, sub, now)
+	var order []string
+	for _, r := range ranked {
+		order = append(order, r.Command)
+	}
+	want := []string{"go test", "go build", "ls", "make"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected order %v, got %v", want, order)
+	}
+
+	if ranked[0].Count != 2 || ranked[0].ExitCode != 0 || ranked[0].Timestamp != entries[6].Timestamp {
+		t.Errorf("expected the latest run of a repeated command, got %+v", ranked[0])
+	}
+	if ranked[3].Count != 3 {
+		t.Errorf("expected runs to be counted, got %+v", ranked[3])
+	}
+
+	// From the root of the repository, runs anywhere in it are boosted
+	entries = []history.Entry{
+		{Command: "go build", Dir: sub, Timestamp: now.Add(-2 * time.Hour)},
+		{Command: "ls", Dir: elsewhere, Timestamp: now.Add(-time.Hour)},
+	}
+	if ranked := history.Rank(entries, repo, now); ranked[0].Command != "go build" {
+		t.Errorf("expected the run in the repository first from its root, got %+v", ranked)
+	}
+	// Outside a repository, only runs in the directory itself are
+	entries = []history.Entry{
+		{Command: "go build", Dir: filepath.Join(elsewhere, "sub"), Timestamp: now.Add(-2 * time.Hour)},
+		{Command: "ls", Dir: repo, Timestamp: now.Add(-time.Hour)},
+	}
+	if ranked := history.Rank(entries, elsewhere, now); ranked[0].Command != "ls" {
+		t.Errorf("expected no boost outside a repository, got %+v", ranked)
+	}
+}
@@ -9,7 +9,9 @@
 //   - Independent shell executors per pane
 //
 // Each pane maintains its own shell executor, output buffer, and state,
-// allowing for parallel command execution across multiple panes.
+// allowing for parallel command execution across multiple panes. A pane's
+// executor runs in session mode, so each pane owns a long-lived bash/zsh
+// process attached to a pseudo-terminal.
 package panes
 
 import (
@@ -43,19 +45,31 @@ type Pane struct {
 // The pane is initialized with:
 //   - A unique 8-character UUID-based identifier
 //   - Default title "Shell"
-//   - A new shell executor for the specified shell type
+//   - A new shell executor for the specified shell type, in session mode
 //   - Empty output buffer
 //
+// The pane's shell process is started by its first command.
+//
 // Parameters:
 //   - shellType: The type of shell to use (bash or zsh)
 //
 // Returns:
 //   - A new Pane ready to execute commands
 func NewPane(shellType core.ShellType) *Pane {
+	executor := shell.NewExecutor(shellType)
+	executor.EnableSession()
+
+	return NewPaneWithExecutor(executor, "Shell")
+}
+
+// NewPaneWithExecutor creates a pane that runs commands with executor,
+// such as one attached to a remote host by shell.NewTerminalExecutor.
+// Resizing the pane resizes the executor's terminal.
+func NewPaneWithExecutor(executor *shell.Executor, title string) *Pane {
 	return &Pane{
 		id:       uuid.New().String()[:8], // Use first 8 chars of UUID
-		title:    "Shell",
-		executor: shell.NewExecutor(shellType),
+		title:    title,
+		executor: executor,
 		output:   make([]string, 0),
 	}
 }
@@ -116,12 +130,20 @@ func (p *Pane) Height() int {
 	return p.height
 }
 
-// SetSize sets the pane dimensions.
+// SetSize sets the pane dimensions and resizes the pane's terminal.
 func (p *Pane) SetSize(width, height int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.width = width
 	p.height = height
+	_ = p.executor.Resize(width, height)
+}
+
+// Close terminates the pane's shell session.
+func (p *Pane) Close() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.executor.Close()
 }
 
 // GetExecutor returns the pane's executor.
@@ -218,16 +240,36 @@ func (m *Manager) Create() (core.Pane, error) {
 	return pane, nil
 }
 
+// CreateRemote creates a pane whose shell is started by start, typically
+// on a remote host, and makes it the active pane.
+func (m *Manager) CreateRemote(title string, start shell.TerminalStarter) (*Pane, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pane := NewPaneWithExecutor(shell.NewTerminalExecutor(m.shellType, start), title)
+	m.panes[pane.ID()] = pane
+
+	if current, exists := m.panes[m.activePaneID]; exists {
+		current.Deactivate()
+	}
+	pane.Activate()
+	m.activePaneID = pane.ID()
+
+	return pane, nil
+}
+
 // Close closes a pane by ID.
 func (m *Manager) Close(id string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.panes[id]; !exists {
+	pane, exists := m.panes[id]
+	if !exists {
 		return fmt.Errorf("pane not found: %s", id)
 	}
 
 	delete(m.panes, id)
+	_ = pane.Close()
 
 	// If we closed the active pane, select another
 	if m.activePaneID == id {
@@ -242,6 +284,15 @@ func (m *Manager) Close(id string) error {
 	return nil
 }
 
+// CloseAll terminates the shell sessions of all panes without removing them.
+func (m *Manager) CloseAll() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, pane := range m.panes {
+		_ = pane.Close()
+	}
+}
+
 // Get returns a pane by ID.
 func (m *Manager) Get(id string) (core.Pane, bool) {
 	m.mu.RLock()
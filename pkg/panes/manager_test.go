@@ -1,10 +1,13 @@
 package panes_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/cbwinslow/cbwsh/pkg/core"
 	"github.com/cbwinslow/cbwsh/pkg/panes"
+	"github.com/cbwinslow/cbwsh/pkg/shell"
 )
 
 func TestNewManager(t *testing.T) {
@@ -546,3 +549,39 @@ func TestConcurrentPaneOperations(t *testing.T) {
 		t.Error("expected at least 1 pane after concurrent operations")
 	}
 }
+
+func TestCreateRemote(t *testing.T) {
+	t.Parallel()
+
+	manager := panes.NewManager(core.ShellTypeBash)
+	first, _ := manager.Create()
+
+	var gotCols, gotRows int
+	start := func(_ core.ShellType, _ string, cols, rows int) (shell.Terminal, error) {
+		gotCols, gotRows = cols, rows
+		return nil, errors.New("host unreachable")
+	}
+
+	pane, err := manager.CreateRemote("web", start)
+	if err != nil {
+		t.Fatalf("CreateRemote failed: %v", err)
+	}
+	if pane.Title() != "web" {
+		t.Errorf("expected title web, got %s", pane.Title())
+	}
+	if manager.ActivePane() != pane || first.IsActive() {
+		t.Error("expected the remote pane to become active")
+	}
+	if pane.GetShellExecutor().Local() {
+		t.Error("expected the remote pane's executor not to be local")
+	}
+
+	// The shell is started with the pane's size
+	pane.SetSize(100, 30)
+	if _, err := pane.GetShellExecutor().Execute(context.Background(), "true"); err == nil {
+		t.Error("expected the failing starter's error")
+	}
+	if gotCols != 100 || gotRows != 30 {
+		t.Errorf("expected the terminal to start at 100x30, got %dx%d", gotCols, gotRows)
+	}
+}
@@ -0,0 +1,139 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// scriptHookTimeout bounds how long a script hook may run.
+const scriptHookTimeout = 10 * time.Second
+
+// Load registers the plugins selected by cfg and initializes them.
+//
+// Plugins come from two places: creators in GlobalRegistry, and executable
+// files in cfg.Directory, each of which becomes a ScriptHook named after
+// the file. With AutoLoad every plugin found is loaded; otherwise only
+// those listed in Enabled are. Plugins listed in Disabled are never
+// loaded. A missing directory is not an error.
+func (m *Manager) Load(ctx context.Context, cfg config.PluginsConfig) error {
+	selected := func(name string) bool {
+		return !contains(cfg.Disabled, name) && (cfg.AutoLoad || contains(cfg.Enabled, name))
+	}
+
+	names := GlobalRegistry.ListAvailable()
+	sort.Strings(names)
+	for _, name := range names {
+		if !selected(name) {
+			continue
+		}
+		if plugin, ok := GlobalRegistry.Create(name); ok {
+			if err := m.Register(plugin); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.Directory != "" {
+		entries, err := os.ReadDir(cfg.Directory)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read plugin directory: %w", err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Mode()&0o111 == 0 {
+				continue
+			}
+			name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+			if !selected(name) {
+				continue
+			}
+			if err := m.Register(NewScriptHook(name, filepath.Join(cfg.Directory, entry.Name()))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return m.Initialize(ctx)
+}
+
+func contains(list []string, name string) bool {
+	for _, item := range list {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ScriptHook is a hook plugin backed by an executable in the plugin
+// directory.
+//
+// Before a command runs the script is called as `script pre` with the
+// command on stdin; whatever it prints replaces the command, empty output
+// keeps it unchanged and a non-zero exit status rejects it. After the
+// command the script is called as `script post` with the command on stdin
+// and CBWSH_EXIT_CODE and CBWSH_DURATION_MS in its environment.
+type ScriptHook struct {
+	*BasePlugin
+	path string
+}
+
+// NewScriptHook creates a hook plugin that runs the script at path.
+func NewScriptHook(name, path string) *ScriptHook {
+	return &ScriptHook{
+		BasePlugin: NewBasePlugin(name, core.PluginTypeHook, "script"),
+		path:       path,
+	}
+}
+
+// PreExecute runs the script's pre hook.
+func (h *ScriptHook) PreExecute(command string) (string, error) {
+	out, err := h.run("pre", command, nil)
+	if err != nil {
+		return "", err
+	}
+	if rewritten := strings.TrimRight(out, "\n"); rewritten != "" {
+		return rewritten, nil
+	}
+	return command, nil
+}
+
+// PostExecute runs the script's post hook.
+func (h *ScriptHook) PostExecute(result *core.CommandResult) error {
+	_, err := h.run("post", result.Command, []string{
+		"CBWSH_EXIT_CODE=" + strconv.Itoa(result.ExitCode),
+		"CBWSH_DURATION_MS=" + strconv.FormatInt(result.Duration, 10),
+	})
+	return err
+}
+
+func (h *ScriptHook) run(stage, command string, env []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptHookTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.path, stage)
+	cmd.Stdin = strings.NewReader(command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), env...)
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", h.Name(), msg)
+		}
+		return "", fmt.Errorf("%s: %w", h.Name(), err)
+	}
+	return stdout.String(), nil
+}
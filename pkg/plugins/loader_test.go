@@ -0,0 +1,111 @@
+package plugins_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/config"
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/plugins"
+)
+
+func writeScript(t *testing.T, dir, name, body string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+}
+
+func TestLoadScriptHooks(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "post.log")
+	writeScript(t, dir, "10-upper.sh", `[ "$1" = pre ] && tr a-z A-Z`+"\nexit 0\n")
+	writeScript(t, dir, "20-log", `[ "$1" = post ] && echo "$(cat) $CBWSH_EXIT_CODE" >> `+log+"\nexit 0\n")
+	writeScript(t, dir, "30-off", "exit 1\n")
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	manager := plugins.NewManager()
+	err := manager.Load(context.Background(), config.PluginsConfig{
+		Directory: dir,
+		AutoLoad:  true,
+		Disabled:  []string{"30-off"},
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if n := len(manager.ListByType(core.PluginTypeHook)); n != 2 {
+		t.Fatalf("expected 2 hook plugins, got %d", n)
+	}
+
+	command, err := manager.RunPreExecuteHooks("echo hi")
+	if err != nil {
+		t.Fatalf("pre-execute hooks failed: %v", err)
+	}
+	if command != "ECHO HI" {
+		t.Errorf("expected rewritten command 'ECHO HI', got %q", command)
+	}
+
+	if err := manager.RunPostExecuteHooks(&core.CommandResult{Command: "false", ExitCode: 1}); err != nil {
+		t.Fatalf("post-execute hooks failed: %v", err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("post hook did not run: %v", err)
+	}
+	if string(data) != "false 1\n" {
+		t.Errorf("unexpected post hook log %q", data)
+	}
+}
+
+func TestLoadScriptHookRejects(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	dir := t.TempDir()
+	writeScript(t, dir, "guard", `[ "$1" = pre ] && grep -q rm && { echo "rm is not allowed" >&2; exit 1; }`+"\nexit 0\n")
+
+	manager := plugins.NewManager()
+	if err := manager.Load(context.Background(), config.PluginsConfig{Directory: dir, Enabled: []string{"guard"}}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if _, err := manager.RunPreExecuteHooks("rm -rf build"); err == nil {
+		t.Error("expected guard hook to reject command")
+	}
+	if command, err := manager.RunPreExecuteHooks("ls"); err != nil || command != "ls" {
+		t.Errorf("expected command unchanged, got %q, %v", command, err)
+	}
+}
+
+func TestLoadSelection(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "a", "exit 0\n")
+	writeScript(t, dir, "b", "exit 0\n")
+
+	manager := plugins.NewManager()
+	if err := manager.Load(context.Background(), config.PluginsConfig{Directory: dir, Enabled: []string{"b"}}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, ok := manager.Get("a"); ok {
+		t.Error("expected plugin a not to be loaded without auto_load")
+	}
+	if _, ok := manager.Get("b"); !ok {
+		t.Error("expected enabled plugin b to be loaded")
+	}
+
+	manager = plugins.NewManager()
+	missing := config.PluginsConfig{Directory: filepath.Join(dir, "missing"), AutoLoad: true}
+	if err := manager.Load(context.Background(), missing); err != nil {
+		t.Errorf("expected missing directory to be ignored, got %v", err)
+	}
+}
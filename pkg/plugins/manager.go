@@ -4,6 +4,7 @@ package plugins
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/cbwinslow/cbwsh/pkg/core"
@@ -192,6 +193,53 @@ func (m *Manager) Shutdown(ctx context.Context) error {
 	return lastErr
 }
 
+// ExecutionHook is implemented by plugins that observe command execution.
+type ExecutionHook interface {
+	// PreExecute may rewrite a command before it runs.
+	PreExecute(command string) (string, error)
+	// PostExecute receives the result once the command has finished.
+	PostExecute(result *core.CommandResult) error
+}
+
+// RunPreExecuteHooks passes the command through every hook plugin in name
+// order and returns the possibly rewritten command. An error from any hook
+// aborts execution.
+func (m *Manager) RunPreExecuteHooks(command string) (string, error) {
+	for _, hook := range m.executionHooks() {
+		rewritten, err := hook.PreExecute(command)
+		if err != nil {
+			return "", fmt.Errorf("pre-execute hook failed: %w", err)
+		}
+		command = rewritten
+	}
+	return command, nil
+}
+
+// RunPostExecuteHooks hands the result to every hook plugin in name order.
+func (m *Manager) RunPostExecuteHooks(result *core.CommandResult) error {
+	for _, hook := range m.executionHooks() {
+		if err := hook.PostExecute(result); err != nil {
+			return fmt.Errorf("post-execute hook failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) executionHooks() []ExecutionHook {
+	plugins := m.ListByType(core.PluginTypeHook)
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name() < plugins[j].Name()
+	})
+
+	hooks := make([]ExecutionHook, 0, len(plugins))
+	for _, plugin := range plugins {
+		if hook, ok := plugin.(ExecutionHook); ok && plugin.Enabled() {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
 // CommandPlugin is a plugin that provides commands.
 type CommandPlugin struct {
 	*BasePlugin
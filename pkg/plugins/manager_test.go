@@ -2,6 +2,7 @@ package plugins_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/cbwinslow/cbwsh/pkg/core"
@@ -236,6 +237,59 @@ func TestHookPlugin(t *testing.T) {
 	}
 }
 
+func TestManagerExecutionHooks(t *testing.T) {
+	manager := plugins.NewManager()
+
+	first := plugins.NewHookPlugin("a-first", "1.0.0")
+	first.SetPreExecuteHook(func(cmd string) (string, error) {
+		return cmd + " a", nil
+	})
+	second := plugins.NewHookPlugin("b-second", "1.0.0")
+	second.SetPreExecuteHook(func(cmd string) (string, error) {
+		return cmd + " b", nil
+	})
+	disabled := plugins.NewHookPlugin("c-disabled", "1.0.0")
+	disabled.SetPreExecuteHook(func(cmd string) (string, error) {
+		return "", errors.New("should not run")
+	})
+	_ = disabled.Disable()
+
+	var seen *core.CommandResult
+	second.SetPostExecuteHook(func(result *core.CommandResult) error {
+		seen = result
+		return nil
+	})
+
+	for _, p := range []core.Plugin{second, disabled, first} {
+		if err := manager.Register(p); err != nil {
+			t.Fatalf("register failed: %v", err)
+		}
+	}
+
+	command, err := manager.RunPreExecuteHooks("echo")
+	if err != nil {
+		t.Fatalf("pre-execute hooks failed: %v", err)
+	}
+	if command != "echo a b" {
+		t.Errorf("expected hooks to run in name order, got %q", command)
+	}
+
+	result := &core.CommandResult{Command: command}
+	if err := manager.RunPostExecuteHooks(result); err != nil {
+		t.Fatalf("post-execute hooks failed: %v", err)
+	}
+	if seen != result {
+		t.Error("expected post-execute hook to receive the result")
+	}
+
+	first.SetPreExecuteHook(func(cmd string) (string, error) {
+		return "", errors.New("blocked")
+	})
+	if _, err := manager.RunPreExecuteHooks("echo"); err == nil {
+		t.Error("expected error from failing pre-execute hook")
+	}
+}
+
 func TestFormatterPlugin(t *testing.T) {
 	formatter := func(output string) (string, error) {
 		return "[formatted] " + output, nil
@@ -0,0 +1,483 @@
+package privileges
+
+import (
+	"strings"
+)
+
+// simpleCommand is one command of a command line, with wrappers such as
+// sudo, env and xargs removed.
+type simpleCommand struct {
+	// words are the command name and its arguments, unquoted.
+	words []string
+	// elevated is set if a wrapper such as sudo runs the command as root.
+	elevated bool
+	// wrapper is the wrapper that elevated the command, such as "sudo".
+	wrapper string
+	// writes are the files the command's redirections write to.
+	writes []string
+}
+
+// name returns the command's base name.
+func (c *simpleCommand) name() string {
+	if len(c.words) == 0 {
+		return ""
+	}
+	name := c.words[0]
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
+}
+
+// args returns the command's arguments.
+func (c *simpleCommand) args() []string {
+	if len(c.words) < 2 {
+		return nil
+	}
+	return c.words[1:]
+}
+
+// operands returns the arguments that are not options. Arguments after
+// "--" are always operands.
+func (c *simpleCommand) operands() []string {
+	var out []string
+	options := true
+	for _, arg := range c.args() {
+		switch {
+		case options && arg == "--":
+			options = false
+		case options && len(arg) > 1 && arg[0] == '-':
+		default:
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+// hasFlag reports whether the command was given any of the short flags
+// letters, alone or combined as in -rf, or any of the long options.
+func (c *simpleCommand) hasFlag(letters string, long ...string) bool {
+	for _, arg := range c.args() {
+		if arg == "--" {
+			return false
+		}
+		if strings.HasPrefix(arg, "--") {
+			for _, l := range long {
+				if arg == l || strings.HasPrefix(arg, l+"=") {
+					return true
+				}
+			}
+			continue
+		}
+		if len(arg) > 1 && arg[0] == '-' && strings.ContainsAny(arg[1:], letters) {
+			return true
+		}
+	}
+	return false
+}
+
+// pipeline is a sequence of commands joined by pipes.
+type pipeline []*simpleCommand
+
+// parsedLine is a command line split into pipelines.
+type parsedLine struct {
+	pipelines []pipeline
+	// substitutions are the contents of $(...), `...`, <(...) and >(...).
+	substitutions []string
+}
+
+// token is a word or an operator of a command line.
+type token struct {
+	text string
+	op   bool
+}
+
+// wrappers are commands that run another command given as their
+// arguments, mapped to their short options that take a value.
+var wrappers = map[string]string{
+	"sudo":    "CDghpRrTtUu",
+	"doas":    "Cu",
+	"env":     "CSu",
+	"nice":    "n",
+	"ionice":  "cnp",
+	"nohup":   "",
+	"time":    "fo",
+	"command": "",
+	"exec":    "a",
+	"builtin": "",
+	"stdbuf":  "eio",
+	"timeout": "ks",
+	"xargs":   "adEILnPs",
+	"watch":   "dn",
+}
+
+// elevators are the wrappers that run their command as root.
+var elevators = map[string]bool{
+	"sudo": true,
+	"doas": true,
+}
+
+// shells are interpreters that take a script with -c.
+var shells = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true,
+	"fish": true, "ash": true,
+}
+
+// keywords are shell reserved words that may precede a command.
+var keywords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"do": true, "done": true, "while": true, "until": true, "{": true,
+	"}": true, "!": true,
+}
+
+// parseCommandLine splits line into pipelines of simple commands. Scripts
+// passed to sh -c or eval are parsed as part of the line. It is a
+// best-effort parser for analysis, not a full shell grammar.
+func parseCommandLine(line string) *parsedLine {
+	parsed := &parsedLine{}
+	parseInto(parsed, line, false, "", 0)
+	return parsed
+}
+
+// maxParseDepth bounds how deeply sh -c scripts and substitutions nest.
+const maxParseDepth = 8
+
+func parseInto(parsed *parsedLine, line string, elevated bool, wrapper string, depth int) {
+	if depth > maxParseDepth {
+		return
+	}
+
+	tokens, subs := tokenize(line)
+	for _, sub := range subs {
+		parsed.substitutions = append(parsed.substitutions, sub)
+		parseInto(parsed, sub, elevated, wrapper, depth+1)
+	}
+
+	var pipelines []pipeline
+	current := pipeline{}
+	cmd := &simpleCommand{elevated: elevated, wrapper: wrapper}
+	endCommand := func() {
+		if len(cmd.words) > 0 || len(cmd.writes) > 0 {
+			current = append(current, cmd)
+		}
+		cmd = &simpleCommand{elevated: elevated, wrapper: wrapper}
+	}
+	endPipeline := func() {
+		endCommand()
+		if len(current) > 0 {
+			pipelines = append(pipelines, current)
+		}
+		current = pipeline{}
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if !tok.op {
+			cmd.words = append(cmd.words, tok.text)
+			continue
+		}
+
+		switch tok.text {
+		case "|", "|&":
+			endCommand()
+		case ">", ">>", ">|", "&>", "&>>":
+			if i+1 < len(tokens) && !tokens[i+1].op {
+				i++
+				cmd.writes = append(cmd.writes, tokens[i].text)
+			}
+		case ">&":
+			// 2>&1 duplicates a descriptor; >&file writes a file
+			if i+1 < len(tokens) && !tokens[i+1].op {
+				i++
+				if target := tokens[i].text; target != "-" && !isNumber(target) {
+					cmd.writes = append(cmd.writes, target)
+				}
+			}
+		case "<", "<<", "<<<", "<>", "<&":
+			if i+1 < len(tokens) && !tokens[i+1].op {
+				i++
+			}
+		default:
+			endPipeline()
+		}
+	}
+	endPipeline()
+
+	// Unwrapping may parse sh -c and eval scripts into further pipelines
+	for _, p := range pipelines {
+		parsed.pipelines = append(parsed.pipelines, p)
+		for _, c := range p {
+			unwrap(parsed, c, depth)
+		}
+	}
+}
+
+// unwrap removes assignments, reserved words and wrappers from the front
+// of c, and parses the scripts of sh -c and eval.
+func unwrap(parsed *parsedLine, c *simpleCommand, depth int) {
+	for len(c.words) > 0 {
+		word := c.words[0]
+		if keywords[word] || isAssignment(word) {
+			c.words = c.words[1:]
+			continue
+		}
+
+		name := c.name()
+		valued, ok := wrappers[name]
+		if !ok {
+			break
+		}
+		if elevators[name] {
+			c.elevated = true
+			c.wrapper = name
+		}
+		c.words = skipOptions(c.words[1:], valued, name == "env")
+		if name == "timeout" && len(c.words) > 0 {
+			// The duration
+			c.words = c.words[1:]
+		}
+	}
+
+	name := c.name()
+	switch {
+	case shells[name]:
+		args := c.args()
+		for i, arg := range args {
+			if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' && strings.Contains(arg, "c") && i+1 < len(args) {
+				parseInto(parsed, args[i+1], c.elevated, c.wrapper, depth+1)
+				break
+			}
+		}
+	case name == "eval":
+		parseInto(parsed, strings.Join(c.args(), " "), c.elevated, c.wrapper, depth+1)
+	}
+}
+
+// skipOptions drops the leading options of a wrapper's arguments, along
+// with the values of the short options in valued. With assignments set,
+// NAME=value words are dropped too, as env takes them.
+func skipOptions(words []string, valued string, assignments bool) []string {
+	for len(words) > 0 {
+		word := words[0]
+		switch {
+		case word == "--":
+			return words[1:]
+		case assignments && isAssignment(word):
+			words = words[1:]
+		case len(word) > 1 && word[0] == '-':
+			words = words[1:]
+			// -u root takes the next word; -uroot does not
+			if len(word) == 2 && strings.ContainsRune(valued, rune(word[1])) && len(words) > 0 {
+				words = words[1:]
+			}
+		default:
+			return words
+		}
+	}
+	return words
+}
+
+// isAssignment reports whether word is a NAME=value assignment.
+func isAssignment(word string) bool {
+	idx := strings.IndexByte(word, '=')
+	if idx <= 0 {
+		return false
+	}
+	for i, r := range word[:idx] {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize splits a command line into words and operators, removing
+// quotes. Substitutions stay in their words as written, and their contents
+// are returned so they can be analyzed too.
+func tokenize(line string) ([]token, []string) {
+	var tokens []token
+	var subs []string
+	var word strings.Builder
+	inWord := false
+
+	endWord := func() {
+		if inWord {
+			tokens = append(tokens, token{text: word.String()})
+		}
+		word.Reset()
+		inWord = false
+	}
+	addOp := func(op string) {
+		endWord()
+		tokens = append(tokens, token{text: op, op: true})
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			endWord()
+
+		case c == '#' && !inWord:
+			// Comment to the end of the line
+			for i < len(line) && line[i] != '\n' {
+				i++
+			}
+			i--
+
+		case c == '\\':
+			if i+1 < len(line) {
+				i++
+				if line[i] != '\n' {
+					word.WriteByte(line[i])
+					inWord = true
+				}
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+
+		case c == '"':
+			inWord = true
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0:
+					i++
+					word.WriteByte(line[i])
+				case line[i] == '$' && i+1 < len(line) && line[i+1] == '(':
+					end := matchParen(line, i+1)
+					subs = append(subs, line[i+2:end])
+					word.WriteString(line[i:min(end+1, len(line))])
+					i = end
+				case line[i] == '`':
+					end := strings.IndexByte(line[i+1:], '`')
+					if end < 0 {
+						end = len(line) - i - 1
+					}
+					subs = append(subs, line[i+1:i+1+end])
+					word.WriteString(line[i:min(i+2+end, len(line))])
+					i += end + 1
+				default:
+					word.WriteByte(line[i])
+				}
+			}
+
+		case c == '$' && i+1 < len(line) && line[i+1] == '(':
+			end := matchParen(line, i+1)
+			subs = append(subs, line[i+2:end])
+			word.WriteString(line[i:min(end+1, len(line))])
+			inWord = true
+			i = end
+
+		case (c == '<' || c == '>') && i+1 < len(line) && line[i+1] == '(':
+			// Process substitution is a word naming a file
+			end := matchParen(line, i+1)
+			subs = append(subs, line[i+2:end])
+			word.WriteString(line[i:min(end+1, len(line))])
+			inWord = true
+			i = end
+
+		case c == '`':
+			end := strings.IndexByte(line[i+1:], '`')
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			subs = append(subs, line[i+1:i+1+end])
+			word.WriteString(line[i:min(i+2+end, len(line))])
+			inWord = true
+			i += end + 1
+
+		case c == '>' || c == '<':
+			// A number before the operator is a descriptor, as in 2>
+			if inWord && isNumber(word.String()) {
+				word.Reset()
+				inWord = false
+			}
+			op := string(c)
+			for _, next := range []string{">>", ">|", ">&", "<<<", "<<", "<>", "<&"} {
+				if strings.HasPrefix(line[i:], next) {
+					op = next
+					break
+				}
+			}
+			addOp(op)
+			i += len(op) - 1
+
+		case c == '&':
+			switch {
+			case strings.HasPrefix(line[i:], "&&"):
+				addOp("&&")
+				i++
+			case strings.HasPrefix(line[i:], "&>>"):
+				addOp("&>>")
+				i += 2
+			case strings.HasPrefix(line[i:], "&>"):
+				addOp("&>")
+				i++
+			default:
+				addOp("&")
+			}
+
+		case c == '|':
+			switch {
+			case strings.HasPrefix(line[i:], "||"):
+				addOp("||")
+				i++
+			case strings.HasPrefix(line[i:], "|&"):
+				addOp("|&")
+				i++
+			default:
+				addOp("|")
+			}
+
+		case c == ';' || c == '\n' || c == '(' || c == ')':
+			addOp(string(c))
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	endWord()
+	return tokens, subs
+}
+
+// matchParen returns the index of the parenthesis closing the one at
+// open, or len(s) if it is not closed.
+func matchParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case '\'':
+			if end := strings.IndexByte(s[i+1:], '\''); end >= 0 {
+				i += end + 1
+			}
+		}
+	}
+	return len(s)
+}
@@ -298,71 +298,84 @@ func (m *Manager) GetCapabilities() []string {
 	return caps
 }
 
+// rootCommands typically require root.
+var rootCommands = map[string]bool{
+	"mount": true, "umount": true, "fdisk": true, "mkfs": true,
+	"iptables": true, "ip6tables": true, "systemctl": true, "service": true,
+	"apt": true, "apt-get": true, "yum": true, "dnf": true, "pacman": true,
+	"apk": true, "pkg": true, "useradd": true, "userdel": true,
+	"usermod": true, "groupadd": true, "groupdel": true, "groupmod": true,
+	"chown": true, "passwd": true, "visudo": true, "shutdown": true,
+	"reboot": true, "poweroff": true, "halt": true, "init": true,
+	"modprobe": true, "insmod": true, "rmmod": true, "sysctl": true,
+	"dpkg": true, "rpm": true,
+}
+
+// writeCommands write to system areas when given system paths.
+var writeCommands = map[string]bool{
+	"chmod":   true,
+	"chown":   true,
+	"rm":      true,
+	"mv":      true,
+	"cp":      true,
+	"touch":   true,
+	"mkdir":   true,
+	"install": true,
+	"crontab": true,
+	"at":      true,
+	"tee":     true,
+	"ln":      true,
+}
+
 // RequiresElevation checks if a command requires elevated privileges.
+// Each command of the line is checked, including those after pipes, &&
+// and inside sh -c; commands already run through sudo or doas are not
+// counted.
 // Note: This is a heuristic and may have false positives/negatives.
 // For accurate permission checking, use CheckFilePermissions.
 func RequiresElevation(command string) bool {
-	// Commands that typically require root
-	rootCommands := []string{
-		"mount", "umount", "fdisk", "mkfs", "iptables", "ip6tables",
-		"systemctl", "service", "apt", "apt-get", "yum",
-		"dnf", "pacman", "apk", "pkg", "useradd", "userdel", "usermod",
-		"groupadd", "groupdel", "groupmod", "chown", "passwd",
-		"visudo", "shutdown", "reboot", "poweroff", "halt", "init",
-		"modprobe", "insmod", "rmmod", "sysctl",
-		"dpkg", "rpm",
-	}
-
-	// Commands that write to system areas when modifying
-	writeCommands := map[string]bool{
-		"chmod":   true,
-		"chown":   true,
-		"rm":      true,
-		"mv":      true,
-		"cp":      true,
-		"touch":   true,
-		"mkdir":   true,
-		"install": true,
-		"crontab": true,
-		"at":      true,
-	}
-
-	// Parse command to get the base command
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return false
+	for _, p := range parseCommandLine(command).pipelines {
+		for _, cmd := range p {
+			if cmd.elevated {
+				continue
+			}
+			if requiresElevation(cmd) {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	baseCmd := parts[0]
-	// Remove path if present
-	if idx := strings.LastIndex(baseCmd, "/"); idx >= 0 {
-		baseCmd = baseCmd[idx+1:]
+func requiresElevation(cmd *simpleCommand) bool {
+	name := cmd.name()
+	if rootCommands[name] || strings.HasPrefix(name, "mkfs.") {
+		return true
 	}
 
-	for _, rc := range rootCommands {
-		if baseCmd == rc {
+	// Redirections to system paths, such as echo x > /etc/hosts
+	for _, target := range cmd.writes {
+		if isElevatedPath(target) {
 			return true
 		}
 	}
 
 	// Only check paths for commands that modify files
-	if writeCommands[baseCmd] {
-		for _, arg := range parts[1:] {
-			// Skip flags
-			if strings.HasPrefix(arg, "-") {
-				continue
-			}
-			// Check for write operations to system paths
-			if strings.HasPrefix(arg, "/etc/") ||
-				strings.HasPrefix(arg, "/boot/") {
+	if writeCommands[name] {
+		for _, arg := range cmd.operands() {
+			if isElevatedPath(arg) {
 				return true
 			}
 		}
 	}
-
 	return false
 }
 
+// isElevatedPath reports whether writing to path needs root.
+func isElevatedPath(path string) bool {
+	return strings.HasPrefix(path, "/etc/") || strings.HasPrefix(path, "/boot/")
+}
+
 // FilePermissions holds file permission information.
 type FilePermissions struct {
 	Path       string
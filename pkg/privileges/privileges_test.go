@@ -123,6 +123,11 @@ func TestRequiresElevation(t *testing.T) {
 		{"chmod 755 /etc/hosts", true}, // Writing to /etc requires elevation
 		{"useradd testuser", true},
 		{"rm /etc/hosts", true}, // Deleting from /etc requires elevation
+		{"echo 1 > /etc/motd", true},
+		{"cd /etc && apt install vim", true},
+		{"echo foo | tee /etc/hosts", true},
+		{"sudo apt update", false}, // Already elevated
+		{`sh -c "systemctl restart nginx"`, true},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,600 @@
+package privileges
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RiskLevel rates how much damage a command can do.
+type RiskLevel int
+
+const (
+	// RiskNone is a command with no known risk.
+	RiskNone RiskLevel = iota
+	// RiskLow is a command that changes things in a limited way, such as
+	// deleting named files.
+	RiskLow
+	// RiskMedium is a command that can lose work, such as a recursive
+	// delete or git reset --hard.
+	RiskMedium
+	// RiskHigh is a command that can damage the system or shared state,
+	// such as writing under /etc or force-pushing.
+	RiskHigh
+	// RiskCritical is a command that can destroy the system or a disk,
+	// such as rm -rf / or dd to a device.
+	RiskCritical
+)
+
+var riskLevelNames = []string{"none", "low", "medium", "high", "critical"}
+
+// String returns the name of the risk level.
+func (l RiskLevel) String() string {
+	if l >= 0 && int(l) < len(riskLevelNames) {
+		return riskLevelNames[l]
+	}
+	return "unknown"
+}
+
+// ParseRiskLevel returns the risk level with the given name.
+func ParseRiskLevel(name string) (RiskLevel, error) {
+	for i, n := range riskLevelNames {
+		if strings.EqualFold(name, n) {
+			return RiskLevel(i), nil
+		}
+	}
+	return RiskNone, fmt.Errorf("unknown risk level: %q", name)
+}
+
+// MarshalText encodes the risk level by name.
+func (l RiskLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a risk level name.
+func (l *RiskLevel) UnmarshalText(text []byte) error {
+	level, err := ParseRiskLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// Finding is a risky thing a command does.
+type Finding struct {
+	Level  RiskLevel
+	Reason string
+}
+
+// Assessment is the risk of a command line.
+type Assessment struct {
+	// Command is the analyzed command line.
+	Command string
+	// Level is the highest level of the findings.
+	Level RiskLevel
+	// Findings are the risks found, highest first.
+	Findings []Finding
+}
+
+// Reasons returns the reasons of the findings, highest risk first.
+func (a *Assessment) Reasons() []string {
+	reasons := make([]string, len(a.Findings))
+	for i, f := range a.Findings {
+		reasons[i] = f.Reason
+	}
+	return reasons
+}
+
+// NeedsConfirmation reports whether the command is risky enough that it
+// should only run after the user confirms, given the lowest level that
+// needs confirmation.
+func (a *Assessment) NeedsConfirmation(threshold RiskLevel) bool {
+	return a.Level > RiskNone && a.Level >= threshold
+}
+
+// Escalate rates the command one level higher, capped at critical, for
+// running in more places than one, such as on every host of a group, and
+// adds reason as a finding. A command with no known risk becomes low.
+func (a *Assessment) Escalate(reason string) {
+	level := RiskLow
+	for i := range a.Findings {
+		if a.Findings[i].Level < RiskCritical {
+			a.Findings[i].Level++
+		}
+		level = max(level, a.Findings[i].Level)
+	}
+	a.Level = level
+	a.add(level, "%s", reason)
+}
+
+func (a *Assessment) add(level RiskLevel, format string, args ...any) {
+	reason := fmt.Sprintf(format, args...)
+	for _, f := range a.Findings {
+		if f.Reason == reason {
+			return
+		}
+	}
+	a.Findings = append(a.Findings, Finding{Level: level, Reason: reason})
+	if level > a.Level {
+		a.Level = level
+	}
+}
+
+// forkBomb matches the classic :(){ :|:& };: and its renamings.
+var forkBomb = regexp.MustCompile(`(\w+|:)\s*\(\)\s*\{\s*(\w+|:)\s*\|\s*(\w+|:)\s*&\s*\}`)
+
+// AnalyzeCommand parses command and rates what it does: destructive
+// deletes, writes to devices and system paths, permission changes, force
+// pushes, downloads piped into a shell and the like. Commands run through
+// sh -c, eval, sudo, xargs and command substitutions are analyzed too.
+//
+// The analysis is a heuristic meant to catch mistakes before they happen;
+// it cannot prove a command safe.
+func AnalyzeCommand(command string) *Assessment {
+	a := &Assessment{Command: command}
+	parsed := parseCommandLine(command)
+
+	if forkBomb.MatchString(command) {
+		a.add(RiskCritical, "defines a fork bomb that exhausts the system's processes")
+	}
+
+	for _, p := range parsed.pipelines {
+		analyzePipeline(a, p)
+		for _, cmd := range p {
+			analyzeCommand(a, cmd)
+		}
+	}
+
+	sort.SliceStable(a.Findings, func(i, j int) bool {
+		return a.Findings[i].Level > a.Findings[j].Level
+	})
+	return a
+}
+
+// fetchers download data from the network.
+var fetchers = map[string]bool{
+	"curl": true, "wget": true, "fetch": true, "http": true, "https": true,
+}
+
+// interpreters run code read from their input.
+var interpreters = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true,
+	"fish": true, "ash": true, "python": true, "python3": true,
+	"perl": true, "ruby": true, "node": true, "php": true,
+}
+
+// fetchedScript matches a download run through a substitution, as in
+// bash <(curl ...) or sh -c "$(wget -O- ...)".
+var fetchedScript = regexp.MustCompile("(\\$\\(|<\\(|`)\\s*(sudo\\s+)?(curl|wget)\\b")
+
+// analyzePipeline flags downloads piped into an interpreter.
+func analyzePipeline(a *Assessment, p pipeline) {
+	fetched := ""
+	for _, cmd := range p {
+		name := cmd.name()
+		if fetchers[name] {
+			fetched = name
+			continue
+		}
+		if fetched != "" && interpreters[name] && !cmd.hasFlag("c") {
+			a.add(RiskHigh, "pipes a download from %s into %s, running code you have not reviewed", fetched, name)
+		}
+	}
+}
+
+// analyzeCommand applies the per-command rules.
+func analyzeCommand(a *Assessment, cmd *simpleCommand) {
+	name := cmd.name()
+
+	if cmd.elevated && name != "" {
+		a.add(RiskLow, "runs %s as root via %s", name, cmd.wrapper)
+	}
+
+	writer := name
+	if writer == "" {
+		writer = "a redirection"
+	}
+	for _, target := range cmd.writes {
+		checkWrite(a, writer, target, "writes")
+	}
+
+	// mkfs.ext4 and the like
+	if strings.HasPrefix(name, "mkfs.") {
+		name = "mkfs"
+	}
+
+	if interpreters[name] {
+		for _, arg := range cmd.args() {
+			if fetchedScript.MatchString(arg) {
+				a.add(RiskHigh, "runs a downloaded script with %s without review", name)
+			}
+		}
+	}
+
+	switch name {
+	case "rm":
+		analyzeRemove(a, cmd)
+	case "rmdir", "unlink", "truncate", "touch", "mkdir", "chattr", "setfacl":
+		for _, target := range cmd.operands() {
+			checkWrite(a, name, target, "modifies")
+		}
+	case "shred", "wipefs":
+		for _, target := range cmd.operands() {
+			if isDevice(target) {
+				a.add(RiskCritical, "erases the device %s", target)
+			} else if name == "shred" {
+				a.add(RiskMedium, "irrecoverably overwrites %s", target)
+			}
+		}
+		if name == "wipefs" && len(cmd.operands()) == 0 {
+			a.add(RiskCritical, "erases filesystem signatures")
+		}
+	case "chmod", "chown", "chgrp":
+		analyzePermissions(a, cmd)
+	case "dd":
+		for _, arg := range cmd.args() {
+			if target, ok := strings.CutPrefix(arg, "of="); ok {
+				if isDevice(target) {
+					a.add(RiskCritical, "writes raw data to the device %s, destroying its contents", target)
+				} else {
+					checkWrite(a, name, target, "writes")
+				}
+			}
+		}
+	case "cp", "mv", "install", "ln", "rsync":
+		operands := cmd.operands()
+		if len(operands) >= 2 {
+			target := operands[len(operands)-1]
+			if name == "mv" && target == "/dev/null" {
+				a.add(RiskHigh, "moves %s onto /dev/null, losing it", strings.Join(operands[:len(operands)-1], " "))
+			} else if isDevice(target) {
+				a.add(RiskCritical, "overwrites the device %s", target)
+			} else {
+				checkWrite(a, name, target, "writes")
+			}
+		}
+		if name == "rsync" && cmd.hasFlag("", "--delete", "--delete-before", "--delete-after", "--delete-during") {
+			a.add(RiskMedium, "deletes files at the destination that are missing from the source")
+		}
+	case "tee":
+		for _, target := range cmd.operands() {
+			checkWrite(a, name, target, "writes")
+		}
+	case "sed", "perl":
+		// The first operand is the script
+		if operands := cmd.operands(); len(operands) > 1 && cmd.hasFlag("i", "--in-place") {
+			for _, target := range operands[1:] {
+				checkWrite(a, name, target, "edits")
+			}
+		}
+	case "find":
+		analyzeFind(a, cmd)
+	case "git":
+		analyzeGit(a, cmd)
+	case "mkfs", "mke2fs", "mkswap":
+		a.add(RiskCritical, "formats %s, destroying its data", strings.Join(cmd.operands(), " "))
+	case "fdisk", "sfdisk", "cfdisk", "gdisk", "sgdisk", "parted":
+		a.add(RiskHigh, "edits the partition table of %s", strings.Join(cmd.operands(), " "))
+	case "shutdown", "reboot", "poweroff", "halt":
+		a.add(RiskHigh, "shuts down or reboots the machine")
+	case "init", "telinit":
+		if ops := cmd.operands(); len(ops) > 0 && (ops[0] == "0" || ops[0] == "6") {
+			a.add(RiskHigh, "shuts down or reboots the machine")
+		}
+	case "kill":
+		// PID -1 is every process the user may signal
+		if args := cmd.args(); len(args) > 0 && args[len(args)-1] == "-1" {
+			a.add(RiskHigh, "kills every process you can signal")
+		}
+	case "killall5":
+		a.add(RiskHigh, "kills every process you can signal")
+	case "pkill", "killall":
+		a.add(RiskLow, "kills every process matching %s", strings.Join(cmd.operands(), " "))
+	case "crontab":
+		if cmd.hasFlag("r") {
+			a.add(RiskHigh, "removes all of your cron jobs")
+		}
+	case "iptables", "ip6tables", "nft":
+		if cmd.hasFlag("F", "--flush") || (name == "nft" && containsWord(cmd.args(), "flush")) {
+			a.add(RiskMedium, "flushes firewall rules")
+		}
+	case "kubectl":
+		analyzeKubectl(a, cmd)
+	case "docker", "podman":
+		args := cmd.operands()
+		if len(args) >= 2 && args[1] == "prune" {
+			a.add(RiskMedium, "prunes unused %s %s", name, args[0])
+		}
+	}
+}
+
+// analyzeRemove rates rm by what it deletes.
+func analyzeRemove(a *Assessment, cmd *simpleCommand) {
+	recursive := cmd.hasFlag("rR", "--recursive")
+	targets := cmd.operands()
+
+	if cmd.hasFlag("", "--no-preserve-root") {
+		a.add(RiskCritical, "deletes with --no-preserve-root, allowing / to be removed")
+	}
+
+	if len(targets) == 0 && recursive {
+		// xargs rm -rf and the like delete whatever they are given
+		a.add(RiskMedium, "recursively deletes the paths it is given")
+		return
+	}
+
+	for _, target := range targets {
+		switch {
+		case recursive && isBroadPath(target):
+			a.add(RiskCritical, "recursively deletes %s", describePath(target))
+		case recursive && isSystemPath(target):
+			a.add(RiskCritical, "recursively deletes the system directory %s", target)
+		case recursive:
+			a.add(RiskMedium, "recursively deletes %s", target)
+		case isSystemPath(target):
+			a.add(RiskHigh, "deletes the system file %s", target)
+		case isBroadPath(target):
+			a.add(RiskMedium, "deletes %s", describePath(target))
+		default:
+			a.add(RiskLow, "deletes %s", target)
+		}
+	}
+}
+
+// analyzePermissions rates chmod, chown and chgrp.
+func analyzePermissions(a *Assessment, cmd *simpleCommand) {
+	name := cmd.name()
+	recursive := cmd.hasFlag("R", "--recursive")
+	operands := cmd.operands()
+	if len(operands) < 2 {
+		return
+	}
+	mode, targets := operands[0], operands[1:]
+
+	worldWritable := name == "chmod" && isWorldWritable(mode)
+	for _, target := range targets {
+		switch {
+		case recursive && (isBroadPath(target) || isSystemPath(target)):
+			a.add(RiskCritical, "recursively changes the %s of %s", permissionNoun(name), describePath(target))
+		case worldWritable && recursive:
+			a.add(RiskHigh, "makes everything under %s writable by every user", target)
+		case worldWritable:
+			a.add(RiskMedium, "makes %s writable by every user", target)
+		case isSystemPath(target):
+			a.add(RiskHigh, "changes the %s of the system file %s", permissionNoun(name), target)
+		}
+	}
+}
+
+func permissionNoun(name string) string {
+	if name == "chmod" {
+		return "permissions"
+	}
+	return "ownership"
+}
+
+// isWorldWritable reports whether a chmod mode grants write access to
+// others, as 777, 0666 and a+w do.
+func isWorldWritable(mode string) bool {
+	if isNumber(mode) {
+		last := mode[len(mode)-1]
+		return last == '2' || last == '3' || last == '6' || last == '7'
+	}
+	for _, clause := range strings.Split(mode, ",") {
+		who, perms, ok := strings.Cut(clause, "+")
+		if !ok {
+			who, perms, ok = strings.Cut(clause, "=")
+		}
+		if ok && strings.ContainsAny(who, "oa") && strings.Contains(perms, "w") {
+			return true
+		}
+	}
+	return false
+}
+
+// analyzeFind flags find expressions that delete or run commands.
+func analyzeFind(a *Assessment, cmd *simpleCommand) {
+	args := cmd.args()
+	var roots []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || arg == "(" || arg == "!" {
+			break
+		}
+		roots = append(roots, arg)
+	}
+
+	broad := false
+	for _, root := range roots {
+		if isBroadPath(root) || isSystemPath(root) {
+			broad = true
+		}
+	}
+
+	for i, arg := range args {
+		switch arg {
+		case "-delete":
+			if broad {
+				a.add(RiskHigh, "deletes every match under %s", strings.Join(roots, " "))
+			} else {
+				a.add(RiskMedium, "deletes every file it matches")
+			}
+		case "-exec", "-execdir", "-ok", "-okdir":
+			// Analyze the command run for each match
+			var words []string
+			for _, word := range args[i+1:] {
+				if word == ";" || word == "+" {
+					break
+				}
+				words = append(words, word)
+			}
+			if len(words) > 0 {
+				analyzeCommand(a, &simpleCommand{words: words, elevated: cmd.elevated, wrapper: cmd.wrapper})
+			}
+		}
+	}
+}
+
+// analyzeGit flags history rewrites and discarded work.
+func analyzeGit(a *Assessment, cmd *simpleCommand) {
+	args := cmd.args()
+	// Skip global options such as -C dir and -c key=value
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if (args[0] == "-C" || args[0] == "-c") && len(args) > 1 {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return
+	}
+	sub := &simpleCommand{words: args}
+
+	switch args[0] {
+	case "push":
+		switch {
+		case sub.hasFlag("f", "--force", "--mirror"):
+			a.add(RiskHigh, "force-pushes, which can discard commits others have pushed")
+		case sub.hasFlag("", "--force-with-lease", "--force-if-includes"):
+			a.add(RiskMedium, "force-pushes, rewriting the remote branch's history")
+		case sub.hasFlag("d", "--delete"):
+			a.add(RiskMedium, "deletes a remote branch")
+		}
+		for _, ref := range sub.operands() {
+			switch {
+			case strings.HasPrefix(ref, "+"):
+				a.add(RiskHigh, "force-pushes %s, which can discard commits others have pushed", ref[1:])
+			case strings.HasPrefix(ref, ":"):
+				a.add(RiskMedium, "deletes the remote branch %s", ref[1:])
+			}
+		}
+	case "reset":
+		if sub.hasFlag("", "--hard") {
+			a.add(RiskMedium, "discards uncommitted changes with reset --hard")
+		}
+	case "clean":
+		if sub.hasFlag("f", "--force") {
+			a.add(RiskMedium, "deletes untracked files")
+		}
+	case "checkout", "restore":
+		if containsWord(sub.operands(), ".") {
+			a.add(RiskLow, "discards uncommitted changes to the working tree")
+		}
+	case "branch":
+		if sub.hasFlag("D") {
+			a.add(RiskLow, "deletes a branch even if it is not merged")
+		}
+	case "filter-branch", "filter-repo":
+		a.add(RiskHigh, "rewrites the repository's history")
+	}
+}
+
+// analyzeKubectl flags deletions of Kubernetes resources.
+func analyzeKubectl(a *Assessment, cmd *simpleCommand) {
+	operands := cmd.operands()
+	if len(operands) == 0 || operands[0] != "delete" {
+		return
+	}
+	switch {
+	case cmd.hasFlag("A", "--all", "--all-namespaces"):
+		a.add(RiskHigh, "deletes Kubernetes resources in bulk")
+	case len(operands) > 1 && (operands[1] == "namespace" || operands[1] == "ns" || strings.HasPrefix(operands[1], "namespace/")):
+		a.add(RiskHigh, "deletes a Kubernetes namespace and everything in it")
+	default:
+		a.add(RiskMedium, "deletes Kubernetes resources")
+	}
+}
+
+// checkWrite flags writes to devices and system paths.
+func checkWrite(a *Assessment, name, target, verb string) {
+	switch {
+	case isDevice(target):
+		a.add(RiskCritical, "%s %s to the device %s", name, verb, target)
+	case isSystemPath(target):
+		a.add(RiskHigh, "%s %s %s, a system path", name, verb, target)
+	}
+}
+
+// systemDirs hold the operating system; writing to them can break it.
+var systemDirs = []string{
+	"/etc", "/boot", "/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64",
+	"/sys", "/proc", "/var/lib",
+}
+
+// isSystemPath reports whether path is in a system directory.
+func isSystemPath(path string) bool {
+	for _, dir := range systemDirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// safeDevices are device files that are safe to write to.
+var safeDevices = []string{
+	"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom",
+	"/dev/stdin", "/dev/stdout", "/dev/stderr", "/dev/tty", "/dev/fd/",
+	"/dev/pts/", "/dev/shm/", "/dev/ptmx",
+}
+
+// isDevice reports whether path is a device file that holds data, such as
+// a disk.
+func isDevice(path string) bool {
+	if !strings.HasPrefix(path, "/dev/") {
+		return false
+	}
+	for _, safe := range safeDevices {
+		if path == safe || (strings.HasSuffix(safe, "/") && strings.HasPrefix(path, safe)) {
+			return false
+		}
+	}
+	// /dev/tty1 and the like are terminals
+	return !strings.HasPrefix(path, "/dev/tty")
+}
+
+// isBroadPath reports whether deleting path would remove far more than a
+// project: the root, a home directory, a top-level directory or the
+// working directory itself.
+func isBroadPath(path string) bool {
+	trimmed := strings.TrimSuffix(path, "*")
+	trimmed = strings.TrimRight(trimmed, "/")
+	switch trimmed {
+	case "", "~", "$HOME", "${HOME}", ".", "..":
+		return path != ""
+	}
+	if !strings.HasPrefix(trimmed, "/") {
+		return false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(trimmed, "/"), "/")
+	if len(parts) == 1 {
+		return true
+	}
+	// A user's home directory
+	return len(parts) == 2 && (parts[0] == "home" || parts[0] == "Users" || parts[0] == "root")
+}
+
+// describePath names a broad path for a finding.
+func describePath(path string) string {
+	trimmed := strings.TrimRight(strings.TrimSuffix(path, "*"), "/")
+	switch {
+	case trimmed == "":
+		return "the whole filesystem (" + path + ")"
+	case trimmed == "~" || trimmed == "$HOME" || trimmed == "${HOME}":
+		return "your home directory (" + path + ")"
+	case trimmed == "." || trimmed == "..":
+		return "the current directory's contents (" + path + ")"
+	}
+	return path
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
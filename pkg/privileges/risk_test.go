@@ -0,0 +1,152 @@
+package privileges_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/privileges"
+)
+
+func TestAnalyzeCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		command string
+		level   privileges.RiskLevel
+		reason  string
+	}{
+		{"ls -la", privileges.RiskNone, ""},
+		{"git status && go test ./...", privileges.RiskNone, ""},
+		{"echo hi > /dev/null 2>&1", privileges.RiskNone, ""},
+		{"cat /etc/hosts", privileges.RiskNone, ""},
+		{"rm notes.txt", privileges.RiskLow, "deletes notes.txt"},
+		{"rm -rf build", privileges.RiskMedium, "recursively deletes build"},
+		{"rm -rf /", privileges.RiskCritical, "whole filesystem"},
+		{"rm -rf ~", privileges.RiskCritical, "home directory"},
+		{"rm -r -f /usr", privileges.RiskCritical, "recursively deletes /usr"},
+		{"rm -rf $HOME/", privileges.RiskCritical, "home directory"},
+		{"rm -rf ./*", privileges.RiskCritical, "current directory"},
+		{"cd /tmp && sudo rm -rf /*", privileges.RiskCritical, "whole filesystem"},
+		{"rm --no-preserve-root -rf x", privileges.RiskCritical, "--no-preserve-root"},
+		{"find . -name '*.o' | xargs rm -rf", privileges.RiskMedium, "paths it is given"},
+		{`sh -c "rm -rf /"`, privileges.RiskCritical, "whole filesystem"},
+		{"echo $(rm -rf ~)", privileges.RiskCritical, "home directory"},
+		{"dd if=image.iso of=/dev/sdb bs=4M", privileges.RiskCritical, "/dev/sdb"},
+		{"dd if=/dev/zero of=disk.img bs=1M count=10", privileges.RiskNone, ""},
+		{"cat image > /dev/nvme0n1", privileges.RiskCritical, "/dev/nvme0n1"},
+		{"mkfs.ext4 /dev/sda1", privileges.RiskCritical, "formats /dev/sda1"},
+		{"chmod -R 777 .", privileges.RiskCritical, "recursively changes the permissions"},
+		{"chmod -R 777 public", privileges.RiskHigh, "writable by every user"},
+		{"chmod o+w notes.txt", privileges.RiskMedium, "writable by every user"},
+		{"chmod 755 script.sh", privileges.RiskNone, ""},
+		{"chown -R me /", privileges.RiskCritical, "ownership"},
+		{"git push --force origin main", privileges.RiskHigh, "force-pushes"},
+		{"git push -f", privileges.RiskHigh, "force-pushes"},
+		{"git push origin +main", privileges.RiskHigh, "force-pushes main"},
+		{"git push --force-with-lease", privileges.RiskMedium, "force-pushes"},
+		{"git push origin main", privileges.RiskNone, ""},
+		{"git -C repo reset --hard HEAD~1", privileges.RiskMedium, "reset --hard"},
+		{"git clean -fdx", privileges.RiskMedium, "untracked files"},
+		{"curl -fsSL https://example.com/install.sh | sh", privileges.RiskHigh, "pipes a download from curl into sh"},
+		{"wget -qO- https://example.com/x | sudo bash", privileges.RiskHigh, "into bash"},
+		{`bash -c "$(curl -fsSL https://example.com/install.sh)"`, privileges.RiskHigh, "downloaded script"},
+		{"bash <(curl -s https://example.com/x)", privileges.RiskHigh, "downloaded script"},
+		{"curl -s https://example.com | jq .", privileges.RiskNone, ""},
+		{"echo 127.0.0.1 foo | sudo tee -a /etc/hosts", privileges.RiskHigh, "/etc/hosts, a system path"},
+		{"echo nameserver 1.1.1.1 > /etc/resolv.conf", privileges.RiskHigh, "/etc/resolv.conf"},
+		{"sudo sed -i 's/a/b/' /etc/ssh/sshd_config", privileges.RiskHigh, "edits /etc/ssh/sshd_config"},
+		{"sudo cp app.conf /etc/nginx/conf.d/", privileges.RiskHigh, "/etc/nginx/conf.d/"},
+		{"sudo apt update", privileges.RiskLow, "runs apt as root via sudo"},
+		{"find / -name '*.log' -delete", privileges.RiskHigh, "every match under /"},
+		{"find . -type f -exec rm -rf {} +", privileges.RiskMedium, "recursively deletes {}"},
+		{":(){ :|:& };:", privileges.RiskCritical, "fork bomb"},
+		{"kill -9 -1", privileges.RiskHigh, "every process"},
+		{"crontab -r", privileges.RiskHigh, "cron jobs"},
+		{"kubectl delete namespace prod", privileges.RiskHigh, "namespace"},
+		{"shutdown -h now", privileges.RiskHigh, "shuts down"},
+		{"mv important.db /dev/null", privileges.RiskHigh, "/dev/null"},
+		{"echo 'rm -rf /'", privileges.RiskNone, ""},
+		{"grep -r 'rm -rf /' .", privileges.RiskNone, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			t.Parallel()
+			a := privileges.AnalyzeCommand(tt.command)
+			if a.Level != tt.level {
+				t.Errorf("level = %v, want %v (reasons: %q)", a.Level, tt.level, a.Reasons())
+			}
+			if tt.reason == "" {
+				return
+			}
+			if len(a.Findings) == 0 || !strings.Contains(a.Findings[0].Reason, tt.reason) {
+				t.Errorf("top reason of %q does not mention %q", a.Reasons(), tt.reason)
+			}
+		})
+	}
+}
+
+func TestAssessmentNeedsConfirmation(t *testing.T) {
+	t.Parallel()
+
+	medium := privileges.AnalyzeCommand("rm -rf build")
+	if !medium.NeedsConfirmation(privileges.RiskMedium) {
+		t.Error("a medium risk command should need confirmation at medium")
+	}
+	if medium.NeedsConfirmation(privileges.RiskHigh) {
+		t.Error("a medium risk command should not need confirmation at high")
+	}
+	if privileges.AnalyzeCommand("ls").NeedsConfirmation(privileges.RiskNone) {
+		t.Error("a command without findings should never need confirmation")
+	}
+}
+
+func TestAssessmentEscalate(t *testing.T) {
+	t.Parallel()
+
+	medium := privileges.AnalyzeCommand("rm -rf build")
+	medium.Escalate("runs on every host of web")
+	if medium.Level != privileges.RiskHigh {
+		t.Errorf("escalated level = %s, want high", medium.Level)
+	}
+	if reasons := medium.Reasons(); reasons[len(reasons)-1] != "runs on every host of web" {
+		t.Errorf("expected the reason to be added, got %q", reasons)
+	}
+	for _, f := range medium.Findings {
+		if f.Level != privileges.RiskHigh {
+			t.Errorf("finding %q at %s, want high", f.Reason, f.Level)
+		}
+	}
+
+	critical := privileges.AnalyzeCommand("rm -rf /")
+	critical.Escalate("runs on every host of web")
+	if critical.Level != privileges.RiskCritical {
+		t.Errorf("escalated level = %s, want critical", critical.Level)
+	}
+
+	none := privileges.AnalyzeCommand("uptime")
+	none.Escalate("runs on every host of web")
+	if none.Level != privileges.RiskLow || len(none.Findings) != 1 {
+		t.Errorf("escalated harmless command = %+v, want low", none)
+	}
+}
+
+func TestRiskLevelText(t *testing.T) {
+	t.Parallel()
+
+	for level := privileges.RiskNone; level <= privileges.RiskCritical; level++ {
+		text, err := level.MarshalText()
+		if err != nil {
+			t.Fatalf("MarshalText(%d) error: %v", level, err)
+		}
+		var got privileges.RiskLevel
+		if err := got.UnmarshalText(text); err != nil || got != level {
+			t.Errorf("UnmarshalText(%q) = %v, %v; want %v", text, got, err, level)
+		}
+	}
+
+	var level privileges.RiskLevel
+	if err := level.UnmarshalText([]byte("severe")); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -43,20 +44,40 @@ func (s JobState) String() string {
 	}
 }
 
+// maxJobOutput is the number of bytes of output kept for each job.
+const maxJobOutput = 1 << 20
+
+// externalJobPollInterval is how often adopted jobs are checked for exit.
+const externalJobPollInterval = 250 * time.Millisecond
+
 // Job represents a background job.
 type Job struct {
-	ID          int
-	Command     string
-	State       JobState
-	PID         int
-	StartTime   time.Time
-	EndTime     time.Time
-	ExitCode    int
-	Background  bool
+	ID         int
+	Command    string
+	State      JobState
+	PID        int
+	StartTime  time.Time
+	EndTime    time.Time
+	ExitCode   int
+	Background bool
+	// External is set for jobs adopted from another shell, whose process
+	// cbwsh did not start and whose output it does not capture.
+	External    bool
 	cmd         *exec.Cmd
+	pgid        int
+	output      *jobOutput
 	mu          sync.RWMutex
 	cancel      context.CancelFunc
 	stoppedChan chan struct{}
+	stopOnce    sync.Once
+}
+
+// JobOptions configures how a job's process is started.
+type JobOptions struct {
+	// Dir is the working directory; empty means the current directory.
+	Dir string
+	// Env is the process environment; nil means the current environment.
+	Env []string
 }
 
 // GetState returns the current job state.
@@ -102,6 +123,50 @@ func (j *Job) Done() <-chan struct{} {
 	return j.stoppedChan
 }
 
+// Output returns the captured stdout and stderr of the job. Only the most
+// recent output is kept once it exceeds 1 MiB.
+func (j *Job) Output() string {
+	out, _ := j.OutputSince(0)
+	return out
+}
+
+// OutputSince returns output written after the given offset along with the
+// offset to pass on the next call, so callers can follow a job's output.
+func (j *Job) OutputSince(offset int64) (string, int64) {
+	if j.output == nil {
+		return "", offset
+	}
+	return j.output.since(offset)
+}
+
+// finish records the final state of the job and closes its Done channel.
+func (j *Job) finish(state JobState, exitCode int) {
+	j.mu.Lock()
+	j.State = state
+	j.ExitCode = exitCode
+	if j.EndTime.IsZero() {
+		j.EndTime = time.Now()
+	}
+	j.mu.Unlock()
+
+	j.stopOnce.Do(func() { close(j.stoppedChan) })
+}
+
+// signal sends sig to the job's process group.
+func (j *Job) signal(sig syscall.Signal) error {
+	j.mu.RLock()
+	pgid := j.pgid
+	j.mu.RUnlock()
+
+	if pgid > 0 {
+		return syscall.Kill(-pgid, sig)
+	}
+	if j.cmd != nil && j.cmd.Process != nil {
+		return j.cmd.Process.Signal(sig)
+	}
+	return fmt.Errorf("job %d has no process", j.ID)
+}
+
 // Duration returns how long the job has been running.
 func (j *Job) Duration() time.Duration {
 	j.mu.RLock()
@@ -140,25 +205,31 @@ func NewJobManager(maxJobs int) *JobManager {
 
 // StartJob starts a new background job.
 func (m *JobManager) StartJob(ctx context.Context, command string, shell string) (*Job, error) {
+	return m.StartJobWithOptions(ctx, command, shell, JobOptions{})
+}
+
+// StartJobWithOptions starts a new background job in its own process group.
+// The job's stdout and stderr are captured and available from Job.Output.
+func (m *JobManager) StartJobWithOptions(ctx context.Context, command string, shell string, opts JobOptions) (*Job, error) {
 	m.mu.Lock()
 
-	// Check if we've reached the maximum number of jobs
-	activeJobs := 0
-	for _, job := range m.jobs {
-		if job.GetState() == JobStateRunning || job.GetState() == JobStateStopped {
-			activeJobs++
-		}
-	}
-	if activeJobs >= m.maxJobs {
+	if err := m.checkCapacityLocked(); err != nil {
 		m.mu.Unlock()
-		return nil, errors.New("maximum number of jobs reached")
+		return nil, err
 	}
 
 	jobID := int(m.nextJobID.Add(1))
 
 	jobCtx, cancel := context.WithCancel(ctx)
 
+	output := &jobOutput{}
 	cmd := exec.CommandContext(jobCtx, shell, "-c", command)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Don't let a daemon holding the output pipe keep the job alive
+	cmd.WaitDelay = time.Second
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true, // Create a new process group
 	}
@@ -170,6 +241,7 @@ func (m *JobManager) StartJob(ctx context.Context, command string, shell string)
 		StartTime:   time.Now(),
 		Background:  true,
 		cmd:         cmd,
+		output:      output,
 		cancel:      cancel,
 		stoppedChan: make(chan struct{}),
 	}
@@ -187,6 +259,7 @@ func (m *JobManager) StartJob(ctx context.Context, command string, shell string)
 
 	job.mu.Lock()
 	job.PID = cmd.Process.Pid
+	job.pgid = cmd.Process.Pid
 	job.mu.Unlock()
 
 	// Monitor job in background
@@ -195,36 +268,105 @@ func (m *JobManager) StartJob(ctx context.Context, command string, shell string)
 	return job, nil
 }
 
-func (m *JobManager) monitorJob(job *Job) {
-	defer close(job.stoppedChan)
+// AdoptJob registers a stopped process group that was started by another
+// shell, such as a command suspended with Ctrl+Z in a pane's session. The
+// job can be signalled like any other; since cbwsh cannot wait on the
+// process, its exit is detected by polling and reported with exit code 0
+// unless the owner supplies the real status through FinishJob.
+func (m *JobManager) AdoptJob(command string, pgid int) (*Job, error) {
+	if pgid <= 0 {
+		return nil, fmt.Errorf("invalid process group %d", pgid)
+	}
 
-	err := job.cmd.Wait()
+	m.mu.Lock()
+	if err := m.checkCapacityLocked(); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
 
-	job.mu.Lock()
-	defer job.mu.Unlock()
+	job := &Job{
+		ID:          int(m.nextJobID.Add(1)),
+		Command:     command,
+		State:       JobStateStopped,
+		PID:         pgid,
+		StartTime:   time.Now(),
+		External:    true,
+		pgid:        pgid,
+		stoppedChan: make(chan struct{}),
+	}
+	m.jobs[job.ID] = job
+	m.mu.Unlock()
+
+	go m.monitorExternalJob(job)
+
+	return job, nil
+}
+
+// FinishJob records the exit status of an adopted job reported by the
+// shell that owns it.
+func (m *JobManager) FinishJob(jobID int, exitCode int) error {
+	job, exists := m.GetJob(jobID)
+	if !exists {
+		return fmt.Errorf("job %d not found", jobID)
+	}
+
+	state := JobStateCompleted
+	if exitCode != 0 {
+		state = JobStateFailed
+	}
+	job.finish(state, exitCode)
+	return nil
+}
+
+func (m *JobManager) checkCapacityLocked() error {
+	// Check if we've reached the maximum number of jobs
+	activeJobs := 0
+	for _, job := range m.jobs {
+		if job.GetState() == JobStateRunning || job.GetState() == JobStateStopped {
+			activeJobs++
+		}
+	}
+	if activeJobs >= m.maxJobs {
+		return errors.New("maximum number of jobs reached")
+	}
+	return nil
+}
+
+func (m *JobManager) monitorJob(job *Job) {
+	err := job.cmd.Wait()
 
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			job.ExitCode = exitErr.ExitCode()
-			// Check if process was killed by a signal
-			// In Unix, processes killed by signals typically have exit codes > 128
-			// or may be represented as -1 depending on the Go version
-			if exitErr.ExitCode() > 128 || exitErr.ExitCode() == -1 {
-				// Process was killed by a signal
-				job.State = JobStateFailed
-			} else {
-				job.State = JobStateFailed
+			// Report processes killed by a signal as 128+N, as shells do
+			exitCode := exitErr.ExitCode()
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				exitCode = 128 + int(status.Signal())
 			}
+			job.finish(JobStateFailed, exitCode)
 		} else {
-			job.State = JobStateFailed
-			job.ExitCode = -1
+			job.finish(JobStateFailed, -1)
 		}
 	} else {
-		job.State = JobStateCompleted
-		job.ExitCode = 0
+		job.finish(JobStateCompleted, 0)
 	}
+}
 
-	job.EndTime = time.Now()
+func (m *JobManager) monitorExternalJob(job *Job) {
+	ticker := time.NewTicker(externalJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-job.Done():
+			return
+		case <-ticker.C:
+			// The group disappears once its owner has reaped the last process
+			if err := syscall.Kill(-job.pgid, 0); errors.Is(err, syscall.ESRCH) {
+				job.finish(JobStateCompleted, 0)
+				return
+			}
+		}
+	}
 }
 
 // StopJob stops a running job.
@@ -243,12 +385,7 @@ func (m *JobManager) StopJob(jobID int) error {
 	}
 
 	// Send SIGSTOP to the process group
-	if job.cmd.Process != nil {
-		pgid, err := syscall.Getpgid(job.cmd.Process.Pid)
-		if err == nil {
-			_ = syscall.Kill(-pgid, syscall.SIGSTOP)
-		}
-	}
+	_ = job.signal(syscall.SIGSTOP)
 
 	job.SetState(JobStateStopped)
 	return nil
@@ -270,12 +407,7 @@ func (m *JobManager) ContinueJob(jobID int) error {
 	}
 
 	// Send SIGCONT to the process group
-	if job.cmd.Process != nil {
-		pgid, err := syscall.Getpgid(job.cmd.Process.Pid)
-		if err == nil {
-			_ = syscall.Kill(-pgid, syscall.SIGCONT)
-		}
-	}
+	_ = job.signal(syscall.SIGCONT)
 
 	job.SetState(JobStateRunning)
 	return nil
@@ -301,16 +433,39 @@ func (m *JobManager) KillJob(jobID int) error {
 		job.cancel()
 	}
 
-	if job.cmd.Process != nil {
-		// Kill the entire process group
-		pgid, err := syscall.Getpgid(job.cmd.Process.Pid)
-		if err == nil {
-			_ = syscall.Kill(-pgid, syscall.SIGKILL)
-		} else {
-			_ = job.cmd.Process.Kill()
-		}
+	// Kill the entire process group
+	_ = job.signal(syscall.SIGKILL)
+
+	return nil
+}
+
+// SignalJob sends sig to a job's process group. As with the kill builtin
+// of POSIX shells, a stopped job is continued after the signal so that it
+// can act on it.
+func (m *JobManager) SignalJob(jobID int, sig syscall.Signal) error {
+	job, exists := m.GetJob(jobID)
+	if !exists {
+		return fmt.Errorf("job %d not found", jobID)
 	}
 
+	state := job.GetState()
+	if state == JobStateCompleted || state == JobStateFailed {
+		return fmt.Errorf("job %d has already finished", jobID)
+	}
+
+	if err := job.signal(sig); err != nil {
+		return fmt.Errorf("failed to signal job %d: %w", jobID, err)
+	}
+
+	switch {
+	case sig == syscall.SIGSTOP || sig == syscall.SIGTSTP:
+		job.SetState(JobStateStopped)
+	case sig == syscall.SIGCONT:
+		job.SetState(JobStateRunning)
+	case state == JobStateStopped:
+		_ = job.signal(syscall.SIGCONT)
+		job.SetState(JobStateRunning)
+	}
 	return nil
 }
 
@@ -331,6 +486,7 @@ func (m *JobManager) ListJobs() []*Job {
 	for _, job := range m.jobs {
 		result = append(result, job)
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
 	return result
 }
 
@@ -346,6 +502,7 @@ func (m *JobManager) ListActiveJobs() []*Job {
 			result = append(result, job)
 		}
 	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
 	return result
 }
 
@@ -387,6 +544,40 @@ func (m *JobManager) WaitForJob(jobID int, timeout time.Duration) error {
 	}
 }
 
+// jobOutput collects a job's output, keeping the most recent maxJobOutput
+// bytes.
+type jobOutput struct {
+	mu    sync.Mutex
+	buf   []byte
+	total int64 // bytes written over the job's lifetime
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.buf = append(o.buf, p...)
+	o.total += int64(len(p))
+	if len(o.buf) > maxJobOutput {
+		o.buf = append(o.buf[:0:0], o.buf[len(o.buf)-maxJobOutput:]...)
+	}
+	return len(p), nil
+}
+
+func (o *jobOutput) since(offset int64) (string, int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	start := offset - (o.total - int64(len(o.buf)))
+	if start < 0 {
+		start = 0
+	}
+	if start > int64(len(o.buf)) {
+		start = int64(len(o.buf))
+	}
+	return string(o.buf[start:]), o.total
+}
+
 // ProcessInfo holds information about a process.
 type ProcessInfo struct {
 	PID        int
@@ -2,6 +2,10 @@ package process_test
 
 import (
 	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -262,3 +266,147 @@ func TestCleanupCompleted(t *testing.T) {
 		t.Errorf("expected 0 jobs after cleanup, got %d", len(jobs))
 	}
 }
+
+func TestJobOutputCapture(t *testing.T) {
+	t.Parallel()
+
+	manager := process.NewJobManager(10)
+	dir := t.TempDir()
+
+	job, err := manager.StartJobWithOptions(context.Background(), "pwd; echo \"$JOB_VAR\"; echo oops >&2", "/bin/bash", process.JobOptions{
+		Dir: dir,
+		Env: append(os.Environ(), "JOB_VAR=set"),
+	})
+	if err != nil {
+		t.Fatalf("failed to start job: %v", err)
+	}
+
+	if err := manager.WaitForJob(job.ID, 5*time.Second); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+
+	expected := dir + "\nset\noops\n"
+	if job.Output() != expected {
+		t.Errorf("expected output %q, got %q", expected, job.Output())
+	}
+
+	rest, next := job.OutputSince(int64(len(dir) + 1))
+	if rest != "set\noops\n" || next != int64(len(expected)) {
+		t.Errorf("unexpected output since offset: %q, %d", rest, next)
+	}
+}
+
+func TestJobManagerSignalJob(t *testing.T) {
+	t.Parallel()
+
+	manager := process.NewJobManager(10)
+
+	job, err := manager.StartJob(context.Background(), "trap 'echo term; exit 3' TERM; while :; do sleep 0.05; done", "/bin/bash")
+	if err != nil {
+		t.Fatalf("failed to start job: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := manager.SignalJob(job.ID, syscall.SIGSTOP); err != nil {
+		t.Fatalf("failed to stop job: %v", err)
+	}
+	if job.GetState() != process.JobStateStopped {
+		t.Errorf("expected stopped job, got %s", job.GetState())
+	}
+
+	// A stopped job is continued so it can handle the signal
+	if err := manager.SignalJob(job.ID, syscall.SIGTERM); err != nil {
+		t.Fatalf("failed to signal job: %v", err)
+	}
+	if err := manager.WaitForJob(job.ID, 5*time.Second); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if job.GetExitCode() != 3 || !strings.HasSuffix(job.Output(), "term\n") {
+		t.Errorf("expected job to handle SIGTERM, got exit %d and output %q", job.GetExitCode(), job.Output())
+	}
+
+	if err := manager.SignalJob(job.ID, syscall.SIGTERM); err == nil {
+		t.Error("expected error signalling finished job")
+	}
+}
+
+func TestJobManagerAdoptJob(t *testing.T) {
+	t.Parallel()
+
+	cmd := exec.Command("/bin/sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start process: %v", err)
+	}
+	go func() { _ = cmd.Wait() }()
+
+	manager := process.NewJobManager(10)
+	job, err := manager.AdoptJob("sleep 10", cmd.Process.Pid)
+	if err != nil {
+		t.Fatalf("failed to adopt job: %v", err)
+	}
+	if !job.External || job.GetState() != process.JobStateStopped {
+		t.Errorf("expected stopped external job, got %+v", job)
+	}
+
+	if err := manager.KillJob(job.ID); err != nil {
+		t.Fatalf("failed to kill job: %v", err)
+	}
+	if err := manager.WaitForJob(job.ID, 5*time.Second); err != nil {
+		t.Fatalf("expected adopted job to be detected as finished: %v", err)
+	}
+	if job.GetState() != process.JobStateCompleted {
+		t.Errorf("expected completed job, got %s", job.GetState())
+	}
+
+	if _, err := manager.AdoptJob("bad", 0); err == nil {
+		t.Error("expected error adopting invalid process group")
+	}
+}
+
+func TestJobManagerFinishJob(t *testing.T) {
+	t.Parallel()
+
+	manager := process.NewJobManager(10)
+	job, err := manager.AdoptJob("sleep", os.Getpid())
+	if err != nil {
+		t.Fatalf("failed to adopt job: %v", err)
+	}
+
+	if err := manager.FinishJob(job.ID, 2); err != nil {
+		t.Fatalf("finish failed: %v", err)
+	}
+	select {
+	case <-job.Done():
+	default:
+		t.Fatal("expected job to be done")
+	}
+	if job.GetState() != process.JobStateFailed || job.GetExitCode() != 2 {
+		t.Errorf("expected failed job with exit code 2, got %s %d", job.GetState(), job.GetExitCode())
+	}
+
+	if err := manager.FinishJob(999, 0); err == nil {
+		t.Error("expected error for unknown job")
+	}
+}
+
+func TestJobExitCodeForSignal(t *testing.T) {
+	t.Parallel()
+
+	manager := process.NewJobManager(10)
+	job, err := manager.StartJob(context.Background(), "exec sleep 10", "/bin/bash")
+	if err != nil {
+		t.Fatalf("failed to start job: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := manager.KillJob(job.ID); err != nil {
+		t.Fatalf("failed to kill job: %v", err)
+	}
+	if err := manager.WaitForJob(job.ID, 5*time.Second); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if job.GetExitCode() != 128+int(syscall.SIGKILL) {
+		t.Errorf("expected exit code %d, got %d", 128+int(syscall.SIGKILL), job.GetExitCode())
+	}
+}
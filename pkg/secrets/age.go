@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// parseAgeIdentities parses the X25519 identities of an identity file, as
+// written by age-keygen: one per line, with # comments and blank lines.
+func parseAgeIdentities(data []byte) ([]*age.X25519Identity, error) {
+	parsed, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read age identities: %w", err)
+	}
+	identities := make([]*age.X25519Identity, 0, len(parsed))
+	for _, identity := range parsed {
+		x25519, ok := identity.(*age.X25519Identity)
+		if !ok {
+			return nil, errors.New("only X25519 age identities are supported")
+		}
+		identities = append(identities, x25519)
+	}
+	return identities, nil
+}
+
+// formatAgeIdentities writes identities in the form parseAgeIdentities
+// reads.
+func formatAgeIdentities(identities []*age.X25519Identity) []byte {
+	var b strings.Builder
+	for _, identity := range identities {
+		b.WriteString("# public key: " + identity.Recipient().String() + "\n")
+		b.WriteString(identity.String() + "\n")
+	}
+	return []byte(b.String())
+}
+
+// ageEncrypt encrypts plaintext to recipients in memory.
+func ageEncrypt(plaintext []byte, recipients ...age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("age encryption failed: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("age encryption failed: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("age encryption failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ageDecrypt decrypts ciphertext encrypted to one of identities in memory.
+func ageDecrypt(ciphertext []byte, identities ...age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), identities...)
+	if err != nil {
+		return nil, fmt.Errorf("age decryption failed: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("age decryption failed: %w", err)
+	}
+	return plaintext, nil
+}
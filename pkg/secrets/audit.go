@@ -0,0 +1,195 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	AuditRetrieve = "retrieve"
+	AuditStore    = "store"
+	AuditDelete   = "delete"
+)
+
+// AuditEntry records an access to a secret.
+type AuditEntry struct {
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Key    string    `json:"key"`
+	Caller string    `json:"caller,omitempty"` // The command or subsystem asking, as given to Manager.For
+	Error  string    `json:"error,omitempty"`
+	Prev   string    `json:"prev"` // Hash of the previous entry
+	Hash   string    `json:"hash"`
+}
+
+// hash returns the hash chaining the entry to the previous one: the
+// SHA-256 of the entry without its own hash.
+func (e AuditEntry) hash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditLog is an append-only log of the accesses to secrets, one JSON
+// entry per line. Values are never logged.
+//
+// Each entry holds the hash of the one before it, so an entry edited or
+// removed by hand, or by a tool unaware of the chain, breaks it, which
+// ReadAuditLog reports. The hashes are not keyed: whoever can write the
+// file can also rewrite the chain, so the log is a record of accesses,
+// not proof against deliberate tampering.
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// OpenAuditLog opens the audit log at path, creating it if need be, to
+// append to it.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &AuditLog{path: path}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := lastAuditEntry(f); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return &AuditLog{path: path}, nil
+}
+
+// Path returns the path of the log file.
+func (l *AuditLog) Path() string {
+	return l.path
+}
+
+// Record appends an entry for the operation op on the secret key by
+// caller, which failed with opErr if not nil. The file stays locked from
+// reading the last entry to syncing the new one, so instances sharing the
+// log chain their entries in turn.
+func (l *AuditLog) Record(op, key, caller string, opErr error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	defer func() { _ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }()
+
+	last, err := lastAuditEntry(f)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	entry := AuditEntry{
+		Seq:    last.Seq + 1,
+		Time:   time.Now().UTC(),
+		Op:     op,
+		Key:    key,
+		Caller: caller,
+		Prev:   last.Hash,
+	}
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+	entry.Hash = entry.hash()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+// lastAuditEntry reads the last entry of the log open in f, reading
+// backwards from its end; an empty log has a zero entry.
+func lastAuditEntry(f *os.File) (AuditEntry, error) {
+	var entry AuditEntry
+	info, err := f.Stat()
+	if err != nil {
+		return entry, err
+	}
+	size := info.Size()
+	for n := int64(4096); ; n *= 2 {
+		n = min(n, size)
+		buf := make([]byte, n)
+		if _, err := f.ReadAt(buf, size-n); err != nil {
+			return entry, err
+		}
+		buf = bytes.TrimRight(buf, "\n")
+		start := bytes.LastIndexByte(buf, '\n')
+		if start < 0 && n < size {
+			continue
+		}
+		if line := buf[start+1:]; len(line) > 0 {
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return entry, err
+			}
+		}
+		return entry, nil
+	}
+}
+
+// ReadAuditLog reads the audit log at path and checks its chain. It
+// returns the entries up to the first one that was tampered with, and an
+// error naming it.
+func ReadAuditLog(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	prev := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return entries, fmt.Errorf("audit log line %d is corrupt: %w", line, err)
+		}
+		switch {
+		case entry.Hash != entry.hash():
+			return entries, fmt.Errorf("audit log entry %d was altered", entry.Seq)
+		case entry.Prev != prev || entry.Seq != int64(line):
+			return entries, fmt.Errorf("audit log entries before %d were removed or altered", entry.Seq)
+		}
+		entries = append(entries, entry)
+		prev = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
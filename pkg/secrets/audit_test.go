@@ -0,0 +1,128 @@
+package secrets_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	log, err := secrets.OpenAuditLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := secrets.NewManager(filepath.Join(dir, "secrets.enc"))
+	manager.SetAuditLog(log)
+	if err := manager.Initialize("test-password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.For("test setup").Store("token", []byte("s3cret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.For("with-secrets: deploy").Retrieve("token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Retrieve("missing"); err == nil {
+		t.Fatal("expected a missing secret to fail")
+	}
+
+	// A reopened log continues the chain
+	reopened, err := secrets.OpenAuditLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetAuditLog(reopened)
+	if err := manager.For("cleanup").Delete("token"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := secrets.ReadAuditLog(logPath)
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error = %v", err)
+	}
+	want := []secrets.AuditEntry{
+		{Op: secrets.AuditStore, Key: "token", Caller: "test setup"},
+		{Op: secrets.AuditRetrieve, Key: "token", Caller: "with-secrets: deploy"},
+		{Op: secrets.AuditRetrieve, Key: "missing", Error: "secret not found: missing"},
+		{Op: secrets.AuditDelete, Key: "token", Caller: "cleanup"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Op != want[i].Op || entry.Key != want[i].Key || entry.Caller != want[i].Caller || entry.Error != want[i].Error {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, want[i])
+		}
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("s3cret")) {
+		t.Error("the audit log holds a secret value")
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	tampered := map[string][]byte{
+		"edited":  bytes.Replace(data, []byte("with-secrets: deploy"), []byte("with-secrets: other!"), 1),
+		"removed": bytes.Join(append([][]byte{lines[0]}, lines[2:]...), nil),
+	}
+	for name, data := range tampered {
+		path := filepath.Join(dir, name+".log")
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := secrets.ReadAuditLog(path)
+		if err == nil {
+			t.Errorf("expected the %s entry to be detected", name)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected the entries before the %s one, got %d", name, len(entries))
+		}
+	}
+}
+
+func TestAuditLogSharedByInstances(t *testing.T) {
+	t.Parallel()
+
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	const instances, records = 4, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, instances*records)
+	for range instances {
+		// Each instance opens the log before the others write to it
+		log, err := secrets.OpenAuditLog(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range records {
+				errs <- log.Record(secrets.AuditRetrieve, "token", "", nil)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	entries, err := secrets.ReadAuditLog(logPath)
+	if err != nil || len(entries) != instances*records {
+		t.Errorf("ReadAuditLog() = %d entries, %v, want %d chained entries", len(entries), err, instances*records)
+	}
+}
@@ -0,0 +1,180 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// BundleVersion is the version of the bundles ExportBundle writes.
+const BundleVersion = 1
+
+// BundleStore is a store secrets are exported from or imported into:
+// a Manager, an ExtendedManager of any backend, or an Accessor.
+type BundleStore interface {
+	List() ([]string, error)
+	Retrieve(key string) ([]byte, error)
+	Store(key string, value []byte) error
+}
+
+// metadataStore is a BundleStore keeping metadata, which bundles carry.
+type metadataStore interface {
+	AllMetadata() (map[string]Metadata, error)
+	importMetadata(key string, md Metadata) error
+}
+
+// bundle is the content of a bundle before it is encrypted.
+type bundle struct {
+	Version  int                     `json:"version"`
+	Exported time.Time               `json:"exported"`
+	Secrets  map[string]bundleSecret `json:"secrets"`
+}
+
+type bundleSecret struct {
+	Value    []byte    `json:"value"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// ExportBundle writes the secrets keys of store, or all of them if none
+// are given, with their metadata to w as a bundle: an ASCII-armored age
+// file encrypted with passphrase, which ImportBundle reads into a store of
+// any backend, on this machine or another. The age command decrypts it
+// too. It returns the keys exported.
+func ExportBundle(w io.Writer, store BundleStore, passphrase string, keys ...string) ([]string, error) {
+	if passphrase == "" {
+		return nil, errors.New("a bundle needs a passphrase")
+	}
+	if len(keys) == 0 {
+		all, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+		keys = all
+	}
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	var metadata map[string]Metadata
+	if ms, ok := store.(metadataStore); ok {
+		all, err := ms.AllMetadata()
+		if err != nil {
+			return nil, err
+		}
+		metadata = all
+	}
+
+	content := bundle{Version: BundleVersion, Exported: time.Now().UTC(), Secrets: make(map[string]bundleSecret, len(keys))}
+	defer func() {
+		for _, secret := range content.Secrets {
+			zero(secret.Value)
+		}
+	}()
+	for _, key := range keys {
+		value, err := store.Retrieve(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", key, err)
+		}
+		secret := bundleSecret{Value: value}
+		if md, ok := metadata[key]; ok {
+			secret.Metadata = &md
+		}
+		content.Secrets[key] = secret
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(data)
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	armored := armor.NewWriter(w)
+	encrypted, err := age.Encrypt(armored, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt bundle: %w", err)
+	}
+	if _, err := encrypted.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := encrypted.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := armored.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return keys, nil
+}
+
+// ImportBundle reads a bundle ExportBundle wrote, encrypted with
+// passphrase, into store. Secrets store already holds are skipped unless
+// replace is set. It returns the keys imported and those skipped.
+func ImportBundle(r io.Reader, store BundleStore, passphrase string, replace bool) (imported, skipped []string, err error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	decrypted, err := age.Decrypt(armor.NewReader(r), identity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt bundle: %w", err)
+	}
+	var data bytes.Buffer
+	if _, err := io.Copy(&data, decrypted); err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt bundle: %w", err)
+	}
+	defer zero(data.Bytes())
+
+	var content bundle
+	if err := json.Unmarshal(data.Bytes(), &content); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+	defer func() {
+		for _, secret := range content.Secrets {
+			zero(secret.Value)
+		}
+	}()
+	if content.Version > BundleVersion {
+		return nil, nil, fmt.Errorf("bundle version %d is newer than this version of cbwsh supports", content.Version)
+	}
+
+	existing, err := store.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, key := range existing {
+		exists[key] = true
+	}
+	ms, hasMetadata := store.(metadataStore)
+
+	keys := make([]string, 0, len(content.Secrets))
+	for key := range content.Secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if exists[key] && !replace {
+			skipped = append(skipped, key)
+			continue
+		}
+		secret := content.Secrets[key]
+		if err := store.Store(key, secret.Value); err != nil {
+			return imported, skipped, fmt.Errorf("failed to import %s: %w", key, err)
+		}
+		if hasMetadata && secret.Metadata != nil {
+			if err := ms.importMetadata(key, *secret.Metadata); err != nil {
+				return imported, skipped, fmt.Errorf("failed to import %s: %w", key, err)
+			}
+		}
+		imported = append(imported, key)
+	}
+	return imported, skipped, nil
+}
@@ -0,0 +1,81 @@
+package secrets_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+func TestBundles(t *testing.T) {
+	source := secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	if err := source.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"github/token": "gh", "aws/key": "aws", "db": "pw"} {
+		if err := source.Store(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	md := secrets.Metadata{Description: "CI token", RotateEvery: 24 * time.Hour}
+	if err := source.SetMetadata("github/token", md); err != nil {
+		t.Fatal(err)
+	}
+	created, _ := source.AllMetadata()
+
+	var bundle bytes.Buffer
+	if _, err := secrets.ExportBundle(&bundle, source, ""); err == nil {
+		t.Error("expected a bundle without a passphrase to be refused")
+	}
+	keys, err := secrets.ExportBundle(&bundle, source.For("export"), "bundle pass", "github/token", "aws/key")
+	if err != nil || !slices.Equal(keys, []string{"aws/key", "github/token"}) {
+		t.Fatalf("ExportBundle() = %v, %v", keys, err)
+	}
+	if !strings.HasPrefix(bundle.String(), "-----BEGIN AGE ENCRYPTED FILE-----") || strings.Contains(bundle.String(), "CI token") {
+		t.Error("expected an armored, encrypted bundle")
+	}
+	data := bundle.Bytes()
+
+	// Into the age backend, keeping the secrets it has
+	target, _ := newBackendManager(t, secrets.BackendAge)
+	if _, _, err := secrets.ImportBundle(bytes.NewReader(data), target, "wrong", false); err == nil {
+		t.Error("expected a wrong passphrase to fail")
+	}
+	if err := target.Store("aws/key", []byte("mine")); err != nil {
+		t.Fatal(err)
+	}
+	imported, skipped, err := secrets.ImportBundle(bytes.NewReader(data), target, "bundle pass", false)
+	if err != nil || !slices.Equal(imported, []string{"github/token"}) || !slices.Equal(skipped, []string{"aws/key"}) {
+		t.Fatalf("ImportBundle() = %v, %v, %v", imported, skipped, err)
+	}
+	if got, _ := target.Retrieve("aws/key"); string(got) != "mine" {
+		t.Errorf("expected an existing secret to be kept, got %q", got)
+	}
+	if got, _ := target.Retrieve("github/token"); string(got) != "gh" {
+		t.Errorf("Retrieve() after import = %q", got)
+	}
+
+	// Into another store of the AES backend, replacing its secrets and
+	// carrying the metadata
+	restored := secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	if err := restored.Initialize("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Store("aws/key", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if imported, _, err := secrets.ImportBundle(bytes.NewReader(data), restored, "bundle pass", true); err != nil || len(imported) != 2 {
+		t.Fatalf("ImportBundle() = %v, %v", imported, err)
+	}
+	if got, _ := restored.Retrieve("aws/key"); string(got) != "aws" {
+		t.Errorf("expected replace to overwrite a secret, got %q", got)
+	}
+	got, _ := restored.AllMetadata()
+	if token := got["github/token"]; token.Description != md.Description || token.RotateEvery != md.RotateEvery || !token.Updated.Equal(created["github/token"].Updated) {
+		t.Errorf("metadata after import = %+v, want %+v", token, created["github/token"])
+	}
+}
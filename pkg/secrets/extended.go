@@ -3,12 +3,17 @@ package secrets
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"filippo.io/age"
 )
 
 // EncryptionBackend represents the encryption method to use.
@@ -36,16 +41,35 @@ const (
 )
 
 // ExtendedManager provides extended secrets management with multiple backends.
+//
+// With the age and GPG backends each secret is a file of its own next to
+// the store, encrypted in-process to the manager's identity and to any
+// other recipients, such as the members of a team sharing the directory
+// as a vault through git (see AddMember).
+// The identity itself is kept in the password-protected store at
+// storePath, so neither it nor any secret is ever written in the clear.
 type ExtendedManager struct {
 	mu                sync.RWMutex
 	baseManager       *Manager
 	encryptionBackend EncryptionBackend
 	gitBackend        GitBackend
 	storePath         string
-	recipientKey      string // For age/GPG
+	recipients        []string // Other age/GPG recipients secrets are encrypted to
 	gitRepoPath       string
+	ageIdentities     []*age.X25519Identity // Loaded from the store while unlocked
+	gpgKeys           []*GPGKey             // Loaded from the store while unlocked
 }
 
+// Names under which the store keeps the identities of the age and GPG
+// backends.
+const (
+	ageIdentitiesSecret = "identity.age"
+	gpgKeysSecret       = "identity.gpg"
+)
+
+// gpgKeyName is the user ID of the OpenPGP keys the GPG backend generates.
+const gpgKeyName = "cbwsh secrets"
+
 // NewExtendedManager creates a new extended secrets manager.
 func NewExtendedManager(storePath string, backend EncryptionBackend, gitBackend GitBackend) *ExtendedManager {
 	return &ExtendedManager{
@@ -56,11 +80,52 @@ func NewExtendedManager(storePath string, backend EncryptionBackend, gitBackend
 	}
 }
 
-// SetRecipientKey sets the recipient key for age/GPG encryption.
+// SetRecipientKey sets the one other recipient age/GPG secrets are
+// encrypted to, as AddRecipient does, replacing any others.
 func (m *ExtendedManager) SetRecipientKey(key string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.recipientKey = key
+	m.recipients = []string{key}
+}
+
+// AddRecipient adds a recipient secrets are encrypted to from now on, in
+// addition to the manager's own identity: an "age1..." public key for the
+// age backend, or an ASCII-armored public key for the GPG backend.
+func (m *ExtendedManager) AddRecipient(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkRecipient(key); err != nil {
+		return err
+	}
+	for _, recipient := range m.recipients {
+		if recipient == key {
+			return nil
+		}
+	}
+	m.recipients = append(m.recipients, key)
+	return nil
+}
+
+// checkRecipient checks that key is a public key of the backend.
+func (m *ExtendedManager) checkRecipient(key string) error {
+	switch m.encryptionBackend {
+	case BackendAge:
+		_, err := age.ParseX25519Recipient(strings.TrimSpace(key))
+		return err
+	case BackendGPG:
+		_, err := ReadGPGKeys([]byte(key), "")
+		return err
+	default:
+		return fmt.Errorf("the %s backend has no recipients", m.encryptionBackend)
+	}
+}
+
+// Recipients returns the recipients added with AddRecipient.
+func (m *ExtendedManager) Recipients() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.recipients...)
 }
 
 // SetGitRepoPath sets the git repository path.
@@ -71,6 +136,9 @@ func (m *ExtendedManager) SetGitRepoPath(path string) {
 }
 
 // Initialize initializes the extended manager.
+//
+// For the age and GPG backends it creates the store holding the manager's
+// identity, protected by masterPassword, and generates the identity.
 func (m *ExtendedManager) Initialize(masterPassword string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -79,44 +147,137 @@ func (m *ExtendedManager) Initialize(masterPassword string) error {
 	case BackendAES:
 		return m.baseManager.Initialize(masterPassword)
 	case BackendAge:
-		return m.initializeAge()
+		return m.initializeAge(masterPassword)
 	case BackendGPG:
-		return m.initializeGPG()
+		return m.initializeGPG(masterPassword)
 	default:
 		return m.baseManager.Initialize(masterPassword)
 	}
 }
 
-func (m *ExtendedManager) initializeAge() error {
-	// Check if age is available
-	if _, err := exec.LookPath("age"); err != nil {
-		return fmt.Errorf("age not found in PATH: %w", err)
+func (m *ExtendedManager) initializeAge(masterPassword string) error {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return fmt.Errorf("failed to generate age identity: %w", err)
 	}
+	if err := m.baseManager.Initialize(masterPassword); err != nil {
+		return err
+	}
+	m.ageIdentities = []*age.X25519Identity{identity}
+	return m.saveIdentities()
+}
 
-	// Create store directory
-	dir := filepath.Dir(m.storePath)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
-		return fmt.Errorf("failed to create store directory: %w", err)
+func (m *ExtendedManager) initializeGPG(masterPassword string) error {
+	key, err := GenerateGPGKey(gpgKeyName, "")
+	if err != nil {
+		return err
 	}
+	if err := m.baseManager.Initialize(masterPassword); err != nil {
+		return err
+	}
+	m.gpgKeys = []*GPGKey{key}
+	return m.saveIdentities()
+}
 
-	// Create empty secrets file
-	return os.WriteFile(m.storePath, []byte("{}"), 0o600)
+// ImportIdentity adds identities to those decrypting age/GPG secrets and
+// keeps them in the store: the lines of an age identity file, or an
+// OpenPGP private key, whose protection passphrase removes. Secrets
+// stored from then on are encrypted to them too.
+func (m *ExtendedManager) ImportIdentity(data []byte, passphrase string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.baseManager.IsUnlocked() {
+		return errors.New("secrets store is locked")
+	}
+
+	switch m.encryptionBackend {
+	case BackendAge:
+		identities, err := parseAgeIdentities(data)
+		if err != nil {
+			return err
+		}
+		m.ageIdentities = append(m.ageIdentities, identities...)
+	case BackendGPG:
+		keys, err := ReadGPGKeys(data, passphrase)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if !key.HasPrivateKey() {
+				return fmt.Errorf("OpenPGP key %s has no private key", key.Fingerprint())
+			}
+		}
+		m.gpgKeys = append(m.gpgKeys, keys...)
+	default:
+		return fmt.Errorf("the %s backend has no identities", m.encryptionBackend)
+	}
+	return m.saveIdentities()
 }
 
-func (m *ExtendedManager) initializeGPG() error {
-	// Check if gpg is available
-	if _, err := exec.LookPath("gpg"); err != nil {
-		return fmt.Errorf("gpg not found in PATH: %w", err)
+// Recipient returns the public key of the manager's identity, for others
+// to add as a recipient: an "age1..." key, or an ASCII-armored OpenPGP
+// public key.
+func (m *ExtendedManager) Recipient() (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	switch {
+	case m.encryptionBackend == BackendAge && len(m.ageIdentities) > 0:
+		return m.ageIdentities[0].Recipient().String(), nil
+	case m.encryptionBackend == BackendGPG && len(m.gpgKeys) > 0:
+		return m.gpgKeys[0].ArmoredPublicKey()
+	case m.encryptionBackend == BackendAge, m.encryptionBackend == BackendGPG:
+		return "", errors.New("secrets store is locked")
+	default:
+		return "", fmt.Errorf("the %s backend has no identities", m.encryptionBackend)
 	}
+}
 
-	// Create store directory
-	dir := filepath.Dir(m.storePath)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
-		return fmt.Errorf("failed to create store directory: %w", err)
+// saveIdentities keeps the manager's identities in the store.
+func (m *ExtendedManager) saveIdentities() error {
+	switch m.encryptionBackend {
+	case BackendAge:
+		return m.baseManager.Store(ageIdentitiesSecret, formatAgeIdentities(m.ageIdentities))
+	case BackendGPG:
+		var data strings.Builder
+		for _, key := range m.gpgKeys {
+			armored, err := key.armoredPrivateKey()
+			if err != nil {
+				return err
+			}
+			data.WriteString(armored + "\n")
+		}
+		return m.baseManager.Store(gpgKeysSecret, []byte(data.String()))
 	}
+	return nil
+}
 
-	// Create empty secrets file
-	return os.WriteFile(m.storePath, []byte("{}"), 0o600)
+// loadIdentities reads the manager's identities from the unlocked store.
+func (m *ExtendedManager) loadIdentities() error {
+	switch m.encryptionBackend {
+	case BackendAge:
+		data, err := m.baseManager.Retrieve(ageIdentitiesSecret)
+		if err != nil {
+			return fmt.Errorf("failed to read age identities: %w", err)
+		}
+		identities, err := parseAgeIdentities(data)
+		if err != nil {
+			return err
+		}
+		m.ageIdentities = identities
+	case BackendGPG:
+		data, err := m.baseManager.Retrieve(gpgKeysSecret)
+		if err != nil {
+			return fmt.Errorf("failed to read OpenPGP keys: %w", err)
+		}
+		keys, err := ReadGPGKeys(data, "")
+		if err != nil {
+			return err
+		}
+		m.gpgKeys = keys
+	}
+	return nil
 }
 
 // Unlock unlocks the secrets store.
@@ -124,32 +285,65 @@ func (m *ExtendedManager) Unlock(masterPassword string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	switch m.encryptionBackend {
-	case BackendAES:
-		return m.baseManager.Unlock(masterPassword)
-	case BackendAge, BackendGPG:
-		// Age and GPG handle their own authentication
-		return nil
-	default:
-		return m.baseManager.Unlock(masterPassword)
+	if err := m.baseManager.Unlock(masterPassword); err != nil {
+		return err
+	}
+	if err := m.loadIdentities(); err != nil {
+		_ = m.baseManager.Lock()
+		return err
+	}
+	return nil
+}
+
+// Rekey re-encrypts the password-protected store, which holds the
+// manager's identity for the age and GPG backends, as Manager's Rekey
+// does. Secrets of those backends are encrypted to the identity and are
+// left as they are.
+func (m *ExtendedManager) Rekey(masterPassword string, params KDFParams, algorithm string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.baseManager.Rekey(masterPassword, params, algorithm); err != nil {
+		return err
 	}
+	return m.loadIdentities()
 }
 
-// Lock locks the secrets store.
+// Lock locks the secrets store, forgetting the age/GPG identities.
 func (m *ExtendedManager) Lock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.forgetIdentities()
 	return m.baseManager.Lock()
 }
 
+// forgetIdentities wipes the age/GPG identities from memory.
+func (m *ExtendedManager) forgetIdentities() {
+	for _, key := range m.gpgKeys {
+		key.wipe()
+	}
+	m.ageIdentities = nil
+	m.gpgKeys = nil
+}
+
+// SetAutoLock makes the store lock itself, forgetting the age/GPG
+// identities, once no secret has been used for idle, as Manager's
+// SetAutoLock does.
+func (m *ExtendedManager) SetAutoLock(idle time.Duration, onLock func()) {
+	m.baseManager.SetAutoLock(idle, func() {
+		m.mu.Lock()
+		m.forgetIdentities()
+		m.mu.Unlock()
+		if onLock != nil {
+			onLock()
+		}
+	})
+}
+
 // IsUnlocked returns whether the store is unlocked.
 func (m *ExtendedManager) IsUnlocked() bool {
-	switch m.encryptionBackend {
-	case BackendAES:
-		return m.baseManager.IsUnlocked()
-	case BackendAge, BackendGPG:
-		return true // External tools manage authentication
-	default:
-		return m.baseManager.IsUnlocked()
-	}
+	return m.baseManager.IsUnlocked()
 }
 
 // Store securely stores a secret.
@@ -160,73 +354,148 @@ func (m *ExtendedManager) Store(key string, value []byte) error {
 	switch m.encryptionBackend {
 	case BackendAES:
 		return m.baseManager.Store(key, value)
-	case BackendAge:
-		return m.storeWithAge(key, value)
-	case BackendGPG:
-		return m.storeWithGPG(key, value)
+	case BackendAge, BackendGPG:
+		return m.storeFile(key, value)
 	default:
 		return m.baseManager.Store(key, value)
 	}
 }
 
-func (m *ExtendedManager) storeWithAge(key string, value []byte) error {
-	if m.recipientKey == "" {
-		return fmt.Errorf("age recipient key not set")
+// storeFile encrypts a secret of the age or GPG backend to the recipients
+// with access to it and writes it to its file, committing it with the
+// files at paths.
+func (m *ExtendedManager) storeFile(key string, value []byte, paths ...string) error {
+	secretPath, err := m.secretPath(key, m.extension())
+	if err != nil {
+		return err
 	}
-
-	// Create a temporary file for input
-	tmpFile, err := os.CreateTemp("", "cbwsh-secret-*")
+	recipients, err := m.secretRecipients(key)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-	}()
-
-	if _, err := tmpFile.Write(value); err != nil {
+	ciphertext, err := m.encrypt(value, recipients)
+	if err != nil {
+		return err
+	}
+	if err := writeSecretFile(secretPath, ciphertext); err != nil {
 		return err
 	}
-	tmpFile.Close()
+	return m.commitToGit("Add secret: "+key, append(paths, secretPath)...)
+}
 
-	// Encrypt with age
-	secretPath := filepath.Join(filepath.Dir(m.storePath), key+".age")
-	cmd := exec.Command("age", "-r", m.recipientKey, "-o", secretPath, tmpFile.Name())
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("age encryption failed: %s: %w", string(output), err)
+// encrypt encrypts value to the manager's own identities and to
+// recipients, the public keys of others.
+func (m *ExtendedManager) encrypt(value []byte, recipients []string) ([]byte, error) {
+	m.baseManager.touch()
+	switch m.encryptionBackend {
+	case BackendAge:
+		if len(m.ageIdentities) == 0 {
+			return nil, errors.New("secrets store is locked")
+		}
+		to := make([]age.Recipient, 0, len(m.ageIdentities)+len(recipients))
+		seen := make(map[string]bool)
+		for _, identity := range m.ageIdentities {
+			to = append(to, identity.Recipient())
+			seen[identity.Recipient().String()] = true
+		}
+		for _, r := range recipients {
+			recipient, err := age.ParseX25519Recipient(strings.TrimSpace(r))
+			if err != nil {
+				return nil, err
+			}
+			if !seen[recipient.String()] {
+				to = append(to, recipient)
+				seen[recipient.String()] = true
+			}
+		}
+		return ageEncrypt(value, to...)
+	case BackendGPG:
+		if len(m.gpgKeys) == 0 {
+			return nil, errors.New("secrets store is locked")
+		}
+		to := append([]*GPGKey(nil), m.gpgKeys...)
+		seen := make(map[string]bool)
+		for _, key := range m.gpgKeys {
+			seen[key.Fingerprint()] = true
+		}
+		for _, r := range recipients {
+			keys, err := ReadGPGKeys([]byte(r), "")
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if !seen[key.Fingerprint()] {
+					to = append(to, key)
+					seen[key.Fingerprint()] = true
+				}
+			}
+		}
+		return EncryptGPG(value, to...)
+	default:
+		return nil, fmt.Errorf("the %s backend does not encrypt to recipients", m.encryptionBackend)
+	}
+}
+
+// decrypt decrypts a secret of the age or GPG backend with the manager's
+// own identities.
+func (m *ExtendedManager) decrypt(ciphertext []byte) ([]byte, error) {
+	m.baseManager.touch()
+	switch m.encryptionBackend {
+	case BackendAge:
+		if len(m.ageIdentities) == 0 {
+			return nil, errors.New("secrets store is locked")
+		}
+		identities := make([]age.Identity, len(m.ageIdentities))
+		for i, identity := range m.ageIdentities {
+			identities[i] = identity
+		}
+		return ageDecrypt(ciphertext, identities...)
+	case BackendGPG:
+		if len(m.gpgKeys) == 0 {
+			return nil, errors.New("secrets store is locked")
+		}
+		return DecryptGPG(ciphertext, m.gpgKeys...)
+	default:
+		return nil, fmt.Errorf("the %s backend does not decrypt files", m.encryptionBackend)
 	}
+}
 
-	return m.commitToGit(secretPath, "Add secret: "+key)
+// extension returns the file extension of the backend's secrets.
+func (m *ExtendedManager) extension() string {
+	return "." + string(m.encryptionBackend)
 }
 
-func (m *ExtendedManager) storeWithGPG(key string, value []byte) error {
-	if m.recipientKey == "" {
-		return fmt.Errorf("gpg recipient key not set")
+// secretPath returns the file of the secret key of the age or GPG backend.
+// Keys may contain slashes, which make directories.
+func (m *ExtendedManager) secretPath(key, extension string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid secret name %q", key)
 	}
+	return filepath.Join(filepath.Dir(m.storePath), filepath.FromSlash(key)+extension), nil
+}
 
-	// Create a temporary file for input
-	tmpFile, err := os.CreateTemp("", "cbwsh-secret-*")
+// writeSecretFile replaces the file at path with an encrypted secret in
+// one step.
+func writeSecretFile(path string, ciphertext []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create secret directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".secret-*")
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to write secret: %w", err)
 	}
-	defer func() {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-	}()
-
-	if _, err := tmpFile.Write(value); err != nil {
-		return err
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(ciphertext); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secret: %w", err)
 	}
-	tmpFile.Close()
-
-	// Encrypt with GPG
-	secretPath := filepath.Join(filepath.Dir(m.storePath), key+".gpg")
-	cmd := exec.Command("gpg", "--encrypt", "--recipient", m.recipientKey, "--output", secretPath, tmpFile.Name())
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("gpg encryption failed: %s: %w", string(output), err)
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write secret: %w", err)
 	}
-
-	return m.commitToGit(secretPath, "Add secret: "+key)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write secret: %w", err)
+	}
+	return nil
 }
 
 // Retrieve gets a stored secret.
@@ -237,37 +506,31 @@ func (m *ExtendedManager) Retrieve(key string) ([]byte, error) {
 	switch m.encryptionBackend {
 	case BackendAES:
 		return m.baseManager.Retrieve(key)
-	case BackendAge:
-		return m.retrieveWithAge(key)
-	case BackendGPG:
-		return m.retrieveWithGPG(key)
+	case BackendAge, BackendGPG:
+		if !m.baseManager.IsUnlocked() {
+			return nil, errors.New("secrets store is locked")
+		}
+		ciphertext, err := m.readSecretFile(key, m.extension())
+		if err != nil {
+			return nil, err
+		}
+		return m.decrypt(ciphertext)
 	default:
 		return m.baseManager.Retrieve(key)
 	}
 }
 
-func (m *ExtendedManager) retrieveWithAge(key string) ([]byte, error) {
-	secretPath := filepath.Join(filepath.Dir(m.storePath), key+".age")
-
-	cmd := exec.Command("age", "--decrypt", secretPath)
-	output, err := cmd.Output()
+// readSecretFile reads the file of the secret key.
+func (m *ExtendedManager) readSecretFile(key, extension string) ([]byte, error) {
+	secretPath, err := m.secretPath(key, extension)
 	if err != nil {
-		return nil, fmt.Errorf("age decryption failed: %w", err)
+		return nil, err
 	}
-
-	return output, nil
-}
-
-func (m *ExtendedManager) retrieveWithGPG(key string) ([]byte, error) {
-	secretPath := filepath.Join(filepath.Dir(m.storePath), key+".gpg")
-
-	cmd := exec.Command("gpg", "--decrypt", secretPath)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("gpg decryption failed: %w", err)
+	data, err := os.ReadFile(secretPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("secret not found: %s", key)
 	}
-
-	return output, nil
+	return data, err
 }
 
 // Delete removes a stored secret.
@@ -278,18 +541,22 @@ func (m *ExtendedManager) Delete(key string) error {
 	switch m.encryptionBackend {
 	case BackendAES:
 		return m.baseManager.Delete(key)
-	case BackendAge:
-		secretPath := filepath.Join(filepath.Dir(m.storePath), key+".age")
-		if err := os.Remove(secretPath); err != nil {
+	case BackendAge, BackendGPG:
+		secretPath, err := m.secretPath(key, m.extension())
+		if err != nil {
 			return err
 		}
-		return m.commitToGit(secretPath, "Remove secret: "+key)
-	case BackendGPG:
-		secretPath := filepath.Join(filepath.Dir(m.storePath), key+".gpg")
 		if err := os.Remove(secretPath); err != nil {
 			return err
 		}
-		return m.commitToGit(secretPath, "Remove secret: "+key)
+		paths := []string{secretPath}
+		accessPath := m.accessPath(key)
+		if err := os.Remove(accessPath); err == nil {
+			paths = append(paths, accessPath)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		return m.commitToGit("Remove secret: "+key, paths...)
 	default:
 		return m.baseManager.Delete(key)
 	}
@@ -312,24 +579,35 @@ func (m *ExtendedManager) List() ([]string, error) {
 	}
 }
 
+// listFiles returns the keys of the secret files with extension, in the
+// store's directory and below it.
 func (m *ExtendedManager) listFiles(extension string) ([]string, error) {
 	dir := filepath.Dir(m.storePath)
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return nil, err
-	}
-
 	var keys []string
-	for _, entry := range entries {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 		if entry.IsDir() {
-			continue
+			// Repository metadata holds no secrets
+			if path != dir && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-		name := entry.Name()
-		if strings.HasSuffix(name, extension) {
-			keys = append(keys, strings.TrimSuffix(name, extension))
+		if !strings.HasSuffix(entry.Name(), extension) {
+			return nil
 		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(strings.TrimSuffix(rel, extension)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return keys, nil
 }
 
@@ -341,20 +619,20 @@ func (m *ExtendedManager) Exists(key string) bool {
 	switch m.encryptionBackend {
 	case BackendAES:
 		return m.baseManager.Exists(key)
-	case BackendAge:
-		secretPath := filepath.Join(filepath.Dir(m.storePath), key+".age")
-		_, err := os.Stat(secretPath)
-		return err == nil
-	case BackendGPG:
-		secretPath := filepath.Join(filepath.Dir(m.storePath), key+".gpg")
-		_, err := os.Stat(secretPath)
+	case BackendAge, BackendGPG:
+		secretPath, err := m.secretPath(key, m.extension())
+		if err != nil {
+			return false
+		}
+		_, err = os.Stat(secretPath)
 		return err == nil
 	default:
 		return m.baseManager.Exists(key)
 	}
 }
 
-func (m *ExtendedManager) commitToGit(filePath, message string) error {
+// commitToGit commits the changes to paths, added, changed or removed.
+func (m *ExtendedManager) commitToGit(message string, paths ...string) error {
 	if m.gitBackend == GitBackendNone {
 		return nil
 	}
@@ -371,8 +649,8 @@ func (m *ExtendedManager) commitToGit(filePath, message string) error {
 
 	ctx := context.Background()
 
-	// Add the file
-	addCmd := exec.CommandContext(ctx, gitCmd, "add", filePath)
+	// Add the files
+	addCmd := exec.CommandContext(ctx, gitCmd, append([]string{"add", "-A", "--"}, paths...)...)
 	addCmd.Dir = m.gitRepoPath
 	if output, err := addCmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("%s add failed: %s: %w", gitCmd, string(output), err)
@@ -398,7 +676,17 @@ func (m *ExtendedManager) commitToGit(filePath, message string) error {
 }
 
 // SyncWithGit syncs secrets with the git repository.
+//
+// Changes made elsewhere are merged in. As each secret is a file of its
+// own, edits to different secrets never conflict; when the same secret
+// was changed on both sides, the most recent change wins, and a secret
+// changed on one side is kept even if the other deleted it. Removing a
+// teammate or their access wins over concurrent changes, and secrets the
+// merge left encrypted for the team as one side knew it are re-keyed.
 func (m *ExtendedManager) SyncWithGit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	if m.gitBackend == GitBackendNone {
 		return nil
 	}
@@ -415,14 +703,20 @@ func (m *ExtendedManager) SyncWithGit() error {
 
 	ctx := context.Background()
 
+	// The commit before the pull, to tell whether it merged anything
+	before, _ := vaultGit(ctx, gitCmd, m.gitRepoPath)("rev-parse", "HEAD")
+
 	// Pull latest changes
-	pullCmd := exec.CommandContext(ctx, gitCmd, "pull", "--rebase")
+	pullCmd := exec.CommandContext(ctx, gitCmd, "pull", "--no-rebase", "--no-edit")
 	pullCmd.Dir = m.gitRepoPath
 	if output, err := pullCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("%s pull failed: %s: %w", gitCmd, string(output), err)
+		pullErr := fmt.Errorf("%s pull failed: %s: %w", gitCmd, string(output), err)
+		if err := m.mergeSecrets(ctx, gitCmd, pullErr); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return m.rekeyMerged(ctx, gitCmd, strings.TrimSpace(before))
 }
 
 // PushToGit pushes secrets to the git repository.
@@ -0,0 +1,202 @@
+package secrets_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+// newBackendManager returns an initialized manager of backend storing its
+// secrets in a temporary directory, and the directory.
+func newBackendManager(t *testing.T, backend secrets.EncryptionBackend) (*secrets.ExtendedManager, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	manager := secrets.NewExtendedManager(filepath.Join(dir, "secrets.enc"), backend, secrets.GitBackendNone)
+	if err := manager.Initialize("master"); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	return manager, dir
+}
+
+func TestExtendedManagerBackends(t *testing.T) {
+	// No plaintext may reach a temporary file
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	for _, backend := range []secrets.EncryptionBackend{secrets.BackendAge, secrets.BackendGPG} {
+		t.Run(string(backend), func(t *testing.T) {
+			manager, dir := newBackendManager(t, backend)
+			value := []byte("token-0123456789")
+			if err := manager.Store("github/token", value); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+
+			got, err := manager.Retrieve("github/token")
+			if err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("Retrieve() = %q, %v", got, err)
+			}
+			keys, err := manager.List()
+			if err != nil || len(keys) != 1 || keys[0] != "github/token" {
+				t.Errorf("List() = %v, %v", keys, err)
+			}
+
+			// Neither the secret nor the identity is stored in the clear
+			err = filepath.WalkDir(dir, func(path string, _ os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				data, _ := os.ReadFile(path)
+				if bytes.Contains(data, value) || bytes.Contains(data, []byte("AGE-SECRET-KEY")) || bytes.Contains(data, []byte("PRIVATE KEY")) {
+					t.Errorf("%s holds plaintext", path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The identity is only available while unlocked
+			if err := manager.Lock(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := manager.Retrieve("github/token"); err == nil {
+				t.Error("expected a locked store not to decrypt")
+			}
+			if err := manager.Unlock("wrong"); err == nil {
+				t.Error("expected a wrong password to fail")
+			}
+			if err := manager.Unlock("master"); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := manager.Retrieve("github/token"); err != nil || !bytes.Equal(got, value) {
+				t.Errorf("Retrieve() after unlocking = %q, %v", got, err)
+			}
+
+			if err := manager.Store("../escape", value); err == nil {
+				t.Error("expected a name outside the store to be refused")
+			}
+			if err := manager.Delete("github/token"); err != nil || manager.Exists("github/token") {
+				t.Errorf("Delete() error = %v", err)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no temporary files, found %d", len(entries))
+	}
+}
+
+func TestExtendedManagerRecipients(t *testing.T) {
+	for _, backend := range []secrets.EncryptionBackend{secrets.BackendAge, secrets.BackendGPG} {
+		t.Run(string(backend), func(t *testing.T) {
+			alice, dir := newBackendManager(t, backend)
+			bob, bobDir := newBackendManager(t, backend)
+
+			// Bob reads Alice's store once she encrypts to him
+			bobKey, err := bob.Recipient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := alice.AddRecipient(bobKey); err != nil {
+				t.Fatalf("AddRecipient() error = %v", err)
+			}
+			if err := alice.Store("shared", []byte("team secret")); err != nil {
+				t.Fatal(err)
+			}
+
+			ext := "." + string(backend)
+			ciphertext, err := os.ReadFile(filepath.Join(dir, "shared"+ext))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(bobDir, "shared"+ext), ciphertext, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := bob.Retrieve("shared"); err != nil || string(got) != "team secret" {
+				t.Errorf("recipient Retrieve() = %q, %v", got, err)
+			}
+			if got, err := alice.Retrieve("shared"); err != nil || string(got) != "team secret" {
+				t.Errorf("owner Retrieve() = %q, %v", got, err)
+			}
+
+			if err := alice.AddRecipient("not a key"); err == nil {
+				t.Error("expected an invalid recipient to be refused")
+			}
+		})
+	}
+}
+
+func TestImportIdentity(t *testing.T) {
+	t.Run("age", func(t *testing.T) {
+		manager, _ := newBackendManager(t, secrets.BackendAge)
+		identityFile := "# created: 2024-01-01\n" +
+			"# public key: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p\n" +
+			"AGE-SECRET-KEY-1QQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQ\n"
+		if err := manager.ImportIdentity([]byte(identityFile), ""); err == nil {
+			t.Error("expected a malformed identity to be refused")
+		}
+		if err := manager.ImportIdentity([]byte("no identities here"), ""); err == nil {
+			t.Error("expected a file without identities to be refused")
+		}
+	})
+
+	t.Run("gpg", func(t *testing.T) {
+		key, err := secrets.GenerateGPGKey("Alice", "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, err := key.ArmoredPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(public, "PGP PUBLIC KEY BLOCK") {
+			t.Errorf("unexpected public key %q", public)
+		}
+
+		manager, _ := newBackendManager(t, secrets.BackendGPG)
+		if err := manager.ImportIdentity([]byte(public), ""); err == nil {
+			t.Error("expected a public key to be refused as an identity")
+		}
+		keys, err := secrets.ReadGPGKeys([]byte(public), "")
+		if err != nil || len(keys) != 1 || keys[0].Fingerprint() != key.Fingerprint() || keys[0].HasPrivateKey() {
+			t.Errorf("ReadGPGKeys() = %v, %v", keys, err)
+		}
+	})
+}
+
+func TestGPGEncryption(t *testing.T) {
+	alice, err := secrets.GenerateGPGKey("Alice", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := secrets.GenerateGPGKey("Bob", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eve, err := secrets.GenerateGPGKey("Eve", "eve@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := secrets.EncryptGPG([]byte("hello"), alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []*secrets.GPGKey{alice, bob} {
+		if got, err := secrets.DecryptGPG(ciphertext, key); err != nil || string(got) != "hello" {
+			t.Errorf("DecryptGPG() = %q, %v", got, err)
+		}
+	}
+	if _, err := secrets.DecryptGPG(ciphertext, eve); err == nil {
+		t.Error("expected a key that is not a recipient to fail")
+	}
+}
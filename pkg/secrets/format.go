@@ -0,0 +1,250 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// StoreVersion is the version of the store format Manager writes. Stores
+// without a version were written before it was recorded, with
+// DefaultKDFParams and AES-256-GCM; they are upgraded when next saved.
+const StoreVersion = 2
+
+// Ciphers encrypting the secrets of a store.
+const (
+	AlgorithmAES256GCM         = "AES-256-GCM"
+	AlgorithmXChaCha20Poly1305 = "XChaCha20-Poly1305"
+)
+
+// KDFArgon2id is the only key derivation function stores use.
+const KDFArgon2id = "argon2id"
+
+// argon2KeyLen is the length of the keys of both ciphers.
+const argon2KeyLen = 32
+
+// KDFParams are the parameters deriving a store's key from its master
+// password.
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Time      uint32 `json:"time"`    // Passes over the memory
+	Memory    uint32 `json:"memory"`  // In KiB
+	Threads   uint8  `json:"threads"` // Lanes computed in parallel
+}
+
+// DefaultKDFParams returns the parameters of stores created without others.
+func DefaultKDFParams() KDFParams {
+	return KDFParams{Algorithm: KDFArgon2id, Time: 1, Memory: 64 * 1024, Threads: 4}
+}
+
+// Validate checks that the parameters can derive a key.
+func (p KDFParams) Validate() error {
+	switch {
+	case p.Algorithm != KDFArgon2id:
+		return fmt.Errorf("unsupported key derivation %q", p.Algorithm)
+	case p.Time < 1 || p.Threads < 1:
+		return errors.New("key derivation needs at least one pass and one thread")
+	case p.Memory < 8*uint32(p.Threads):
+		return fmt.Errorf("key derivation needs at least %d KiB of memory for %d threads", 8*uint32(p.Threads), p.Threads)
+	}
+	return nil
+}
+
+// Weaker reports whether deriving a key with p costs less than with q.
+func (p KDFParams) Weaker(q KDFParams) bool {
+	return p.Time < q.Time || p.Memory < q.Memory
+}
+
+// String describes the parameters, such as "argon2id t=1 m=64MiB p=4".
+func (p KDFParams) String() string {
+	memory := fmt.Sprintf("%dKiB", p.Memory)
+	if p.Memory%1024 == 0 {
+		memory = fmt.Sprintf("%dMiB", p.Memory/1024)
+	}
+	return fmt.Sprintf("%s t=%d m=%s p=%d", p.Algorithm, p.Time, memory, p.Threads)
+}
+
+// deriveKey derives the encryption key from password and salt.
+func (p KDFParams) deriveKey(password string, salt []byte) []byte {
+	secret := []byte(password)
+	defer zero(secret)
+	return argon2.IDKey(secret, salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+}
+
+// StoreFormat describes how a store is encrypted.
+type StoreFormat struct {
+	Version   int
+	KDF       KDFParams
+	Algorithm string
+}
+
+// checkAlgorithm returns the canonical name of the cipher algorithm.
+func checkAlgorithm(algorithm string) (string, error) {
+	for _, known := range []string{AlgorithmAES256GCM, AlgorithmXChaCha20Poly1305} {
+		if strings.EqualFold(algorithm, known) {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported cipher %q", algorithm)
+}
+
+// newAEAD returns the cipher algorithm keyed with key.
+func newAEAD(algorithm string, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case AlgorithmAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case AlgorithmXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher %q", algorithm)
+	}
+}
+
+// sealSecret encrypts plaintext with algorithm and key, prefixing the nonce.
+func sealSecret(algorithm string, key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openSecret decrypts what sealSecret encrypted.
+func openSecret(algorithm string, key, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// SetStoreFormat sets the key derivation parameters and cipher of the
+// stores Initialize creates. Existing stores keep theirs until Rekey.
+func (m *Manager) SetStoreFormat(params KDFParams, algorithm string) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	algorithm, err := checkAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.newKDF, m.newAlgorithm = params, algorithm
+	return nil
+}
+
+// Format returns how the store on disk is encrypted. It works while the
+// store is locked.
+func (m *Manager) Format() (StoreFormat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	store, _, err := m.readStore()
+	if err != nil {
+		return StoreFormat{}, err
+	}
+	return store.format(), nil
+}
+
+// Rekey re-encrypts the store with a key derived from its master password
+// with params, and with the cipher algorithm, upgrading it to the current
+// format. The new store is checked to decrypt to the same secrets before
+// it replaces the old one, which is left as it was on failure.
+func (m *Manager) Rekey(masterPassword string, params KDFParams, algorithm string) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	algorithm, err := checkAlgorithm(algorithm)
+	if err != nil {
+		return err
+	}
+	// Verifies the password and decrypts every secret
+	if err := m.Unlock(masterPassword); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Unlock skips secrets that do not decrypt, which would be lost
+	store, _, err := m.readStore()
+	if err != nil {
+		return err
+	}
+	if len(store.Secrets) != len(m.secrets) {
+		return fmt.Errorf("%d secrets do not decrypt, not rekeying the store", len(store.Secrets)-len(m.secrets))
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	oldKey, oldHash, oldKDF, oldAlgorithm := m.encryptionKey, m.masterKeyHash, m.kdf, m.algorithm
+	m.encryptionKey = params.deriveKey(masterPassword, salt)
+	hash := sha256.Sum256(m.encryptionKey)
+	m.masterKeyHash, m.kdf, m.algorithm = hash[:], params, algorithm
+
+	data, err := m.encodeStore(salt)
+	if err == nil {
+		err = m.verifyStore(data)
+	}
+	if err == nil {
+		err = writeSecretFile(m.storePath, data)
+	}
+	if err != nil {
+		zero(m.encryptionKey)
+		m.encryptionKey, m.masterKeyHash, m.kdf, m.algorithm = oldKey, oldHash, oldKDF, oldAlgorithm
+		return fmt.Errorf("failed to rekey store: %w", err)
+	}
+	zero(oldKey)
+	return nil
+}
+
+// verifyStore checks that the encoded store data opens with the key and
+// cipher of the manager and holds its secrets.
+func (m *Manager) verifyStore(data []byte) error {
+	var store storeData
+	if err := json.Unmarshal(data, &store); err != nil {
+		return err
+	}
+	if len(store.Secrets) != len(m.secrets) {
+		return errors.New("secrets are missing from the new store")
+	}
+	for key, value := range m.secrets {
+		ciphertext, err := base64.StdEncoding.DecodeString(store.Secrets[key])
+		if err != nil {
+			return err
+		}
+		plaintext, err := openSecret(store.Algorithm, m.encryptionKey, ciphertext)
+		if err != nil || !equalBytes(plaintext, value) {
+			return fmt.Errorf("secret %s does not decrypt in the new store", key)
+		}
+		zero(plaintext)
+	}
+	return nil
+}
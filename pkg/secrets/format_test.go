@@ -0,0 +1,139 @@
+package secrets_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+// fastKDF derives keys cheaply, for tests.
+var fastKDF = secrets.KDFParams{Algorithm: secrets.KDFArgon2id, Time: 1, Memory: 8 * 1024, Threads: 1}
+
+// rewriteStore edits the JSON of the store file at path with edit.
+func rewriteStore(t *testing.T, path string, edit func(map[string]any)) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var store map[string]any
+	if err := json.Unmarshal(data, &store); err != nil {
+		t.Fatal(err)
+	}
+	edit(store)
+	if data, err = json.Marshal(store); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	manager := secrets.NewManager(path)
+	if err := manager.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Store("token", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	want := secrets.StoreFormat{Version: secrets.StoreVersion, KDF: secrets.DefaultKDFParams(), Algorithm: secrets.AlgorithmAES256GCM}
+	if format, err := manager.Format(); err != nil || format != want {
+		t.Errorf("Format() = %+v, %v, want %+v", format, err, want)
+	}
+
+	// Stores written before versions were recorded still open, and are
+	// upgraded when next saved
+	rewriteStore(t, path, func(store map[string]any) {
+		delete(store, "version")
+		delete(store, "kdf")
+		delete(store, "algorithm")
+	})
+	legacy := secrets.NewManager(path)
+	if format, err := legacy.Format(); err != nil || format.Version != 1 || format.KDF != secrets.DefaultKDFParams() {
+		t.Errorf("Format() of a legacy store = %+v, %v", format, err)
+	}
+	if err := legacy.Unlock("master"); err != nil {
+		t.Fatalf("Unlock() of a legacy store error = %v", err)
+	}
+	if err := legacy.Store("other", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if format, err := legacy.Format(); err != nil || format != want {
+		t.Errorf("Format() after saving a legacy store = %+v, %v", format, err)
+	}
+
+	rewriteStore(t, path, func(store map[string]any) { store["version"] = secrets.StoreVersion + 1 })
+	if err := secrets.NewManager(path).Unlock("master"); err == nil {
+		t.Error("expected a store of a newer format to be refused")
+	}
+
+	// New stores are created as configured
+	configured := secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	if err := configured.SetStoreFormat(fastKDF, "xchacha20-poly1305"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configured.SetStoreFormat(secrets.KDFParams{Algorithm: "scrypt"}, secrets.AlgorithmAES256GCM); err == nil {
+		t.Error("expected an unknown key derivation to be refused")
+	}
+	if err := configured.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	if format, _ := configured.Format(); format.KDF != fastKDF || format.Algorithm != secrets.AlgorithmXChaCha20Poly1305 {
+		t.Errorf("Format() of a configured store = %+v", format)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+	manager := secrets.NewManager(path)
+	if err := manager.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{"a": "1", "b": "2", "c": ""}
+	for key, value := range values {
+		if err := manager.Store(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Rekey("wrong", fastKDF, secrets.AlgorithmXChaCha20Poly1305); err == nil {
+		t.Error("expected Rekey() with a wrong password to fail")
+	}
+	if err := manager.Rekey("master", fastKDF, "DES"); err == nil {
+		t.Error("expected Rekey() to an unknown cipher to fail")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Error("expected a failed Rekey() to leave the store as it was")
+	}
+
+	upgraded := fastKDF
+	upgraded.Time = 2
+	if err := manager.Rekey("master", upgraded, secrets.AlgorithmXChaCha20Poly1305); err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if format, _ := manager.Format(); format.KDF != upgraded || format.Algorithm != secrets.AlgorithmXChaCha20Poly1305 {
+		t.Errorf("Format() after Rekey() = %+v", format)
+	}
+	if !fastKDF.Weaker(upgraded) || upgraded.Weaker(fastKDF) {
+		t.Error("expected fewer passes to be weaker")
+	}
+
+	reopened := secrets.NewManager(path)
+	if err := reopened.Unlock("master"); err != nil {
+		t.Fatalf("Unlock() after Rekey() error = %v", err)
+	}
+	for key, value := range values {
+		if got, err := reopened.Retrieve(key); err != nil || string(got) != value {
+			t.Errorf("Retrieve(%s) after Rekey() = %q, %v, want %q", key, got, err, value)
+		}
+	}
+}
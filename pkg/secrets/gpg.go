@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// GPGKey is an OpenPGP key, encrypted to as a recipient and, if it holds
+// its private key, decrypting as an identity. Keys are handled in-process
+// and interoperate with those of gpg.
+type GPGKey struct {
+	entity *openpgp.Entity
+}
+
+// GenerateGPGKey returns a new Curve25519 key, as gpg generates by
+// default, with the user ID "name <email>".
+func GenerateGPGKey(name, email string) (*GPGKey, error) {
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Curve: packet.Curve25519}
+	entity, err := openpgp.NewEntity(name, "", email, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate OpenPGP key: %w", err)
+	}
+	return &GPGKey{entity: entity}, nil
+}
+
+// ReadGPGKeys reads the keys of a key ring, ASCII-armored as exported by
+// gpg --export --armor or binary. Private keys protected with a
+// passphrase are decrypted with passphrase.
+func ReadGPGKeys(data []byte, passphrase string) ([]*GPGKey, error) {
+	var entities openpgp.EntityList
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenPGP keys: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("no OpenPGP keys found")
+	}
+
+	keys := make([]*GPGKey, 0, len(entities))
+	for _, entity := range entities {
+		if err := decryptGPGEntity(entity, passphrase); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &GPGKey{entity: entity})
+	}
+	return keys, nil
+}
+
+// decryptGPGEntity decrypts the private keys of entity protected with a
+// passphrase.
+func decryptGPGEntity(entity *openpgp.Entity, passphrase string) error {
+	encrypted := entity.PrivateKey != nil && entity.PrivateKey.Encrypted
+	for _, subkey := range entity.Subkeys {
+		encrypted = encrypted || subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted
+	}
+	if !encrypted {
+		return nil
+	}
+	if passphrase == "" {
+		return fmt.Errorf("OpenPGP key %X is protected by a passphrase", entity.PrimaryKey.Fingerprint)
+	}
+	if err := entity.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+		return fmt.Errorf("failed to decrypt OpenPGP key %X: %w", entity.PrimaryKey.Fingerprint, err)
+	}
+	return nil
+}
+
+// Fingerprint returns the key's fingerprint in hex.
+func (k *GPGKey) Fingerprint() string {
+	return fmt.Sprintf("%X", k.entity.PrimaryKey.Fingerprint)
+}
+
+// HasPrivateKey reports whether the key can decrypt.
+func (k *GPGKey) HasPrivateKey() bool {
+	return k.entity.PrivateKey != nil
+}
+
+// ArmoredPublicKey returns the public key, ASCII-armored, to share with
+// those encrypting to it.
+func (k *GPGKey) ArmoredPublicKey() (string, error) {
+	return armorGPG(openpgp.PublicKeyType, k.entity.Serialize)
+}
+
+// armoredPrivateKey returns the private key, ASCII-armored and not
+// protected by a passphrase.
+func (k *GPGKey) armoredPrivateKey() (string, error) {
+	if !k.HasPrivateKey() {
+		return "", errors.New("OpenPGP key has no private key")
+	}
+	return armorGPG(openpgp.PrivateKeyType, func(w io.Writer) error {
+		return k.entity.SerializePrivateWithoutSigning(w, nil)
+	})
+}
+
+func armorGPG(blockType string, serialize func(io.Writer) error) (string, error) {
+	var buf strings.Builder
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := serialize(w); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// wipe drops the key's private key.
+func (k *GPGKey) wipe() {
+	k.entity.PrivateKey = nil
+	for i := range k.entity.Subkeys {
+		k.entity.Subkeys[i].PrivateKey = nil
+	}
+}
+
+// EncryptGPG encrypts plaintext to recipients as a binary OpenPGP
+// message, which any of their private keys decrypts.
+func EncryptGPG(plaintext []byte, recipients ...*GPGKey) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no OpenPGP recipients")
+	}
+	to := make([]*openpgp.Entity, len(recipients))
+	for i, recipient := range recipients {
+		to[i] = recipient.entity
+	}
+
+	var buf bytes.Buffer
+	w, err := openpgp.Encrypt(&buf, to, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPGP encryption failed: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("OpenPGP encryption failed: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("OpenPGP encryption failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptGPG decrypts an OpenPGP message encrypted to one of keys.
+func DecryptGPG(ciphertext []byte, keys ...*GPGKey) ([]byte, error) {
+	keyring := make(openpgp.EntityList, 0, len(keys))
+	for _, key := range keys {
+		if key.HasPrivateKey() {
+			keyring = append(keyring, key.entity)
+		}
+	}
+
+	md, err := openpgp.ReadMessage(bytes.NewReader(ciphertext), keyring, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPGP decryption failed: %w", err)
+	}
+	plaintext, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPGP decryption failed: %w", err)
+	}
+	return plaintext, nil
+}
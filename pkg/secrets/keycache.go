@@ -0,0 +1,271 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// KeyCache keeps the keys of unlocked secrets stores for a while, so that
+// other cbwsh instances unlock them without asking for the master password
+// again. It serves them on a Unix socket in a directory only its owner may
+// enter, as ssh-agent does, answers only processes of the same user, and
+// zeroes each key when it expires.
+type KeyCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedKey // By absolute store path
+	linger  time.Duration
+}
+
+type cachedKey struct {
+	key     []byte
+	expires time.Time
+}
+
+// keyCacheRequest is a request to a key cache; each connection carries one.
+type keyCacheRequest struct {
+	Op    string        `json:"op"` // get, put or forget
+	Store string        `json:"store"`
+	Key   []byte        `json:"key,omitempty"`
+	TTL   time.Duration `json:"ttl,omitempty"`
+}
+
+type keyCacheResponse struct {
+	Key   []byte `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// keyCachePurgeInterval is how often expired keys are dropped.
+const keyCachePurgeInterval = time.Second
+
+// NewKeyCache returns an empty key cache whose Serve returns once it has
+// held no keys for linger.
+func NewKeyCache(linger time.Duration) *KeyCache {
+	return &KeyCache{entries: make(map[string]cachedKey), linger: linger}
+}
+
+// Serve serves the cache on the Unix socket at path until ctx ends or the
+// cache has been empty for its linger time, then zeroes the keys it holds.
+// It fails if another cache serves the socket already.
+func (c *KeyCache) Serve(ctx context.Context, path string) error {
+	// Only the directory keeps other users away from the socket between
+	// binding it and changing its mode, so it must be ours and private
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("a key cache is already running on %s", path)
+	}
+	// A socket left behind by a cache that did not exit cleanly
+	_ = os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	defer listener.Close()
+	if err := os.Chmod(path, 0o600); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go c.handle(conn)
+		}
+	}()
+
+	ticker := time.NewTicker(keyCachePurgeInterval)
+	defer ticker.Stop()
+	emptySince := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			c.purge(time.Time{})
+			return nil
+		case now := <-ticker.C:
+			if c.purge(now) > 0 {
+				emptySince = now
+			} else if now.Sub(emptySince) >= c.linger {
+				return nil
+			}
+		}
+	}
+}
+
+// purge zeroes and drops the keys expired at now, or all of them if now is
+// zero, returning how many are left.
+func (c *KeyCache) purge(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for store, entry := range c.entries {
+		if now.IsZero() || !now.Before(entry.expires) {
+			zero(entry.key)
+			delete(c.entries, store)
+		}
+	}
+	return len(c.entries)
+}
+
+// privateDir creates the directory dir if need be, and makes sure only its
+// owner, the current user, may enter it.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket directory %s belongs to another user", dir)
+	}
+	if info.Mode().Perm() != 0o700 {
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to make socket directory private: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *KeyCache) handle(conn net.Conn) {
+	defer conn.Close()
+	if err := checkPeer(conn); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Now().Add(keyCacheTimeout))
+
+	var req keyCacheRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	var resp keyCacheResponse
+
+	c.mu.Lock()
+	entry, ok := c.entries[req.Store]
+	if ok && !time.Now().Before(entry.expires) {
+		zero(entry.key)
+		delete(c.entries, req.Store)
+		ok = false
+	}
+	switch req.Op {
+	case "get":
+		if ok {
+			resp.Key = entry.key
+		} else {
+			resp.Error = "no cached key"
+		}
+	case "put":
+		if ok {
+			zero(entry.key)
+		}
+		c.entries[req.Store] = cachedKey{key: req.Key, expires: time.Now().Add(req.TTL)}
+	case "forget":
+		if ok {
+			zero(entry.key)
+			delete(c.entries, req.Store)
+		}
+	default:
+		resp.Error = "unknown operation " + req.Op
+	}
+	// Encoded before unlocking, as a purge may zero the key
+	data, _ := json.Marshal(resp)
+	c.mu.Unlock()
+
+	_, _ = conn.Write(data)
+}
+
+// keyCacheTimeout bounds each exchange with a key cache.
+const keyCacheTimeout = 2 * time.Second
+
+// KeyCacheClient caches the keys of secrets stores in the KeyCache
+// serving a socket.
+type KeyCacheClient struct {
+	socket string
+	ttl    time.Duration
+	start  func() error
+}
+
+// NewKeyCacheClient returns a client of the key cache on socket keeping
+// keys for ttl. If no cache is running when a key is put, start is called,
+// if not nil, to run one serving socket.
+func NewKeyCacheClient(socket string, ttl time.Duration, start func() error) *KeyCacheClient {
+	return &KeyCacheClient{socket: socket, ttl: ttl, start: start}
+}
+
+// Get returns the cached key of the store at storePath.
+func (c *KeyCacheClient) Get(storePath string) ([]byte, error) {
+	resp, err := c.request(keyCacheRequest{Op: "get", Store: storePath})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Key, nil
+}
+
+// Put caches the key of the store at storePath, starting the cache if it
+// is not running.
+func (c *KeyCacheClient) Put(storePath string, key []byte) error {
+	req := keyCacheRequest{Op: "put", Store: storePath, Key: key, TTL: c.ttl}
+	_, err := c.request(req)
+	var dialErr *net.OpError
+	if err == nil || c.start == nil || !errors.As(err, &dialErr) || dialErr.Op != "dial" {
+		return err
+	}
+
+	if err := c.start(); err != nil {
+		return fmt.Errorf("failed to start key cache: %w", err)
+	}
+	deadline := time.Now().Add(keyCacheTimeout)
+	for {
+		if _, err = c.request(req); err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Forget drops the cached key of the store at storePath.
+func (c *KeyCacheClient) Forget(storePath string) error {
+	_, err := c.request(keyCacheRequest{Op: "forget", Store: storePath})
+	return err
+}
+
+func (c *KeyCacheClient) request(req keyCacheRequest) (keyCacheResponse, error) {
+	var resp keyCacheResponse
+	if path, err := filepath.Abs(req.Store); err == nil {
+		req.Store = path
+	}
+
+	conn, err := net.DialTimeout("unix", c.socket, keyCacheTimeout)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(keyCacheTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("key cache: %w", err)
+	}
+	if resp.Error != "" {
+		return resp, errors.New("key cache: " + resp.Error)
+	}
+	return resp, nil
+}
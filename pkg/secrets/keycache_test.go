@@ -0,0 +1,106 @@
+package secrets_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+func TestKeyCache(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	start := func() error {
+		go func() { served <- secrets.NewKeyCache(time.Second).Serve(ctx, socket) }()
+		return nil
+	}
+	client := secrets.NewKeyCacheClient(socket, 300*time.Millisecond, start)
+
+	if _, err := client.Get("store.enc"); err == nil {
+		t.Fatal("expected no key without a cache")
+	}
+	// Putting a key starts the cache
+	if err := client.Put("store.enc", []byte("key")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if key, err := client.Get("store.enc"); err != nil || string(key) != "key" {
+		t.Errorf("Get() = %q, %v", key, err)
+	}
+	if _, err := client.Get("other.enc"); err == nil {
+		t.Error("expected no key for another store")
+	}
+	if err := secrets.NewKeyCache(time.Second).Serve(ctx, socket); err == nil {
+		t.Error("expected a second cache on the socket to fail")
+	}
+
+	// Keys expire after the TTL
+	time.Sleep(400 * time.Millisecond)
+	if _, err := client.Get("store.enc"); err == nil {
+		t.Error("expected the key to expire")
+	}
+
+	if err := client.Put("store.enc", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Forget("store.enc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get("store.enc"); err == nil {
+		t.Error("expected a forgotten key to be gone")
+	}
+
+	// An empty cache exits after lingering
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the empty cache to exit")
+	}
+	if err := client.Forget("store.enc"); err == nil {
+		t.Error("expected the cache to be gone")
+	}
+}
+
+func TestKeyCacheSocketDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- secrets.NewKeyCache(time.Minute).Serve(ctx, filepath.Join(dir, "agent.sock")) }()
+
+	client := secrets.NewKeyCacheClient(filepath.Join(dir, "agent.sock"), time.Minute, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Put("store.enc", []byte("key")) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the cache to serve the socket")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// An existing directory others may enter is made private
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("socket directory mode = %v, %v, want 0700", info.Mode().Perm(), err)
+	}
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Serve() error = %v", err)
+	}
+
+	// A socket directory that is not one is refused
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.NewKeyCache(time.Minute).Serve(context.Background(), filepath.Join(file, "agent.sock")); err == nil {
+		t.Error("expected a file as socket directory to be refused")
+	}
+}
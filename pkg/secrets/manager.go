@@ -7,8 +7,8 @@
 //   - Authentication tokens
 //
 // Security features:
-//   - AES-256-GCM encryption
-//   - Argon2id key derivation
+//   - AES-256-GCM or XChaCha20-Poly1305 encryption
+//   - Argon2id key derivation, with parameters recorded in the store
 //   - Salt-based key generation
 //   - Secure file permissions (0600)
 //   - Memory-safe operations
@@ -22,8 +22,6 @@
 package secrets
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -31,17 +29,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
-
-	"golang.org/x/crypto/argon2"
+	"sync/atomic"
+	"time"
 )
 
 // Manager provides encrypted secrets storage and retrieval.
 //
-// The Manager uses AES-256-GCM encryption with Argon2id key derivation
-// to securely store secrets. All operations are thread-safe.
+// The Manager uses AES-256-GCM (or XChaCha20-Poly1305) encryption with
+// Argon2id key derivation to securely store secrets; the store records
+// both, see StoreFormat. All operations are thread-safe.
 //
 // Security considerations:
 //   - Master password is never stored (only its hash)
@@ -49,6 +49,8 @@ import (
 //   - AES-256-GCM provides both confidentiality and authenticity
 //   - Store files use restrictive permissions (0600)
 //   - Secrets are locked by default and require explicit unlocking
+//   - Locking, explicitly or after SetAutoLock's idle time, zeroes the key
+//     and the decrypted secrets
 type Manager struct {
 	mu            sync.RWMutex   // Protects concurrent access
 	storePath     string         // Path to encrypted store file
@@ -56,21 +58,26 @@ type Manager struct {
 	encryptionKey []byte         // Derived encryption key (ephemeral)
 	secrets       map[string][]byte  // In-memory secrets cache (when unlocked)
 	unlocked      bool           // Whether the store is currently unlocked
+	autoLock      time.Duration  // Idle time after which the store locks itself
+	autoLockTimer *time.Timer    // Fires when the store may have been idle for autoLock
+	onAutoLock    func()         // Called after the store locked itself
+	lastUsed      atomic.Int64   // When a secret was last used, in Unix nanoseconds
+	metadata      map[string]Metadata // Metadata of the secrets (when unlocked)
+	auditLog      *AuditLog      // Records every access to a secret, if set
+	kdf           KDFParams      // Key derivation of the open store
+	algorithm     string         // Cipher of the open store
+	newKDF        KDFParams      // Key derivation of stores Initialize creates
+	newAlgorithm  string         // Cipher of stores Initialize creates
 }
 
-// Argon2 parameters for key derivation.
-const (
-	argon2Time    = 1
-	argon2Memory  = 64 * 1024
-	argon2Threads = 4
-	argon2KeyLen  = 32
-)
-
 // NewManager creates a new secrets manager.
 func NewManager(storePath string) *Manager {
 	return &Manager{
-		storePath: storePath,
-		secrets:   make(map[string][]byte),
+		storePath:    storePath,
+		secrets:      make(map[string][]byte),
+		metadata:     make(map[string]Metadata),
+		newKDF:       DefaultKDFParams(),
+		newAlgorithm: AlgorithmAES256GCM,
 	}
 }
 
@@ -86,21 +93,16 @@ func (m *Manager) Initialize(masterPassword string) error {
 	}
 
 	// Derive encryption key from master password
-	m.encryptionKey = argon2.IDKey(
-		[]byte(masterPassword),
-		salt,
-		argon2Time,
-		argon2Memory,
-		argon2Threads,
-		argon2KeyLen,
-	)
+	m.wipe()
+	m.kdf, m.algorithm = m.newKDF, m.newAlgorithm
+	m.encryptionKey = m.kdf.deriveKey(masterPassword, salt)
 
 	// Store hash for verification
 	hash := sha256.Sum256(m.encryptionKey)
 	m.masterKeyHash = hash[:]
 
-	m.secrets = make(map[string][]byte)
 	m.unlocked = true
+	m.startAutoLock()
 
 	// Create store directory if needed
 	dir := filepath.Dir(m.storePath)
@@ -117,33 +119,74 @@ func (m *Manager) Unlock(masterPassword string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	store, salt, err := m.readStore()
+	if err != nil {
+		return err
+	}
+	// Derive key from password
+	return m.unlock(store, store.format().KDF.deriveKey(masterPassword, salt))
+}
+
+// UnlockWithKey unlocks the secrets store with the key derived from its
+// master password, as Key returns it, sparing the cost of deriving it.
+func (m *Manager) UnlockWithKey(key []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	store, _, err := m.readStore()
+	if err != nil {
+		return err
+	}
+	return m.unlock(store, append([]byte(nil), key...))
+}
+
+// Key returns a copy of the key derived from the master password, while
+// the store is unlocked. It opens this store only, and is worth as much
+// as the password to it.
+func (m *Manager) Key() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.unlocked {
+		return nil, errors.New("secrets store is locked")
+	}
+	return append([]byte(nil), m.encryptionKey...), nil
+}
+
+// readStore reads the store file and its salt.
+func (m *Manager) readStore() (storeData, []byte, error) {
+	var store storeData
+
 	// Read store file
 	data, err := os.ReadFile(m.storePath)
 	if err != nil {
-		return fmt.Errorf("failed to read store: %w", err)
+		return store, nil, fmt.Errorf("failed to read store: %w", err)
 	}
 
 	// Parse store structure
-	var store storeData
 	if err := json.Unmarshal(data, &store); err != nil {
-		return fmt.Errorf("failed to parse store: %w", err)
+		return store, nil, fmt.Errorf("failed to parse store: %w", err)
+	}
+	if store.Version > StoreVersion {
+		return store, nil, fmt.Errorf("store format %d is newer than this version of cbwsh supports", store.Version)
 	}
 
 	// Decode salt
 	salt, err := base64.StdEncoding.DecodeString(store.Salt)
 	if err != nil {
-		return fmt.Errorf("failed to decode salt: %w", err)
+		return store, nil, fmt.Errorf("failed to decode salt: %w", err)
 	}
+	return store, salt, nil
+}
 
-	// Derive key from password
-	m.encryptionKey = argon2.IDKey(
-		[]byte(masterPassword),
-		salt,
-		argon2Time,
-		argon2Memory,
-		argon2Threads,
-		argon2KeyLen,
-	)
+// unlock decrypts store with key, which it keeps if it is the right one
+// and zeroes otherwise.
+func (m *Manager) unlock(store storeData, key []byte) error {
+	m.wipe()
+	m.unlocked = false
+	m.encryptionKey = key
+	format := store.format()
+	m.kdf, m.algorithm = format.KDF, format.Algorithm
 
 	// Verify key hash
 	hash := sha256.Sum256(m.encryptionKey)
@@ -151,17 +194,20 @@ func (m *Manager) Unlock(masterPassword string) error {
 
 	storedHash, err := base64.StdEncoding.DecodeString(store.KeyHash)
 	if err != nil {
+		m.wipe()
 		return fmt.Errorf("failed to decode key hash: %w", err)
 	}
 
 	if !equalBytes(m.masterKeyHash, storedHash) {
-		m.encryptionKey = nil
-		m.masterKeyHash = nil
+		m.wipe()
 		return errors.New("invalid master password")
 	}
 
+	for key, md := range store.Metadata {
+		m.metadata[key] = md
+	}
+
 	// Decrypt secrets
-	m.secrets = make(map[string][]byte)
 	for key, encValue := range store.Secrets {
 		encData, err := base64.StdEncoding.DecodeString(encValue)
 		if err != nil {
@@ -175,6 +221,7 @@ func (m *Manager) Unlock(masterPassword string) error {
 	}
 
 	m.unlocked = true
+	m.startAutoLock()
 	return nil
 }
 
@@ -184,16 +231,98 @@ func (m *Manager) Lock() error {
 	defer m.mu.Unlock()
 
 	// Clear sensitive data
-	if m.encryptionKey != nil {
-		for i := range m.encryptionKey {
-			m.encryptionKey[i] = 0
-		}
-		m.encryptionKey = nil
+	m.wipe()
+	m.unlocked = false
+	if m.autoLockTimer != nil {
+		m.autoLockTimer.Stop()
+		m.autoLockTimer = nil
 	}
+	return nil
+}
 
+// wipe zeroes the key and the decrypted secrets, and forgets them.
+func (m *Manager) wipe() {
+	zero(m.encryptionKey)
+	m.encryptionKey = nil
+	m.masterKeyHash = nil
+	for _, value := range m.secrets {
+		zero(value)
+	}
 	m.secrets = make(map[string][]byte)
+	m.metadata = make(map[string]Metadata)
+}
+
+// SetAutoLock makes the store lock itself once no secret has been used
+// for idle while it is unlocked, calling onLock, if not nil, after it did.
+// Zero turns the automatic locking off.
+func (m *Manager) SetAutoLock(idle time.Duration, onLock func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.autoLock = idle
+	m.onAutoLock = onLock
+	if m.autoLockTimer != nil {
+		m.autoLockTimer.Stop()
+		m.autoLockTimer = nil
+	}
+	if m.unlocked {
+		m.startAutoLock()
+	}
+}
+
+// startAutoLock starts the idle time of the unlocked store over.
+func (m *Manager) startAutoLock() {
+	m.touch()
+	if m.autoLock <= 0 {
+		return
+	}
+	if m.autoLockTimer != nil {
+		m.autoLockTimer.Reset(m.autoLock)
+		return
+	}
+	m.autoLockTimer = time.AfterFunc(m.autoLock, m.autoLockExpired)
+}
+
+// autoLockExpired locks the store if it has been idle long enough, or
+// waits for the rest of the idle time.
+func (m *Manager) autoLockExpired() {
+	m.mu.Lock()
+	if !m.unlocked || m.autoLock <= 0 || m.autoLockTimer == nil {
+		m.mu.Unlock()
+		return
+	}
+	idle := time.Since(time.Unix(0, m.lastUsed.Load()))
+	if idle < m.autoLock {
+		m.autoLockTimer.Reset(m.autoLock - idle)
+		m.mu.Unlock()
+		return
+	}
+	m.wipe()
 	m.unlocked = false
-	return nil
+	m.autoLockTimer = nil
+	onLock := m.onAutoLock
+	m.mu.Unlock()
+
+	if onLock != nil {
+		onLock()
+	}
+}
+
+// touch records that a secret was used, postponing the automatic lock.
+func (m *Manager) touch() {
+	m.lastUsed.Store(time.Now().UnixNano())
+}
+
+// Path returns the path of the store file.
+func (m *Manager) Path() string {
+	return m.storePath
+}
+
+// Initialized reports whether the store has been set up with Initialize,
+// so Unlock can open it.
+func (m *Manager) Initialized() bool {
+	_, err := os.Stat(m.storePath)
+	return err == nil
 }
 
 // IsUnlocked returns whether the store is unlocked.
@@ -205,15 +334,35 @@ func (m *Manager) IsUnlocked() bool {
 
 // Store securely stores a secret.
 func (m *Manager) Store(key string, value []byte) error {
+	return m.store(key, value, "")
+}
+
+func (m *Manager) store(key string, value []byte, caller string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if !m.unlocked {
-		return errors.New("secrets store is locked")
+		return m.audit(AuditStore, key, caller, errors.New("secrets store is locked"))
 	}
+	m.touch()
 
-	m.secrets[key] = value
+	// The store owns its copy, which Lock zeroes
+	zero(m.secrets[key])
+	m.secrets[key] = append([]byte(nil), value...)
 
+	now := time.Now().UTC()
+	md := m.metadata[key]
+	if md.Created.IsZero() {
+		md.Created = now
+	}
+	md.Updated = now
+	m.metadata[key] = md
+
+	return m.audit(AuditStore, key, caller, m.save())
+}
+
+// save writes the store with the salt it has.
+func (m *Manager) save() error {
 	// Read existing store to get salt
 	data, err := os.ReadFile(m.storePath)
 	if err != nil {
@@ -231,16 +380,25 @@ func (m *Manager) Store(key string, value []byte) error {
 
 // Retrieve gets a stored secret.
 func (m *Manager) Retrieve(key string) ([]byte, error) {
+	return m.retrieve(key, "")
+}
+
+func (m *Manager) retrieve(key, caller string) ([]byte, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if !m.unlocked {
-		return nil, errors.New("secrets store is locked")
+		return nil, m.audit(AuditRetrieve, key, caller, errors.New("secrets store is locked"))
 	}
+	m.touch()
 
 	value, exists := m.secrets[key]
 	if !exists {
-		return nil, fmt.Errorf("secret not found: %s", key)
+		return nil, m.audit(AuditRetrieve, key, caller, fmt.Errorf("secret not found: %s", key))
+	}
+	// Nothing is revealed that was not recorded
+	if err := m.audit(AuditRetrieve, key, caller, nil); err != nil {
+		return nil, err
 	}
 
 	// Return a copy to prevent modification
@@ -251,28 +409,159 @@ func (m *Manager) Retrieve(key string) ([]byte, error) {
 
 // Delete removes a stored secret.
 func (m *Manager) Delete(key string) error {
+	return m.delete(key, "")
+}
+
+func (m *Manager) delete(key, caller string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if !m.unlocked {
-		return errors.New("secrets store is locked")
+		return m.audit(AuditDelete, key, caller, errors.New("secrets store is locked"))
 	}
+	m.touch()
 
+	zero(m.secrets[key])
 	delete(m.secrets, key)
+	delete(m.metadata, key)
 
-	// Read existing store to get salt
-	data, err := os.ReadFile(m.storePath)
+	return m.audit(AuditDelete, key, caller, m.save())
+}
+
+// SetAuditLog records every retrieve, store and delete in log from now on.
+// An access that cannot be recorded fails.
+func (m *Manager) SetAuditLog(log *AuditLog) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auditLog = log
+}
+
+// audit records the operation op on key by caller, which failed with err
+// if not nil, in the audit log if there is one. It returns err, joined
+// with the error recording it.
+func (m *Manager) audit(op, key, caller string, err error) error {
+	if m.auditLog == nil {
+		return err
+	}
+	if auditErr := m.auditLog.Record(op, key, caller, err); auditErr != nil {
+		return errors.Join(err, auditErr)
+	}
+	return err
+}
+
+// AllMetadata returns the metadata of every secret, by name; secrets
+// stored without any have zero metadata. It works while the store is
+// locked, as names and metadata are not encrypted.
+func (m *Manager) AllMetadata() (map[string]Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]Metadata)
+	if m.unlocked {
+		for key := range m.secrets {
+			result[key] = m.metadata[key]
+		}
+		return result, nil
+	}
+
+	store, _, err := m.readStore()
+	if errors.Is(err, fs.ErrNotExist) {
+		return result, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read store: %w", err)
+		return nil, err
 	}
+	for key := range store.Secrets {
+		result[key] = store.Metadata[key]
+	}
+	return result, nil
+}
 
-	var store storeData
-	if err := json.Unmarshal(data, &store); err != nil {
-		return fmt.Errorf("failed to parse store: %w", err)
+// SetMetadata sets the description, tags, expiry and rotation interval of
+// a secret. Its creation and update times are kept.
+func (m *Manager) SetMetadata(key string, md Metadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.unlocked {
+		return errors.New("secrets store is locked")
 	}
+	if _, exists := m.secrets[key]; !exists {
+		return fmt.Errorf("secret not found: %s", key)
+	}
+	m.touch()
 
-	salt, _ := base64.StdEncoding.DecodeString(store.Salt)
-	return m.saveStore(salt)
+	current := m.metadata[key]
+	md.Created, md.Updated = current.Created, current.Updated
+	m.metadata[key] = md
+	return m.save()
+}
+
+// importMetadata sets the metadata of a secret imported from a bundle,
+// keeping its creation and update times from the bundle if it has them.
+func (m *Manager) importMetadata(key string, md Metadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.unlocked {
+		return errors.New("secrets store is locked")
+	}
+	current := m.metadata[key]
+	if md.Created.IsZero() {
+		md.Created = current.Created
+	}
+	if md.Updated.IsZero() {
+		md.Updated = current.Updated
+	}
+	m.metadata[key] = md
+	return m.save()
+}
+
+// For returns access to the store on behalf of caller, the command or
+// subsystem using it, which the audit log records.
+func (m *Manager) For(caller string) *Accessor {
+	return &Accessor{manager: m, caller: caller}
+}
+
+// Accessor accesses a Manager's secrets on behalf of a caller.
+type Accessor struct {
+	manager *Manager
+	caller  string
+}
+
+// Store stores a secret, as Manager's Store does.
+func (a *Accessor) Store(key string, value []byte) error {
+	return a.manager.store(key, value, a.caller)
+}
+
+// Retrieve gets a secret, as Manager's Retrieve does.
+func (a *Accessor) Retrieve(key string) ([]byte, error) {
+	return a.manager.retrieve(key, a.caller)
+}
+
+// Delete removes a secret, as Manager's Delete does.
+func (a *Accessor) Delete(key string) error {
+	return a.manager.delete(key, a.caller)
+}
+
+// List returns the names of the secrets, as Manager's List does.
+func (a *Accessor) List() ([]string, error) {
+	return a.manager.List()
+}
+
+// AllMetadata returns the metadata of every secret, as Manager's
+// AllMetadata does.
+func (a *Accessor) AllMetadata() (map[string]Metadata, error) {
+	return a.manager.AllMetadata()
+}
+
+func (a *Accessor) importMetadata(key string, md Metadata) error {
+	return a.manager.importMetadata(key, md)
+}
+
+// IsUnlocked returns whether the store is unlocked.
+func (a *Accessor) IsUnlocked() bool {
+	return a.manager.IsUnlocked()
 }
 
 // List returns all stored secret keys.
@@ -306,72 +595,84 @@ func (m *Manager) Exists(key string) bool {
 
 // storeData is the on-disk format for the secrets store.
 type storeData struct {
-	Salt    string            `json:"salt"`
-	KeyHash string            `json:"key_hash"`
-	Secrets map[string]string `json:"secrets"`
+	Version   int                 `json:"version,omitempty"` // Zero before versions were recorded
+	KDF       *KDFParams          `json:"kdf,omitempty"`
+	Algorithm string              `json:"algorithm,omitempty"`
+	Salt      string              `json:"salt"`
+	KeyHash   string              `json:"key_hash"`
+	Secrets   map[string]string   `json:"secrets"`
+	Metadata  map[string]Metadata `json:"metadata,omitempty"` // Not encrypted
+}
+
+// format returns how the store is encrypted, filling in what stores
+// written before versions were recorded left out.
+func (s storeData) format() StoreFormat {
+	format := StoreFormat{Version: s.Version, KDF: DefaultKDFParams(), Algorithm: AlgorithmAES256GCM}
+	if format.Version == 0 {
+		format.Version = 1
+	}
+	if s.KDF != nil {
+		format.KDF = *s.KDF
+	}
+	if s.Algorithm != "" {
+		format.Algorithm = s.Algorithm
+	}
+	return format
 }
 
 func (m *Manager) saveStore(salt []byte) error {
+	data, err := m.encodeStore(salt)
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(m.storePath, data)
+}
+
+// encodeStore encrypts the secrets with the key of the manager, for the
+// store file.
+func (m *Manager) encodeStore(salt []byte) ([]byte, error) {
+	kdf := m.kdf
 	store := storeData{
-		Salt:    base64.StdEncoding.EncodeToString(salt),
-		KeyHash: base64.StdEncoding.EncodeToString(m.masterKeyHash),
-		Secrets: make(map[string]string),
+		Version:   StoreVersion,
+		KDF:       &kdf,
+		Algorithm: m.algorithm,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		KeyHash:   base64.StdEncoding.EncodeToString(m.masterKeyHash),
+		Secrets:   make(map[string]string),
+		Metadata:  make(map[string]Metadata),
 	}
 
 	for key, value := range m.secrets {
 		encrypted, err := m.encrypt(value)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt secret %s: %w", key, err)
+			return nil, fmt.Errorf("failed to encrypt secret %s: %w", key, err)
 		}
 		store.Secrets[key] = base64.StdEncoding.EncodeToString(encrypted)
+		if md, ok := m.metadata[key]; ok {
+			store.Metadata[key] = md
+		}
 	}
 
 	data, err := json.MarshalIndent(store, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal store: %w", err)
+		return nil, fmt.Errorf("failed to marshal store: %w", err)
 	}
-
-	return os.WriteFile(m.storePath, data, 0o600)
+	return data, nil
 }
 
 func (m *Manager) encrypt(plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(m.encryptionKey)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
-	}
-
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext, nil
+	return sealSecret(m.algorithm, m.encryptionKey, plaintext)
 }
 
 func (m *Manager) decrypt(ciphertext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(m.encryptionKey)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
+	return openSecret(m.algorithm, m.encryptionKey, ciphertext)
+}
 
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, errors.New("ciphertext too short")
+// zero overwrites b with zeros.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
 	}
-
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return gcm.Open(nil, nonce, ciphertext, nil)
 }
 
 func equalBytes(a, b []byte) bool {
@@ -402,14 +703,8 @@ func (m *Manager) ChangePassword(oldPassword, newPassword string) error {
 	}
 
 	// Derive new encryption key
-	m.encryptionKey = argon2.IDKey(
-		[]byte(newPassword),
-		salt,
-		argon2Time,
-		argon2Memory,
-		argon2Threads,
-		argon2KeyLen,
-	)
+	zero(m.encryptionKey)
+	m.encryptionKey = m.kdf.deriveKey(newPassword, salt)
 
 	// Update key hash
 	hash := sha256.Sum256(m.encryptionKey)
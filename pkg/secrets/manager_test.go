@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/cbwinslow/cbwsh/pkg/secrets"
 )
@@ -410,3 +411,88 @@ func TestBinaryData(t *testing.T) {
 		}
 	}
 }
+
+func TestMasker(t *testing.T) {
+	t.Parallel()
+
+	masker := secrets.NewMasker("s3cret", "", "s3cret-token", "s3cret")
+	got := masker.Mask("token=s3cret-token key=s3cret plain")
+	if want := "token=" + secrets.MaskText + " key=" + secrets.MaskText + " plain"; got != want {
+		t.Errorf("Mask() = %q, want %q", got, want)
+	}
+
+	var none *secrets.Masker
+	if none.Mask("s3cret") != "s3cret" || secrets.NewMasker("") != nil {
+		t.Error("expected a masker without values to hide nothing")
+	}
+}
+
+func TestAutoLock(t *testing.T) {
+	t.Parallel()
+
+	manager := secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	locked := make(chan struct{}, 1)
+	manager.SetAutoLock(200*time.Millisecond, func() { locked <- struct{}{} })
+	if err := manager.Initialize("test-password"); err != nil {
+		t.Fatalf("failed to initialize: %v", err)
+	}
+	value := []byte("value")
+	if err := manager.Store("key", value); err != nil {
+		t.Fatal(err)
+	}
+
+	// Using a secret postpones the lock
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := manager.Retrieve("key"); err != nil {
+			t.Fatalf("store locked while in use: %v", err)
+		}
+	}
+
+	select {
+	case <-locked:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the idle store to lock itself")
+	}
+	if manager.IsUnlocked() {
+		t.Error("expected the store to be locked")
+	}
+	if _, err := manager.Key(); err == nil {
+		t.Error("expected no key while locked")
+	}
+	// The store zeroes its own copy, not the caller's
+	if string(value) != "value" {
+		t.Errorf("stored value changed to %q", value)
+	}
+}
+
+func TestUnlockWithKey(t *testing.T) {
+	t.Parallel()
+
+	storePath := filepath.Join(t.TempDir(), "secrets.enc")
+	manager := secrets.NewManager(storePath)
+	if err := manager.Initialize("test-password"); err != nil {
+		t.Fatalf("failed to initialize: %v", err)
+	}
+	if err := manager.Store("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	key, err := manager.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := secrets.NewManager(storePath)
+	if err := other.UnlockWithKey(make([]byte, len(key))); err == nil {
+		t.Error("expected a wrong key to fail")
+	}
+	if other.IsUnlocked() {
+		t.Error("expected a wrong key to leave the store locked")
+	}
+	if err := other.UnlockWithKey(key); err != nil {
+		t.Fatalf("UnlockWithKey() error = %v", err)
+	}
+	if value, err := other.Retrieve("key"); err != nil || string(value) != "value" {
+		t.Errorf("Retrieve() = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,48 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+)
+
+// MaskText replaces secret values in masked text.
+const MaskText = "********"
+
+// Masker hides secret values in text, such as the output of commands the
+// secrets were passed to. A nil Masker hides nothing.
+type Masker struct {
+	replacer *strings.Replacer
+}
+
+// NewMasker returns a masker hiding values. Where values overlap, the
+// longest is hidden.
+func NewMasker(values ...string) *Masker {
+	unique := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		unique = append(unique, value)
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	// The replacer prefers earlier values among those matching at a position
+	sort.Slice(unique, func(i, j int) bool { return len(unique[i]) > len(unique[j]) })
+	pairs := make([]string, 0, 2*len(unique))
+	for _, value := range unique {
+		pairs = append(pairs, value, MaskText)
+	}
+	return &Masker{replacer: strings.NewReplacer(pairs...)}
+}
+
+// Mask returns text with the masker's values replaced by MaskText.
+func (m *Masker) Mask(text string) string {
+	if m == nil {
+		return text
+	}
+	return m.replacer.Replace(text)
+}
@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"sort"
+	"time"
+)
+
+// Metadata describes a secret. It is kept in the clear next to the
+// secret's name, so it can be read while the store is locked, for example
+// to remind the user of secrets due for rotation.
+type Metadata struct {
+	Description string        `json:"description,omitempty"`
+	Tags        []string      `json:"tags,omitempty"`
+	Created     time.Time     `json:"created,omitzero"`
+	Updated     time.Time     `json:"updated,omitzero"`
+	Expires     time.Time     `json:"expires,omitzero"`      // Zero if the secret does not expire
+	RotateEvery time.Duration `json:"rotate_every,omitzero"` // Zero if it need not be rotated
+}
+
+// RotateBy returns when the secret is due for rotation, or zero if it need
+// not be rotated.
+func (md Metadata) RotateBy() time.Time {
+	if md.RotateEvery <= 0 || md.Updated.IsZero() {
+		return time.Time{}
+	}
+	return md.Updated.Add(md.RotateEvery)
+}
+
+// Reasons a secret needs attention.
+const (
+	DueExpiry   = "expires"
+	DueRotation = "rotation"
+)
+
+// DueSecret is a secret that expires or is due for rotation.
+type DueSecret struct {
+	Key    string
+	Reason string // DueExpiry or DueRotation
+	When   time.Time
+}
+
+// Overdue reports whether the secret expired or was due for rotation
+// before now.
+func (d DueSecret) Overdue(now time.Time) bool {
+	return !d.When.After(now)
+}
+
+// DueSecrets returns the secrets of metadata that expire or are due for
+// rotation by the time by, soonest first. A secret both expiring and due
+// for rotation is listed for whichever comes first.
+func DueSecrets(metadata map[string]Metadata, by time.Time) []DueSecret {
+	var due []DueSecret
+	for key, md := range metadata {
+		var next DueSecret
+		if !md.Expires.IsZero() {
+			next = DueSecret{Key: key, Reason: DueExpiry, When: md.Expires}
+		}
+		if rotateBy := md.RotateBy(); !rotateBy.IsZero() && (next.When.IsZero() || rotateBy.Before(next.When)) {
+			next = DueSecret{Key: key, Reason: DueRotation, When: rotateBy}
+		}
+		if !next.When.IsZero() && !next.When.After(by) {
+			due = append(due, next)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].When.Equal(due[j].When) {
+			return due[i].Key < due[j].Key
+		}
+		return due[i].When.Before(due[j].When)
+	})
+	return due
+}
@@ -0,0 +1,111 @@
+package secrets_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+func TestDueSecrets(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	metadata := map[string]secrets.Metadata{
+		"plain":    {Description: "never due"},
+		"expired":  {Expires: now.Add(-day)},
+		"rotate":   {Updated: now.Add(-100 * day), RotateEvery: 90 * day},
+		"fresh":    {Updated: now, RotateEvery: 90 * day},
+		"both":     {Updated: now, RotateEvery: 10 * day, Expires: now.Add(20 * day)},
+		"expiring": {Expires: now.Add(5 * day)},
+	}
+
+	due := secrets.DueSecrets(metadata, now)
+	if len(due) != 2 || due[0].Key != "rotate" || due[1].Key != "expired" {
+		t.Fatalf("DueSecrets(now) = %+v", due)
+	}
+	if due[0].Reason != secrets.DueRotation || !due[0].When.Equal(now.Add(-10*day)) || !due[0].Overdue(now) {
+		t.Errorf("unexpected rotation %+v", due[0])
+	}
+	if due[1].Reason != secrets.DueExpiry {
+		t.Errorf("unexpected expiry %+v", due[1])
+	}
+
+	due = secrets.DueSecrets(metadata, now.Add(30*day))
+	keys := make([]string, len(due))
+	for i, d := range due {
+		keys[i] = d.Key
+	}
+	want := []string{"rotate", "expired", "expiring", "both"}
+	if len(keys) != len(want) {
+		t.Fatalf("DueSecrets(in 30 days) = %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("DueSecrets(in 30 days) = %v, want %v", keys, want)
+		}
+	}
+	// Rotation comes before expiry
+	if due[3].Reason != secrets.DueRotation {
+		t.Errorf("expected the earlier rotation, got %+v", due[3])
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+
+	storePath := filepath.Join(t.TempDir(), "secrets.enc")
+	manager := secrets.NewManager(storePath)
+	if err := manager.Initialize("test-password"); err != nil {
+		t.Fatalf("failed to initialize: %v", err)
+	}
+	if err := manager.Store("github/token", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Store("plain", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	md := secrets.Metadata{Description: "CI token", Tags: []string{"ci"}, Expires: expires, RotateEvery: time.Hour}
+	if err := manager.SetMetadata("github/token", md); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetMetadata("missing", md); err == nil {
+		t.Error("expected metadata of a missing secret to be refused")
+	}
+
+	all, err := manager.AllMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := all["github/token"].Created
+	if created.IsZero() || all["github/token"].Description != "CI token" {
+		t.Fatalf("AllMetadata() = %+v", all)
+	}
+
+	// Updating the secret keeps its metadata and creation time
+	time.Sleep(10 * time.Millisecond)
+	if err := manager.Store("github/token", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata is readable while locked
+	if err := manager.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	all, err = secrets.NewManager(storePath).AllMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := all["github/token"]
+	if got.Description != "CI token" || !got.Expires.Equal(expires) || got.RotateEvery != time.Hour ||
+		!got.Created.Equal(created) || !got.Updated.After(created) {
+		t.Errorf("metadata after locking = %+v", got)
+	}
+	if _, ok := all["plain"]; !ok || len(all) != 2 {
+		t.Errorf("expected every secret to be listed, got %v", all)
+	}
+	if all, err := secrets.NewManager(filepath.Join(t.TempDir(), "none.enc")).AllMetadata(); err != nil || len(all) != 0 {
+		t.Errorf("AllMetadata() without a store = %v, %v", all, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package secrets
+
+import "net"
+
+// checkPeer accepts every peer: without SO_PEERCRED, the private directory
+// of the socket alone keeps other users away.
+func checkPeer(net.Conn) error {
+	return nil
+}
@@ -0,0 +1,581 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A team vault is the directory of an age or GPG store shared through git
+// or yadm. Besides the secrets it holds, as plain files:
+//
+//	.members/<name>.pub       the public key of the teammate name
+//	.access/<secret>.members  the teammates with access to a secret, one per line
+//
+// A secret without an access list is shared with the whole team. Whoever
+// stores or re-keys a secret can always read it back.
+const (
+	membersDir      = ".members"
+	memberExtension = ".pub"
+	accessDir       = ".access"
+	accessExtension = ".members"
+)
+
+// memberNamePattern matches the names of teammates.
+var memberNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]*$`)
+
+// AddMember adds the teammate name to the vault with their public key, as
+// AddRecipient takes it, or replaces their key. Secrets shared with them
+// are re-encrypted so they can read them.
+func (m *ExtendedManager) AddMember(name, publicKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !memberNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid member name %q", name)
+	}
+	if err := m.checkRecipient(publicKey); err != nil {
+		return err
+	}
+	keys, err := m.accessibleBy(name)
+	if err != nil {
+		return err
+	}
+
+	memberPath := m.memberPath(name)
+	if err := writeSecretFile(memberPath, []byte(strings.TrimSpace(publicKey)+"\n")); err != nil {
+		return err
+	}
+	paths, rekeyErr := m.rekey(keys)
+	if err := m.commitToGit("Add member: "+name, append(paths, memberPath)...); err != nil {
+		return err
+	}
+	return rekeyErr
+}
+
+// RemoveMember removes the teammate name from the vault and from the
+// access lists of secrets, re-encrypting the secrets they could read so
+// they no longer can. Secrets they already copied should be rotated.
+func (m *ExtendedManager) RemoveMember(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	memberPath := m.memberPath(name)
+	if !memberNamePattern.MatchString(name) || !fileExists(memberPath) {
+		return fmt.Errorf("no member %q", name)
+	}
+	keys, err := m.accessibleBy(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(memberPath); err != nil {
+		return err
+	}
+	paths := []string{memberPath}
+	for _, key := range keys {
+		members, restricted, err := m.readAccess(key)
+		if err != nil {
+			return err
+		}
+		if !restricted {
+			continue
+		}
+		if err := m.writeAccess(key, slices.DeleteFunc(members, func(member string) bool { return member == name })); err != nil {
+			return err
+		}
+		paths = append(paths, m.accessPath(key))
+	}
+	rekeyed, rekeyErr := m.rekey(keys)
+	if err := m.commitToGit("Remove member: "+name, append(paths, rekeyed...)...); err != nil {
+		return err
+	}
+	return rekeyErr
+}
+
+// Members returns the names of the teammates in the vault.
+func (m *ExtendedManager) Members() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.members()
+}
+
+func (m *ExtendedManager) members() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(filepath.Dir(m.storePath), membersDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), memberExtension)
+		if ok && !entry.IsDir() && memberNamePattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// StoreFor stores a secret only the teammates members have access to, as
+// Grant and Revoke change.
+func (m *ExtendedManager) StoreFor(key string, value []byte, members []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.secretPath(key, m.extension()); err != nil {
+		return err
+	}
+	if err := m.checkMembers(members); err != nil {
+		return err
+	}
+	if err := m.writeAccess(key, members); err != nil {
+		return err
+	}
+	return m.storeFile(key, value, m.accessPath(key))
+}
+
+// Access returns the teammates with access to a secret.
+func (m *ExtendedManager) Access(key string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, err := m.secretPath(key, m.extension()); err != nil {
+		return nil, err
+	}
+	members, _, err := m.readAccess(key)
+	return members, err
+}
+
+// Grant gives teammates access to a secret, re-encrypting it.
+func (m *ExtendedManager) Grant(key string, members ...string) error {
+	return m.changeAccess(key, "Grant access to "+key, func(access []string) []string {
+		return append(access, members...)
+	}, members)
+}
+
+// Revoke takes access to a secret away from teammates, re-encrypting it.
+// A secret shared with the whole team is then shared with the others
+// only. Secrets they already read should be rotated.
+func (m *ExtendedManager) Revoke(key string, members ...string) error {
+	return m.changeAccess(key, "Revoke access to "+key, func(access []string) []string {
+		return slices.DeleteFunc(access, func(member string) bool { return slices.Contains(members, member) })
+	}, members)
+}
+
+func (m *ExtendedManager) changeAccess(key, message string, change func([]string) []string, members []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secretPath, err := m.secretPath(key, m.extension())
+	if err != nil {
+		return err
+	}
+	if !fileExists(secretPath) {
+		return fmt.Errorf("secret not found: %s", key)
+	}
+	if err := m.checkMembers(members); err != nil {
+		return err
+	}
+	access, restricted, err := m.readAccess(key)
+	if err != nil {
+		return err
+	}
+	if err := m.writeAccess(key, change(slices.Clone(access))); err != nil {
+		return err
+	}
+	paths, err := m.rekey([]string{key})
+	if err != nil {
+		// Leave the access as the secret's encryption has it
+		if restricted {
+			_ = m.writeAccess(key, access)
+		} else {
+			_ = os.Remove(m.accessPath(key))
+		}
+		return err
+	}
+	return m.commitToGit(message, append(paths, m.accessPath(key))...)
+}
+
+// checkMembers checks that members are teammates in the vault.
+func (m *ExtendedManager) checkMembers(members []string) error {
+	for _, member := range members {
+		if !memberNamePattern.MatchString(member) || !fileExists(m.memberPath(member)) {
+			return fmt.Errorf("no member %q", member)
+		}
+	}
+	return nil
+}
+
+func (m *ExtendedManager) memberPath(name string) string {
+	return filepath.Join(filepath.Dir(m.storePath), membersDir, name+memberExtension)
+}
+
+func (m *ExtendedManager) accessPath(key string) string {
+	return filepath.Join(filepath.Dir(m.storePath), accessDir, filepath.FromSlash(key)+accessExtension)
+}
+
+// readAccess returns the teammates with access to a secret, and whether
+// its access is restricted to them rather than shared with the team.
+func (m *ExtendedManager) readAccess(key string) ([]string, bool, error) {
+	data, err := os.ReadFile(m.accessPath(key))
+	if os.IsNotExist(err) {
+		members, err := m.members()
+		return members, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return strings.Fields(string(data)), true, nil
+}
+
+// writeAccess restricts the access to a secret to members.
+func (m *ExtendedManager) writeAccess(key string, members []string) error {
+	members = slices.Compact(slices.Sorted(slices.Values(members)))
+	var data strings.Builder
+	for _, member := range members {
+		data.WriteString(member + "\n")
+	}
+	return writeSecretFile(m.accessPath(key), []byte(data.String()))
+}
+
+// secretRecipients returns the public keys of the teammates with access
+// to a secret, and of the recipients added with AddRecipient.
+func (m *ExtendedManager) secretRecipients(key string) ([]string, error) {
+	members, _, err := m.readAccess(key)
+	if err != nil {
+		return nil, err
+	}
+	recipients := append([]string(nil), m.recipients...)
+	for _, member := range members {
+		data, err := os.ReadFile(m.memberPath(member))
+		if os.IsNotExist(err) {
+			// Removed concurrently; the access list is updated on merge
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, string(data))
+	}
+	return recipients, nil
+}
+
+// accessibleBy returns the secrets the teammate name has access to.
+func (m *ExtendedManager) accessibleBy(name string) ([]string, error) {
+	keys, err := m.listFiles(m.extension())
+	if err != nil {
+		return nil, err
+	}
+	var accessible []string
+	for _, key := range keys {
+		members, restricted, err := m.readAccess(key)
+		if err != nil {
+			return nil, err
+		}
+		if !restricted || slices.Contains(members, name) {
+			accessible = append(accessible, key)
+		}
+	}
+	return accessible, nil
+}
+
+// rekey re-encrypts secrets to the recipients now having access to them,
+// returning the files rewritten. Secrets the manager cannot read are left
+// as they are and reported.
+func (m *ExtendedManager) rekey(keys []string) ([]string, error) {
+	var paths []string
+	var errs []error
+	for _, key := range keys {
+		secretPath, err := m.secretPath(key, m.extension())
+		if err == nil {
+			err = m.rekeyFile(key, secretPath)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to re-key %s: %w", key, err))
+			continue
+		}
+		paths = append(paths, secretPath)
+	}
+	return paths, errors.Join(errs...)
+}
+
+func (m *ExtendedManager) rekeyFile(key, secretPath string) error {
+	ciphertext, err := os.ReadFile(secretPath)
+	if err != nil {
+		return err
+	}
+	value, err := m.decrypt(ciphertext)
+	if err != nil {
+		return err
+	}
+	recipients, err := m.secretRecipients(key)
+	if err != nil {
+		return err
+	}
+	if ciphertext, err = m.encrypt(value, recipients); err != nil {
+		return err
+	}
+	return writeSecretFile(secretPath, ciphertext)
+}
+
+// vaultGit returns a function running gitCmd in dir, the vault's
+// directory, that returns its output.
+func vaultGit(ctx context.Context, gitCmd, dir string) func(args ...string) (string, error) {
+	return func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, gitCmd, args...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("%s %s failed: %s: %w", gitCmd, args[0], string(output), err)
+		}
+		return string(output), nil
+	}
+}
+
+// mergeSecrets resolves the conflicts of a merge that stopped with
+// pullErr, file by file: a secret changed on one side only is kept, and
+// of a secret changed on both sides the most recent change wins. Access
+// lists keep the teammates both sides kept and those either side granted
+// access to, and a teammate removed on one side stays removed. Conflicts
+// outside the vault are not resolved, and abort the merge.
+func (m *ExtendedManager) mergeSecrets(ctx context.Context, gitCmd string, pullErr error) error {
+	dir := filepath.Dir(m.storePath)
+	git := vaultGit(ctx, gitCmd, dir)
+	conflicts := func(args ...string) ([]string, error) {
+		output, err := git(append([]string{"diff", "--name-only", "-z", "--diff-filter=U"}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		return splitNames(output), nil
+	}
+
+	paths, err := conflicts("--relative")
+	if err != nil || len(paths) == 0 {
+		// Not a merge stopped by conflicting secrets
+		return pullErr
+	}
+
+	resolve := func() error {
+		for _, path := range paths {
+			if err := resolveSecretConflict(git, dir, path); err != nil {
+				return err
+			}
+		}
+		if remaining, err := conflicts(); err != nil || len(remaining) > 0 {
+			return errors.Join(pullErr, err)
+		}
+		_, err := git("commit", "--no-edit")
+		return err
+	}
+	if err := resolve(); err != nil {
+		_, _ = git("merge", "--abort")
+		return err
+	}
+	return nil
+}
+
+// resolveSecretConflict resolves the conflict of a merge on the file at
+// path, relative to dir, the working directory of git.
+func resolveSecretConflict(git func(args ...string) (string, error), dir, path string) error {
+	// The versions of the file at the merge base, ours and theirs
+	_, baseErr := git("cat-file", "-e", ":1:./"+path)
+	_, oursErr := git("cat-file", "-e", "HEAD:./"+path)
+	_, theirsErr := git("cat-file", "-e", "MERGE_HEAD:./"+path)
+
+	switch {
+	case strings.HasPrefix(path, accessDir+"/") && oursErr == nil && theirsErr == nil:
+		return mergeAccess(git, dir, path)
+	case strings.HasPrefix(path, membersDir+"/") && baseErr == nil && (oursErr != nil || theirsErr != nil):
+		// A teammate removed on one side stays removed, whatever the
+		// other side did to their key
+		_, err := git("rm", "--quiet", "--", path)
+		return err
+	}
+
+	side := "--theirs"
+	switch {
+	case oursErr == nil && theirsErr != nil:
+		side = "--ours"
+	case oursErr == nil && theirsErr == nil && changedAt(git, "HEAD", path) > changedAt(git, "MERGE_HEAD", path):
+		side = "--ours"
+	case oursErr != nil && theirsErr != nil:
+		_, err := git("rm", "--cached", "--quiet", "--", path)
+		return err
+	}
+	if _, err := git("checkout", side, "--", path); err != nil {
+		return err
+	}
+	_, err := git("add", "--", path)
+	return err
+}
+
+// mergeAccess merges the access list at path changed on both sides: the
+// teammates both sides kept, and those either side granted access to.
+func mergeAccess(git func(args ...string) (string, error), dir, path string) error {
+	stage := func(n int) []string {
+		output, _ := git("show", fmt.Sprintf(":%d:./%s", n, path))
+		return strings.Fields(output)
+	}
+	base, ours, theirs := stage(1), stage(2), stage(3)
+
+	var merged []string
+	for _, member := range append(ours, theirs...) {
+		inOurs, inTheirs := slices.Contains(ours, member), slices.Contains(theirs, member)
+		if (inOurs && inTheirs) || !slices.Contains(base, member) {
+			merged = append(merged, member)
+		}
+	}
+	merged = slices.Compact(slices.Sorted(slices.Values(merged)))
+
+	var data strings.Builder
+	for _, member := range merged {
+		data.WriteString(member + "\n")
+	}
+	if err := writeSecretFile(filepath.Join(dir, filepath.FromSlash(path)), []byte(data.String())); err != nil {
+		return err
+	}
+	_, err := git("add", "--", path)
+	return err
+}
+
+// rekeyMerged re-encrypts the secrets a merge into HEAD, made by a pull
+// from before, left encrypted to other teammates than now have access to
+// them: each side encrypted its changes for the team as it knew it. Access
+// lists are first cleared of the teammates no longer in the vault.
+// Secrets the manager cannot read are left as they are and reported.
+func (m *ExtendedManager) rekeyMerged(ctx context.Context, gitCmd, before string) error {
+	git := vaultGit(ctx, gitCmd, filepath.Dir(m.storePath))
+	if head, err := git("rev-parse", "HEAD"); err != nil || strings.TrimSpace(head) == before {
+		return nil
+	}
+	if _, err := git("rev-parse", "--verify", "--quiet", "HEAD^2"); err != nil {
+		// A fast-forward brings changes made for the vault as it is
+		return nil
+	}
+	base, err := git("merge-base", "HEAD^1", "HEAD^2")
+	if err != nil {
+		return err
+	}
+
+	// Only changes to the team or to access lists change recipients
+	teamChanged := false
+	for _, side := range []string{"HEAD^1", "HEAD^2"} {
+		output, err := git("diff", "--name-only", "-z", "--relative", strings.TrimSpace(base), side, "--", membersDir, accessDir)
+		if err != nil {
+			return err
+		}
+		teamChanged = teamChanged || len(splitNames(output)) > 0
+	}
+	if !teamChanged {
+		return nil
+	}
+
+	keys, err := m.listFiles(m.extension())
+	if err != nil {
+		return err
+	}
+	var paths, stale []string
+	for _, key := range keys {
+		members, restricted, err := m.readAccess(key)
+		if err != nil {
+			return err
+		}
+		if kept := slices.DeleteFunc(slices.Clone(members), func(member string) bool {
+			return !fileExists(m.memberPath(member))
+		}); restricted && len(kept) != len(members) {
+			if err := m.writeAccess(key, kept); err != nil {
+				return err
+			}
+			paths = append(paths, m.accessPath(key))
+		}
+
+		now, err := m.secretRecipients(key)
+		if err != nil {
+			return err
+		}
+		then, err := encryptedFor(git, strings.TrimSpace(base), key, m.extension())
+		if err != nil || !slices.Equal(normalizeKeys(now[len(m.recipients):]), then) {
+			stale = append(stale, key)
+		}
+	}
+
+	rekeyed, rekeyErr := m.rekey(stale)
+	if err := m.commitToGit("Re-key secrets after merge", append(paths, rekeyed...)...); err != nil {
+		return err
+	}
+	return rekeyErr
+}
+
+// encryptedFor returns the public keys of the teammates the secret key was
+// encrypted to, as the vault was at the commit its file comes from: one of
+// the parents of the merge in HEAD, or base, their merge base.
+func encryptedFor(git func(args ...string) (string, error), base, key, extension string) ([]string, error) {
+	current, err := git("rev-parse", "HEAD:./"+key+extension)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range []string{"HEAD^1", "HEAD^2", base} {
+		if blob, err := git("rev-parse", rev+":./"+key+extension); err != nil || blob != current {
+			continue
+		}
+
+		var members []string
+		if access, err := git("show", rev+":./"+accessDir+"/"+key+accessExtension); err == nil {
+			members = strings.Fields(access)
+		} else if names, err := git("ls-tree", "--name-only", "-z", rev+":./"+membersDir); err == nil {
+			for _, name := range splitNames(names) {
+				if member, ok := strings.CutSuffix(name, memberExtension); ok {
+					members = append(members, member)
+				}
+			}
+		}
+		var keys []string
+		for _, member := range members {
+			if publicKey, err := git("show", rev+":./"+membersDir+"/"+member+memberExtension); err == nil {
+				keys = append(keys, publicKey)
+			}
+		}
+		return normalizeKeys(keys), nil
+	}
+	return nil, fmt.Errorf("no version of %s in the merge", key)
+}
+
+// normalizeKeys returns public keys trimmed, sorted and without duplicates,
+// to compare sets of recipients.
+func normalizeKeys(keys []string) []string {
+	normalized := make([]string, 0, len(keys))
+	for _, key := range keys {
+		normalized = append(normalized, strings.TrimSpace(key))
+	}
+	return slices.Compact(slices.Sorted(slices.Values(normalized)))
+}
+
+// splitNames splits the NUL-separated output of git's -z options.
+func splitNames(output string) []string {
+	return strings.FieldsFunc(output, func(r rune) bool { return r == 0 })
+}
+
+// changedAt returns the time, in seconds, of the last commit of rev
+// changing path.
+func changedAt(git func(args ...string) (string, error), rev, path string) int64 {
+	output, err := git("log", "-1", "--format=%ct", rev, "--", path)
+	if err != nil {
+		return 0
+	}
+	seconds, _ := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	return seconds
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
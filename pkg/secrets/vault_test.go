@@ -0,0 +1,293 @@
+package secrets_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+)
+
+// git runs git in dir, failing the test if it fails.
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %s: %v", args, output, err)
+	}
+}
+
+// newTeamVaults returns the age managers of two teammates with clones of
+// the same vault repository.
+func newTeamVaults(t *testing.T) (alice, bob *secrets.ExtendedManager) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(name, "cbwsh")
+	}
+	for _, name := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(name, "cbwsh@example.com")
+	}
+
+	remote := filepath.Join(home, "remote.git")
+	git(t, home, "init", "--quiet", "--bare", "--initial-branch=main", remote)
+	git(t, home, "clone", "--quiet", remote, "alice")
+	aliceDir := filepath.Join(home, "alice")
+	if err := os.WriteFile(filepath.Join(aliceDir, ".gitignore"), []byte("secrets.enc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	git(t, aliceDir, "add", ".gitignore")
+	git(t, aliceDir, "commit", "--quiet", "-m", "Create vault")
+	git(t, aliceDir, "push", "--quiet", "-u", "origin", "HEAD")
+	git(t, home, "clone", "--quiet", remote, "bob")
+
+	open := func(dir string) *secrets.ExtendedManager {
+		manager := secrets.NewExtendedManager(filepath.Join(dir, "secrets.enc"), secrets.BackendAge, secrets.GitBackendGit)
+		manager.SetGitRepoPath(dir)
+		if err := manager.Initialize("master"); err != nil {
+			t.Fatal(err)
+		}
+		return manager
+	}
+	return open(aliceDir), open(filepath.Join(home, "bob"))
+}
+
+func syncVaults(t *testing.T, managers ...*secrets.ExtendedManager) {
+	t.Helper()
+	for _, manager := range managers {
+		if err := manager.SyncWithGit(); err != nil {
+			t.Fatalf("SyncWithGit() error = %v", err)
+		}
+		if err := manager.PushToGit(); err != nil {
+			t.Fatalf("PushToGit() error = %v", err)
+		}
+	}
+}
+
+func TestTeamVaultAccess(t *testing.T) {
+	alice, bob := newTeamVaults(t)
+	aliceKey, _ := alice.Recipient()
+	bobKey, _ := bob.Recipient()
+
+	if err := alice.AddMember("alice", aliceKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Store("team/db", []byte("shared")); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.StoreFor("team/root", []byte("private"), []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	// Adding a teammate re-keys the secrets shared with the whole team
+	if err := alice.AddMember("bob", bobKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.AddMember("../bob", bobKey); err == nil {
+		t.Error("expected an invalid member name to be refused")
+	}
+	syncVaults(t, alice, bob)
+
+	if members, err := bob.Members(); err != nil || !slices.Equal(members, []string{"alice", "bob"}) {
+		t.Errorf("Members() = %v, %v", members, err)
+	}
+	if got, err := bob.Retrieve("team/db"); err != nil || string(got) != "shared" {
+		t.Errorf("Retrieve() of a team secret = %q, %v", got, err)
+	}
+	if _, err := bob.Retrieve("team/root"); err == nil {
+		t.Error("expected a secret restricted to alice not to decrypt for bob")
+	}
+
+	if err := alice.Grant("team/root", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Grant("team/root", "carol"); err == nil {
+		t.Error("expected granting access to a stranger to fail")
+	}
+	syncVaults(t, alice, bob)
+	if got, err := bob.Retrieve("team/root"); err != nil || string(got) != "private" {
+		t.Errorf("Retrieve() after Grant() = %q, %v", got, err)
+	}
+
+	// Removing a teammate re-keys whatever they could read
+	if err := alice.RemoveMember("bob"); err != nil {
+		t.Fatal(err)
+	}
+	syncVaults(t, alice, bob)
+	for _, key := range []string{"team/db", "team/root"} {
+		if _, err := bob.Retrieve(key); err == nil {
+			t.Errorf("expected %s not to decrypt for a removed member", key)
+		}
+		if got, err := alice.Retrieve(key); err != nil {
+			t.Errorf("Retrieve(%s) = %q, %v", key, got, err)
+		}
+	}
+	if access, err := alice.Access("team/root"); err != nil || !slices.Equal(access, []string{"alice"}) {
+		t.Errorf("Access() = %v, %v", access, err)
+	}
+}
+
+func TestTeamVaultMerge(t *testing.T) {
+	alice, bob := newTeamVaults(t)
+	aliceKey, _ := alice.Recipient()
+	bobKey, _ := bob.Recipient()
+	if err := alice.AddMember("alice", aliceKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.AddMember("bob", bobKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Store("shared", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Store("stale", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	syncVaults(t, alice, bob)
+
+	// Both edit the vault concurrently; bob's edit of "shared" is newer
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T10:00:00Z")
+	if err := alice.Store("shared", []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Store("from-alice", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Delete("stale"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T11:00:00Z")
+	if err := bob.Store("shared", []byte("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Store("stale", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Store("from-bob", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T12:00:00Z")
+
+	syncVaults(t, alice, bob, alice)
+
+	for _, manager := range []*secrets.ExtendedManager{alice, bob} {
+		want := map[string]string{"shared": "bob", "stale": "new", "from-alice": "a", "from-bob": "b"}
+		for key, value := range want {
+			if got, err := manager.Retrieve(key); err != nil || string(got) != value {
+				t.Errorf("Retrieve(%s) = %q, %v, want %q", key, got, err, value)
+			}
+		}
+	}
+}
+
+func TestTeamVaultMergeRevoke(t *testing.T) {
+	alice, bob := newTeamVaults(t)
+	aliceKey, _ := alice.Recipient()
+	bobKey, _ := bob.Recipient()
+	if err := alice.AddMember("alice", aliceKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.AddMember("bob", bobKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"team/db", "team/ci"} {
+		if err := alice.Store(key, []byte("v1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	syncVaults(t, alice, bob)
+
+	// Alice revokes bob's access to the database while bob edits it, later
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T10:00:00Z")
+	if err := alice.Revoke("team/db", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T11:00:00Z")
+	if err := bob.Store("team/db", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T12:00:00Z")
+	syncVaults(t, bob, alice, bob)
+
+	// Bob's edit is kept, but alice's merge re-keyed it to her only
+	if got, err := alice.Retrieve("team/db"); err != nil || string(got) != "v2" {
+		t.Errorf("Retrieve() of the edited secret = %q, %v", got, err)
+	}
+	if _, err := bob.Retrieve("team/db"); err == nil {
+		t.Error("expected the revoked secret not to decrypt for bob after the merge")
+	}
+	if access, err := alice.Access("team/db"); err != nil || !slices.Equal(access, []string{"alice"}) {
+		t.Errorf("Access() after the merge = %v, %v", access, err)
+	}
+	if got, err := bob.Retrieve("team/ci"); err != nil || string(got) != "v1" {
+		t.Errorf("Retrieve() of a secret still shared = %q, %v", got, err)
+	}
+
+	// A revoke and a grant of the same secret both hold
+	carol, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.AddMember("carol", carol.Recipient().String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.StoreFor("team/root", []byte("root"), []string{"alice", "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	syncVaults(t, alice, bob)
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T12:30:00Z")
+	if err := alice.Revoke("team/root", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T12:45:00Z")
+	if err := bob.Grant("team/root", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	syncVaults(t, bob, alice, bob)
+	if access, err := bob.Access("team/root"); err != nil || !slices.Equal(access, []string{"alice", "carol"}) {
+		t.Errorf("Access() after a revoke and a grant = %v, %v", access, err)
+	}
+	if _, err := bob.Retrieve("team/root"); err == nil {
+		t.Error("expected the revoked secret not to decrypt for bob after the merge")
+	}
+
+	// A removed teammate stays removed, even if they changed their key
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T13:00:00Z")
+	if err := alice.RemoveMember("bob"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T14:00:00Z")
+	newKey, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.AddMember("bob", newKey.Recipient().String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Store("team/ci", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_COMMITTER_DATE", "2024-01-01T15:00:00Z")
+	syncVaults(t, bob, alice, bob)
+
+	for _, manager := range []*secrets.ExtendedManager{alice, bob} {
+		if members, err := manager.Members(); err != nil || !slices.Equal(members, []string{"alice", "carol"}) {
+			t.Errorf("Members() after the merge = %v, %v", members, err)
+		}
+	}
+	if got, err := alice.Retrieve("team/ci"); err != nil || string(got) != "v2" {
+		t.Errorf("Retrieve() of the edited secret = %q, %v", got, err)
+	}
+	if _, err := bob.Retrieve("team/ci"); err == nil {
+		t.Error("expected a removed teammate's edit to be re-keyed without them")
+	}
+}
@@ -6,6 +6,7 @@
 //
 // Key features:
 //   - Synchronous and asynchronous command execution
+//   - Persistent PTY-backed shell sessions
 //   - Command streaming with real-time output
 //   - Environment variable management
 //   - Command aliasing
@@ -15,12 +16,14 @@
 package shell
 
 import (
-	"bufio"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -45,6 +48,10 @@ type Executor struct {
 	env        map[string]string     // Custom environment variables
 	currentCmd *exec.Cmd             // Currently executing command (if any)
 	aliases    map[string]string     // Command aliases map
+	persistent bool                  // Run commands in a long-lived shell session
+	session    *Session              // Persistent shell session (if enabled)
+	starter    TerminalStarter       // Starts a non-local session shell (if set)
+	cols, rows int                   // Terminal size for the session
 }
 
 // NewExecutor creates a new shell executor for the specified shell type.
@@ -71,6 +78,22 @@ func NewExecutor(shellType core.ShellType) *Executor {
 	}
 }
 
+// NewTerminalExecutor creates an executor whose commands run in a session
+// on the terminals that start provides, such as a shell on a remote host.
+//
+// The executor is always in session mode. Its working directory is the
+// one the shell starts in until the first command reports it, and the
+// local environment is not passed to the shell.
+func NewTerminalExecutor(shellType core.ShellType, start TerminalStarter) *Executor {
+	return &Executor{
+		shellType:  shellType,
+		env:        make(map[string]string),
+		aliases:    make(map[string]string),
+		persistent: true,
+		starter:    start,
+	}
+}
+
 // Execute runs a shell command synchronously and returns the result.
 //
 // This method:
@@ -89,12 +112,20 @@ func NewExecutor(shellType core.ShellType) *Executor {
 //
 // Returns:
 //   - *core.CommandResult: Result containing output, errors, and exit code
-//   - error: Always nil (errors are returned in CommandResult)
+//   - error: Non-nil only if the persistent session could not be started
+//     (command failures are returned in CommandResult)
 func (e *Executor) Execute(ctx context.Context, command string) (*core.CommandResult, error) {
 	e.mu.Lock()
 	// Expand aliases before execution
 	command = e.expandAliases(command)
 
+	// Hand off to the persistent session when enabled
+	if e.persistent {
+		session := e.ensureSession()
+		e.mu.Unlock()
+		return e.runInSession(ctx, session, command, nil)
+	}
+
 	startTime := time.Now()
 
 	// Prepare command execution
@@ -140,76 +171,131 @@ func (e *Executor) Execute(ctx context.Context, command string) (*core.CommandRe
 }
 
 // ExecuteAsync runs a command asynchronously and streams the output.
-func (e *Executor) ExecuteAsync(ctx context.Context, command string) (<-chan *core.CommandResult, error) {
-	resultCh := make(chan *core.CommandResult, 1)
+//
+// The returned channel receives a core.CommandEvent carrying an
+// OutputChunk each time the command writes to stdout or stderr, followed
+// by a final event carrying the CommandResult, after which the channel is
+// closed. Callers must drain the channel until it is closed.
+//
+// In session mode stdout is read from the session's terminal, so chunks
+// arrive exactly as an interactive program writes them.
+func (e *Executor) ExecuteAsync(ctx context.Context, command string) (<-chan core.CommandEvent, error) {
+	return e.executeAsync(ctx, command, nil)
+}
+
+// ExecuteAsyncWithEnv runs a command asynchronously as ExecuteAsync does,
+// with env added to the environment of the command's process only.
+//
+// The command always runs in a process of its own, even in session mode,
+// so env never reaches the session's shell; it starts in the session's
+// working directory but does not see variables exported in the session.
+// Commands of remote executors cannot be given an environment this way.
+func (e *Executor) ExecuteAsyncWithEnv(ctx context.Context, command string, env map[string]string) (<-chan core.CommandEvent, error) {
+	if !e.Local() {
+		return nil, errors.New("environment can only be passed to local commands")
+	}
+	if env == nil {
+		env = map[string]string{}
+	}
+	return e.executeAsync(ctx, command, env)
+}
+
+// executeAsync implements ExecuteAsync. A non-nil env runs the command in
+// a process of its own with env added to its environment.
+func (e *Executor) executeAsync(ctx context.Context, command string, env map[string]string) (<-chan core.CommandEvent, error) {
+	events := make(chan core.CommandEvent, 64)
 
 	go func() {
-		defer close(resultCh)
+		defer close(events)
+
+		emit := func(chunk core.OutputChunk) {
+			events <- core.CommandEvent{Chunk: &chunk}
+		}
 
 		e.mu.Lock()
 		command = e.expandAliases(command)
+
+		if e.persistent && env == nil {
+			session := e.ensureSession()
+			e.mu.Unlock()
+			result, err := e.runInSession(ctx, session, command, emit)
+			if err != nil {
+				result = &core.CommandResult{
+					Command:  command,
+					Error:    err.Error(),
+					ExitCode: -1,
+				}
+			}
+			events <- core.CommandEvent{Result: result}
+			return
+		}
+
 		startTime := time.Now()
 
 		shell := e.getShellPath()
 		cmd := exec.CommandContext(ctx, shell, "-c", command)
 		cmd.Dir = e.workingDir
 		cmd.Env = e.buildEnv()
+		for k, v := range env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
 		e.currentCmd = cmd
 		e.mu.Unlock()
 
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			resultCh <- &core.CommandResult{
+		failed := func(err error) {
+			e.mu.Lock()
+			e.currentCmd = nil
+			e.mu.Unlock()
+			events <- core.CommandEvent{Result: &core.CommandResult{
 				Command:  command,
 				Error:    err.Error(),
 				ExitCode: -1,
-			}
+			}}
+		}
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			failed(err)
 			return
 		}
 
 		stderr, err := cmd.StderrPipe()
 		if err != nil {
-			resultCh <- &core.CommandResult{
-				Command:  command,
-				Error:    err.Error(),
-				ExitCode: -1,
-			}
+			failed(err)
 			return
 		}
 
 		if err := cmd.Start(); err != nil {
-			resultCh <- &core.CommandResult{
-				Command:  command,
-				Error:    err.Error(),
-				ExitCode: -1,
-			}
+			failed(err)
 			return
 		}
 
 		var outputBuf, errorBuf strings.Builder
-		outputDone := make(chan struct{})
-		errorDone := make(chan struct{})
-
-		go func() {
-			defer close(outputDone)
-			scanner := bufio.NewScanner(stdout)
-			for scanner.Scan() {
-				outputBuf.WriteString(scanner.Text())
-				outputBuf.WriteString("\n")
+		var emitMu sync.Mutex
+		var wg sync.WaitGroup
+
+		stream := func(r io.Reader, buf *strings.Builder, which core.OutputStream) {
+			defer wg.Done()
+			chunk := make([]byte, 32*1024)
+			for {
+				n, err := r.Read(chunk)
+				if n > 0 {
+					data := string(chunk[:n])
+					emitMu.Lock()
+					buf.WriteString(data)
+					emit(core.OutputChunk{Stream: which, Data: data})
+					emitMu.Unlock()
+				}
+				if err != nil {
+					return
+				}
 			}
-		}()
-
-		go func() {
-			defer close(errorDone)
-			scanner := bufio.NewScanner(stderr)
-			for scanner.Scan() {
-				errorBuf.WriteString(scanner.Text())
-				errorBuf.WriteString("\n")
-			}
-		}()
+		}
 
-		<-outputDone
-		<-errorDone
+		wg.Add(2)
+		go stream(stdout, &outputBuf, core.StreamStdout)
+		go stream(stderr, &errorBuf, core.StreamStderr)
+		wg.Wait()
 
 		err = cmd.Wait()
 
@@ -234,10 +320,10 @@ func (e *Executor) ExecuteAsync(ctx context.Context, command string) (<-chan *co
 			result.ExitCode = 0
 		}
 
-		resultCh <- result
+		events <- core.CommandEvent{Result: result}
 	}()
 
-	return resultCh, nil
+	return events, nil
 }
 
 // ExecuteInteractive runs an interactive command with PTY support.
@@ -264,21 +350,50 @@ func (e *Executor) ExecuteInteractive(ctx context.Context, command string, stdin
 }
 
 // Interrupt stops the currently running command.
+//
+// In session mode Ctrl+C is delivered to the session's terminal, which
+// interrupts the foreground command but keeps the shell alive. Commands
+// running in a process of their own are killed.
 func (e *Executor) Interrupt() error {
 	e.mu.RLock()
 	cmd := e.currentCmd
+	session := e.session
 	e.mu.RUnlock()
 
 	if cmd != nil && cmd.Process != nil {
 		return cmd.Process.Kill()
 	}
+	if session != nil {
+		return session.Interrupt()
+	}
 	return nil
 }
 
+// Suspend stops the command running in the persistent session, as Ctrl+Z
+// does in a terminal, and returns its process group. The stopped command
+// remains a job of the session's shell.
+func (e *Executor) Suspend() (int, error) {
+	e.mu.RLock()
+	session := e.session
+	e.mu.RUnlock()
+
+	if session == nil {
+		return 0, errors.New("suspend requires a persistent session")
+	}
+	return session.Suspend()
+}
+
 // SetShellType sets the shell type (bash/zsh).
+//
+// In session mode the running shell is closed and a shell of the new type
+// is started on the next command.
 func (e *Executor) SetShellType(shellType core.ShellType) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	if e.session != nil && shellType != e.shellType {
+		_ = e.session.Close()
+		e.session = nil
+	}
 	e.shellType = shellType
 	return nil
 }
@@ -292,29 +407,62 @@ func (e *Executor) GetShellType() core.ShellType {
 
 // SetWorkingDirectory sets the current working directory.
 func (e *Executor) SetWorkingDirectory(path string) error {
+	if !e.Local() {
+		e.mu.Lock()
+		session := e.ensureSession()
+		e.mu.Unlock()
+		return e.runSilently(session, "cd -- "+Quote(path))
+	}
+
 	if _, err := os.Stat(path); err != nil {
 		return err
 	}
 	e.mu.Lock()
-	defer e.mu.Unlock()
 	e.workingDir = path
+	session := e.session
+	e.mu.Unlock()
+
+	if session != nil && session.Alive() {
+		return e.runSilently(session, "cd -- "+Quote(path))
+	}
 	return nil
 }
 
 // GetWorkingDirectory returns the current working directory.
+//
+// In session mode this tracks `cd` performed by the shell itself.
 func (e *Executor) GetWorkingDirectory() string {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 	return e.workingDir
 }
 
-// SetEnvironment sets environment variables.
+// envNamePattern matches the names environment variables may have.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SetEnvironment sets environment variables. Nothing is set if any of the
+// names is not a valid variable name.
 func (e *Executor) SetEnvironment(env map[string]string) error {
+	for k := range env {
+		if !envNamePattern.MatchString(k) {
+			return fmt.Errorf("invalid environment variable name %q", k)
+		}
+	}
+
 	e.mu.Lock()
-	defer e.mu.Unlock()
 	for k, v := range env {
 		e.env[k] = v
 	}
+	session := e.session
+	e.mu.Unlock()
+
+	if session != nil && session.Alive() && len(env) > 0 {
+		exports := make([]string, 0, len(env))
+		for k, v := range env {
+			exports = append(exports, "export "+k+"="+Quote(v))
+		}
+		return e.runSilently(session, strings.Join(exports, "; "))
+	}
 	return nil
 }
 
@@ -329,6 +477,20 @@ func (e *Executor) GetEnvironment() map[string]string {
 	return result
 }
 
+// ShellPath returns the path of the shell binary commands run in.
+func (e *Executor) ShellPath() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.getShellPath()
+}
+
+// Environ returns the environment commands run with, in os.Environ form.
+func (e *Executor) Environ() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.buildEnv()
+}
+
 // SetAlias sets a command alias.
 func (e *Executor) SetAlias(name, command string) {
 	e.mu.Lock()
@@ -354,15 +516,111 @@ func (e *Executor) GetAliases() map[string]string {
 	return result
 }
 
-func (e *Executor) getShellPath() string {
-	switch e.shellType {
-	case core.ShellTypeBash:
-		return "/bin/bash"
-	case core.ShellTypeZsh:
-		return "/bin/zsh"
-	default:
-		return "/bin/sh"
+// ExpandAliases returns command with a leading alias replaced by its
+// expansion, as Execute does before running it.
+func (e *Executor) ExpandAliases(command string) string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.expandAliases(command)
+}
+
+// EnableSession switches the executor to persistent session mode.
+//
+// In session mode commands are sent to a long-lived shell attached to a
+// pseudo-terminal instead of a fresh `sh -c` per command, so exports,
+// functions, shell options and `cd` carry over between commands. The
+// shell is started lazily on the first command.
+func (e *Executor) EnableSession() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.persistent = true
+}
+
+// SessionEnabled returns whether the executor runs commands in a persistent session.
+func (e *Executor) SessionEnabled() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.persistent
+}
+
+// Local returns whether commands run on this machine.
+func (e *Executor) Local() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.starter == nil
+}
+
+// Resize sets the terminal size used by the persistent session.
+func (e *Executor) Resize(cols, rows int) error {
+	e.mu.Lock()
+	e.cols, e.rows = cols, rows
+	session := e.session
+	e.mu.Unlock()
+
+	if session != nil {
+		return session.Resize(cols, rows)
+	}
+	return nil
+}
+
+// Close terminates the persistent session, if any.
+func (e *Executor) Close() error {
+	e.mu.Lock()
+	session := e.session
+	e.session = nil
+	e.mu.Unlock()
+
+	if session != nil {
+		return session.Close()
+	}
+	return nil
+}
+
+// ensureSession returns the executor's session, creating it if needed.
+// Callers must hold e.mu.
+func (e *Executor) ensureSession() *Session {
+	if e.session == nil && e.starter != nil {
+		e.session = NewTerminalSession(e.shellType, e.workingDir, e.starter)
+		_ = e.session.Resize(e.cols, e.rows)
+	}
+	if e.session == nil {
+		e.session = NewSession(e.shellType, e.workingDir, e.buildEnv())
+		_ = e.session.Resize(e.cols, e.rows)
+	}
+	return e.session
+}
+
+// runInSession runs a command in the session and syncs the working directory.
+// onChunk, if non-nil, receives output as it arrives.
+func (e *Executor) runInSession(ctx context.Context, session *Session, command string, onChunk func(core.OutputChunk)) (*core.CommandResult, error) {
+	result, err := session.RunStream(ctx, command, onChunk)
+	if err != nil {
+		return nil, err
 	}
+
+	if cwd := session.WorkingDirectory(); cwd != "" {
+		e.mu.Lock()
+		e.workingDir = cwd
+		e.mu.Unlock()
+	}
+
+	return result, nil
+}
+
+// runSilently runs an internal command in the session and reports failure as an error.
+func (e *Executor) runSilently(session *Session, command string) error {
+	result, err := e.runInSession(context.Background(), session, command, nil)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(result.Error))
+	}
+	return nil
+}
+
+func (e *Executor) getShellPath() string {
+	return shellPath(e.shellType)
 }
 
 func (e *Executor) buildEnv() []string {
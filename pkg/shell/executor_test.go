@@ -109,6 +109,15 @@ func TestSetGetEnvironment(t *testing.T) {
 	if result["TEST_VAR"] != "test_value" {
 		t.Errorf("expected test_value, got %s", result["TEST_VAR"])
 	}
+
+	for _, name := range []string{"", "1VAR", "A-B", "X=1; touch /tmp/x #"} {
+		if err := exec.SetEnvironment(map[string]string{"OK_VAR": "1", name: "value"}); err == nil {
+			t.Errorf("expected %q to be refused as a variable name", name)
+		}
+	}
+	if _, ok := exec.GetEnvironment()["OK_VAR"]; ok {
+		t.Error("expected nothing to be set along with an invalid name")
+	}
 }
 
 func TestAliases(t *testing.T) {
@@ -227,20 +236,87 @@ func TestExecuteAsync(t *testing.T) {
 	exec := shell.NewExecutor(core.ShellTypeBash)
 	ctx := context.Background()
 
-	resultCh, err := exec.ExecuteAsync(ctx, "echo async")
+	events, err := exec.ExecuteAsync(ctx, "echo async")
 	if err != nil {
 		t.Fatalf("execute async failed: %v", err)
 	}
 
+	result, chunks := drainEvents(t, events)
+	if result == nil {
+		t.Fatal("expected a final result")
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", result.ExitCode)
+	}
+	if result.Output != "async\n" {
+		t.Errorf("expected 'async\\n', got '%s'", result.Output)
+	}
+	if joinChunks(chunks, core.StreamStdout) != "async\n" {
+		t.Errorf("expected streamed stdout 'async\\n', got %q", joinChunks(chunks, core.StreamStdout))
+	}
+}
+
+func TestExecuteAsyncStreamsBeforeCompletion(t *testing.T) {
+	exec := shell.NewExecutor(core.ShellTypeBash)
+	ctx := context.Background()
+
+	events, err := exec.ExecuteAsync(ctx, "echo first; echo warn >&2; sleep 1; echo second")
+	if err != nil {
+		t.Fatalf("execute async failed: %v", err)
+	}
+
+	// The first chunk must arrive well before the command finishes
+	var first core.OutputChunk
 	select {
-	case result := <-resultCh:
-		if result.ExitCode != 0 {
-			t.Errorf("expected exit code 0, got %d", result.ExitCode)
+	case ev := <-events:
+		if ev.Chunk == nil {
+			t.Fatal("expected an output chunk before the result")
+		}
+		first = *ev.Chunk
+	case <-time.After(900 * time.Millisecond):
+		t.Fatal("timeout waiting for streamed output")
+	}
+
+	result, chunks := drainEvents(t, events)
+	chunks = append([]core.OutputChunk{first}, chunks...)
+	if result == nil || result.ExitCode != 0 {
+		t.Fatalf("expected successful result, got %+v", result)
+	}
+	if got := joinChunks(chunks, core.StreamStderr); got != "warn\n" {
+		t.Errorf("expected streamed stderr 'warn\\n', got %q", got)
+	}
+}
+
+func drainEvents(t *testing.T, events <-chan core.CommandEvent) (*core.CommandResult, []core.OutputChunk) {
+	t.Helper()
+
+	var result *core.CommandResult
+	var chunks []core.OutputChunk
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return result, chunks
+			}
+			if ev.Chunk != nil {
+				chunks = append(chunks, *ev.Chunk)
+			}
+			if ev.Result != nil {
+				result = ev.Result
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for async result")
 		}
-		if result.Output != "async\n" {
-			t.Errorf("expected 'async\\n', got '%s'", result.Output)
+	}
+}
+
+func joinChunks(chunks []core.OutputChunk, stream core.OutputStream) string {
+	var out string
+	for _, c := range chunks {
+		if c.Stream == stream {
+			out += c.Data
 		}
-	case <-time.After(5 * time.Second):
-		t.Error("timeout waiting for async result")
 	}
+	return out
 }
@@ -0,0 +1,678 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// Session timing parameters.
+const (
+	// sessionStartTimeout bounds how long a new shell may take to print its first marker.
+	sessionStartTimeout = 10 * time.Second
+	// sessionInterruptGrace is how long a cancelled command may take to return to the prompt
+	// after Ctrl+C before the whole session is torn down.
+	sessionInterruptGrace = 2 * time.Second
+	// sessionDrainTimeout is how long to wait for trailing output once the shell has exited.
+	sessionDrainTimeout = 100 * time.Millisecond
+)
+
+// markerOSC is the private OSC sequence the shell prints after every command.
+// The full marker is ESC ] 697 ; <token> ; <exit code> ; <cwd> BEL.
+const markerOSC = "\x1b]697;"
+
+// ErrSessionClosed is returned when a command is sent to a closed session.
+var ErrSessionClosed = errors.New("shell session closed")
+
+// Session is a long-lived bash or zsh process attached to a pseudo-terminal.
+//
+// Unlike Executor's one-shot mode, state such as exported variables, shell
+// functions, `set -o` options and the working directory survives between
+// commands. Command boundaries are detected with a per-session marker that
+// the shell prints from its prompt hook, which also reports the exit code
+// and working directory of the command that just finished.
+//
+// stdin and stdout are attached to the PTY so interactive programs work,
+// while stderr is a separate pipe so it can be reported on its own. A
+// session created with NewTerminalSession runs its shell on whatever
+// Terminal the starter provides, such as a PTY on a remote host; if that
+// terminal has no separate stderr, stderr is reported as part of Output.
+//
+// Commands are serialized; Session is safe for concurrent use.
+type Session struct {
+	runMu sync.Mutex   // Serializes command execution
+	mu    sync.RWMutex // Protects the fields below
+
+	shellType core.ShellType
+	dir       string
+	starter   TerminalStarter
+	token     string
+
+	term     Terminal
+	stdoutCh chan []byte
+	stderrCh chan []byte
+	exited   chan struct{}
+	exitCode int
+
+	cwd    string
+	cols   uint16
+	rows   uint16
+	closed bool
+}
+
+// NewSession creates a session for the given shell. The shell process is
+// started lazily by the first call to Start or Run.
+//
+// Parameters:
+//   - shellType: The type of shell to run (bash or zsh)
+//   - dir: The initial working directory
+//   - env: The environment of the shell process, in os.Environ form
+func NewSession(shellType core.ShellType, dir string, env []string) *Session {
+	return NewTerminalSession(shellType, dir, localStarter(shellPath(shellType), env))
+}
+
+// NewTerminalSession creates a session whose shell is started by start.
+// Like NewSession, the shell is started lazily and restarted by the next
+// command if it exits.
+func NewTerminalSession(shellType core.ShellType, dir string, start TerminalStarter) *Session {
+	return &Session{
+		shellType: shellType,
+		dir:       dir,
+		starter:   start,
+		cwd:       dir,
+		cols:      80,
+		rows:      24,
+	}
+}
+
+// Start launches the shell process if it is not already running.
+func (s *Session) Start() error {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	return s.ensureStarted()
+}
+
+// Run sends a command to the shell and waits for it to finish.
+//
+// The command's stdout (including anything written directly to the
+// terminal) is returned in Output and its stderr in Error. If ctx is
+// cancelled, Ctrl+C is sent to the terminal; if the shell does not return
+// to its prompt shortly afterwards, the session is closed and the next
+// Run starts a fresh shell.
+//
+// If the command makes the shell exit (for example `exit 3`), the shell's
+// exit status is reported and the next Run starts a fresh shell.
+func (s *Session) Run(ctx context.Context, command string) (*core.CommandResult, error) {
+	return s.RunStream(ctx, command, nil)
+}
+
+// RunStream is like Run but also calls onChunk with output as it arrives.
+// onChunk is called from the calling goroutine and may be nil.
+func (s *Session) RunStream(ctx context.Context, command string, onChunk func(core.OutputChunk)) (*core.CommandResult, error) {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	if err := s.ensureStarted(); err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+
+	s.mu.RLock()
+	term := s.term
+	s.mu.RUnlock()
+
+	if _, err := io.WriteString(term, command+"\n"); err != nil {
+		s.teardown()
+		return nil, fmt.Errorf("failed to write to shell: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	var stdoutW, stderrW io.Writer = &stdout, &stderr
+	if onChunk != nil {
+		stdoutW = io.MultiWriter(&stdout, chunkWriter{stream: core.StreamStdout, fn: onChunk})
+		stderrW = io.MultiWriter(&stderr, chunkWriter{stream: core.StreamStderr, fn: onChunk})
+	}
+	mark, err := s.collect(ctx, stdoutW, stderrW)
+
+	result := &core.CommandResult{
+		Command:  command,
+		Output:   normalizeNewlines(stdout.String()),
+		Error:    normalizeNewlines(stderr.String()),
+		Duration: time.Since(startTime).Milliseconds(),
+	}
+
+	switch {
+	case mark != nil:
+		result.ExitCode = mark.exitCode
+		s.mu.Lock()
+		s.cwd = mark.cwd
+		s.mu.Unlock()
+	case err != nil:
+		result.ExitCode = -1
+		if result.Error == "" {
+			result.Error = err.Error()
+		}
+	default:
+		// The shell exited while running the command
+		s.mu.RLock()
+		result.ExitCode = s.exitCode
+		s.mu.RUnlock()
+	}
+
+	return result, nil
+}
+
+// Interrupt sends Ctrl+C to the foreground process of the session.
+func (s *Session) Interrupt() error {
+	s.mu.RLock()
+	term := s.term
+	s.mu.RUnlock()
+
+	if term == nil {
+		return nil
+	}
+	_, err := term.Write([]byte{0x03})
+	return err
+}
+
+// Suspend sends Ctrl+Z to the session, which stops the foreground command
+// and returns control to the shell. It returns the process group of the
+// stopped command so it can be tracked as a job. Only local sessions can
+// be suspended.
+func (s *Session) Suspend() (int, error) {
+	s.mu.RLock()
+	term := s.term
+	alive := s.aliveLocked()
+	s.mu.RUnlock()
+
+	if term == nil || !alive {
+		return 0, ErrSessionClosed
+	}
+	local, ok := term.(*localTerminal)
+	if !ok {
+		return 0, errors.New("suspend is only supported in local sessions")
+	}
+	ptmx, shellPID := local.ptmx, local.cmd.Process.Pid
+
+	pgid, err := foregroundProcessGroup(ptmx)
+	if err != nil {
+		return 0, err
+	}
+	if pgid == shellPID {
+		return 0, errors.New("no foreground command to suspend")
+	}
+
+	if _, err := ptmx.Write([]byte{0x1a}); err != nil {
+		return 0, err
+	}
+	return pgid, nil
+}
+
+// Resize changes the size of the session's terminal.
+func (s *Session) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cols = uint16(cols)
+	s.rows = uint16(rows)
+	if s.term == nil {
+		return nil
+	}
+	return s.term.Resize(cols, rows)
+}
+
+// WorkingDirectory returns the shell's working directory as of the last
+// completed command.
+func (s *Session) WorkingDirectory() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cwd
+}
+
+// ShellType returns the type of shell the session runs.
+func (s *Session) ShellType() core.ShellType {
+	return s.shellType
+}
+
+// Alive reports whether the shell process is running.
+func (s *Session) Alive() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.aliveLocked()
+}
+
+// PID returns the process ID of the shell, or 0 if it is not running
+// locally.
+func (s *Session) PID() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	local, ok := s.term.(*localTerminal)
+	if !ok || !s.aliveLocked() {
+		return 0
+	}
+	return local.cmd.Process.Pid
+}
+
+// Close terminates the shell process. A closed session cannot be restarted.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.teardown()
+	return nil
+}
+
+func (s *Session) aliveLocked() bool {
+	if s.term == nil || s.exited == nil {
+		return false
+	}
+	select {
+	case <-s.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// ensureStarted starts the shell if needed. Callers must hold runMu.
+func (s *Session) ensureStarted() error {
+	s.mu.RLock()
+	closed := s.closed
+	alive := s.aliveLocked()
+	s.mu.RUnlock()
+
+	if closed {
+		return ErrSessionClosed
+	}
+	if alive {
+		return nil
+	}
+
+	// Clean up the remains of a previous shell before starting another
+	s.teardown()
+	return s.start()
+}
+
+func (s *Session) start() error {
+	token, err := newSessionToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate session token: %w", err)
+	}
+
+	s.mu.Lock()
+	dir := s.cwd
+	if dir == "" {
+		dir = s.dir
+	}
+
+	term, err := s.starter(s.shellType, dir, int(s.cols), int(s.rows))
+	if err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("failed to start %s: %w", s.shellType, err)
+	}
+
+	s.token = token
+	s.term = term
+	s.stdoutCh = make(chan []byte, 64)
+	s.stderrCh = nil
+	s.exited = make(chan struct{})
+	s.exitCode = 0
+
+	go readChunks(term.Output(), s.stdoutCh)
+	stderr := term.Stderr()
+	if stderr != nil {
+		s.stderrCh = make(chan []byte, 64)
+		go readChunks(stderr, s.stderrCh)
+	}
+	go s.wait(term, s.exited)
+	s.mu.Unlock()
+
+	if _, err := io.WriteString(term, initScript(s.shellType, token, stderr != nil)); err != nil {
+		s.teardown()
+		return fmt.Errorf("failed to initialize %s: %w", s.shellType, err)
+	}
+
+	// Discard the banner, echoed init script and first prompt
+	ctx, cancel := context.WithTimeout(context.Background(), sessionStartTimeout)
+	defer cancel()
+
+	mark, err := s.collect(ctx, io.Discard, io.Discard)
+	if err != nil || mark == nil {
+		s.teardown()
+		if err == nil {
+			err = errors.New("shell exited during startup")
+		}
+		return fmt.Errorf("failed to initialize %s: %w", s.shellType, err)
+	}
+
+	s.mu.Lock()
+	s.cwd = mark.cwd
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *Session) wait(term Terminal, exited chan struct{}) {
+	exitCode := term.Wait()
+
+	s.mu.Lock()
+	s.exitCode = exitCode
+	s.mu.Unlock()
+
+	close(exited)
+}
+
+// collect copies output into stdout and stderr until the shell has printed
+// its marker on both streams. It returns nil and no error if the shell exits
+// first.
+func (s *Session) collect(ctx context.Context, stdout, stderr io.Writer) (*sessionMarker, error) {
+	s.mu.RLock()
+	stdoutCh, stderrCh, exited, token := s.stdoutCh, s.stderrCh, s.exited, s.token
+	s.mu.RUnlock()
+
+	outScan := newMarkerScanner(token)
+	errScan := newMarkerScanner(token)
+
+	var outMark, errMark *sessionMarker
+	if stderrCh == nil {
+		// The terminal carries stderr, so only its marker is printed
+		errMark = &sessionMarker{}
+	}
+	var interrupted <-chan time.Time
+	var draining <-chan time.Time
+	done := ctx.Done()
+
+	for (outMark == nil || errMark == nil) && (stdoutCh != nil || stderrCh != nil) {
+		select {
+		case chunk, ok := <-stdoutCh:
+			if !ok {
+				stdoutCh = nil
+				continue
+			}
+			if outMark != nil {
+				continue
+			}
+			data, mark := outScan.feed(chunk)
+			_, _ = stdout.Write(data)
+			outMark = mark
+
+		case chunk, ok := <-stderrCh:
+			if !ok {
+				stderrCh = nil
+				continue
+			}
+			if errMark != nil {
+				continue
+			}
+			data, mark := errScan.feed(chunk)
+			_, _ = stderr.Write(data)
+			errMark = mark
+
+		case <-done:
+			done = nil
+			_ = s.Interrupt()
+			interrupted = time.After(sessionInterruptGrace)
+
+		case <-interrupted:
+			s.teardown()
+			return nil, ctx.Err()
+
+		case <-exited:
+			exited = nil
+			draining = time.After(sessionDrainTimeout)
+
+		case <-draining:
+			_, _ = stdout.Write(outScan.flush())
+			_, _ = stderr.Write(errScan.flush())
+			return nil, nil
+		}
+	}
+
+	if outMark != nil {
+		return outMark, nil
+	}
+	if errMark != nil {
+		return errMark, nil
+	}
+	_, _ = stdout.Write(outScan.flush())
+	_, _ = stderr.Write(errScan.flush())
+
+	// Both streams hit EOF, so the shell is exiting; wait for its status
+	if exited != nil {
+		select {
+		case <-exited:
+		case <-time.After(sessionInterruptGrace):
+			s.teardown()
+		}
+	}
+	return nil, nil
+}
+
+// teardown stops the shell and releases its terminal.
+func (s *Session) teardown() {
+	s.mu.Lock()
+	term, exited := s.term, s.exited
+	s.term = nil
+	s.mu.Unlock()
+
+	if term != nil {
+		_ = term.Close()
+		if exited != nil {
+			<-exited
+		}
+	}
+}
+
+// readChunks forwards everything read from r to ch and closes ch at EOF.
+func readChunks(r io.Reader, ch chan<- []byte) {
+	defer close(ch)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			ch <- chunk
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// chunkWriter adapts an output callback to an io.Writer.
+type chunkWriter struct {
+	stream core.OutputStream
+	fn     func(core.OutputChunk)
+}
+
+func (w chunkWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.fn(core.OutputChunk{Stream: w.stream, Data: normalizeNewlines(string(p))})
+	}
+	return len(p), nil
+}
+
+// sessionMarker is the parsed form of the marker printed after each command.
+type sessionMarker struct {
+	exitCode int
+	cwd      string
+}
+
+// markerScanner finds the session marker in a stream of output chunks,
+// holding back bytes that might be the start of a marker split across reads.
+type markerScanner struct {
+	prefix []byte
+	buf    []byte
+}
+
+func newMarkerScanner(token string) *markerScanner {
+	return &markerScanner{prefix: []byte(markerOSC + token + ";")}
+}
+
+// feed adds a chunk and returns the output that precedes any marker, along
+// with the marker itself once it is complete.
+func (sc *markerScanner) feed(chunk []byte) ([]byte, *sessionMarker) {
+	sc.buf = append(sc.buf, chunk...)
+
+	if i := bytes.Index(sc.buf, sc.prefix); i >= 0 {
+		end := bytes.IndexByte(sc.buf[i:], '\a')
+		if end < 0 {
+			out := bytes.Clone(sc.buf[:i])
+			sc.buf = bytes.Clone(sc.buf[i:])
+			return out, nil
+		}
+
+		mark := parseMarker(string(sc.buf[i+len(sc.prefix) : i+end]))
+		out := bytes.Clone(sc.buf[:i])
+		sc.buf = nil
+		return out, mark
+	}
+
+	keep := partialPrefixLen(sc.buf, sc.prefix)
+	out := bytes.Clone(sc.buf[:len(sc.buf)-keep])
+	sc.buf = bytes.Clone(sc.buf[len(sc.buf)-keep:])
+	return out, nil
+}
+
+// flush returns any held-back output.
+func (sc *markerScanner) flush() []byte {
+	out := sc.buf
+	sc.buf = nil
+	return out
+}
+
+// partialPrefixLen returns the length of the longest suffix of buf that is a
+// proper prefix of prefix.
+func partialPrefixLen(buf, prefix []byte) int {
+	n := min(len(buf), len(prefix)-1)
+	for ; n > 0; n-- {
+		if bytes.HasPrefix(prefix, buf[len(buf)-n:]) {
+			return n
+		}
+	}
+	return 0
+}
+
+func parseMarker(body string) *sessionMarker {
+	code, cwd, _ := strings.Cut(body, ";")
+	exitCode, err := strconv.Atoi(code)
+	if err != nil {
+		exitCode = -1
+	}
+	return &sessionMarker{exitCode: exitCode, cwd: cwd}
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+// foregroundProcessGroup returns the foreground process group of the
+// terminal whose master side is ptmx.
+func foregroundProcessGroup(ptmx *os.File) (int, error) {
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var pgid int
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		pgid, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if ioctlErr != nil {
+		return 0, fmt.Errorf("failed to read foreground process group: %w", ioctlErr)
+	}
+	return pgid, nil
+}
+
+// Quote quotes s for safe use as a single word in a bash, zsh or POSIX
+// shell command line.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellPath(shellType core.ShellType) string {
+	switch shellType {
+	case core.ShellTypeBash:
+		return "/bin/bash"
+	case core.ShellTypeZsh:
+		return "/bin/zsh"
+	default:
+		return "/bin/sh"
+	}
+}
+
+// shellArgs returns the arguments for an interactive shell without user rc
+// files or line editing, so that input is read verbatim from the terminal.
+func shellArgs(shellType core.ShellType) []string {
+	switch shellType {
+	case core.ShellTypeZsh:
+		return []string{"-f", "-i"}
+	default:
+		return []string{"--noprofile", "--norc", "--noediting", "-i"}
+	}
+}
+
+// initScript returns the commands that configure a fresh shell: terminal
+// echo and prompts are turned off and a prompt hook is installed that
+// prints the command marker on stdout and, if separateStderr is set, on
+// stderr.
+func initScript(shellType core.ShellType, token string, separateStderr bool) string {
+	mark := `printf '\033]697;` + token + `;%d;%s\007' "$__cbwsh_rc" "$PWD"`
+	marks := mark
+	if separateStderr {
+		marks += `; ` + mark + ` >&2`
+	}
+	hook := `{ local __cbwsh_rc=$?; ` + marks + `; return $__cbwsh_rc; }`
+
+	switch shellType {
+	case core.ShellTypeZsh:
+		return strings.Join([]string{
+			"unsetopt zle prompt_cr prompt_sp bang_hist 2>/dev/null",
+			"stty -echo 2>/dev/null",
+			"unset HISTFILE",
+			"PS1=''; PS2=''; RPS1=''",
+			"precmd() " + hook,
+		}, "\n") + "\n"
+	default:
+		return strings.Join([]string{
+			"stty -echo 2>/dev/null",
+			"unset HISTFILE; set +H",
+			"PS1=''; PS2=''",
+			"__cbwsh_mark() " + hook,
+			"PROMPT_COMMAND=__cbwsh_mark",
+		}, "\n") + "\n"
+	}
+}
@@ -0,0 +1,285 @@
+package shell_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/shell"
+)
+
+func newTestSession(t *testing.T) *shell.Session {
+	t.Helper()
+	if _, err := os.Stat("/bin/bash"); err != nil {
+		t.Skip("bash not available")
+	}
+
+	session := shell.NewSession(core.ShellTypeBash, t.TempDir(), os.Environ())
+	t.Cleanup(func() { _ = session.Close() })
+	return session
+}
+
+func runInSession(t *testing.T, session *shell.Session, command string) *core.CommandResult {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := session.Run(ctx, command)
+	if err != nil {
+		t.Fatalf("run %q failed: %v", command, err)
+	}
+	return result
+}
+
+func TestSessionRun(t *testing.T) {
+	session := newTestSession(t)
+
+	result := runInSession(t, session, "echo hello")
+	if result.ExitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", result.ExitCode)
+	}
+	if result.Output != "hello\n" {
+		t.Errorf("expected 'hello\\n', got %q", result.Output)
+	}
+}
+
+func TestSessionRunStream(t *testing.T) {
+	session := newTestSession(t)
+
+	var stdout, stderr string
+	result, err := session.RunStream(context.Background(), "printf 'a\\nb\\n'; echo e >&2", func(chunk core.OutputChunk) {
+		if chunk.Stream == core.StreamStderr {
+			stderr += chunk.Data
+		} else {
+			stdout += chunk.Data
+		}
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if stdout != "a\nb\n" || stdout != result.Output {
+		t.Errorf("expected streamed stdout to match result, got %q and %q", stdout, result.Output)
+	}
+	if stderr != "e\n" || stderr != result.Error {
+		t.Errorf("expected streamed stderr to match result, got %q and %q", stderr, result.Error)
+	}
+}
+
+func TestSessionPersistsState(t *testing.T) {
+	session := newTestSession(t)
+
+	runInSession(t, session, "export CBWSH_TEST_VAR=persisted")
+	runInSession(t, session, "greet() { echo \"hi $1\"; }")
+	runInSession(t, session, "set -o noclobber")
+
+	if result := runInSession(t, session, "echo $CBWSH_TEST_VAR"); result.Output != "persisted\n" {
+		t.Errorf("expected exported variable to persist, got %q", result.Output)
+	}
+	if result := runInSession(t, session, "greet there"); result.Output != "hi there\n" {
+		t.Errorf("expected function to persist, got %q", result.Output)
+	}
+	if result := runInSession(t, session, "[[ -o noclobber ]] && echo on"); result.Output != "on\n" {
+		t.Errorf("expected shell option to persist, got %q", result.Output)
+	}
+}
+
+func TestSessionTracksWorkingDirectory(t *testing.T) {
+	session := newTestSession(t)
+	dir := t.TempDir()
+
+	runInSession(t, session, "mkdir -p sub && cd "+dir)
+	if session.WorkingDirectory() != dir {
+		t.Errorf("expected working directory %s, got %s", dir, session.WorkingDirectory())
+	}
+
+	if result := runInSession(t, session, "pwd"); result.Output != dir+"\n" {
+		t.Errorf("expected pwd %s, got %q", dir, result.Output)
+	}
+}
+
+func TestSessionExitCodeAndStderr(t *testing.T) {
+	session := newTestSession(t)
+
+	result := runInSession(t, session, "echo out; echo oops >&2; (exit 3)")
+	if result.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", result.ExitCode)
+	}
+	if result.Output != "out\n" {
+		t.Errorf("expected stdout 'out\\n', got %q", result.Output)
+	}
+	if result.Error != "oops\n" {
+		t.Errorf("expected stderr 'oops\\n', got %q", result.Error)
+	}
+}
+
+func TestSessionCancel(t *testing.T) {
+	session := newTestSession(t)
+	runInSession(t, session, "export KEEP=1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := session.Run(ctx, "sleep 30")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("expected cancellation to interrupt the command")
+	}
+	if result.ExitCode != 130 {
+		t.Errorf("expected exit code 130 after interrupt, got %d", result.ExitCode)
+	}
+
+	// The shell itself survives the interrupt
+	if result := runInSession(t, session, "echo $KEEP"); result.Output != "1\n" {
+		t.Errorf("expected session state to survive interrupt, got %q", result.Output)
+	}
+}
+
+func TestSessionSuspend(t *testing.T) {
+	session := newTestSession(t)
+
+	if _, err := session.Suspend(); err == nil {
+		t.Error("expected error suspending a session that is not running")
+	}
+	runInSession(t, session, "true")
+	if _, err := session.Suspend(); err == nil {
+		t.Error("expected error suspending with no foreground command")
+	}
+
+	done := make(chan *core.CommandResult, 1)
+	go func() {
+		result, _ := session.Run(context.Background(), "sleep 30")
+		done <- result
+	}()
+
+	var pgid int
+	deadline := time.Now().Add(5 * time.Second)
+	for pgid == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		pgid, _ = session.Suspend()
+	}
+	if pgid == 0 {
+		t.Fatal("expected to suspend the running command")
+	}
+
+	select {
+	case result := <-done:
+		if result == nil || result.ExitCode != 148 {
+			t.Errorf("expected exit code 148 for stopped command, got %+v", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected suspended command to return to the prompt")
+	}
+
+	if result := runInSession(t, session, "jobs -l"); !strings.Contains(result.Output, strconv.Itoa(pgid)) {
+		t.Errorf("expected stopped job %d in job table, got %q", pgid, result.Output)
+	}
+	runInSession(t, session, "kill -9 %1")
+}
+
+func TestSessionRestartsAfterExit(t *testing.T) {
+	session := newTestSession(t)
+
+	result := runInSession(t, session, "exit 4")
+	if result.ExitCode != 4 {
+		t.Errorf("expected exit code 4, got %d", result.ExitCode)
+	}
+	if session.Alive() {
+		t.Error("expected session to be dead after exit")
+	}
+
+	if result := runInSession(t, session, "echo again"); result.Output != "again\n" {
+		t.Errorf("expected restarted session to run commands, got %q", result.Output)
+	}
+}
+
+func TestSessionClosed(t *testing.T) {
+	session := newTestSession(t)
+	_ = session.Close()
+
+	if _, err := session.Run(context.Background(), "true"); err == nil {
+		t.Error("expected error running command in closed session")
+	}
+}
+
+func TestExecutorSessionMode(t *testing.T) {
+	if _, err := os.Stat("/bin/bash"); err != nil {
+		t.Skip("bash not available")
+	}
+
+	exec := shell.NewExecutor(core.ShellTypeBash)
+	exec.EnableSession()
+	defer exec.Close()
+
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	if _, err := exec.Execute(ctx, "cd "+dir+" && FOO=bar; export FOO"); err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if exec.GetWorkingDirectory() != dir {
+		t.Errorf("expected working directory %s, got %s", dir, exec.GetWorkingDirectory())
+	}
+
+	events, err := exec.ExecuteAsync(ctx, "echo streamed")
+	if err != nil {
+		t.Fatalf("execute async failed: %v", err)
+	}
+	result, chunks := drainEvents(t, events)
+	if result == nil || result.Output != "streamed\n" || joinChunks(chunks, core.StreamStdout) != "streamed\n" {
+		t.Errorf("expected streamed session output, got %+v", result)
+	}
+
+	if err := exec.SetEnvironment(map[string]string{"BAZ": "it's quoted"}); err != nil {
+		t.Fatalf("set environment failed: %v", err)
+	}
+
+	result, err = exec.Execute(ctx, "echo \"$FOO $BAZ\"")
+	if err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if result.Output != "bar it's quoted\n" {
+		t.Errorf("expected 'bar it's quoted\\n', got %q", result.Output)
+	}
+}
+
+func TestExecutorSessionEnvIsPerCommand(t *testing.T) {
+	if _, err := os.Stat("/bin/bash"); err != nil {
+		t.Skip("bash not available")
+	}
+
+	exec := shell.NewExecutor(core.ShellTypeBash)
+	exec.EnableSession()
+	defer exec.Close()
+
+	dir := t.TempDir()
+	ctx := context.Background()
+	if err := exec.SetWorkingDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := exec.ExecuteAsyncWithEnv(ctx, "echo \"$TOKEN\"; pwd", map[string]string{"TOKEN": "s3cret"})
+	if err != nil {
+		t.Fatalf("execute async failed: %v", err)
+	}
+	result, _ := drainEvents(t, events)
+	if result == nil || result.Output != "s3cret\n"+dir+"\n" {
+		t.Errorf("expected the variable in the session's directory, got %+v", result)
+	}
+
+	// The session's shell never sees it
+	result, err = exec.Execute(ctx, "echo \"[$TOKEN]\"")
+	if err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+	if result.Output != "[]\n" {
+		t.Errorf("expected the variable not to reach the session, got %q", result.Output)
+	}
+}
@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/creack/pty"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// Terminal is a running shell attached to a terminal. The shell may run on
+// this machine or on a remote host.
+type Terminal interface {
+	// Write sends input to the terminal.
+	io.Writer
+	// Output returns the terminal's output stream.
+	Output() io.Reader
+	// Stderr returns the shell's standard error if it is kept apart from
+	// the terminal, or nil if the shell writes it to the terminal.
+	Stderr() io.Reader
+	// Resize changes the terminal size.
+	Resize(cols, rows int) error
+	// Wait blocks until the shell exits and returns its exit status.
+	Wait() int
+	// Close stops the shell and releases the terminal.
+	Close() error
+}
+
+// TerminalStarter starts an interactive shell of the given type on a
+// terminal of the given size. The shell starts in dir; an empty dir leaves
+// the choice to the starter.
+type TerminalStarter func(shellType core.ShellType, dir string, cols, rows int) (Terminal, error)
+
+// InteractiveCommand returns the command line that starts an interactive
+// shell without user rc files or line editing, as sessions expect.
+func InteractiveCommand(shellType core.ShellType) string {
+	name := "bash"
+	if shellType == core.ShellTypeZsh {
+		name = "zsh"
+	}
+	return name + " " + strings.Join(shellArgs(shellType), " ")
+}
+
+// localTerminal is a shell process on a local pseudo-terminal, with its
+// stderr on a separate pipe.
+type localTerminal struct {
+	cmd    *exec.Cmd
+	ptmx   *os.File
+	stderr *os.File
+}
+
+// localStarter returns a TerminalStarter that runs shellPath locally with
+// the environment env.
+func localStarter(shellPath string, env []string) TerminalStarter {
+	return func(shellType core.ShellType, dir string, cols, rows int) (Terminal, error) {
+		cmd := exec.Command(shellPath, shellArgs(shellType)...)
+		cmd.Dir = dir
+		cmd.Env = env
+
+		stderrR, stderrW, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stderr = stderrW
+
+		ptmx, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+		stderrW.Close()
+		if err != nil {
+			stderrR.Close()
+			return nil, err
+		}
+
+		return &localTerminal{cmd: cmd, ptmx: ptmx, stderr: stderrR}, nil
+	}
+}
+
+func (t *localTerminal) Write(p []byte) (int, error) {
+	return t.ptmx.Write(p)
+}
+
+func (t *localTerminal) Output() io.Reader {
+	return t.ptmx
+}
+
+func (t *localTerminal) Stderr() io.Reader {
+	return t.stderr
+}
+
+func (t *localTerminal) Resize(cols, rows int) error {
+	return pty.Setsize(t.ptmx, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+func (t *localTerminal) Wait() int {
+	_ = t.cmd.Wait()
+	if t.cmd.ProcessState == nil {
+		return -1
+	}
+	return t.cmd.ProcessState.ExitCode()
+}
+
+func (t *localTerminal) Close() error {
+	if t.cmd.Process != nil {
+		_ = t.cmd.Process.Kill()
+	}
+	_ = t.stderr.Close()
+	return t.ptmx.Close()
+}
@@ -0,0 +1,379 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// vaultKeyPrefix prefixes the names of the secrets holding the keys of a
+// VaultAgent.
+const vaultKeyPrefix = "ssh-key/"
+
+// SecretStore is an encrypted key-value store, such as secrets.Manager.
+type SecretStore interface {
+	Store(key string, value []byte) error
+	Retrieve(key string) ([]byte, error)
+	Delete(key string) error
+	List() ([]string, error)
+}
+
+// VaultAgent is an SSH agent whose keys live in a SecretStore instead of
+// on disk. Keys are read from the store for every request, so they are
+// only usable while the store is unlocked, and only held in memory while a
+// request is served.
+type VaultAgent struct {
+	mu         sync.Mutex
+	store      SecretStore
+	passphrase []byte
+	locked     bool
+}
+
+// NewVaultAgent creates an agent backed by store.
+func NewVaultAgent(store SecretStore) *VaultAgent {
+	return &VaultAgent{store: store}
+}
+
+// vaultKey is a key of a VaultAgent.
+type vaultKey struct {
+	name   string
+	signer ssh.Signer
+}
+
+// keys loads the agent's keys from the store, sorted by name.
+func (a *VaultAgent) keys() ([]vaultKey, error) {
+	names, err := a.store.List()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var keys []vaultKey
+	for _, name := range names {
+		if !strings.HasPrefix(name, vaultKeyPrefix) {
+			continue
+		}
+		data, err := a.store.Retrieve(name)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		clear(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		keys = append(keys, vaultKey{name: strings.TrimPrefix(name, vaultKeyPrefix), signer: signer})
+	}
+	return keys, nil
+}
+
+// find returns the stored key matching pub.
+func (a *VaultAgent) find(pub ssh.PublicKey) (vaultKey, error) {
+	keys, err := a.keys()
+	if err != nil {
+		return vaultKey{}, err
+	}
+	wire := pub.Marshal()
+	for _, key := range keys {
+		if bytes.Equal(key.signer.PublicKey().Marshal(), wire) {
+			return key, nil
+		}
+	}
+	return vaultKey{}, errors.New("key not found")
+}
+
+// List returns the public keys in the store, commented with their names.
+func (a *VaultAgent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, nil
+	}
+
+	keys, err := a.keys()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*agent.Key, 0, len(keys))
+	for _, key := range keys {
+		pub := key.signer.PublicKey()
+		result = append(result, &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: key.name})
+	}
+	return result, nil
+}
+
+// Sign signs data with the stored key matching pub.
+func (a *VaultAgent) Sign(pub ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(pub, data, 0)
+}
+
+// SignWithFlags signs data with the stored key matching pub, using the
+// RSA signature algorithm the flags ask for.
+func (a *VaultAgent) SignWithFlags(pub ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, errors.New("agent is locked")
+	}
+
+	key, err := a.find(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := ""
+	switch {
+	case flags&agent.SignatureFlagRsaSha256 != 0:
+		algorithm = ssh.KeyAlgoRSASHA256
+	case flags&agent.SignatureFlagRsaSha512 != 0:
+		algorithm = ssh.KeyAlgoRSASHA512
+	}
+	if algorithm == "" {
+		return key.signer.Sign(rand.Reader, data)
+	}
+	signer, ok := key.signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("key %s does not support %s", key.name, algorithm)
+	}
+	return signer.SignWithAlgorithm(rand.Reader, data, algorithm)
+}
+
+// Add stores a private key under its comment, or its fingerprint if it has
+// none. Certificates and constraints are not supported.
+func (a *VaultAgent) Add(key agent.AddedKey) error {
+	if key.Certificate != nil {
+		return errors.New("certificates are not supported")
+	}
+	if key.LifetimeSecs != 0 || key.ConfirmBeforeUse || len(key.ConstraintExtensions) > 0 {
+		return errors.New("key constraints are not supported")
+	}
+
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	name := key.Comment
+	if name == "" {
+		name = ssh.FingerprintSHA256(signer.PublicKey())
+	}
+
+	block, err := ssh.MarshalPrivateKey(key.PrivateKey, key.Comment)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(block)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errors.New("agent is locked")
+	}
+	return a.store.Store(vaultKeyPrefix+name, data)
+}
+
+// ImportKey stores a PEM-encoded private key, decrypting it with
+// passphrase if it is protected, under name.
+func (a *VaultAgent) ImportKey(name string, data, passphrase []byte) error {
+	var (
+		key any
+		err error
+	)
+	if len(passphrase) > 0 {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+	} else {
+		key, err = ssh.ParseRawPrivateKey(data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse key: %w", err)
+	}
+	return a.Add(agent.AddedKey{PrivateKey: key, Comment: name})
+}
+
+// Remove deletes the stored key matching pub.
+func (a *VaultAgent) Remove(pub ssh.PublicKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errors.New("agent is locked")
+	}
+
+	key, err := a.find(pub)
+	if err != nil {
+		return err
+	}
+	return a.store.Delete(vaultKeyPrefix + key.name)
+}
+
+// RemoveAll deletes every stored key.
+func (a *VaultAgent) RemoveAll() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errors.New("agent is locked")
+	}
+
+	names, err := a.store.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, vaultKeyPrefix) {
+			if err := a.store.Delete(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Lock refuses requests until Unlock is called with the same passphrase.
+func (a *VaultAgent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return errors.New("agent is already locked")
+	}
+	a.locked = true
+	a.passphrase = bytes.Clone(passphrase)
+	return nil
+}
+
+// Unlock undoes Lock.
+func (a *VaultAgent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.locked {
+		return errors.New("agent is not locked")
+	}
+	if !bytes.Equal(passphrase, a.passphrase) {
+		return errors.New("incorrect passphrase")
+	}
+	clear(a.passphrase)
+	a.locked, a.passphrase = false, nil
+	return nil
+}
+
+// Signers returns signers for the stored keys.
+func (a *VaultAgent) Signers() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.locked {
+		return nil, nil
+	}
+
+	keys, err := a.keys()
+	if err != nil {
+		return nil, err
+	}
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, key := range keys {
+		signers = append(signers, key.signer)
+	}
+	return signers, nil
+}
+
+// Extension reports that no extensions are supported.
+func (a *VaultAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// ConnectAgent connects to the agent listening on socket, or on
+// SSH_AUTH_SOCK if socket is empty, and uses it from then on for
+// authentication and agent forwarding.
+func (m *Manager) ConnectAgent(socket string) error {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return errors.New("SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to agent: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.agentConn != nil {
+		_ = m.agentConn.Close()
+	}
+	m.agent = agent.NewClient(conn)
+	m.agentConn = conn
+	return nil
+}
+
+// SetAgent sets the agent used for authentication and agent forwarding,
+// such as a VaultAgent. nil disables the agent.
+func (m *Manager) SetAgent(a agent.Agent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.agentConn != nil {
+		_ = m.agentConn.Close()
+		m.agentConn = nil
+	}
+	m.agent = a
+}
+
+// Agent returns the agent in use, or nil.
+func (m *Manager) Agent() agent.Agent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.agent
+}
+
+// SetForwardAgent enables or disables forwarding the agent to every host.
+// Hosts with ForwardAgent set have it forwarded either way.
+func (m *Manager) SetForwardAgent(forward bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.forwardAgent = forward
+}
+
+// forwardedAgent returns the agent to forward to host, or nil if it is
+// not to be forwarded there.
+func (m *Manager) forwardedAgent(host core.SSHHost) agent.Agent {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.forwardAgent && !host.ForwardAgent {
+		return nil
+	}
+	return m.agent
+}
+
+// serveAgent makes client answer the host's agent requests with a, if a
+// is not nil, and reports whether it does. Sessions must still ask for
+// forwarding with agent.RequestAgentForwarding.
+func serveAgent(client *ssh.Client, a agent.Agent) bool {
+	if a == nil {
+		return false
+	}
+	return agent.ForwardToAgent(client, a) == nil
+}
+
+// agentSigners returns the signers of the agent, if there is one.
+func (m *Manager) agentSigners() []ssh.Signer {
+	m.mu.RLock()
+	a := m.agent
+	m.mu.RUnlock()
+
+	if a == nil {
+		return nil
+	}
+	signers, err := a.Signers()
+	if err != nil {
+		return nil
+	}
+	return signers
+}
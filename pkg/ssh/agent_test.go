@@ -0,0 +1,227 @@
+package ssh_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// serveAgent serves a on a Unix socket and returns the socket's path.
+func serveAgent(t *testing.T, a agent.Agent) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(a, conn)
+			}()
+		}
+	}()
+	return socket
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestConnectAgent(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: server.authorizeNewKey(t), Comment: "deploy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	if err := manager.ConnectAgent(serveAgent(t, keyring)); err != nil {
+		t.Fatalf("ConnectAgent failed: %v", err)
+	}
+
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester"}
+	conn, err := manager.Open(context.Background(), "agent", host)
+	if err != nil {
+		t.Fatalf("Open with agent keys failed: %v", err)
+	}
+	if conn.ForwardsAgent() {
+		t.Error("expected the agent not to be forwarded by default")
+	}
+
+	// The agent's keys come first, and a password still works after them
+	other := newTestServer(t)
+	if _, err := manager.Open(context.Background(), "password", core.SSHHost{Host: "127.0.0.1", Port: other.port(), User: "tester"}, gossh.Password(testPassword)); err != nil {
+		t.Fatalf("Open with password failed: %v", err)
+	}
+
+	if err := manager.ConnectAgent(filepath.Join(t.TempDir(), "missing.sock")); err == nil {
+		t.Error("expected an error for a missing socket")
+	}
+}
+
+func TestAgentForwarding(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: server.authorizeNewKey(t), Comment: "forwarded"}); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	manager.SetAgent(keyring)
+
+	// ForwardAgent on the host forwards the agent to it alone
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester", ForwardAgent: true}
+	conn, err := manager.Open(context.Background(), "fwd", host)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if !conn.ForwardsAgent() {
+		t.Fatal("expected the agent to be forwarded")
+	}
+	if _, err := conn.Execute(context.Background(), "true"); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if !waitFor(t, func() bool { return len(server.agentKeyComments()) == 1 }) {
+		t.Fatalf("expected the server to list the forwarded agent, got %v", server.agentKeyComments())
+	}
+	if got := server.agentKeyComments()[0]; got != "forwarded" {
+		t.Errorf("expected key comment forwarded, got %q", got)
+	}
+
+	// Terminals ask for forwarding too
+	terminal, err := conn.StartTerminal(core.ShellTypeBash, "", 80, 24)
+	if err != nil {
+		t.Fatalf("StartTerminal failed: %v", err)
+	}
+	defer terminal.Close()
+	if !waitFor(t, func() bool { return len(server.agentKeyComments()) == 2 }) {
+		t.Errorf("expected the terminal session to forward the agent, got %v", server.agentKeyComments())
+	}
+}
+
+func TestVaultAgent(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := secrets.NewManager(filepath.Join(dir, "secrets.enc"))
+	if err := store.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	vault := ssh.NewVaultAgent(store)
+
+	server := newTestServer(t)
+	if err := vault.Add(agent.AddedKey{PrivateKey: server.authorizeNewKey(t), Comment: "added"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	// A passphrase-protected key file is imported decrypted into the vault
+	_, imported, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(imported, "", []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vault.ImportKey("imported", pem.EncodeToMemory(block), []byte("pass")); err != nil {
+		t.Fatalf("ImportKey failed: %v", err)
+	}
+
+	keys, err := vault.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0].Comment != "added" || keys[1].Comment != "imported" {
+		t.Fatalf("expected keys added and imported, got %v", keys)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "secrets.enc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("PRIVATE KEY")) {
+		t.Error("expected keys to be stored encrypted")
+	}
+
+	// Keys are served over the agent protocol and used to authenticate
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	if err := manager.ConnectAgent(serveAgent(t, vault)); err != nil {
+		t.Fatal(err)
+	}
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester"}
+	if _, err := manager.Open(context.Background(), "vault", host); err != nil {
+		t.Fatalf("Open with vault keys failed: %v", err)
+	}
+
+	// Locking the store makes the keys unusable
+	if err := store.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Open(context.Background(), "locked", host); err == nil {
+		t.Error("expected authentication to fail with the store locked")
+	}
+	if err := store.Unlock("master"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := vault.Lock([]byte("agent")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := vault.List(); len(keys) != 0 {
+		t.Errorf("expected a locked agent to list no keys, got %v", keys)
+	}
+	if err := vault.Unlock([]byte("wrong")); err == nil {
+		t.Error("expected unlocking with the wrong passphrase to fail")
+	}
+	if err := vault.Unlock([]byte("agent")); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	if err := vault.Remove(keys[0]); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if keys, _ := vault.List(); len(keys) != 1 || keys[0].Comment != "imported" {
+		t.Errorf("expected only imported to remain, got %v", keys)
+	}
+	names, _ := store.List()
+	if len(names) != 1 || !strings.HasPrefix(names[0], "ssh-key/") {
+		t.Errorf("expected one key in the store, got %v", names)
+	}
+}
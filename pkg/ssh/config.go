@@ -0,0 +1,391 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth bounds nested Include directives, as in OpenSSH.
+const maxIncludeDepth = 16
+
+// Config is a parsed OpenSSH client configuration, such as ~/.ssh/config.
+//
+// Host blocks, Include and the keywords cbwsh uses are understood: HostName,
+// User, Port, IdentityFile, ProxyJump and ForwardAgent. Other keywords are ignored, as
+// are Match blocks, which never apply.
+type Config struct {
+	entries  []configEntry
+	hosts    []string
+	patterns []string
+}
+
+// configEntry is a directive together with the Host patterns it is
+// subject to.
+type configEntry struct {
+	// patterns are the patterns of the enclosing Host line; nil for
+	// directives before the first Host line, which apply to every host.
+	patterns []string
+	// inMatch is set for directives in a Match block.
+	inMatch bool
+	key     string
+	value   string
+}
+
+// HostConfig is the configuration that applies to a host alias.
+type HostConfig struct {
+	// Alias is the name that was resolved.
+	Alias string
+	// HostName is the real host name; the alias if not configured.
+	HostName string
+	// User is the remote user, or "" if not configured.
+	User string
+	// Port is the port, or 0 if not configured.
+	Port int
+	// IdentityFiles are the configured key files, in order.
+	IdentityFiles []string
+	// ProxyJump lists the jump hosts, separated by commas; "" if none.
+	ProxyJump string
+	// ForwardAgent is set when the agent is to be forwarded.
+	ForwardAgent bool
+}
+
+// LoadConfig parses the OpenSSH client configuration at path. Relative
+// Include paths are resolved against the directory of path.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if err := c.load(path, filepath.Dir(path), nil, false, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParseConfig parses an OpenSSH client configuration. Relative Include
+// paths are resolved against dir.
+func ParseConfig(r io.Reader, dir string) (*Config, error) {
+	c := &Config{}
+	if err := c.parse(r, dir, nil, false, 0); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) load(path, dir string, patterns []string, inMatch bool, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := c.parse(f, dir, patterns, inMatch, depth); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// parse adds the directives read from r. patterns and inMatch are the
+// state of the enclosing block, which an included file starts in.
+func (c *Config) parse(r io.Reader, dir string, patterns []string, inMatch bool, depth int) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		key, args, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			if len(args) == 0 {
+				return fmt.Errorf("line %d: Host needs at least one pattern", lineNum)
+			}
+			patterns, inMatch = args, false
+			c.addHosts(args)
+
+		case "match":
+			patterns, inMatch = nil, true
+
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("line %d: Include nested too deeply", lineNum)
+			}
+			for _, arg := range args {
+				if err := c.include(arg, dir, patterns, inMatch, depth+1); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if len(args) == 0 {
+				return fmt.Errorf("line %d: %s needs a value", lineNum, key)
+			}
+			c.entries = append(c.entries, configEntry{
+				patterns: patterns,
+				inMatch:  inMatch,
+				key:      key,
+				value:    strings.Join(args, " "),
+			})
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses the files matching pattern. Missing files are ignored, as
+// OpenSSH does.
+func (c *Config) include(pattern, dir string, patterns []string, inMatch bool, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("Include %s: %w", pattern, err)
+	}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		if err := c.load(path, dir, patterns, inMatch, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve returns the configuration for alias. As in OpenSSH the first
+// value found for a keyword wins, except IdentityFile, whose values add
+// up.
+func (c *Config) Resolve(alias string) HostConfig {
+	hc := HostConfig{Alias: alias}
+	seen := make(map[string]bool)
+
+	for _, entry := range c.entries {
+		if entry.inMatch || (entry.patterns != nil && !matchHostPatterns(entry.patterns, alias)) {
+			continue
+		}
+		if entry.key == "identityfile" {
+			hc.IdentityFiles = append(hc.IdentityFiles, entry.value)
+			continue
+		}
+		if seen[entry.key] {
+			continue
+		}
+		seen[entry.key] = true
+
+		switch entry.key {
+		case "hostname":
+			hc.HostName = entry.value
+		case "user":
+			hc.User = entry.value
+		case "port":
+			if port, err := strconv.Atoi(entry.value); err == nil {
+				hc.Port = port
+			}
+		case "proxyjump":
+			if !strings.EqualFold(entry.value, "none") {
+				hc.ProxyJump = entry.value
+			}
+		case "forwardagent":
+			hc.ForwardAgent = strings.EqualFold(entry.value, "yes")
+		}
+	}
+
+	hc.HostName = expandTokens(hc.HostName, map[byte]string{'h': alias})
+	if hc.HostName == "" {
+		hc.HostName = alias
+	}
+
+	localUser := ""
+	if current, err := user.Current(); err == nil {
+		localUser = current.Username
+	}
+	home, _ := os.UserHomeDir()
+	remoteUser := hc.User
+	if remoteUser == "" {
+		remoteUser = localUser
+	}
+	tokens := map[byte]string{
+		'd': home,
+		'h': hc.HostName,
+		'n': alias,
+		'r': remoteUser,
+		'u': localUser,
+	}
+	for i, path := range hc.IdentityFiles {
+		hc.IdentityFiles[i] = expandHome(expandTokens(path, tokens))
+	}
+
+	return hc
+}
+
+// Hosts returns the aliases named by Host lines, without patterns, in the
+// order they appear.
+func (c *Config) Hosts() []string {
+	return append([]string(nil), c.hosts...)
+}
+
+// Patterns returns the wildcard patterns of Host lines, such as web-*, in
+// the order they appear. Negated patterns are left out.
+func (c *Config) Patterns() []string {
+	return append([]string(nil), c.patterns...)
+}
+
+// addHosts records the plain aliases and the wildcard patterns among the
+// patterns of a Host line.
+func (c *Config) addHosts(patterns []string) {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		if strings.ContainsAny(pattern, "*?") {
+			known := false
+			for _, existing := range c.patterns {
+				known = known || existing == pattern
+			}
+			if !known && pattern != "*" {
+				c.patterns = append(c.patterns, pattern)
+			}
+			continue
+		}
+		known := false
+		for _, host := range c.hosts {
+			known = known || host == pattern
+		}
+		if !known {
+			c.hosts = append(c.hosts, pattern)
+		}
+	}
+}
+
+// splitConfigLine splits a configuration line into its lowercased keyword
+// and arguments. Keywords may be separated from their arguments by spaces
+// or an equals sign, and arguments may be double-quoted.
+func splitConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" || rest[0] == '#' {
+			return key, args, nil
+		}
+		if rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return "", nil, errors.New("unterminated quote")
+			}
+			args = append(args, rest[1:1+closing])
+			rest = rest[2+closing:]
+			continue
+		}
+		n := strings.IndexAny(rest, " \t")
+		if n < 0 {
+			n = len(rest)
+		}
+		args = append(args, rest[:n])
+		rest = rest[n:]
+	}
+}
+
+// matchHostPatterns reports whether host matches a Host line: it must
+// match one of the patterns and none of the negated ones.
+func matchHostPatterns(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.ToLower(strings.TrimPrefix(pattern, "!"))
+		if !matchWildcard(pattern, host) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// matchWildcard matches s against a pattern in which * matches any run of
+// characters and ? matches one.
+func matchWildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// expandTokens replaces %x tokens in s with their values; %% is a percent
+// sign. Unknown tokens are left as they are.
+func expandTokens(s string, tokens map[byte]string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if s[i] == '%' {
+			b.WriteByte('%')
+		} else if value, ok := tokens[s[i]]; ok {
+			b.WriteString(value)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// expandHome replaces a leading ~ in path with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
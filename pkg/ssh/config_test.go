@@ -0,0 +1,265 @@
+package ssh_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+const testConfig = `# Global defaults come last in real files, but apply everywhere
+Host web web-*
+    HostName %h.example.com
+    User deploy
+    IdentityFile ~/.ssh/web_%r
+
+Host db
+    HostName=10.0.0.5
+    Port 2200
+    ProxyJump bastion
+    ForwardAgent yes
+
+Host bastion
+    HostName bastion.example.com
+    User jump
+    ProxyJump none
+
+Host *.internal !skip.internal
+    User ops
+
+Match host db
+    User ignored
+
+Include conf.d/*.conf
+
+Host *
+    User fallback
+    Port 2222
+    IdentityFile "/keys/with space"
+`
+
+func writeConfig(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"config":           testConfig,
+		"conf.d/app.conf":  "Host app\n  HostName app.example.com\n  Include nested.conf\n",
+		"conf.d/other.txt": "Host ignored\n",
+		"nested.conf":      "Port 8022\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "config")
+}
+
+func TestConfigResolve(t *testing.T) {
+	t.Parallel()
+
+	config, err := ssh.LoadConfig(writeConfig(t))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	home, _ := os.UserHomeDir()
+
+	tests := []struct {
+		alias string
+		want  ssh.HostConfig
+	}{
+		{"web", ssh.HostConfig{
+			Alias: "web", HostName: "web.example.com", User: "deploy", Port: 2222,
+			IdentityFiles: []string{filepath.Join(home, ".ssh/web_deploy"), "/keys/with space"},
+		}},
+		{"web-2", ssh.HostConfig{
+			Alias: "web-2", HostName: "web-2.example.com", User: "deploy", Port: 2222,
+			IdentityFiles: []string{filepath.Join(home, ".ssh/web_deploy"), "/keys/with space"},
+		}},
+		{"db", ssh.HostConfig{
+			Alias: "db", HostName: "10.0.0.5", User: "fallback", Port: 2200, ProxyJump: "bastion", ForwardAgent: true,
+			IdentityFiles: []string{"/keys/with space"},
+		}},
+		{"bastion", ssh.HostConfig{
+			Alias: "bastion", HostName: "bastion.example.com", User: "jump", Port: 2222,
+			IdentityFiles: []string{"/keys/with space"},
+		}},
+		{"api.internal", ssh.HostConfig{
+			Alias: "api.internal", HostName: "api.internal", User: "ops", Port: 2222,
+			IdentityFiles: []string{"/keys/with space"},
+		}},
+		{"skip.internal", ssh.HostConfig{
+			Alias: "skip.internal", HostName: "skip.internal", User: "fallback", Port: 2222,
+			IdentityFiles: []string{"/keys/with space"},
+		}},
+		{"app", ssh.HostConfig{
+			Alias: "app", HostName: "app.example.com", User: "fallback", Port: 8022,
+			IdentityFiles: []string{"/keys/with space"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			if got := config.Resolve(tt.alias); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve(%q) = %+v\nwant %+v", tt.alias, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigHosts(t *testing.T) {
+	t.Parallel()
+
+	config, err := ssh.LoadConfig(writeConfig(t))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	want := []string{"web", "db", "bastion", "app"}
+	if got := config.Hosts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hosts() = %v, want %v", got, want)
+	}
+
+	// Negated patterns and the catch-all are not host groups
+	wantPatterns := []string{"web-*", "*.internal"}
+	if got := config.Patterns(); !reflect.DeepEqual(got, wantPatterns) {
+		t.Errorf("Patterns() = %v, want %v", got, wantPatterns)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{
+		"Host\n",
+		"Host a\n  User\n",
+		"Host a\n  IdentityFile \"unterminated\n",
+	} {
+		if _, err := ssh.ParseConfig(strings.NewReader(input), t.TempDir()); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestIncludeLoop(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte("Include config\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssh.LoadConfig(path); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("expected a nesting error, got %v", err)
+	}
+}
+
+func TestResolveHost(t *testing.T) {
+	t.Parallel()
+
+	manager := ssh.NewManager("", time.Second)
+	if err := manager.LoadClientConfig(writeConfig(t)); err != nil {
+		t.Fatalf("LoadClientConfig failed: %v", err)
+	}
+	_ = manager.SaveHost(core.SSHHost{Name: "saved", Host: "saved.example.com", Port: 22, User: "me"})
+
+	tests := []struct {
+		target string
+		want   core.SSHHost
+	}{
+		{"saved", core.SSHHost{Name: "saved", Host: "saved.example.com", Port: 22, User: "me"}},
+		{"db", core.SSHHost{Name: "db", Host: "10.0.0.5", Port: 2200, User: "fallback", ProxyJump: "bastion", ForwardAgent: true}},
+		{"admin@db:22", core.SSHHost{Name: "admin@db:22", Host: "10.0.0.5", Port: 22, User: "admin", ProxyJump: "bastion", ForwardAgent: true}},
+		{"ssh://web-1", core.SSHHost{Name: "ssh://web-1", Host: "web-1.example.com", Port: 2222, User: "deploy"}},
+	}
+	for _, tt := range tests {
+		if got := manager.ResolveHost(tt.target); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ResolveHost(%q) = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+
+	if err := manager.LoadClientConfig(filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Errorf("expected a missing config to be ignored, got %v", err)
+	}
+	if hosts := manager.ConfigHosts(); len(hosts) != 0 {
+		t.Errorf("expected no hosts without a config, got %v", hosts)
+	}
+}
+
+func TestConnectThroughProxyJump(t *testing.T) {
+	t.Parallel()
+
+	jump := newTestServer(t)
+	target := newTestServer(t)
+	dir := t.TempDir()
+	jumpKey := jump.authorizeKey(t, dir)
+	targetDir := filepath.Join(dir, "target")
+	if err := os.Mkdir(targetDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	targetKey := target.authorizeKey(t, targetDir)
+
+	config := fmt.Sprintf(`Host bastion
+    HostName 127.0.0.1
+    Port %d
+    User jumper
+    IdentityFile %s
+
+Host app
+    HostName 127.0.0.1
+    Port %d
+    User tester
+    IdentityFile %s
+    ProxyJump bastion
+`, jump.port(), jumpKey, target.port(), targetKey)
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	if err := manager.LoadClientConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.ConnectToSavedHost(context.Background(), "app"); err != nil {
+		t.Fatalf("ConnectToSavedHost failed: %v", err)
+	}
+	if manager.State() != core.SSHConnected {
+		t.Errorf("expected connected state, got %v", manager.State())
+	}
+	result, err := manager.Execute(context.Background(), "echo through")
+	if err != nil || strings.TrimSpace(result.Output) != "through" {
+		t.Fatalf("Execute = %+v, %v", result, err)
+	}
+
+	want := fmt.Sprintf("127.0.0.1:%d", target.port())
+	if got := jump.forwardedTargets(); len(got) != 1 || got[0] != want {
+		t.Errorf("expected the jump host to forward to %s, got %v", want, got)
+	}
+
+	// Named connections dial through the jump host too
+	conn, err := manager.Open(context.Background(), "app", manager.ResolveHost("app"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if result, err := conn.Execute(context.Background(), "echo again"); err != nil || strings.TrimSpace(result.Output) != "again" {
+		t.Fatalf("Execute = %+v, %v", result, err)
+	}
+
+	if err := manager.ConnectToSavedHost(context.Background(), "unknown"); err == nil {
+		t.Error("expected an error for an unknown host")
+	}
+}
@@ -0,0 +1,572 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/shell"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// terminalType is the TERM requested for remote terminals.
+const terminalType = "xterm-256color"
+
+// Connection is a named, open connection to a host. Any number of remote
+// terminals and commands can share it.
+//
+// A connection that is lost is reconnected as the manager's reconnect
+// policy allows. It keeps its name and identity meanwhile, so terminals
+// started on it can start again once it is back.
+type Connection struct {
+	name string
+	host core.SSHHost
+	// auth are the auth methods the connection was opened with, which it
+	// reconnects with.
+	auth []ssh.AuthMethod
+	// done is closed once the connection is closed for good; closing is
+	// closed by Close.
+	done      chan struct{}
+	closing   chan struct{}
+	closeOnce sync.Once
+
+	mu     sync.RWMutex
+	client *ssh.Client
+	// forwardAgent is set when sessions get the agent forwarded.
+	forwardAgent bool
+	state        core.SSHConnectionState
+	err          error
+	// forwards are the tunnels started with StartTunnel, which are started
+	// again after a reconnect.
+	forwards []core.TunnelSpec
+
+	sftpMu sync.Mutex
+	sftp   *sftp.Client
+}
+
+func newConnection(name string, host core.SSHHost, auth []ssh.AuthMethod, client *ssh.Client, forwardAgent bool) *Connection {
+	return &Connection{
+		name:         name,
+		host:         host,
+		auth:         auth,
+		client:       client,
+		done:         make(chan struct{}),
+		closing:      make(chan struct{}),
+		forwardAgent: forwardAgent,
+		state:        core.SSHConnected,
+	}
+}
+
+// Name returns the name the connection was opened with.
+func (c *Connection) Name() string {
+	return c.name
+}
+
+// Host returns the host the connection is attached to.
+func (c *Connection) Host() core.SSHHost {
+	return c.host
+}
+
+// Client returns the underlying SSH client, which is replaced when the
+// connection is reconnected.
+func (c *Connection) Client() *ssh.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
+
+// Alive reports whether the connection is up.
+func (c *Connection) Alive() bool {
+	return c.State() == core.SSHConnected
+}
+
+// State returns the state of the connection: connected, reconnecting,
+// disconnected once closed, or error if it could not be reconnected.
+func (c *Connection) State() core.SSHConnectionState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// Err returns why the connection was last lost or could not be
+// reconnected, or nil.
+func (c *Connection) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.err
+}
+
+// Done returns a channel that is closed when the connection is closed, or
+// lost without being reconnected.
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the connection and every terminal running on it. It is not
+// reconnected.
+func (c *Connection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+	return c.Client().Close()
+}
+
+// Execute runs a command on the host in a fresh session.
+func (c *Connection) Execute(ctx context.Context, command string) (*core.CommandResult, error) {
+	client, forwardAgent, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	return execute(ctx, client, command, forwardAgent)
+}
+
+// ForwardsAgent reports whether the agent is forwarded to the host.
+func (c *Connection) ForwardsAgent() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.forwardAgent
+}
+
+// current returns the client of a connection that is up, and whether the
+// agent is forwarded over it.
+func (c *Connection) current() (*ssh.Client, bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.state != core.SSHConnected {
+		return nil, false, fmt.Errorf("connection %s is %s", c.name, c.state)
+	}
+	return c.client, c.forwardAgent, nil
+}
+
+// finished reports whether the connection is closed for good.
+func (c *Connection) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// StartTerminal starts an interactive shell of the given type on a remote
+// pseudo-terminal. It is a shell.TerminalStarter, so a connection can back
+// a shell.Executor:
+//
+//	executor := shell.NewTerminalExecutor(core.ShellTypeBash, conn.StartTerminal)
+//
+// Resizing the terminal sends a window-change request to the host.
+func (c *Connection) StartTerminal(shellType core.ShellType, dir string, cols, rows int) (shell.Terminal, error) {
+	client, forwardAgent, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	session, err := newSession(client, forwardAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 38400,
+		ssh.TTY_OP_OSPEED: 38400,
+	}
+	if err := session.RequestPty(terminalType, rows, cols, modes); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to request terminal: %w", err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	command := "exec " + shell.InteractiveCommand(shellType)
+	if dir != "" {
+		command = "cd -- " + shell.Quote(dir) + " 2>/dev/null; " + command
+	}
+	if err := session.Start(command); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", shellType, err)
+	}
+
+	return &remoteTerminal{session: session, stdin: stdin, stdout: stdout}, nil
+}
+
+// remoteTerminal is a shell on a remote pseudo-terminal. The remote shell
+// writes stderr to the terminal, so it has no separate stderr stream.
+type remoteTerminal struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+}
+
+func (t *remoteTerminal) Write(p []byte) (int, error) {
+	return t.stdin.Write(p)
+}
+
+func (t *remoteTerminal) Output() io.Reader {
+	return t.stdout
+}
+
+func (t *remoteTerminal) Stderr() io.Reader {
+	return nil
+}
+
+func (t *remoteTerminal) Resize(cols, rows int) error {
+	return t.session.WindowChange(rows, cols)
+}
+
+func (t *remoteTerminal) Wait() int {
+	err := t.session.Wait()
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus()
+	default:
+		return -1
+	}
+}
+
+func (t *remoteTerminal) Close() error {
+	err := t.session.Close()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// Open connects to host and keeps the connection under name, next to any
+// other open connections. The host's key file, if any, is tried before the
+// given auth methods; a host without a key file and without auth methods
+// tries the identity files that load without a passphrase. The host's
+// tunnels are started once connected. The connection stays open until
+// CloseConnection or CloseAll is called. If the host goes away it is
+// reconnected as the reconnect policy allows, with the same auth methods.
+func (m *Manager) Open(ctx context.Context, name string, host core.SSHHost, auth ...ssh.AuthMethod) (*Connection, error) {
+	if name == "" {
+		return nil, errors.New("connection name is required")
+	}
+
+	m.mu.RLock()
+	existing, ok := m.connections[name]
+	m.mu.RUnlock()
+	if ok && !existing.finished() {
+		return nil, fmt.Errorf("connection already open: %s", name)
+	}
+
+	if host.Port == 0 {
+		host.Port = 22
+	}
+	auth, err := m.authMethods(ctx, host, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := m.dial(ctx, host, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newConnection(name, host, auth, client, serveAgent(client, m.forwardedAgent(host)))
+
+	m.mu.Lock()
+	if existing, ok := m.connections[name]; ok && !existing.finished() {
+		m.mu.Unlock()
+		_ = client.Close()
+		return nil, fmt.Errorf("connection already open: %s", name)
+	}
+	m.connections[name] = conn
+	m.mu.Unlock()
+
+	m.publish(StateEvent{Connection: name, State: core.SSHConnected})
+	go m.supervise(conn)
+	go m.startHostTunnels(client, name, host)
+	return conn, nil
+}
+
+// Connection returns the open connection with the given name, which may
+// be reconnecting.
+func (m *Manager) Connection(name string) (*Connection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conn, ok := m.connections[name]
+	if !ok || conn.finished() {
+		return nil, false
+	}
+	return conn, true
+}
+
+// Connections returns the open connections, including those reconnecting,
+// sorted by name.
+func (m *Manager) Connections() []*Connection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]*Connection, 0, len(m.connections))
+	for _, conn := range m.connections {
+		if !conn.finished() {
+			result = append(result, conn)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// CloseConnection closes the connection with the given name.
+func (m *Manager) CloseConnection(name string) error {
+	m.mu.Lock()
+	conn, ok := m.connections[name]
+	delete(m.connections, name)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("connection not found: %s", name)
+	}
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// CloseAll closes every named connection and the current connection.
+func (m *Manager) CloseAll() {
+	m.mu.Lock()
+	conns := m.connections
+	m.connections = make(map[string]*Connection)
+	m.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	_ = m.Disconnect()
+}
+
+// authMethods returns the auth methods for host: public keys, followed by
+// extra and the host's password, if it has one in the secrets store. The
+// public keys are the host's key file, if any, with its certificate, the
+// agent's keys, and the identity files for hosts with neither a key file
+// nor extra methods. They form a single method, as the client tries each
+// kind of method only once.
+func (m *Manager) authMethods(ctx context.Context, host core.SSHHost, extra []ssh.AuthMethod) ([]ssh.AuthMethod, error) {
+	passphrase, password, err := m.hostSecrets(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyFile []ssh.Signer
+	if host.KeyPath != "" {
+		keys, err := keySigners(host.KeyPath, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key: %w", err)
+		}
+		keyFile = keys
+	}
+	useIdentities := keyFile == nil && len(extra) == 0
+
+	publicKeys := ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		signers := append([]ssh.Signer(nil), keyFile...)
+		signers = append(signers, m.agentSigners()...)
+		if useIdentities {
+			signers = append(signers, m.identitySigners()...)
+		}
+		return signers, nil
+	})
+	auth := append([]ssh.AuthMethod{publicKeys}, extra...)
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	return auth, nil
+}
+
+// identitySigners loads the identity files that exist and are not
+// protected by a passphrase.
+func (m *Manager) identitySigners() []ssh.Signer {
+	m.mu.RLock()
+	paths := append([]string(nil), m.identityFiles...)
+	m.mu.RUnlock()
+
+	var signers []ssh.Signer
+	for _, path := range paths {
+		if keys, err := keySigners(path, ""); err == nil {
+			signers = append(signers, keys...)
+		}
+	}
+	return signers
+}
+
+// dial opens an SSH client connection to host, through its jump hosts if
+// it has any, honouring ctx while connections and handshakes are in
+// progress. Jump hosts are resolved like any other target and
+// authenticate with their own keys.
+func (m *Manager) dial(ctx context.Context, host core.SSHHost, auth []ssh.AuthMethod) (*ssh.Client, error) {
+	m.mu.RLock()
+	timeout := m.timeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Host keys are confirmed within the timeout
+	hostKeyCallback, err := m.getHostKeyCallback(ctx)
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure host key verification: %w", err)
+	}
+
+	var jumps []*ssh.Client
+	closeJumps := func() {
+		for i := len(jumps) - 1; i >= 0; i-- {
+			_ = jumps[i].Close()
+		}
+	}
+
+	dialer := &net.Dialer{}
+	dialTCP := dialer.DialContext
+	for _, spec := range splitJumps(host.ProxyJump) {
+		jump := m.ResolveHost(spec)
+		jump.ProxyJump = ""
+		jumpAuth, err := m.authMethods(ctx, jump, nil)
+		if err != nil {
+			closeJumps()
+			return nil, fmt.Errorf("jump host %s: %w", spec, err)
+		}
+
+		client, err := handshake(ctx, dialTCP, jump, jumpAuth, hostKeyCallback, timeout)
+		if err != nil {
+			closeJumps()
+			return nil, fmt.Errorf("jump host %s: %w", spec, err)
+		}
+		jumps = append(jumps, client)
+		dialTCP = client.DialContext
+	}
+
+	client, err := handshake(ctx, dialTCP, host, auth, hostKeyCallback, timeout)
+	if err != nil {
+		closeJumps()
+		return nil, err
+	}
+
+	if len(jumps) > 0 {
+		go func() {
+			_ = client.Wait()
+			closeJumps()
+		}()
+	}
+	return client, nil
+}
+
+// handshake connects to host with dialTCP and performs the SSH handshake.
+func handshake(ctx context.Context, dialTCP func(context.Context, string, string) (net.Conn, error), host core.SSHHost, auth []ssh.AuthMethod, hostKeyCallback ssh.HostKeyCallback, timeout time.Duration) (*ssh.Client, error) {
+	config := &ssh.ClientConfig{
+		User:            host.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}
+
+	addr := net.JoinHostPort(host.Host, strconv.Itoa(host.Port))
+	netConn, err := dialTCP(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	// Abort the handshake if ctx ends first
+	stop := context.AfterFunc(ctx, func() {
+		_ = netConn.Close()
+	})
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if !stop() || err != nil {
+		netConn.Close()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// splitJumps splits a ProxyJump value into its hosts.
+func splitJumps(proxyJump string) []string {
+	var jumps []string
+	for _, jump := range strings.Split(proxyJump, ",") {
+		if jump = strings.TrimSpace(jump); jump != "" && !strings.EqualFold(jump, "none") {
+			jumps = append(jumps, jump)
+		}
+	}
+	return jumps
+}
+
+// newSession opens a session on client, asking for the agent to be
+// forwarded into it if forwardAgent is set. A host that refuses forwarding
+// still gets the session.
+func newSession(client *ssh.Client, forwardAgent bool) (*ssh.Session, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	if forwardAgent {
+		_ = agent.RequestAgentForwarding(session)
+	}
+	return session, nil
+}
+
+// execute runs command in a fresh session of client and returns its
+// combined output. The session is closed if ctx ends first.
+func execute(ctx context.Context, client *ssh.Client, command string, forwardAgent bool) (*core.CommandResult, error) {
+	session, err := newSession(client, forwardAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	startTime := time.Now()
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = session.Signal(ssh.SIGKILL)
+		_ = session.Close()
+	})
+	output, err := session.CombinedOutput(command)
+	if !stop() {
+		return nil, fmt.Errorf("command interrupted: %w", ctx.Err())
+	}
+
+	result := &core.CommandResult{
+		Command:  command,
+		Duration: time.Since(startTime).Milliseconds(),
+	}
+
+	if err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			result.ExitCode = exitErr.ExitStatus()
+		} else {
+			result.ExitCode = -1
+		}
+		result.Error = string(output)
+	} else {
+		result.Output = string(output)
+		result.ExitCode = 0
+	}
+
+	return result, nil
+}
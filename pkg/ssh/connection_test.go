@@ -0,0 +1,150 @@
+package ssh_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/shell"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+func openTestConnection(t *testing.T, manager *ssh.Manager, server *testServer, name string) *ssh.Connection {
+	t.Helper()
+
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester"}
+	conn, err := manager.Open(context.Background(), name, host, gossh.Password(testPassword))
+	if err != nil {
+		t.Fatalf("Open(%s) failed: %v", name, err)
+	}
+	return conn
+}
+
+func TestOpenMultipleConnections(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+
+	openTestConnection(t, manager, server, "web")
+	openTestConnection(t, manager, server, "db")
+
+	conns := manager.Connections()
+	if len(conns) != 2 || conns[0].Name() != "db" || conns[1].Name() != "web" {
+		t.Fatalf("expected connections db and web, got %v", conns)
+	}
+
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester"}
+	if _, err := manager.Open(context.Background(), "web", host, gossh.Password(testPassword)); err == nil {
+		t.Error("expected error opening a name that is already open")
+	}
+
+	for _, conn := range conns {
+		result, err := conn.Execute(context.Background(), "echo "+conn.Name())
+		if err != nil {
+			t.Fatalf("Execute on %s failed: %v", conn.Name(), err)
+		}
+		if got := strings.TrimSpace(result.Output); got != conn.Name() {
+			t.Errorf("expected output %q, got %q", conn.Name(), got)
+		}
+	}
+
+	if err := manager.CloseConnection("web"); err != nil {
+		t.Fatalf("CloseConnection failed: %v", err)
+	}
+	if _, ok := manager.Connection("web"); ok {
+		t.Error("expected web to be closed")
+	}
+	if conn, ok := manager.Connection("db"); !ok || !conn.Alive() {
+		t.Error("expected db to stay open")
+	}
+	if err := manager.CloseConnection("web"); err == nil {
+		t.Error("expected error closing an unknown connection")
+	}
+}
+
+func TestOpenWrongPassword(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester"}
+	if _, err := manager.Open(context.Background(), "bad", host, gossh.Password("wrong")); err == nil {
+		t.Fatal("expected authentication to fail")
+	}
+	if len(manager.Connections()) != 0 {
+		t.Error("expected no open connections")
+	}
+}
+
+func TestRemoteTerminalSession(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	conn := openTestConnection(t, manager, server, "remote")
+
+	executor := shell.NewTerminalExecutor(core.ShellTypeBash, conn.StartTerminal)
+	t.Cleanup(func() { _ = executor.Close() })
+	if executor.Local() {
+		t.Error("expected a terminal executor not to be local")
+	}
+	if err := executor.Resize(80, 24); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+
+	ctx := context.Background()
+	run := func(command string) *core.CommandResult {
+		t.Helper()
+		result, err := executor.Execute(ctx, command)
+		if err != nil {
+			t.Fatalf("Execute(%q) failed: %v", command, err)
+		}
+		return result
+	}
+
+	if result := run("greeting=hello; echo $greeting"); result.Output != "hello\n" || result.ExitCode != 0 {
+		t.Errorf("expected hello, got %q (exit %d)", result.Output, result.ExitCode)
+	}
+
+	// Shell state survives between commands
+	if result := run("echo $greeting"); result.Output != "hello\n" {
+		t.Errorf("expected variable to persist, got %q", result.Output)
+	}
+
+	// Stderr shares the terminal
+	if result := run("echo oops >&2; false"); result.Output != "oops\n" || result.ExitCode != 1 {
+		t.Errorf("expected stderr in output and exit 1, got %q (exit %d)", result.Output, result.ExitCode)
+	}
+
+	dir := t.TempDir()
+	if err := executor.SetWorkingDirectory(dir); err != nil {
+		t.Fatalf("SetWorkingDirectory failed: %v", err)
+	}
+	if got := executor.GetWorkingDirectory(); got != dir {
+		t.Errorf("expected working directory %s, got %s", dir, got)
+	}
+
+	// Resizing sends a window change to the remote terminal
+	if err := executor.Resize(132, 40); err != nil {
+		t.Fatalf("Resize failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		result := run("stty size")
+		if strings.TrimSpace(result.Output) == "40 132" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected terminal size 40 132, got %q", result.Output)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,289 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultFanOutConcurrency is how many hosts FanOut runs a command on
+	// at once unless told otherwise.
+	DefaultFanOutConcurrency = 10
+	// DefaultFanOutTimeout bounds connecting to a host and running the
+	// command there unless told otherwise.
+	DefaultFanOutTimeout = 30 * time.Second
+)
+
+// FanOutOptions configure FanOut.
+type FanOutOptions struct {
+	// Concurrency is how many hosts run the command at once;
+	// DefaultFanOutConcurrency if 0.
+	Concurrency int
+	// Timeout bounds connecting to each host and running the command
+	// there; DefaultFanOutTimeout if 0.
+	Timeout time.Duration
+}
+
+// HostResult is the outcome of a command on one host of a fan-out.
+type HostResult struct {
+	// Host is the host the command ran on.
+	Host string
+	// Output is the combined standard output and error of the command.
+	Output string
+	// ExitCode is the command's exit code, or -1 if Err is set.
+	ExitCode int
+	// Err is set when the host could not be reached or timed out.
+	Err error
+	// Duration is how long connecting and running the command took.
+	Duration time.Duration
+}
+
+// Failed reports whether the command failed on the host.
+func (r HostResult) Failed() bool {
+	return r.Err != nil || r.ExitCode != 0
+}
+
+// ResultGroup is a set of hosts on which a command had the same outcome.
+type ResultGroup struct {
+	// Hosts are the hosts, in the order they were given to FanOut.
+	Hosts []string
+	// Output, ExitCode and Err are the outcome the hosts share.
+	Output   string
+	ExitCode int
+	Err      error
+}
+
+// Failed reports whether the command failed on the group's hosts.
+func (g ResultGroup) Failed() bool {
+	return g.Err != nil || g.ExitCode != 0
+}
+
+// SetGroups sets the host groups, which map a group name to its members.
+// Members are host names, saved hosts and aliases of the OpenSSH client
+// configuration, or patterns such as web-* that match the saved hosts and
+// aliases. A member starting with ! leaves out the hosts it matches.
+func (m *Manager) SetGroups(groups map[string][]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.groups = make(map[string][]string, len(groups))
+	for name, members := range groups {
+		m.groups[name] = append([]string(nil), members...)
+	}
+}
+
+// Groups returns the names of the host groups set with SetGroups, sorted,
+// followed by the wildcard patterns of Host lines in the OpenSSH client
+// configuration, which can be used as groups as well.
+func (m *Manager) Groups() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.groups))
+	for name := range m.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if m.clientConfig != nil {
+		names = append(names, m.clientConfig.Patterns()...)
+	}
+	return names
+}
+
+// GroupHosts returns the hosts of target: a group set with SetGroups, or
+// a comma-separated list of members like a group's, such as web-*,!web-3.
+func (m *Manager) GroupHosts(target string) ([]string, error) {
+	m.mu.RLock()
+	members, ok := m.groups[target]
+	m.mu.RUnlock()
+	if !ok {
+		if !strings.ContainsAny(target, "*?,!") {
+			return nil, fmt.Errorf("group not found: %s", target)
+		}
+		members = strings.Split(target, ",")
+	}
+
+	known := m.hostNames()
+	var hosts, excluded []string
+	seen := make(map[string]bool)
+	add := func(host string) {
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	for _, member := range members {
+		member = strings.TrimSpace(member)
+		switch {
+		case member == "":
+		case strings.HasPrefix(member, "!"):
+			excluded = append(excluded, strings.ToLower(member[1:]))
+		case strings.ContainsAny(member, "*?"):
+			for _, host := range known {
+				if matchWildcard(strings.ToLower(member), strings.ToLower(host)) {
+					add(host)
+				}
+			}
+		default:
+			add(member)
+		}
+	}
+
+	result := hosts[:0]
+	for _, host := range hosts {
+		keep := true
+		for _, pattern := range excluded {
+			keep = keep && !matchWildcard(pattern, strings.ToLower(host))
+		}
+		if keep {
+			result = append(result, host)
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no hosts in %s", target)
+	}
+	return result, nil
+}
+
+// hostNames returns the names of the saved hosts followed by the aliases
+// of the OpenSSH client configuration.
+func (m *Manager) hostNames() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.savedHosts))
+	for _, host := range m.savedHosts {
+		names = append(names, host.Name)
+	}
+	if m.clientConfig != nil {
+		names = append(names, m.clientConfig.Hosts()...)
+	}
+	return names
+}
+
+// FanOut runs command on each of hosts, at most opts.Concurrency at once,
+// and returns the results in the order of hosts. A host with an open
+// connection of the same name runs the command over it. Others are
+// resolved like ResolveHost, connected to for the command alone with their
+// key files, the agent or the identity files, and disconnected after.
+// Hosts still running when ctx ends fail with its error.
+func (m *Manager) FanOut(ctx context.Context, hosts []string, command string, opts FanOutOptions) []HostResult {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultFanOutConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultFanOutTimeout
+	}
+
+	results := make([]HostResult, len(hosts))
+	slots := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				results[i] = m.runOnHost(ctx, host, command, opts.Timeout)
+			case <-ctx.Done():
+				results[i] = HostResult{Host: host, ExitCode: -1, Err: ctx.Err()}
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runOnHost runs command on host within timeout.
+func (m *Manager) runOnHost(ctx context.Context, host, command string, timeout time.Duration) HostResult {
+	start := time.Now()
+	result := HostResult{Host: host, ExitCode: -1}
+
+	hostCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	fail := func(err error) HostResult {
+		if ctx.Err() == nil && errors.Is(hostCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	client, forward, release, err := m.fanOutClient(hostCtx, host)
+	if err != nil {
+		return fail(err)
+	}
+	defer release()
+
+	res, err := execute(hostCtx, client, command, forward)
+	if err != nil {
+		return fail(err)
+	}
+	result.Output = res.Output + res.Error
+	result.ExitCode = res.ExitCode
+	result.Duration = time.Since(start)
+	return result
+}
+
+// fanOutClient returns a client for host: that of the open connection
+// named host, or a new one that release closes.
+func (m *Manager) fanOutClient(ctx context.Context, name string) (*ssh.Client, bool, func(), error) {
+	if conn, ok := m.Connection(name); ok {
+		client, forward, err := conn.current()
+		return client, forward, func() {}, err
+	}
+
+	host := m.ResolveHost(name)
+	auth, err := m.authMethods(ctx, host, nil)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	client, err := m.dial(ctx, host, auth)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	forward := serveAgent(client, m.forwardedAgent(host))
+	return client, forward, func() { _ = client.Close() }, nil
+}
+
+// GroupResults groups results with the same output, exit code and error.
+// Failed groups come first, then larger groups before smaller ones.
+func GroupResults(results []HostResult) []ResultGroup {
+	type key struct {
+		output   string
+		exitCode int
+		err      string
+	}
+
+	var groups []ResultGroup
+	index := make(map[key]int)
+	for _, r := range results {
+		k := key{output: r.Output, exitCode: r.ExitCode}
+		if r.Err != nil {
+			k.err = r.Err.Error()
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, ResultGroup{Output: r.Output, ExitCode: r.ExitCode, Err: r.Err})
+		}
+		groups[i].Hosts = append(groups[i].Hosts, r.Host)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Failed() != groups[j].Failed() {
+			return groups[i].Failed()
+		}
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+	return groups
+}
@@ -0,0 +1,166 @@
+package ssh_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// closedPort returns a local port nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// newFanOutManager returns a manager with saved hosts web-1, web-2 and
+// db-1 on server, which accepts their key, and down, which refuses
+// connections.
+func newFanOutManager(t *testing.T, server *testServer) *ssh.Manager {
+	t.Helper()
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	keyPath := server.authorizeKey(t, t.TempDir())
+	for _, name := range []string{"web-1", "web-2", "db-1"} {
+		host := core.SSHHost{Name: name, Host: "127.0.0.1", Port: server.port(), User: "tester", KeyPath: keyPath}
+		if err := manager.SaveHost(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	down := core.SSHHost{Name: "down", Host: "127.0.0.1", Port: closedPort(t), User: "tester", KeyPath: keyPath}
+	if err := manager.SaveHost(down); err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+func TestGroupHosts(t *testing.T) {
+	t.Parallel()
+
+	manager := newFanOutManager(t, newTestServer(t))
+	config, err := ssh.ParseConfig(strings.NewReader("Host app-*\n  User deploy\nHost app-1 app-2\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetClientConfig(config)
+	manager.SetGroups(map[string][]string{
+		"web": {"web-*", "!web-2", "extra.example.com"},
+		"all": {"*"},
+	})
+
+	if got, want := manager.Groups(), []string{"all", "web", "app-*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Groups() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"web", []string{"web-1", "extra.example.com"}},
+		{"all", []string{"web-1", "web-2", "db-1", "down", "app-1", "app-2"}},
+		{"app-*", []string{"app-1", "app-2"}},
+		{"web-*,db-1,web-1", []string{"web-1", "web-2", "db-1"}},
+	}
+	for _, tt := range tests {
+		got, err := manager.GroupHosts(tt.target)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GroupHosts(%q) = %v, %v; want %v", tt.target, got, err, tt.want)
+		}
+	}
+
+	if _, err := manager.GroupHosts("nope"); err == nil || !strings.Contains(err.Error(), "group not found") {
+		t.Errorf("expected an unknown group to fail, got %v", err)
+	}
+	if _, err := manager.GroupHosts("cache-*"); err == nil {
+		t.Error("expected a pattern matching nothing to fail")
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := newFanOutManager(t, server)
+	// An open connection of the same name is used as is
+	openTestConnection(t, manager, server, "db-1")
+
+	hosts := []string{"web-1", "down", "db-1", "web-2"}
+	results := manager.FanOut(context.Background(), hosts, "echo hello; exit 3", ssh.FanOutOptions{})
+	if len(results) != len(hosts) {
+		t.Fatalf("expected %d results, got %d", len(hosts), len(results))
+	}
+	for i, r := range results {
+		if r.Host != hosts[i] {
+			t.Errorf("expected results in the order of hosts, got %s at %d", r.Host, i)
+		}
+		if r.Host == "down" {
+			if r.Err == nil || r.ExitCode != -1 || !r.Failed() {
+				t.Errorf("expected down to fail to connect, got %+v", r)
+			}
+			continue
+		}
+		if r.Err != nil || r.ExitCode != 3 || r.Output != "hello\n" {
+			t.Errorf("%s: expected exit 3 with hello, got %+v", r.Host, r)
+		}
+	}
+
+	groups := ssh.GroupResults(results)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %+v", groups)
+	}
+	if !reflect.DeepEqual(groups[0].Hosts, []string{"web-1", "db-1", "web-2"}) || groups[0].ExitCode != 3 {
+		t.Errorf("expected the larger failed group first, got %+v", groups[0])
+	}
+	if !reflect.DeepEqual(groups[1].Hosts, []string{"down"}) || groups[1].Err == nil {
+		t.Errorf("expected down on its own, got %+v", groups[1])
+	}
+
+	ok := ssh.GroupResults([]ssh.HostResult{
+		{Host: "a", Output: "same"}, {Host: "b", Output: "different"}, {Host: "c", Output: "same"},
+		{Host: "d", Output: "same", ExitCode: 1},
+	})
+	if len(ok) != 3 || ok[0].Hosts[0] != "d" || !reflect.DeepEqual(ok[1].Hosts, []string{"a", "c"}) {
+		t.Errorf("expected failures first, then groups by size, got %+v", ok)
+	}
+}
+
+func TestFanOutLimits(t *testing.T) {
+	t.Parallel()
+
+	manager := newFanOutManager(t, newTestServer(t))
+
+	start := time.Now()
+	results := manager.FanOut(context.Background(), []string{"web-1", "web-2", "db-1"}, "sleep 0.3",
+		ssh.FanOutOptions{Concurrency: 1})
+	if took := time.Since(start); took < 900*time.Millisecond {
+		t.Errorf("expected hosts to run one at a time, took %s", took)
+	}
+	for _, r := range results {
+		if r.Failed() {
+			t.Errorf("%s failed: %v", r.Host, r.Err)
+		}
+	}
+
+	results = manager.FanOut(context.Background(), []string{"web-1", "web-2"}, "sleep 5",
+		ssh.FanOutOptions{Timeout: 300 * time.Millisecond})
+	for _, r := range results {
+		if r.Err == nil || !strings.Contains(r.Err.Error(), "timed out after 300ms") {
+			t.Errorf("%s: expected a timeout, got %+v", r.Host, r)
+		}
+		if r.Duration > 2*time.Second {
+			t.Errorf("%s: expected the command cut short, took %s", r.Host, r.Duration)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyPolicy decides what happens to hosts whose key is not in
+// known_hosts, like OpenSSH's StrictHostKeyChecking. Keys that differ from
+// the known one are refused whatever the policy, unless checking is off.
+type HostKeyPolicy string
+
+const (
+	// HostKeyAsk asks the host key prompt whether to trust a new host,
+	// and records it in known_hosts if so. Without a prompt, new hosts are
+	// refused.
+	HostKeyAsk HostKeyPolicy = "ask"
+	// HostKeyAcceptNew trusts new hosts and records them without asking.
+	HostKeyAcceptNew HostKeyPolicy = "accept-new"
+	// HostKeyStrict refuses hosts that are not in known_hosts.
+	HostKeyStrict HostKeyPolicy = "strict"
+	// HostKeyOff accepts any host key without checking it.
+	HostKeyOff HostKeyPolicy = "off"
+)
+
+// ParseHostKeyPolicy parses a host key policy. The values of OpenSSH's
+// StrictHostKeyChecking are accepted as well: yes, no and accept-new.
+func ParseHostKeyPolicy(s string) (HostKeyPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "ask", "":
+		return HostKeyAsk, nil
+	case "accept-new":
+		return HostKeyAcceptNew, nil
+	case "strict", "yes":
+		return HostKeyStrict, nil
+	case "off", "no":
+		return HostKeyOff, nil
+	}
+	return "", fmt.Errorf("invalid host key policy: %s", s)
+}
+
+// HostKeyQuestion asks whether to trust a host seen for the first time.
+type HostKeyQuestion struct {
+	// Host is the host and port as dialed.
+	Host string
+	// Address is the address of the host, if it differs from Host.
+	Address string
+	// KeyType is the type of the host's key, such as ssh-ed25519.
+	KeyType string
+	// Fingerprint is the SHA256 fingerprint of the host's key.
+	Fingerprint string
+}
+
+// HostKeyPrompt answers a HostKeyQuestion, typically by asking the user.
+// It blocks until the question is answered or ctx ends, which abandons
+// the connection. The prompt runs while the connection is being made,
+// within its timeout.
+type HostKeyPrompt func(ctx context.Context, question HostKeyQuestion) bool
+
+// HostKeyChangedError reports a host whose key differs from the one in
+// known_hosts.
+type HostKeyChangedError struct {
+	// Host is the host and port as dialed.
+	Host string
+	// KeyType and Fingerprint describe the key the host offered.
+	KeyType     string
+	Fingerprint string
+	// Known are the keys known_hosts has for the host.
+	Known []knownhosts.KnownKey
+}
+
+// Error implements error.
+func (e *HostKeyChangedError) Error() string {
+	var known string
+	if len(e.Known) > 0 {
+		known = fmt.Sprintf(" (known key at %s:%d)", e.Known[0].Filename, e.Known[0].Line)
+	}
+	return fmt.Sprintf("host key for %s has changed%s: it now offers %s %s. "+
+		"Someone could be intercepting the connection, or the host was reinstalled; "+
+		"if the change is expected, remove the old key with ssh-keygen -R %s",
+		e.Host, known, e.KeyType, e.Fingerprint, knownHostsHost(e.Host))
+}
+
+// SetHostKeyPolicy sets how hosts missing from known_hosts are treated.
+func (m *Manager) SetHostKeyPolicy(policy HostKeyPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hostKeyPolicy = policy
+}
+
+// SetHostKeyPrompt sets the prompt that HostKeyAsk asks about new hosts.
+// The prompt must not call the manager, as Connect and its variants hold
+// it while connecting.
+func (m *Manager) SetHostKeyPrompt(prompt HostKeyPrompt) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hostKeyPrompt = prompt
+}
+
+// SetHashKnownHosts sets whether hosts added to known_hosts are hashed,
+// like OpenSSH's HashKnownHosts, so the file does not list them.
+func (m *Manager) SetHashKnownHosts(hash bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hashKnownHosts = hash
+}
+
+// AddHostCAFile trusts the certificate authorities whose public keys are
+// in the file at path, one per line as in authorized_keys, to sign the
+// certificates of any host. Authorities limited to some hosts can be given
+// as @cert-authority lines of known_hosts instead.
+func (m *Manager) AddHostCAFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read host CA: %w", err)
+	}
+
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse host CA %s: %w", path, err)
+		}
+		keys = append(keys, key)
+		data = rest
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys in host CA %s", path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hostCAs = append(m.hostCAs, keys...)
+	return nil
+}
+
+// getHostKeyCallback returns the host key callback of the host key policy.
+// Callers must hold m.mu; ctx bounds the host key prompt.
+func (m *Manager) getHostKeyCallback(ctx context.Context) (ssh.HostKeyCallback, error) {
+	if m.hostKeyPolicy == HostKeyOff {
+		// Only for trusted networks, or when the user turned checking off
+		return ssh.InsecureIgnoreHostKey(), nil //nolint:gosec // User explicitly disabled host key checking
+	}
+	if m.hostKeyPolicy == HostKeyStrict && m.knownHostsPath == "" && len(m.hostCAs) == 0 {
+		return nil, errors.New("known_hosts path not configured")
+	}
+
+	verifier := &hostKeyVerifier{
+		manager: m,
+		ctx:     ctx,
+		policy:  m.hostKeyPolicy,
+		prompt:  m.hostKeyPrompt,
+		path:    m.knownHostsPath,
+		hash:    m.hashKnownHosts,
+		cas:     append([]ssh.PublicKey(nil), m.hostCAs...),
+	}
+	return verifier.check, nil
+}
+
+// hostKeyVerifier checks host keys against known_hosts and the trusted
+// host certificate authorities, and records new hosts as its policy
+// allows. known_hosts is read at each check, so hosts recorded by other
+// connections are seen.
+type hostKeyVerifier struct {
+	manager *Manager
+	ctx     context.Context
+	policy  HostKeyPolicy
+	prompt  HostKeyPrompt
+	path    string
+	hash    bool
+	cas     []ssh.PublicKey
+}
+
+// check implements ssh.HostKeyCallback. A host certificate signed by a
+// trusted authority is checked as a certificate; other certificates are
+// checked by their key, like a plain host key.
+func (v *hostKeyVerifier) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		authorities, err := v.authorities(hostname)
+		if err != nil {
+			return err
+		}
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+				return containsKey(authorities, auth)
+			},
+		}
+		if checker.IsHostAuthority(cert.SignatureKey, hostname) {
+			if err := checker.CheckHostKey(hostname, remote, cert); err != nil {
+				return fmt.Errorf("host certificate for %s: %w", hostname, err)
+			}
+			return nil
+		}
+		key = cert.Key
+	}
+
+	known, err := v.knownHosts()
+	if err != nil {
+		return err
+	}
+	err = known(hostname, remote, key)
+
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &revokedErr):
+		return fmt.Errorf("host key for %s is revoked at %s:%d", hostname, revokedErr.Revoked.Filename, revokedErr.Revoked.Line)
+	case !errors.As(err, &keyErr):
+		return err
+	case len(keyErr.Want) > 0:
+		return &HostKeyChangedError{
+			Host:        hostname,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			Known:       keyErr.Want,
+		}
+	}
+
+	// The host is new
+	question := HostKeyQuestion{
+		Host:        hostname,
+		KeyType:     key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+	}
+	if address := remoteAddress(hostname, remote); address != "" {
+		question.Address = address
+	}
+	switch {
+	case v.policy == HostKeyAcceptNew:
+	case v.policy == HostKeyAsk && v.prompt != nil:
+		if !v.prompt(v.ctx, question) {
+			return fmt.Errorf("host key for %s (%s) was not trusted", hostname, question.Fingerprint)
+		}
+	default:
+		return fmt.Errorf("host key for %s is unknown (%s %s)", hostname, question.KeyType, question.Fingerprint)
+	}
+
+	if v.path == "" {
+		return nil
+	}
+	addresses := []string{hostname}
+	if question.Address != "" {
+		addresses = append(addresses, question.Address)
+	}
+	return v.manager.writeKnownHost(v.path, v.hash, addresses, key)
+}
+
+// knownHosts returns a callback checking keys against known_hosts, which
+// knows no hosts if the file does not exist.
+func (v *hostKeyVerifier) knownHosts() (ssh.HostKeyCallback, error) {
+	var files []string
+	if v.path != "" {
+		if _, err := os.Stat(v.path); err == nil {
+			files = append(files, v.path)
+		}
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// authorities returns the certificate authorities trusted to sign the
+// host key of hostname: those added with AddHostCAFile, and those of the
+// @cert-authority lines of known_hosts whose patterns match hostname.
+func (v *hostKeyVerifier) authorities(hostname string) ([]ssh.PublicKey, error) {
+	authorities := append([]ssh.PublicKey(nil), v.cas...)
+	if v.path == "" {
+		return authorities, nil
+	}
+
+	data, err := os.ReadFile(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return authorities, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	for len(data) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(data)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+		}
+		// Patterns match hosts as known_hosts writes them, [host]:port
+		// for ports other than 22
+		if marker == "cert-authority" && matchHostPatterns(hosts, knownhosts.Normalize(hostname)) {
+			authorities = append(authorities, key)
+		}
+		data = rest
+	}
+	return authorities, nil
+}
+
+// writeKnownHost appends key to the known_hosts file at path for each of
+// addresses, hashed if hash is set.
+func (m *Manager) writeKnownHost(path string, hash bool, addresses []string, key ssh.PublicKey) error {
+	m.knownHostsMu.Lock()
+	defer m.knownHostsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create .ssh directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	if hash {
+		// Hashed hosts each take a line of their own
+		for _, address := range addresses {
+			hashed := knownhosts.HashHostname(knownhosts.Normalize(address))
+			lines = append(lines, knownhosts.Line([]string{hashed}, key))
+		}
+	} else {
+		lines = append(lines, knownhosts.Line(addresses, key))
+	}
+
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		return fmt.Errorf("failed to write to known_hosts: %w", err)
+	}
+	return nil
+}
+
+// remoteAddress returns the address of remote in the form of known_hosts,
+// if it is an IP address other than the host of hostname.
+func remoteAddress(hostname string, remote net.Addr) string {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok || tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified() {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(hostname)
+	if err != nil || host == tcpAddr.IP.String() {
+		return ""
+	}
+	return tcpAddr.String()
+}
+
+// knownHostsHost returns hostname as ssh-keygen -R expects it.
+func knownHostsHost(hostname string) string {
+	normalized := knownhosts.Normalize(hostname)
+	if strings.HasPrefix(normalized, "[") {
+		return "'" + normalized + "'"
+	}
+	return normalized
+}
+
+// containsKey reports whether keys holds key.
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
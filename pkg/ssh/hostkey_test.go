@@ -0,0 +1,288 @@
+package ssh_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// newSigner returns a new ed25519 key.
+func newSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newHostKeyManager returns a manager checking host keys against a
+// known_hosts file in a temporary directory, and the file's path.
+func newHostKeyManager(t *testing.T, policy ssh.HostKeyPolicy) (*ssh.Manager, string) {
+	t.Helper()
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	manager.SetKnownHostsPath(knownHosts)
+	manager.SetHostKeyPolicy(policy)
+	return manager, knownHosts
+}
+
+func openHost(manager *ssh.Manager, server *testServer, name string) error {
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester"}
+	_, err := manager.Open(context.Background(), name, host, gossh.Password(testPassword))
+	return err
+}
+
+func TestParseHostKeyPolicy(t *testing.T) {
+	t.Parallel()
+
+	for input, want := range map[string]ssh.HostKeyPolicy{
+		"": ssh.HostKeyAsk, "ask": ssh.HostKeyAsk, "yes": ssh.HostKeyStrict, "Strict": ssh.HostKeyStrict,
+		"no": ssh.HostKeyOff, "accept-new": ssh.HostKeyAcceptNew,
+	} {
+		if got, err := ssh.ParseHostKeyPolicy(input); err != nil || got != want {
+			t.Errorf("ParseHostKeyPolicy(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ssh.ParseHostKeyPolicy("maybe"); err == nil {
+		t.Error("expected an unknown policy to fail")
+	}
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager, knownHosts := newHostKeyManager(t, ssh.HostKeyAsk)
+	manager.SetHashKnownHosts(true)
+
+	var mu sync.Mutex
+	var questions []ssh.HostKeyQuestion
+	trust := false
+	manager.SetHostKeyPrompt(func(_ context.Context, question ssh.HostKeyQuestion) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		questions = append(questions, question)
+		return trust
+	})
+
+	err := openHost(manager, server, "web")
+	if err == nil || !strings.Contains(err.Error(), "was not trusted") {
+		t.Fatalf("expected a declined host to fail, got %v", err)
+	}
+	if _, err := os.Stat(knownHosts); !os.IsNotExist(err) {
+		t.Error("expected a declined host not to be recorded")
+	}
+
+	mu.Lock()
+	trust = true
+	mu.Unlock()
+	if err := openHost(manager, server, "web"); err != nil {
+		t.Fatalf("expected a trusted host to connect, got %v", err)
+	}
+
+	mu.Lock()
+	question := questions[len(questions)-1]
+	mu.Unlock()
+	if question.Fingerprint != gossh.FingerprintSHA256(server.hostKey.PublicKey()) || question.KeyType != "ssh-ed25519" {
+		t.Errorf("expected the server's key in the question, got %+v", question)
+	}
+
+	data, err := os.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "|1|") || strings.Contains(string(data), "127.0.0.1") {
+		t.Errorf("expected a hashed entry, got %q", data)
+	}
+	if !manager.IsHostKnown(net.JoinHostPort("127.0.0.1", strconv.Itoa(server.port()))) {
+		t.Error("expected the hashed host to be known")
+	}
+
+	// Known hosts are not asked about again
+	if err := openHost(manager, server, "db"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(questions) != 2 {
+		t.Errorf("expected 2 questions, got %d", len(questions))
+	}
+}
+
+func TestHostKeyPolicies(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+
+	strict, _ := newHostKeyManager(t, ssh.HostKeyStrict)
+	if err := openHost(strict, server, "web"); err == nil || !strings.Contains(err.Error(), "is unknown") {
+		t.Errorf("expected strict checking to refuse an unknown host, got %v", err)
+	}
+	unanswered, _ := newHostKeyManager(t, ssh.HostKeyAsk)
+	if err := openHost(unanswered, server, "web"); err == nil {
+		t.Error("expected asking without a prompt to refuse an unknown host")
+	}
+
+	acceptNew, knownHosts := newHostKeyManager(t, ssh.HostKeyAcceptNew)
+	if err := openHost(acceptNew, server, "web"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[127.0.0.1]:" + strconv.Itoa(server.port()) + " ssh-ed25519 "; !strings.HasPrefix(string(data), want) {
+		t.Errorf("expected an entry starting %q, got %q", want, data)
+	}
+	if !acceptNew.IsHostKnown("[127.0.0.1]:" + strconv.Itoa(server.port())) {
+		t.Error("expected the host to be known")
+	}
+
+	// The recorded host passes strict checking
+	strict.SetKnownHostsPath(knownHosts)
+	if err := openHost(strict, server, "web"); err != nil {
+		t.Errorf("expected a known host to connect, got %v", err)
+	}
+}
+
+func TestHostKeyChanged(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager, _ := newHostKeyManager(t, ssh.HostKeyAcceptNew)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: server.port()}
+	if err := manager.AddHostToKnownHosts(addr.String(), addr, newSigner(t).PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	err := openHost(manager, server, "web")
+	var changed *ssh.HostKeyChangedError
+	if !errors.As(err, &changed) {
+		t.Fatalf("expected a changed host key, got %v", err)
+	}
+	if changed.Fingerprint != gossh.FingerprintSHA256(server.hostKey.PublicKey()) || len(changed.Known) != 1 {
+		t.Errorf("unexpected error %+v", changed)
+	}
+	if !strings.Contains(err.Error(), "ssh-keygen -R '[127.0.0.1]:"+strconv.Itoa(server.port())+"'") {
+		t.Errorf("expected advice on removing the old key, got %v", err)
+	}
+}
+
+func TestHostCertificate(t *testing.T) {
+	t.Parallel()
+
+	ca := newSigner(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pub")
+	if err := os.WriteFile(caFile, gossh.MarshalAuthorizedKey(ca.PublicKey()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t)
+	server.useHostCertificate(t, ca, "127.0.0.1")
+
+	// A configured authority vouches for the host
+	manager, knownHosts := newHostKeyManager(t, ssh.HostKeyStrict)
+	if err := manager.AddHostCAFile(caFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := openHost(manager, server, "web"); err != nil {
+		t.Fatalf("expected a certified host to connect, got %v", err)
+	}
+	if _, err := os.Stat(knownHosts); !os.IsNotExist(err) {
+		t.Error("expected a certified host not to be recorded")
+	}
+
+	// So does an @cert-authority line of known_hosts
+	byKnownHosts, knownHosts := newHostKeyManager(t, ssh.HostKeyStrict)
+	line := "@cert-authority [127.0.0.*]:* " + string(gossh.MarshalAuthorizedKey(ca.PublicKey()))
+	if err := os.WriteFile(knownHosts, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := openHost(byKnownHosts, server, "web"); err != nil {
+		t.Errorf("expected a host certified per known_hosts to connect, got %v", err)
+	}
+
+	// The certificate must name the host
+	other := newTestServer(t)
+	other.useHostCertificate(t, ca, "other.example.com")
+	if err := openHost(manager, other, "other"); err == nil || !strings.Contains(err.Error(), "host certificate") {
+		t.Errorf("expected a certificate for another host to fail, got %v", err)
+	}
+
+	// Certificates of unknown authorities are checked by their key
+	untrusted, _ := newHostKeyManager(t, ssh.HostKeyAcceptNew)
+	if err := openHost(untrusted, server, "web"); err != nil {
+		t.Errorf("expected an uncertified host to be accepted as new, got %v", err)
+	}
+}
+
+func TestUserCertificate(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	ca := newSigner(t)
+	server.trustUserCA(ca.PublicKey())
+
+	// The key alone is not authorized, its certificate is
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &gossh.Certificate{
+		Key:             sshPub,
+		CertType:        gossh.UserCert,
+		KeyId:           "tester",
+		ValidPrincipals: []string{"tester"},
+		ValidBefore:     gossh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath+"-cert.pub", gossh.MarshalAuthorizedKey(cert), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	host := core.SSHHost{Host: "127.0.0.1", Port: server.port(), User: "tester", KeyPath: keyPath}
+	if _, err := manager.Open(context.Background(), "web", host); err != nil {
+		t.Fatalf("expected the certificate to authenticate, got %v", err)
+	}
+	if server.certificateLogins() != 1 {
+		t.Errorf("expected a login with the certificate, got %d", server.certificateLogins())
+	}
+}
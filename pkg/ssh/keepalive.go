@@ -0,0 +1,310 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"golang.org/x/crypto/ssh"
+)
+
+// keepAliveRequest is the global request sent as a keepalive, as OpenSSH
+// does. Servers answer it, if only with a failure.
+const keepAliveRequest = "keepalive@openssh.com"
+
+// eventBuffer is how many state events a subscriber may fall behind by
+// before further events are dropped.
+const eventBuffer = 32
+
+// ReconnectPolicy describes how lost connections are reconnected.
+type ReconnectPolicy struct {
+	// Enabled turns reconnecting on.
+	Enabled bool
+	// InitialDelay is the wait before the first attempt, doubled after
+	// each failed attempt up to MaxDelay.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// MaxAttempts is how many attempts are made before giving up; 0
+	// retries until the connection is closed.
+	MaxAttempts int
+}
+
+// DefaultReconnectPolicy returns a policy that retries after 1s, 2s, 4s
+// and so on up to a minute, ten times in all.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		Enabled:      true,
+		InitialDelay: time.Second,
+		MaxDelay:     time.Minute,
+		MaxAttempts:  10,
+	}
+}
+
+// StateEvent reports that a connection changed state.
+type StateEvent struct {
+	// Connection is the name of the connection, or "" for the current
+	// connection of Connect.
+	Connection string
+	// State is the new state.
+	State core.SSHConnectionState
+	// Err is why the connection was lost, or why it could not be
+	// reconnected.
+	Err error
+	// Attempt numbers reconnect attempts from 1, while reconnecting and
+	// once reconnected; it is 0 otherwise.
+	Attempt int
+	// Delay is how long until the attempt, while reconnecting.
+	Delay time.Duration
+}
+
+// SetKeepAlive makes open connections send a keepalive every interval and
+// treats a connection as dead once countMax keepalives in a row go
+// unanswered. An interval of 0 turns keepalives off. It applies to
+// connections opened afterwards.
+func (m *Manager) SetKeepAlive(interval time.Duration, countMax int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keepAliveInterval = interval
+	m.keepAliveCountMax = max(countMax, 1)
+}
+
+// SetReconnectPolicy sets how lost connections are reconnected.
+func (m *Manager) SetReconnectPolicy(policy ReconnectPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reconnect = policy
+}
+
+// Subscribe returns a channel of state changes of the connections, and a
+// function that ends the subscription and closes the channel. Events are
+// dropped rather than block the connections if the channel is not read.
+func (m *Manager) Subscribe() (<-chan StateEvent, func()) {
+	events := make(chan StateEvent, eventBuffer)
+
+	m.eventsMu.Lock()
+	if m.subscribers == nil {
+		m.subscribers = make(map[chan StateEvent]struct{})
+	}
+	m.subscribers[events] = struct{}{}
+	m.eventsMu.Unlock()
+
+	return events, func() {
+		m.eventsMu.Lock()
+		defer m.eventsMu.Unlock()
+		if _, ok := m.subscribers[events]; ok {
+			delete(m.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// publish sends event to the subscribers.
+func (m *Manager) publish(event StateEvent) {
+	m.eventsMu.Lock()
+	defer m.eventsMu.Unlock()
+	for events := range m.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// setState sets the state of the current connection and publishes the
+// change. Callers must hold m.mu.
+func (m *Manager) setState(state core.SSHConnectionState) {
+	if m.state == state {
+		return
+	}
+	m.state = state
+	m.publish(StateEvent{State: state})
+}
+
+// supervise watches conn until it is closed for good: it sends keepalives
+// while conn is up and reconnects it when it is lost, as the reconnect
+// policy allows.
+func (m *Manager) supervise(conn *Connection) {
+	defer close(conn.done)
+
+	for {
+		cause := m.watch(conn.Client())
+
+		select {
+		case <-conn.closing:
+			m.connectionState(conn, core.SSHDisconnected, nil)
+			return
+		default:
+		}
+
+		m.mu.RLock()
+		policy := m.reconnect
+		m.mu.RUnlock()
+		if !policy.Enabled {
+			m.connectionState(conn, core.SSHDisconnected, cause)
+			return
+		}
+		if !m.reconnectConnection(conn, cause, policy) {
+			return
+		}
+	}
+}
+
+// watch waits for client to go away, sending keepalives meanwhile, and
+// returns why it went. A client whose keepalives go unanswered is closed.
+func (m *Manager) watch(client *ssh.Client) error {
+	lost := make(chan error, 1)
+	go func() {
+		lost <- client.Wait()
+	}()
+
+	m.mu.RLock()
+	interval, countMax := m.keepAliveInterval, m.keepAliveCountMax
+	m.mu.RUnlock()
+	if interval <= 0 {
+		return connectionLost(<-lost)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	missed := 0
+	for {
+		select {
+		case err := <-lost:
+			return connectionLost(err)
+		case <-ticker.C:
+			if sendKeepAlive(client, interval) {
+				missed = 0
+				continue
+			}
+			missed++
+			if missed >= countMax {
+				_ = client.Close()
+				<-lost
+				return fmt.Errorf("no reply to %d keepalives", missed)
+			}
+		}
+	}
+}
+
+// sendKeepAlive sends a keepalive over client and reports whether the
+// server answered within timeout.
+func sendKeepAlive(client *ssh.Client, timeout time.Duration) bool {
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+		reply <- err
+	}()
+
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// connectionLost describes the error a client ended with.
+func connectionLost(err error) error {
+	if err == nil || errors.Is(err, io.EOF) {
+		return errors.New("connection lost")
+	}
+	return fmt.Errorf("connection lost: %w", err)
+}
+
+// reconnectConnection dials conn's host again, waiting longer after each
+// failed attempt, and reports whether it is back. It gives up when conn is
+// closed or the attempts run out.
+func (m *Manager) reconnectConnection(conn *Connection, cause error, policy ReconnectPolicy) bool {
+	delay := policy.InitialDelay
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		conn.setState(core.SSHReconnecting, cause)
+		m.publish(StateEvent{Connection: conn.name, State: core.SSHReconnecting, Err: cause, Attempt: attempt, Delay: delay})
+
+		select {
+		case <-time.After(delay):
+		case <-conn.closing:
+			m.connectionState(conn, core.SSHDisconnected, nil)
+			return false
+		}
+
+		// Closing the connection abandons the attempt
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-conn.closing:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		client, err := m.dial(ctx, conn.host, conn.auth)
+		cancel()
+
+		if err == nil {
+			forward := serveAgent(client, m.forwardedAgent(conn.host))
+			forwards, ok := conn.reconnected(client, forward)
+			if !ok {
+				_ = client.Close()
+				m.connectionState(conn, core.SSHDisconnected, nil)
+				return false
+			}
+			m.publish(StateEvent{Connection: conn.name, State: core.SSHConnected, Attempt: attempt})
+			go m.restartTunnels(conn, client, forwards)
+			return true
+		}
+
+		cause = err
+		delay = min(delay*2, max(policy.MaxDelay, policy.InitialDelay))
+	}
+
+	err := fmt.Errorf("gave up reconnecting after %d attempts: %w", policy.MaxAttempts, cause)
+	m.connectionState(conn, core.SSHError, err)
+	return false
+}
+
+// connectionState sets the state of conn and publishes the change.
+func (m *Manager) connectionState(conn *Connection, state core.SSHConnectionState, err error) {
+	conn.setState(state, err)
+	m.publish(StateEvent{Connection: conn.name, State: state, Err: err})
+}
+
+// setState sets the state of the connection and why it got there.
+func (c *Connection) setState(state core.SSHConnectionState, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = state
+	c.err = err
+}
+
+// reconnected puts client in place of the lost one, unless the connection
+// was closed meanwhile, and reports whether it did. It returns the forwards
+// to start again, as they were when the connection came back up.
+func (c *Connection) reconnected(client *ssh.Client, forwardAgent bool) ([]core.TunnelSpec, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closing:
+		return nil, false
+	default:
+	}
+	c.client = client
+	c.forwardAgent = forwardAgent
+	c.state = core.SSHConnected
+	c.err = nil
+	return append([]core.TunnelSpec(nil), c.forwards...), true
+}
+
+// removeForward forgets a tunnel started with StartTunnel, so it is not
+// started again after a reconnect.
+func (c *Connection) removeForward(spec core.TunnelSpec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, forward := range c.forwards {
+		if forward == spec {
+			c.forwards = append(c.forwards[:i], c.forwards[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package ssh_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// waitEvent reads events until one for connection reaches state, failing
+// the test if none does in time.
+func waitEvent(t *testing.T, events <-chan ssh.StateEvent, connection string, state core.SSHConnectionState) ssh.StateEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Connection == connection && event.State == state {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %s event for %q", state, connection)
+		}
+	}
+}
+
+// fastReconnect retries quickly so tests need not wait.
+var fastReconnect = ssh.ReconnectPolicy{
+	Enabled:      true,
+	InitialDelay: 20 * time.Millisecond,
+	MaxDelay:     100 * time.Millisecond,
+	MaxAttempts:  5,
+}
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	manager.SetReconnectPolicy(fastReconnect)
+	events, unsubscribe := manager.Subscribe()
+	defer unsubscribe()
+
+	conn := openTestConnection(t, manager, server, "web")
+	waitEvent(t, events, "web", core.SSHConnected)
+	spec := core.TunnelSpec{Type: core.TunnelLocal, Listen: "127.0.0.1:0", Target: "127.0.0.1:9"}
+	if _, err := manager.StartTunnel("web", spec); err != nil {
+		t.Fatalf("StartTunnel failed: %v", err)
+	}
+
+	server.dropConnections()
+	lost := waitEvent(t, events, "web", core.SSHReconnecting)
+	if lost.Attempt != 1 || lost.Err == nil || lost.Delay != fastReconnect.InitialDelay {
+		t.Errorf("expected the first attempt with the cause, got %+v", lost)
+	}
+	back := waitEvent(t, events, "web", core.SSHConnected)
+	if back.Attempt < 1 {
+		t.Errorf("expected the attempt that reconnected, got %+v", back)
+	}
+
+	// The same connection carries on with a new client
+	if got, ok := manager.Connection("web"); !ok || got != conn || !conn.Alive() {
+		t.Fatal("expected the connection to be back under its name")
+	}
+	result, err := conn.Execute(context.Background(), "echo again")
+	if err != nil || strings.TrimSpace(result.Output) != "again" {
+		t.Errorf("expected commands to run after reconnecting, got %+v, %v", result, err)
+	}
+
+	// The tunnel started on the connection is started again
+	if !waitFor(t, func() bool {
+		tunnels := manager.Tunnels()
+		return len(tunnels) == 1 && tunnels[0].Connection() == "web" && tunnels[0].Err() == nil
+	}) {
+		t.Errorf("expected the tunnel started again, got %d tunnels", len(manager.Tunnels()))
+	}
+
+	if err := manager.CloseConnection("web"); err != nil {
+		t.Fatalf("CloseConnection failed: %v", err)
+	}
+	waitEvent(t, events, "web", core.SSHDisconnected)
+	select {
+	case <-conn.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a closed connection to be done")
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	policy := fastReconnect
+	policy.MaxAttempts = 2
+	manager.SetReconnectPolicy(policy)
+	events, unsubscribe := manager.Subscribe()
+	defer unsubscribe()
+
+	conn := openTestConnection(t, manager, server, "web")
+	server.listener.Close()
+	server.dropConnections()
+
+	failed := waitEvent(t, events, "web", core.SSHError)
+	if failed.Err == nil || !strings.Contains(failed.Err.Error(), "gave up reconnecting after 2 attempts") {
+		t.Errorf("expected to give up after 2 attempts, got %v", failed.Err)
+	}
+	<-conn.Done()
+	if conn.State() != core.SSHError {
+		t.Errorf("expected the connection in error, got %s", conn.State())
+	}
+	if _, ok := manager.Connection("web"); ok {
+		t.Error("expected a connection that gave up to be gone")
+	}
+	if _, err := conn.Execute(context.Background(), "true"); err == nil {
+		t.Error("expected commands to fail on a lost connection")
+	}
+}
+
+func TestKeepAliveDetectsDeadConnection(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	manager.SetKeepAlive(30*time.Millisecond, 2)
+	events, unsubscribe := manager.Subscribe()
+	defer unsubscribe()
+
+	conn := openTestConnection(t, manager, server, "web")
+
+	// Answered keepalives keep the connection up
+	time.Sleep(200 * time.Millisecond)
+	if !conn.Alive() {
+		t.Fatalf("expected the connection up, got %s: %v", conn.State(), conn.Err())
+	}
+
+	server.setUnresponsive(true)
+	lost := waitEvent(t, events, "web", core.SSHDisconnected)
+	if lost.Err == nil || !strings.Contains(lost.Err.Error(), "no reply to 2 keepalives") {
+		t.Errorf("expected unanswered keepalives to end the connection, got %v", lost.Err)
+	}
+	<-conn.Done()
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Parallel()
+
+	manager := ssh.NewManager("", time.Second)
+	events, unsubscribe := manager.Subscribe()
+
+	if err := manager.Connect(context.Background(), "127.0.0.1", closedPort(t), "tester"); err == nil {
+		t.Fatal("expected Connect to a closed port to fail")
+	}
+	waitEvent(t, events, "", core.SSHConnecting)
+	waitEvent(t, events, "", core.SSHError)
+
+	unsubscribe()
+	unsubscribe()
+	if _, open := <-events; open {
+		t.Error("expected unsubscribing to close the channel")
+	}
+}
@@ -3,31 +3,72 @@ package ssh
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/cbwinslow/cbwsh/pkg/core"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 // Manager handles SSH connections and host management.
+//
+// Connect and its variants manage a single current connection used by
+// Execute and ForwardLocalPort. Open keeps any number of named connections
+// alive at once, each of which can back remote terminals.
 type Manager struct {
 	mu             sync.RWMutex
 	client         *ssh.Client
+	connections    map[string]*Connection
 	state          core.SSHConnectionState
 	currentHost    *core.SSHHost
 	savedHosts     []core.SSHHost
 	hostFilePath   string
 	knownHostsPath string
 	timeout        time.Duration
-	strictHostKey  bool
+	hostKeyPolicy  HostKeyPolicy
+	hostKeyPrompt  HostKeyPrompt
+	hashKnownHosts bool
+	hostCAs        []ssh.PublicKey
+	knownHostsMu   sync.Mutex
+	secretStore    SecretStore
+	unlockStore    UnlockFunc
+	unlockMu       sync.Mutex
+	identityFiles  []string
+	clientConfig   *Config
+	agent          agent.Agent
+	agentConn      net.Conn
+	forwardAgent   bool
+	// forwardCurrent is set when the agent is forwarded over client.
+	forwardCurrent bool
+	groups         map[string][]string
+	// keepAliveInterval and keepAliveCountMax configure the keepalives of
+	// named connections, and reconnect how they are reconnected.
+	keepAliveInterval time.Duration
+	keepAliveCountMax int
+	reconnect         ReconnectPolicy
+	eventsMu          sync.Mutex
+	subscribers       map[chan StateEvent]struct{}
+	tunnels           map[int]*Tunnel
+	nextTunnelID      int
+	// transfers lists every transfer; pendingTransfers those still to
+	// run, in order. transferring is set while a worker runs them.
+	transfers        []*Transfer
+	pendingTransfers []*Transfer
+	nextTransferID   int
+	transferring     bool
 }
 
 // NewManager creates a new SSH manager.
@@ -36,20 +77,31 @@ func NewManager(hostFilePath string, timeout time.Duration) *Manager {
 	knownHostsPath := filepath.Join(homeDir, ".ssh", "known_hosts")
 
 	return &Manager{
-		state:          core.SSHDisconnected,
-		savedHosts:     make([]core.SSHHost, 0),
-		hostFilePath:   hostFilePath,
-		knownHostsPath: knownHostsPath,
-		timeout:        timeout,
-		strictHostKey:  false, // Default to permissive for ease of use
+		state:             core.SSHDisconnected,
+		keepAliveCountMax: 3,
+		savedHosts:        make([]core.SSHHost, 0),
+		connections:       make(map[string]*Connection),
+		tunnels:           make(map[int]*Tunnel),
+		hostFilePath:      hostFilePath,
+		knownHostsPath:    knownHostsPath,
+		timeout:           timeout,
+		hostKeyPolicy:     HostKeyOff, // Default to permissive for ease of use
+		identityFiles: []string{
+			filepath.Join(homeDir, ".ssh", "id_ed25519"),
+			filepath.Join(homeDir, ".ssh", "id_ecdsa"),
+			filepath.Join(homeDir, ".ssh", "id_rsa"),
+		},
 	}
 }
 
-// SetStrictHostKeyChecking enables or disables strict host key checking.
+// SetStrictHostKeyChecking enables or disables strict host key checking,
+// setting the host key policy to HostKeyStrict or HostKeyOff.
 func (m *Manager) SetStrictHostKeyChecking(strict bool) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.strictHostKey = strict
+	policy := HostKeyOff
+	if strict {
+		policy = HostKeyStrict
+	}
+	m.SetHostKeyPolicy(policy)
 }
 
 // SetKnownHostsPath sets the path to the known_hosts file.
@@ -59,8 +111,34 @@ func (m *Manager) SetKnownHostsPath(path string) {
 	m.knownHostsPath = path
 }
 
-// Connect establishes an SSH connection.
+// AddIdentityFile adds a key file that Open tries, before the default
+// ones, for hosts without a key of their own.
+func (m *Manager) AddIdentityFile(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.identityFiles {
+		if existing == path {
+			return
+		}
+	}
+	m.identityFiles = append([]string{path}, m.identityFiles...)
+}
+
+// Connect establishes an SSH connection. A saved host with the same host
+// and user authenticates with its key file and password, the passphrase
+// and password read from the secrets store if it keeps them.
 func (m *Manager) Connect(ctx context.Context, host string, port int, user string) error {
+	// Read before locking the manager, as unlocking the store may ask the
+	// user and reads the store through the manager
+	saved, found := m.savedHostFor(host, user)
+	var passphrase, password string
+	if found {
+		var err error
+		if passphrase, password, err = m.hostSecrets(ctx, saved); err != nil {
+			return err
+		}
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -71,11 +149,11 @@ func (m *Manager) Connect(ctx context.Context, host string, port int, user strin
 		}
 	}
 
-	m.state = core.SSHConnecting
+	m.setState(core.SSHConnecting)
 
-	hostKeyCallback, err := m.getHostKeyCallback()
+	hostKeyCallback, err := m.getHostKeyCallback(ctx)
 	if err != nil {
-		m.state = core.SSHError
+		m.setState(core.SSHError)
 		return fmt.Errorf("failed to configure host key verification: %w", err)
 	}
 
@@ -85,33 +163,45 @@ func (m *Manager) Connect(ctx context.Context, host string, port int, user strin
 		Timeout:         m.timeout,
 	}
 
-	// Try to find saved host with key
-	for _, savedHost := range m.savedHosts {
-		if savedHost.Host == host && savedHost.User == user {
-			if savedHost.KeyPath != "" {
-				key, err := loadPrivateKey(savedHost.KeyPath, savedHost.Passphrase)
-				if err == nil {
-					config.Auth = append(config.Auth, ssh.PublicKeys(key))
-				}
-			}
-			break
+	// The saved host's key first
+	var signers []ssh.Signer
+	if saved.KeyPath != "" {
+		keys, err := keySigners(saved.KeyPath, passphrase)
+		if err != nil {
+			m.setState(core.SSHError)
+			return fmt.Errorf("failed to load key: %w", err)
 		}
+		signers = append(signers, keys...)
+	}
+
+	// Then the agent's keys
+	if m.agent != nil {
+		if agentSigners, err := m.agent.Signers(); err == nil {
+			signers = append(signers, agentSigners...)
+		}
+	}
+	if len(signers) > 0 {
+		config.Auth = append(config.Auth, ssh.PublicKeys(signers...))
+	}
+	if password != "" {
+		config.Auth = append(config.Auth, ssh.Password(password))
 	}
 
 	addr := fmt.Sprintf("%s:%d", host, port)
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
-		m.state = core.SSHError
+		m.setState(core.SSHError)
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
 	m.client = client
-	m.state = core.SSHConnected
+	m.setState(core.SSHConnected)
 	m.currentHost = &core.SSHHost{
 		Host: host,
 		Port: port,
 		User: user,
 	}
+	m.forwardCurrent = m.forwardAgent && serveAgent(client, m.agent)
 
 	return nil
 }
@@ -125,23 +215,23 @@ func (m *Manager) ConnectWithKey(ctx context.Context, host string, port int, use
 		_ = m.client.Close()
 	}
 
-	m.state = core.SSHConnecting
+	m.setState(core.SSHConnecting)
 
-	key, err := loadPrivateKey(keyPath, passphrase)
+	keys, err := keySigners(keyPath, passphrase)
 	if err != nil {
-		m.state = core.SSHError
+		m.setState(core.SSHError)
 		return fmt.Errorf("failed to load key: %w", err)
 	}
 
-	hostKeyCallback, err := m.getHostKeyCallback()
+	hostKeyCallback, err := m.getHostKeyCallback(ctx)
 	if err != nil {
-		m.state = core.SSHError
+		m.setState(core.SSHError)
 		return fmt.Errorf("failed to configure host key verification: %w", err)
 	}
 
 	config := &ssh.ClientConfig{
 		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(keys...)},
 		HostKeyCallback: hostKeyCallback,
 		Timeout:         m.timeout,
 	}
@@ -149,18 +239,19 @@ func (m *Manager) ConnectWithKey(ctx context.Context, host string, port int, use
 	addr := fmt.Sprintf("%s:%d", host, port)
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
-		m.state = core.SSHError
+		m.setState(core.SSHError)
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
 	m.client = client
-	m.state = core.SSHConnected
+	m.setState(core.SSHConnected)
 	m.currentHost = &core.SSHHost{
 		Host:    host,
 		Port:    port,
 		User:    user,
 		KeyPath: keyPath,
 	}
+	m.forwardCurrent = m.forwardAgent && serveAgent(client, m.agent)
 
 	return nil
 }
@@ -174,11 +265,11 @@ func (m *Manager) ConnectWithPassword(ctx context.Context, host string, port int
 		_ = m.client.Close()
 	}
 
-	m.state = core.SSHConnecting
+	m.setState(core.SSHConnecting)
 
-	hostKeyCallback, err := m.getHostKeyCallback()
+	hostKeyCallback, err := m.getHostKeyCallback(ctx)
 	if err != nil {
-		m.state = core.SSHError
+		m.setState(core.SSHError)
 		return fmt.Errorf("failed to configure host key verification: %w", err)
 	}
 
@@ -192,42 +283,57 @@ func (m *Manager) ConnectWithPassword(ctx context.Context, host string, port int
 	addr := fmt.Sprintf("%s:%d", host, port)
 	client, err := ssh.Dial("tcp", addr, config)
 	if err != nil {
-		m.state = core.SSHError
+		m.setState(core.SSHError)
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 
 	m.client = client
-	m.state = core.SSHConnected
+	m.setState(core.SSHConnected)
 	m.currentHost = &core.SSHHost{
 		Host: host,
 		Port: port,
 		User: user,
 	}
+	m.forwardCurrent = m.forwardAgent && serveAgent(client, m.agent)
 
 	return nil
 }
 
-// ConnectToSavedHost connects to a saved host by name.
+// ConnectToSavedHost connects to a saved host, or a host of the OpenSSH
+// client configuration, by name. Jump hosts are dialed first.
 func (m *Manager) ConnectToSavedHost(ctx context.Context, name string) error {
-	m.mu.RLock()
-	var host *core.SSHHost
-	for i := range m.savedHosts {
-		if m.savedHosts[i].Name == name {
-			host = &m.savedHosts[i]
-			break
-		}
-	}
-	m.mu.RUnlock()
-
-	if host == nil {
+	if !m.knowsHost(name) {
 		return fmt.Errorf("host not found: %s", name)
 	}
+	host := m.ResolveHost(name)
 
-	if host.KeyPath != "" {
-		return m.ConnectWithKey(ctx, host.Host, host.Port, host.User, host.KeyPath, host.Passphrase)
+	auth, err := m.authMethods(ctx, host, nil)
+	if err != nil {
+		return err
 	}
 
-	return m.Connect(ctx, host.Host, host.Port, host.User)
+	m.mu.Lock()
+	m.setState(core.SSHConnecting)
+	m.mu.Unlock()
+
+	client, err := m.dial(ctx, host, auth)
+	forward := err == nil && serveAgent(client, m.forwardedAgent(host))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.setState(core.SSHError)
+		return err
+	}
+	if m.client != nil {
+		_ = m.client.Close()
+	}
+	m.client = client
+	m.setState(core.SSHConnected)
+	m.currentHost = &host
+	m.forwardCurrent = forward
+	go m.startHostTunnels(client, "", host)
+	return nil
 }
 
 // Disconnect closes the current SSH connection.
@@ -238,71 +344,173 @@ func (m *Manager) Disconnect() error {
 	if m.client != nil {
 		err := m.client.Close()
 		m.client = nil
-		m.state = core.SSHDisconnected
+		m.setState(core.SSHDisconnected)
 		m.currentHost = nil
+		m.forwardCurrent = false
 		return err
 	}
 
-	m.state = core.SSHDisconnected
+	m.setState(core.SSHDisconnected)
 	return nil
 }
 
 // Execute runs a command on the remote host.
 func (m *Manager) Execute(ctx context.Context, command string) (*core.CommandResult, error) {
 	m.mu.RLock()
-	client := m.client
+	client, forward := m.client, m.forwardCurrent
 	m.mu.RUnlock()
 
 	if client == nil {
 		return nil, fmt.Errorf("not connected")
 	}
 
-	session, err := client.NewSession()
+	return execute(ctx, client, command, forward)
+}
+
+// State returns the current connection state.
+func (m *Manager) State() core.SSHConnectionState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// CurrentHost returns the currently connected host.
+func (m *Manager) CurrentHost() *core.SSHHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.currentHost == nil {
+		return nil
+	}
+	host := *m.currentHost
+	return &host
+}
+
+// LoadClientConfig reads the OpenSSH client configuration at path, such as
+// ~/.ssh/config, whose hosts are then resolved by ResolveHost and offered
+// by ConfigHosts. A missing file is not an error.
+func (m *Manager) LoadClientConfig(path string) error {
+	config, err := LoadConfig(path)
+	if errors.Is(err, os.ErrNotExist) {
+		config, err = &Config{}, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create session: %w", err)
+		return err
 	}
-	defer session.Close()
 
-	startTime := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clientConfig = config
+	return nil
+}
 
-	output, err := session.CombinedOutput(command)
+// SetClientConfig sets the OpenSSH client configuration used to resolve
+// hosts.
+func (m *Manager) SetClientConfig(config *Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clientConfig = config
+}
 
-	result := &core.CommandResult{
-		Command:  command,
-		Duration: time.Since(startTime).Milliseconds(),
+// ConfigHosts returns the host aliases of the OpenSSH client
+// configuration.
+func (m *Manager) ConfigHosts() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.clientConfig == nil {
+		return nil
 	}
+	return m.clientConfig.Hosts()
+}
 
-	if err != nil {
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			result.ExitCode = exitErr.ExitStatus()
-		} else {
-			result.ExitCode = -1
+// ResolveHost turns a target into the host to connect to. A target naming
+// a saved host returns that host. Otherwise the target is read as
+// [user@]host[:port] and host is resolved through the OpenSSH client
+// configuration the way ssh does, with a user or port given in the target
+// taking precedence. The result is named after the target.
+func (m *Manager) ResolveHost(target string) core.SSHHost {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, saved := range m.savedHosts {
+		if saved.Name == target {
+			return saved
 		}
-		result.Error = string(output)
-	} else {
-		result.Output = string(output)
-		result.ExitCode = 0
 	}
 
-	return result, nil
+	host := core.SSHHost{Name: target}
+	rest := strings.TrimPrefix(target, "ssh://")
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		host.User, rest = rest[:i], rest[i+1:]
+	}
+	if h, p, err := net.SplitHostPort(rest); err == nil {
+		if port, err := strconv.Atoi(p); err == nil {
+			rest, host.Port = h, port
+		}
+	}
+	host.Host = strings.Trim(rest, "[]")
+
+	if m.clientConfig != nil {
+		hc := m.clientConfig.Resolve(host.Host)
+		host.Host = hc.HostName
+		if host.User == "" {
+			host.User = hc.User
+		}
+		if host.Port == 0 {
+			host.Port = hc.Port
+		}
+		for _, path := range hc.IdentityFiles {
+			if _, err := os.Stat(path); err == nil {
+				host.KeyPath = path
+				break
+			}
+		}
+		host.ProxyJump = hc.ProxyJump
+		host.ForwardAgent = hc.ForwardAgent
+	}
+
+	if host.User == "" {
+		if current, err := user.Current(); err == nil {
+			host.User = current.Username
+		}
+	}
+	if host.Port == 0 {
+		host.Port = 22
+	}
+	return host
 }
 
-// State returns the current connection state.
-func (m *Manager) State() core.SSHConnectionState {
+// savedHostFor returns the saved host connecting to host as user, if any.
+func (m *Manager) savedHostFor(host, user string) (core.SSHHost, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.state
+
+	for _, saved := range m.savedHosts {
+		if saved.Host == host && saved.User == user {
+			return saved, true
+		}
+	}
+	return core.SSHHost{}, false
 }
 
-// CurrentHost returns the currently connected host.
-func (m *Manager) CurrentHost() *core.SSHHost {
+// knowsHost reports whether name is a saved host or a host alias of the
+// OpenSSH client configuration.
+func (m *Manager) knowsHost(name string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if m.currentHost == nil {
-		return nil
+
+	for _, saved := range m.savedHosts {
+		if saved.Name == name {
+			return true
+		}
 	}
-	host := *m.currentHost
-	return &host
+	if m.clientConfig != nil {
+		for _, alias := range m.clientConfig.Hosts() {
+			if alias == name {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // ListSavedHosts returns a list of saved SSH hosts.
@@ -351,68 +559,24 @@ func (m *Manager) RemoveHost(name string) error {
 	return fmt.Errorf("host not found: %s", name)
 }
 
-// ForwardLocalPort creates a local port forwarding tunnel.
+// ForwardLocalPort forwards a local port to remoteHost:remotePort over the
+// current connection until ctx is done. The tunnel is listed by Tunnels.
 func (m *Manager) ForwardLocalPort(ctx context.Context, localPort int, remoteHost string, remotePort int) error {
-	m.mu.RLock()
-	client := m.client
-	m.mu.RUnlock()
-
-	if client == nil {
-		return fmt.Errorf("not connected")
-	}
-
-	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", localPort))
+	t, err := m.StartTunnel("", core.TunnelSpec{
+		Type:   core.TunnelLocal,
+		Listen: net.JoinHostPort("localhost", strconv.Itoa(localPort)),
+		Target: net.JoinHostPort(remoteHost, strconv.Itoa(remotePort)),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to listen: %w", err)
+		return err
 	}
 
-	go func() {
-		defer listener.Close()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			localConn, err := listener.Accept()
-			if err != nil {
-				continue
-			}
-
-			remoteConn, err := client.Dial("tcp", fmt.Sprintf("%s:%d", remoteHost, remotePort))
-			if err != nil {
-				localConn.Close()
-				continue
-			}
-
-			go copyConn(localConn, remoteConn)
-			go copyConn(remoteConn, localConn)
-		}
-	}()
-
+	context.AfterFunc(ctx, func() {
+		_ = t.Close()
+	})
 	return nil
 }
 
-func copyConn(dst, src net.Conn) {
-	defer dst.Close()
-	defer src.Close()
-
-	buf := make([]byte, 32*1024)
-	for {
-		n, err := src.Read(buf)
-		if n > 0 {
-			if _, werr := dst.Write(buf[:n]); werr != nil {
-				return
-			}
-		}
-		if err != nil {
-			return
-		}
-	}
-}
-
 func loadPrivateKey(keyPath, passphrase string) (ssh.Signer, error) {
 	keyData, err := os.ReadFile(keyPath)
 	if err != nil {
@@ -426,83 +590,119 @@ func loadPrivateKey(keyPath, passphrase string) (ssh.Signer, error) {
 	return ssh.ParsePrivateKey(keyData)
 }
 
-// getHostKeyCallback returns an appropriate host key callback.
-// If strict host key checking is enabled and a known_hosts file exists,
-// it uses that file for verification. Otherwise, it uses a permissive callback.
-func (m *Manager) getHostKeyCallback() (ssh.HostKeyCallback, error) {
-	if m.strictHostKey && m.knownHostsPath != "" {
-		// Try to use known_hosts file
-		if _, err := os.Stat(m.knownHostsPath); err == nil {
-			callback, err := knownhosts.New(m.knownHostsPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
-			}
-			return callback, nil
-		}
+// keySigners loads the private key at keyPath and, ahead of it, the
+// OpenSSH certificate of the key if there is one next to it, as
+// <keyPath>-cert.pub. Hosts that do not trust the certificate's authority
+// still accept the key itself.
+func keySigners(keyPath, passphrase string) ([]ssh.Signer, error) {
+	key, err := loadPrivateKey(keyPath, passphrase)
+	if err != nil {
+		return nil, err
 	}
 
-	// Fall back to a callback that accepts any key but logs it
-	// NOTE: This is insecure and should only be used in trusted networks
-	// or when strict host key checking is explicitly disabled
-	return ssh.InsecureIgnoreHostKey(), nil //nolint:gosec // User explicitly disabled strict mode
+	data, err := os.ReadFile(keyPath + "-cert.pub")
+	if err != nil {
+		return []ssh.Signer{key}, nil
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return []ssh.Signer{key}, nil
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok || !bytes.Equal(cert.Key.Marshal(), key.PublicKey().Marshal()) {
+		return []ssh.Signer{key}, nil
+	}
+	certSigner, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		return []ssh.Signer{key}, nil
+	}
+	return []ssh.Signer{certSigner, key}, nil
 }
 
-// AddHostToKnownHosts adds a host key to the known_hosts file.
+// AddHostToKnownHosts adds a host key to the known_hosts file, for
+// hostname and for the remote address if it differs. The entries are
+// hashed if SetHashKnownHosts is set.
 func (m *Manager) AddHostToKnownHosts(hostname string, remote net.Addr, key ssh.PublicKey) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.mu.RLock()
+	path, hash := m.knownHostsPath, m.hashKnownHosts
+	m.mu.RUnlock()
 
-	if m.knownHostsPath == "" {
+	if path == "" {
 		return fmt.Errorf("known_hosts path not configured")
 	}
 
-	// Create directory if needed
-	dir := filepath.Dir(m.knownHostsPath)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
-		return fmt.Errorf("failed to create .ssh directory: %w", err)
+	var addresses []string
+	if hostname != "" {
+		addresses = append(addresses, hostname)
 	}
-
-	// Open file for appending
-	f, err := os.OpenFile(m.knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
-	if err != nil {
-		return fmt.Errorf("failed to open known_hosts: %w", err)
-	}
-	defer f.Close()
-
-	// Format the entry
-	entry := knownhosts.Line([]string{knownhosts.Normalize(remote.String())}, key)
-
-	if _, err := f.WriteString(entry + "\n"); err != nil {
-		return fmt.Errorf("failed to write to known_hosts: %w", err)
+	if address := remoteAddress(hostname, remote); address != "" || hostname == "" {
+		addresses = append(addresses, remote.String())
 	}
-
-	return nil
+	return m.writeKnownHost(path, hash, addresses, key)
 }
 
-// IsHostKnown checks if a host is in the known_hosts file.
+// IsHostKnown checks if a host is in the known_hosts file, hashed or
+// not. The host may be given with a port, as host:port.
 func (m *Manager) IsHostKnown(hostname string) bool {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	path := m.knownHostsPath
+	m.mu.RUnlock()
 
-	if m.knownHostsPath == "" {
+	if path == "" {
+		return false
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
 		return false
 	}
 
-	file, err := os.Open(m.knownHostsPath)
+	// Checking a key no host has lists the keys known for the host
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
 	if err != nil {
 		return false
 	}
+	address := hostname
+	if _, _, err := net.SplitHostPort(hostname); err != nil {
+		address = net.JoinHostPort(strings.Trim(hostname, "[]"), "22")
+	}
+	var keyErr *knownhosts.KeyError
+	err = callback(address, &net.TCPAddr{IP: net.IPv4zero}, probe)
+	return errors.As(err, &keyErr) && len(keyErr.Want) > 0
+}
+
+// KnownHostNames returns the host names in the known_hosts file that are
+// not hashed, without ports.
+func (m *Manager) KnownHostNames() []string {
+	m.mu.RLock()
+	path := m.knownHostsPath
+	m.mu.RUnlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
 	defer file.Close()
 
+	var names []string
+	seen := make(map[string]bool)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, hostname) {
-			return true
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "@") || strings.HasPrefix(fields[0], "|") {
+			continue
+		}
+		for _, name := range strings.Split(fields[0], ",") {
+			if host, _, err := net.SplitHostPort(name); err == nil {
+				name = host
+			}
+			name = strings.Trim(name, "[]")
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
 		}
 	}
-
-	return false
+	return names
 }
 
 // HostKeyCallback returns a host key callback that checks known hosts.
@@ -0,0 +1,126 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// Prefixes of the names of the secrets holding the credentials of saved
+// hosts.
+const (
+	passphraseSecretPrefix = "ssh-passphrase/"
+	passwordSecretPrefix   = "ssh-password/"
+)
+
+// UnlockFunc unlocks a locked secret store, typically by asking the user
+// for its master password. reason says what the store is needed for. It
+// blocks until the store is unlocked, the user gives up or ctx ends.
+type UnlockFunc func(ctx context.Context, reason string) error
+
+// PassphraseSecret returns the name MigrateHostSecrets gives the secret
+// holding the passphrase of a saved host's key.
+func PassphraseSecret(host string) string {
+	return passphraseSecretPrefix + host
+}
+
+// PasswordSecret returns the name of the secret conventionally holding the
+// password of a saved host.
+func PasswordSecret(host string) string {
+	return passwordSecretPrefix + host
+}
+
+// SetSecretStore sets the store the passphrases and passwords of saved
+// hosts are read from when they are connected to. If the store is locked
+// then, unlock is called to unlock it; without unlock, such hosts fail to
+// connect.
+func (m *Manager) SetSecretStore(store SecretStore, unlock UnlockFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secretStore = store
+	m.unlockStore = unlock
+}
+
+// hostSecrets returns the passphrase of host's key and the password of
+// its user: the secrets they refer to, or the plaintext passphrase of
+// hosts that have not been migrated yet.
+func (m *Manager) hostSecrets(ctx context.Context, host core.SSHHost) (string, string, error) {
+	passphrase := host.Passphrase
+	if host.PassphraseSecret != "" {
+		secret, err := m.secret(ctx, host.PassphraseSecret, "the passphrase of "+host.Name)
+		if err != nil {
+			return "", "", fmt.Errorf("passphrase of %s: %w", host.Name, err)
+		}
+		passphrase = secret
+	}
+
+	var password string
+	if host.PasswordSecret != "" {
+		secret, err := m.secret(ctx, host.PasswordSecret, "the password of "+host.Name)
+		if err != nil {
+			return "", "", fmt.Errorf("password of %s: %w", host.Name, err)
+		}
+		password = secret
+	}
+	return passphrase, password, nil
+}
+
+// secret reads the named secret, unlocking the store first if it is
+// locked. Hosts connecting at once unlock it only once.
+func (m *Manager) secret(ctx context.Context, name, reason string) (string, error) {
+	m.mu.RLock()
+	store, unlock := m.secretStore, m.unlockStore
+	m.mu.RUnlock()
+	if store == nil {
+		return "", fmt.Errorf("no secrets store for %s", name)
+	}
+
+	if lockable, ok := store.(interface{ IsUnlocked() bool }); ok {
+		m.unlockMu.Lock()
+		if !lockable.IsUnlocked() {
+			if unlock == nil {
+				m.unlockMu.Unlock()
+				return "", fmt.Errorf("secrets store is locked")
+			}
+			if err := unlock(ctx, reason); err != nil {
+				m.unlockMu.Unlock()
+				return "", fmt.Errorf("failed to unlock secrets store: %w", err)
+			}
+		}
+		m.unlockMu.Unlock()
+	}
+
+	value, err := store.Retrieve(name)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// MigrateHostSecrets moves the plaintext passphrases of hosts into store,
+// under the names of PassphraseSecret, and returns the hosts referring to
+// those secrets instead, along with how many passphrases were moved.
+// Hosts without a plaintext passphrase are returned as they are.
+func MigrateHostSecrets(hosts []core.SSHHost, store SecretStore) ([]core.SSHHost, int, error) {
+	migrated := make([]core.SSHHost, len(hosts))
+	copy(migrated, hosts)
+
+	moved := 0
+	for i, host := range migrated {
+		if host.Passphrase == "" {
+			continue
+		}
+		name := host.PassphraseSecret
+		if name == "" {
+			name = PassphraseSecret(host.Name)
+		}
+		if err := store.Store(name, []byte(host.Passphrase)); err != nil {
+			return nil, moved, fmt.Errorf("failed to store the passphrase of %s: %w", host.Name, err)
+		}
+		migrated[i].Passphrase = ""
+		migrated[i].PassphraseSecret = name
+		moved++
+	}
+	return migrated, moved, nil
+}
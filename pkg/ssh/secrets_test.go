@@ -0,0 +1,189 @@
+package ssh_test
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/secrets"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// newLockedStore returns a secrets store holding values, locked with
+// password.
+func newLockedStore(t *testing.T, password string, values map[string]string) *secrets.Manager {
+	t.Helper()
+
+	store := secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	if err := store.Initialize(password); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range values {
+		if err := store.Store(name, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestHostSecretsFromStore(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	dir := t.TempDir()
+
+	// A key encrypted with a passphrase kept in the store
+	key := server.authorizeNewKey(t)
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(key, "", []byte("key passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_test")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newLockedStore(t, "master", map[string]string{
+		ssh.PassphraseSecret("web"): "key passphrase",
+		ssh.PasswordSecret("db"):    testPassword,
+	})
+	var unlocks atomic.Int32
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	manager.SetSecretStore(store, func(_ context.Context, reason string) error {
+		unlocks.Add(1)
+		if reason != "the passphrase of web" {
+			t.Errorf("unexpected reason %q", reason)
+		}
+		return store.Unlock("master")
+	})
+
+	web := core.SSHHost{
+		Name: "web", Host: "127.0.0.1", Port: server.port(), User: "tester",
+		KeyPath: keyPath, PassphraseSecret: ssh.PassphraseSecret("web"),
+	}
+	if _, err := manager.Open(context.Background(), "web", web); err != nil {
+		t.Fatalf("expected the passphrase from the store to decrypt the key, got %v", err)
+	}
+	if unlocks.Load() != 1 {
+		t.Errorf("expected the store to be unlocked once, got %d", unlocks.Load())
+	}
+
+	db := core.SSHHost{
+		Name: "db", Host: "127.0.0.1", Port: server.port(), User: "tester",
+		PasswordSecret: ssh.PasswordSecret("db"),
+	}
+	if _, err := manager.Open(context.Background(), "db", db); err != nil {
+		t.Fatalf("expected the password from the store to authenticate, got %v", err)
+	}
+	if unlocks.Load() != 1 {
+		t.Errorf("expected an unlocked store not to be unlocked again, got %d", unlocks.Load())
+	}
+}
+
+func TestConnectReadsHostSecrets(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	block, err := gossh.MarshalPrivateKeyWithPassphrase(server.authorizeNewKey(t), "", []byte("key passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_test")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := newLockedStore(t, "master", map[string]string{ssh.PassphraseSecret("web"): "key passphrase"})
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	if err := manager.SaveHost(core.SSHHost{
+		Name: "web", Host: "127.0.0.1", Port: server.port(), User: "tester",
+		KeyPath: keyPath, PassphraseSecret: ssh.PassphraseSecret("web"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	declined := errors.New("declined")
+	manager.SetSecretStore(store, func(context.Context, string) error { return declined })
+	if err := manager.Connect(context.Background(), "127.0.0.1", server.port(), "tester"); !errors.Is(err, declined) {
+		t.Errorf("expected the declined unlock to fail Connect, got %v", err)
+	}
+
+	manager.SetSecretStore(store, func(context.Context, string) error { return store.Unlock("master") })
+	if err := manager.Connect(context.Background(), "127.0.0.1", server.port(), "tester"); err != nil {
+		t.Fatalf("expected the passphrase from the store to decrypt the key, got %v", err)
+	}
+	if manager.State() != core.SSHConnected {
+		t.Error("expected Connect to connect")
+	}
+}
+
+func TestHostSecretsLocked(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	store := newLockedStore(t, "master", map[string]string{ssh.PasswordSecret("db"): testPassword})
+	host := core.SSHHost{
+		Name: "db", Host: "127.0.0.1", Port: server.port(), User: "tester",
+		PasswordSecret: ssh.PasswordSecret("db"),
+	}
+
+	declined := errors.New("declined")
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	manager.SetSecretStore(store, func(context.Context, string) error { return declined })
+	if _, err := manager.Open(context.Background(), "db", host); !errors.Is(err, declined) {
+		t.Errorf("expected the declined unlock to fail the connection, got %v", err)
+	}
+
+	// Without a way to unlock it, a locked store fails too
+	manager.SetSecretStore(store, nil)
+	if _, err := manager.Open(context.Background(), "db", host); err == nil {
+		t.Error("expected a locked store to fail the connection")
+	}
+}
+
+func TestMigrateHostSecrets(t *testing.T) {
+	t.Parallel()
+
+	store := secrets.NewManager(filepath.Join(t.TempDir(), "secrets.enc"))
+	if err := store.Initialize("master"); err != nil {
+		t.Fatal(err)
+	}
+	hosts := []core.SSHHost{
+		{Name: "web", Host: "web.example.com", KeyPath: "~/.ssh/id_web", Passphrase: "plain"},
+		{Name: "db", Host: "db.example.com"},
+	}
+
+	migrated, moved, err := ssh.MigrateHostSecrets(hosts, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 {
+		t.Errorf("expected 1 passphrase to be moved, got %d", moved)
+	}
+	if migrated[0].Passphrase != "" || migrated[0].PassphraseSecret != ssh.PassphraseSecret("web") {
+		t.Errorf("expected web to refer to its secret, got %+v", migrated[0])
+	}
+	if migrated[1].PassphraseSecret != "" {
+		t.Errorf("expected db to be unchanged, got %+v", migrated[1])
+	}
+	if hosts[0].Passphrase != "plain" {
+		t.Error("expected the hosts passed in to be left alone")
+	}
+	if value, err := store.Retrieve(ssh.PassphraseSecret("web")); err != nil || string(value) != "plain" {
+		t.Errorf("expected the passphrase in the store, got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,533 @@
+package ssh_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/creack/pty"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testPassword is the password the test server accepts.
+const testPassword = "secret"
+
+// testServer is an in-process SSH server that runs commands with the
+// local /bin/sh, on a pseudo-terminal when one is requested.
+type testServer struct {
+	addr     string
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+	wg       sync.WaitGroup
+
+	mu         sync.Mutex
+	authorized []ssh.PublicKey
+	forwarded  []string
+	agentKeys  []string
+	conns      []ssh.Conn
+	// userCA signs user certificates the server accepts; certLogins
+	// counts the logins with one.
+	userCA     ssh.PublicKey
+	certLogins int
+	// unresponsive leaves keepalives unanswered, as a host that has gone
+	// away without closing the connection would.
+	unresponsive bool
+}
+
+// newTestServer starts a server on a random local port. It is stopped when
+// the test ends.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{addr: listener.Addr().String(), listener: listener, hostKey: signer}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != testPassword {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if cert, ok := key.(*ssh.Certificate); ok {
+				if s.userCA == nil || cert.CertType != ssh.UserCert || !bytes.Equal(cert.SignatureKey.Marshal(), s.userCA.Marshal()) {
+					return nil, errors.New("unknown certificate authority")
+				}
+				s.certLogins++
+				return nil, nil
+			}
+			for _, authorized := range s.authorized {
+				if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// authorizeKey writes a new private key to a file in dir, which the server
+// then accepts, and returns the file's path.
+func (s *testServer) authorizeKey(t *testing.T, dir string) string {
+	t.Helper()
+
+	key := s.authorizeNewKey(t)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "id_test")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// authorizeNewKey generates a private key that the server then accepts.
+func (s *testServer) authorizeNewKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.authorized = append(s.authorized, sshPub)
+	s.mu.Unlock()
+	return key
+}
+
+// useHostCertificate makes the server present a certificate of its host
+// key, signed by ca for principals.
+func (s *testServer) useHostCertificate(t *testing.T, ca ssh.Signer, principals ...string) {
+	t.Helper()
+
+	cert := &ssh.Certificate{
+		Key:             s.hostKey.PublicKey(),
+		CertType:        ssh.HostCert,
+		KeyId:           "test host",
+		ValidPrincipals: principals,
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewCertSigner(cert, s.hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.AddHostKey(signer)
+}
+
+// trustUserCA makes the server accept user certificates signed by ca.
+func (s *testServer) trustUserCA(ca ssh.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userCA = ca
+}
+
+// certificateLogins returns how many logins used a user certificate.
+func (s *testServer) certificateLogins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.certLogins
+}
+
+// agentKeyComments returns the comments of the keys listed by agents
+// forwarded to the server.
+func (s *testServer) agentKeyComments() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.agentKeys...)
+}
+
+// forwardedTargets returns the addresses of direct-tcpip channels the
+// server has opened.
+func (s *testServer) forwardedTargets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.forwarded...)
+}
+
+// dropConnections closes every connection to the server, as a host that
+// restarts would.
+func (s *testServer) dropConnections() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// setUnresponsive makes the server leave keepalives unanswered, or answer
+// them again.
+func (s *testServer) setUnresponsive(unresponsive bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unresponsive = unresponsive
+}
+
+// port returns the port the server listens on.
+func (s *testServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *testServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *testServer) handleConn(netConn net.Conn) {
+	s.mu.Lock()
+	config := *s.config
+	s.mu.Unlock()
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, &config)
+	if err != nil {
+		netConn.Close()
+		return
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+	go s.handleGlobalRequests(conn, reqs)
+
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "session":
+			channel, requests, err := newChan.Accept()
+			if err != nil {
+				continue
+			}
+			go s.handleSession(conn, channel, requests)
+
+		case "direct-tcpip":
+			go s.handleDirectTCPIP(newChan)
+
+		default:
+			_ = newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// handleGlobalRequests serves tcpip-forward requests, as for ssh -R, by
+// listening locally and forwarding connections back over conn. Listeners
+// are closed when conn closes. Other requests, such as keepalives, are
+// refused, or left unanswered while the server is unresponsive.
+func (s *testServer) handleGlobalRequests(conn ssh.Conn, reqs <-chan *ssh.Request) {
+	listeners := make(map[string]net.Listener)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for req := range reqs {
+		s.mu.Lock()
+		unresponsive := s.unresponsive
+		s.mu.Unlock()
+		if unresponsive && strings.HasPrefix(req.Type, "keepalive@") {
+			continue
+		}
+
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		if req.Type != "tcpip-forward" && req.Type != "cancel-tcpip-forward" || ssh.Unmarshal(req.Payload, &payload) != nil {
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+			continue
+		}
+
+		addr := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+		if req.Type == "cancel-tcpip-forward" {
+			if listener, ok := listeners[addr]; ok {
+				listener.Close()
+				delete(listeners, addr)
+			}
+			_ = req.Reply(true, nil)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = listener
+		_ = req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func() {
+			for {
+				local, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				origin := local.RemoteAddr().(*net.TCPAddr)
+				channel, requests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+					Addr       string
+					Port       uint32
+					OriginAddr string
+					OriginPort uint32
+				}{payload.Addr, port, origin.IP.String(), uint32(origin.Port)}))
+				if err != nil {
+					local.Close()
+					continue
+				}
+				go ssh.DiscardRequests(requests)
+				go func() {
+					_, _ = io.Copy(channel, local)
+					channel.CloseWrite()
+				}()
+				go func() {
+					_, _ = io.Copy(local, channel)
+					local.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// handleDirectTCPIP connects a direct-tcpip channel to its target, as a
+// jump host does.
+func (s *testServer) handleDirectTCPIP(newChan ssh.NewChannel) {
+	var payload struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &payload); err != nil {
+		_ = newChan.Reject(ssh.ConnectionFailed, "bad payload")
+		return
+	}
+
+	addr := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		_ = newChan.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChan.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, addr)
+	s.mu.Unlock()
+
+	go func() {
+		_, _ = io.Copy(target, channel)
+		target.Close()
+	}()
+	_, _ = io.Copy(channel, target)
+	channel.Close()
+}
+
+// listForwardedAgent lists the keys of the agent forwarded over conn.
+func (s *testServer) listForwardedAgent(conn ssh.Conn) {
+	channel, requests, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	keys, err := agent.NewClient(channel).List()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.agentKeys = append(s.agentKeys, key.Comment)
+	}
+}
+
+// handleSession serves the requests of a session channel: pty-req,
+// window-change, auth-agent-req@openssh.com, exec and the sftp subsystem.
+// A forwarded agent's keys are listed right away.
+func (s *testServer) handleSession(conn ssh.Conn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	var (
+		mu      sync.Mutex
+		size    *pty.Winsize
+		ptmx    io.ReadWriteCloser
+		resizer func(*pty.Winsize)
+		done    = make(chan struct{})
+		started bool
+	)
+
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			var payload struct {
+				Term   string
+				Cols   uint32
+				Rows   uint32
+				Width  uint32
+				Height uint32
+				Modes  string
+			}
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			mu.Lock()
+			size = &pty.Winsize{Cols: uint16(payload.Cols), Rows: uint16(payload.Rows)}
+			mu.Unlock()
+			_ = req.Reply(true, nil)
+
+		case "auth-agent-req@openssh.com":
+			_ = req.Reply(true, nil)
+			go s.listForwardedAgent(conn)
+
+		case "window-change":
+			cols := binary.BigEndian.Uint32(req.Payload)
+			rows := binary.BigEndian.Uint32(req.Payload[4:])
+			mu.Lock()
+			size = &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}
+			if resizer != nil {
+				resizer(size)
+			}
+			mu.Unlock()
+
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || started {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			started = true
+
+			cmd := exec.Command("/bin/sh", "-c", payload.Command)
+			mu.Lock()
+			if size != nil {
+				f, err := pty.StartWithSize(cmd, size)
+				if err != nil {
+					mu.Unlock()
+					_ = req.Reply(false, nil)
+					return
+				}
+				ptmx = f
+				resizer = func(size *pty.Winsize) { _ = pty.Setsize(f, size) }
+				go func() { _, _ = io.Copy(f, channel) }()
+				go func() {
+					_, _ = io.Copy(channel, f)
+					close(done)
+				}()
+			} else {
+				cmd.Stdin = channel
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				if err := cmd.Start(); err != nil {
+					mu.Unlock()
+					_ = req.Reply(false, nil)
+					return
+				}
+				close(done)
+			}
+			mu.Unlock()
+			_ = req.Reply(true, nil)
+
+			go func() {
+				_ = cmd.Wait()
+				<-done
+				if ptmx != nil {
+					ptmx.Close()
+				}
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, uint32(cmd.ProcessState.ExitCode()))
+				_, _ = channel.SendRequest("exit-status", false, status)
+				channel.Close()
+			}()
+
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" || started {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			started = true
+			_ = req.Reply(true, nil)
+			go func() {
+				_ = server.Serve()
+				server.Close()
+			}()
+
+		default:
+			if req.WantReply {
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+
+	if started && ptmx != nil {
+		ptmx.Close()
+	}
+}
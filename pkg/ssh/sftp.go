@@ -0,0 +1,193 @@
+package ssh
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTP returns an SFTP client on the connection, starting the subsystem
+// the first time. The client is shared by everything that transfers files
+// over the connection and is replaced if it stops.
+func (c *Connection) SFTP() (*sftp.Client, error) {
+	c.sftpMu.Lock()
+	defer c.sftpMu.Unlock()
+
+	if c.sftp != nil {
+		return c.sftp, nil
+	}
+	conn, _, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SFTP: %w", err)
+	}
+	c.sftp = client
+
+	go func() {
+		_ = client.Wait()
+		c.sftpMu.Lock()
+		if c.sftp == client {
+			c.sftp = nil
+		}
+		c.sftpMu.Unlock()
+	}()
+	return client, nil
+}
+
+// SFTP returns the SFTP client of the named connection.
+func (m *Manager) SFTP(connection string) (*sftp.Client, error) {
+	conn, ok := m.Connection(connection)
+	if !ok {
+		return nil, fmt.Errorf("connection not found: %s", connection)
+	}
+	return conn.SFTP()
+}
+
+// transferFile is an open file on either side of a transfer.
+type transferFile interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	Seek(offset int64, whence int) (int64, error)
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+// transferFS is one side of a transfer: the local file system or a host's
+// over SFTP.
+type transferFS interface {
+	// Name describes the side for messages, such as "local" or a
+	// connection name.
+	Name() string
+	Glob(pattern string) ([]string, error)
+	Stat(name string) (os.FileInfo, error)
+	// Walk calls fn for root and everything below it, parents first.
+	Walk(root string, fn func(name string, info os.FileInfo) error) error
+	Join(elem ...string) string
+	Base(name string) string
+	Rel(base, target string) (string, error)
+	MkdirAll(dir string) error
+	OpenFile(name string, flag int) (transferFile, error)
+}
+
+// localFS is the local file system, with relative paths taken from dir.
+type localFS struct {
+	dir string
+}
+
+func (l localFS) Name() string {
+	return "local"
+}
+
+func (l localFS) abs(name string) string {
+	if filepath.IsAbs(name) || l.dir == "" {
+		return name
+	}
+	return filepath.Join(l.dir, name)
+}
+
+func (l localFS) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(l.abs(pattern))
+}
+
+func (l localFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(l.abs(name))
+}
+
+func (l localFS) Walk(root string, fn func(string, os.FileInfo) error) error {
+	return filepath.WalkDir(l.abs(root), func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(name, info)
+	})
+}
+
+func (l localFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (l localFS) Base(name string) string {
+	return filepath.Base(name)
+}
+
+func (l localFS) Rel(base, target string) (string, error) {
+	return filepath.Rel(l.abs(base), l.abs(target))
+}
+
+func (l localFS) MkdirAll(dir string) error {
+	return os.MkdirAll(l.abs(dir), 0o755)
+}
+
+func (l localFS) OpenFile(name string, flag int) (transferFile, error) {
+	return os.OpenFile(l.abs(name), flag, 0o644)
+}
+
+// remoteFS is a host's file system over SFTP. Relative paths are taken
+// from the directory the SFTP server starts in, usually the home
+// directory.
+type remoteFS struct {
+	name   string
+	client *sftp.Client
+}
+
+func (r remoteFS) Name() string {
+	return r.name
+}
+
+func (r remoteFS) Glob(pattern string) ([]string, error) {
+	return r.client.Glob(pattern)
+}
+
+func (r remoteFS) Stat(name string) (os.FileInfo, error) {
+	return r.client.Stat(name)
+}
+
+func (r remoteFS) Walk(root string, fn func(string, os.FileInfo) error) error {
+	walker := r.client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		if err := fn(walker.Path(), walker.Stat()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r remoteFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (r remoteFS) Base(name string) string {
+	return path.Base(name)
+}
+
+func (r remoteFS) Rel(base, target string) (string, error) {
+	rel, err := filepath.Rel(filepath.FromSlash(base), filepath.FromSlash(target))
+	return filepath.ToSlash(rel), err
+}
+
+func (r remoteFS) MkdirAll(dir string) error {
+	return r.client.MkdirAll(dir)
+}
+
+func (r remoteFS) OpenFile(name string, flag int) (transferFile, error) {
+	file, err := r.client.OpenFile(name, flag)
+	if err != nil {
+		// The client leaves the file's path out of its errors
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return file, nil
+}
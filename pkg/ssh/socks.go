@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// socksHandshakeTimeout bounds the SOCKS5 handshake of a dynamic tunnel's
+// client.
+const socksHandshakeTimeout = 30 * time.Second
+
+// SOCKS5 protocol values, from RFC 1928.
+const (
+	socksVersion        = 5
+	socksNoAuth         = 0
+	socksNoAcceptable   = 0xff
+	socksCmdConnect     = 1
+	socksAddrIPv4       = 1
+	socksAddrDomain     = 3
+	socksAddrIPv6       = 4
+	socksSucceeded      = 0
+	socksGeneralFailure = 1
+	socksRefused        = 5
+	socksNoCommand      = 7
+	socksNoAddrType     = 8
+)
+
+// socksConnect serves the SOCKS5 handshake of a client on conn, without
+// authentication and for the CONNECT command only. It dials the address
+// the client asks for and tells the client whether that worked.
+func socksConnect(conn net.Conn, dial func(network, addr string) (net.Conn, error)) (net.Conn, error) {
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// Greeting: version and the offered authentication methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != socksVersion {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+	noAuth := false
+	for _, method := range methods {
+		noAuth = noAuth || method == socksNoAuth
+	}
+	if !noAuth {
+		_, _ = conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return nil, errors.New("SOCKS client requires authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return nil, err
+	}
+
+	// Request: version, command, reserved and the address
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, err
+	}
+	if request[1] != socksCmdConnect {
+		_ = socksReply(conn, socksNoCommand)
+		return nil, fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddrIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return nil, err
+		}
+		host = string(domain)
+	default:
+		_ = socksReply(conn, socksNoAddrType)
+		return nil, fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+	target, err := dial("tcp", addr)
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) && openErr.Reason == ssh.ConnectionFailed {
+			_ = socksReply(conn, socksRefused)
+		} else {
+			_ = socksReply(conn, socksGeneralFailure)
+		}
+		return nil, err
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+// socksReply answers a SOCKS5 request. The bound address is left empty,
+// as it is not known on this side of the connection.
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
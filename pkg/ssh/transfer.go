@@ -0,0 +1,518 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TransferDirection is which way a transfer copies its file.
+type TransferDirection string
+
+const (
+	// TransferUpload copies a local file to a host.
+	TransferUpload TransferDirection = "upload"
+	// TransferDownload copies a file from a host.
+	TransferDownload TransferDirection = "download"
+)
+
+// TransferState is where a transfer is in the queue.
+type TransferState string
+
+const (
+	TransferQueued   TransferState = "queued"   // Waiting for the transfers before it
+	TransferRunning  TransferState = "running"  // Copying
+	TransferDone     TransferState = "done"     // Copied
+	TransferFailed   TransferState = "failed"   // Stopped by an error, see Err
+	TransferCanceled TransferState = "canceled" // Canceled while queued or running
+)
+
+// errTransferCanceled stops a transfer that is canceled while it runs.
+var errTransferCanceled = errors.New("transfer canceled")
+
+// TransferOptions controls which files Put and Get queue and how.
+type TransferOptions struct {
+	// Recursive copies directories with everything below them.
+	Recursive bool
+	// Resume continues files that were partly copied before instead of
+	// starting them over. Destinations larger than their source are
+	// started over.
+	Resume bool
+	// LocalDir is the directory relative local paths are taken from. The
+	// process's working directory is used if it is empty.
+	LocalDir string
+}
+
+// Transfer is a file copied over SFTP. Transfers run one at a time in the
+// order they were queued, and stay in the manager's list once finished
+// until ClearTransfers is called.
+type Transfer struct {
+	id         int
+	direction  TransferDirection
+	connection string
+	source     string
+	dest       string
+	resume     bool
+	from, to   transferFS
+
+	size        atomic.Int64
+	transferred atomic.Int64
+
+	mu       sync.Mutex
+	state    TransferState
+	err      error
+	started  time.Time
+	finished time.Time
+
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	done       chan struct{}
+}
+
+func newTransfer(direction TransferDirection, connection string, from, to transferFS, source, dest string, size int64, resume bool) *Transfer {
+	t := &Transfer{
+		direction:  direction,
+		connection: connection,
+		source:     source,
+		dest:       dest,
+		resume:     resume,
+		from:       from,
+		to:         to,
+		state:      TransferQueued,
+		cancel:     make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	t.size.Store(size)
+	return t
+}
+
+// ID returns the transfer's number in the queue.
+func (t *Transfer) ID() int {
+	return t.id
+}
+
+// Direction returns which way the transfer copies.
+func (t *Transfer) Direction() TransferDirection {
+	return t.direction
+}
+
+// Connection returns the name of the connection the transfer runs over.
+func (t *Transfer) Connection() string {
+	return t.connection
+}
+
+// Source returns the path of the file being copied, on the local machine
+// for uploads and on the host for downloads.
+func (t *Transfer) Source() string {
+	return t.source
+}
+
+// Dest returns the path the file is copied to.
+func (t *Transfer) Dest() string {
+	return t.dest
+}
+
+// Size returns the size of the file in bytes.
+func (t *Transfer) Size() int64 {
+	return t.size.Load()
+}
+
+// Transferred returns how many bytes of the file are at the destination,
+// counting those a resumed transfer found there.
+func (t *Transfer) Transferred() int64 {
+	return t.transferred.Load()
+}
+
+// State returns where the transfer is in the queue.
+func (t *Transfer) State() TransferState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Err returns why the transfer failed, or nil.
+func (t *Transfer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Started returns when the transfer started running, or the zero time if
+// it has not.
+func (t *Transfer) Started() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.started
+}
+
+// Finished returns when the transfer finished, or the zero time if it
+// has not.
+func (t *Transfer) Finished() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.finished
+}
+
+// Done returns a channel that is closed when the transfer has finished,
+// whether it succeeded, failed or was canceled.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Cancel stops the transfer if it is running, or takes it off the queue.
+// A canceled download or upload leaves the part that was copied, so it
+// can be resumed.
+func (t *Transfer) Cancel() {
+	t.cancelOnce.Do(func() {
+		close(t.cancel)
+	})
+
+	t.mu.Lock()
+	queued := t.state == TransferQueued
+	if queued {
+		t.state = TransferCanceled
+		t.finished = time.Now()
+	}
+	t.mu.Unlock()
+	if queued {
+		close(t.done)
+	}
+}
+
+// run copies the file unless the transfer was canceled while queued.
+func (t *Transfer) run() {
+	t.mu.Lock()
+	if t.state != TransferQueued {
+		t.mu.Unlock()
+		return
+	}
+	t.state = TransferRunning
+	t.started = time.Now()
+	t.mu.Unlock()
+
+	err := t.copy()
+
+	t.mu.Lock()
+	switch {
+	case errors.Is(err, errTransferCanceled):
+		t.state = TransferCanceled
+	case err != nil:
+		t.state = TransferFailed
+		t.err = err
+	default:
+		t.state = TransferDone
+	}
+	t.finished = time.Now()
+	t.mu.Unlock()
+	close(t.done)
+}
+
+// copy copies the source to the destination, from where an earlier
+// attempt stopped if the transfer resumes.
+func (t *Transfer) copy() error {
+	src, err := t.from.OpenFile(t.source, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	t.size.Store(size)
+
+	var offset int64
+	if t.resume {
+		if existing, err := t.to.Stat(t.dest); err == nil && existing.Mode().IsRegular() && existing.Size() <= size {
+			offset = existing.Size()
+		}
+	}
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flag |= os.O_TRUNC
+	}
+
+	dst, err := t.to.OpenFile(t.dest, flag)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			dst.Close()
+			return err
+		}
+		if _, err := dst.Seek(offset, io.SeekStart); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+	t.transferred.Store(offset)
+
+	// Writes go out in order, so whatever is at the destination when a
+	// transfer stops is a prefix of the source that can be resumed
+	_, err = io.Copy(transferWriter{t: t, w: dst}, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// transferWriter counts the bytes written through it and fails once the
+// transfer is canceled.
+type transferWriter struct {
+	t *Transfer
+	w io.Writer
+}
+
+func (w transferWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.t.cancel:
+		return 0, errTransferCanceled
+	default:
+	}
+	n, err := w.w.Write(p)
+	w.t.transferred.Add(int64(n))
+	return n, err
+}
+
+// Put queues copying local files to the host of the named connection.
+// Sources may be glob patterns. dest is a directory on the host to copy
+// into, or the path to copy to when there is a single source; it defaults
+// to the directory the host's SFTP server starts in. Directories are only
+// copied with opts.Recursive, and are created on the host before Put
+// returns.
+func (m *Manager) Put(connection string, sources []string, dest string, opts TransferOptions) ([]*Transfer, error) {
+	client, err := m.SFTP(connection)
+	if err != nil {
+		return nil, err
+	}
+	return m.queueTransfers(TransferUpload, connection, localFS{dir: opts.LocalDir}, remoteFS{name: connection, client: client}, sources, dest, opts)
+}
+
+// Get queues copying files from the host of the named connection, like
+// Put the other way around. dest defaults to opts.LocalDir.
+func (m *Manager) Get(connection string, sources []string, dest string, opts TransferOptions) ([]*Transfer, error) {
+	client, err := m.SFTP(connection)
+	if err != nil {
+		return nil, err
+	}
+	return m.queueTransfers(TransferDownload, connection, remoteFS{name: connection, client: client}, localFS{dir: opts.LocalDir}, sources, dest, opts)
+}
+
+// plannedFile is a file Put or Get will copy.
+type plannedFile struct {
+	source, dest string
+	size         int64
+}
+
+// queueTransfers expands sources, creates the directories they need and
+// queues a transfer for each file.
+func (m *Manager) queueTransfers(direction TransferDirection, connection string, from, to transferFS, sources []string, dest string, opts TransferOptions) ([]*Transfer, error) {
+	files, dirs, err := planTransfers(from, to, sources, dest, opts.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if err := to.MkdirAll(dir); err != nil {
+			return nil, fmt.Errorf("%s: failed to create %s: %w", to.Name(), dir, err)
+		}
+	}
+
+	transfers := make([]*Transfer, 0, len(files))
+	for _, file := range files {
+		transfers = append(transfers, newTransfer(direction, connection, from, to, file.source, file.dest, file.size, opts.Resume))
+	}
+	m.enqueueTransfers(transfers...)
+	return transfers, nil
+}
+
+// planTransfers expands the glob patterns in sources and walks the
+// directories they match, returning the files to copy and the directories
+// to create for them.
+func planTransfers(from, to transferFS, sources []string, dest string, recursive bool) ([]plannedFile, []string, error) {
+	if len(sources) == 0 {
+		return nil, nil, errors.New("no files to transfer")
+	}
+	if dest == "" {
+		dest = "."
+	}
+
+	var matches []string
+	for _, pattern := range sources {
+		found, err := from.Glob(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(found) == 0 {
+			return nil, nil, fmt.Errorf("%s: %s: no such file or directory", from.Name(), pattern)
+		}
+		matches = append(matches, found...)
+	}
+
+	destInfo, err := to.Stat(dest)
+	destIsDir := err == nil && destInfo.IsDir()
+	if len(matches) > 1 && !destIsDir {
+		return nil, nil, fmt.Errorf("%s: %s: not a directory", to.Name(), dest)
+	}
+
+	var (
+		files []plannedFile
+		dirs  []string
+	)
+	for _, match := range matches {
+		target := dest
+		if destIsDir {
+			target = to.Join(dest, from.Base(match))
+		}
+
+		info, err := from.Stat(match)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", from.Name(), err)
+		}
+		if !info.IsDir() {
+			files = append(files, plannedFile{source: match, dest: target, size: info.Size()})
+			continue
+		}
+		if !recursive {
+			return nil, nil, fmt.Errorf("%s: %s: is a directory (use -r)", from.Name(), match)
+		}
+
+		err = from.Walk(match, func(name string, info os.FileInfo) error {
+			rel, err := from.Rel(match, name)
+			if err != nil {
+				return err
+			}
+			into := to.Join(target, filepath.ToSlash(rel))
+			switch {
+			case info.IsDir():
+				dirs = append(dirs, into)
+			case info.Mode().IsRegular():
+				files = append(files, plannedFile{source: name, dest: into, size: info.Size()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", from.Name(), err)
+		}
+	}
+	return files, dirs, nil
+}
+
+// enqueueTransfers numbers transfers and queues them, starting the worker
+// that runs the queue if it is idle.
+func (m *Manager) enqueueTransfers(transfers ...*Transfer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range transfers {
+		m.nextTransferID++
+		t.id = m.nextTransferID
+		m.transfers = append(m.transfers, t)
+		m.pendingTransfers = append(m.pendingTransfers, t)
+	}
+	if !m.transferring && len(m.pendingTransfers) > 0 {
+		m.transferring = true
+		go m.runTransfers()
+	}
+}
+
+// runTransfers runs queued transfers until the queue is empty.
+func (m *Manager) runTransfers() {
+	for {
+		m.mu.Lock()
+		if len(m.pendingTransfers) == 0 {
+			m.transferring = false
+			m.mu.Unlock()
+			return
+		}
+		t := m.pendingTransfers[0]
+		m.pendingTransfers = m.pendingTransfers[1:]
+		m.mu.Unlock()
+
+		t.run()
+	}
+}
+
+// Transfers returns the queued, running and finished transfers, in the
+// order they were queued.
+func (m *Manager) Transfers() []*Transfer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]*Transfer(nil), m.transfers...)
+}
+
+// transfer returns the transfer with the given ID.
+func (m *Manager) transfer(id int) (*Transfer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.transfers {
+		if t.id == id {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("transfer not found: %d", id)
+}
+
+// CancelTransfer cancels the transfer with the given ID.
+func (m *Manager) CancelTransfer(id int) error {
+	t, err := m.transfer(id)
+	if err != nil {
+		return err
+	}
+	switch t.State() {
+	case TransferQueued, TransferRunning:
+		t.Cancel()
+		return nil
+	}
+	return fmt.Errorf("transfer %d already %s", id, t.State())
+}
+
+// RetryTransfer queues a failed or canceled transfer again, resuming from
+// where it stopped. The new transfer gets an ID of its own.
+func (m *Manager) RetryTransfer(id int) (*Transfer, error) {
+	t, err := m.transfer(id)
+	if err != nil {
+		return nil, err
+	}
+	if state := t.State(); state != TransferFailed && state != TransferCanceled {
+		return nil, fmt.Errorf("transfer %d is %s", id, state)
+	}
+
+	// The connection may have been opened again since, with a new client
+	client, err := m.SFTP(t.connection)
+	if err != nil {
+		return nil, err
+	}
+	from, to := t.from, t.to
+	if t.direction == TransferUpload {
+		to = remoteFS{name: t.connection, client: client}
+	} else {
+		from = remoteFS{name: t.connection, client: client}
+	}
+
+	retry := newTransfer(t.direction, t.connection, from, to, t.source, t.dest, t.Size(), true)
+	m.enqueueTransfers(retry)
+	return retry, nil
+}
+
+// ClearTransfers removes finished transfers from the list.
+func (m *Manager) ClearTransfers() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.transfers[:0]
+	for _, t := range m.transfers {
+		if state := t.State(); state == TransferQueued || state == TransferRunning {
+			kept = append(kept, t)
+		}
+	}
+	clear(m.transfers[len(kept):])
+	m.transfers = kept
+}
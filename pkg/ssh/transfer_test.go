@@ -0,0 +1,286 @@
+package ssh_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// writeFiles creates files under dir from a map of slash-separated
+// relative paths to contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFile returns the content of a file, failing the test if it cannot
+// be read.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// waitTransfers waits for transfers to finish and fails the test unless
+// they all succeeded.
+func waitTransfers(t *testing.T, transfers []*ssh.Transfer) {
+	t.Helper()
+	for _, transfer := range transfers {
+		select {
+		case <-transfer.Done():
+		case <-time.After(10 * time.Second):
+			t.Fatalf("transfer #%d of %s did not finish", transfer.ID(), transfer.Source())
+		}
+		if transfer.State() != ssh.TransferDone {
+			t.Fatalf("transfer #%d of %s is %s: %v", transfer.ID(), transfer.Source(), transfer.State(), transfer.Err())
+		}
+	}
+}
+
+func TestPutAndGet(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	openTestConnection(t, manager, server, "web")
+
+	local := t.TempDir()
+	remote := t.TempDir()
+	writeFiles(t, local, map[string]string{
+		"a.txt":           "alpha",
+		"b.txt":           "bravo",
+		"c.log":           "charlie",
+		"site/index.html": "<h1>hi</h1>",
+		"site/css/s.css":  "body{}",
+	})
+	if err := os.Mkdir(filepath.Join(local, "site", "empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative local paths and globs are taken from LocalDir
+	transfers, err := manager.Put("web", []string{"*.txt"}, remote, ssh.TransferOptions{LocalDir: local})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if len(transfers) != 2 || transfers[0].Direction() != ssh.TransferUpload {
+		t.Fatalf("expected 2 uploads, got %d", len(transfers))
+	}
+	waitTransfers(t, transfers)
+	if got := readFile(t, filepath.Join(remote, "b.txt")); got != "bravo" {
+		t.Errorf("expected b.txt uploaded, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "c.log")); err == nil {
+		t.Error("expected c.log not to match *.txt")
+	}
+
+	if _, err := manager.Put("web", []string{"site"}, remote, ssh.TransferOptions{LocalDir: local}); err == nil || !strings.Contains(err.Error(), "-r") {
+		t.Errorf("expected directories to need -r, got %v", err)
+	}
+	transfers, err = manager.Put("web", []string{"site"}, remote, ssh.TransferOptions{LocalDir: local, Recursive: true})
+	if err != nil {
+		t.Fatalf("recursive Put failed: %v", err)
+	}
+	waitTransfers(t, transfers)
+	if got := readFile(t, filepath.Join(remote, "site", "css", "s.css")); got != "body{}" {
+		t.Errorf("expected site/css/s.css uploaded, got %q", got)
+	}
+	if info, err := os.Stat(filepath.Join(remote, "site", "empty")); err != nil || !info.IsDir() {
+		t.Errorf("expected the empty directory to be created, got %v", err)
+	}
+
+	// A single source can be copied to a new name
+	back := t.TempDir()
+	transfers, err = manager.Get("web", []string{filepath.Join(remote, "a.txt")}, "renamed.txt", ssh.TransferOptions{LocalDir: back})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	waitTransfers(t, transfers)
+	if got := readFile(t, filepath.Join(back, "renamed.txt")); got != "alpha" {
+		t.Errorf("expected a.txt downloaded as renamed.txt, got %q", got)
+	}
+
+	transfers, err = manager.Get("web", []string{filepath.Join(remote, "s*")}, "", ssh.TransferOptions{LocalDir: back, Recursive: true})
+	if err != nil {
+		t.Fatalf("recursive Get failed: %v", err)
+	}
+	waitTransfers(t, transfers)
+	if got := readFile(t, filepath.Join(back, "site", "index.html")); got != "<h1>hi</h1>" {
+		t.Errorf("expected site/index.html downloaded, got %q", got)
+	}
+
+	if _, err := manager.Get("web", []string{filepath.Join(remote, "*.txt")}, "renamed.txt", ssh.TransferOptions{LocalDir: back}); err == nil {
+		t.Error("expected an error copying several files to a file")
+	}
+	if _, err := manager.Get("web", []string{filepath.Join(remote, "*.zip")}, "", ssh.TransferOptions{LocalDir: back}); err == nil {
+		t.Error("expected an error for a pattern matching nothing")
+	}
+	if _, err := manager.Put("missing", []string{"a.txt"}, "", ssh.TransferOptions{LocalDir: local}); err == nil {
+		t.Error("expected an error for an unknown connection")
+	}
+
+	if got := len(manager.Transfers()); got != 7 {
+		t.Errorf("expected 7 transfers in the list, got %d", got)
+	}
+	manager.ClearTransfers()
+	if got := len(manager.Transfers()); got != 0 {
+		t.Errorf("expected finished transfers to be cleared, got %d", got)
+	}
+}
+
+func TestTransferResume(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	openTestConnection(t, manager, server, "web")
+
+	local := t.TempDir()
+	remote := t.TempDir()
+	source := bytes.Repeat([]byte("0123456789"), 50000)
+	if err := os.WriteFile(filepath.Join(local, "big.bin"), source, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A different prefix shows the copy continued rather than started over
+	prefix := bytes.Repeat([]byte("x"), 123456)
+	dest := filepath.Join(remote, "big.bin")
+	if err := os.WriteFile(dest, prefix, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	transfers, err := manager.Put("web", []string{"big.bin"}, remote, ssh.TransferOptions{LocalDir: local, Resume: true})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	waitTransfers(t, transfers)
+
+	want := append(append([]byte(nil), prefix...), source[len(prefix):]...)
+	if got := readFile(t, dest); got != string(want) {
+		t.Errorf("expected the transfer to resume after %d bytes", len(prefix))
+	}
+	if transfers[0].Transferred() != int64(len(source)) || transfers[0].Size() != int64(len(source)) {
+		t.Errorf("expected %d bytes transferred, got %d of %d", len(source), transfers[0].Transferred(), transfers[0].Size())
+	}
+
+	// Without Resume the file is copied from the start
+	transfers, err = manager.Put("web", []string{"big.bin"}, remote, ssh.TransferOptions{LocalDir: local})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	waitTransfers(t, transfers)
+	if got := readFile(t, dest); got != string(source) {
+		t.Error("expected the file to be copied over")
+	}
+}
+
+func TestCancelAndRetryTransfer(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	openTestConnection(t, manager, server, "web")
+
+	local := t.TempDir()
+	remote := t.TempDir()
+	writeFiles(t, local, map[string]string{"next.txt": "queued"})
+
+	// Reading a FIFO blocks until data is written, which holds the first
+	// transfer in the middle of its copy
+	fifo := filepath.Join(local, "stream")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	stalled, err := manager.Put("web", []string{"stream"}, remote, ssh.TransferOptions{LocalDir: local})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	queued, err := manager.Put("web", []string{"next.txt"}, remote, ssh.TransferOptions{LocalDir: local})
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	writer, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if _, err := writer.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, func() bool { return stalled[0].Transferred() == 5 }) {
+		t.Fatalf("expected the first chunk copied, got %d bytes", stalled[0].Transferred())
+	}
+	if stalled[0].State() != ssh.TransferRunning || queued[0].State() != ssh.TransferQueued {
+		t.Fatalf("expected one running and one queued transfer, got %s and %s", stalled[0].State(), queued[0].State())
+	}
+
+	if err := manager.CancelTransfer(queued[0].ID()); err != nil {
+		t.Fatalf("CancelTransfer failed: %v", err)
+	}
+	if queued[0].State() != ssh.TransferCanceled {
+		t.Errorf("expected the queued transfer canceled, got %s", queued[0].State())
+	}
+	if err := manager.CancelTransfer(stalled[0].ID()); err != nil {
+		t.Fatalf("CancelTransfer failed: %v", err)
+	}
+	// The copy notices on its next write
+	if _, err := writer.Write([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stalled[0].Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the running transfer to stop")
+	}
+	if stalled[0].State() != ssh.TransferCanceled {
+		t.Errorf("expected the running transfer canceled, got %s: %v", stalled[0].State(), stalled[0].Err())
+	}
+	if got := readFile(t, filepath.Join(remote, "stream")); got != "first" {
+		t.Errorf("expected the copied part kept, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "next.txt")); err == nil {
+		t.Error("expected the canceled transfer not to run")
+	}
+
+	retry, err := manager.RetryTransfer(queued[0].ID())
+	if err != nil {
+		t.Fatalf("RetryTransfer failed: %v", err)
+	}
+	if retry.ID() == queued[0].ID() {
+		t.Error("expected the retry to get a new ID")
+	}
+	waitTransfers(t, []*ssh.Transfer{retry})
+	if got := readFile(t, filepath.Join(remote, "next.txt")); got != "queued" {
+		t.Errorf("expected the retried file copied, got %q", got)
+	}
+
+	if _, err := manager.RetryTransfer(retry.ID()); err == nil {
+		t.Error("expected an error retrying a finished transfer")
+	}
+	if err := manager.CancelTransfer(retry.ID()); err == nil {
+		t.Error("expected an error canceling a finished transfer")
+	}
+	if err := manager.CancelTransfer(999); err == nil {
+		t.Error("expected an error for an unknown transfer")
+	}
+}
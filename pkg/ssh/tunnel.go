@@ -0,0 +1,457 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"golang.org/x/crypto/ssh"
+)
+
+// tunnelDialTimeout bounds connecting to the target of a remote tunnel.
+const tunnelDialTimeout = 10 * time.Second
+
+// Tunnel is an active port forward over a connection. Tunnels are kept in
+// the manager's registry until they are stopped or their connection
+// closes.
+type Tunnel struct {
+	id         int
+	spec       core.TunnelSpec
+	connection string
+	started    time.Time
+	err        error
+
+	listener net.Listener
+	dial     func(net.Conn) (net.Conn, error)
+
+	sent     atomic.Int64
+	received atomic.Int64
+
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{} // Both ends of every forwarded connection
+	active    int
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ID returns the tunnel's number in the registry.
+func (t *Tunnel) ID() int {
+	return t.id
+}
+
+// Spec returns the forward the tunnel implements.
+func (t *Tunnel) Spec() core.TunnelSpec {
+	return t.spec
+}
+
+// Connection returns the name of the connection the tunnel runs over, or
+// "" for the current connection.
+func (t *Tunnel) Connection() string {
+	return t.connection
+}
+
+// Started returns when the tunnel was started.
+func (t *Tunnel) Started() time.Time {
+	return t.started
+}
+
+// Addr returns the address the tunnel listens on, with the port that was
+// picked if the spec asked for port 0. It is "" for a tunnel that failed.
+func (t *Tunnel) Addr() string {
+	if t.listener == nil {
+		return ""
+	}
+	return t.listener.Addr().String()
+}
+
+// Err returns why the tunnel could not be started, or nil if it is
+// running.
+func (t *Tunnel) Err() error {
+	return t.err
+}
+
+// BytesSent returns the number of bytes forwarded towards the target.
+func (t *Tunnel) BytesSent() int64 {
+	return t.sent.Load()
+}
+
+// BytesReceived returns the number of bytes forwarded back from the
+// target.
+func (t *Tunnel) BytesReceived() int64 {
+	return t.received.Load()
+}
+
+// ActiveConnections returns the number of connections being forwarded.
+func (t *Tunnel) ActiveConnections() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// Done returns a channel that is closed when the tunnel stops.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Close stops listening and closes the connections being forwarded.
+func (t *Tunnel) Close() error {
+	t.closeOnce.Do(func() {
+		if t.listener != nil {
+			_ = t.listener.Close()
+		}
+		t.mu.Lock()
+		for conn := range t.conns {
+			_ = conn.Close()
+		}
+		t.mu.Unlock()
+		close(t.done)
+	})
+	return nil
+}
+
+// openTunnel starts forwarding spec over client.
+func openTunnel(client *ssh.Client, connection string, spec core.TunnelSpec) (*Tunnel, error) {
+	spec, err := normalizeTunnel(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{
+		spec:       spec,
+		connection: connection,
+		started:    time.Now(),
+		conns:      make(map[net.Conn]struct{}),
+		done:       make(chan struct{}),
+	}
+
+	switch spec.Type {
+	case core.TunnelLocal:
+		t.listener, err = net.Listen("tcp", spec.Listen)
+		t.dial = func(net.Conn) (net.Conn, error) {
+			return client.Dial("tcp", spec.Target)
+		}
+	case core.TunnelRemote:
+		t.listener, err = client.Listen("tcp", spec.Listen)
+		t.dial = func(net.Conn) (net.Conn, error) {
+			return net.DialTimeout("tcp", spec.Target, tunnelDialTimeout)
+		}
+	case core.TunnelDynamic:
+		t.listener, err = net.Listen("tcp", spec.Listen)
+		t.dial = func(conn net.Conn) (net.Conn, error) {
+			return socksConnect(conn, client.Dial)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", spec.Listen, err)
+	}
+
+	go t.serve()
+	return t, nil
+}
+
+// failedTunnel records a tunnel that could not be started.
+func failedTunnel(connection string, spec core.TunnelSpec, err error) *Tunnel {
+	return &Tunnel{
+		spec:       spec,
+		connection: connection,
+		started:    time.Now(),
+		err:        err,
+		conns:      make(map[net.Conn]struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (t *Tunnel) serve() {
+	defer t.Close()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+// forward connects conn to the tunnel's target and copies between them
+// until either side is done.
+func (t *Tunnel) forward(conn net.Conn) {
+	if !t.track(conn) {
+		return
+	}
+	defer t.untrack(conn)
+
+	t.mu.Lock()
+	t.active++
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.active--
+		t.mu.Unlock()
+	}()
+
+	target, err := t.dial(conn)
+	if err != nil {
+		return
+	}
+	if !t.track(target) {
+		return
+	}
+	defer t.untrack(target)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(countingWriter{target, &t.sent}, conn)
+		_ = target.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(countingWriter{conn, &t.received}, target)
+		_ = conn.Close()
+	}()
+	wg.Wait()
+}
+
+// track records an open connection, or closes it if the tunnel has
+// stopped.
+func (t *Tunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.done:
+		_ = conn.Close()
+		return false
+	default:
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *Tunnel) untrack(conn net.Conn) {
+	_ = conn.Close()
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+}
+
+// countingWriter adds the bytes written through it to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// normalizeTunnel checks spec and fills in the default listen address.
+func normalizeTunnel(spec core.TunnelSpec) (core.TunnelSpec, error) {
+	switch spec.Type {
+	case core.TunnelLocal, core.TunnelRemote:
+		if _, _, err := net.SplitHostPort(spec.Target); err != nil {
+			return spec, fmt.Errorf("invalid tunnel target %q: %w", spec.Target, err)
+		}
+	case core.TunnelDynamic:
+		spec.Target = ""
+	default:
+		return spec, fmt.Errorf("unknown tunnel type: %q", spec.Type)
+	}
+
+	if _, err := strconv.Atoi(spec.Listen); err == nil {
+		spec.Listen = net.JoinHostPort("localhost", spec.Listen)
+	}
+	if _, _, err := net.SplitHostPort(spec.Listen); err != nil {
+		return spec, fmt.Errorf("invalid tunnel address %q: %w", spec.Listen, err)
+	}
+	return spec, nil
+}
+
+// ParseForward parses a forward given the way ssh takes it: flag is "L",
+// "R" or "D" and spec is [bind_address:]port:host:hostport, or
+// [bind_address:]port for dynamic forwards.
+func ParseForward(flag, spec string) (core.TunnelSpec, error) {
+	var tunnel core.TunnelSpec
+	switch strings.TrimPrefix(flag, "-") {
+	case "L":
+		tunnel.Type = core.TunnelLocal
+	case "R":
+		tunnel.Type = core.TunnelRemote
+	case "D":
+		tunnel.Type = core.TunnelDynamic
+	default:
+		return tunnel, fmt.Errorf("unknown forward flag: %s", flag)
+	}
+
+	parts := splitForward(spec)
+	switch {
+	case tunnel.Type == core.TunnelDynamic && len(parts) == 1:
+		tunnel.Listen = parts[0]
+	case tunnel.Type == core.TunnelDynamic && len(parts) == 2:
+		tunnel.Listen = net.JoinHostPort(parts[0], parts[1])
+	case tunnel.Type != core.TunnelDynamic && len(parts) == 3:
+		tunnel.Listen = parts[0]
+		tunnel.Target = net.JoinHostPort(parts[1], parts[2])
+	case tunnel.Type != core.TunnelDynamic && len(parts) == 4:
+		tunnel.Listen = net.JoinHostPort(parts[0], parts[1])
+		tunnel.Target = net.JoinHostPort(parts[2], parts[3])
+	default:
+		return tunnel, fmt.Errorf("invalid forward: %s", spec)
+	}
+	return normalizeTunnel(tunnel)
+}
+
+// splitForward splits a forward spec at colons outside brackets, so IPv6
+// addresses can be given as [::1].
+func splitForward(spec string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range spec {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, strings.Trim(spec[start:i], "[]"))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.Trim(spec[start:], "[]"))
+}
+
+// StartTunnel starts a forward over the named connection, or over the
+// current connection if connection is "". The tunnel stops when it is
+// stopped with StopTunnel or the connection closes.
+//
+// Tunnels over a named connection are started again when the connection is
+// reconnected, until they are stopped.
+func (m *Manager) StartTunnel(connection string, spec core.TunnelSpec) (*Tunnel, error) {
+	var client *ssh.Client
+	var conn *Connection
+	if connection == "" {
+		m.mu.RLock()
+		client = m.client
+		m.mu.RUnlock()
+		if client == nil {
+			return nil, errors.New("not connected")
+		}
+	} else {
+		var ok bool
+		if conn, ok = m.Connection(connection); !ok {
+			return nil, fmt.Errorf("connection not found: %s", connection)
+		}
+		var err error
+		if client, _, err = conn.current(); err != nil {
+			return nil, err
+		}
+	}
+
+	t, err := openTunnel(client, connection, spec)
+	if err != nil {
+		return nil, err
+	}
+	if conn != nil {
+		conn.mu.Lock()
+		conn.forwards = append(conn.forwards, t.spec)
+		conn.mu.Unlock()
+	}
+	m.registerTunnel(client, t)
+	return t, nil
+}
+
+// startHostTunnels starts the tunnels declared for host. Tunnels that
+// fail are registered with their error, so they show up next to the
+// others.
+func (m *Manager) startHostTunnels(client *ssh.Client, connection string, host core.SSHHost) {
+	m.startTunnels(client, connection, host.Tunnels)
+}
+
+// restartTunnels starts the tunnels of a reconnected connection over its
+// new client: those declared for its host and forwards, those started on it
+// before.
+func (m *Manager) restartTunnels(conn *Connection, client *ssh.Client, forwards []core.TunnelSpec) {
+	m.startHostTunnels(client, conn.name, conn.host)
+	m.startTunnels(client, conn.name, forwards)
+}
+
+// startTunnels starts forwarding specs over client, registering those that
+// fail with their error.
+func (m *Manager) startTunnels(client *ssh.Client, connection string, specs []core.TunnelSpec) {
+	for _, spec := range specs {
+		t, err := openTunnel(client, connection, spec)
+		if err != nil {
+			t = failedTunnel(connection, spec, err)
+		}
+		m.registerTunnel(client, t)
+	}
+}
+
+// registerTunnel adds t to the registry until it stops or client closes.
+func (m *Manager) registerTunnel(client *ssh.Client, t *Tunnel) {
+	m.mu.Lock()
+	m.nextTunnelID++
+	t.id = m.nextTunnelID
+	m.tunnels[t.id] = t
+	m.mu.Unlock()
+
+	closed := make(chan struct{})
+	go func() {
+		_ = client.Wait()
+		close(closed)
+	}()
+	go func() {
+		select {
+		case <-closed:
+			_ = t.Close()
+		case <-t.done:
+		}
+		m.mu.Lock()
+		delete(m.tunnels, t.id)
+		m.mu.Unlock()
+	}()
+}
+
+// Tunnels returns the tunnels in the registry, in the order they were
+// started.
+func (m *Manager) Tunnels() []*Tunnel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]*Tunnel, 0, len(m.tunnels))
+	for _, t := range m.tunnels {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+	return result
+}
+
+// StopTunnel stops the tunnel with the given ID.
+func (m *Manager) StopTunnel(id int) error {
+	m.mu.Lock()
+	t, ok := m.tunnels[id]
+	delete(m.tunnels, id)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("tunnel not found: %d", id)
+	}
+	if conn, ok := m.Connection(t.connection); ok {
+		conn.removeForward(t.spec)
+	}
+	return t.Close()
+}
@@ -0,0 +1,314 @@
+package ssh_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// newEchoServer starts a TCP server that echoes what it reads, and returns
+// its address.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// roundTrip sends message over conn and checks it is echoed back.
+func roundTrip(t *testing.T, conn net.Conn, message string) {
+	t.Helper()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(message)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	reply := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(reply) != message {
+		t.Errorf("expected echo %q, got %q", message, reply)
+	}
+}
+
+func TestLocalTunnel(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	echo := newEchoServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	openTestConnection(t, manager, server, "web")
+
+	tunnel, err := manager.StartTunnel("web", core.TunnelSpec{Type: core.TunnelLocal, Listen: "127.0.0.1:0", Target: echo})
+	if err != nil {
+		t.Fatalf("StartTunnel failed: %v", err)
+	}
+	if tunnels := manager.Tunnels(); len(tunnels) != 1 || tunnels[0] != tunnel || tunnel.Connection() != "web" {
+		t.Fatalf("expected the tunnel in the registry, got %v", tunnels)
+	}
+
+	conn, err := net.Dial("tcp", tunnel.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, conn, "ping")
+	if !waitFor(t, func() bool { return tunnel.ActiveConnections() == 1 }) {
+		t.Errorf("expected 1 active connection, got %d", tunnel.ActiveConnections())
+	}
+	conn.Close()
+
+	if tunnel.BytesSent() != 4 || tunnel.BytesReceived() != 4 {
+		t.Errorf("expected 4 bytes each way, got %d sent and %d received", tunnel.BytesSent(), tunnel.BytesReceived())
+	}
+	if !waitFor(t, func() bool { return tunnel.ActiveConnections() == 0 }) {
+		t.Errorf("expected no active connections, got %d", tunnel.ActiveConnections())
+	}
+
+	if err := manager.StopTunnel(tunnel.ID()); err != nil {
+		t.Fatalf("StopTunnel failed: %v", err)
+	}
+	if len(manager.Tunnels()) != 0 {
+		t.Error("expected the registry to be empty")
+	}
+	if _, err := net.Dial("tcp", tunnel.Addr()); err == nil {
+		t.Error("expected the tunnel to stop listening")
+	}
+	if err := manager.StopTunnel(tunnel.ID()); err == nil {
+		t.Error("expected an error stopping a stopped tunnel")
+	}
+
+	if _, err := manager.StartTunnel("missing", core.TunnelSpec{Type: core.TunnelLocal, Listen: "0", Target: echo}); err == nil {
+		t.Error("expected an error for an unknown connection")
+	}
+	if _, err := manager.StartTunnel("", core.TunnelSpec{Type: core.TunnelLocal, Listen: "0", Target: echo}); err == nil {
+		t.Error("expected an error without a current connection")
+	}
+}
+
+func TestRemoteTunnel(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	echo := newEchoServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	openTestConnection(t, manager, server, "web")
+
+	tunnel, err := manager.StartTunnel("web", core.TunnelSpec{Type: core.TunnelRemote, Listen: "127.0.0.1:0", Target: echo})
+	if err != nil {
+		t.Fatalf("StartTunnel failed: %v", err)
+	}
+	defer tunnel.Close()
+
+	// The test server listens on this machine, so its port is reachable
+	conn, err := net.Dial("tcp", tunnel.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "remote")
+	if tunnel.BytesSent() != 6 || tunnel.BytesReceived() != 6 {
+		t.Errorf("expected 6 bytes each way, got %d sent and %d received", tunnel.BytesSent(), tunnel.BytesReceived())
+	}
+}
+
+func TestDynamicTunnel(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	echo := newEchoServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+	openTestConnection(t, manager, server, "web")
+
+	tunnel, err := manager.StartTunnel("web", core.TunnelSpec{Type: core.TunnelDynamic, Listen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("StartTunnel failed: %v", err)
+	}
+	defer tunnel.Close()
+
+	host, portText, _ := net.SplitHostPort(echo)
+	portNum, _ := strconv.Atoi(portText)
+	var port [2]byte
+	binary.BigEndian.PutUint16(port[:], uint16(portNum))
+
+	conn, err := net.Dial("tcp", tunnel.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Greeting offering no authentication
+	if _, err := conn.Write([]byte{5, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil || !bytes.Equal(reply, []byte{5, 0}) {
+		t.Fatalf("unexpected greeting reply %v, %v", reply, err)
+	}
+
+	// CONNECT to the echo server by domain name
+	request := append([]byte{5, 1, 0, 3, byte(len(host))}, host...)
+	request = append(request, port[:]...)
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	reply = make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("unexpected connect reply %v, %v", reply, err)
+	}
+
+	roundTrip(t, conn, "socks")
+	if tunnel.BytesSent() != 5 || tunnel.BytesReceived() != 5 {
+		t.Errorf("expected only the 5 payload bytes counted, got %d sent and %d received", tunnel.BytesSent(), tunnel.BytesReceived())
+	}
+	if want := "127.0.0.1:" + portText; !strings.Contains(strings.Join(server.forwardedTargets(), " "), want) {
+		t.Errorf("expected the host to connect to %s, got %v", want, server.forwardedTargets())
+	}
+}
+
+func TestHostTunnelsStartOnConnect(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	echo := newEchoServer(t)
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+
+	host := core.SSHHost{
+		Host: "127.0.0.1", Port: server.port(), User: "tester",
+		Tunnels: []core.TunnelSpec{
+			{Type: core.TunnelLocal, Listen: "127.0.0.1:0", Target: echo},
+			{Type: core.TunnelLocal, Listen: busy.Addr().String(), Target: echo},
+		},
+	}
+	if _, err := manager.Open(context.Background(), "web", host, gossh.Password(testPassword)); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if !waitFor(t, func() bool { return len(manager.Tunnels()) == 2 }) {
+		t.Fatalf("expected 2 tunnels, got %d", len(manager.Tunnels()))
+	}
+	tunnels := manager.Tunnels()
+	if tunnels[0].Err() != nil && tunnels[1].Err() != nil || tunnels[0].Err() == nil && tunnels[1].Err() == nil {
+		t.Fatalf("expected exactly one tunnel to fail, got %v and %v", tunnels[0].Err(), tunnels[1].Err())
+	}
+
+	// Tunnels go away with their connection
+	if err := manager.CloseConnection("web"); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(t, func() bool { return len(manager.Tunnels()) == 0 }) {
+		t.Errorf("expected tunnels to stop with the connection, got %d", len(manager.Tunnels()))
+	}
+}
+
+func TestForwardLocalPort(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	echo := newEchoServer(t)
+	manager := ssh.NewManager("", 5*time.Second)
+	t.Cleanup(manager.CloseAll)
+
+	if err := manager.ConnectWithPassword(context.Background(), "127.0.0.1", server.port(), "tester", testPassword); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	host, echoPort, _ := net.SplitHostPort(echo)
+	remotePort, _ := strconv.Atoi(echoPort)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := manager.ForwardLocalPort(ctx, port, host, remotePort); err != nil {
+		t.Fatalf("ForwardLocalPort failed: %v", err)
+	}
+	tunnels := manager.Tunnels()
+	if len(tunnels) != 1 || tunnels[0].Connection() != "" {
+		t.Fatalf("expected a tunnel on the current connection, got %v", tunnels)
+	}
+
+	conn, err := net.Dial("tcp", tunnels[0].Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(t, conn, "legacy")
+	conn.Close()
+
+	cancel()
+	if !waitFor(t, func() bool { return len(manager.Tunnels()) == 0 }) {
+		t.Error("expected cancelling ctx to stop the tunnel")
+	}
+}
+
+func TestParseForward(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		flag, spec string
+		want       core.TunnelSpec
+		wantErr    bool
+	}{
+		{"L", "8080:db:5432", core.TunnelSpec{Type: core.TunnelLocal, Listen: "localhost:8080", Target: "db:5432"}, false},
+		{"-L", "0.0.0.0:8080:db:5432", core.TunnelSpec{Type: core.TunnelLocal, Listen: "0.0.0.0:8080", Target: "db:5432"}, false},
+		{"R", "[::1]:9000:localhost:3000", core.TunnelSpec{Type: core.TunnelRemote, Listen: "[::1]:9000", Target: "localhost:3000"}, false},
+		{"D", "1080", core.TunnelSpec{Type: core.TunnelDynamic, Listen: "localhost:1080"}, false},
+		{"D", "127.0.0.1:1080", core.TunnelSpec{Type: core.TunnelDynamic, Listen: "127.0.0.1:1080"}, false},
+		{"L", "8080", core.TunnelSpec{}, true},
+		{"D", "a:b:c", core.TunnelSpec{}, true},
+		{"X", "8080:db:5432", core.TunnelSpec{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ssh.ParseForward(tt.flag, tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseForward(%q, %q) error = %v", tt.flag, tt.spec, err)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseForward(%q, %q) = %+v, want %+v", tt.flag, tt.spec, got, tt.want)
+		}
+	}
+}
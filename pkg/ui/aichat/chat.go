@@ -3,6 +3,9 @@ package aichat
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,9 +21,12 @@ import (
 	"github.com/cbwinslow/cbwsh/pkg/ui/markdown"
 )
 
+// toolOutputLines is how many lines of a tool's output the chat shows.
+const toolOutputLines = 5
+
 // Message represents a chat message.
 type Message struct {
-	Role      string // "user" or "assistant"
+	Role      string // "user", "assistant" or "tool"
 	Content   string
 	Timestamp time.Time
 }
@@ -45,10 +51,17 @@ type ChatPane struct {
 
 	// AI
 	aiManager *ai.Manager
+	tools     *ai.ToolRegistry
+
+	// Running agent state
+	cancel   context.CancelFunc  // Stops the running request
+	events   chan tea.Msg        // Steps of the running request
+	approval *approvalRequestMsg // Tool call waiting for the user
 
 	// Styles
 	userStyle      lipgloss.Style
 	assistantStyle lipgloss.Style
+	toolStyle      lipgloss.Style
 	borderStyle    lipgloss.Style
 	titleStyle     lipgloss.Style
 }
@@ -61,6 +74,10 @@ type KeyMap struct {
 	Resize     key.Binding
 	ScrollUp   key.Binding
 	ScrollDown key.Binding
+	Cancel     key.Binding
+	Approve    key.Binding
+	Deny       key.Binding
+	Insert     key.Binding
 }
 
 // DefaultKeyMap returns the default key bindings.
@@ -75,7 +92,7 @@ func DefaultKeyMap() KeyMap {
 			key.WithHelp("ctrl+l", "clear chat"),
 		),
 		Escape: key.NewBinding(
-			key.WithKeys("escape"),
+			key.WithKeys("esc"),
 			key.WithHelp("esc", "unfocus"),
 		),
 		Resize: key.NewBinding(
@@ -90,6 +107,22 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("ctrl+down", "pagedown"),
 			key.WithHelp("pgdn", "scroll down"),
 		),
+		Cancel: key.NewBinding(
+			key.WithKeys("ctrl+c"),
+			key.WithHelp("ctrl+c", "cancel request"),
+		),
+		Approve: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "allow tool call"),
+		),
+		Deny: key.NewBinding(
+			key.WithKeys("n", "esc"),
+			key.WithHelp("n", "deny tool call"),
+		),
+		Insert: key.NewBinding(
+			key.WithKeys("ctrl+e"),
+			key.WithHelp("ctrl+e", "use suggested command"),
+		),
 	}
 }
 
@@ -122,6 +155,8 @@ func NewChatPane(aiManager *ai.Manager) *ChatPane {
 			Bold(true),
 		assistantStyle: lipgloss.NewStyle().
 			Foreground(lipgloss.Color("141")),
+		toolStyle: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("245")),
 		borderStyle: lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
 			BorderForeground(lipgloss.Color("62")),
@@ -257,6 +292,8 @@ func (c *ChatPane) updateViewportContent() {
 		if msg.Role == "user" {
 			content.WriteString(c.userStyle.Render("You: "))
 			content.WriteString(msg.Content)
+		} else if msg.Role == "tool" {
+			content.WriteString(c.toolStyle.Render(msg.Content))
 		} else {
 			content.WriteString(c.assistantStyle.Render("AI: "))
 			// Render markdown for assistant messages
@@ -279,21 +316,30 @@ func (c *ChatPane) updateViewportContent() {
 }
 
 // SendMessage sends a message to the AI and adds the response.
+//
+// With tools set, the AI may call them before answering; each call and its
+// result are shown as they happen, and calls that need approval wait for
+// the user to press y or n.
 func (c *ChatPane) SendMessage(ctx context.Context) tea.Cmd {
 	c.mu.Lock()
 	message := strings.TrimSpace(c.textarea.Value())
-	if message == "" {
+	if message == "" || c.loading {
 		c.mu.Unlock()
 		return nil
 	}
 
 	c.textarea.Reset()
 	c.loading = true
+	tools := c.tools
 	c.mu.Unlock()
 
 	// Add user message
 	c.AddMessage("user", message)
 
+	if tools != nil && c.aiManager != nil {
+		return c.runAgent(ctx, message, tools)
+	}
+
 	return func() tea.Msg {
 		var response string
 		var err error
@@ -312,25 +358,238 @@ func (c *ChatPane) SendMessage(ctx context.Context) tea.Cmd {
 	}
 }
 
+// runAgent runs the agent loop in the background, delivering its steps,
+// approval requests and final answer as messages on c.events.
+func (c *ChatPane) runAgent(ctx context.Context, message string, tools *ai.ToolRegistry) tea.Cmd {
+	ctx, cancel := context.WithCancel(ctx)
+	events := make(chan tea.Msg)
+
+	c.mu.Lock()
+	c.cancel = cancel
+	c.events = events
+	c.mu.Unlock()
+
+	send := func(msg tea.Msg) {
+		select {
+		case events <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	approve := func(ctx context.Context, call ai.ToolCall) bool {
+		reply := make(chan bool, 1)
+		send(approvalRequestMsg{call: call, reply: reply})
+		select {
+		case ok := <-reply:
+			return ok
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	onStep := func(step ai.Step) {
+		// The answer arrives as aiResponseMsg
+		if step.Kind != ai.StepAnswer {
+			send(toolStepMsg{step: step})
+		}
+	}
+
+	go func() {
+		defer cancel()
+		response, err := c.aiManager.RunWithTools(ctx, message, tools, approve, onStep)
+		switch {
+		case errors.Is(err, context.Canceled):
+			response = "Cancelled."
+		case err != nil:
+			response = "Error: " + err.Error()
+		}
+		// The pane waits for this even after cancelling
+		events <- aiResponseMsg{response: response}
+	}()
+
+	return waitForEvent(events)
+}
+
+// waitForEvent delivers the next message of a running request.
+func waitForEvent(events <-chan tea.Msg) tea.Cmd {
+	if events == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return <-events
+	}
+}
+
 type aiResponseMsg struct {
 	response string
 }
 
+// InsertCommandMsg asks for Command, suggested by the AI, to be put at the
+// shell prompt for the user to review and run.
+type InsertCommandMsg struct {
+	Command string
+}
+
+// suggestedCommand returns the first line of the last code block in
+// content, without a "$ " prompt, or "" if there is none.
+func suggestedCommand(content string) string {
+	blocks := strings.Split(content, "```")
+	// Code blocks are the odd pieces between fences
+	for i := len(blocks) - 2; i >= 1; i -= 2 {
+		lines := strings.Split(blocks[i], "\n")
+		// The first line holds the language, if any
+		for _, line := range lines[1:] {
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "$ "))
+			if line != "" && !strings.HasPrefix(line, "#") {
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// insertCommand returns a command delivering the latest answer's
+// suggested command, if any.
+func (c *ChatPane) insertCommand() tea.Cmd {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if c.messages[i].Role != "assistant" {
+			continue
+		}
+		command := suggestedCommand(c.messages[i].Content)
+		if command == "" {
+			return nil
+		}
+		return func() tea.Msg {
+			return InsertCommandMsg{Command: command}
+		}
+	}
+	return nil
+}
+
+// toolStepMsg reports a tool call or its result.
+type toolStepMsg struct {
+	step ai.Step
+}
+
+// approvalRequestMsg asks the user to allow a tool call.
+type approvalRequestMsg struct {
+	call  ai.ToolCall
+	reply chan<- bool
+}
+
+// formatToolCall renders a call as "name key=value ...".
+func formatToolCall(call ai.ToolCall) string {
+	names := make([]string, 0, len(call.Args))
+	for name := range call.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(call.Name)
+	for _, name := range names {
+		fmt.Fprintf(&b, " %s=%q", name, call.Args[name])
+	}
+	return b.String()
+}
+
+// formatToolStep renders a step for the chat.
+func formatToolStep(step ai.Step) string {
+	if step.Kind == ai.StepToolCall {
+		return "⚙ " + formatToolCall(step.Call)
+	}
+
+	switch {
+	case step.Denied:
+		return "  ✗ denied"
+	case step.Err != nil:
+		return "  ✗ " + step.Err.Error()
+	}
+
+	lines := strings.Split(strings.TrimRight(step.Text, "\n"), "\n")
+	more := len(lines) - toolOutputLines
+	if more > 0 {
+		lines = lines[:toolOutputLines]
+	}
+	for i, line := range lines {
+		lines[i] = "  │ " + line
+	}
+	if more > 0 {
+		lines = append(lines, fmt.Sprintf("  │ … %d more lines", more))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SetTools sets the tools the AI may call. Without tools, messages are
+// sent as plain queries.
+func (c *ChatPane) SetTools(tools *ai.ToolRegistry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tools = tools
+}
+
+// answerApproval replies to the pending approval request and resumes
+// waiting for the request's events.
+func (c *ChatPane) answerApproval(ok bool) tea.Cmd {
+	c.mu.Lock()
+	request := c.approval
+	c.approval = nil
+	events := c.events
+	c.mu.Unlock()
+
+	if request == nil {
+		return nil
+	}
+	request.reply <- ok
+	return waitForEvent(events)
+}
+
 // Update handles messages for the chat pane.
 func (c *ChatPane) Update(msg tea.Msg) (*ChatPane, tea.Cmd) {
-	c.mu.Lock()
-	if !c.visible {
+	var cmds []tea.Cmd
+
+	// Messages of a running request are handled even while hidden
+	switch msg := msg.(type) {
+	case aiResponseMsg:
+		c.mu.Lock()
+		c.loading = false
+		c.cancel = nil
+		c.events = nil
+		c.approval = nil
 		c.mu.Unlock()
+		c.AddMessage("assistant", msg.response)
+		return c, nil
+
+	case toolStepMsg:
+		c.AddMessage("tool", formatToolStep(msg.step))
+		c.mu.RLock()
+		events := c.events
+		c.mu.RUnlock()
+		return c, waitForEvent(events)
+
+	case approvalRequestMsg:
+		c.mu.Lock()
+		c.approval = &msg
+		c.mu.Unlock()
+		c.AddMessage("tool", "? Allow "+formatToolCall(msg.call)+"? (y/n)")
 		return c, nil
 	}
-	c.mu.Unlock()
 
-	var cmds []tea.Cmd
+	c.mu.RLock()
+	visible := c.visible
+	c.mu.RUnlock()
+	if !visible {
+		return c, nil
+	}
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		c.mu.RLock()
 		focused := c.focused
+		pending := c.approval != nil
+		cancel := c.cancel
 		c.mu.RUnlock()
 
 		if !focused {
@@ -338,9 +597,30 @@ func (c *ChatPane) Update(msg tea.Msg) (*ChatPane, tea.Cmd) {
 		}
 
 		switch {
+		case key.Matches(msg, keys.Cancel) && cancel != nil:
+			cancel()
+			// A pending approval no longer holds up the request
+			if pending {
+				return c, c.answerApproval(false)
+			}
+			return c, nil
+
+		case pending && key.Matches(msg, keys.Approve):
+			return c, c.answerApproval(true)
+
+		case pending && key.Matches(msg, keys.Deny):
+			return c, c.answerApproval(false)
+
+		case pending:
+			// Other keys wait until the call is answered
+			return c, nil
+
 		case key.Matches(msg, keys.Send):
 			return c, c.SendMessage(context.Background())
 
+		case key.Matches(msg, keys.Insert):
+			return c, c.insertCommand()
+
 		case key.Matches(msg, keys.Clear):
 			c.ClearMessages()
 			return c, nil
@@ -366,13 +646,6 @@ func (c *ChatPane) Update(msg tea.Msg) (*ChatPane, tea.Cmd) {
 			return c, nil
 		}
 
-	case aiResponseMsg:
-		c.mu.Lock()
-		c.loading = false
-		c.mu.Unlock()
-		c.AddMessage("assistant", msg.response)
-		return c, nil
-
 	case tea.WindowSizeMsg:
 		c.SetSize(c.GetWidth(msg.Width), msg.Height-4)
 		return c, nil
@@ -407,8 +680,11 @@ func (c *ChatPane) View() string {
 
 	// Title
 	title := c.titleStyle.Render("🤖 AI Assistant")
-	if c.loading {
-		title += " (thinking...)"
+	switch {
+	case c.approval != nil:
+		title += " (allow tool call? y/n)"
+	case c.loading:
+		title += " (thinking... ctrl+c to cancel)"
 	}
 	content.WriteString(title)
 	content.WriteString("\n")
@@ -1,6 +1,7 @@
 package aichat
 
 import (
+	"strings"
 	"testing"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -238,3 +239,81 @@ func TestDefaultChatKeyMap(t *testing.T) {
 		// Just checking initialization
 	}
 }
+
+func TestChatPaneToolApproval(t *testing.T) {
+	t.Parallel()
+
+	pane := NewChatPane(&ai.Manager{})
+	pane.Show()
+	pane.Focus()
+
+	call := ai.ToolCall{Name: "run_command", Args: map[string]string{"command": "ls"}}
+	pane.Update(toolStepMsg{step: ai.Step{Kind: ai.StepToolCall, Call: call}})
+
+	reply := make(chan bool, 1)
+	pane.Update(approvalRequestMsg{call: call, reply: reply})
+	if pane.approval == nil {
+		t.Fatal("expected a pending approval")
+	}
+
+	// Typing does not reach the input while a call waits
+	pane.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	if pane.textarea.Value() != "" {
+		t.Errorf("input = %q, want empty", pane.textarea.Value())
+	}
+
+	pane.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("y")})
+	if ok := <-reply; !ok {
+		t.Error("expected the call to be approved")
+	}
+	if pane.approval != nil {
+		t.Error("approval should be cleared after answering")
+	}
+
+	pane.Update(toolStepMsg{step: ai.Step{Kind: ai.StepToolResult, Call: call, Text: "a\nb\n"}})
+	pane.Update(aiResponseMsg{response: "Two files."})
+
+	want := []string{
+		`⚙ run_command command="ls"`,
+		`? Allow run_command command="ls"? (y/n)`,
+		"  │ a\n  │ b",
+		"Two files.",
+	}
+	if len(pane.messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(pane.messages), len(want))
+	}
+	for i, content := range want {
+		if pane.messages[i].Content != content {
+			t.Errorf("message %d = %q, want %q", i, pane.messages[i].Content, content)
+		}
+	}
+}
+
+func TestFormatToolStepTruncates(t *testing.T) {
+	t.Parallel()
+
+	out := formatToolStep(ai.Step{Kind: ai.StepToolResult, Text: "1\n2\n3\n4\n5\n6\n7\n"})
+	if !strings.HasSuffix(out, "… 2 more lines") {
+		t.Errorf("formatToolStep() = %q", out)
+	}
+}
+
+func TestSuggestedCommand(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"Use:\n```bash\nfind . -size +100M\n```\n", "find . -size +100M"},
+		{"First ```sh\nls\n``` then\n```\n# check\n$ du -sh .\n```", "du -sh ."},
+		{"No code here.", ""},
+		{"Inline `ls` only.", ""},
+	}
+
+	for _, tt := range tests {
+		if got := suggestedCommand(tt.content); got != tt.want {
+			t.Errorf("suggestedCommand(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,632 @@
+package autocomplete
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+// Generator limits.
+const (
+	// generatorTimeout bounds how long a generator command may run.
+	generatorTimeout = 2 * time.Second
+	// generatorCacheTTL is how long generator output is reused.
+	generatorCacheTTL = 5 * time.Second
+)
+
+//go:embed specs/*.yaml
+var builtinSpecFS embed.FS
+
+// ArgType selects how an argument without fixed suggestions is completed.
+type ArgType string
+
+const (
+	// ArgTypeAny is an argument with no completion beyond its suggestions
+	// and generator.
+	ArgTypeAny ArgType = ""
+	// ArgTypeFile completes file and directory names.
+	ArgTypeFile ArgType = "file"
+	// ArgTypeDirectory completes directory names.
+	ArgTypeDirectory ArgType = "directory"
+)
+
+// Spec describes the command line of a CLI for argument-aware completion.
+//
+// Specs are written in YAML:
+//
+//	name: git
+//	description: Distributed version control
+//	generators:
+//	  branches: git for-each-ref --format='%(refname:short)' refs/heads
+//	subcommands:
+//	  - name: checkout
+//	    options:
+//	      - names: [-b]
+//	        arg: {name: new-branch}
+//	    args:
+//	      - name: branch
+//	        generator: branches
+//
+// A generator is a shell command run in the pane's working directory whose
+// output lines are offered as suggestions; a tab on a line separates the
+// suggestion from its description. Positional arguments already given to
+// the current subcommand are passed to it as $1, $2 and so on.
+type Spec struct {
+	Command `yaml:",inline"`
+	// Generators maps generator names to shell commands.
+	Generators map[string]string `yaml:"generators"`
+}
+
+// Command is a command or subcommand in a spec.
+type Command struct {
+	// Name is the command or subcommand name.
+	Name string `yaml:"name"`
+	// Aliases are alternative names for a subcommand.
+	Aliases []string `yaml:"aliases"`
+	// Description is shown next to the suggestion.
+	Description string `yaml:"description"`
+	// Subcommands are the subcommands accepted as the first argument.
+	Subcommands []*Command `yaml:"subcommands"`
+	// Options are the flags accepted by the command.
+	Options []*Option `yaml:"options"`
+	// Args are the positional arguments, in order.
+	Args []*Arg `yaml:"args"`
+}
+
+// Option is a flag accepted by a command.
+type Option struct {
+	// Names are the spellings of the flag, such as -v and --verbose.
+	Names []string `yaml:"names"`
+	// Description is shown next to the suggestion.
+	Description string `yaml:"description"`
+	// Arg is the flag's argument, if it takes one.
+	Arg *Arg `yaml:"arg"`
+	// Persistent makes the flag valid for all subcommands as well.
+	Persistent bool `yaml:"persistent"`
+}
+
+// Arg is a positional argument or flag argument.
+type Arg struct {
+	// Name describes the argument.
+	Name string `yaml:"name"`
+	// Type selects file or directory completion.
+	Type ArgType `yaml:"type"`
+	// Suggestions are fixed values offered for the argument.
+	Suggestions []string `yaml:"suggestions"`
+	// Generator names a generator of the spec that produces values.
+	Generator string `yaml:"generator"`
+	// Script is an inline generator command.
+	Script string `yaml:"script"`
+	// Variadic lets the argument repeat; it must be the last one.
+	Variadic bool `yaml:"variadic"`
+}
+
+// ParseSpec parses a spec from YAML.
+func ParseSpec(data []byte) (*Spec, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	if spec.Name == "" {
+		return nil, fmt.Errorf("spec has no name")
+	}
+	return &spec, nil
+}
+
+// LoadSpecs loads every .yaml and .yml spec in dir. A missing directory
+// yields no specs.
+func LoadSpecs(dir string) ([]*Spec, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var specs []*Spec
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		spec, err := ParseSpec(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// BuiltinSpecs returns the specs shipped with cbwsh: git, docker, kubectl,
+// go, make and ssh.
+func BuiltinSpecs() []*Spec {
+	entries, _ := builtinSpecFS.ReadDir("specs")
+	specs := make([]*Spec, 0, len(entries))
+	for _, entry := range entries {
+		data, err := builtinSpecFS.ReadFile("specs/" + entry.Name())
+		if err != nil {
+			continue
+		}
+		if spec, err := ParseSpec(data); err == nil {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// GeneratorFunc produces the values of a generator in Go instead of a
+// shell command. args are the positional arguments already given.
+type GeneratorFunc func(args []string) []core.Suggestion
+
+// SpecProvider completes subcommands, flags and arguments of commands
+// that have a spec.
+type SpecProvider struct {
+	mu    sync.RWMutex
+	specs map[string]*Spec
+	funcs map[string]GeneratorFunc
+	dir   string
+	cache map[string]generatorResult
+}
+
+type generatorResult struct {
+	suggestions []core.Suggestion
+	expires     time.Time
+}
+
+// NewSpecProvider creates a provider for the given specs.
+func NewSpecProvider(specs ...*Spec) *SpecProvider {
+	p := &SpecProvider{
+		specs: make(map[string]*Spec),
+		funcs: make(map[string]GeneratorFunc),
+		cache: make(map[string]generatorResult),
+	}
+	p.AddSpecs(specs...)
+	return p
+}
+
+// Name returns the provider name.
+func (p *SpecProvider) Name() string {
+	return "specs"
+}
+
+// AddSpecs adds specs, replacing any existing spec for the same command.
+func (p *SpecProvider) AddSpecs(specs ...*Spec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, spec := range specs {
+		p.specs[spec.Name] = spec
+	}
+}
+
+// LoadDir adds the specs in dir, which override built-in specs.
+func (p *SpecProvider) LoadDir(dir string) error {
+	specs, err := LoadSpecs(dir)
+	if err != nil {
+		return err
+	}
+	p.AddSpecs(specs...)
+	return nil
+}
+
+// SetGeneratorFunc makes the named generator of command's spec call fn
+// instead of running its shell command.
+func (p *SpecProvider) SetGeneratorFunc(command, generator string, fn GeneratorFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.funcs[command+"\x00"+generator] = fn
+}
+
+// SetWorkingDirectory sets the directory generators run in and file
+// arguments are completed from.
+func (p *SpecProvider) SetWorkingDirectory(dir string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dir = dir
+}
+
+// Provide returns suggestions for the word at the cursor based on the
+// spec of the command being typed.
+func (p *SpecProvider) Provide(input string, cursorPos int) ([]core.Suggestion, error) {
+	if cursorPos > len(input) {
+		cursorPos = len(input)
+	}
+	words, current := splitCommandLine(input[:cursorPos])
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	p.mu.RLock()
+	spec, ok := p.specs[words[0]]
+	dir := p.dir
+	p.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	state := walkSpec(spec, words[1:])
+
+	var suggestions []core.Suggestion
+	switch {
+	case state.pending != nil:
+		suggestions = p.argSuggestions(spec, state.pending, state.positional, dir, current)
+
+	case strings.HasPrefix(current, "-") && !state.optionsDone:
+		if name, value, found := strings.Cut(current, "="); found {
+			if opt := state.findOption(name); opt != nil && opt.Arg != nil {
+				for _, s := range p.argSuggestions(spec, opt.Arg, state.positional, dir, value) {
+					s.Text = name + "=" + s.Text
+					suggestions = append(suggestions, s)
+				}
+			}
+			break
+		}
+		suggestions = state.optionSuggestions(current)
+
+	default:
+		if len(state.positional) == 0 {
+			for _, sub := range state.command.Subcommands {
+				for _, name := range append([]string{sub.Name}, sub.Aliases...) {
+					if strings.HasPrefix(name, current) {
+						suggestions = append(suggestions, core.Suggestion{
+							Text:        name,
+							Description: sub.Description,
+							Category:    "subcommand",
+						})
+					}
+				}
+			}
+		}
+		if arg := state.nextArg(); arg != nil {
+			suggestions = append(suggestions, p.argSuggestions(spec, arg, state.positional, dir, current)...)
+		}
+	}
+
+	return suggestions, nil
+}
+
+// specState is the position in a spec reached by the words typed so far.
+type specState struct {
+	command     *Command
+	options     []*Option // Options valid at this point
+	positional  []string  // Positional arguments of command
+	pending     *Arg      // Argument of the preceding flag
+	optionsDone bool      // Set after --
+}
+
+// walkSpec follows words through spec's subcommands and flags.
+func walkSpec(spec *Spec, words []string) *specState {
+	state := &specState{command: &spec.Command}
+	state.options = append(state.options, spec.Options...)
+
+	for _, word := range words {
+		if state.pending != nil {
+			state.pending = nil
+			continue
+		}
+		if word == "--" && !state.optionsDone {
+			state.optionsDone = true
+			continue
+		}
+		if strings.HasPrefix(word, "-") && word != "-" && !state.optionsDone {
+			if _, _, found := strings.Cut(word, "="); found {
+				continue
+			}
+			if opt := state.findOption(word); opt != nil && opt.Arg != nil {
+				state.pending = opt.Arg
+			}
+			continue
+		}
+		if len(state.positional) == 0 {
+			if sub := state.command.findSubcommand(word); sub != nil {
+				state.enter(sub)
+				continue
+			}
+		}
+		state.positional = append(state.positional, word)
+	}
+	return state
+}
+
+// enter descends into a subcommand, keeping persistent flags of its parents.
+func (s *specState) enter(sub *Command) {
+	var options []*Option
+	for _, opt := range s.options {
+		if opt.Persistent {
+			options = append(options, opt)
+		}
+	}
+	s.options = append(options, sub.Options...)
+	s.command = sub
+}
+
+func (s *specState) findOption(name string) *Option {
+	for _, opt := range s.options {
+		for _, n := range opt.Names {
+			if n == name {
+				return opt
+			}
+		}
+	}
+	return nil
+}
+
+// nextArg returns the spec of the next positional argument.
+func (s *specState) nextArg() *Arg {
+	args := s.command.Args
+	if len(args) == 0 {
+		return nil
+	}
+	if n := len(s.positional); n < len(args) {
+		return args[n]
+	}
+	if last := args[len(args)-1]; last.Variadic {
+		return last
+	}
+	return nil
+}
+
+func (s *specState) optionSuggestions(prefix string) []core.Suggestion {
+	var suggestions []core.Suggestion
+	for _, opt := range s.options {
+		for _, name := range opt.Names {
+			if strings.HasPrefix(name, prefix) {
+				suggestions = append(suggestions, core.Suggestion{
+					Text:        name,
+					Description: opt.Description,
+					Category:    "option",
+				})
+			}
+		}
+	}
+	return suggestions
+}
+
+func (c *Command) findSubcommand(name string) *Command {
+	for _, sub := range c.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+		for _, alias := range sub.Aliases {
+			if alias == name {
+				return sub
+			}
+		}
+	}
+	return nil
+}
+
+// argSuggestions returns the values for arg that start with prefix.
+func (p *SpecProvider) argSuggestions(spec *Spec, arg *Arg, positional []string, dir, prefix string) []core.Suggestion {
+	var suggestions []core.Suggestion
+	for _, value := range arg.Suggestions {
+		if strings.HasPrefix(value, prefix) {
+			suggestions = append(suggestions, core.Suggestion{
+				Text:        value,
+				Description: arg.Name,
+				Category:    "argument",
+			})
+		}
+	}
+
+	p.mu.RLock()
+	fn := p.funcs[spec.Name+"\x00"+arg.Generator]
+	p.mu.RUnlock()
+
+	script := arg.Script
+	if script == "" && arg.Generator != "" {
+		script = spec.Generators[arg.Generator]
+	}
+	if fn != nil && arg.Script == "" && arg.Generator != "" {
+		for _, s := range fn(positional) {
+			if strings.HasPrefix(s.Text, prefix) {
+				suggestions = append(suggestions, s)
+			}
+		}
+	} else if script != "" {
+		for _, s := range p.generate(script, positional, dir) {
+			if strings.HasPrefix(s.Text, prefix) {
+				suggestions = append(suggestions, s)
+			}
+		}
+	}
+
+	switch arg.Type {
+	case ArgTypeFile:
+		suggestions = append(suggestions, listPaths(dir, prefix, false)...)
+	case ArgTypeDirectory:
+		suggestions = append(suggestions, listPaths(dir, prefix, true)...)
+	}
+
+	return suggestions
+}
+
+// generate runs a generator command, reusing recent output.
+func (p *SpecProvider) generate(script string, args []string, dir string) []core.Suggestion {
+	key := dir + "\x00" + script + "\x00" + strings.Join(args, "\x00")
+
+	p.mu.RLock()
+	cached, ok := p.cache[key]
+	p.mu.RUnlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.suggestions
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), generatorTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", append([]string{"-c", script, "sh"}, args...)...)
+	cmd.Dir = dir
+	// Kill the generator's whole process group on timeout, and don't wait
+	// on children left holding its output
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	out, _ := cmd.Output()
+
+	var suggestions []core.Suggestion
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		text, desc, _ := strings.Cut(strings.TrimRight(line, "\r"), "\t")
+		text = strings.TrimSpace(text)
+		if text == "" || seen[text] {
+			continue
+		}
+		seen[text] = true
+		suggestions = append(suggestions, core.Suggestion{
+			Text:        text,
+			Description: desc,
+			Category:    "argument",
+		})
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	for k, cached := range p.cache {
+		if !now.Before(cached.expires) {
+			delete(p.cache, k)
+		}
+	}
+	p.cache[key] = generatorResult{suggestions: suggestions, expires: now.Add(generatorCacheTTL)}
+	p.mu.Unlock()
+
+	return suggestions
+}
+
+// listPaths returns the files, or only directories, matching a partial
+// path relative to dir.
+func listPaths(dir, prefix string, dirsOnly bool) []core.Suggestion {
+	base := dir
+	path := prefix
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			base, path = home, path[2:]
+		}
+	}
+
+	parent, partial := filepath.Split(path)
+	search := parent
+	if !filepath.IsAbs(search) {
+		search = filepath.Join(base, parent)
+	}
+
+	entries, err := os.ReadDir(search)
+	if err != nil {
+		return nil
+	}
+
+	var suggestions []core.Suggestion
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, partial) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(partial, ".")) {
+			continue
+		}
+		isDir := entry.IsDir()
+		if dirsOnly && !isDir {
+			continue
+		}
+
+		text := strings.TrimSuffix(prefix, partial) + name
+		description := "File"
+		if isDir {
+			text += "/"
+			description = "Directory"
+		}
+		suggestions = append(suggestions, core.Suggestion{
+			Text:        text,
+			Description: description,
+			Category:    "file",
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		return suggestions[i].Text < suggestions[j].Text
+	})
+	return suggestions
+}
+
+// splitCommandLine splits the last simple command of a partial command
+// line into its completed words and the word being typed. Quotes are
+// removed from the words.
+func splitCommandLine(line string) ([]string, string) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '|' || c == ';' || c == '&' || c == '(':
+			// A new command starts after a pipe or list operator
+			words = words[:0]
+			word.Reset()
+			inWord = false
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		return words, word.String()
+	}
+	return words, ""
+}
+
+// ReplaceWord replaces the word at the cursor with text, returning the new
+// input and the cursor position after the inserted text.
+func ReplaceWord(input string, cursorPos int, text string) (string, int) {
+	if cursorPos > len(input) {
+		cursorPos = len(input)
+	}
+
+	start := cursorPos
+	for start > 0 && !isDelimiter(input[start-1]) {
+		start--
+	}
+	end := cursorPos
+	for end < len(input) && !isDelimiter(input[end]) {
+		end++
+	}
+
+	return input[:start] + text + input[end:], start + len(text)
+}
@@ -0,0 +1,343 @@
+package autocomplete
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+)
+
+const testSpec = `
+name: tool
+description: A test tool
+generators:
+  items: printf 'alpha\tfirst\nbeta\tsecond\n'
+  echo-arg: echo "item-$1"
+options:
+  - names: [-C]
+    description: Directory
+    arg: {name: dir, type: directory}
+    persistent: true
+  - names: [--verbose, -v]
+    description: Verbose
+subcommands:
+  - name: get
+    aliases: [g]
+    description: Get items
+    options:
+      - names: [--output, -o]
+        description: Output format
+        arg: {name: format, suggestions: [json, yaml]}
+    args:
+      - name: item
+        generator: items
+      - name: detail
+        generator: echo-arg
+  - name: go
+    description: Go somewhere
+`
+
+func parseTestSpec(t *testing.T) *Spec {
+	t.Helper()
+	spec, err := ParseSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatalf("ParseSpec failed: %v", err)
+	}
+	return spec
+}
+
+func suggestionTexts(suggestions []core.Suggestion) []string {
+	texts := make([]string, 0, len(suggestions))
+	for _, s := range suggestions {
+		texts = append(texts, s.Text)
+	}
+	sort.Strings(texts)
+	return texts
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSpecProviderProvide(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSpecProvider(parseTestSpec(t))
+
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"tool ", []string{"g", "get", "go"}},
+		{"tool g", []string{"g", "get", "go"}},
+		{"tool ge", []string{"get"}},
+		{"tool --v", []string{"--verbose"}},
+		{"tool get -", []string{"--output", "-C", "-o"}},
+		{"tool get -o ", []string{"json", "yaml"}},
+		{"tool get --output=y", []string{"--output=yaml"}},
+		{"tool get ", []string{"alpha", "beta"}},
+		{"tool g b", []string{"beta"}},
+		{"tool -v get -o json a", []string{"alpha"}},
+		{"tool get alpha ", []string{"item-alpha"}},
+		{"tool get alpha x ", nil},
+		{"tool go ", nil},
+		{"ls | tool ge", []string{"get"}},
+		{"other ", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		suggestions, err := provider.Provide(tt.input, len(tt.input))
+		if err != nil {
+			t.Errorf("Provide(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if got := suggestionTexts(suggestions); !equalStrings(got, tt.want) {
+			t.Errorf("Provide(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSpecProviderGeneratorDescription(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSpecProvider(parseTestSpec(t))
+	suggestions, _ := provider.Provide("tool get al", 11)
+	if len(suggestions) != 1 {
+		t.Fatalf("expected 1 suggestion, got %d", len(suggestions))
+	}
+	if suggestions[0].Description != "first" || suggestions[0].Category != "argument" {
+		t.Errorf("unexpected suggestion %+v", suggestions[0])
+	}
+}
+
+func TestSpecProviderGeneratorFunc(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSpecProvider(parseTestSpec(t))
+	var gotArgs []string
+	provider.SetGeneratorFunc("tool", "echo-arg", func(args []string) []core.Suggestion {
+		gotArgs = args
+		return []core.Suggestion{{Text: "from-go"}, {Text: "other"}}
+	})
+
+	suggestions, _ := provider.Provide("tool get alpha fr", 17)
+	if got := suggestionTexts(suggestions); !equalStrings(got, []string{"from-go"}) {
+		t.Errorf("expected the Go generator to be used, got %q", got)
+	}
+	if !equalStrings(gotArgs, []string{"alpha"}) {
+		t.Errorf("expected positional args [alpha], got %q", gotArgs)
+	}
+
+	// Other generators still run their shell command
+	suggestions, _ = provider.Provide("tool get al", 11)
+	if got := suggestionTexts(suggestions); !equalStrings(got, []string{"alpha"}) {
+		t.Errorf("expected the shell generator, got %q", got)
+	}
+}
+
+func TestSpecProviderGeneratorTimeout(t *testing.T) {
+	t.Parallel()
+
+	provider := NewSpecProvider()
+	provider.cache["stale"] = generatorResult{expires: time.Now().Add(-time.Second)}
+
+	// A child left running past the timeout is killed with the generator
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	start := time.Now()
+	provider.generate(`sleep 30 & echo $! > "$1"; wait`, []string{pidFile}, "")
+	if elapsed := time.Since(start); elapsed > generatorTimeout+5*time.Second {
+		t.Errorf("generator took %v to give up", elapsed)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the generator's child %d to be killed", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := provider.cache["stale"]; ok {
+		t.Error("expected expired generator output to be pruned")
+	}
+}
+
+// processRunning reports whether pid is a live, unreaped process.
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return syscall.Kill(pid, 0) == nil
+	}
+	return !strings.Contains(string(stat), ") Z ")
+}
+
+func TestSpecProviderDirectoryArg(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "setup.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewSpecProvider(parseTestSpec(t))
+	provider.SetWorkingDirectory(dir)
+
+	suggestions, _ := provider.Provide("tool get -C s", 13)
+	if got := suggestionTexts(suggestions); !equalStrings(got, []string{"src/"}) {
+		t.Errorf("expected only directories, got %q", got)
+	}
+}
+
+func TestBuiltinSpecs(t *testing.T) {
+	t.Parallel()
+
+	names := make(map[string]bool)
+	for _, spec := range BuiltinSpecs() {
+		names[spec.Name] = true
+		for _, cmd := range append([]*Command{&spec.Command}, spec.Subcommands...) {
+			for _, arg := range cmd.Args {
+				if arg.Generator != "" && spec.Generators[arg.Generator] == "" {
+					t.Errorf("%s %s: unknown generator %q", spec.Name, cmd.Name, arg.Generator)
+				}
+			}
+		}
+	}
+	for _, name := range []string{"git", "docker", "kubectl", "go", "make", "ssh"} {
+		if !names[name] {
+			t.Errorf("expected built-in spec for %s", name)
+		}
+	}
+}
+
+func TestBuiltinMakeTargets(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("sed"); err != nil {
+		t.Skip("sed not available")
+	}
+
+	dir := t.TempDir()
+	makefile := "VAR := 1\nbuild: deps\n\tgo build\ntest:\n\tgo test\n.PHONY: build test\n"
+	if err := os.WriteFile(filepath.Join(dir, "Makefile"), []byte(makefile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewSpecProvider(BuiltinSpecs()...)
+	provider.SetWorkingDirectory(dir)
+
+	suggestions, _ := provider.Provide("make ", 5)
+	if got := suggestionTexts(suggestions); !equalStrings(got, []string{"build", "test"}) {
+		t.Errorf("expected Makefile targets, got %q", got)
+	}
+}
+
+func TestBuiltinGitBranches(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init"},
+		{"branch", "feature"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("git %v failed: %v: %s", args, err, out)
+		}
+	}
+
+	provider := NewSpecProvider(BuiltinSpecs()...)
+	provider.SetWorkingDirectory(dir)
+
+	suggestions, _ := provider.Provide("git checkout ", 13)
+	if got := suggestionTexts(suggestions); !equalStrings(got, []string{"feature", "main"}) {
+		t.Errorf("expected branches, got %q", got)
+	}
+}
+
+func TestLoadSpecs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tool.yaml"), []byte(testSpec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	specs, err := LoadSpecs(dir)
+	if err != nil {
+		t.Fatalf("LoadSpecs failed: %v", err)
+	}
+	if len(specs) != 1 || specs[0].Name != "tool" {
+		t.Errorf("expected the tool spec, got %d specs", len(specs))
+	}
+
+	if specs, err := LoadSpecs(filepath.Join(dir, "missing")); err != nil || specs != nil {
+		t.Errorf("expected no specs for a missing directory, got %v, %v", specs, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.yml"), []byte("description: no name\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSpecs(dir); err == nil {
+		t.Error("expected error for spec without a name")
+	}
+}
+
+func TestReplaceWord(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input      string
+		cursor     int
+		text       string
+		want       string
+		wantCursor int
+	}{
+		{"git che", 7, "checkout", "git checkout", 12},
+		{"git ", 4, "main", "git main", 8},
+		{"ls src/ma | wc", 9, "src/main.go", "ls src/main.go | wc", 14},
+		{"make bu && make", 7, "build", "make build && make", 10},
+	}
+
+	for _, tt := range tests {
+		got, cursor := ReplaceWord(tt.input, tt.cursor, tt.text)
+		if got != tt.want || cursor != tt.wantCursor {
+			t.Errorf("ReplaceWord(%q, %d, %q) = %q, %d; want %q, %d", tt.input, tt.cursor, tt.text, got, cursor, tt.want, tt.wantCursor)
+		}
+	}
+}
@@ -0,0 +1,585 @@
+// Package browser provides a two-column file browser for cbwsh that copies
+// files between the local machine and a remote host.
+package browser
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/cbwinslow/cbwsh/pkg/ui/tunnels"
+)
+
+// Side is a column of the browser.
+type Side int
+
+const (
+	// Local is the left column, the local file system.
+	Local Side = iota
+	// Remote is the right column, the host's file system.
+	Remote
+)
+
+// other returns the opposite column.
+func (s Side) other() Side {
+	return 1 - s
+}
+
+// Source is the file system shown in a column.
+type Source struct {
+	// Title names the column, such as "local" or a connection name.
+	Title string
+	// Dir is the directory shown first.
+	Dir string
+	// List lists a directory. It runs off the UI goroutine.
+	List func(dir string) ([]os.FileInfo, error)
+	// Join and Parent handle the source's paths, such as filepath.Join and
+	// filepath.Dir for local files or path.Join and path.Dir over SFTP.
+	Join   func(elem ...string) string
+	Parent func(dir string) string
+}
+
+// CopyMsg asks for files in one column to be copied into the directory
+// shown in the other.
+type CopyMsg struct {
+	// From is the column the files are in; Local means upload.
+	From Side
+	// Paths are the files and directories to copy.
+	Paths []string
+	// Dest is the directory to copy them into.
+	Dest string
+}
+
+// ListedMsg delivers a directory listing to the browser that asked for
+// it; pass it to Update.
+type ListedMsg struct {
+	side    Side
+	dir     string
+	entries []os.FileInfo
+	err     error
+}
+
+// Styles defines the browser styles.
+type Styles struct {
+	Border       lipgloss.Style
+	ActiveBorder lipgloss.Style
+	Title        lipgloss.Style
+	Dir          lipgloss.Style
+	Entry        lipgloss.Style
+	Directory    lipgloss.Style
+	Cursor       lipgloss.Style
+	Marked       lipgloss.Style
+	Size         lipgloss.Style
+	Error        lipgloss.Style
+	Help         lipgloss.Style
+}
+
+// DefaultStyles returns default browser styles.
+func DefaultStyles() Styles {
+	return Styles{
+		Border: lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("240")),
+		ActiveBorder: lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("62")),
+		Title: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("62")).
+			Bold(true),
+		Dir: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("243")),
+		Entry: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("252")),
+		Directory: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("75")).
+			Bold(true),
+		Cursor: lipgloss.NewStyle().
+			Background(lipgloss.Color("237")),
+		Marked: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("214")),
+		Size: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("243")),
+		Error: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196")),
+		Help: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("241")),
+	}
+}
+
+// KeyMap defines key bindings for the browser.
+type KeyMap struct {
+	Up       key.Binding
+	Down     key.Binding
+	PageUp   key.Binding
+	PageDown key.Binding
+	Open     key.Binding
+	Back     key.Binding
+	Switch   key.Binding
+	Mark     key.Binding
+	Copy     key.Binding
+	Refresh  key.Binding
+	Close    key.Binding
+}
+
+// DefaultKeyMap returns default key bindings.
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Up:       key.NewBinding(key.WithKeys("up", "k")),
+		Down:     key.NewBinding(key.WithKeys("down", "j")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+		Open:     key.NewBinding(key.WithKeys("enter", "right", "l"), key.WithHelp("enter", "open")),
+		Back:     key.NewBinding(key.WithKeys("backspace", "left", "h"), key.WithHelp("⌫", "up")),
+		Switch:   key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "switch")),
+		Mark:     key.NewBinding(key.WithKeys(" "), key.WithHelp("space", "mark")),
+		Copy:     key.NewBinding(key.WithKeys("c", "f5"), key.WithHelp("c", "copy")),
+		Refresh:  key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "refresh")),
+		Close:    key.NewBinding(key.WithKeys("esc", "q"), key.WithHelp("esc", "close")),
+	}
+}
+
+// row is a line of a column: an entry of its directory, or the way up.
+type row struct {
+	info os.FileInfo
+	up   bool
+}
+
+func (r row) name() string {
+	if r.up {
+		return ".."
+	}
+	return r.info.Name()
+}
+
+func (r row) isDir() bool {
+	return r.up || r.info.IsDir()
+}
+
+// column is the state of one side of the browser.
+type column struct {
+	src     Source
+	dir     string
+	rows    []row
+	cursor  int
+	offset  int
+	marked  map[string]bool
+	loading bool
+	err     error
+}
+
+// drag is an entry being dragged with the mouse.
+type drag struct {
+	side Side
+	row  int
+}
+
+// Browser shows the local working directory next to a directory on a
+// remote host. Files are copied to the other column with the copy key or
+// by dragging them there with the mouse.
+type Browser struct {
+	columns [2]*column
+	active  Side
+	drag    *drag
+	visible bool
+	width   int
+	height  int
+	keys    KeyMap
+	styles  Styles
+}
+
+// New creates a new, closed browser.
+func New() *Browser {
+	return &Browser{
+		keys:   DefaultKeyMap(),
+		styles: DefaultStyles(),
+	}
+}
+
+// Open shows the browser on local and remote and starts listing their
+// directories.
+func (b *Browser) Open(local, remote Source) tea.Cmd {
+	b.columns = [2]*column{
+		{src: local, dir: local.Dir, marked: make(map[string]bool)},
+		{src: remote, dir: remote.Dir, marked: make(map[string]bool)},
+	}
+	b.active = Local
+	b.drag = nil
+	b.visible = true
+	return b.Refresh()
+}
+
+// Close hides the browser.
+func (b *Browser) Close() {
+	b.visible = false
+	b.drag = nil
+}
+
+// IsVisible returns whether the browser is open.
+func (b *Browser) IsVisible() bool {
+	return b.visible
+}
+
+// Title returns the title of the remote column, usually the name of the
+// connection it browses.
+func (b *Browser) Title() string {
+	if b.columns[Remote] == nil {
+		return ""
+	}
+	return b.columns[Remote].src.Title
+}
+
+// SetSize sets the size of the browser.
+func (b *Browser) SetSize(width, height int) {
+	b.width = width
+	b.height = height
+}
+
+// SetStyles sets the browser styles.
+func (b *Browser) SetStyles(styles Styles) {
+	b.styles = styles
+}
+
+// Refresh lists the directories of both columns again, such as after
+// files were copied into them.
+func (b *Browser) Refresh() tea.Cmd {
+	if !b.visible {
+		return nil
+	}
+	return tea.Batch(b.load(Local, b.columns[Local].dir), b.load(Remote, b.columns[Remote].dir))
+}
+
+// load returns a command listing dir in a column.
+func (b *Browser) load(side Side, dir string) tea.Cmd {
+	col := b.columns[side]
+	if dir != col.dir {
+		col.rows, col.cursor, col.offset = nil, 0, 0
+		clear(col.marked)
+	}
+	col.dir = dir
+	col.loading = true
+	list := col.src.List
+	return func() tea.Msg {
+		entries, err := list(dir)
+		return ListedMsg{side: side, dir: dir, entries: entries, err: err}
+	}
+}
+
+// listed shows a listing, unless the column has moved on to another
+// directory since it was asked for.
+func (b *Browser) listed(msg ListedMsg) {
+	col := b.columns[msg.side]
+	if msg.dir != col.dir {
+		return
+	}
+	col.loading = false
+	col.err = msg.err
+
+	// Keep the cursor on the same entry when refreshing
+	current := ""
+	if col.cursor < len(col.rows) {
+		current = col.rows[col.cursor].name()
+	}
+
+	sort.Slice(msg.entries, func(i, j int) bool {
+		a, b := msg.entries[i], msg.entries[j]
+		if a.IsDir() != b.IsDir() {
+			return a.IsDir()
+		}
+		return strings.ToLower(a.Name()) < strings.ToLower(b.Name())
+	})
+	col.rows = col.rows[:0]
+	if col.src.Parent(col.dir) != col.dir {
+		col.rows = append(col.rows, row{up: true})
+	}
+	names := make(map[string]bool, len(msg.entries))
+	for _, info := range msg.entries {
+		col.rows = append(col.rows, row{info: info})
+		names[info.Name()] = true
+	}
+	for name := range col.marked {
+		if !names[name] {
+			delete(col.marked, name)
+		}
+	}
+
+	col.cursor = 0
+	for i, r := range col.rows {
+		if r.name() == current {
+			col.cursor = i
+		}
+	}
+	b.scroll(col)
+}
+
+// Update handles input while the browser is open. It reports whether the
+// message was consumed; the browser takes every key while it is open.
+// Mouse messages are expected relative to the browser's top-left corner.
+// Copying returns a command delivering a CopyMsg.
+func (b *Browser) Update(msg tea.Msg) (bool, tea.Cmd) {
+	if msg, ok := msg.(ListedMsg); ok {
+		if b.visible {
+			b.listed(msg)
+		}
+		return true, nil
+	}
+	if !b.visible {
+		return false, nil
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		return true, b.handleKey(msg)
+	case tea.MouseMsg:
+		return true, b.handleMouse(msg)
+	}
+	return false, nil
+}
+
+func (b *Browser) handleKey(msg tea.KeyMsg) tea.Cmd {
+	col := b.columns[b.active]
+	switch {
+	case key.Matches(msg, b.keys.Close):
+		b.Close()
+	case key.Matches(msg, b.keys.Up):
+		b.move(col, -1)
+	case key.Matches(msg, b.keys.Down):
+		b.move(col, 1)
+	case key.Matches(msg, b.keys.PageUp):
+		b.move(col, -b.listHeight())
+	case key.Matches(msg, b.keys.PageDown):
+		b.move(col, b.listHeight())
+	case key.Matches(msg, b.keys.Switch):
+		b.active = b.active.other()
+	case key.Matches(msg, b.keys.Open):
+		return b.open(b.active, col.cursor)
+	case key.Matches(msg, b.keys.Back):
+		return b.load(b.active, col.src.Parent(col.dir))
+	case key.Matches(msg, b.keys.Mark):
+		if col.cursor < len(col.rows) && !col.rows[col.cursor].up {
+			name := col.rows[col.cursor].name()
+			if col.marked[name] {
+				delete(col.marked, name)
+			} else {
+				col.marked[name] = true
+			}
+			b.move(col, 1)
+		}
+	case key.Matches(msg, b.keys.Copy):
+		return b.copy(b.active, col.cursor)
+	case key.Matches(msg, b.keys.Refresh):
+		return b.Refresh()
+	}
+	return nil
+}
+
+// handleMouse selects entries on click, scrolls with the wheel and copies
+// an entry dragged onto the other column.
+func (b *Browser) handleMouse(msg tea.MouseMsg) tea.Cmd {
+	side := Local
+	if msg.X >= b.width/2 {
+		side = Remote
+	}
+	col := b.columns[side]
+	index, onRow := b.rowAt(col, msg.Y)
+
+	switch {
+	case msg.Button == tea.MouseButtonWheelUp:
+		b.move(col, -3)
+	case msg.Button == tea.MouseButtonWheelDown:
+		b.move(col, 3)
+	case msg.Button == tea.MouseButtonLeft && msg.Action == tea.MouseActionPress:
+		b.active = side
+		b.drag = nil
+		if onRow {
+			col.cursor = index
+			b.drag = &drag{side: side, row: index}
+		}
+	case msg.Action == tea.MouseActionRelease:
+		dragged := b.drag
+		b.drag = nil
+		if dragged != nil && side != dragged.side {
+			return b.copy(dragged.side, dragged.row)
+		}
+	}
+	return nil
+}
+
+// rowAt returns the index of the row shown at line y of the browser.
+func (b *Browser) rowAt(col *column, y int) (int, bool) {
+	// Below the border, the title and the directory
+	index := col.offset + y - 3
+	if y < 3 || y-3 >= b.listHeight() || index >= len(col.rows) {
+		return 0, false
+	}
+	return index, true
+}
+
+// move moves a column's cursor by delta rows.
+func (b *Browser) move(col *column, delta int) {
+	col.cursor = min(max(col.cursor+delta, 0), max(len(col.rows)-1, 0))
+	b.scroll(col)
+}
+
+// scroll keeps a column's cursor in view.
+func (b *Browser) scroll(col *column) {
+	height := b.listHeight()
+	if col.cursor < col.offset {
+		col.offset = col.cursor
+	}
+	if col.cursor >= col.offset+height {
+		col.offset = col.cursor - height + 1
+	}
+	col.offset = max(min(col.offset, len(col.rows)-height), 0)
+}
+
+// open enters the directory at index in a column.
+func (b *Browser) open(side Side, index int) tea.Cmd {
+	col := b.columns[side]
+	if index >= len(col.rows) || !col.rows[index].isDir() {
+		return nil
+	}
+	if col.rows[index].up {
+		return b.load(side, col.src.Parent(col.dir))
+	}
+	return b.load(side, col.src.Join(col.dir, col.rows[index].name()))
+}
+
+// copy asks for the marked entries of a column to be copied to the other,
+// or for the entry at index if it is not marked.
+func (b *Browser) copy(side Side, index int) tea.Cmd {
+	col := b.columns[side]
+	if index >= len(col.rows) || col.rows[index].up {
+		return nil
+	}
+
+	var names []string
+	if col.marked[col.rows[index].name()] {
+		for _, r := range col.rows {
+			if !r.up && col.marked[r.name()] {
+				names = append(names, r.name())
+			}
+		}
+	} else {
+		names = []string{col.rows[index].name()}
+	}
+	clear(col.marked)
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = col.src.Join(col.dir, name)
+	}
+	msg := CopyMsg{From: side, Paths: paths, Dest: b.columns[side.other()].dir}
+	return func() tea.Msg {
+		return msg
+	}
+}
+
+// listHeight returns how many rows a column shows.
+func (b *Browser) listHeight() int {
+	// Borders, title, directory and the help line
+	return max(b.height-5, 1)
+}
+
+// View renders the browser.
+func (b *Browser) View() string {
+	if !b.visible {
+		return ""
+	}
+
+	left := b.width / 2
+	columns := lipgloss.JoinHorizontal(lipgloss.Top,
+		b.renderColumn(Local, left),
+		b.renderColumn(Remote, b.width-left))
+
+	help := []string{}
+	for _, binding := range []key.Binding{b.keys.Switch, b.keys.Open, b.keys.Back, b.keys.Mark, b.keys.Copy, b.keys.Refresh, b.keys.Close} {
+		h := binding.Help()
+		help = append(help, h.Key+" "+h.Desc)
+	}
+	footer := strings.Join(help, " · ") + " · drag to the other side to copy"
+	if b.drag != nil {
+		col := b.columns[b.drag.side]
+		if b.drag.row < len(col.rows) {
+			footer = fmt.Sprintf("Drop on the %s column to copy %s", b.columns[b.drag.side.other()].src.Title, col.rows[b.drag.row].name())
+		}
+	}
+	footer = lipgloss.NewStyle().MaxWidth(b.width).Render(b.styles.Help.Render(footer))
+	return lipgloss.JoinVertical(lipgloss.Left, columns, footer)
+}
+
+// renderColumn renders a column with its border in width cells.
+func (b *Browser) renderColumn(side Side, width int) string {
+	col := b.columns[side]
+	innerWidth := max(width-2, 10)
+	height := b.listHeight()
+
+	lines := []string{
+		b.styles.Title.Render(col.src.Title),
+		b.styles.Dir.Render(truncateLeft(col.dir, innerWidth)),
+	}
+	switch {
+	case col.err != nil:
+		lines = append(lines, b.styles.Error.Render(col.err.Error()))
+	case col.loading && len(col.rows) == 0:
+		lines = append(lines, b.styles.Dir.Render("Loading..."))
+	}
+	if col.err == nil {
+		end := min(col.offset+height, len(col.rows))
+		for i := col.offset; i < end; i++ {
+			lines = append(lines, b.renderRow(col, i, side == b.active, innerWidth))
+		}
+	}
+
+	clip := lipgloss.NewStyle().MaxWidth(innerWidth)
+	for i, line := range lines {
+		lines[i] = clip.Render(line)
+	}
+
+	border := b.styles.Border
+	if side == b.active {
+		border = b.styles.ActiveBorder
+	}
+	return border.Width(innerWidth).Height(height + 2).Render(strings.Join(lines, "\n"))
+}
+
+// renderRow renders a row of a column: a mark, the name and the size.
+func (b *Browser) renderRow(col *column, index int, active bool, width int) string {
+	r := col.rows[index]
+
+	mark := "  "
+	if col.marked[r.name()] {
+		mark = b.styles.Marked.Render("* ")
+	}
+
+	name, style := r.name(), b.styles.Entry
+	if r.isDir() {
+		name += "/"
+		style = b.styles.Directory
+	}
+	size := ""
+	if !r.isDir() {
+		size = tunnels.FormatBytes(r.info.Size())
+	}
+
+	gap := max(width-2-lipgloss.Width(name)-lipgloss.Width(size), 1)
+	line := mark + style.Render(name) + strings.Repeat(" ", gap) + b.styles.Size.Render(size)
+	if active && index == col.cursor {
+		line = b.styles.Cursor.Render(line)
+	}
+	return line
+}
+
+// truncateLeft shortens s to width cells by dropping its start, which
+// keeps the end of a long path in view.
+func truncateLeft(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return "…" + string(runes[len(runes)-width+1:])
+}
@@ -0,0 +1,211 @@
+package browser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// localSource browses dir on the local file system.
+func localSource(title, dir string) Source {
+	return Source{
+		Title: title,
+		Dir:   dir,
+		List: func(dir string) ([]os.FileInfo, error) {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			infos := make([]os.FileInfo, 0, len(entries))
+			for _, entry := range entries {
+				if info, err := entry.Info(); err == nil {
+					infos = append(infos, info)
+				}
+			}
+			return infos, nil
+		},
+		Join:   filepath.Join,
+		Parent: filepath.Dir,
+	}
+}
+
+// run runs cmd and feeds listings back to b, returning the other
+// messages it produced.
+func run(b *Browser, cmd tea.Cmd) []tea.Msg {
+	if cmd == nil {
+		return nil
+	}
+	var out []tea.Msg
+	switch msg := cmd().(type) {
+	case tea.BatchMsg:
+		for _, cmd := range msg {
+			out = append(out, run(b, cmd)...)
+		}
+	case ListedMsg:
+		b.Update(msg)
+	case nil:
+	default:
+		out = append(out, msg)
+	}
+	return out
+}
+
+func press(b *Browser, keys ...string) []tea.Msg {
+	var out []tea.Msg
+	for _, k := range keys {
+		var msg tea.KeyMsg
+		switch k {
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "backspace":
+			msg = tea.KeyMsg{Type: tea.KeyBackspace}
+		case "tab":
+			msg = tea.KeyMsg{Type: tea.KeyTab}
+		case "esc":
+			msg = tea.KeyMsg{Type: tea.KeyEsc}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		case " ":
+			msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+		default:
+			msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+		}
+		handled, cmd := b.Update(msg)
+		if !handled {
+			panic("key not handled: " + k)
+		}
+		out = append(out, run(b, cmd)...)
+	}
+	return out
+}
+
+// newTestBrowser opens a browser on two directories with a few files.
+func newTestBrowser(t *testing.T) (*Browser, string, string) {
+	t.Helper()
+
+	local, remote := t.TempDir(), t.TempDir()
+	for _, name := range []string{"b.txt", "a.txt", "docs/readme.md"} {
+		path := filepath.Join(local, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := New()
+	b.SetSize(80, 20)
+	run(b, b.Open(localSource("local", local), localSource("web", remote)))
+	return b, local, remote
+}
+
+func TestBrowserLists(t *testing.T) {
+	b, _, _ := newTestBrowser(t)
+
+	if !b.IsVisible() || b.Title() != "web" {
+		t.Fatalf("expected an open browser on web, got visible=%v title=%q", b.IsVisible(), b.Title())
+	}
+
+	var names []string
+	for _, r := range b.columns[Local].rows {
+		names = append(names, r.name())
+	}
+	if want := []string{"..", "docs", "a.txt", "b.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected directories first, then files by name: got %v, want %v", names, want)
+	}
+
+	view := b.View()
+	for _, want := range []string{"local", "web", "docs/", "a.txt", "4 B"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("expected %q in the view", want)
+		}
+	}
+	if got := len(strings.Split(view, "\n")); got != 20 {
+		t.Errorf("expected the view to fill 20 lines, got %d", got)
+	}
+}
+
+func TestBrowserNavigates(t *testing.T) {
+	b, local, _ := newTestBrowser(t)
+
+	press(b, "down", "enter")
+	if got := b.columns[Local].dir; got != filepath.Join(local, "docs") {
+		t.Fatalf("expected to enter docs, got %s", got)
+	}
+	if rows := b.columns[Local].rows; len(rows) != 2 || rows[1].name() != "readme.md" {
+		t.Errorf("expected docs listed, got %v", rows)
+	}
+
+	press(b, "backspace")
+	if got := b.columns[Local].dir; got != local {
+		t.Errorf("expected to go back up to %s, got %s", local, got)
+	}
+	if got := b.columns[Local].rows[b.columns[Local].cursor].name(); got != ".." {
+		t.Errorf("expected the cursor on the first row, got %s", got)
+	}
+
+	press(b, "tab")
+	if b.active != Remote {
+		t.Error("expected tab to switch columns")
+	}
+
+	press(b, "esc")
+	if b.IsVisible() {
+		t.Error("expected esc to close the browser")
+	}
+	if handled, _ := b.Update(tea.KeyMsg{Type: tea.KeyEnter}); handled {
+		t.Error("expected a closed browser to leave keys alone")
+	}
+}
+
+func TestBrowserCopiesMarkedFiles(t *testing.T) {
+	b, local, remote := newTestBrowser(t)
+
+	// The entry under the cursor is copied when nothing is marked
+	msgs := press(b, "down", "down", "c")
+	want := CopyMsg{From: Local, Paths: []string{filepath.Join(local, "a.txt")}, Dest: remote}
+	if len(msgs) != 1 || !reflect.DeepEqual(msgs[0], want) {
+		t.Fatalf("expected %+v, got %+v", want, msgs)
+	}
+
+	msgs = press(b, " ", " ", "c")
+	want = CopyMsg{From: Local, Paths: []string{filepath.Join(local, "a.txt"), filepath.Join(local, "b.txt")}, Dest: remote}
+	if len(msgs) != 1 || !reflect.DeepEqual(msgs[0], want) {
+		t.Fatalf("expected %+v, got %+v", want, msgs)
+	}
+	if len(b.columns[Local].marked) != 0 {
+		t.Error("expected copying to clear the marks")
+	}
+}
+
+func TestBrowserDragCopies(t *testing.T) {
+	b, local, remote := newTestBrowser(t)
+
+	// Rows start below the border, the title and the directory; the
+	// second row is docs
+	b.Update(tea.MouseMsg{X: 5, Y: 4, Button: tea.MouseButtonLeft, Action: tea.MouseActionPress})
+	if b.columns[Local].cursor != 1 {
+		t.Fatalf("expected the click to select docs, got row %d", b.columns[Local].cursor)
+	}
+	if !strings.Contains(b.View(), "Drop on the web column") {
+		t.Error("expected a hint while dragging")
+	}
+
+	_, cmd := b.Update(tea.MouseMsg{X: 60, Y: 6, Button: tea.MouseButtonNone, Action: tea.MouseActionRelease})
+	msgs := run(b, cmd)
+	want := CopyMsg{From: Local, Paths: []string{filepath.Join(local, "docs")}, Dest: remote}
+	if len(msgs) != 1 || !reflect.DeepEqual(msgs[0], want) {
+		t.Fatalf("expected %+v, got %+v", want, msgs)
+	}
+
+	// Dropping on the same column copies nothing
+	b.Update(tea.MouseMsg{X: 5, Y: 5, Button: tea.MouseButtonLeft, Action: tea.MouseActionPress})
+	if _, cmd := b.Update(tea.MouseMsg{X: 6, Y: 6, Action: tea.MouseActionRelease}); cmd != nil {
+		t.Error("expected no copy when dropping on the same column")
+	}
+}
@@ -0,0 +1,266 @@
+// Package dialog provides modal dialogs that ask the user to decide
+// before cbwsh continues, such as confirming a risky command.
+package dialog
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Option is a choice the user makes by pressing its key.
+type Option struct {
+	// Key is the key that picks the option, such as "y".
+	Key string
+	// Label describes the option.
+	Label string
+}
+
+// Spec describes a dialog.
+type Spec struct {
+	// ID identifies the dialog in its ResultMsg.
+	ID string
+	// Title is shown at the top.
+	Title string
+	// Body explains what is being decided.
+	Body string
+	// Options are the choices. They are ignored when Input is set.
+	Options []Option
+	// Input asks for text instead; Enter submits it.
+	Input bool
+	// Placeholder is shown while the input is empty.
+	Placeholder string
+	// Mask hides the typed text, for passwords.
+	Mask bool
+	// Danger draws the dialog as a warning.
+	Danger bool
+}
+
+// ResultMsg reports how a dialog was closed.
+type ResultMsg struct {
+	// ID is the ID of the dialog's Spec.
+	ID string
+	// Choice is the key of the picked option.
+	Choice string
+	// Input is the submitted text of an input dialog.
+	Input string
+	// Cancelled is set if the user dismissed the dialog with Esc.
+	Cancelled bool
+}
+
+// Styles defines the dialog styles.
+type Styles struct {
+	Border       lipgloss.Style
+	DangerBorder lipgloss.Style
+	Title        lipgloss.Style
+	DangerTitle  lipgloss.Style
+	Body         lipgloss.Style
+	Key          lipgloss.Style
+	Label        lipgloss.Style
+}
+
+// DefaultStyles returns default dialog styles.
+func DefaultStyles() Styles {
+	return Styles{
+		Border: lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("62")).
+			Padding(0, 1),
+		DangerBorder: lipgloss.NewStyle().
+			Border(lipgloss.ThickBorder()).
+			BorderForeground(lipgloss.Color("196")).
+			Padding(0, 1),
+		Title: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("86")).
+			Bold(true),
+		DangerTitle: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196")).
+			Bold(true),
+		Body: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("252")),
+		Key: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("255")).
+			Background(lipgloss.Color("238")).
+			Padding(0, 1),
+		Label: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("245")),
+	}
+}
+
+// KeyMap defines key bindings for the dialog.
+type KeyMap struct {
+	Submit key.Binding
+	Cancel key.Binding
+}
+
+// DefaultKeyMap returns default key bindings.
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Submit: key.NewBinding(
+			key.WithKeys("enter"),
+			key.WithHelp("enter", "submit"),
+		),
+		Cancel: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "cancel"),
+		),
+	}
+}
+
+// Dialog is a modal dialog. Only one dialog is open at a time.
+type Dialog struct {
+	spec    Spec
+	input   textinput.Model
+	visible bool
+	width   int
+	styles  Styles
+}
+
+// New creates a new, closed dialog.
+func New() *Dialog {
+	ti := textinput.New()
+	ti.Prompt = "> "
+	ti.CharLimit = 256
+
+	return &Dialog{
+		input:  ti,
+		styles: DefaultStyles(),
+	}
+}
+
+// Open shows a dialog described by spec, replacing any open one.
+func (d *Dialog) Open(spec Spec) tea.Cmd {
+	d.spec = spec
+	d.visible = true
+	d.input.Reset()
+	d.input.Placeholder = spec.Placeholder
+	d.input.EchoMode = textinput.EchoNormal
+	if spec.Mask {
+		d.input.EchoMode = textinput.EchoPassword
+	}
+	if spec.Input {
+		d.input.Focus()
+		return textinput.Blink
+	}
+	d.input.Blur()
+	return nil
+}
+
+// Close hides the dialog without reporting a result.
+func (d *Dialog) Close() {
+	d.visible = false
+	d.input.Blur()
+}
+
+// IsVisible returns whether the dialog is open.
+func (d *Dialog) IsVisible() bool {
+	return d.visible
+}
+
+// ID returns the ID of the open dialog.
+func (d *Dialog) ID() string {
+	return d.spec.ID
+}
+
+// SetSize sets the width available to the dialog.
+func (d *Dialog) SetSize(width, _ int) {
+	d.width = width
+	if width > 0 {
+		d.input.Width = max(min(width, 80)-10, 1)
+	}
+}
+
+// SetStyles sets the dialog styles.
+func (d *Dialog) SetStyles(styles Styles) {
+	d.styles = styles
+}
+
+// Update handles input while the dialog is open. It reports whether the
+// message was consumed; the dialog takes every key while it is open. When
+// the user decides, the dialog closes and the returned command delivers a
+// ResultMsg.
+func (d *Dialog) Update(msg tea.Msg) (bool, tea.Cmd) {
+	if !d.visible {
+		return false, nil
+	}
+
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		if d.spec.Input {
+			var cmd tea.Cmd
+			d.input, cmd = d.input.Update(msg)
+			return false, cmd
+		}
+		return false, nil
+	}
+
+	keys := DefaultKeyMap()
+	switch {
+	case key.Matches(keyMsg, keys.Cancel):
+		return true, d.finish(ResultMsg{ID: d.spec.ID, Cancelled: true})
+
+	case d.spec.Input && key.Matches(keyMsg, keys.Submit):
+		return true, d.finish(ResultMsg{ID: d.spec.ID, Input: d.input.Value()})
+
+	case d.spec.Input:
+		var cmd tea.Cmd
+		d.input, cmd = d.input.Update(keyMsg)
+		return true, cmd
+	}
+
+	for _, option := range d.spec.Options {
+		if keyMsg.String() == option.Key {
+			return true, d.finish(ResultMsg{ID: d.spec.ID, Choice: option.Key})
+		}
+	}
+	return true, nil
+}
+
+// finish closes the dialog and returns a command delivering result.
+func (d *Dialog) finish(result ResultMsg) tea.Cmd {
+	d.Close()
+	return func() tea.Msg {
+		return result
+	}
+}
+
+// View renders the dialog.
+func (d *Dialog) View() string {
+	if !d.visible {
+		return ""
+	}
+
+	border, title := d.styles.Border, d.styles.Title
+	if d.spec.Danger {
+		border, title = d.styles.DangerBorder, d.styles.DangerTitle
+	}
+
+	var sb strings.Builder
+	sb.WriteString(title.Render(d.spec.Title))
+	if d.spec.Body != "" {
+		sb.WriteString("\n\n")
+		sb.WriteString(d.styles.Body.Render(d.spec.Body))
+	}
+	sb.WriteString("\n\n")
+
+	if d.spec.Input {
+		sb.WriteString(d.input.View())
+		sb.WriteString("\n")
+		sb.WriteString(d.styles.Label.Render("enter submit · esc cancel"))
+	} else {
+		choices := make([]string, 0, len(d.spec.Options)+1)
+		for _, option := range d.spec.Options {
+			choices = append(choices, d.styles.Key.Render(option.Key)+" "+d.styles.Label.Render(option.Label))
+		}
+		choices = append(choices, d.styles.Key.Render("esc")+" "+d.styles.Label.Render("cancel"))
+		sb.WriteString(strings.Join(choices, "  "))
+	}
+
+	if d.width > 0 {
+		border = border.Width(min(d.width, 80) - 4)
+	}
+	return border.Render(sb.String())
+}
@@ -0,0 +1,123 @@
+package dialog
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func runes(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+// result runs cmd and returns the ResultMsg it delivers.
+func result(t *testing.T, cmd tea.Cmd) ResultMsg {
+	t.Helper()
+	if cmd == nil {
+		t.Fatal("expected a command delivering the result")
+	}
+	msg, ok := cmd().(ResultMsg)
+	if !ok {
+		t.Fatalf("expected ResultMsg, got %T", msg)
+	}
+	return msg
+}
+
+func TestDialog_Options(t *testing.T) {
+	d := New()
+	d.Open(Spec{
+		ID:      "confirm",
+		Title:   "Run this command?",
+		Body:    "rm -rf build",
+		Options: []Option{{Key: "y", Label: "run"}, {Key: "n", Label: "don't run"}},
+	})
+
+	if !d.IsVisible() {
+		t.Fatal("dialog should be visible after Open")
+	}
+	view := d.View()
+	for _, want := range []string{"Run this command?", "rm -rf build", "don't run", "esc"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view does not contain %q", want)
+		}
+	}
+
+	// Other keys are swallowed
+	handled, cmd := d.Update(runes("x"))
+	if !handled || cmd != nil || !d.IsVisible() {
+		t.Error("an unbound key should be consumed without closing the dialog")
+	}
+
+	handled, cmd = d.Update(runes("y"))
+	if !handled {
+		t.Error("option key should be handled")
+	}
+	if got := result(t, cmd); got.ID != "confirm" || got.Choice != "y" || got.Cancelled {
+		t.Errorf("result = %+v", got)
+	}
+	if d.IsVisible() {
+		t.Error("dialog should close after a choice")
+	}
+}
+
+func TestDialog_Cancel(t *testing.T) {
+	d := New()
+	d.Open(Spec{ID: "confirm", Options: []Option{{Key: "y", Label: "yes"}}})
+
+	_, cmd := d.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if got := result(t, cmd); !got.Cancelled || got.Choice != "" {
+		t.Errorf("result = %+v, want cancelled", got)
+	}
+}
+
+func TestDialog_Input(t *testing.T) {
+	d := New()
+	d.Open(Spec{ID: "typed", Title: "Type yes", Input: true, Options: []Option{{Key: "y"}}})
+
+	// Option keys are text in an input dialog
+	for _, r := range "yes" {
+		if _, cmd := d.Update(runes(string(r))); cmd != nil {
+			if _, ok := cmd().(ResultMsg); ok {
+				t.Fatal("typing should not close an input dialog")
+			}
+		}
+	}
+
+	_, cmd := d.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if got := result(t, cmd); got.Input != "yes" || got.Cancelled {
+		t.Errorf("result = %+v", got)
+	}
+}
+
+func TestDialog_Mask(t *testing.T) {
+	d := New()
+	d.Open(Spec{ID: "unlock", Title: "Unlock", Input: true, Mask: true})
+	for _, r := range "hunter2" {
+		d.Update(runes(string(r)))
+	}
+	if strings.Contains(d.View(), "hunter2") {
+		t.Error("a masked input should not show the typed text")
+	}
+	_, cmd := d.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if got := result(t, cmd); got.Input != "hunter2" {
+		t.Errorf("result = %+v", got)
+	}
+
+	// The next dialog shows its input again
+	d.Open(Spec{ID: "name", Title: "Name", Input: true})
+	d.Update(runes("web"))
+	if !strings.Contains(d.View(), "web") {
+		t.Error("an unmasked input should show the typed text")
+	}
+}
+
+func TestDialog_Closed(t *testing.T) {
+	d := New()
+	if handled, _ := d.Update(runes("y")); handled {
+		t.Error("a closed dialog should not handle keys")
+	}
+	if d.View() != "" {
+		t.Error("a closed dialog should render nothing")
+	}
+}
@@ -0,0 +1,158 @@
+// Package fanout renders the results of running a command across a group
+// of SSH hosts for cbwsh.
+package fanout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// DefaultMaxLines is how many output lines a group shows by default.
+const DefaultMaxLines = 20
+
+// Styles defines the report styles.
+type Styles struct {
+	Summary lipgloss.Style
+	OK      lipgloss.Style
+	Failed  lipgloss.Style
+	Hosts   lipgloss.Style
+	Output  lipgloss.Style
+	Muted   lipgloss.Style
+}
+
+// DefaultStyles returns default report styles.
+func DefaultStyles() Styles {
+	return Styles{
+		Summary: lipgloss.NewStyle().
+			Bold(true),
+		OK: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("42")).
+			Bold(true),
+		Failed: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196")).
+			Bold(true),
+		Hosts: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("255")),
+		Output: lipgloss.NewStyle(),
+		Muted: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("243")),
+	}
+}
+
+// Report renders fan-out results as output lines: a summary, then one
+// block per set of hosts with the same outcome, failures first, each with
+// its exit code or error and the output the hosts share.
+type Report struct {
+	styles   Styles
+	maxLines int
+}
+
+// NewReport creates a report with the default styles.
+func NewReport() *Report {
+	return &Report{
+		styles:   DefaultStyles(),
+		maxLines: DefaultMaxLines,
+	}
+}
+
+// SetStyles sets the report styles.
+func (r *Report) SetStyles(styles Styles) {
+	r.styles = styles
+}
+
+// SetMaxLines sets how many output lines each group shows; the rest are
+// counted. 0 shows them all.
+func (r *Report) SetMaxLines(n int) {
+	r.maxLines = n
+}
+
+// Lines renders results, as returned by ssh.Manager.FanOut.
+func (r *Report) Lines(results []ssh.HostResult) []string {
+	lines := []string{r.summary(results)}
+	for _, group := range ssh.GroupResults(results) {
+		lines = append(lines, r.header(group))
+		lines = append(lines, r.output(group.Output)...)
+	}
+	return lines
+}
+
+// summary counts the hosts that succeeded and failed and names the
+// slowest.
+func (r *Report) summary(results []ssh.HostResult) string {
+	var ok, failed int
+	var slowest ssh.HostResult
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		} else {
+			ok++
+		}
+		if result.Duration >= slowest.Duration {
+			slowest = result
+		}
+	}
+
+	parts := []string{r.styles.OK.Render(fmt.Sprintf("✓ %d ok", ok))}
+	if failed > 0 {
+		parts = append(parts, r.styles.Failed.Render(fmt.Sprintf("✗ %d failed", failed)))
+	}
+	summary := r.styles.Summary.Render(fmt.Sprintf("%s on %s", strings.Join(parts, "  "), plural(len(results), "host")))
+	if slowest.Host != "" {
+		summary += r.styles.Muted.Render(fmt.Sprintf(" · slowest %s %s", slowest.Host, slowest.Duration.Round(time.Millisecond)))
+	}
+	return summary
+}
+
+// header renders the outcome and hosts of a group.
+func (r *Report) header(group ssh.ResultGroup) string {
+	var outcome string
+	switch {
+	case group.Err != nil:
+		outcome = r.styles.Failed.Render("✗ " + group.Err.Error())
+	case group.ExitCode != 0:
+		outcome = r.styles.Failed.Render(fmt.Sprintf("✗ exit %d", group.ExitCode))
+	default:
+		outcome = r.styles.OK.Render("✓ exit 0")
+	}
+	hosts := r.styles.Hosts.Render(strings.Join(group.Hosts, ", "))
+	if len(group.Hosts) > 1 {
+		hosts += r.styles.Muted.Render(fmt.Sprintf(" (%d hosts)", len(group.Hosts)))
+	}
+	return outcome + r.styles.Muted.Render(" · ") + hosts
+}
+
+// output renders a group's output indented under its header, cut to the
+// maximum number of lines.
+func (r *Report) output(output string) []string {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return nil
+	}
+
+	all := strings.Split(output, "\n")
+	shown := all
+	if r.maxLines > 0 && len(all) > r.maxLines {
+		shown = all[:r.maxLines]
+	}
+	lines := make([]string, 0, len(shown)+1)
+	for _, line := range shown {
+		lines = append(lines, "    "+r.styles.Output.Render(strings.TrimRight(line, "\r")))
+	}
+	if hidden := len(all) - len(shown); hidden > 0 {
+		lines = append(lines, "    "+r.styles.Muted.Render("… "+plural(hidden, "more line")))
+	}
+	return lines
+}
+
+// plural formats a count of things, such as "1 host" or "3 hosts".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,53 @@
+package fanout
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// plainStyles renders without colors, so lines can be compared.
+func plainStyles() Styles {
+	plain := lipgloss.NewStyle()
+	return Styles{Summary: plain, OK: plain, Failed: plain, Hosts: plain, Output: plain, Muted: plain}
+}
+
+func TestReportGroupsOutcomes(t *testing.T) {
+	r := NewReport()
+	r.SetStyles(plainStyles())
+
+	lines := r.Lines([]ssh.HostResult{
+		{Host: "web-1", Output: "up 3 days\n", Duration: time.Second},
+		{Host: "web-2", Output: "up 3 days\n", Duration: 2 * time.Second},
+		{Host: "db-1", Output: "disk full\n", ExitCode: 2, Duration: time.Second},
+		{Host: "down", ExitCode: -1, Err: errors.New("timed out after 30s")},
+	})
+
+	want := []string{
+		"✓ 2 ok  ✗ 2 failed on 4 hosts · slowest web-2 2s",
+		"✗ exit 2 · db-1",
+		"    disk full",
+		"✗ timed out after 30s · down",
+		"✓ exit 0 · web-1, web-2 (2 hosts)",
+		"    up 3 days",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected report:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestReportCutsLongOutput(t *testing.T) {
+	r := NewReport()
+	r.SetStyles(plainStyles())
+	r.SetMaxLines(2)
+
+	lines := r.Lines([]ssh.HostResult{{Host: "web-1", Output: "a\nb\nc\nd\n"}})
+	if len(lines) != 5 || lines[4] != "    … 2 more lines" {
+		t.Errorf("expected two lines and a count of the rest, got %q", lines)
+	}
+}
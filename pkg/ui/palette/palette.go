@@ -2,8 +2,10 @@
 package palette
 
 import (
+	"sort"
 	"strings"
 	"sync"
+	"unicode"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textinput"
@@ -27,6 +29,10 @@ type Command struct {
 	Action func() tea.Cmd
 	// Keywords are search keywords.
 	Keywords []string
+	// Preview is shown below the list while the command is selected.
+	Preview string
+	// Weight is added to the match score when ranking commands.
+	Weight float64
 }
 
 // Palette is a command palette component.
@@ -41,6 +47,7 @@ type Palette struct {
 	height   int
 	maxItems int
 	styles   Styles
+	allow    func(Command) bool
 }
 
 // Styles defines the palette styles.
@@ -54,6 +61,7 @@ type Styles struct {
 	Shortcut     lipgloss.Style
 	Category     lipgloss.Style
 	NoResults    lipgloss.Style
+	Preview      lipgloss.Style
 }
 
 // DefaultStyles returns default palette styles.
@@ -86,6 +94,12 @@ func DefaultStyles() Styles {
 			Foreground(lipgloss.Color("243")).
 			Italic(true).
 			Padding(0, 1),
+		Preview: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("250")).
+			BorderStyle(lipgloss.NormalBorder()).
+			BorderTop(true).
+			BorderForeground(lipgloss.Color("240")).
+			Padding(0, 1),
 	}
 }
 
@@ -204,7 +218,15 @@ func (p *Palette) IsVisible() bool {
 func (p *Palette) SetSize(width, height int) {
 	p.width = width
 	p.height = height
-	p.input.Width = width - 10
+	p.resizeInput()
+}
+
+// resizeInput fits the query input, after its prompt, inside the border
+// and padding.
+func (p *Palette) resizeInput() {
+	if p.width > 0 {
+		p.input.Width = max(p.width-10-lipgloss.Width(p.input.Prompt), 1)
+	}
 }
 
 // SetStyles sets the palette styles.
@@ -212,6 +234,27 @@ func (p *Palette) SetStyles(styles Styles) {
 	p.styles = styles
 }
 
+// SetPrompt sets the prompt shown before the query.
+func (p *Palette) SetPrompt(prompt string) {
+	p.input.Prompt = prompt
+	p.resizeInput()
+}
+
+// SetPlaceholder sets the text shown while the query is empty.
+func (p *Palette) SetPlaceholder(placeholder string) {
+	p.input.Placeholder = placeholder
+}
+
+// SetFilter restricts the palette to commands for which allow returns
+// true. A nil allow shows every command.
+func (p *Palette) SetFilter(allow func(Command) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.allow = allow
+	p.filter()
+	p.selected = 0
+}
+
 // Update handles input messages.
 func (p *Palette) Update(msg tea.Msg) (bool, tea.Cmd) {
 	if !p.visible {
@@ -290,14 +333,16 @@ func (p *Palette) View() string {
 	filtered := p.filtered
 	p.mu.RUnlock()
 
-	// Results
+	// Results, scrolled to keep the selection in view
 	if len(filtered) == 0 {
 		sb.WriteString(p.styles.NoResults.Render("No matching commands"))
 	} else {
-		for i, cmd := range filtered {
-			if i >= p.maxItems {
-				break
-			}
+		start := 0
+		if p.selected >= p.maxItems {
+			start = p.selected - p.maxItems + 1
+		}
+		for i := start; i < len(filtered) && i < start+p.maxItems; i++ {
+			cmd := filtered[i]
 
 			style := p.styles.Item
 			if i == p.selected {
@@ -315,6 +360,10 @@ func (p *Palette) View() string {
 			sb.WriteString(style.Render(line))
 			sb.WriteString("\n")
 		}
+
+		if p.selected < len(filtered) && filtered[p.selected].Preview != "" {
+			sb.WriteString(p.styles.Preview.Render(filtered[p.selected].Preview))
+		}
 	}
 
 	content := sb.String()
@@ -323,47 +372,123 @@ func (p *Palette) View() string {
 	return content
 }
 
+// filter selects the commands matching the query, best match first.
+// Commands that match equally well keep the order they were added in.
 func (p *Palette) filter() {
-	query := strings.ToLower(p.input.Value())
+	query := p.input.Value()
 
-	if query == "" {
-		p.filtered = make([]Command, len(p.commands))
-		copy(p.filtered, p.commands)
-		return
+	type ranked struct {
+		cmd   Command
+		score float64
 	}
-
-	p.filtered = make([]Command, 0)
+	matches := make([]ranked, 0, len(p.commands))
 	for _, cmd := range p.commands {
-		if p.matches(cmd, query) {
-			p.filtered = append(p.filtered, cmd)
+		if p.allow != nil && !p.allow(cmd) {
+			continue
+		}
+		if score, ok := p.matches(cmd, query); ok {
+			matches = append(matches, ranked{cmd: cmd, score: float64(score) + cmd.Weight})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	p.filtered = make([]Command, len(matches))
+	for i, m := range matches {
+		p.filtered[i] = m.cmd
+	}
+}
+
+// matches returns the best fuzzy match score of the query against the
+// command's name, description, category and keywords.
+func (p *Palette) matches(cmd Command, query string) (int, bool) {
+	best, found := 0, false
+	fields := append([]string{cmd.Name, cmd.Description, cmd.Category}, cmd.Keywords...)
+	for _, field := range fields {
+		if score, ok := FuzzyMatch(query, field); ok && (!found || score > best) {
+			best, found = score, true
 		}
 	}
+	return best, found
 }
 
-func (p *Palette) matches(cmd Command, query string) bool {
-	// Check name
-	if strings.Contains(strings.ToLower(cmd.Name), query) {
-		return true
+// Fuzzy match scoring. Every matched character scores, with bonuses for
+// runs of adjacent characters and for characters that start a word, and
+// a penalty for each character skipped inside the match.
+const (
+	fuzzyMatchScore       = 1
+	fuzzyConsecutiveBonus = 4
+	fuzzyBoundaryBonus    = 6
+	fuzzyGapPenalty       = 1
+)
+
+// FuzzyMatch reports whether the characters of pattern appear in text in
+// order, ignoring case, and scores how well they match: higher is better.
+// An empty pattern matches everything with a score of zero.
+func FuzzyMatch(pattern, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
 	}
 
-	// Check description
-	if strings.Contains(strings.ToLower(cmd.Description), query) {
-		return true
+	pat := []rune(strings.ToLower(pattern))
+	orig := []rune(text)
+	lower := make([]rune, len(orig))
+	for i, r := range orig {
+		lower[i] = unicode.ToLower(r)
 	}
 
-	// Check category
-	if strings.Contains(strings.ToLower(cmd.Category), query) {
-		return true
+	// Find where the earliest match ends, then walk back from there to
+	// the latest start so the match is as tight as possible
+	end, pi := -1, 0
+	for i, r := range lower {
+		if r == pat[pi] {
+			pi++
+			if pi == len(pat) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, false
+	}
+	start, pi := end, len(pat)-1
+	for i := end; i >= 0; i-- {
+		if lower[i] == pat[pi] {
+			pi--
+			if pi < 0 {
+				start = i
+				break
+			}
+		}
 	}
 
-	// Check keywords
-	for _, kw := range cmd.Keywords {
-		if strings.Contains(strings.ToLower(kw), query) {
-			return true
+	score, pi, prev := 0, 0, -2
+	for i := start; i <= end && pi < len(pat); i++ {
+		if lower[i] != pat[pi] {
+			score -= fuzzyGapPenalty
+			continue
 		}
+		score += fuzzyMatchScore
+		if i == prev+1 {
+			score += fuzzyConsecutiveBonus
+		}
+		if i == 0 || isWordBoundary(orig[i-1], orig[i]) {
+			score += fuzzyBoundaryBonus
+		}
+		prev = i
+		pi++
 	}
+	return score, true
+}
 
-	return false
+// isWordBoundary reports whether cur starts a word after prev.
+func isWordBoundary(prev, cur rune) bool {
+	if unicode.IsLower(prev) && unicode.IsUpper(cur) {
+		return true
+	}
+	return !unicode.IsLetter(prev) && !unicode.IsDigit(prev)
 }
 
 // Selected returns the currently selected command.
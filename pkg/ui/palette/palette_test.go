@@ -1,6 +1,7 @@
 package palette
 
 import (
+	"strings"
 	"testing"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -327,3 +328,84 @@ func TestDefaultStyles(t *testing.T) {
 	_ = styles.Item
 	_ = styles.SelectedItem
 }
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		match   bool
+	}{
+		{"", "anything", true},
+		{"gst", "git status", true},
+		{"GS", "git status", true},
+		{"dkr", "docker run", true},
+		{"tsg", "git status", false},
+		{"xyz", "git status", false},
+	}
+
+	for _, tt := range tests {
+		if _, ok := FuzzyMatch(tt.pattern, tt.text); ok != tt.match {
+			t.Errorf("FuzzyMatch(%q, %q) matched = %v, want %v", tt.pattern, tt.text, ok, tt.match)
+		}
+	}
+
+	// Adjacent characters and word starts score higher than scattered ones
+	tight, _ := FuzzyMatch("stat", "git status")
+	loose, _ := FuzzyMatch("stat", "systemctl restart")
+	if tight <= loose {
+		t.Errorf("expected contiguous match to score higher: %d <= %d", tight, loose)
+	}
+	boundary, _ := FuzzyMatch("gs", "git status")
+	inner, _ := FuzzyMatch("gs", "logs")
+	if boundary <= inner {
+		t.Errorf("expected word-start match to score higher: %d <= %d", boundary, inner)
+	}
+}
+
+func TestPalette_Ranking(t *testing.T) {
+	p := New()
+	p.AddCommands([]Command{
+		{ID: "scattered", Name: "systemctl restart"},
+		{ID: "tight", Name: "git status"},
+		{ID: "heavy", Name: "kubectl get pods", Weight: 100},
+	})
+	p.Open()
+
+	if p.filtered[0].ID != "heavy" {
+		t.Errorf("expected weight to rank unfiltered commands, got %s first", p.filtered[0].ID)
+	}
+
+	p.SetQuery("stat")
+	if len(p.filtered) != 2 || p.filtered[0].ID != "tight" {
+		t.Errorf("expected best match first, got %+v", p.filtered)
+	}
+}
+
+func TestPalette_SetFilter(t *testing.T) {
+	p := New()
+	p.AddCommands([]Command{
+		{ID: "ok", Name: "make", Category: "ok"},
+		{ID: "failed", Name: "make test", Category: "failed"},
+	})
+	p.Open()
+
+	p.SetFilter(func(cmd Command) bool { return cmd.Category == "failed" })
+	if len(p.filtered) != 1 || p.filtered[0].ID != "failed" {
+		t.Errorf("expected only allowed commands, got %+v", p.filtered)
+	}
+
+	p.SetFilter(nil)
+	if len(p.filtered) != 2 {
+		t.Errorf("expected all commands without a filter, got %+v", p.filtered)
+	}
+}
+
+func TestPalette_PreviewView(t *testing.T) {
+	p := New()
+	p.AddCommand(Command{ID: "multi", Name: "for f in *; do ⏎", Preview: "for f in *; do\n  echo \"$f\"\ndone"})
+	p.Open()
+
+	if view := p.View(); !strings.Contains(view, "echo \"$f\"") {
+		t.Errorf("expected preview of the selected command, got %q", view)
+	}
+}
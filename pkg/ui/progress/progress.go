@@ -25,6 +25,7 @@ type Bar struct {
 	colorA      string
 	colorB      string
 	useGradient bool
+	format      func(int) string
 }
 
 // NewBar creates a new progress bar.
@@ -84,6 +85,21 @@ func (b *Bar) IncrementBy(amount int) {
 	}
 }
 
+// Set sets progress to current, within the total.
+func (b *Bar) Set(current int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current = min(max(current, 0), b.total)
+}
+
+// SetFormatter sets how the current and total counts are shown, such as
+// in bytes with a unit. Counts are shown as plain numbers by default.
+func (b *Bar) SetFormatter(format func(int) string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.format = format
+}
+
 // SetMessage sets the progress message.
 func (b *Bar) SetMessage(message string) {
 	b.mu.Lock()
@@ -113,6 +129,9 @@ func (b *Bar) View() string {
 
 	elapsed := time.Since(b.startTime).Round(time.Second)
 	stats := fmt.Sprintf(" %d/%d (%s)", b.current, b.total, elapsed)
+	if b.format != nil {
+		stats = fmt.Sprintf(" %s/%s (%s)", b.format(b.current), b.format(b.total), elapsed)
+	}
 
 	if b.message != "" {
 		return fmt.Sprintf("%s %s%s", b.message, bar, stats)
@@ -1,6 +1,8 @@
 package progress
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -112,6 +114,41 @@ func TestBarSetProgress(t *testing.T) {
 	}
 }
 
+func TestBarSet(t *testing.T) {
+	t.Parallel()
+
+	bar := NewBar()
+	bar.Start(100)
+
+	bar.Set(40)
+	if bar.current != 40 {
+		t.Errorf("expected current 40, got %d", bar.current)
+	}
+	bar.Set(150)
+	if bar.current != 100 {
+		t.Errorf("expected current clamped to 100, got %d", bar.current)
+	}
+	bar.Set(-5)
+	if bar.current != 0 {
+		t.Errorf("expected current clamped to 0, got %d", bar.current)
+	}
+}
+
+func TestBarSetFormatter(t *testing.T) {
+	t.Parallel()
+
+	bar := NewBar()
+	bar.Start(2048)
+	bar.Set(1024)
+	bar.SetFormatter(func(n int) string {
+		return fmt.Sprintf("%dK", n/1024)
+	})
+
+	if view := bar.View(); !strings.Contains(view, "1K/2K") {
+		t.Errorf("expected formatted counts in %q", view)
+	}
+}
+
 func TestBarSetMessage(t *testing.T) {
 	t.Parallel()
 
@@ -110,6 +110,7 @@ type Styles struct {
 
 	// Output styles
 	Output  lipgloss.Style
+	Stderr  lipgloss.Style
 	Error   lipgloss.Style
 	Warning lipgloss.Style
 	Success lipgloss.Style
@@ -191,6 +192,9 @@ func NewStyles(theme Theme) *Styles {
 		Output: lipgloss.NewStyle().
 			Foreground(theme.Foreground),
 
+		Stderr: lipgloss.NewStyle().
+			Foreground(theme.Warning),
+
 		Error: lipgloss.NewStyle().
 			Foreground(theme.Error),
 
@@ -0,0 +1,219 @@
+// Package transfers provides a view of the SFTP transfer queue for cbwsh.
+package transfers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+	"github.com/cbwinslow/cbwsh/pkg/ui/progress"
+	"github.com/cbwinslow/cbwsh/pkg/ui/tunnels"
+)
+
+// refreshInterval is how often a visible view updates its progress bars.
+const refreshInterval = 250 * time.Millisecond
+
+// TickMsg asks a visible view to refresh.
+type TickMsg time.Time
+
+// Tick returns a command that sends a TickMsg after the refresh interval.
+func Tick() tea.Cmd {
+	return tea.Tick(refreshInterval, func(t time.Time) tea.Msg {
+		return TickMsg(t)
+	})
+}
+
+// Styles defines the view styles.
+type Styles struct {
+	Border lipgloss.Style
+	Title  lipgloss.Style
+	Name   lipgloss.Style
+	Stats  lipgloss.Style
+	Done   lipgloss.Style
+	Error  lipgloss.Style
+	Empty  lipgloss.Style
+}
+
+// DefaultStyles returns default view styles.
+func DefaultStyles() Styles {
+	return Styles{
+		Border: lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("62")).
+			Padding(0, 1),
+		Title: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("62")).
+			Bold(true),
+		Name: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("255")).
+			Bold(true),
+		Stats: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("243")),
+		Done: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("42")),
+		Error: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196")),
+		Empty: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("243")).
+			Italic(true),
+	}
+}
+
+// View lists the transfers of an SSH manager: the running transfer with a
+// progress bar, then the queued ones, then the finished ones, most recent
+// first.
+type View struct {
+	source  func() []*ssh.Transfer
+	bars    map[int]*progress.Bar
+	visible bool
+	width   int
+	height  int
+	styles  Styles
+}
+
+// NewView creates a hidden view of the transfers returned by source, such
+// as ssh.Manager.Transfers.
+func NewView(source func() []*ssh.Transfer) *View {
+	return &View{
+		source: source,
+		bars:   make(map[int]*progress.Bar),
+		styles: DefaultStyles(),
+	}
+}
+
+// SetSize sets the size of the view, borders included.
+func (v *View) SetSize(width, height int) {
+	v.width = width
+	v.height = height
+}
+
+// Show makes the view visible.
+func (v *View) Show() {
+	v.visible = true
+}
+
+// Hide hides the view.
+func (v *View) Hide() {
+	v.visible = false
+}
+
+// Toggle shows or hides the view.
+func (v *View) Toggle() {
+	v.visible = !v.visible
+}
+
+// IsVisible reports whether the view is visible.
+func (v *View) IsVisible() bool {
+	return v.visible
+}
+
+// View renders the view.
+func (v *View) View() string {
+	if !v.visible {
+		return ""
+	}
+
+	innerWidth := max(v.width-4, 10)
+	innerHeight := max(v.height-2, 1)
+
+	all := v.source()
+	var active, finished []*ssh.Transfer
+	for _, t := range all {
+		switch t.State() {
+		case ssh.TransferQueued, ssh.TransferRunning:
+			active = append(active, t)
+		default:
+			finished = append([]*ssh.Transfer{t}, finished...)
+		}
+	}
+	v.pruneBars(all)
+
+	title := "Transfers"
+	if len(active) > 0 {
+		title += fmt.Sprintf(" (%d left)", len(active))
+	}
+	lines := []string{v.styles.Title.Render(title), ""}
+	if len(all) == 0 {
+		lines = append(lines, v.styles.Empty.Render("No transfers"))
+	}
+	for _, t := range append(active, finished...) {
+		lines = append(lines, v.renderTransfer(t, innerWidth)...)
+	}
+
+	if len(lines) > innerHeight {
+		lines = lines[:innerHeight]
+	}
+	clip := lipgloss.NewStyle().MaxWidth(innerWidth)
+	for i, line := range lines {
+		lines[i] = clip.Render(line)
+	}
+	return v.styles.Border.
+		Width(innerWidth + 2).
+		Height(innerHeight).
+		Render(strings.Join(lines, "\n"))
+}
+
+// renderTransfer renders one transfer as a few lines.
+func (v *View) renderTransfer(t *ssh.Transfer, width int) []string {
+	arrow := "↑"
+	if t.Direction() == ssh.TransferDownload {
+		arrow = "↓"
+	}
+	header := v.styles.Name.Render(fmt.Sprintf("#%d %s %s", t.ID(), arrow, path.Base(t.Source()))) +
+		v.styles.Stats.Render(" "+t.Connection())
+
+	size := tunnels.FormatBytes(t.Size())
+	var status string
+	switch t.State() {
+	case ssh.TransferQueued:
+		status = v.styles.Stats.Render("queued, " + size)
+	case ssh.TransferRunning:
+		status = v.bar(t, width-2).View()
+	case ssh.TransferDone:
+		took := t.Finished().Sub(t.Started()).Truncate(time.Second)
+		status = v.styles.Done.Render(fmt.Sprintf("✓ %s in %s", size, took))
+	case ssh.TransferCanceled:
+		status = v.styles.Stats.Render(fmt.Sprintf("canceled at %s of %s", tunnels.FormatBytes(t.Transferred()), size))
+	case ssh.TransferFailed:
+		status = v.styles.Error.Render(t.Err().Error())
+	}
+	return []string{header, "  " + status, ""}
+}
+
+// bar returns the progress bar of a running transfer, updated to its
+// current progress.
+func (v *View) bar(t *ssh.Transfer, width int) *progress.Bar {
+	bar, ok := v.bars[t.ID()]
+	if !ok {
+		bar = progress.NewBar()
+		bar.SetFormatter(func(n int) string {
+			return tunnels.FormatBytes(int64(n))
+		})
+		bar.Start(int(t.Size()))
+		v.bars[t.ID()] = bar
+	}
+	// Leave room for the byte counts and elapsed time after the bar
+	bar.SetWidth(max(width-28, 10))
+	bar.Set(int(t.Transferred()))
+	return bar
+}
+
+// pruneBars drops the bars of transfers that are no longer running.
+func (v *View) pruneBars(transfers []*ssh.Transfer) {
+	running := make(map[int]bool)
+	for _, t := range transfers {
+		if t.State() == ssh.TransferRunning {
+			running[t.ID()] = true
+		}
+	}
+	for id := range v.bars {
+		if !running[id] {
+			delete(v.bars, id)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package transfers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+func TestViewVisibility(t *testing.T) {
+	v := NewView(func() []*ssh.Transfer { return nil })
+	v.SetSize(40, 10)
+
+	if v.View() != "" {
+		t.Error("expected a hidden view to render nothing")
+	}
+
+	v.Toggle()
+	if !v.IsVisible() {
+		t.Fatal("expected Toggle to show the view")
+	}
+	out := v.View()
+	if !strings.Contains(out, "Transfers") || !strings.Contains(out, "No transfers") {
+		t.Errorf("expected an empty transfer list, got %q", out)
+	}
+
+	v.Hide()
+	if v.IsVisible() {
+		t.Error("expected Hide to hide the view")
+	}
+}
+
+func TestViewFitsItsSize(t *testing.T) {
+	v := NewView(func() []*ssh.Transfer { return nil })
+	v.SetSize(30, 8)
+	v.Show()
+
+	lines := strings.Split(v.View(), "\n")
+	if len(lines) != 8 {
+		t.Errorf("expected 8 lines, got %d", len(lines))
+	}
+}
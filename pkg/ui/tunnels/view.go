@@ -0,0 +1,187 @@
+// Package tunnels provides a view of the live SSH tunnels for cbwsh.
+package tunnels
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+// refreshInterval is how often a visible view updates its counters.
+const refreshInterval = time.Second
+
+// TickMsg asks a visible view to refresh.
+type TickMsg time.Time
+
+// Tick returns a command that sends a TickMsg after the refresh interval.
+func Tick() tea.Cmd {
+	return tea.Tick(refreshInterval, func(t time.Time) tea.Msg {
+		return TickMsg(t)
+	})
+}
+
+// Styles defines the view styles.
+type Styles struct {
+	Border lipgloss.Style
+	Title  lipgloss.Style
+	Name   lipgloss.Style
+	Route  lipgloss.Style
+	Stats  lipgloss.Style
+	Error  lipgloss.Style
+	Empty  lipgloss.Style
+}
+
+// DefaultStyles returns default view styles.
+func DefaultStyles() Styles {
+	return Styles{
+		Border: lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("62")).
+			Padding(0, 1),
+		Title: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("62")).
+			Bold(true),
+		Name: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("255")).
+			Bold(true),
+		Route: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("252")),
+		Stats: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("243")),
+		Error: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196")),
+		Empty: lipgloss.NewStyle().
+			Foreground(lipgloss.Color("243")).
+			Italic(true),
+	}
+}
+
+// View lists the tunnels of an SSH manager with their traffic.
+type View struct {
+	source  func() []*ssh.Tunnel
+	visible bool
+	width   int
+	height  int
+	styles  Styles
+}
+
+// NewView creates a hidden view of the tunnels returned by source, such as
+// ssh.Manager.Tunnels.
+func NewView(source func() []*ssh.Tunnel) *View {
+	return &View{
+		source: source,
+		styles: DefaultStyles(),
+	}
+}
+
+// SetSize sets the size of the view, borders included.
+func (v *View) SetSize(width, height int) {
+	v.width = width
+	v.height = height
+}
+
+// Show makes the view visible.
+func (v *View) Show() {
+	v.visible = true
+}
+
+// Hide hides the view.
+func (v *View) Hide() {
+	v.visible = false
+}
+
+// Toggle shows or hides the view.
+func (v *View) Toggle() {
+	v.visible = !v.visible
+}
+
+// IsVisible reports whether the view is visible.
+func (v *View) IsVisible() bool {
+	return v.visible
+}
+
+// View renders the view.
+func (v *View) View() string {
+	if !v.visible {
+		return ""
+	}
+
+	lines := []string{v.styles.Title.Render("Tunnels"), ""}
+	tunnels := v.source()
+	if len(tunnels) == 0 {
+		lines = append(lines, v.styles.Empty.Render("No tunnels"))
+	}
+	for _, t := range tunnels {
+		lines = append(lines, v.renderTunnel(t)...)
+	}
+
+	innerWidth := max(v.width-4, 10)
+	innerHeight := max(v.height-2, 1)
+	if len(lines) > innerHeight {
+		lines = lines[:innerHeight]
+	}
+	clip := lipgloss.NewStyle().MaxWidth(innerWidth)
+	for i, line := range lines {
+		lines[i] = clip.Render(line)
+	}
+	return v.styles.Border.
+		Width(innerWidth + 2).
+		Height(innerHeight).
+		Render(strings.Join(lines, "\n"))
+}
+
+// renderTunnel renders one tunnel as a few lines.
+func (v *View) renderTunnel(t *ssh.Tunnel) []string {
+	spec := t.Spec()
+	connection := t.Connection()
+	if connection == "" {
+		connection = "current"
+	}
+	header := v.styles.Name.Render(fmt.Sprintf("#%d %s", t.ID(), connection)) +
+		v.styles.Stats.Render(" "+string(spec.Type))
+
+	listen := spec.Listen
+	if addr := t.Addr(); addr != "" {
+		listen = addr
+	}
+	route := listen + " → " + Target(spec)
+
+	if err := t.Err(); err != nil {
+		return []string{header, "  " + v.styles.Route.Render(route), "  " + v.styles.Error.Render(err.Error()), ""}
+	}
+
+	stats := fmt.Sprintf("↑ %s  ↓ %s  %d open  %s",
+		FormatBytes(t.BytesSent()),
+		FormatBytes(t.BytesReceived()),
+		t.ActiveConnections(),
+		time.Since(t.Started()).Truncate(time.Second))
+	return []string{header, "  " + v.styles.Route.Render(route), "  " + v.styles.Stats.Render(stats), ""}
+}
+
+// Target describes where a tunnel's connections go.
+func Target(spec core.TunnelSpec) string {
+	if spec.Type == core.TunnelDynamic {
+		return "SOCKS5"
+	}
+	return spec.Target
+}
+
+// FormatBytes formats a byte count with a binary unit, such as 1.5 KiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,60 @@
+package tunnels
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cbwinslow/cbwsh/pkg/core"
+	"github.com/cbwinslow/cbwsh/pkg/ssh"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	if got := Target(core.TunnelSpec{Type: core.TunnelLocal, Target: "db:5432"}); got != "db:5432" {
+		t.Errorf("expected the target, got %q", got)
+	}
+	if got := Target(core.TunnelSpec{Type: core.TunnelDynamic}); got != "SOCKS5" {
+		t.Errorf("expected SOCKS5 for a dynamic tunnel, got %q", got)
+	}
+}
+
+func TestViewVisibility(t *testing.T) {
+	v := NewView(func() []*ssh.Tunnel { return nil })
+	v.SetSize(40, 10)
+
+	if v.View() != "" {
+		t.Error("expected a hidden view to render nothing")
+	}
+
+	v.Toggle()
+	if !v.IsVisible() {
+		t.Fatal("expected Toggle to show the view")
+	}
+	out := v.View()
+	if !strings.Contains(out, "Tunnels") || !strings.Contains(out, "No tunnels") {
+		t.Errorf("expected an empty tunnel list, got %q", out)
+	}
+
+	v.Hide()
+	if v.IsVisible() {
+		t.Error("expected Hide to hide the view")
+	}
+}